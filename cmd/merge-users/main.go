@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/joho/godotenv"
+	"github.com/vladimiradmaev/diabetes-helper/internal/config"
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+	"github.com/vladimiradmaev/diabetes-helper/internal/services"
+)
+
+// Одноразовый помощник: объединяет пользователей, зарегистрированных с одним
+// Telegram ID, пока telegram_id не был уникальным. Миграция уникального
+// индекса останавливает запуск бота, пока такие дубли есть, поэтому утилита
+// подключается к базе без миграций. История каждого дубля переносится к
+// пользователю с наименьшим ID — его бот и читал. По умолчанию только
+// показывает найденные дубли.
+func main() {
+	apply := flag.Bool("apply", false, "объединить дубли (по умолчанию только отчет)")
+	admin := flag.Int64("admin", 0, "Telegram ID администратора для журнала аудита (0 — запуск из консоли)")
+	flag.Parse()
+
+	if err := logger.Init(); err != nil {
+		fmt.Printf("❌ Не удалось инициализировать логгер: %v\n", err)
+		os.Exit(1)
+	}
+	defer logger.Close()
+
+	if err := godotenv.Load(); err != nil {
+		fmt.Printf("⚠️  .env файл не найден: %v\n", err)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Printf("❌ Ошибка конфигурации:\n%v\n", err)
+		os.Exit(1)
+	}
+
+	db, err := database.OpenPostgresDB(cfg.DB)
+	if err != nil {
+		fmt.Printf("❌ Ошибка подключения к базе данных: %v\n", err)
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+	svc := services.NewTransferService(db)
+	duplicates, err := svc.FindDuplicateUsers(ctx)
+	if err != nil {
+		fmt.Printf("❌ Ошибка поиска дублей: %v\n", err)
+		os.Exit(1)
+	}
+	if len(duplicates) == 0 {
+		fmt.Println("✅ Дублей нет, бот можно запускать")
+		return
+	}
+
+	fmt.Printf("📋 Telegram ID с несколькими пользователями: %d\n", len(duplicates))
+	for _, d := range duplicates {
+		into := d.UserIDs[0]
+		fmt.Printf("  - telegram_id %d: пользователи %v, история переносится к %d\n", d.TelegramID, d.UserIDs, into)
+		if !*apply {
+			continue
+		}
+		for _, from := range d.UserIDs[1:] {
+			counts, err := svc.MergeUsers(ctx, *admin, from, into)
+			if err != nil {
+				fmt.Printf("❌ Не удалось объединить %d с %d, данные не изменились: %v\n", from, into, err)
+				os.Exit(1)
+			}
+			for _, c := range counts {
+				fmt.Printf("      %d → %d, %s: %d\n", from, into, c.Table, c.Rows)
+			}
+		}
+	}
+	if *apply {
+		fmt.Println("✅ Дубли объединены, бот можно запускать")
+	} else {
+		fmt.Println("ℹ️  Изменения не записаны. Запустите с -apply, чтобы объединить.")
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/vladimiradmaev/diabetes-helper/internal/config"
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+	"github.com/vladimiradmaev/diabetes-helper/internal/services"
+)
+
+// Одноразовый помощник: пересчитывает коэффициент на ХЕ у анализов, сохраненных
+// до поддержки часовых поясов, когда период выбирался по времени сервера.
+// По умолчанию только показывает, что будет изменено.
+func main() {
+	apply := flag.Bool("apply", false, "записать изменения (по умолчанию только отчет)")
+	serverTZ := flag.String("server-tz", "", "часовой пояс сервера, на котором создавались старые анализы (по умолчанию локальный)")
+	flag.Parse()
+
+	if err := logger.Init(); err != nil {
+		fmt.Printf("❌ Не удалось инициализировать логгер: %v\n", err)
+		os.Exit(1)
+	}
+	defer logger.Close()
+
+	if err := godotenv.Load(); err != nil {
+		fmt.Printf("⚠️  .env файл не найден: %v\n", err)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Printf("❌ Ошибка конфигурации:\n%v\n", err)
+		os.Exit(1)
+	}
+
+	serverLoc := time.Local
+	if *serverTZ != "" {
+		if serverLoc, err = time.LoadLocation(*serverTZ); err != nil {
+			fmt.Printf("❌ Неизвестный часовой пояс %q: %v\n", *serverTZ, err)
+			os.Exit(1)
+		}
+	}
+
+	db, err := database.NewPostgresDB(cfg.DB)
+	if err != nil {
+		fmt.Printf("❌ Ошибка подключения к базе данных: %v\n", err)
+		os.Exit(1)
+	}
+
+	// The AI service isn't needed for recomputation
+	svc := services.NewFoodAnalysisService(nil, db, nil, cfg.CorrectionBand)
+	report, err := svc.RecomputeHistoricalRatios(context.Background(), serverLoc, *apply)
+	if err != nil {
+		fmt.Printf("❌ Ошибка пересчета: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("📋 Проверено анализов: %d\n", report.Checked)
+	fmt.Printf("  - Коэффициент выбран по времени сервера: %d\n", report.Mismatched)
+	fmt.Printf("  - Не удалось определить (расписание менялось): %d\n", report.Ambiguous)
+	if *apply {
+		fmt.Printf("✅ Обновлено: %d\n", report.Updated)
+	} else {
+		fmt.Println("ℹ️  Изменения не записаны. Запустите с -apply, чтобы применить.")
+	}
+}
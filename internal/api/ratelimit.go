@@ -0,0 +1,52 @@
+package api
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter allows a fixed number of requests per key in each one-minute window
+type rateLimiter struct {
+	limit int
+
+	mu      sync.Mutex
+	windows map[string]*rateWindow
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+func newRateLimiter(perMinute int) *rateLimiter {
+	return &rateLimiter{limit: perMinute, windows: make(map[string]*rateWindow)}
+}
+
+// Allow records a request for key and reports whether it is within the limit
+func (l *rateLimiter) Allow(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	w, ok := l.windows[key]
+	if !ok || now.Sub(w.start) >= time.Minute {
+		if !ok && len(l.windows) >= pruneThreshold {
+			l.prune(now)
+		}
+		w = &rateWindow{start: now}
+		l.windows[key] = w
+	}
+	w.count++
+	return w.count <= l.limit
+}
+
+// pruneThreshold is the number of tracked keys above which expired windows are dropped
+const pruneThreshold = 10000
+
+// prune drops expired windows. Must be called with l.mu held.
+func (l *rateLimiter) prune(now time.Time) {
+	for key, w := range l.windows {
+		if now.Sub(w.start) >= time.Minute {
+			delete(l.windows, key)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+// Package api serves the personal HTTP API used by watch complications and
+// widgets. Requests are authenticated with the token from /api_token.
+package api
+
+import "time"
+
+// Responses are versioned: fields may be added to a version, but removing or
+// changing one requires a new version with its own URL prefix.
+const SchemaVersion = 1
+
+// IOBV1 is the response of GET /api/v1/iob
+type IOBV1 struct {
+	SchemaVersion        int       `json:"schema_version"`
+	Units                float64   `json:"units"`
+	ActiveInsulinMinutes int       `json:"active_insulin_minutes"`
+	Model                string    `json:"model"` // "linear" or "curved"
+	CalculatedAt         time.Time `json:"calculated_at"`
+}
+
+// RecommendationV1 is the response of GET /api/v1/last_recommendation
+type RecommendationV1 struct {
+	SchemaVersion    int       `json:"schema_version"`
+	CreatedAt        time.Time `json:"created_at"`
+	Carbs            float64   `json:"carbs_grams"`
+	BreadUnits       float64   `json:"bread_units"`
+	CarbRatio        float64   `json:"carb_ratio"`
+	RecommendedUnits float64   `json:"recommended_units"`
+	Manual           bool      `json:"manual"`
+	InsulinOnBoard   float64   `json:"insulin_on_board"`
+}
+
+// ErrorV1 is returned with every non-2xx response
+type ErrorV1 struct {
+	SchemaVersion int    `json:"schema_version"`
+	Error         string `json:"error"`
+}
@@ -0,0 +1,182 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/dosing"
+	"github.com/vladimiradmaev/diabetes-helper/internal/interfaces"
+	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+	"github.com/vladimiradmaev/diabetes-helper/internal/metrics"
+	"github.com/vladimiradmaev/diabetes-helper/internal/services"
+)
+
+var apiRequests = metrics.NewCounterVec("api_requests_total", "HTTP API requests by response status", "status")
+
+// Dependencies holds the services the API reads from
+type Dependencies struct {
+	TokenSvc        interfaces.APITokenServiceInterface
+	InjectionSvc    interfaces.InjectionServiceInterface
+	FoodAnalysisSvc interfaces.FoodAnalysisServiceInterface
+}
+
+type server struct {
+	deps    Dependencies
+	limiter *rateLimiter
+}
+
+// Serve exposes the API on addr until ctx is cancelled, allowing each client
+// address and each user ratePerMinute requests per minute.
+// It returns immediately; the server runs in its own goroutine.
+func Serve(ctx context.Context, addr string, deps Dependencies, ratePerMinute int) {
+	s := &server{deps: deps, limiter: newRateLimiter(ratePerMinute)}
+
+	mux := http.NewServeMux()
+	mux.Handle("/api/v1/iob", s.authenticated(s.handleIOB))
+	mux.Handle("/api/v1/last_recommendation", s.authenticated(s.handleLastRecommendation))
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+		WriteTimeout:      10 * time.Second,
+	}
+
+	go func() {
+		logger.Info("API server listening", "addr", addr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("API server failed", "error", err)
+		}
+	}()
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+}
+
+// authenticated checks the method, rate limits and the bearer token before
+// calling next with the token's user
+func (s *server) authenticated(next func(w http.ResponseWriter, r *http.Request, user *database.User)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Responses are personal and change with every injection
+		w.Header().Set("Cache-Control", "no-store, private")
+		w.Header().Set("Pragma", "no-cache")
+		w.Header().Set("Vary", "Authorization")
+
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+			return
+		}
+
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		if !s.limiter.Allow("addr:"+host, time.Now()) {
+			writeError(w, http.StatusTooManyRequests, "rate_limited")
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			writeError(w, http.StatusUnauthorized, "missing_token")
+			return
+		}
+		user, err := s.deps.TokenSvc.Authenticate(r.Context(), token)
+		if errors.Is(err, services.ErrInvalidAPIToken) {
+			writeError(w, http.StatusUnauthorized, "invalid_token")
+			return
+		}
+		if err != nil {
+			logger.Error("Failed to authenticate API request", "error", err)
+			writeError(w, http.StatusInternalServerError, "internal_error")
+			return
+		}
+
+		if !s.limiter.Allow("user:"+strconv.FormatUint(uint64(user.ID), 10), time.Now()) {
+			writeError(w, http.StatusTooManyRequests, "rate_limited")
+			return
+		}
+		next(w, r, user)
+	})
+}
+
+func (s *server) handleIOB(w http.ResponseWriter, r *http.Request, user *database.User) {
+	now := time.Now()
+	iob, err := s.deps.InjectionSvc.InsulinOnBoard(r.Context(), user, now)
+	if err != nil {
+		logger.Error("Failed to calculate insulin on board", "user_id", user.ID, "error", err)
+		writeError(w, http.StatusInternalServerError, "internal_error")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, IOBV1{
+		SchemaVersion:        SchemaVersion,
+		Units:                iob.Units(),
+		ActiveInsulinMinutes: int(services.ActiveInsulinTime(user) / time.Minute),
+		Model:                iobModel(user),
+		CalculatedAt:         now.UTC(),
+	})
+}
+
+func (s *server) handleLastRecommendation(w http.ResponseWriter, r *http.Request, user *database.User) {
+	analysis, err := s.deps.FoodAnalysisSvc.GetLastAnalysis(r.Context(), user.ID)
+	if err != nil {
+		logger.Error("Failed to get last analysis", "user_id", user.ID, "error", err)
+		writeError(w, http.StatusInternalServerError, "internal_error")
+		return
+	}
+	if analysis == nil {
+		writeError(w, http.StatusNotFound, "no_recommendation")
+		return
+	}
+
+	iob, err := s.deps.InjectionSvc.InsulinOnBoard(r.Context(), user, time.Now())
+	if err != nil {
+		logger.Error("Failed to calculate insulin on board", "user_id", user.ID, "error", err)
+		writeError(w, http.StatusInternalServerError, "internal_error")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, RecommendationV1{
+		SchemaVersion:    SchemaVersion,
+		CreatedAt:        analysis.CreatedAt.UTC(),
+		Carbs:            analysis.Carbs,
+		BreadUnits:       analysis.BreadUnits,
+		CarbRatio:        analysis.InsulinRatio,
+		RecommendedUnits: analysis.InsulinUnits.Units(),
+		Manual:           analysis.UsedProvider == services.ManualProvider,
+		InsulinOnBoard:   iob.Units(),
+	})
+}
+
+// iobModel names the user's IOB decay model
+func iobModel(user *database.User) string {
+	if services.CurvedIOB(user) {
+		return dosing.IOBCurved
+	}
+	return dosing.IOBLinear
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	apiRequests.Inc(strconv.Itoa(status))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		logger.Warn("Failed to write API response", "error", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, code string) {
+	writeJSON(w, status, ErrorV1{SchemaVersion: SchemaVersion, Error: code})
+}
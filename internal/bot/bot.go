@@ -3,10 +3,12 @@ package bot
 import (
 	"context"
 	"fmt"
+	"net/http"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/vladimiradmaev/diabetes-helper/internal/bot/handlers"
 	"github.com/vladimiradmaev/diabetes-helper/internal/bot/state"
+	"github.com/vladimiradmaev/diabetes-helper/internal/dosing"
 	"github.com/vladimiradmaev/diabetes-helper/internal/interfaces"
 	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
 )
@@ -14,6 +16,8 @@ import (
 // Bot represents the main bot structure
 type Bot struct {
 	api           *tgbotapi.BotAPI
+	deps          handlers.Dependencies
+	stateManager  state.StateManager
 	updateHandler *handlers.UpdateHandler
 }
 
@@ -23,10 +27,37 @@ func NewBot(
 	redisHost, redisPort string,
 	userService interfaces.UserServiceInterface,
 	foodAnalysisSvc interfaces.FoodAnalysisServiceInterface,
+	analysisQueue interfaces.AnalysisQueueInterface,
 	bloodSugarSvc interfaces.BloodSugarServiceInterface,
 	insulinSvc interfaces.InsulinServiceInterface,
+	injectionSvc interfaces.InjectionServiceInterface,
+	basalSvc interfaces.BasalServiceInterface,
+	snapshotSvc interfaces.SettingsSnapshotServiceInterface,
+	statsSvc interfaces.StatsServiceInterface,
+	eventSvc interfaces.EventServiceInterface,
+	usageSvc interfaces.UsageServiceInterface,
+	jobSvc interfaces.JobServiceInterface,
+	chartSvc interfaces.ChartServiceInterface,
+	apiTokenSvc interfaces.APITokenServiceInterface,
+	insightsSvc interfaces.InsightsServiceInterface,
+	supportSvc interfaces.SupportServiceInterface,
+	dataCheckSvc interfaces.DataCheckServiceInterface,
+	transferSvc interfaces.TransferServiceInterface,
+	profileSvc interfaces.UserProfileServiceInterface,
+	flags interfaces.FeatureFlagsInterface,
+	telemetry interfaces.TelemetryInterface,
+	conversationLog interfaces.ConversationLogInterface,
+	bolusTiming dosing.TimingConfig,
+	adminIDs []int64,
 ) (*Bot, error) {
-	api, err := tgbotapi.NewBotAPI(token)
+	var api *tgbotapi.BotAPI
+	var err error
+	if conversationLog.Enabled() {
+		client := &conversationLogClient{next: &http.Client{}, log: conversationLog}
+		api, err = tgbotapi.NewBotAPIWithClient(token, tgbotapi.APIEndpoint, client)
+	} else {
+		api, err = tgbotapi.NewBotAPI(token)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to create bot: %w", err)
 	}
@@ -37,8 +68,28 @@ func NewBot(
 	deps := handlers.Dependencies{
 		UserService:     userService,
 		FoodAnalysisSvc: foodAnalysisSvc,
+		AnalysisQueue:   analysisQueue,
 		BloodSugarSvc:   bloodSugarSvc,
 		InsulinSvc:      insulinSvc,
+		InjectionSvc:    injectionSvc,
+		BasalSvc:        basalSvc,
+		SnapshotSvc:     snapshotSvc,
+		StatsSvc:        statsSvc,
+		EventSvc:        eventSvc,
+		UsageSvc:        usageSvc,
+		JobSvc:          jobSvc,
+		ChartSvc:        chartSvc,
+		APITokenSvc:     apiTokenSvc,
+		InsightsSvc:     insightsSvc,
+		SupportSvc:      supportSvc,
+		DataCheckSvc:    dataCheckSvc,
+		TransferSvc:     transferSvc,
+		ProfileSvc:      profileSvc,
+		Flags:           flags,
+		Telemetry:       telemetry,
+		ConversationLog: conversationLog,
+		BolusTiming:     bolusTiming,
+		Admins:          handlers.NewAdmins(adminIDs),
 	}
 
 	// Create Redis state manager
@@ -52,6 +103,8 @@ func NewBot(
 
 	return &Bot{
 		api:           api,
+		deps:          deps,
+		stateManager:  stateManager,
 		updateHandler: updateHandler,
 	}, nil
 }
@@ -70,6 +123,9 @@ func (b *Bot) Start(ctx context.Context) error {
 		case <-ctx.Done():
 			logger.Info("Bot is shutting down...")
 			b.api.StopReceivingUpdates()
+			if err := b.stateManager.Close(); err != nil {
+				logger.Error("Failed to close state manager", "error", err)
+			}
 			logger.Info("Bot stopped gracefully")
 			return nil
 		case update := <-updates:
@@ -81,3 +137,33 @@ func (b *Bot) Start(ctx context.Context) error {
 		}
 	}
 }
+
+// NotifyAdmins sends every admin the text
+func (b *Bot) NotifyAdmins(text string) {
+	b.deps.Admins.Notify(b.api, text)
+}
+
+// SendMonthlyUsageReport sends admins the previous month's AI usage once per month
+func (b *Bot) SendMonthlyUsageReport(ctx context.Context) error {
+	return handlers.SendMonthlyUsageReport(ctx, b.api, b.deps)
+}
+
+// SendReminders sends users their due reminders, several at once as one digest
+func (b *Bot) SendReminders(ctx context.Context) error {
+	return handlers.SendReminders(ctx, b.api, b.deps)
+}
+
+// ActivateRatioChanges switches in users' scheduled ratio changes that are due
+func (b *Bot) ActivateRatioChanges(ctx context.Context) error {
+	return handlers.ActivateRatioChanges(ctx, b.api, b.deps)
+}
+
+// ProcessAnalysisQueue starts queued photo analyses as the AI rate limit allows
+func (b *Bot) ProcessAnalysisQueue(ctx context.Context) error {
+	return handlers.ProcessAnalysisQueue(ctx, b.api, b.deps, b.stateManager)
+}
+
+// ProcessJobs runs queued background jobs such as exports
+func (b *Bot) ProcessJobs(ctx context.Context) error {
+	return handlers.ProcessJobs(ctx, b.api, b.deps)
+}
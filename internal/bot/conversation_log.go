@@ -0,0 +1,88 @@
+package bot
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/vladimiradmaev/diabetes-helper/internal/interfaces"
+)
+
+// multipartPeekBytes is how much of an upload is read to find its fields.
+// The Bot API library writes the fields before the files, so this covers
+// them without holding the file in memory.
+const multipartPeekBytes = 64 << 10
+
+// conversationLogClient records the messages the bot sends or edits in the
+// conversation log before passing the request on. It sees every Bot API call
+// in one place, whichever handler or background job makes it.
+type conversationLogClient struct {
+	next *http.Client
+	log  interfaces.ConversationLogInterface
+}
+
+func (c *conversationLogClient) Do(req *http.Request) (*http.Response, error) {
+	method := path.Base(req.URL.Path)
+	if req.Body != nil && (strings.HasPrefix(method, "send") || strings.HasPrefix(method, "edit")) {
+		if fields := c.peekFields(req); fields != nil {
+			if chatID, err := strconv.ParseInt(fields.Get("chat_id"), 10, 64); err == nil {
+				text := fields.Get("text")
+				if text == "" {
+					text = fields.Get("caption")
+				}
+				c.log.RecordOutgoing(chatID, method, text)
+			}
+		}
+	}
+	return c.next.Do(req)
+}
+
+// peekFields returns the form fields of the request and leaves its body
+// readable as before; nil if they can't be read
+func (c *conversationLogClient) peekFields(req *http.Request) url.Values {
+	mediaType, params, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		body, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		if err != nil {
+			return nil
+		}
+		fields, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil
+		}
+		return fields
+	case "multipart/form-data":
+		prefix := make([]byte, multipartPeekBytes)
+		n, _ := io.ReadFull(req.Body, prefix)
+		prefix = prefix[:n]
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(prefix), req.Body), req.Body}
+
+		fields := url.Values{}
+		reader := multipart.NewReader(bytes.NewReader(prefix), params["boundary"])
+		for {
+			part, err := reader.NextPart()
+			if err != nil || part.FileName() != "" {
+				break
+			}
+			value, err := io.ReadAll(part)
+			if err != nil {
+				break
+			}
+			fields.Add(part.FormName(), string(value))
+		}
+		return fields
+	}
+	return nil
+}
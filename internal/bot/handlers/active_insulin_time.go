@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/vladimiradmaev/diabetes-helper/internal/bot/state"
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+)
+
+// Allowed active insulin time in minutes
+const (
+	minActiveInsulinTime = 2 * 60
+	maxActiveInsulinTime = 8 * 60
+)
+
+// activeInsulinTimePattern matches hours and minutes, e.g. "3:30"
+var activeInsulinTimePattern = regexp.MustCompile(`^(\d{1,2}):(\d{2})$`)
+
+// parseActiveInsulinTime parses hours ("4", "3,5") or hours and minutes
+// ("3:30") into minutes
+func parseActiveInsulinTime(text string) (int, bool) {
+	text = strings.TrimSpace(text)
+	if m := activeInsulinTimePattern.FindStringSubmatch(text); m != nil {
+		hours, _ := strconv.Atoi(m[1])
+		minutes, _ := strconv.Atoi(m[2])
+		if minutes > 59 {
+			return 0, false
+		}
+		return hours*60 + minutes, true
+	}
+	hours, err := strconv.ParseFloat(strings.ReplaceAll(text, ",", "."), 64)
+	if err != nil {
+		return 0, false
+	}
+	return int(hours*60 + 0.5), true
+}
+
+// handleActiveInsulinTime asks for how long the user's rapid-acting insulin acts
+func (h *CallbackHandler) handleActiveInsulinTime(ctx context.Context, chatID int64, user *database.User) error {
+	minutes, err := h.deps.InsulinSvc.GetActiveInsulinTime(ctx, user.ID)
+	if err != nil {
+		logger.Error("Failed to get active insulin time", "user_id", user.ID, "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при получении времени активного инсулина")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+	h.stateManager.SetUserState(user.TelegramID, state.WaitingForActiveInsulinTime)
+
+	text := "Введите, сколько действует ваш быстрый инсулин, в часах или часах и минутах, например: 4 или 3:30\n" +
+		"Когда время задано, активный инсулин от прошлых уколов вычитается из рекомендуемой дозы.\n" +
+		"Отправьте 0, чтобы не вычитать активный инсулин."
+	if minutes > 0 {
+		text = fmt.Sprintf("Сейчас: %d ч %02d мин\n\n", minutes/60, minutes%60) + text
+	} else {
+		text = "Сейчас: не задано\n\n" + text
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("◀️ Отмена", "settings"),
+		),
+	)
+	_, err = h.api.Send(msg)
+	return err
+}
+
+// handleActiveInsulinTime saves the active insulin time
+func (h *TextHandler) handleActiveInsulinTime(ctx context.Context, message *tgbotapi.Message, user *database.User) error {
+	minutes, ok := parseActiveInsulinTime(message.Text)
+	if !ok || (minutes != 0 && (minutes < minActiveInsulinTime || minutes > maxActiveInsulinTime)) {
+		msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("Пожалуйста, введите время от %d до %d часов, например: 4 или 3:30",
+			minActiveInsulinTime/60, maxActiveInsulinTime/60))
+		_, err := h.api.Send(msg)
+		return err
+	}
+
+	if err := h.deps.InsulinSvc.SetActiveInsulinTime(ctx, user.ID, minutes); err != nil {
+		logger.Error("Failed to save active insulin time", "user_id", user.ID, "error", err)
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Ошибка при сохранении времени активного инсулина")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+	user.ActiveInsulinTime = minutes
+	h.stateManager.SetUserState(user.TelegramID, state.None)
+
+	text := "✅ Активный инсулин не вычитается из дозы"
+	if minutes > 0 {
+		text = fmt.Sprintf("✅ Время активного инсулина: %d ч %02d мин", minutes/60, minutes%60)
+	}
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	if _, err := h.api.Send(msg); err != nil {
+		return err
+	}
+	return sendSettingsMenu(ctx, h.api, h.deps, message.Chat.ID, user)
+}
@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/vladimiradmaev/diabetes-helper/internal/bot/state"
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+	"github.com/vladimiradmaev/diabetes-helper/internal/metrics"
+	"github.com/vladimiradmaev/diabetes-helper/internal/services"
+)
+
+var queuedAnalyses = metrics.NewGauge("ai_queue_depth", "Photos waiting for the AI rate limit")
+
+// queueStatusText describes a queued photo's position and expected wait
+func queueStatusText(deps Dependencies, position int) string {
+	seconds := int(math.Ceil(deps.AnalysisQueue.EstimateWait(position).Seconds()))
+	if seconds < 60 {
+		return fmt.Sprintf("⏳ Сейчас много фото на анализ. Ваше фото в очереди (%d-е), примерно %d секунд. Результат придет сюда.", position, seconds)
+	}
+	return fmt.Sprintf("⏳ Сейчас много фото на анализ. Ваше фото в очереди (%d-е), примерно %d мин. Результат придет сюда.", position, (seconds+59)/60)
+}
+
+// queueCancelKeyboard lets the user drop their queued photo
+func queueCancelKeyboard(id uint) tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("❌ Отменить", fmt.Sprintf("queue_cancel_%d", id)),
+		),
+	)
+}
+
+// enqueue puts a photo over the AI rate limit into the queue, or honestly
+// refuses it when the queue is full
+func (h *PhotoHandler) enqueue(ctx context.Context, chatID int64, user *database.User, fileID string, weight float64, dish, ingredients string) error {
+	item := &database.QueuedAnalysis{
+		UserID:      user.ID,
+		TelegramID:  user.TelegramID,
+		ChatID:      chatID,
+		FileID:      fileID,
+		Weight:      weight,
+		Dish:        dish,
+		Ingredients: ingredients,
+	}
+	position, err := h.deps.AnalysisQueue.Enqueue(ctx, item)
+	if errors.Is(err, services.ErrAnalysisQueueFull) {
+		logger.Warn("Analysis queue is full, photo refused", "user_id", user.ID)
+		msg := tgbotapi.NewMessage(chatID, "😔 Сейчас слишком много фото на анализ, и очередь заполнена. "+
+			"Пожалуйста, отправьте фото еще раз через несколько минут или введите углеводы вручную.")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to queue analysis: %w", err)
+	}
+	queuedAnalyses.Set(int64(position))
+	logger.Info("Photo queued for analysis", "user_id", user.ID, "queue_id", item.ID, "position", position)
+
+	msg := tgbotapi.NewMessage(chatID, queueStatusText(h.deps, position))
+	msg.ReplyMarkup = queueCancelKeyboard(item.ID)
+	sent, err := h.api.Send(msg)
+	if err != nil {
+		return err
+	}
+	if err := h.deps.AnalysisQueue.SetMessageID(ctx, item.ID, sent.MessageID); err != nil {
+		logger.Warn("Failed to save queue status message", "queue_id", item.ID, "error", err)
+	}
+
+	h.stateManager.SetUserState(user.TelegramID, state.None)
+	return nil
+}
+
+// handleQueueCancel removes the user's photo from the analysis queue
+func (h *CallbackHandler) handleQueueCancel(ctx context.Context, chatID int64, idStr string, user *database.User) error {
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		return h.handleUnknownCallback(chatID)
+	}
+
+	item, err := h.deps.AnalysisQueue.Cancel(ctx, user.ID, uint(id))
+	if err != nil {
+		logger.Error("Failed to cancel queued analysis", "user_id", user.ID, "queue_id", id, "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при отмене")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+	if item == nil {
+		msg := tgbotapi.NewMessage(chatID, "Анализ этого фото уже начался или отменен")
+		_, err := h.api.Send(msg)
+		return err
+	}
+
+	if item.MessageID != 0 {
+		_, err = h.api.Send(tgbotapi.NewEditMessageText(chatID, item.MessageID, "❌ Анализ фото отменен"))
+	} else {
+		_, err = h.api.Send(tgbotapi.NewMessage(chatID, "❌ Анализ фото отменен"))
+	}
+	return err
+}
+
+// ProcessAnalysisQueue starts the analyses of queued photos as far as the AI
+// rate limit allows and then updates the positions shown to those still waiting
+func ProcessAnalysisQueue(ctx context.Context, api *tgbotapi.BotAPI, deps Dependencies, stateManager state.StateManager) error {
+	h := NewPhotoHandler(api, deps, stateManager)
+
+	var wg sync.WaitGroup
+	started := 0
+	for ctx.Err() == nil {
+		item, err := deps.AnalysisQueue.Next(ctx)
+		if err != nil {
+			return err
+		}
+		if item == nil {
+			break
+		}
+		started++
+
+		wg.Add(1)
+		go func(item *database.QueuedAnalysis) {
+			defer wg.Done()
+			runQueuedAnalysis(ctx, h, item)
+		}(item)
+	}
+	wg.Wait()
+
+	if started == 0 {
+		return nil
+	}
+	queued, err := deps.AnalysisQueue.Queued(ctx)
+	if err != nil {
+		return err
+	}
+	queuedAnalyses.Set(int64(len(queued)))
+	for i, item := range queued {
+		if item.MessageID == 0 {
+			continue
+		}
+		edit := tgbotapi.NewEditMessageText(item.ChatID, item.MessageID, queueStatusText(deps, i+1))
+		markup := queueCancelKeyboard(item.ID)
+		edit.ReplyMarkup = &markup
+		if _, err := api.Send(edit); err != nil {
+			logger.Warn("Failed to update queue position", "queue_id", item.ID, "error", err)
+		}
+	}
+	return nil
+}
+
+// runQueuedAnalysis analyzes a photo leased from the queue. Failures are
+// reported to the user, since nothing else is waiting for the result. The
+// photo leaves the queue only once the user got an answer; on shutdown it
+// stays leased and is analyzed again when the lease runs out.
+func runQueuedAnalysis(ctx context.Context, h *PhotoHandler, item *database.QueuedAnalysis) {
+	if item.Attempts > services.MaxQueuedAttempts {
+		logger.Error("Queued analysis given up after repeated crashes", "queue_id", item.ID, "attempts", item.Attempts)
+		msg := tgbotapi.NewMessage(item.ChatID, "Извините, фото из очереди так и не удалось проанализировать. Пожалуйста, отправьте его еще раз.")
+		if _, err := h.api.Send(msg); err == nil {
+			finishQueuedAnalysis(ctx, h.deps, item)
+		}
+		return
+	}
+
+	user, err := h.deps.UserService.GetUserByTelegramID(ctx, item.TelegramID)
+	if err != nil {
+		logger.Error("Failed to get user of queued analysis", "queue_id", item.ID, "error", err)
+		return
+	}
+
+	if item.MessageID != 0 {
+		h.api.Send(tgbotapi.NewEditMessageText(item.ChatID, item.MessageID, "Анализирую изображение..."))
+	}
+	if err := h.analyze(ctx, item.ChatID, user, item.FileID, item.Weight, item.Dish, item.Ingredients, item.MessageID); err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		logger.Error("Queued analysis failed", "user_id", user.ID, "queue_id", item.ID, "error", err)
+		msg := tgbotapi.NewMessage(item.ChatID, "Извините, произошла ошибка при анализе изображения. Пожалуйста, попробуйте еще раз через несколько минут.")
+		if _, err := h.api.Send(msg); err != nil {
+			logger.Warn("Failed to report queued analysis failure", "queue_id", item.ID, "error", err)
+			return
+		}
+	}
+	finishQueuedAnalysis(ctx, h.deps, item)
+}
+
+// finishQueuedAnalysis removes a photo whose answer was delivered from the queue
+func finishQueuedAnalysis(ctx context.Context, deps Dependencies, item *database.QueuedAnalysis) {
+	if err := deps.AnalysisQueue.Done(ctx, item.ID); err != nil {
+		logger.Error("Failed to remove finished queued analysis", "queue_id", item.ID, "error", err)
+	}
+}
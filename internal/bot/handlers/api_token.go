@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+)
+
+// handleAPIToken handles the /api_token command: without arguments it issues
+// a new HTTP API token, replacing the previous one; "revoke" deletes it
+func (h *CommandHandler) handleAPIToken(ctx context.Context, chatID int64, user *database.User, args string) error {
+	if strings.EqualFold(strings.TrimSpace(args), "revoke") {
+		if err := h.deps.APITokenSvc.RevokeToken(ctx, user.ID); err != nil {
+			logger.Error("Failed to revoke API token", "user_id", user.ID, "error", err)
+			msg := tgbotapi.NewMessage(chatID, "Ошибка при отзыве токена")
+			_, sendErr := h.api.Send(msg)
+			return sendErr
+		}
+		msg := tgbotapi.NewMessage(chatID, "🔒 Токен API отозван, доступ по нему закрыт")
+		_, err := h.api.Send(msg)
+		return err
+	}
+
+	token, err := h.deps.APITokenSvc.IssueToken(ctx, user.ID)
+	if err != nil {
+		logger.Error("Failed to issue API token", "user_id", user.ID, "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при создании токена")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+
+	text := fmt.Sprintf("🔑 Ваш токен API:\n\n`%s`\n\n"+
+		"Передавайте его в заголовке `Authorization: Bearer <токен>`. "+
+		"Доступны /api/v1/iob (активный инсулин) и /api/v1/last\\_recommendation (последняя рекомендация).\n\n"+
+		"Предыдущий токен больше не действует. Никому не показывайте токен и удалите это сообщение после копирования. "+
+		"Отозвать токен: /api\\_token revoke", token)
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ParseMode = "Markdown"
+	_, err = h.api.Send(msg)
+	return err
+}
@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/vladimiradmaev/diabetes-helper/internal/bot/menus"
+	"github.com/vladimiradmaev/diabetes-helper/internal/bot/state"
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+)
+
+// basalCancelKeyboard returns to the basal menu from the add flow
+func basalCancelKeyboard() tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("◀️ Отмена", "basal_rates"),
+		),
+	)
+}
+
+// sendBasalMenu loads the user's basal schedule and shows its menu
+func sendBasalMenu(ctx context.Context, api *tgbotapi.BotAPI, deps Dependencies, chatID int64, user *database.User) error {
+	rates, err := deps.BasalSvc.GetUserRates(ctx, user.ID)
+	if err != nil {
+		logger.Error("Failed to get basal rates", "user_id", user.ID, "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при получении базального профиля")
+		_, sendErr := api.Send(msg)
+		return sendErr
+	}
+	return menus.SendBasalMenu(api, chatID, rates)
+}
+
+// handleBasalRates handles the basal schedule callback
+func (h *CallbackHandler) handleBasalRates(ctx context.Context, chatID int64, user *database.User) error {
+	h.stateManager.SetUserState(user.TelegramID, state.None)
+	clearPrompts(h.api, h.stateManager, chatID, user)
+	return sendBasalMenu(ctx, h.api, h.deps, chatID, user)
+}
+
+// handleAddBasalRate starts adding a basal period
+func (h *CallbackHandler) handleAddBasalRate(chatID int64, user *database.User) error {
+	h.stateManager.SetUserState(user.TelegramID, state.WaitingForBasalPeriod)
+	h.stateManager.ClearTempData(user.TelegramID)
+
+	msg := tgbotapi.NewMessage(chatID, "Введите период времени в формате ЧЧ:ММ-ЧЧ:ММ (например, 00:00-06:00):")
+	msg.ReplyMarkup = basalCancelKeyboard()
+	return sendPrompt(h.api, h.stateManager, user, msg)
+}
+
+// handleDeleteBasalRates asks for confirmation before deleting the schedule
+func (h *CallbackHandler) handleDeleteBasalRates(ctx context.Context, chatID int64, user *database.User) error {
+	rates, err := h.deps.BasalSvc.GetUserRates(ctx, user.ID)
+	if err != nil {
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при получении базального профиля")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+	if len(rates) == 0 {
+		msg := tgbotapi.NewMessage(chatID, "Базальный профиль не задан")
+		_, err := h.api.Send(msg)
+		return err
+	}
+
+	text := "⚠️ Удалить весь базальный профиль?\n\n"
+	for _, r := range rates {
+		text += fmt.Sprintf("• %s-%s: %.2f ед/ч\n", r.StartTime, r.EndTime, r.Rate)
+	}
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✅ Да, удалить все", "clear_basal_rates"),
+			tgbotapi.NewInlineKeyboardButtonData("❌ Нет", "basal_rates"),
+		),
+	)
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyMarkup = keyboard
+	_, err = h.api.Send(msg)
+	return err
+}
+
+// handleClearBasalRates deletes the whole basal schedule
+func (h *CallbackHandler) handleClearBasalRates(ctx context.Context, chatID int64, user *database.User) error {
+	if err := h.deps.BasalSvc.ClearRates(ctx, user.ID); err != nil {
+		msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("Ошибка при удалении базального профиля: %v", err))
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+
+	if _, err := h.api.Send(tgbotapi.NewMessage(chatID, "✅ Базальный профиль удален")); err != nil {
+		return err
+	}
+	return sendBasalMenu(ctx, h.api, h.deps, chatID, user)
+}
+
+// handleBasalPeriod handles time period input for a basal rate
+func (h *TextHandler) handleBasalPeriod(message *tgbotapi.Message, user *database.User) error {
+	trackPrompt(h.stateManager, user, message.MessageID)
+
+	startTime, endTime, guessed, problem := parsePeriod(message.Text)
+	if problem != "" {
+		msg := tgbotapi.NewMessage(message.Chat.ID, problem)
+		return sendPrompt(h.api, h.stateManager, user, msg)
+	}
+
+	h.stateManager.SetTempData(user.TelegramID, "startTime", startTime)
+	h.stateManager.SetTempData(user.TelegramID, "endTime", endTime)
+	h.stateManager.SetUserState(user.TelegramID, state.WaitingForBasalRate)
+
+	text := "Введите базальную скорость (единиц инсулина в час, например 0.85):"
+	if guessed {
+		text = periodConfirmation(startTime, endTime) + text
+	}
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	msg.ReplyMarkup = basalCancelKeyboard()
+	return sendPrompt(h.api, h.stateManager, user, msg)
+}
+
+// handleBasalRate handles basal rate input and saves the period
+func (h *TextHandler) handleBasalRate(ctx context.Context, message *tgbotapi.Message, user *database.User) error {
+	trackPrompt(h.stateManager, user, message.MessageID)
+
+	rate, err := strconv.ParseFloat(strings.ReplaceAll(strings.TrimSpace(message.Text), ",", "."), 64)
+	if err != nil || rate < 0 {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Пожалуйста, введите корректное число (например: 0.85)")
+		return sendPrompt(h.api, h.stateManager, user, msg)
+	}
+
+	startTimeVal, okStart := h.stateManager.GetTempData(user.TelegramID, "startTime")
+	endTimeVal, okEnd := h.stateManager.GetTempData(user.TelegramID, "endTime")
+	startTime, _ := startTimeVal.(string)
+	endTime, _ := endTimeVal.(string)
+	if !okStart || !okEnd || startTime == "" || endTime == "" {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Ошибка: период не найден. Пожалуйста, добавьте его еще раз.")
+		_, err := h.api.Send(msg)
+		return err
+	}
+
+	if err := h.deps.BasalSvc.AddRate(ctx, user.ID, startTime, endTime, rate); err != nil {
+		msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("Ошибка при сохранении базальной скорости: %v", err))
+		_, err := h.api.Send(msg)
+		return err
+	}
+
+	clearPrompts(h.api, h.stateManager, message.Chat.ID, user)
+	h.stateManager.ClearTempData(user.TelegramID)
+	h.stateManager.SetUserState(user.TelegramID, state.None)
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("✅ Базальная скорость %.2f ед/ч для периода %s-%s сохранена", rate, startTime, endTime))
+	if _, err := h.api.Send(msg); err != nil {
+		return err
+	}
+	return sendBasalMenu(ctx, h.api, h.deps, message.Chat.ID, user)
+}
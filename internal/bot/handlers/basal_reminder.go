@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/vladimiradmaev/diabetes-helper/internal/bot/state"
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/dosing"
+	"github.com/vladimiradmaev/diabetes-helper/internal/format"
+	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+	"github.com/vladimiradmaev/diabetes-helper/internal/services"
+)
+
+// maxBasalReminderUnits bounds the dose accepted for a basal reminder
+const maxBasalReminderUnits = 100
+
+// basalReminderPattern matches "22:00 18" or "7:30 12,5"
+var basalReminderPattern = regexp.MustCompile(`^(\d{1,2}):(\d{2})\s+(\d+(?:[.,]\d+)?)$`)
+
+// handleBasalReminder asks for the time and dose of the daily basal reminder
+func (h *CallbackHandler) handleBasalReminder(chatID int64, user *database.User) error {
+	h.stateManager.SetUserState(user.TelegramID, state.WaitingForBasalReminder)
+
+	text := "Введите время и дозу длинного инсулина через пробел, например: 22:00 18\n" +
+		"Каждый день в это время (по вашему часовому поясу) придет напоминание с кнопкой «✅ Принял».\n" +
+		"Отправьте 0, чтобы выключить напоминание."
+	if user.BasalReminderTime != "" {
+		text = fmt.Sprintf("Сейчас напоминание в %s, %.1f ед.\n\n", user.BasalReminderTime, user.BasalReminderUnits.Units()) + text
+	}
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("◀️ Отмена", "settings"),
+		),
+	)
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyMarkup = keyboard
+	_, err := h.api.Send(msg)
+	return err
+}
+
+// handleBasalTaken logs the basal injection of a reminder
+func (h *CallbackHandler) handleBasalTaken(ctx context.Context, chatID int64, idStr string, user *database.User) error {
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		return h.handleUnknownCallback(chatID)
+	}
+
+	injection, err := h.deps.InjectionSvc.TakeBasalReminder(ctx, user, uint(id))
+	if errors.Is(err, services.ErrBasalReminderTaken) {
+		msg := tgbotapi.NewMessage(chatID, "Этот укол уже записан")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+	if err != nil {
+		logger.Error("Failed to log basal injection from reminder", "user_id", user.ID, "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при записи укола")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("✅ Записан базальный укол: %.1f ед. в %s",
+		injection.Units.Units(), format.Clock(injection.Timestamp, services.UserLocation(user), format.Default)))
+	_, err = h.api.Send(msg)
+	return err
+}
+
+// handleBasalReminder saves the basal reminder time and dose
+func (h *TextHandler) handleBasalReminder(ctx context.Context, message *tgbotapi.Message, user *database.User) error {
+	input := strings.TrimSpace(message.Text)
+
+	var at string
+	var units float64
+	if input != "0" {
+		m := basalReminderPattern.FindStringSubmatch(input)
+		if m == nil {
+			msg := tgbotapi.NewMessage(message.Chat.ID, "Пожалуйста, введите время и дозу, например: 22:00 18")
+			_, err := h.api.Send(msg)
+			return err
+		}
+		hour, _ := strconv.Atoi(m[1])
+		minute, _ := strconv.Atoi(m[2])
+		units, _ = strconv.ParseFloat(strings.ReplaceAll(m[3], ",", "."), 64)
+		if hour > 23 || minute > 59 || units <= 0 || units > maxBasalReminderUnits {
+			msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("Время должно быть от 00:00 до 23:59, доза от 0 до %d ед.", maxBasalReminderUnits))
+			_, err := h.api.Send(msg)
+			return err
+		}
+		at = fmt.Sprintf("%02d:%02d", hour, minute)
+	}
+
+	if err := h.deps.UserService.SetBasalReminder(ctx, user.ID, at, dosing.ToDeciUnits(units)); err != nil {
+		logger.Error("Failed to save basal reminder", "user_id", user.ID, "error", err)
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Ошибка при сохранении напоминания")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+	user.BasalReminderTime = at
+	user.BasalReminderUnits = dosing.ToDeciUnits(units)
+	h.stateManager.SetUserState(user.TelegramID, state.None)
+
+	text := "✅ Напоминание о базальном инсулине выключено"
+	if at != "" {
+		text = fmt.Sprintf("✅ Напоминание о базальном инсулине: каждый день в %s, %.1f ед.", at, units)
+	}
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	if _, err := h.api.Send(msg); err != nil {
+		return err
+	}
+	return sendSettingsMenu(ctx, h.api, h.deps, message.Chat.ID, user)
+}
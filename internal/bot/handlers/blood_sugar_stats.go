@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/vladimiradmaev/diabetes-helper/internal/bot/state"
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+)
+
+// bloodSugarStatsPeriods are the periods in days the statistics can be shown for
+var bloodSugarStatsPeriods = []int{7, 14, 90}
+
+// defaultBloodSugarStatsDays is the period shown first
+const defaultBloodSugarStatsDays = 14
+
+// trendThreshold is the change of the mean in mmol/L below which glucose
+// counts as stable
+const trendThreshold = 0.5
+
+// handleBloodSugarStatsPeriod handles a period button, "blood_sugar_stats_<days>"
+func (h *CallbackHandler) handleBloodSugarStatsPeriod(ctx context.Context, chatID int64, daysStr string, user *database.User) error {
+	days, err := strconv.Atoi(daysStr)
+	if err != nil || days <= 0 || days > 90 {
+		return h.handleUnknownCallback(chatID)
+	}
+	return h.handleBloodSugarStats(ctx, chatID, user, days)
+}
+
+// handleBloodSugarStats shows the glucose statistics of the last days
+func (h *CallbackHandler) handleBloodSugarStats(ctx context.Context, chatID int64, user *database.User, days int) error {
+	// Opened from the glucose prompt, which waits for a reading
+	h.stateManager.SetUserState(user.TelegramID, state.None)
+
+	stats, err := h.deps.BloodSugarSvc.GetStatistics(ctx, user.ID, time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		logger.Error("Failed to get blood sugar statistics", "user_id", user.ID, "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при получении статистики сахара")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "📊 Сахар за %d дней\n\n", days)
+	if stats.Count == 0 {
+		b.WriteString("За этот период нет записей сахара.")
+	} else {
+		unit := user.GlucoseUnit
+		fmt.Fprintf(&b, "Записей: %d\n", stats.Count)
+		fmt.Fprintf(&b, "Средний: %s\n", formatGlucose(stats.Mean, unit))
+		fmt.Fprintf(&b, "Минимум: %s\n", formatGlucose(stats.Min, unit))
+		fmt.Fprintf(&b, "Максимум: %s\n", formatGlucose(stats.Max, unit))
+		if stats.Count > 1 {
+			fmt.Fprintf(&b, "Стандартное отклонение: %s\n", formatGlucose(stats.StdDev, unit))
+		}
+		fmt.Fprintf(&b, "Расчетный HbA1c: %.1f%%\n", stats.EstimatedHbA1c)
+		switch {
+		case stats.Trend >= trendThreshold:
+			fmt.Fprintf(&b, "Тренд: ↗️ растет, во второй половине периода в среднем на %s выше\n", formatGlucose(stats.Trend, unit))
+		case stats.Trend <= -trendThreshold:
+			fmt.Fprintf(&b, "Тренд: ↘️ снижается, во второй половине периода в среднем на %s ниже\n", formatGlucose(math.Abs(stats.Trend), unit))
+		case stats.Trend != 0:
+			b.WriteString("Тренд: ➡️ стабильный\n")
+		}
+		b.WriteString("\nHbA1c оценивается по среднему сахару и может отличаться от лабораторного анализа.")
+	}
+
+	var periods []tgbotapi.InlineKeyboardButton
+	for _, period := range bloodSugarStatsPeriods {
+		label := fmt.Sprintf("%d дней", period)
+		if period == days {
+			label = "• " + label
+		}
+		periods = append(periods, tgbotapi.NewInlineKeyboardButtonData(label, fmt.Sprintf("blood_sugar_stats_%d", period)))
+	}
+	msg := tgbotapi.NewMessage(chatID, b.String())
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		periods,
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("◀️ Главное меню", "main_menu"),
+		),
+	)
+	_, err = h.api.Send(msg)
+	return err
+}
@@ -3,11 +3,21 @@ package handlers
 import (
 	"context"
 	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/vladimiradmaev/diabetes-helper/internal/bot/keyboards"
 	"github.com/vladimiradmaev/diabetes-helper/internal/bot/menus"
+	"github.com/vladimiradmaev/diabetes-helper/internal/bot/messages"
 	"github.com/vladimiradmaev/diabetes-helper/internal/bot/state"
 	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/dosing"
+	"github.com/vladimiradmaev/diabetes-helper/internal/format"
+	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+	"github.com/vladimiradmaev/diabetes-helper/internal/services"
 )
 
 // CallbackHandler handles callback query messages
@@ -38,13 +48,21 @@ func (h *CallbackHandler) Handle(ctx context.Context, query *tgbotapi.CallbackQu
 	case "analyze_food":
 		return h.handleAnalyzeFood(query.Message.Chat.ID, user)
 	case "settings":
-		return h.handleSettings(query.Message.Chat.ID)
+		return h.handleSettings(ctx, query.Message.Chat.ID, user)
+	case "low_recheck":
+		return h.handleLowRecheck(ctx, query.Message.Chat.ID, user)
+	case "low_rule":
+		return h.handleLowRuleSetup(query.Message.Chat.ID, user)
+	case "correction_factor":
+		return h.handleCorrectionFactor(query.Message.Chat.ID, user)
+	case "active_insulin_time":
+		return h.handleActiveInsulinTime(ctx, query.Message.Chat.ID, user)
 	case "insulin_ratio":
 		return h.handleInsulinRatio(query.Message.Chat.ID, user)
 	case "add_insulin_ratio":
 		return h.handleAddInsulinRatio(query.Message.Chat.ID, user)
 	case "main_menu":
-		return h.handleMainMenu(query.Message.Chat.ID, user)
+		return h.handleMainMenu(ctx, query.Message.Chat.ID, user)
 	case "edit_insulin_ratio":
 		return h.handleEditInsulinRatio(query.Message.Chat.ID, user)
 	case "clear_and_add_ratio":
@@ -57,19 +75,178 @@ func (h *CallbackHandler) Handle(ctx context.Context, query *tgbotapi.CallbackQu
 		return h.handleHelp(query.Message.Chat.ID)
 	case "food_examples":
 		return h.handleFoodExamples(query.Message.Chat.ID)
+	case "blood_sugar":
+		return h.handleBloodSugar(query.Message.Chat.ID, user)
+	case "blood_sugar_stats":
+		return h.handleBloodSugarStats(ctx, query.Message.Chat.ID, user, defaultBloodSugarStatsDays)
+	case "toggle_glucose_unit":
+		return h.handleToggleGlucoseUnit(ctx, query.Message.Chat.ID, user)
+	case "toggle_result_verbosity":
+		return h.handleToggleResultVerbosity(ctx, query.Message.Chat.ID, user)
+	case "toggle_iob_model":
+		return h.handleToggleIOBModel(ctx, query.Message.Chat.ID, user)
+	case "toggle_bread_unit_step":
+		return h.handleToggleBreadUnitStep(ctx, query.Message.Chat.ID, user)
+	case "toggle_carbs_step":
+		return h.handleToggleCarbsStep(ctx, query.Message.Chat.ID, user)
+	case "toggle_notifications":
+		return h.handleToggleNotifications(ctx, query.Message.Chat.ID, user)
+	case "toggle_measurement_system":
+		return h.handleToggleMeasurementSystem(ctx, query.Message.Chat.ID, user)
+	case "toggle_bolus_timing":
+		return h.handleToggleBolusTiming(ctx, query.Message.Chat.ID, user)
+	case "toggle_reanalyze_primary":
+		return h.handleToggleReanalyzeOnPrimary(ctx, query.Message.Chat.ID, user)
+	case "toggle_archive_photos":
+		return h.handleToggleArchivePhotos(ctx, query.Message.Chat.ID, user)
+	case "toggle_keep_prompts":
+		return h.handleToggleKeepPrompts(ctx, query.Message.Chat.ID, user)
+	case "last_result":
+		return sendLastResult(ctx, h.api, h.deps, query.Message.Chat.ID, user)
+	case "claim_merge":
+		return h.handleClaimMerge(ctx, query.Message.Chat.ID, user)
+	case "claim_cancel":
+		return h.handleClaimCancel(query.Message.Chat.ID, user)
+	case "bs_unit_convert":
+		return h.handleBloodSugarUnitChoice(ctx, query.Message.Chat.ID, user, true)
+	case "bs_unit_keep":
+		return h.handleBloodSugarUnitChoice(ctx, query.Message.Chat.ID, user, false)
+	case "bs_unit_cancel":
+		return h.handleBloodSugarUnitCancel(ctx, query.Message.Chat.ID, user)
+	case "carb_target":
+		return h.handleCarbTarget(query.Message.Chat.ID, user)
+	case "basal_reminder":
+		return h.handleBasalReminder(query.Message.Chat.ID, user)
+	case "export_csv":
+		return h.handleExportFormat(ctx, query.Message.Chat.ID, user, services.JobKindExport)
+	case "export_tidepool":
+		return h.handleExportFormat(ctx, query.Message.Chat.ID, user, services.JobKindExportTidepool)
+	case "quiet_hours":
+		return h.handleQuietHours(query.Message.Chat.ID, user)
+	case "timezone":
+		return h.handleTimezone(query.Message.Chat.ID, user)
+	case "travel_mode":
+		return h.handleTravelMode(query.Message.Chat.ID, user)
+	case "travel_mode_off":
+		return h.handleTravelModeOff(ctx, query.Message.Chat.ID, user)
+	case "basal_rates":
+		return h.handleBasalRates(ctx, query.Message.Chat.ID, user)
+	case "add_basal_rate":
+		return h.handleAddBasalRate(query.Message.Chat.ID, user)
+	case "delete_basal_rates":
+		return h.handleDeleteBasalRates(ctx, query.Message.Chat.ID, user)
+	case "clear_basal_rates":
+		return h.handleClearBasalRates(ctx, query.Message.Chat.ID, user)
+	case "ratio_import":
+		return h.handleRatioImport(query.Message.Chat.ID, user)
+	case "ratio_import_save":
+		return h.handleRatioImportSave(ctx, query.Message.Chat.ID, user)
+	case "confirm_changes":
+		return h.handleConfirmChanges(ctx, query.Message.Chat.ID, user)
+	case "ratio_confirm":
+		return h.handleRatioConfirm(ctx, query.Message.Chat.ID, user)
+	case "ratio_reenter":
+		return h.handleRatioReenter(query.Message.Chat.ID, user)
+	case "ratio_schedule_image":
+		return sendRatioSchedule(ctx, h.api, h.deps, query.Message.Chat.ID, user)
+	case "schedule_ratio_change":
+		return h.handleScheduleRatioChange(query.Message.Chat.ID, user)
+	case "ratio_change":
+		return h.handleRatioChange(query.Message.Chat.ID, user)
+	case "ratio_change_cancel":
+		return h.handleRatioChangeCancel(ctx, query.Message.Chat.ID, user)
+	case "ratio_presets":
+		return h.handleRatioPresets(query.Message.Chat.ID)
+	case "snapshot_save":
+		return h.handleSnapshotSave(query.Message.Chat.ID, user)
+	case "snapshots":
+		return h.handleSnapshots(ctx, query.Message.Chat.ID, user)
+	case "share_settings":
+		return h.handleShareSettings(ctx, query.Message.Chat.ID, user)
+	case "share_import":
+		return h.handleShareImport(query.Message.Chat.ID, user)
+	case "share_import_apply":
+		return h.handleShareImportApply(ctx, query.Message.Chat.ID, user)
+	case "manual_carbs":
+		return h.handleManualCarbs(query.Message.Chat.ID, user)
+	case "save_manual_carbs":
+		return h.handleSaveManualCarbs(ctx, query.Message.Chat.ID, user)
+	case "history_carbs_use":
+		return h.handleHistoryCarbsUse(ctx, query.Message.Chat.ID, user)
+	case "history_carbs_decline":
+		return h.handleHistoryCarbsDecline(query.Message.Chat.ID, user)
+	case "correct_total":
+		return h.handleCorrectTotal(query.Message.Chat.ID, user)
+	case "correct_save":
+		return h.handleCorrectSave(ctx, query.Message.Chat.ID, user)
+	case "copy_yesterday":
+		return h.handleCopyYesterday(ctx, query.Message.Chat.ID, user)
+	case "log_injection":
+		return h.handleLogInjection(query.Message.Chat.ID, user)
+	case "injection_sites":
+		return sendInjectionSites(ctx, h.api, h.deps, query.Message.Chat.ID, user)
+	case "food_history":
+		return h.handleFoodHistory(ctx, query.Message.Chat.ID, user, 0)
+	case "food_history_prev":
+		return h.handleFoodHistoryPage(ctx, query.Message.Chat.ID, user, -1)
+	case "food_history_next":
+		return h.handleFoodHistoryPage(ctx, query.Message.Chat.ID, user, 1)
+	case "food_history_back":
+		return h.handleFoodHistoryPage(ctx, query.Message.Chat.ID, user, 0)
+	case "bs_history":
+		return h.handleBloodSugarHistory(ctx, query.Message.Chat.ID, user, 1)
 	default:
-		return h.handleUnknownCallback(query.Message.Chat.ID)
+		return h.handlePrefixedCallback(ctx, query.Message.Chat.ID, query.Data, user)
+	}
+}
+
+// handlePrefixedCallback handles callbacks that carry a parameter in their data
+func (h *CallbackHandler) handlePrefixedCallback(ctx context.Context, chatID int64, data string, user *database.User) error {
+	switch {
+	case strings.HasPrefix(data, "apply_ratio_preset_"):
+		return h.handleApplyRatioPreset(ctx, chatID, strings.TrimPrefix(data, "apply_ratio_preset_"), user)
+	case strings.HasPrefix(data, "snapshot_restore_confirm_"):
+		return h.handleSnapshotRestoreConfirm(ctx, chatID, strings.TrimPrefix(data, "snapshot_restore_confirm_"), user)
+	case strings.HasPrefix(data, "snapshot_restore_"):
+		return h.handleSnapshotRestore(ctx, chatID, strings.TrimPrefix(data, "snapshot_restore_"), user)
+	case strings.HasPrefix(data, "blood_sugar_stats_"):
+		return h.handleBloodSugarStatsPeriod(ctx, chatID, strings.TrimPrefix(data, "blood_sugar_stats_"), user)
+	case strings.HasPrefix(data, "correct_analysis_"):
+		return h.handleCorrectAnalysis(ctx, chatID, strings.TrimPrefix(data, "correct_analysis_"), user)
+	case strings.HasPrefix(data, "reanalyze_primary_"):
+		return h.handleReanalyzePrimary(ctx, chatID, strings.TrimPrefix(data, "reanalyze_primary_"), user)
+	case strings.HasPrefix(data, "correct_item_"):
+		return h.handleCorrectItem(ctx, chatID, strings.TrimPrefix(data, "correct_item_"), user)
+	case strings.HasPrefix(data, "clone_meal_"):
+		return h.handleCloneMeal(ctx, chatID, strings.TrimPrefix(data, "clone_meal_"), user)
+	case strings.HasPrefix(data, "basal_taken_"):
+		return h.handleBasalTaken(ctx, chatID, strings.TrimPrefix(data, "basal_taken_"), user)
+	case strings.HasPrefix(data, "inj_site_"):
+		return h.handleInjectionSite(ctx, chatID, strings.TrimPrefix(data, "inj_site_"), user)
+	case strings.HasPrefix(data, "food_history_item_"):
+		return h.handleFoodHistoryItem(ctx, chatID, strings.TrimPrefix(data, "food_history_item_"), user)
+	case strings.HasPrefix(data, "bs_history_page_"):
+		return h.handleBloodSugarHistoryPage(ctx, chatID, strings.TrimPrefix(data, "bs_history_page_"), user)
+	case strings.HasPrefix(data, "queue_cancel_"):
+		return h.handleQueueCancel(ctx, chatID, strings.TrimPrefix(data, "queue_cancel_"), user)
+	case strings.HasPrefix(data, "edit_ratio_"):
+		return h.handleEditRatio(chatID, strings.TrimPrefix(data, "edit_ratio_"), user)
+	case strings.HasPrefix(data, "ratio_preset_"):
+		return h.handleRatioPreset(chatID, strings.TrimPrefix(data, "ratio_preset_"))
+	default:
+		return h.handleUnknownCallback(chatID)
 	}
 }
 
 // handleAnalyzeFood handles analyze food callback
 func (h *CallbackHandler) handleAnalyzeFood(chatID int64, user *database.User) error {
-	h.stateManager.SetUserState(user.TelegramID, "analyzing_food")
+	h.stateManager.SetUserState(user.TelegramID, state.WaitingForFoodWeight)
+	h.stateManager.ClearUserWeight(user.TelegramID)
 
 	text := `📷 *Отправьте фото еды для анализа*
 
 💡 *Для точного расчета:*
-• Укажите вес в подписи к фото (например: "150")
+• Укажите вес в подписи к фото (например: "150") или отправьте его числом перед фото
 • Сфотографируйте блюдо целиком
 • Убедитесь, что освещение хорошее
 
@@ -95,12 +272,313 @@ func (h *CallbackHandler) handleAnalyzeFood(chatID int64, user *database.User) e
 }
 
 // handleSettings handles settings callback
-func (h *CallbackHandler) handleSettings(chatID int64) error {
-	return menus.SendSettingsMenu(h.api, chatID)
+func (h *CallbackHandler) handleSettings(ctx context.Context, chatID int64, user *database.User) error {
+	return sendSettingsMenu(ctx, h.api, h.deps, chatID, user)
+}
+
+// handleToggleGlucoseUnit switches the user's glucose unit between mmol/L and mg/dL
+func (h *CallbackHandler) handleToggleGlucoseUnit(ctx context.Context, chatID int64, user *database.User) error {
+	unit := services.OtherGlucoseUnit(user.GlucoseUnit)
+	if err := h.deps.UserService.SetGlucoseUnit(ctx, user.ID, unit); err != nil {
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при сохранении единиц измерения")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+	user.GlucoseUnit = unit
+	return sendSettingsMenu(ctx, h.api, h.deps, chatID, user)
+}
+
+// handleToggleResultVerbosity switches analysis results between full and compact
+func (h *CallbackHandler) handleToggleResultVerbosity(ctx context.Context, chatID int64, user *database.User) error {
+	verbosity := services.ResultVerbosityCompact
+	if services.CompactResults(user) {
+		verbosity = services.ResultVerbosityFull
+	}
+	if err := h.deps.UserService.SetResultVerbosity(ctx, user.ID, verbosity); err != nil {
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при сохранении настройки")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+	user.ResultVerbosity = verbosity
+	return sendSettingsMenu(ctx, h.api, h.deps, chatID, user)
+}
+
+// handleToggleIOBModel switches insulin on board between linear and curved decay
+func (h *CallbackHandler) handleToggleIOBModel(ctx context.Context, chatID int64, user *database.User) error {
+	model := dosing.IOBCurved
+	if services.CurvedIOB(user) {
+		model = dosing.IOBLinear
+	}
+	if err := h.deps.UserService.SetIOBModel(ctx, user.ID, model); err != nil {
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при сохранении настройки")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+	user.IOBModel = model
+	return sendSettingsMenu(ctx, h.api, h.deps, chatID, user)
+}
+
+// handleToggleArchivePhotos turns meal photo archiving on or off. Turning it
+// off also removes the photos archived so far.
+func (h *CallbackHandler) handleToggleArchivePhotos(ctx context.Context, chatID int64, user *database.User) error {
+	enabled := !user.ArchivePhotos
+	if !enabled {
+		// Delete first, so a failure leaves the setting on and the user can retry
+		if err := h.deps.FoodAnalysisSvc.DeleteArchivedPhotos(ctx, user.ID); err != nil {
+			logger.Error("Failed to delete archived photos", "user_id", user.ID, "error", err)
+			msg := tgbotapi.NewMessage(chatID, "Ошибка при удалении сохраненных фото, попробуйте позже")
+			_, sendErr := h.api.Send(msg)
+			return sendErr
+		}
+	}
+	if err := h.deps.UserService.SetArchivePhotos(ctx, user.ID, enabled); err != nil {
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при сохранении настройки")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+	user.ArchivePhotos = enabled
+
+	text := "📷 Фото блюд будут сохраняться и останутся доступны, даже если Telegram их удалит"
+	if !enabled {
+		text = "📷 Фото блюд больше не сохраняются, сохраненные фото удалены"
+	}
+	if _, err := h.api.Send(tgbotapi.NewMessage(chatID, text)); err != nil {
+		return err
+	}
+	return sendSettingsMenu(ctx, h.api, h.deps, chatID, user)
+}
+
+// handleToggleBreadUnitStep switches to the next display rounding of bread units
+func (h *CallbackHandler) handleToggleBreadUnitStep(ctx context.Context, chatID int64, user *database.User) error {
+	steps := services.BreadUnitSteps
+	step := steps[(slices.Index(steps, services.BreadUnitStep(user))+1)%len(steps)]
+	if err := h.deps.UserService.SetBreadUnitStep(ctx, user.ID, step); err != nil {
+		logger.Error("Failed to save bread unit rounding", "user_id", user.ID, "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при сохранении настройки")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+	user.BreadUnitStep = step
+	return sendSettingsMenu(ctx, h.api, h.deps, chatID, user)
+}
+
+// handleToggleMeasurementSystem switches portion estimation between metric
+// and imperial references
+func (h *CallbackHandler) handleToggleMeasurementSystem(ctx context.Context, chatID int64, user *database.User) error {
+	system := services.MeasurementImperial
+	if services.ImperialUnits(user) {
+		system = services.MeasurementMetric
+	}
+	if err := h.deps.UserService.SetMeasurementSystem(ctx, user.ID, system); err != nil {
+		logger.Error("Failed to save measurement system", "user_id", user.ID, "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при сохранении настройки")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+	user.MeasurementSystem = system
+	return sendSettingsMenu(ctx, h.api, h.deps, chatID, user)
+}
+
+// handleToggleCarbsStep switches to the next rounding of analysed carbs
+func (h *CallbackHandler) handleToggleCarbsStep(ctx context.Context, chatID int64, user *database.User) error {
+	steps := services.CarbsSteps
+	step := steps[(slices.Index(steps, user.CarbsStep)+1)%len(steps)]
+	if err := h.deps.UserService.SetCarbsStep(ctx, user.ID, step); err != nil {
+		logger.Error("Failed to save carbs rounding", "user_id", user.ID, "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при сохранении настройки")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+	user.CarbsStep = step
+	return sendSettingsMenu(ctx, h.api, h.deps, chatID, user)
+}
+
+// handleToggleBolusTiming shows or hides the injection timing hint in results
+func (h *CallbackHandler) handleToggleBolusTiming(ctx context.Context, chatID int64, user *database.User) error {
+	hide := !user.HideBolusTiming
+	if err := h.deps.UserService.SetHideBolusTiming(ctx, user.ID, hide); err != nil {
+		logger.Error("Failed to save bolus timing setting", "user_id", user.ID, "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при сохранении настройки")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+	user.HideBolusTiming = hide
+	return sendSettingsMenu(ctx, h.api, h.deps, chatID, user)
+}
+
+// handleToggleReanalyzeOnPrimary switches the offer to repeat fallback-model
+// analyses on the primary model
+func (h *CallbackHandler) handleToggleReanalyzeOnPrimary(ctx context.Context, chatID int64, user *database.User) error {
+	enabled := !user.ReanalyzeOnPrimary
+	if err := h.deps.UserService.SetReanalyzeOnPrimary(ctx, user.ID, enabled); err != nil {
+		logger.Error("Failed to save primary re-analysis setting", "user_id", user.ID, "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при сохранении настройки")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+	user.ReanalyzeOnPrimary = enabled
+	return sendSettingsMenu(ctx, h.api, h.deps, chatID, user)
+}
+
+// handleToggleKeepPrompts switches between removing and keeping the prompts
+// of finished input flows
+func (h *CallbackHandler) handleToggleKeepPrompts(ctx context.Context, chatID int64, user *database.User) error {
+	keep := !user.KeepPrompts
+	if err := h.deps.UserService.SetKeepPrompts(ctx, user.ID, keep); err != nil {
+		logger.Error("Failed to save prompt cleanup setting", "user_id", user.ID, "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при сохранении настройки")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+	user.KeepPrompts = keep
+	return sendSettingsMenu(ctx, h.api, h.deps, chatID, user)
+}
+
+// handleBloodSugar handles blood sugar callback
+func (h *CallbackHandler) handleBloodSugar(chatID int64, user *database.User) error {
+	h.stateManager.SetUserState(user.TelegramID, state.WaitingForBloodSugar)
+	h.stateManager.ClearTempData(user.TelegramID)
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("📊 Статистика", "blood_sugar_stats"),
+			tgbotapi.NewInlineKeyboardButtonData("◀️ Отмена", "main_menu"),
+		),
+	)
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("Введите уровень сахара в крови (%s):", services.GlucoseUnitLabel(user.GlucoseUnit)))
+	msg.ReplyMarkup = keyboard
+	_, err := h.api.Send(msg)
+	return err
+}
+
+// handleBloodSugarUnitChoice saves a pending blood sugar value after the user resolved a unit mismatch
+func (h *CallbackHandler) handleBloodSugarUnitChoice(ctx context.Context, chatID int64, user *database.User, convert bool) error {
+	valueVal, ok := h.stateManager.GetTempData(user.TelegramID, "pendingBloodSugar")
+	value, isFloat := valueVal.(float64)
+	if !ok || !isFloat {
+		msg := tgbotapi.NewMessage(chatID, "Значение не найдено. Пожалуйста, введите уровень сахара еще раз.")
+		_, err := h.api.Send(msg)
+		return err
+	}
+
+	unit := user.GlucoseUnit
+	if convert {
+		unit = services.OtherGlucoseUnit(unit)
+	}
+	mmol := services.ToMmol(value, unit)
+
+	if err := h.deps.BloodSugarSvc.AddRecord(ctx, user.ID, mmol); err != nil {
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при сохранении уровня сахара")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+
+	h.stateManager.ClearTempData(user.TelegramID)
+	h.stateManager.SetUserState(user.TelegramID, state.None)
+
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("✅ Уровень сахара %s сохранен", formatGlucose(mmol, user.GlucoseUnit)))
+	msg.ReplyMarkup = keyboards.MainMenu()
+	_, err := h.api.Send(msg)
+	return err
+}
+
+// handleBloodSugarUnitCancel discards a pending blood sugar value
+func (h *CallbackHandler) handleBloodSugarUnitCancel(ctx context.Context, chatID int64, user *database.User) error {
+	h.stateManager.ClearTempData(user.TelegramID)
+	h.stateManager.SetUserState(user.TelegramID, state.None)
+	return menus.SendMainMenu(h.api, chatID, mainMenuStatus(ctx, h.deps, user))
+}
+
+// handleCarbTarget handles daily carb target callback
+func (h *CallbackHandler) handleCarbTarget(chatID int64, user *database.User) error {
+	h.stateManager.SetUserState(user.TelegramID, state.WaitingForCarbTarget)
+
+	text := "Введите дневную цель по углеводам в граммах (например, 220).\nОтправьте 0, чтобы отключить отслеживание."
+	if user.DailyCarbTarget > 0 {
+		text = fmt.Sprintf("Текущая цель: %.0f г в день.\n\n", user.DailyCarbTarget) + text
+	}
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("◀️ Отмена", "settings"),
+		),
+	)
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyMarkup = keyboard
+	_, err := h.api.Send(msg)
+	return err
+}
+
+// handleTimezone handles timezone callback
+func (h *CallbackHandler) handleTimezone(chatID int64, user *database.User) error {
+	h.stateManager.SetUserState(user.TelegramID, state.WaitingForTimezone)
+
+	current := user.Timezone
+	if current == "" {
+		current = "время сервера"
+	}
+	text := fmt.Sprintf("Текущий часовой пояс: %s\n\n"+
+		"Введите часовой пояс в формате Europe/Moscow или смещение от UTC (например, +3):", current)
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("◀️ Отмена", "settings"),
+		),
+	)
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyMarkup = keyboard
+	_, err := h.api.Send(msg)
+	return err
+}
+
+// handleTravelMode shows the active travel mode or asks for the destination timezone
+func (h *CallbackHandler) handleTravelMode(chatID int64, user *database.User) error {
+	if services.TravelModeActive(user, time.Now()) {
+		text := travelModeBanner(user) + "\n\nКоэффициенты, напоминания и время считаются по местному времени."
+		msg := tgbotapi.NewMessage(chatID, text)
+		msg.ReplyMarkup = keyboards.TravelModeMenu()
+		_, err := h.api.Send(msg)
+		return err
+	}
+
+	h.stateManager.SetUserState(user.TelegramID, state.WaitingForTravelMode)
+
+	text := "✈️ Режим путешествия временно переводит коэффициенты, напоминания и время на часовой пояс поездки, " +
+		"не меняя основной.\n\n" +
+		"Введите часовой пояс места назначения и, по желанию, последний день поездки:\n" +
+		"Asia/Tokyo 25.10\n+9\nEurope/Berlin 03.11.2025"
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("◀️ Отмена", "settings"),
+		),
+	)
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyMarkup = keyboard
+	_, err := h.api.Send(msg)
+	return err
+}
+
+// handleTravelModeOff ends travel mode
+func (h *CallbackHandler) handleTravelModeOff(ctx context.Context, chatID int64, user *database.User) error {
+	if err := h.deps.UserService.ClearTravelMode(ctx, user.ID); err != nil {
+		logger.Error("Failed to end travel mode", "user_id", user.ID, "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при завершении режима путешествия")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+	user.TravelTimezone = ""
+	user.TravelUntil = nil
+
+	msg := tgbotapi.NewMessage(chatID, "🏠 Режим путешествия завершен, снова используется основной часовой пояс")
+	if _, err := h.api.Send(msg); err != nil {
+		return err
+	}
+	return sendSettingsMenu(ctx, h.api, h.deps, chatID, user)
 }
 
 // handleInsulinRatio handles insulin ratio callback
 func (h *CallbackHandler) handleInsulinRatio(chatID int64, user *database.User) error {
+	clearPrompts(h.api, h.stateManager, chatID, user)
 	ratios, err := h.deps.InsulinSvc.GetUserRatios(context.Background(), user.ID)
 	if err != nil {
 		msg := tgbotapi.NewMessage(chatID, "Ошибка при получении коэффициентов")
@@ -122,49 +600,105 @@ func (h *CallbackHandler) handleAddInsulinRatio(chatID int64, user *database.Use
 	)
 	msg := tgbotapi.NewMessage(chatID, "Введите период времени в формате ЧЧ:ММ-ЧЧ:ММ (например, 08:00-12:00):")
 	msg.ReplyMarkup = keyboard
+	return sendPrompt(h.api, h.stateManager, user, msg)
+}
+
+// handleRatioPresets shows the list of built-in ratio presets
+func (h *CallbackHandler) handleRatioPresets(chatID int64) error {
+	text := "📋 *Шаблоны коэффициентов*\n\n" +
+		"Шаблон — это отправная точка с типичными значениями на все 24 часа. " +
+		"Подберите свои коэффициенты вместе с врачом!\n\n"
+	for _, p := range services.RatioPresets {
+		text += fmt.Sprintf("• *%s* — %s\n", p.Name, p.Description)
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ParseMode = "Markdown"
+	msg.ReplyMarkup = keyboards.RatioPresetsMenu()
 	_, err := h.api.Send(msg)
 	return err
 }
 
-// handleMainMenu handles main menu callback
-func (h *CallbackHandler) handleMainMenu(chatID int64, user *database.User) error {
-	h.stateManager.SetUserState(user.TelegramID, state.None)
-	return menus.SendMainMenu(h.api, chatID)
+// handleRatioPreset shows a preview of a ratio preset before applying it
+func (h *CallbackHandler) handleRatioPreset(chatID int64, presetID string) error {
+	preset, ok := services.GetRatioPreset(presetID)
+	if !ok {
+		return h.handleUnknownCallback(chatID)
+	}
+
+	text := fmt.Sprintf("📋 Шаблон «%s»\n\n", preset.Name)
+	for _, p := range preset.Periods {
+		text += fmt.Sprintf("🕒 %s - %s: %.1f ед/ХЕ\n", p.StartTime, p.EndTime, p.Ratio)
+	}
+	text += "\n⚠️ Это примерные значения, а не назначение. Обязательно скорректируйте их под себя вместе с врачом.\n\n" +
+		"Применение шаблона заменит все текущие периоды."
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✅ Применить", "apply_ratio_preset_"+preset.ID),
+			tgbotapi.NewInlineKeyboardButtonData("◀️ Назад", "ratio_presets"),
+		),
+	)
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyMarkup = keyboard
+	_, err := h.api.Send(msg)
+	return err
 }
 
-// handleEditInsulinRatio handles edit insulin ratio callback
-func (h *CallbackHandler) handleEditInsulinRatio(chatID int64, user *database.User) error {
-	ratios, err := h.deps.InsulinSvc.GetUserRatios(context.Background(), user.ID)
+// handleApplyRatioPreset replaces the user's ratios with the selected preset
+func (h *CallbackHandler) handleApplyRatioPreset(ctx context.Context, chatID int64, presetID string, user *database.User) error {
+	if err := h.deps.InsulinSvc.ApplyRatioPreset(ctx, user.ID, presetID); err != nil {
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при применении шаблона")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+
+	msg := tgbotapi.NewMessage(chatID, "✅ Шаблон применен. Не забудьте скорректировать коэффициенты под себя.")
+	if _, err := h.api.Send(msg); err != nil {
+		return err
+	}
+
+	ratios, err := h.deps.InsulinSvc.GetUserRatios(ctx, user.ID)
 	if err != nil {
-		msg := tgbotapi.NewMessage(chatID, "Ошибка при получении коэффициентов")
-		_, err := h.api.Send(msg)
 		return err
 	}
+	return menus.SendInsulinRatioMenu(h.api, chatID, ratios)
+}
 
-	if len(ratios) == 0 {
-		msg := tgbotapi.NewMessage(chatID, "Нет сохраненных коэффициентов для редактирования")
+// handleMainMenu handles main menu callback
+func (h *CallbackHandler) handleMainMenu(ctx context.Context, chatID int64, user *database.User) error {
+	h.stateManager.SetUserState(user.TelegramID, state.None)
+	return menus.SendMainMenu(h.api, chatID, mainMenuStatus(ctx, h.deps, user))
+}
+
+// handleRatioConfirm saves a ratio outside the typical range after the user confirmed it
+func (h *CallbackHandler) handleRatioConfirm(ctx context.Context, chatID int64, user *database.User) error {
+	ratioVal, _ := h.stateManager.GetTempData(user.TelegramID, "pendingRatio")
+	startTimeVal, _ := h.stateManager.GetTempData(user.TelegramID, "startTime")
+	endTimeVal, _ := h.stateManager.GetTempData(user.TelegramID, "endTime")
+	ratio, okRatio := ratioVal.(float64)
+	startTime, okStart := startTimeVal.(string)
+	endTime, okEnd := endTimeVal.(string)
+	if !okRatio || !okStart || !okEnd {
+		msg := tgbotapi.NewMessage(chatID, "Коэффициент не найден. Пожалуйста, добавьте его еще раз.")
 		_, err := h.api.Send(msg)
 		return err
 	}
+	return saveInsulinRatio(ctx, h.api, h.deps, h.stateManager, chatID, user, startTime, endTime, ratio)
+}
 
-	// Show confirmation message
-	text := "⚠️ Внимание!\n\nРедактирование коэффициентов удалит все существующие периоды.\n\n"
-	text += "Текущие периоды:\n"
-	for _, r := range ratios {
-		text += fmt.Sprintf("• %s-%s: %.1f ед/ХЕ\n", r.StartTime, r.EndTime, r.Ratio)
-	}
-	text += "\nПродолжить?"
+// handleRatioReenter asks for the ratio again after an unusual value
+func (h *CallbackHandler) handleRatioReenter(chatID int64, user *database.User) error {
+	h.stateManager.SetUserState(user.TelegramID, state.WaitingForInsulinRatio)
 
 	keyboard := tgbotapi.NewInlineKeyboardMarkup(
 		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("✅ Да, удалить все", "clear_and_add_ratio"),
-			tgbotapi.NewInlineKeyboardButtonData("❌ Нет", "insulin_ratio"),
+			tgbotapi.NewInlineKeyboardButtonData("◀️ Отмена", "insulin_ratio"),
 		),
 	)
-	msg := tgbotapi.NewMessage(chatID, text)
+	msg := tgbotapi.NewMessage(chatID, "Введите коэффициент (количество единиц инсулина на 1 ХЕ):")
 	msg.ReplyMarkup = keyboard
-	_, err = h.api.Send(msg)
-	return err
+	return sendPrompt(h.api, h.stateManager, user, msg)
 }
 
 // handleClearAndAddRatio handles clear and add ratio callback
@@ -196,8 +730,7 @@ func (h *CallbackHandler) handleClearAndAddRatio(chatID int64, user *database.Us
 	)
 	msg := tgbotapi.NewMessage(chatID, "Введите период времени в формате ЧЧ:ММ-ЧЧ:ММ (например, 08:00-12:00):")
 	msg.ReplyMarkup = keyboard
-	_, err = h.api.Send(msg)
-	return err
+	return sendPrompt(h.api, h.stateManager, user, msg)
 }
 
 // handleDeleteInsulinRatio handles delete insulin ratio callback
@@ -268,29 +801,12 @@ func (h *CallbackHandler) handleClearRatios(chatID int64, user *database.User) e
 
 // handleHelp handles help callback
 func (h *CallbackHandler) handleHelp(chatID int64) error {
-	text := `🤖 *Справка по использованию бота*
-
-*🍽️ Анализ еды:*
-• Отправьте фото блюда
-• В подписи можете указать вес в граммах (например: "150")
-• Если вес не указан, ИИ попробует определить его самостоятельно, но результат может быть менее точным
-• Получите информацию об углеводах, ХЕ и дозе инсулина
-
-*⚙️ Настройки:*
-• Установите коэффициенты инсулина на ХЕ для разного времени суток
-• Это повысит точность расчета дозы инсулина
-
-*💡 Советы:*
-• Указывайте точный вес блюда для наиболее точного расчета
-• Настройте коэффициенты для персонализированных рекомендаций
-• Всегда консультируйтесь с врачом!`
-
 	keyboard := tgbotapi.NewInlineKeyboardMarkup(
 		tgbotapi.NewInlineKeyboardRow(
 			tgbotapi.NewInlineKeyboardButtonData("◀️ Главное меню", "main_menu"),
 		),
 	)
-	msg := tgbotapi.NewMessage(chatID, text)
+	msg := tgbotapi.NewMessage(chatID, messages.Guide)
 	msg.ParseMode = "Markdown"
 	msg.ReplyMarkup = keyboard
 	_, err := h.api.Send(msg)
@@ -343,3 +859,436 @@ func (h *CallbackHandler) handleUnknownCallback(chatID int64) error {
 	_, err := h.api.Send(msg)
 	return err
 }
+
+// handleLogInjection asks for the number of insulin units to log
+func (h *CallbackHandler) handleLogInjection(chatID int64, user *database.User) error {
+	h.stateManager.SetUserState(user.TelegramID, state.WaitingForInjection)
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("◀️ Отмена", "main_menu"),
+		),
+	)
+	msg := tgbotapi.NewMessage(chatID, "Введите количество единиц инсулина (например, 4.5)")
+	msg.ReplyMarkup = keyboard
+	_, err := h.api.Send(msg)
+	return err
+}
+
+// handleInjectionSite saves the pending injection with the chosen site and
+// warns when the same site was used too many times in a row
+func (h *CallbackHandler) handleInjectionSite(ctx context.Context, chatID int64, site string, user *database.User) error {
+	unitsVal, ok := h.stateManager.GetTempData(user.TelegramID, "pendingInjectionUnits")
+	units, isFloat := unitsVal.(float64)
+	if !ok || !isFloat {
+		msg := tgbotapi.NewMessage(chatID, "Доза не найдена. Пожалуйста, начните запись укола заново.")
+		_, err := h.api.Send(msg)
+		return err
+	}
+
+	if site == "skip" {
+		site = ""
+	} else if !services.IsInjectionSite(site) {
+		return h.handleUnknownCallback(chatID)
+	}
+
+	if _, err := h.deps.InjectionSvc.LogInjection(ctx, user.ID, dosing.ToDeciUnits(units), services.InjectionKindBolus, site); err != nil {
+		logger.Error("Failed to log injection", "user_id", user.ID, "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при сохранении укола")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+	h.stateManager.ClearTempData(user.TelegramID)
+
+	text := fmt.Sprintf("✅ Укол записан: %.1f ед.", units)
+	if site != "" {
+		text += fmt.Sprintf(", %s", strings.ToLower(services.InjectionSiteName(site)))
+
+		overused, count, err := h.deps.InjectionSvc.SiteOverused(ctx, user.ID, site)
+		if err != nil {
+			logger.Error("Failed to check injection site rotation", "user_id", user.ID, "error", err)
+		} else if overused {
+			text += fmt.Sprintf("\n\n⚠️ Последние %d уколов сделаны в одно место. "+
+				"Чередуйте места инъекций, чтобы избежать липогипертрофии.", count)
+		}
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyMarkup = keyboards.InjectionLoggedMenu()
+	_, err := h.api.Send(msg)
+	return err
+}
+
+// handleManualCarbs asks for the amount of carbs to calculate a dose for
+func (h *CallbackHandler) handleManualCarbs(chatID int64, user *database.User) error {
+	h.stateManager.SetUserState(user.TelegramID, state.WaitingForManualCarbs)
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("◀️ Отмена", "main_menu"),
+		),
+	)
+	msg := tgbotapi.NewMessage(chatID, "Введите количество углеводов в граммах (например, 45). Я посчитаю ХЕ и дозу инсулина.")
+	msg.ReplyMarkup = keyboard
+	_, err := h.api.Send(msg)
+	return err
+}
+
+// handleSaveManualCarbs saves the pending manual carb entry to the history
+func (h *CallbackHandler) handleSaveManualCarbs(ctx context.Context, chatID int64, user *database.User) error {
+	carbsVal, ok := h.stateManager.GetTempData(user.TelegramID, "pendingManualCarbs")
+	carbs, isFloat := carbsVal.(float64)
+	if !ok || !isFloat {
+		msg := tgbotapi.NewMessage(chatID, "Значение не найдено. Пожалуйста, введите углеводы еще раз.")
+		_, err := h.api.Send(msg)
+		return err
+	}
+
+	if _, err := h.deps.FoodAnalysisSvc.CalculateManual(ctx, user.ID, carbs, true); err != nil {
+		logger.Error("Failed to save manual carbs", "user_id", user.ID, "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при сохранении")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+	h.stateManager.ClearTempData(user.TelegramID)
+
+	text := fmt.Sprintf("✅ Сохранено: %.0f г углеводов", carbs)
+	if progress := carbProgressText(ctx, h.deps, user); progress != "" {
+		text += "\n\n" + progress
+	}
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🏠 Главное меню", "main_menu"),
+		),
+	)
+	_, err := h.api.Send(msg)
+	return err
+}
+
+// handleSnapshotSave asks for a name for a new settings snapshot
+func (h *CallbackHandler) handleSnapshotSave(chatID int64, user *database.User) error {
+	h.stateManager.SetUserState(user.TelegramID, state.WaitingForSnapshotName)
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("◀️ Отмена", "settings"),
+		),
+	)
+	msg := tgbotapi.NewMessage(chatID, "Введите название для сохранения текущих настроек (например, «до новых коэффициентов»).\n"+
+		"Сохраняются коэффициенты на ХЕ, базальный профиль, коррекция, правило купирования гипо, единицы сахара, "+
+		"цель по углеводам, часовой пояс, активный инсулин, тихие часы, напоминание о базальном и настройки отображения.")
+	msg.ReplyMarkup = keyboard
+	_, err := h.api.Send(msg)
+	return err
+}
+
+// handleSnapshots lists saved settings snapshots
+func (h *CallbackHandler) handleSnapshots(ctx context.Context, chatID int64, user *database.User) error {
+	snapshots, err := h.deps.SnapshotSvc.ListSnapshots(ctx, user.ID)
+	if err != nil {
+		logger.Error("Failed to list settings snapshots", "user_id", user.ID, "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при получении сохраненных настроек")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+
+	text := "Выберите сохранение для восстановления:"
+	if len(snapshots) == 0 {
+		text = "У вас пока нет сохраненных настроек. Нажмите «💾 Сохранить настройки» в меню настроек."
+	}
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyMarkup = keyboards.SnapshotsMenu(snapshots, services.UserLocation(user))
+	_, err = h.api.Send(msg)
+	return err
+}
+
+// handleSnapshotRestore shows what a snapshot contains and asks for confirmation
+func (h *CallbackHandler) handleSnapshotRestore(ctx context.Context, chatID int64, idStr string, user *database.User) error {
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		return h.handleUnknownCallback(chatID)
+	}
+	snapshot, err := h.deps.SnapshotSvc.GetSnapshot(ctx, user.ID, uint(id))
+	if err != nil {
+		msg := tgbotapi.NewMessage(chatID, "Сохранение не найдено")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+	data, err := services.DecodeSnapshot(snapshot)
+	if err != nil {
+		logger.Error("Failed to decode settings snapshot", "snapshot_id", snapshot.ID, "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Сохранение повреждено и не может быть восстановлено")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+
+	text := fmt.Sprintf("♻️ Восстановить «%s»?\n\nКоэффициенты на ХЕ:\n", snapshot.Name)
+	if len(data.Ratios) == 0 {
+		text += "нет\n"
+	}
+	for _, r := range data.Ratios {
+		text += fmt.Sprintf("%s-%s: %.1f ед/ХЕ\n", r.StartTime, r.EndTime, r.Ratio)
+	}
+	text += fmt.Sprintf("\nЕдиницы сахара: %s\n", services.GlucoseUnitLabel(data.GlucoseUnit))
+	if data.DailyCarbTarget > 0 {
+		text += fmt.Sprintf("Цель по углеводам: %.0f г\n", data.DailyCarbTarget)
+	}
+	if data.Timezone != "" {
+		text += fmt.Sprintf("Часовой пояс: %s\n", data.Timezone)
+	}
+	if data.ResultVerbosity == services.ResultVerbosityCompact {
+		text += "Результат анализа: краткий\n"
+	}
+	if data.IOBModel == dosing.IOBCurved {
+		text += "Активный инсулин: по кривой\n"
+	}
+	if len(data.BasalRates) > 0 {
+		text += fmt.Sprintf("Базальный профиль: %d периодов\n", len(data.BasalRates))
+	}
+	if data.CorrectionFactor > 0 {
+		text += fmt.Sprintf("Коррекция: 1 ед снижает на %s, цель %s\n",
+			formatGlucose(data.CorrectionFactor, data.GlucoseUnit), formatGlucose(data.TargetBloodSugar, data.GlucoseUnit))
+	}
+	if data.LowRuleGrams > 0 {
+		text += fmt.Sprintf("Правило гипо: %.0f г поднимают на %s\n", data.LowRuleGrams, formatGlucose(data.LowRuleRise, data.GlucoseUnit))
+	}
+	if data.QuietHoursStart != "" {
+		text += fmt.Sprintf("Тихие часы: %s-%s\n", data.QuietHoursStart, data.QuietHoursEnd)
+	}
+	if data.BasalReminderTime != "" {
+		text += fmt.Sprintf("Напоминание о базальном: %s, %.1f ед.\n", data.BasalReminderTime, data.BasalReminderUnits)
+	}
+	if data.Version < 4 {
+		text += "\nСохранение старое: базальный профиль, коррекция и другие настройки, появившиеся позже, останутся как есть.\n"
+	}
+	text += "\nТекущие настройки будут заменены."
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✅ Восстановить", fmt.Sprintf("snapshot_restore_confirm_%d", snapshot.ID)),
+			tgbotapi.NewInlineKeyboardButtonData("◀️ Отмена", "snapshots"),
+		),
+	)
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyMarkup = keyboard
+	_, err = h.api.Send(msg)
+	return err
+}
+
+// handleSnapshotRestoreConfirm restores a snapshot
+func (h *CallbackHandler) handleSnapshotRestoreConfirm(ctx context.Context, chatID int64, idStr string, user *database.User) error {
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		return h.handleUnknownCallback(chatID)
+	}
+	if err := h.deps.SnapshotSvc.RestoreSnapshot(ctx, user.ID, uint(id)); err != nil {
+		logger.Error("Failed to restore settings snapshot", "user_id", user.ID, "snapshot_id", id, "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при восстановлении настроек. Текущие настройки не изменены.")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+
+	// Reload the user so the settings menu reflects the restored values
+	restored, err := h.deps.UserService.GetUserByTelegramID(ctx, user.TelegramID)
+	if err != nil {
+		return err
+	}
+
+	msg := tgbotapi.NewMessage(chatID, "✅ Настройки восстановлены")
+	if _, err := h.api.Send(msg); err != nil {
+		return err
+	}
+	return sendSettingsMenu(ctx, h.api, h.deps, chatID, restored)
+}
+
+// foodHistoryPageSize is how many analyses one page of the history shows
+const foodHistoryPageSize = 10
+
+// handleFoodHistoryPage shows the previous or next page of the food history
+// relative to the page the user last saw
+func (h *CallbackHandler) handleFoodHistoryPage(ctx context.Context, chatID int64, user *database.User, step int) error {
+	offset := 0
+	if value, ok := h.stateManager.GetTempData(user.TelegramID, "foodHistoryOffset"); ok {
+		if stored, ok := value.(float64); ok {
+			offset = int(stored)
+		}
+	}
+	return h.handleFoodHistory(ctx, chatID, user, offset+step*foodHistoryPageSize)
+}
+
+// handleFoodHistory shows a page of the user's analyses, newest first, with
+// a button per analysis to open it
+func (h *CallbackHandler) handleFoodHistory(ctx context.Context, chatID int64, user *database.User, offset int) error {
+	offset = max(offset, 0)
+	analyses, total, err := h.deps.FoodAnalysisSvc.GetUserAnalyses(ctx, user.ID, foodHistoryPageSize, offset)
+	if err == nil && len(analyses) == 0 && total > 0 {
+		// The history shrank since the page was shown, show the last page
+		offset = (total - 1) / foodHistoryPageSize * foodHistoryPageSize
+		analyses, total, err = h.deps.FoodAnalysisSvc.GetUserAnalyses(ctx, user.ID, foodHistoryPageSize, offset)
+	}
+	if err != nil {
+		logger.Error("Failed to get user analyses", "user_id", user.ID, "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при получении истории анализов")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+	if len(analyses) == 0 {
+		msg := tgbotapi.NewMessage(chatID, "📜 История пока пуста. Отправьте фото еды, и здесь появятся ваши анализы.")
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("🍽️ Анализ еды", "analyze_food"),
+				tgbotapi.NewInlineKeyboardButtonData("🏠 Главное меню", "main_menu"),
+			),
+		)
+		_, err := h.api.Send(msg)
+		return err
+	}
+
+	h.stateManager.SetTempData(user.TelegramID, "foodHistoryOffset", float64(offset))
+	end := offset + len(analyses)
+
+	loc := services.UserLocation(user)
+	step := services.BreadUnitStep(user)
+	var b strings.Builder
+	var items [][]tgbotapi.InlineKeyboardButton
+	fmt.Fprintf(&b, "📜 История анализов (%d–%d из %d):\n", offset+1, end, total)
+	for i, a := range analyses {
+		number := strconv.Itoa(offset + i + 1)
+		fmt.Fprintf(&b, "\n%s. 📅 %s — %s, %s", number,
+			format.DateTime(a.CreatedAt, loc, format.Default),
+			format.Grams(a.Carbs, 0, format.Default),
+			format.BreadUnits(a.BreadUnits, step, format.Default))
+		if a.Weight > 0 {
+			fmt.Fprintf(&b, ", ⚖️ %s", format.Grams(a.Weight, 0, format.Default))
+		}
+		if a.InsulinUnits > 0 {
+			fmt.Fprintf(&b, ", 💉 %.1f ед.", a.InsulinUnits.Units())
+		}
+		if summary := mealSummary(a.AnalysisText); summary != "" {
+			b.WriteString("\n" + summary)
+		}
+		b.WriteString("\n")
+
+		if i%5 == 0 {
+			items = append(items, nil)
+		}
+		items[len(items)-1] = append(items[len(items)-1],
+			tgbotapi.NewInlineKeyboardButtonData(number, fmt.Sprintf("food_history_item_%d", a.ID)))
+	}
+	b.WriteString("\nНажмите номер, чтобы открыть анализ целиком с фото.")
+
+	var pages []tgbotapi.InlineKeyboardButton
+	if offset > 0 {
+		pages = append(pages, tgbotapi.NewInlineKeyboardButtonData("◀️", "food_history_prev"))
+	}
+	if end < total {
+		pages = append(pages, tgbotapi.NewInlineKeyboardButtonData("▶️", "food_history_next"))
+	}
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(items...)
+	if len(pages) > 0 {
+		keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, pages)
+	}
+	keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("🏠 Главное меню", "main_menu"),
+	))
+
+	return sendLongText(h.api, chatID, strings.TrimSpace(b.String()), keyboard)
+}
+
+// handleFoodHistoryItem shows one analysis of the history in full with its photo
+func (h *CallbackHandler) handleFoodHistoryItem(ctx context.Context, chatID int64, idStr string, user *database.User) error {
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		return h.handleUnknownCallback(chatID)
+	}
+	analysis, err := h.deps.FoodAnalysisSvc.GetAnalysis(ctx, user.ID, uint(id))
+	if err != nil {
+		logger.Error("Failed to get analysis from history", "user_id", user.ID, "analysis_id", id, "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Анализ не найден, возможно, он был удален")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✏️ Исправить углеводы", fmt.Sprintf("correct_analysis_%d", analysis.ID)),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("◀️ К истории", "food_history_back"),
+			tgbotapi.NewInlineKeyboardButtonData("🏠 Главное меню", "main_menu"),
+		),
+	)
+	return sendStoredResult(ctx, h.api, h.deps, chatID, user, analysis, false, keyboard)
+}
+
+// bloodSugarHistoryPageSize is how many records one page of the blood sugar
+// history shows
+const bloodSugarHistoryPageSize = 10
+
+// handleBloodSugarHistoryPage handles the pagination buttons of the blood
+// sugar history
+func (h *CallbackHandler) handleBloodSugarHistoryPage(ctx context.Context, chatID int64, pageStr string, user *database.User) error {
+	page, err := strconv.Atoi(pageStr)
+	if err != nil {
+		return h.handleUnknownCallback(chatID)
+	}
+	return h.handleBloodSugarHistory(ctx, chatID, user, page)
+}
+
+// handleBloodSugarHistory shows a page of the user's blood sugar records,
+// newest first. Pages are numbered from 1.
+func (h *CallbackHandler) handleBloodSugarHistory(ctx context.Context, chatID int64, user *database.User, page int) error {
+	records, err := h.deps.BloodSugarSvc.GetUserRecords(ctx, user.ID)
+	if err != nil {
+		logger.Error("Failed to get blood sugar records", "user_id", user.ID, "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при получении истории сахара")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+	if len(records) == 0 {
+		msg := tgbotapi.NewMessage(chatID, "📈 Измерений пока нет. Запишите первое, и здесь появится история сахара.")
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("🩸 Записать сахар", "blood_sugar"),
+				tgbotapi.NewInlineKeyboardButtonData("🏠 Главное меню", "main_menu"),
+			),
+		)
+		_, err := h.api.Send(msg)
+		return err
+	}
+
+	pageCount := (len(records) + bloodSugarHistoryPageSize - 1) / bloodSugarHistoryPageSize
+	page = max(1, min(page, pageCount))
+	start := (page - 1) * bloodSugarHistoryPageSize
+	end := min(start+bloodSugarHistoryPageSize, len(records))
+
+	loc := services.UserLocation(user)
+	var b strings.Builder
+	fmt.Fprintf(&b, "📈 История сахара (%d–%d из %d):\n", start+1, end, len(records))
+	for _, r := range records[start:end] {
+		fmt.Fprintf(&b, "\n%s — %s", format.DateTime(r.Timestamp, loc, format.Default), formatGlucose(r.Value, user.GlucoseUnit))
+	}
+
+	var pages []tgbotapi.InlineKeyboardButton
+	if page > 1 {
+		pages = append(pages, tgbotapi.NewInlineKeyboardButtonData("◀️ Назад", fmt.Sprintf("bs_history_page_%d", page-1)))
+	}
+	if page < pageCount {
+		pages = append(pages, tgbotapi.NewInlineKeyboardButtonData("Показать ещё ▶️", fmt.Sprintf("bs_history_page_%d", page+1)))
+	}
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🩸 Записать сахар", "blood_sugar"),
+			tgbotapi.NewInlineKeyboardButtonData("🏠 Главное меню", "main_menu"),
+		),
+	)
+	if len(pages) > 0 {
+		keyboard.InlineKeyboard = append([][]tgbotapi.InlineKeyboardButton{pages}, keyboard.InlineKeyboard...)
+	}
+
+	msg := tgbotapi.NewMessage(chatID, b.String())
+	msg.ReplyMarkup = keyboard
+	_, err = h.api.Send(msg)
+	return err
+}
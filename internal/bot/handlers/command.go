@@ -2,24 +2,35 @@ package handlers
 
 import (
 	"context"
+	"fmt"
+	"runtime"
+	"strings"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/vladimiradmaev/diabetes-helper/internal/bot/menus"
+	"github.com/vladimiradmaev/diabetes-helper/internal/bot/messages"
 	"github.com/vladimiradmaev/diabetes-helper/internal/bot/state"
+	"github.com/vladimiradmaev/diabetes-helper/internal/buildinfo"
 	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/featureflags"
+	"github.com/vladimiradmaev/diabetes-helper/internal/format"
 	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+	"github.com/vladimiradmaev/diabetes-helper/internal/services"
 )
 
 // CommandHandler handles bot commands
 type CommandHandler struct {
 	api          *tgbotapi.BotAPI
+	deps         Dependencies
 	stateManager state.StateManager
 }
 
 // NewCommandHandler creates a new command handler
-func NewCommandHandler(api *tgbotapi.BotAPI, stateManager state.StateManager) *CommandHandler {
+func NewCommandHandler(api *tgbotapi.BotAPI, deps Dependencies, stateManager state.StateManager) *CommandHandler {
 	return &CommandHandler{
 		api:          api,
+		deps:         deps,
 		stateManager: stateManager,
 	}
 }
@@ -28,31 +39,325 @@ func NewCommandHandler(api *tgbotapi.BotAPI, stateManager state.StateManager) *C
 func (h *CommandHandler) Handle(ctx context.Context, message *tgbotapi.Message, user *database.User) error {
 	logger.Infof("Handling command %s from user %d", message.Command(), user.ID)
 
+	// A command always runs, whatever input the user was in the middle of
+	if !commandKeepsState[message.Command()] {
+		h.interruptInput(message.Chat.ID, user)
+	}
+
 	switch message.Command() {
 	case "start":
-		h.stateManager.SetUserState(user.TelegramID, state.None)
-		return menus.SendMainMenu(h.api, message.Chat.ID)
+		return menus.SendMainMenu(h.api, message.Chat.ID, mainMenuStatus(ctx, h.deps, user))
 	case "help":
 		return h.handleHelp(message.Chat.ID)
+	case "stats":
+		return h.handleStats(ctx, message.Chat.ID, user)
+	case "sites":
+		return sendInjectionSites(ctx, h.api, h.deps, message.Chat.ID, user)
+	case "schedule":
+		return sendRatioSchedule(ctx, h.api, h.deps, message.Chat.ID, user)
+	case "last":
+		return sendLastResult(ctx, h.api, h.deps, message.Chat.ID, user)
+	case "insulin":
+		return sendInsulinChart(ctx, h.api, h.deps, message.Chat.ID, user)
+	case "export":
+		return h.handleExport(message.Chat.ID)
+	case "api_token":
+		return h.handleAPIToken(ctx, message.Chat.ID, user, message.CommandArguments())
+	case "iob":
+		return h.handleIOB(ctx, message.Chat.ID, user)
+	case "low":
+		return h.handleLow(ctx, message.Chat.ID, user, message.CommandArguments())
+	case "low_rule":
+		return h.handleLowRule(ctx, message.Chat.ID, user, message.CommandArguments())
+	case "insights":
+		return h.handleInsights(ctx, message.Chat.ID, user)
+	case "status":
+		return h.handleStatus(message.Chat.ID, user)
+	case "transfer":
+		return h.handleTransfer(ctx, message.Chat.ID, user)
+	case "claim":
+		return h.handleClaim(ctx, message.Chat.ID, user, message.CommandArguments())
+	case "pause":
+		return h.handlePause(ctx, message.Chat.ID, user, true)
+	case "resume":
+		return h.handlePause(ctx, message.Chat.ID, user, false)
+	case "search":
+		return h.handleSearch(ctx, message.Chat.ID, user, message.CommandArguments())
+	case "maintenance":
+		if !h.deps.Admins.Contains(user.TelegramID) {
+			return h.handleUnknownCommand(message.Chat.ID)
+		}
+		return h.handleMaintenance(ctx, message.Chat.ID, message.CommandArguments())
+	case "usage":
+		if !h.deps.Admins.Contains(user.TelegramID) {
+			return h.handleUnknownCommand(message.Chat.ID)
+		}
+		return h.handleUsage(ctx, message.Chat.ID)
+	case "version":
+		if !h.deps.Admins.Contains(user.TelegramID) {
+			return h.handleUnknownCommand(message.Chat.ID)
+		}
+		return h.handleVersion(message.Chat.ID)
+	case "telemetry":
+		if !h.deps.Admins.Contains(user.TelegramID) {
+			return h.handleUnknownCommand(message.Chat.ID)
+		}
+		return h.handleTelemetry(ctx, message.Chat.ID)
+	case "support":
+		if !h.deps.Admins.Contains(user.TelegramID) {
+			return h.handleUnknownCommand(message.Chat.ID)
+		}
+		return h.handleSupport(ctx, message.Chat.ID, user, message.CommandArguments())
+	case "merge_users":
+		if !h.deps.Admins.Contains(user.TelegramID) {
+			return h.handleUnknownCommand(message.Chat.ID)
+		}
+		return h.handleMergeUsers(ctx, message.Chat.ID, user, message.CommandArguments())
+	case "conversation":
+		if !h.deps.Admins.Contains(user.TelegramID) {
+			return h.handleUnknownCommand(message.Chat.ID)
+		}
+		return h.handleConversation(ctx, message.Chat.ID, user, message.CommandArguments())
+	case "checkdata":
+		if !h.deps.Admins.Contains(user.TelegramID) {
+			return h.handleUnknownCommand(message.Chat.ID)
+		}
+		return h.handleCheckData(ctx, message.Chat.ID)
 	default:
 		return h.handleUnknownCommand(message.Chat.ID)
 	}
 }
 
+// commandKeepsState lists the commands that only show something and leave an
+// unfinished input flow running, so the user can answer the pending prompt next
+var commandKeepsState = map[string]bool{
+	"status": true,
+}
+
+// interruptInput ends the input flow the user is in, if any, and removes its
+// prompts. Temp data stays: result card buttons may still refer to it, and a
+// flow started again overwrites its own keys.
+func (h *CommandHandler) interruptInput(chatID int64, user *database.User) {
+	current := h.stateManager.GetUserState(user.TelegramID)
+	if current == state.None {
+		return
+	}
+	logger.Info("Command interrupted input", "user_id", user.ID, "state", current)
+	clearPrompts(h.api, h.stateManager, chatID, user)
+	h.stateManager.SetUserState(user.TelegramID, state.None)
+}
+
 // handleHelp handles the /help command
 func (h *CommandHandler) handleHelp(chatID int64) error {
-	text := `Доступные команды:
-/start - Показать главное меню
-/help - Показать это сообщение
+	msg := tgbotapi.NewMessage(chatID, messages.Help)
+	_, err := h.api.Send(msg)
+	return err
+}
+
+// handleStats handles the /stats command
+func (h *CommandHandler) handleStats(ctx context.Context, chatID int64, user *database.User) error {
+	summaries, err := h.deps.StatsSvc.GetDailySummaries(ctx, user.ID, 7)
+	if err != nil {
+		logger.Error("Failed to get daily summaries", "user_id", user.ID, "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при получении статистики")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+
+	text := "📊 Статистика за 7 дней\n\n"
+	for _, d := range summaries {
+		text += format.ShortDate(d.Day, d.Day.Location(), format.Default) + ": "
+		if d.ReadingsCount > 0 {
+			text += fmt.Sprintf("🩸 %s, в диапазоне %.0f%%", formatGlucose(d.MeanGlucose, user.GlucoseUnit), d.TimeInRange*100)
+		} else {
+			text += "🩸 нет замеров"
+		}
+		text += "; 🍞 " + format.Grams(d.CarbsTotal, 0, format.Default)
+		if d.CarbTarget > 0 {
+			if d.CarbsTotal <= d.CarbTarget {
+				text += fmt.Sprintf(" из %.0f ✅", d.CarbTarget)
+			} else {
+				text += fmt.Sprintf(" из %.0f ⚠️", d.CarbTarget)
+			}
+		}
+		if total := d.BolusTotal + d.BasalTotal; total > 0 {
+			text += fmt.Sprintf("; 💉 %.1f ед", total.Units())
+			if d.BasalTotal > 0 {
+				text += fmt.Sprintf(" (базал %.1f)", d.BasalTotal.Units())
+			}
+		}
+		text += "\n"
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	_, err = h.api.Send(msg)
+	return err
+}
+
+// handleIOB handles the /iob command: the insulin still active from recent
+// bolus and correction injections
+func (h *CommandHandler) handleIOB(ctx context.Context, chatID int64, user *database.User) error {
+	iob, err := h.deps.InjectionSvc.InsulinOnBoard(ctx, user, time.Now())
+	if err != nil {
+		logger.Error("Failed to calculate insulin on board", "user_id", user.ID, "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при расчете активного инсулина")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+
+	model := "линейное"
+	if services.CurvedIOB(user) {
+		model = "по кривой"
+	}
+	duration := services.ActiveInsulinTime(user)
+	text := fmt.Sprintf("💉 Активный инсулин: %.1f ед.\n\nУчитываются болюсные и корректирующие уколы за %d ч %02d мин, затухание %s. "+
+		"Модель меняется в настройках.", iob.Units(), int(duration.Hours()), int(duration.Minutes())%60, model)
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	_, err = h.api.Send(msg)
+	return err
+}
+
+// insightsLimit is how many foods /insights lists
+const insightsLimit = 5
+
+// handleInsights handles the /insights command: the foods whose meals are
+// followed by the highest blood sugar rise
+func (h *CommandHandler) handleInsights(ctx context.Context, chatID int64, user *database.User) error {
+	insights, err := h.deps.InsightsSvc.TopSpikes(ctx, user.ID, insightsLimit)
+	if err != nil {
+		logger.Error("Failed to get food insights", "user_id", user.ID, "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при подборе продуктов")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+
+	if len(insights) == 0 {
+		msg := tgbotapi.NewMessage(chatID, "Пока недостаточно данных. Записывайте сахар перед едой и через 2 часа после неё: "+
+			"продукт появится здесь, когда наберется хотя бы два таких приема пищи.")
+		_, err = h.api.Send(msg)
+		return err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "📈 После чего сахар растет сильнее всего (за %d дней):\n\n", int(services.InsightsPeriod.Hours()/24))
+	for i, insight := range insights {
+		sign := ""
+		if insight.AvgRise >= 0 {
+			sign = "+"
+		}
+		fmt.Fprintf(&b, "%d. %s: %s%s в среднем (приемов пищи: %d, ~%.0f г углеводов)\n",
+			i+1, insight.Food, sign, formatGlucose(insight.AvgRise, user.GlucoseUnit), insight.Meals, insight.AvgCarbs)
+	}
+	b.WriteString("\nРост считается от замера за час до еды до замера через 1,5–3 часа после неё.")
 
-Как указать вес блюда:
-1. Нажмите кнопку "🍽️ Анализ еды"
-2. Отправьте фото еды
-3. В подписи к фото напишите только число - вес в граммах
-Пример: "150" или "200"
+	msg := tgbotapi.NewMessage(chatID, b.String())
+	_, err = h.api.Send(msg)
+	return err
+}
 
-Если вес не указан, бот попробует оценить его автоматически.`
+// handleStatus handles the /status command: it tells the user whether the AI
+// provider is currently failing so they know a problem isn't on their side
+func (h *CommandHandler) handleStatus(chatID int64, user *database.User) error {
+	health := h.deps.FoodAnalysisSvc.AIHealth()
+	loc := services.UserLocation(user)
 
+	var text string
+	if health.Healthy {
+		text = "🟢 Анализ еды работает нормально"
+	} else {
+		text = "🔴 Сервис анализа еды сейчас недоступен. Это проблема на нашей стороне, а не у вас: попробуйте позже или введите углеводы вручную."
+		if health.ConsecutiveFailures > 0 {
+			text += fmt.Sprintf("\nОшибок подряд: %d, последняя в %s", health.ConsecutiveFailures, format.Clock(health.LastFailure, loc, format.Default))
+		}
+	}
+
+	if !health.LastSuccess.IsZero() {
+		text += "\nПоследний успешный анализ: " + format.DateTime(health.LastSuccess, loc, format.Default)
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	_, err := h.api.Send(msg)
+	return err
+}
+
+// handleSearch handles the /search command
+func (h *CommandHandler) handleSearch(ctx context.Context, chatID int64, user *database.User, query string) error {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		msg := tgbotapi.NewMessage(chatID, "Укажите продукт после команды, например: /search гречка")
+		_, err := h.api.Send(msg)
+		return err
+	}
+
+	analyses, err := h.deps.FoodAnalysisSvc.SearchAnalyses(ctx, user.ID, query, 10)
+	if err != nil {
+		logger.Error("Failed to search analyses", "user_id", user.ID, "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при поиске")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+	if len(analyses) == 0 {
+		msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("Анализы с продуктом «%s» не найдены", query))
+		_, err := h.api.Send(msg)
+		return err
+	}
+
+	loc := services.UserLocation(user)
+	text := fmt.Sprintf("🔍 Найдено по запросу «%s»:\n\n", query)
+	for _, a := range analyses {
+		text += fmt.Sprintf("%s: %s углеводов (%s)", format.DateTime(a.CreatedAt, loc, format.Default),
+			format.Grams(a.Carbs, 1, format.Default), format.BreadUnits(a.BreadUnits, services.BreadUnitStep(user), format.Default))
+		if a.Weight > 0 {
+			text += ", " + format.Grams(a.Weight, 0, format.Default)
+		}
+		text += "\n"
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	_, err = h.api.Send(msg)
+	return err
+}
+
+// handleVersion handles the admin-only /version command with the build info
+func (h *CommandHandler) handleVersion(chatID int64) error {
+	text := fmt.Sprintf("🏷️ Версия: %s\nКоммит: %s\nСборка: %s\nGo: %s",
+		buildinfo.Version, buildinfo.Commit, buildinfo.Date, runtime.Version())
+	msg := tgbotapi.NewMessage(chatID, text)
+	_, err := h.api.Send(msg)
+	return err
+}
+
+// handleMaintenance handles the admin-only /maintenance command
+func (h *CommandHandler) handleMaintenance(ctx context.Context, chatID int64, args string) error {
+	var enabled bool
+	switch strings.ToLower(strings.TrimSpace(args)) {
+	case "on":
+		enabled = true
+	case "off":
+		enabled = false
+	default:
+		status := "выключен"
+		if h.deps.Flags.Enabled(ctx, featureflags.Maintenance, 0) {
+			status = "включен"
+		}
+		msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("Режим обслуживания %s.\nИспользование: /maintenance on|off", status))
+		_, err := h.api.Send(msg)
+		return err
+	}
+
+	if err := h.deps.Flags.SetGlobal(ctx, featureflags.Maintenance, enabled); err != nil {
+		logger.Error("Failed to toggle maintenance mode", "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при переключении режима обслуживания")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+	logger.Info("Maintenance mode toggled", "enabled", enabled)
+
+	text := "✅ Режим обслуживания выключен"
+	if enabled {
+		text = "🛠️ Режим обслуживания включен. Пользователи получают сообщение о недоступности, администраторы работают как обычно."
+	}
 	msg := tgbotapi.NewMessage(chatID, text)
 	_, err := h.api.Send(msg)
 	return err
@@ -60,7 +365,7 @@ func (h *CommandHandler) handleHelp(chatID int64) error {
 
 // handleUnknownCommand handles unknown commands
 func (h *CommandHandler) handleUnknownCommand(chatID int64) error {
-	msg := tgbotapi.NewMessage(chatID, "Неизвестная команда. Используйте /help для просмотра доступных команд.")
+	msg := tgbotapi.NewMessage(chatID, messages.UnknownCommand)
 	_, err := h.api.Send(msg)
 	return err
 }
@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/vladimiradmaev/diabetes-helper/internal/bot/state"
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+)
+
+// fakeTelegram starts a Bot API server that accepts every request and
+// returns the API client and the methods it was called with
+func fakeTelegram(t *testing.T) (*tgbotapi.BotAPI, func() []string) {
+	t.Helper()
+	var mu sync.Mutex
+	var methods []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		methods = append(methods, r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:])
+		mu.Unlock()
+		// One result that decodes as the bot user and as a sent message
+		fmt.Fprint(w, `{"ok":true,"result":{"id":1,"is_bot":true,"first_name":"bot","username":"bot",`+
+			`"message_id":1,"date":0,"chat":{"id":1,"type":"private"}}}`)
+	}))
+	t.Cleanup(server.Close)
+
+	api, err := tgbotapi.NewBotAPIWithClient("token", server.URL+"/bot%s/%s", server.Client())
+	if err != nil {
+		t.Fatalf("failed to create bot API: %v", err)
+	}
+	return api, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), methods...)
+	}
+}
+
+// fakeRedis starts a server speaking the subset of the Redis protocol that
+// RedisManager uses (GET, SET and DEL) and returns its host and port
+func fakeRedis(t *testing.T) (string, string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	var mu sync.Mutex
+	data := make(map[string]string)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveRedis(conn, &mu, data)
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	return host, port
+}
+
+func serveRedis(conn net.Conn, mu *sync.Mutex, data map[string]string) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readRedisCommand(r)
+		if err != nil {
+			return
+		}
+
+		mu.Lock()
+		var reply string
+		switch strings.ToUpper(args[0]) {
+		case "HELLO":
+			// Makes the client fall back to RESP2
+			reply = "-ERR unknown command 'HELLO'\r\n"
+		case "PING":
+			reply = "+PONG\r\n"
+		case "GET":
+			if v, ok := data[args[1]]; ok {
+				reply = fmt.Sprintf("$%d\r\n%s\r\n", len(v), v)
+			} else {
+				reply = "$-1\r\n"
+			}
+		case "SET":
+			data[args[1]] = args[2]
+			reply = "+OK\r\n"
+		case "DEL":
+			deleted := 0
+			for _, key := range args[1:] {
+				if _, ok := data[key]; ok {
+					delete(data, key)
+					deleted++
+				}
+			}
+			reply = fmt.Sprintf(":%d\r\n", deleted)
+		default:
+			reply = "+OK\r\n"
+		}
+		mu.Unlock()
+
+		if _, err := io.WriteString(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+// readRedisCommand reads one command sent as an array of bulk strings
+func readRedisCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("unexpected command %q", line)
+	}
+
+	args := make([]string, n)
+	for i := range args {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "$")))
+		if err != nil {
+			return nil, fmt.Errorf("unexpected argument %q", line)
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func commandMessage(command string) *tgbotapi.Message {
+	return &tgbotapi.Message{
+		MessageID: 100,
+		Text:      "/" + command,
+		Chat:      &tgbotapi.Chat{ID: 1},
+		Entities:  []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len(command) + 1}},
+	}
+}
+
+func TestStartResetsState(t *testing.T) {
+	if err := logger.InitWithConfig(logger.Config{Level: logger.LevelError, OutputPath: "stdout"}); err != nil {
+		t.Fatalf("failed to init logger: %v", err)
+	}
+
+	managers := []struct {
+		name string
+		new  func(t *testing.T) state.StateManager
+	}{
+		{"in memory", func(t *testing.T) state.StateManager { return state.NewInMemoryManager() }},
+		{"redis", func(t *testing.T) state.StateManager {
+			m, err := state.NewRedisManager(fakeRedis(t))
+			if err != nil {
+				t.Fatalf("failed to create Redis manager: %v", err)
+			}
+			t.Cleanup(func() { m.Close() })
+			return m
+		}},
+	}
+
+	for _, m := range managers {
+		t.Run(m.name, func(t *testing.T) {
+			api, methods := fakeTelegram(t)
+			stateManager := m.new(t)
+			user := &database.User{TelegramID: 42}
+
+			stateManager.SetUserState(user.TelegramID, state.WaitingForBloodSugar)
+			stateManager.SetTempData(user.TelegramID, "analysisID", float64(7))
+			trackPrompt(stateManager, user, 10)
+			trackPrompt(stateManager, user, 11)
+
+			h := NewCommandHandler(api, Dependencies{}, stateManager)
+			if err := h.Handle(context.Background(), commandMessage("start"), user); err != nil {
+				t.Fatalf("Handle(/start) error = %v", err)
+			}
+
+			if got := stateManager.GetUserState(user.TelegramID); got != state.None {
+				t.Errorf("state after /start = %q, want %q", got, state.None)
+			}
+			// Result card buttons may still refer to the temp data
+			if v, ok := stateManager.GetTempData(user.TelegramID, "analysisID"); !ok || v != float64(7) {
+				t.Errorf("temp data after /start = %v, %v, want it kept", v, ok)
+			}
+			var deleted, sent int
+			for _, method := range methods() {
+				switch method {
+				case "deleteMessage":
+					deleted++
+				case "sendMessage":
+					sent++
+				}
+			}
+			if deleted != 2 {
+				t.Errorf("/start deleted %d prompts, want 2", deleted)
+			}
+			if sent != 1 {
+				t.Errorf("/start sent %d messages, want the main menu", sent)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+	"github.com/vladimiradmaev/diabetes-helper/internal/services"
+)
+
+// Number of exchanges /conversation shows by default and at most
+const (
+	defaultConversationExchanges = 5
+	maxConversationExchanges     = 30
+)
+
+// describeUpdate returns what the conversation log records of an incoming
+// update: its chat, kind, key (command, callback data or emoji) and text
+func describeUpdate(update tgbotapi.Update) (chatID int64, kind, key, text string) {
+	if query := update.CallbackQuery; query != nil {
+		chatID = query.From.ID
+		if query.Message != nil {
+			chatID = query.Message.Chat.ID
+		}
+		return chatID, "callback", query.Data, ""
+	}
+
+	message := update.Message
+	chatID = message.Chat.ID
+	switch {
+	case message.IsCommand():
+		return chatID, "command", "/" + message.Command(), message.CommandArguments()
+	case message.Sticker != nil:
+		return chatID, "sticker", message.Sticker.Emoji, ""
+	case len(message.Photo) > 0:
+		return chatID, "photo", "", message.Caption
+	case message.Text != "":
+		return chatID, "text", "", message.Text
+	default:
+		return chatID, "other", "", ""
+	}
+}
+
+// handleConversation handles the admin-only /conversation <telegram ID> [N]
+// command: the last N exchanges of a user with the bot as a compact transcript
+func (h *CommandHandler) handleConversation(ctx context.Context, chatID int64, admin *database.User, args string) error {
+	const usage = "Использование: /conversation <telegram ID> [число обменов]"
+
+	fields := strings.Fields(args)
+	if len(fields) == 0 || len(fields) > 2 {
+		_, err := h.api.Send(tgbotapi.NewMessage(chatID, usage))
+		return err
+	}
+	telegramID, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		_, err := h.api.Send(tgbotapi.NewMessage(chatID, usage))
+		return err
+	}
+	exchanges := defaultConversationExchanges
+	if len(fields) == 2 {
+		exchanges, err = strconv.Atoi(fields[1])
+		if err != nil || exchanges < 1 {
+			_, err := h.api.Send(tgbotapi.NewMessage(chatID, usage))
+			return err
+		}
+		exchanges = min(exchanges, maxConversationExchanges)
+	}
+
+	entries, err := h.deps.ConversationLog.Transcript(ctx, admin.TelegramID, telegramID, exchanges)
+	switch {
+	case errors.Is(err, services.ErrConversationLogDisabled):
+		_, sendErr := h.api.Send(tgbotapi.NewMessage(chatID, "Журнал диалогов выключен (CONVERSATION_LOG_ENABLED)"))
+		return sendErr
+	case err != nil:
+		logger.Error("Failed to get conversation log", "telegram_id", telegramID, "error", err)
+		_, sendErr := h.api.Send(tgbotapi.NewMessage(chatID, "Ошибка при чтении журнала диалогов"))
+		return sendErr
+	case len(entries) == 0:
+		_, sendErr := h.api.Send(tgbotapi.NewMessage(chatID, "В журнале нет сообщений этого пользователя"))
+		return sendErr
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "💬 Диалог %d, время UTC\n", telegramID)
+	if !h.deps.ConversationLog.FullText() {
+		b.WriteString("Тексты не хранятся: только тип, ключ и числа\n")
+	}
+	for _, e := range entries {
+		b.WriteString("\n" + formatConversationEntry(e))
+	}
+	return sendLongText(h.api, chatID, b.String(), nil)
+}
+
+// formatConversationEntry formats one log entry as a transcript line, e.g.
+// "14.03 12:03:05 ← #812 command /low [3.4]"
+func formatConversationEntry(e database.ConversationLog) string {
+	arrow := "→"
+	if e.Direction == services.ConversationIn {
+		arrow = "←"
+	}
+	update := "фон"
+	if e.UpdateID != 0 {
+		update = fmt.Sprintf("#%d", e.UpdateID)
+	}
+
+	line := fmt.Sprintf("%s %s %s %s", e.CreatedAt.UTC().Format("02.01 15:04:05"), arrow, update, e.Kind)
+	if e.Template != "" {
+		line += " " + e.Template
+	}
+	if e.Text != "" {
+		line += "\n    " + strings.ReplaceAll(e.Text, "\n", "\n    ")
+	} else if e.Variables != "" {
+		line += " [" + e.Variables + "]"
+	}
+	return line
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/format"
+	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+	"github.com/vladimiradmaev/diabetes-helper/internal/services"
+)
+
+// mealSummaryLength caps the description shown for a copied meal
+const mealSummaryLength = 60
+
+// handleCopyYesterday lists yesterday's meals as drafts for today; each one is
+// logged only when the user confirms it
+func (h *CallbackHandler) handleCopyYesterday(ctx context.Context, chatID int64, user *database.User) error {
+	now := time.Now().In(services.UserLocation(user))
+	drafts, err := h.deps.FoodAnalysisSvc.CloneDay(ctx, user.ID, now.AddDate(0, 0, -1), now)
+	if err != nil {
+		logger.Error("Failed to copy yesterday's meals", "user_id", user.ID, "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при получении вчерашних записей")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+	if len(drafts) == 0 {
+		msg := tgbotapi.NewMessage(chatID, "Вчера не было записей о еде")
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("🏠 Главное меню", "main_menu"),
+			),
+		)
+		_, err := h.api.Send(msg)
+		return err
+	}
+
+	text := "📋 Вчерашние приемы пищи. Нажмите на прием, когда едите его сегодня, — он будет записан с дозой по текущему коэффициенту:\n\n"
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, d := range drafts {
+		a := d.Analysis
+		text += fmt.Sprintf("🕒 %s — %s углеводов, %s", format.Clock(a.CreatedAt, a.CreatedAt.Location(), format.Default),
+			format.Grams(a.Carbs, 0, format.Default), format.BreadUnits(a.BreadUnits, services.BreadUnitStep(user), format.Default))
+		if a.InsulinRatio > 0 {
+			text += fmt.Sprintf(", 💉 %.1f ед.", a.InsulinUnits.Units())
+		}
+		if summary := mealSummary(a.AnalysisText); summary != "" {
+			text += "\n" + summary
+		}
+		text += "\n\n"
+
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(
+				fmt.Sprintf("✅ %s · %s", format.Clock(a.CreatedAt, a.CreatedAt.Location(), format.Default), format.Grams(a.Carbs, 0, format.Default)),
+				fmt.Sprintf("clone_meal_%d", d.SourceID)),
+		))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("🏠 Главное меню", "main_menu"),
+	))
+
+	return sendLongText(h.api, chatID, text, tgbotapi.NewInlineKeyboardMarkup(rows...))
+}
+
+// handleCloneMeal logs a copy of an earlier meal now
+func (h *CallbackHandler) handleCloneMeal(ctx context.Context, chatID int64, idStr string, user *database.User) error {
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		return h.handleUnknownCallback(chatID)
+	}
+
+	analysis, err := h.deps.FoodAnalysisSvc.CloneAnalysis(ctx, user.ID, uint(id))
+	if err != nil {
+		logger.Error("Failed to copy meal", "user_id", user.ID, "analysis_id", id, "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при записи приема пищи")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+
+	msg := cloneMealMessage(chatID, user, analysis, recentGlucose(ctx, h.deps, user), carbProgressText(ctx, h.deps, user))
+	_, err = h.api.Send(msg)
+	return err
+}
+
+// cloneMealMessage reports a copied meal with its dose. The injection can be
+// logged in one tap, unless glucose bg is too low to inject.
+func cloneMealMessage(chatID int64, user *database.User, analysis *database.FoodAnalysis, bg float64, progress string) tgbotapi.MessageConfig {
+	header := fmt.Sprintf("✅ Записано: %.0f г углеводов, %s\n", analysis.Carbs,
+		format.BreadUnits(analysis.BreadUnits, services.BreadUnitStep(user), format.Default))
+	text, hypo := doseText(user, analysis, bg, header, "Рекомендуемая доза инсулина", progress)
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	if hypo {
+		msg.ReplyMarkup = withLowRecheckButton(tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("🏠 Главное меню", "main_menu"),
+			),
+		))
+		return msg
+	}
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("💉 Записать укол", "log_injection"),
+			tgbotapi.NewInlineKeyboardButtonData("🏠 Главное меню", "main_menu"),
+		),
+	)
+	return msg
+}
+
+// mealSummary returns the first line of an analysis text, shortened for lists
+func mealSummary(analysisText string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(analysisText), "\n")
+	line = strings.TrimSpace(line)
+	if runes := []rune(line); len(runes) > mealSummaryLength {
+		line = string(runes[:mealSummaryLength-1]) + "…"
+	}
+	return line
+}
@@ -0,0 +1,325 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/vladimiradmaev/diabetes-helper/internal/bot/state"
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/format"
+	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+	"github.com/vladimiradmaev/diabetes-helper/internal/services"
+)
+
+// correctionItemKey is the temp data key of an item's corrected carbs
+func correctionItemKey(itemID uint) string {
+	return fmt.Sprintf("correctItem_%d", itemID)
+}
+
+// correctionAnalysisID returns the analysis being corrected
+func correctionAnalysisID(stateManager state.StateManager, user *database.User) (uint, bool) {
+	idVal, _ := stateManager.GetTempData(user.TelegramID, "correctAnalysis")
+	id, ok := idVal.(float64)
+	return uint(id), ok && id > 0
+}
+
+// pendingItemCarbs returns the corrected carbs entered so far, by item ID
+func pendingItemCarbs(stateManager state.StateManager, user *database.User, items []database.FoodItem) map[uint]float64 {
+	pending := make(map[uint]float64)
+	for _, item := range items {
+		if v, ok := stateManager.GetTempData(user.TelegramID, correctionItemKey(item.ID)); ok {
+			if carbs, isFloat := v.(float64); isFloat {
+				pending[item.ID] = carbs
+			}
+		}
+	}
+	return pending
+}
+
+// parseCarbsInput parses grams of carbs typed by the user
+func parseCarbsInput(text string) (float64, bool) {
+	carbs, err := strconv.ParseFloat(strings.ReplaceAll(strings.TrimSpace(text), ",", "."), 64)
+	return carbs, err == nil && carbs >= 0 && carbs <= 1000
+}
+
+// parseTotalCorrection parses the corrected total carbs of an analysis,
+// optionally followed by the portion weight in grams, e.g. "45 200". weight
+// is 0 when only carbs are given.
+func parseTotalCorrection(text string) (carbs, weight float64, ok bool) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || len(fields) > 2 {
+		return 0, 0, false
+	}
+	if carbs, ok = parseCarbsInput(fields[0]); !ok {
+		return 0, 0, false
+	}
+	if len(fields) == 2 {
+		weight, err := strconv.ParseFloat(strings.ReplaceAll(fields[1], ",", "."), 64)
+		if err != nil || weight < 1 || weight > 5000 {
+			return 0, 0, false
+		}
+		return carbs, weight, true
+	}
+	return carbs, 0, true
+}
+
+// sendCorrectionMenu lists the analysed food items with their carbs so the
+// user can fix the wrong ones. Analyses without item carbs go straight to
+// entering the corrected total.
+func sendCorrectionMenu(ctx context.Context, api *tgbotapi.BotAPI, deps Dependencies, stateManager state.StateManager, chatID int64, user *database.User) error {
+	analysisID, ok := correctionAnalysisID(stateManager, user)
+	if !ok {
+		msg := tgbotapi.NewMessage(chatID, "Анализ не найден. Пожалуйста, начните исправление заново.")
+		_, err := api.Send(msg)
+		return err
+	}
+	analysis, items, err := deps.FoodAnalysisSvc.GetAnalysisWithItems(ctx, user.ID, analysisID)
+	if err != nil {
+		logger.Error("Failed to get analysis for correction", "user_id", user.ID, "analysis_id", analysisID, "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при получении анализа")
+		_, sendErr := api.Send(msg)
+		return sendErr
+	}
+
+	var withCarbs []database.FoodItem
+	for _, item := range items {
+		if item.Carbs > 0 {
+			withCarbs = append(withCarbs, item)
+		}
+	}
+	if len(withCarbs) == 0 {
+		stateManager.SetUserState(user.TelegramID, state.WaitingForTotalCarbs)
+		msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("Сейчас: %.0f г углеводов. Введите правильное количество в граммах, "+
+			"можно через пробел с весом порции (например: 45 200):", analysis.Carbs))
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("◀️ Отмена", "main_menu"),
+			),
+		)
+		_, err := api.Send(msg)
+		return err
+	}
+
+	pending := pendingItemCarbs(stateManager, user, withCarbs)
+	total := analysis.Carbs
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, item := range withCarbs {
+		label := fmt.Sprintf("%s: %.0f г", item.Name, item.Carbs)
+		if carbs, ok := pending[item.ID]; ok {
+			label = fmt.Sprintf("%s: %.0f → %.0f г", item.Name, item.Carbs, carbs)
+			total += carbs - item.Carbs
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(label, fmt.Sprintf("correct_item_%d", item.ID)),
+		))
+	}
+	if total < 0 {
+		total = 0
+	}
+
+	text := "✏️ Нажмите на продукт, чтобы изменить его углеводы.\n\n"
+	if len(pending) > 0 {
+		text += fmt.Sprintf("Итого: %.0f → %.0f г углеводов", analysis.Carbs, total)
+	} else {
+		text += fmt.Sprintf("Итого: %.0f г углеводов", analysis.Carbs)
+	}
+
+	if len(pending) > 0 {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✅ Сохранить", "correct_save"),
+		))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("🔢 Изменить итог", "correct_total"),
+		tgbotapi.NewInlineKeyboardButtonData("◀️ Отмена", "main_menu"),
+	))
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	_, err = api.Send(msg)
+	return err
+}
+
+// sendCorrectionResult reports the corrected analysis
+func sendCorrectionResult(ctx context.Context, api *tgbotapi.BotAPI, deps Dependencies, chatID int64, user *database.User, originalCarbs float64, analysis *database.FoodAnalysis) error {
+	msg := correctionResultMessage(chatID, user, originalCarbs, analysis,
+		recentGlucose(ctx, deps, user), carbProgressText(ctx, deps, user))
+	_, err := api.Send(msg)
+	return err
+}
+
+// correctionResultMessage reports the corrected analysis with its new dose,
+// behind the hypo warning when glucose bg is too low to inject
+func correctionResultMessage(chatID int64, user *database.User, originalCarbs float64, analysis *database.FoodAnalysis, bg float64, progress string) tgbotapi.MessageConfig {
+	header := fmt.Sprintf("✅ Исправлено: %.0f → %.0f г углеводов, %s\n", originalCarbs, analysis.Carbs,
+		format.BreadUnits(analysis.BreadUnits, services.BreadUnitStep(user), format.Default))
+	if analysis.Weight > 0 {
+		header += fmt.Sprintf("⚖️ Вес: %s\n", format.Grams(analysis.Weight, 0, format.Default))
+	}
+	text, hypo := doseText(user, analysis, bg, header, "Доза с учетом исправления", progress)
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🏠 Главное меню", "main_menu"),
+		),
+	)
+	if hypo {
+		keyboard = withLowRecheckButton(keyboard)
+	}
+	msg.ReplyMarkup = keyboard
+	return msg
+}
+
+// handleCorrectAnalysis starts correcting an analysis
+func (h *CallbackHandler) handleCorrectAnalysis(ctx context.Context, chatID int64, idStr string, user *database.User) error {
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		return h.handleUnknownCallback(chatID)
+	}
+
+	h.stateManager.ClearTempData(user.TelegramID)
+	h.stateManager.SetUserState(user.TelegramID, state.None)
+	h.stateManager.SetTempData(user.TelegramID, "correctAnalysis", float64(id))
+	return sendCorrectionMenu(ctx, h.api, h.deps, h.stateManager, chatID, user)
+}
+
+// handleCorrectItem asks for the corrected carbs of one food item
+func (h *CallbackHandler) handleCorrectItem(ctx context.Context, chatID int64, idStr string, user *database.User) error {
+	itemID, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		return h.handleUnknownCallback(chatID)
+	}
+	analysisID, ok := correctionAnalysisID(h.stateManager, user)
+	if !ok {
+		msg := tgbotapi.NewMessage(chatID, "Анализ не найден. Пожалуйста, начните исправление заново.")
+		_, err := h.api.Send(msg)
+		return err
+	}
+	_, items, err := h.deps.FoodAnalysisSvc.GetAnalysisWithItems(ctx, user.ID, analysisID)
+	if err != nil {
+		logger.Error("Failed to get analysis for correction", "user_id", user.ID, "analysis_id", analysisID, "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при получении анализа")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+
+	for _, item := range items {
+		if item.ID != uint(itemID) {
+			continue
+		}
+		h.stateManager.SetTempData(user.TelegramID, "correctItemID", float64(item.ID))
+		h.stateManager.SetUserState(user.TelegramID, state.WaitingForItemCarbs)
+		msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("Сколько углеводов в «%s»? Сейчас: %.0f г. Введите число в граммах:", item.Name, item.Carbs))
+		_, err := h.api.Send(msg)
+		return err
+	}
+	return h.handleUnknownCallback(chatID)
+}
+
+// handleCorrectTotal asks for the corrected total instead of per-item carbs
+func (h *CallbackHandler) handleCorrectTotal(chatID int64, user *database.User) error {
+	if _, ok := correctionAnalysisID(h.stateManager, user); !ok {
+		msg := tgbotapi.NewMessage(chatID, "Анализ не найден. Пожалуйста, начните исправление заново.")
+		_, err := h.api.Send(msg)
+		return err
+	}
+	h.stateManager.SetUserState(user.TelegramID, state.WaitingForTotalCarbs)
+	msg := tgbotapi.NewMessage(chatID, "Введите правильное общее количество углеводов в граммах, "+
+		"можно через пробел с весом порции (например: 45 200):")
+	_, err := h.api.Send(msg)
+	return err
+}
+
+// handleCorrectSave saves the per-item corrections entered so far
+func (h *CallbackHandler) handleCorrectSave(ctx context.Context, chatID int64, user *database.User) error {
+	analysisID, ok := correctionAnalysisID(h.stateManager, user)
+	if !ok {
+		msg := tgbotapi.NewMessage(chatID, "Анализ не найден. Пожалуйста, начните исправление заново.")
+		_, err := h.api.Send(msg)
+		return err
+	}
+	original, items, err := h.deps.FoodAnalysisSvc.GetAnalysisWithItems(ctx, user.ID, analysisID)
+	if err != nil {
+		logger.Error("Failed to get analysis for correction", "user_id", user.ID, "analysis_id", analysisID, "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при получении анализа")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+	pending := pendingItemCarbs(h.stateManager, user, items)
+	if len(pending) == 0 {
+		return sendCorrectionMenu(ctx, h.api, h.deps, h.stateManager, chatID, user)
+	}
+
+	analysis, err := h.deps.FoodAnalysisSvc.CorrectAnalysis(ctx, user.ID, analysisID, services.AnalysisCorrection{Items: pending})
+	if err != nil {
+		logger.Error("Failed to correct analysis", "user_id", user.ID, "analysis_id", analysisID, "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при сохранении исправления")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+	h.stateManager.ClearTempData(user.TelegramID)
+	h.stateManager.SetUserState(user.TelegramID, state.None)
+	return sendCorrectionResult(ctx, h.api, h.deps, chatID, user, original.Carbs, analysis)
+}
+
+// handleItemCarbs handles the corrected carbs of a food item and returns to the item list
+func (h *TextHandler) handleItemCarbs(ctx context.Context, message *tgbotapi.Message, user *database.User) error {
+	carbs, ok := parseCarbsInput(message.Text)
+	if !ok {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Пожалуйста, введите количество углеводов от 0 до 1000 г (например: 30)")
+		_, err := h.api.Send(msg)
+		return err
+	}
+
+	itemVal, _ := h.stateManager.GetTempData(user.TelegramID, "correctItemID")
+	itemID, isFloat := itemVal.(float64)
+	if !isFloat || itemID <= 0 {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Продукт не найден. Пожалуйста, начните исправление заново.")
+		_, err := h.api.Send(msg)
+		return err
+	}
+
+	h.stateManager.SetTempData(user.TelegramID, correctionItemKey(uint(itemID)), carbs)
+	h.stateManager.SetUserState(user.TelegramID, state.None)
+	return sendCorrectionMenu(ctx, h.api, h.deps, h.stateManager, message.Chat.ID, user)
+}
+
+// handleTotalCarbs handles the corrected total carbs of an analysis and saves it
+func (h *TextHandler) handleTotalCarbs(ctx context.Context, message *tgbotapi.Message, user *database.User) error {
+	carbs, weight, ok := parseTotalCorrection(message.Text)
+	if !ok {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Пожалуйста, введите количество углеводов от 0 до 1000 г "+
+			"и, если нужно, вес порции от 1 до 5000 г (например: 45 или 45 200)")
+		_, err := h.api.Send(msg)
+		return err
+	}
+
+	analysisID, ok := correctionAnalysisID(h.stateManager, user)
+	if !ok {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Анализ не найден. Пожалуйста, начните исправление заново.")
+		_, err := h.api.Send(msg)
+		return err
+	}
+	original, _, err := h.deps.FoodAnalysisSvc.GetAnalysisWithItems(ctx, user.ID, analysisID)
+	if err != nil {
+		logger.Error("Failed to get analysis for correction", "user_id", user.ID, "analysis_id", analysisID, "error", err)
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Ошибка при получении анализа")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+
+	analysis, err := h.deps.FoodAnalysisSvc.CorrectAnalysis(ctx, user.ID, analysisID, services.AnalysisCorrection{Carbs: carbs, Weight: weight})
+	if err != nil {
+		logger.Error("Failed to correct analysis", "user_id", user.ID, "analysis_id", analysisID, "error", err)
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Ошибка при сохранении исправления")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+	h.stateManager.ClearTempData(user.TelegramID)
+	h.stateManager.SetUserState(user.TelegramID, state.None)
+	return sendCorrectionResult(ctx, h.api, h.deps, message.Chat.ID, user, original.Carbs, analysis)
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/vladimiradmaev/diabetes-helper/internal/bot/state"
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+	"github.com/vladimiradmaev/diabetes-helper/internal/services"
+)
+
+// Allowed correction settings in mmol/L
+const (
+	minCorrectionFactor = 0.2
+	maxCorrectionFactor = 10
+	minTargetGlucose    = 4
+	maxTargetGlucose    = 10
+)
+
+// handleCorrectionFactor asks for the correction factor and target glucose
+func (h *CallbackHandler) handleCorrectionFactor(chatID int64, user *database.User) error {
+	h.stateManager.SetUserState(user.TelegramID, state.WaitingForCorrectionFactor)
+
+	unit := services.GlucoseUnitLabel(user.GlucoseUnit)
+	text := fmt.Sprintf("Введите через пробел, на сколько %s 1 ед. инсулина снижает сахар (ФЧИ), и целевой сахар, например: %s %s\n"+
+		"Если перед фото еды за последние 30 минут записан сахар выше цели, к дозе на углеводы добавится коррекция.\n"+
+		"Отправьте 0, чтобы выключить коррекцию.",
+		unit, formatGlucose(2, user.GlucoseUnit), formatGlucose(6, user.GlucoseUnit))
+	if user.CorrectionFactor > 0 {
+		text = fmt.Sprintf("Сейчас: ФЧИ %s, цель %s\n\n",
+			formatGlucose(user.CorrectionFactor, user.GlucoseUnit), formatGlucose(user.TargetBloodSugar, user.GlucoseUnit)) + text
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("◀️ Отмена", "settings"),
+		),
+	)
+	_, err := h.api.Send(msg)
+	return err
+}
+
+// handleCorrectionFactor saves the correction factor and target glucose,
+// entered in the user's glucose unit
+func (h *TextHandler) handleCorrectionFactor(ctx context.Context, message *tgbotapi.Message, user *database.User) error {
+	fields := strings.Fields(message.Text)
+
+	var factor, target float64
+	if len(fields) != 1 || strings.TrimSpace(fields[0]) != "0" {
+		if len(fields) != 2 {
+			msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("Пожалуйста, введите ФЧИ и целевой сахар через пробел, например: %s %s",
+				formatGlucose(2, user.GlucoseUnit), formatGlucose(6, user.GlucoseUnit)))
+			_, err := h.api.Send(msg)
+			return err
+		}
+		factorValue, factorOK := parsePositiveArg(fields[0])
+		targetValue, targetOK := parsePositiveArg(fields[1])
+		factor = services.ToMmol(factorValue, user.GlucoseUnit)
+		target = services.ToMmol(targetValue, user.GlucoseUnit)
+		if !factorOK || factor < minCorrectionFactor || factor > maxCorrectionFactor {
+			msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("ФЧИ должен быть от %s до %s",
+				formatGlucose(minCorrectionFactor, user.GlucoseUnit), formatGlucose(maxCorrectionFactor, user.GlucoseUnit)))
+			_, err := h.api.Send(msg)
+			return err
+		}
+		if !targetOK || target < minTargetGlucose || target > maxTargetGlucose {
+			msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("Целевой сахар должен быть от %s до %s",
+				formatGlucose(minTargetGlucose, user.GlucoseUnit), formatGlucose(maxTargetGlucose, user.GlucoseUnit)))
+			_, err := h.api.Send(msg)
+			return err
+		}
+	}
+
+	if err := h.deps.UserService.SetCorrection(ctx, user.ID, factor, target); err != nil {
+		logger.Error("Failed to save correction settings", "user_id", user.ID, "error", err)
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Ошибка при сохранении коррекции")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+	user.CorrectionFactor = factor
+	user.TargetBloodSugar = target
+	h.stateManager.SetUserState(user.TelegramID, state.None)
+
+	text := "✅ Коррекция выключена"
+	if factor > 0 {
+		text = fmt.Sprintf("✅ ФЧИ %s, цель %s",
+			formatGlucose(factor, user.GlucoseUnit), formatGlucose(target, user.GlucoseUnit))
+	}
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	if _, err := h.api.Send(msg); err != nil {
+		return err
+	}
+	return sendSettingsMenu(ctx, h.api, h.deps, message.Chat.ID, user)
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+)
+
+// handleCheckData handles the admin-only /checkdata command: it reports
+// inconsistent data with a few IDs of each kind so affected users can be fixed
+func (h *CommandHandler) handleCheckData(ctx context.Context, chatID int64) error {
+	findings, err := h.deps.DataCheckSvc.Check(ctx)
+	if err != nil {
+		logger.Error("Failed to check data consistency", "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при проверке данных")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+
+	var b strings.Builder
+	b.WriteString("🔎 Проверка данных\n\n")
+	problems := 0
+	for _, f := range findings {
+		if f.Count == 0 {
+			continue
+		}
+		problems++
+		ids := make([]string, len(f.SampleIDs))
+		for i, id := range f.SampleIDs {
+			ids[i] = fmt.Sprint(id)
+		}
+		fmt.Fprintf(&b, "⚠️ %s: %d\n   например: %s\n", f.Name, f.Count, strings.Join(ids, ", "))
+	}
+	if problems == 0 {
+		fmt.Fprintf(&b, "✅ Проблем не найдено (проверок: %d)", len(findings))
+	} else {
+		fmt.Fprintf(&b, "\nОстальные проверки (%d) без проблем", len(findings)-problems)
+	}
+
+	return sendLongText(h.api, chatID, b.String(), nil)
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+)
+
+// maxDocumentSize is the largest file a bot may upload to Telegram
+const maxDocumentSize = 50 << 20
+
+// sendDocument sends generated content as a file. Telegram picks the MIME
+// type from the file name, so the name must carry the right extension. A file
+// over the upload limit is gzipped, and text that is still too large is split
+// at line boundaries into numbered parts. The caption goes with the first file.
+func sendDocument(api *tgbotapi.BotAPI, chatID int64, filename, contentType string, data []byte, caption string) error {
+	if len(data) > maxDocumentSize {
+		compressed, err := gzipBytes(data)
+		if err != nil {
+			return err
+		}
+		if len(compressed) <= maxDocumentSize {
+			logger.Info("Document over the upload limit sent gzipped", "file", filename, "content_type", contentType,
+				"size", len(data), "compressed", len(compressed))
+			filename, data = filename+".gz", compressed
+		} else if !strings.HasPrefix(contentType, "text/") {
+			return fmt.Errorf("document %s is %d bytes, over the upload limit even compressed", filename, len(data))
+		}
+	}
+
+	parts := splitLines(data, maxDocumentSize)
+	for i, part := range parts {
+		name := filename
+		if len(parts) > 1 {
+			ext := ""
+			if dot := strings.LastIndex(filename, "."); dot > 0 {
+				name, ext = filename[:dot], filename[dot:]
+			}
+			name = fmt.Sprintf("%s_part%d%s", name, i+1, ext)
+		}
+
+		doc := tgbotapi.NewDocument(chatID, tgbotapi.FileBytes{Name: name, Bytes: part})
+		if i == 0 {
+			doc.Caption = caption
+			if len(parts) > 1 {
+				doc.Caption += fmt.Sprintf("\n\nФайл большой, поэтому разделен на части: %d", len(parts))
+			}
+		}
+		if _, err := api.Send(doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// gzipBytes compresses data with gzip
+func gzipBytes(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, fmt.Errorf("failed to compress document: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress document: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// splitLines cuts data into parts of at most limit bytes, ending each part
+// after a newline where there is one
+func splitLines(data []byte, limit int) [][]byte {
+	var parts [][]byte
+	for len(data) > limit {
+		cut := bytes.LastIndexByte(data[:limit], '\n') + 1
+		if cut == 0 {
+			cut = limit
+		}
+		parts = append(parts, data[:cut])
+		data = data[cut:]
+	}
+	return append(parts, data)
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/vladimiradmaev/diabetes-helper/internal/bot/state"
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/format"
+	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+	"github.com/vladimiradmaev/diabetes-helper/internal/services"
+)
+
+// EventHandler quick-logs events sent as stickers or emoji
+type EventHandler struct {
+	api          *tgbotapi.BotAPI
+	deps         Dependencies
+	stateManager state.StateManager
+}
+
+// NewEventHandler creates a new event handler
+func NewEventHandler(api *tgbotapi.BotAPI, deps Dependencies, stateManager state.StateManager) *EventHandler {
+	return &EventHandler{
+		api:          api,
+		deps:         deps,
+		stateManager: stateManager,
+	}
+}
+
+// IsEventEmoji reports whether a text message is a single recognized event emoji
+func (h *EventHandler) IsEventEmoji(message *tgbotapi.Message) bool {
+	_, ok := services.EventKindForEmoji(message.Text)
+	return ok
+}
+
+// Handle logs the event for a sticker or an emoji message
+func (h *EventHandler) Handle(ctx context.Context, message *tgbotapi.Message, user *database.User) error {
+	emoji := message.Text
+	if message.Sticker != nil {
+		emoji = message.Sticker.Emoji
+	}
+
+	kind, ok := services.EventKindForEmoji(emoji)
+	if !ok {
+		unsupportedMessages.Inc("sticker")
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Этот стикер не распознан. Для быстрой отметки отправьте: "+services.EventEmojiHelp()+
+			"\n"+stateHint(h.stateManager.GetUserState(user.TelegramID)))
+		_, err := h.api.Send(msg)
+		return err
+	}
+
+	event, err := h.deps.EventSvc.AddEvent(ctx, user.ID, kind, emoji)
+	if err != nil {
+		logger.Error("Failed to add event", "user_id", user.ID, "kind", kind, "error", err)
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Ошибка при сохранении отметки")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+
+	loc := services.UserLocation(user)
+	msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("✅ Отмечено: %s %s в %s",
+		emoji, services.EventKindLabel(kind), format.Clock(event.Timestamp, loc, format.Default)))
+	msg.ReplyToMessageID = message.MessageID
+	_, err = h.api.Send(msg)
+	return err
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+	"github.com/vladimiradmaev/diabetes-helper/internal/services"
+)
+
+// exportProgressStep is how many percent of progress trigger a status update
+const exportProgressStep = 10
+
+// handleExport handles the /export command with the choice of format
+func (h *CommandHandler) handleExport(chatID int64) error {
+	msg := tgbotapi.NewMessage(chatID, "📤 Выберите формат экспорта истории:\n\n"+
+		"📄 CSV — таблица для Excel и Google Таблиц\n"+
+		"🩺 Tidepool JSON — сахар, углеводы и инсулин в открытом формате Tidepool для загрузки в клинику")
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("📄 CSV", "export_csv"),
+			tgbotapi.NewInlineKeyboardButtonData("🩺 Tidepool JSON", "export_tidepool"),
+		),
+	)
+	_, err := h.api.Send(msg)
+	return err
+}
+
+// handleExportFormat queues an export of the chosen kind. The export is
+// generated by a background job, so this only queues it and replies right away.
+func (h *CallbackHandler) handleExportFormat(ctx context.Context, chatID int64, user *database.User, kind string) error {
+	job, created, err := h.deps.JobSvc.EnqueueExport(ctx, user.ID, chatID, kind)
+	if err != nil {
+		logger.Error("Failed to enqueue export", "user_id", user.ID, "kind", kind, "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при создании экспорта")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+	if !created {
+		msg := tgbotapi.NewMessage(chatID, "⏳ Экспорт уже готовится, пришлю файл, как только он будет готов")
+		_, err := h.api.Send(msg)
+		return err
+	}
+
+	sent, err := h.api.Send(tgbotapi.NewMessage(chatID, "⏳ Готовлю экспорт истории, пришлю файл"))
+	if err != nil {
+		return err
+	}
+	if err := h.deps.JobSvc.SetMessageID(ctx, job.ID, sent.MessageID); err != nil {
+		logger.Warn("Failed to save export status message", "job_id", job.ID, "error", err)
+	}
+	return nil
+}
+
+// ProcessJobs runs queued jobs one by one until the queue is empty
+func ProcessJobs(ctx context.Context, api *tgbotapi.BotAPI, deps Dependencies) error {
+	for ctx.Err() == nil {
+		job, err := deps.JobSvc.ClaimNext(ctx)
+		if err != nil {
+			return err
+		}
+		if job == nil {
+			return nil
+		}
+		runJob(ctx, api, deps, job)
+	}
+	return nil
+}
+
+// runJob processes a job chunk by chunk and delivers the result. Jobs
+// interrupted by shutdown are left running and requeued on the next start.
+func runJob(ctx context.Context, api *tgbotapi.BotAPI, deps Dependencies, job *database.Job) {
+	logger.Info("Running job", "job_id", job.ID, "kind", job.Kind, "attempt", job.Attempts)
+
+	if job.Kind == services.JobKindReanalysis {
+		runReanalysis(ctx, api, deps, job)
+		return
+	}
+
+	err := runJobChunks(ctx, api, deps, job)
+	if err == nil {
+		err = deliverExport(ctx, api, deps, job)
+	}
+	if err == nil || ctx.Err() != nil {
+		return
+	}
+
+	logger.Error("Job failed", "job_id", job.ID, "error", err)
+	retry, failErr := deps.JobSvc.Fail(ctx, job, err)
+	if failErr != nil {
+		logger.Error("Failed to record job failure", "job_id", job.ID, "error", failErr)
+		return
+	}
+	if !retry {
+		updateJobStatus(api, job, "❌ Не удалось подготовить экспорт. Попробуйте позже командой /export")
+	}
+}
+
+func runJobChunks(ctx context.Context, api *tgbotapi.BotAPI, deps Dependencies, job *database.Job) error {
+	reported := -exportProgressStep
+	for {
+		done, err := deps.JobSvc.RunChunk(ctx, job)
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+
+		if job.Total > 0 {
+			percent := job.Processed * 100 / job.Total
+			if percent/exportProgressStep != reported/exportProgressStep {
+				reported = percent
+				updateJobStatus(api, job, fmt.Sprintf("⏳ Готовлю экспорт истории: %d%%", percent))
+			}
+		}
+	}
+}
+
+// deliverExport sends the export file and removes it afterwards
+func deliverExport(ctx context.Context, api *tgbotapi.BotAPI, deps Dependencies, job *database.Job) error {
+	filename, contentType, data, err := deps.JobSvc.Result(ctx, job)
+	if err != nil {
+		return err
+	}
+
+	caption := fmt.Sprintf("📤 Экспорт истории: %d записей", job.Processed)
+	if err := sendDocument(api, job.ChatID, filename, contentType, data, caption); err != nil {
+		return fmt.Errorf("failed to send export file: %w", err)
+	}
+	updateJobStatus(api, job, "✅ Экспорт готов")
+	return deps.JobSvc.MarkDelivered(ctx, job)
+}
+
+// updateJobStatus edits the job's status message; failures are only logged
+func updateJobStatus(api *tgbotapi.BotAPI, job *database.Job, text string) {
+	if job.MessageID == 0 {
+		return
+	}
+	if _, err := api.Send(tgbotapi.NewEditMessageText(job.ChatID, job.MessageID, text)); err != nil {
+		logger.Warn("Failed to update job status", "job_id", job.ID, "error", err)
+	}
+}
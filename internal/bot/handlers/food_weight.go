@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+)
+
+// handleFoodWeight saves the weight typed before the food photo. The state
+// stays the same, so another number replaces the weight; the photo handler
+// uses and clears it.
+func (h *TextHandler) handleFoodWeight(ctx context.Context, message *tgbotapi.Message, user *database.User) error {
+	weight, dish, ok := parseCaption(message.Text)
+	if !ok || dish != "" || weight < 1 || weight > 5000 {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Пожалуйста, отправьте фото еды или сначала вес порции в граммах, например: 150")
+		_, err := h.api.Send(msg)
+		return err
+	}
+
+	h.stateManager.SetUserWeight(user.TelegramID, weight)
+	logger.Infof("User %d saved weight before photo: %.1f g", user.ID, weight)
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("✅ Вес %.0f г. Теперь отправьте фото блюда.", weight))
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("◀️ Главное меню", "main_menu"),
+		),
+	)
+	_, err := h.api.Send(msg)
+	return err
+}
@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/format"
+	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+	"github.com/vladimiradmaev/diabetes-helper/internal/services"
+)
+
+// captionWeightPattern matches a weight in a photo caption, such as "150" or "150г"
+var captionWeightPattern = regexp.MustCompile(`^(\d+(?:[.,]\d+)?)(?:г|гр|g)?\.?$`)
+
+// captionWeightUnits are unit words that may follow a weight in a caption
+var captionWeightUnits = map[string]bool{"г": true, "г.": true, "гр": true, "гр.": true, "g": true}
+
+// parseCaption splits a photo caption into the weight in grams and the dish
+// name, so "борщ 300 г", "300" and "борщ" are all accepted. ok is false when
+// the caption holds neither.
+func parseCaption(caption string) (weight float64, dish string, ok bool) {
+	var words []string
+	afterWeight := false
+	for _, w := range strings.Fields(caption) {
+		lower := strings.ToLower(w)
+		if afterWeight && captionWeightUnits[lower] {
+			afterWeight = false
+			continue
+		}
+		afterWeight = false
+		if m := captionWeightPattern.FindStringSubmatch(lower); m != nil && weight == 0 {
+			weight, _ = strconv.ParseFloat(strings.ReplaceAll(m[1], ",", "."), 64)
+			afterWeight = true
+			continue
+		}
+		words = append(words, w)
+	}
+
+	dish = strings.Join(words, " ")
+	if services.NormalizeFoodName(dish) == "" {
+		dish = ""
+	}
+	return weight, dish, weight > 0 || dish != ""
+}
+
+// Weights outside this range in a recipe caption are ingredient amounts or
+// servings rather than the weight of the portion
+const (
+	recipeMinWeight = 10
+	recipeMaxWeight = 3000
+)
+
+// recipeSeparators split a recipe caption into ingredients
+var recipeSeparators = regexp.MustCompile(`[\n,;]+`)
+
+// recipeWeightPattern matches a weight named explicitly in a recipe caption,
+// such as "вес порции 350", "вес: 350 г" or "Вес 0,4 кг"
+var recipeWeightPattern = regexp.MustCompile(`(?:^|[^\p{L}])вес(?:[^\p{L}\d][^\d\n]{0,20}?)?(\d+(?:[.,]\d+)?)\s*(кг|гр|г|g)?`)
+
+// parseRecipeCaption recognizes a caption listing several ingredients, such
+// as "Овсянка 60г, молоко 200мл, банан 1 шт, вес порции 350". The weight is
+// the one after "вес" or else a last line or item holding only a number; it
+// is 0 when neither is there or it is out of range. The ingredients are the
+// whole caption. isRecipe is false for a caption with at most one item, which
+// parseCaption handles.
+func parseRecipeCaption(caption string) (weight float64, ingredients string, isRecipe bool) {
+	ingredients = strings.TrimSpace(caption)
+	var segments []string
+	for _, segment := range recipeSeparators.Split(ingredients, -1) {
+		if strings.TrimSpace(segment) != "" {
+			segments = append(segments, segment)
+		}
+	}
+
+	items := 0
+	for _, segment := range segments {
+		if _, dish, _ := parseCaption(segment); dish != "" {
+			items++
+		}
+	}
+	if items < 2 {
+		return 0, "", false
+	}
+
+	lower := strings.ToLower(ingredients)
+	if m := recipeWeightPattern.FindStringSubmatch(lower); m != nil {
+		weight, _ = strconv.ParseFloat(strings.ReplaceAll(m[1], ",", "."), 64)
+		if m[2] == "кг" {
+			weight *= 1000
+		}
+	} else if last, dish, _ := parseCaption(segments[len(segments)-1]); dish == "" {
+		weight = last
+	}
+	if weight < recipeMinWeight || weight > recipeMaxWeight {
+		weight = 0
+	}
+	return weight, ingredients, true
+}
+
+// offerHistoryEstimate offers the typical carbs of the dish from the user's
+// history when the AI analysis failed. offered is false when the history has
+// no similar meal.
+func (h *PhotoHandler) offerHistoryEstimate(ctx context.Context, chatID int64, user *database.User, dish string, weight float64) (offered bool, err error) {
+	estimate, err := h.deps.FoodAnalysisSvc.EstimateFromHistory(ctx, user.ID, dish, weight)
+	if err != nil {
+		logger.Error("Failed to estimate carbs from history", "user_id", user.ID, "error", err)
+		return false, nil
+	}
+	if estimate == nil {
+		return false, nil
+	}
+
+	h.stateManager.SetTempData(user.TelegramID, "historyDish", estimate.Dish)
+	h.stateManager.SetTempData(user.TelegramID, "historyCarbs", estimate.Carbs)
+	h.stateManager.SetTempData(user.TelegramID, "historyMatches", float64(estimate.Matches))
+	h.stateManager.SetTempData(user.TelegramID, "historyWeight", weight)
+
+	text := fmt.Sprintf("⚠️ ИИ недоступен, но похожее блюдо «%s» обычно было ~%.0f г углеводов", estimate.Dish, estimate.Carbs)
+	if weight > 0 {
+		text += fmt.Sprintf(" на %.0f г", weight)
+	}
+	text += fmt.Sprintf(" (по %d записям). Использовать?", estimate.Matches)
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✅ Использовать", "history_carbs_use"),
+			tgbotapi.NewInlineKeyboardButtonData("❌ Нет", "history_carbs_decline"),
+		),
+	)
+	_, err = h.api.Send(msg)
+	return true, err
+}
+
+// handleHistoryCarbsUse logs the meal with the carbs offered from history
+func (h *CallbackHandler) handleHistoryCarbsUse(ctx context.Context, chatID int64, user *database.User) error {
+	dishVal, _ := h.stateManager.GetTempData(user.TelegramID, "historyDish")
+	carbsVal, _ := h.stateManager.GetTempData(user.TelegramID, "historyCarbs")
+	matchesVal, _ := h.stateManager.GetTempData(user.TelegramID, "historyMatches")
+	weightVal, _ := h.stateManager.GetTempData(user.TelegramID, "historyWeight")
+	dish, _ := dishVal.(string)
+	carbs, _ := carbsVal.(float64)
+	matches, _ := matchesVal.(float64)
+	weight, _ := weightVal.(float64)
+	if dish == "" || carbs <= 0 {
+		msg := tgbotapi.NewMessage(chatID, "Предложение устарело. Пожалуйста, отправьте фото еще раз или введите углеводы вручную.")
+		_, err := h.api.Send(msg)
+		return err
+	}
+
+	estimate := services.HistoryEstimate{Dish: dish, Carbs: carbs, Matches: int(matches)}
+	analysis, err := h.deps.FoodAnalysisSvc.SaveFromHistory(ctx, user.ID, estimate, weight)
+	if err != nil {
+		logger.Error("Failed to save meal from history", "user_id", user.ID, "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при сохранении")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+	h.stateManager.ClearTempData(user.TelegramID)
+
+	header := fmt.Sprintf("✅ Записано: %.0f г углеводов, %s\n", analysis.Carbs,
+		format.BreadUnits(analysis.BreadUnits, services.BreadUnitStep(user), format.Default))
+	text, hypo := doseText(user, analysis, recentGlucose(ctx, h.deps, user), header,
+		"Рекомендуемая доза инсулина", carbProgressText(ctx, h.deps, user))
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🏠 Главное меню", "main_menu"),
+		),
+	)
+	if hypo {
+		keyboard = withLowRecheckButton(keyboard)
+	}
+	msg.ReplyMarkup = keyboard
+	_, err = h.api.Send(msg)
+	return err
+}
+
+// handleHistoryCarbsDecline drops the offer and suggests entering carbs manually
+func (h *CallbackHandler) handleHistoryCarbsDecline(chatID int64, user *database.User) error {
+	h.stateManager.ClearTempData(user.TelegramID)
+
+	msg := tgbotapi.NewMessage(chatID, "Хорошо. Попробуйте отправить фото позже или введите углеводы вручную.")
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🔢 Ввести углеводы", "manual_carbs"),
+			tgbotapi.NewInlineKeyboardButtonData("🏠 Главное меню", "main_menu"),
+		),
+	)
+	_, err := h.api.Send(msg)
+	return err
+}
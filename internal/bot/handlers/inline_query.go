@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/vladimiradmaev/diabetes-helper/internal/dosing"
+	"github.com/vladimiradmaev/diabetes-helper/internal/format"
+	"github.com/vladimiradmaev/diabetes-helper/internal/services"
+)
+
+// inlineResultsLimit is how many foods an inline query returns
+const inlineResultsLimit = 10
+
+// inlineCacheSeconds is how long Telegram may cache inline results. They
+// come from a fixed table and are the same for everyone.
+const inlineCacheSeconds = 3600
+
+// InlineQueryHandler answers "@bot банан" typed in any chat with the carbs of
+// matching foods from the reference table
+type InlineQueryHandler struct {
+	api *tgbotapi.BotAPI
+}
+
+// NewInlineQueryHandler creates a new inline query handler
+func NewInlineQueryHandler(api *tgbotapi.BotAPI) *InlineQueryHandler {
+	return &InlineQueryHandler{api: api}
+}
+
+// Handle answers an inline query. An empty or unknown query gets no results,
+// which Telegram shows as an empty list.
+func (h *InlineQueryHandler) Handle(query *tgbotapi.InlineQuery) error {
+	refs := services.LookupFoodReferences(query.Query, inlineResultsLimit)
+
+	results := make([]interface{}, 0, len(refs))
+	for i, ref := range refs {
+		title := fmt.Sprintf("%s — %s углеводов на 100 г", ref.Name, format.Grams(ref.CarbsPer100, 0, format.Default))
+		article := tgbotapi.NewInlineQueryResultArticle(strconv.Itoa(i), title, foodReferenceText(ref))
+		article.Description = fmt.Sprintf("%s (%s) ≈ %s, %s",
+			ref.Portion, format.Grams(ref.PortionGrams, 0, format.Default),
+			format.Grams(ref.PortionCarbs(), 0, format.Default),
+			format.BreadUnits(ref.PortionCarbs()/dosing.BreadUnitGrams, 0, format.Default))
+		results = append(results, article)
+	}
+
+	_, err := h.api.Request(tgbotapi.InlineConfig{
+		InlineQueryID: query.ID,
+		Results:       results,
+		CacheTime:     inlineCacheSeconds,
+	})
+	return err
+}
+
+// foodReferenceText is the message sent to the chat when a result is chosen
+func foodReferenceText(ref services.FoodReference) string {
+	return fmt.Sprintf("🍽 %s\n100 г: %s углеводов, %s\n%s (%s): %s углеводов, %s\n\nТипичные значения, у конкретного продукта могут отличаться",
+		ref.Name,
+		format.Grams(ref.CarbsPer100, 1, format.Default),
+		format.BreadUnits(ref.CarbsPer100/dosing.BreadUnitGrams, 0, format.Default),
+		ref.Portion, format.Grams(ref.PortionGrams, 0, format.Default),
+		format.Grams(ref.PortionCarbs(), 1, format.Default),
+		format.BreadUnits(ref.PortionCarbs()/dosing.BreadUnitGrams, 0, format.Default))
+}
@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+	"github.com/vladimiradmaev/diabetes-helper/internal/services"
+)
+
+// insulinChartDays is how many days the /insulin chart covers
+const insulinChartDays = 14
+
+// sendInsulinChart sends the user's logged insulin per day as an image
+func sendInsulinChart(ctx context.Context, api *tgbotapi.BotAPI, deps Dependencies, chatID int64, user *database.User) error {
+	since := time.Now().AddDate(0, 0, -insulinChartDays)
+	injections, err := deps.InjectionSvc.GetUserInjections(ctx, user.ID, since)
+	if err != nil {
+		logger.Error("Failed to get injections", "user_id", user.ID, "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при получении уколов")
+		_, sendErr := api.Send(msg)
+		return sendErr
+	}
+
+	if len(injections) == 0 {
+		msg := tgbotapi.NewMessage(chatID, "За последние 14 дней уколы не записаны")
+		_, err := api.Send(msg)
+		return err
+	}
+
+	image, err := deps.ChartSvc.RenderInsulinChart(injections, services.UserLocation(user), insulinChartDays)
+	if err != nil {
+		logger.Error("Failed to render insulin chart", "user_id", user.ID, "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при создании картинки")
+		_, sendErr := api.Send(msg)
+		return sendErr
+	}
+
+	photo := tgbotapi.NewPhoto(chatID, tgbotapi.FileBytes{Name: "insulin.png", Bytes: image})
+	photo.Caption = "💉 Инсулин по дням за 14 дней\n🟩 базальный, 🟦 на еду, 🟧 коррекция\nНад столбцом всего единиц за день, под ним число месяца"
+	_, err = api.Send(photo)
+	return err
+}
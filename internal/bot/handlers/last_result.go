@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"context"
+	"unicode/utf8"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+	"github.com/vladimiradmaev/diabetes-helper/internal/services"
+	"github.com/vladimiradmaev/diabetes-helper/internal/utils"
+)
+
+// sendLastResult sends the user's most recent analysis result again, rebuilt
+// from the stored analysis
+func sendLastResult(ctx context.Context, api *tgbotapi.BotAPI, deps Dependencies, chatID int64, user *database.User) error {
+	analysis, err := deps.FoodAnalysisSvc.GetLastAnalysis(ctx, user.ID)
+	if err != nil {
+		logger.Error("Failed to get last analysis", "user_id", user.ID, "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при получении последнего анализа")
+		_, sendErr := api.Send(msg)
+		return sendErr
+	}
+	if analysis == nil {
+		msg := tgbotapi.NewMessage(chatID, "Анализов пока нет. Отправьте фото еды, и здесь появится результат.")
+		_, err := api.Send(msg)
+		return err
+	}
+
+	return sendStoredResult(ctx, api, deps, chatID, user, analysis, services.CompactResults(user), resultKeyboard(analysis.ID))
+}
+
+// sendStoredResult sends the result card of a stored analysis. With a photo
+// it is sent as the photo's caption when it fits, like the original result,
+// or after the photo otherwise. The photo is the archived one, or else the
+// analysed image while Telegram still has it.
+func sendStoredResult(ctx context.Context, api *tgbotapi.BotAPI, deps Dependencies, chatID int64, user *database.User, analysis *database.FoodAnalysis, compact bool, keyboard tgbotapi.InlineKeyboardMarkup) error {
+	card := resultCard{
+		Analysis:      analysis,
+		Compact:       compact,
+		BreadUnitStep: services.BreadUnitStep(user),
+		GlucoseUnit:   user.GlucoseUnit,
+		Hypo:          hypoWarningText(user, recentGlucose(ctx, deps, user)),
+		Replay:        true,
+		Loc:           services.UserLocation(user),
+	}
+	if card.Hypo != "" {
+		keyboard = withLowRecheckButton(keyboard)
+	}
+	text := card.markdown()
+
+	var photoFile tgbotapi.RequestFileData
+	switch {
+	case analysis.PhotoFileID != "":
+		photoFile = tgbotapi.FileID(analysis.PhotoFileID)
+	case analysis.ImageURL != "":
+		photoFile = tgbotapi.FileURL(analysis.ImageURL)
+	}
+
+	const maxCaptionLength = 1024
+	if photoFile != nil && utf8.RuneCountInString(text) <= maxCaptionLength {
+		photo := tgbotapi.NewPhoto(chatID, photoFile)
+		photo.Caption = text
+		photo.ParseMode = "Markdown"
+		photo.ReplyMarkup = keyboard
+		_, err := api.Send(photo)
+		if err == nil {
+			return nil
+		}
+		// Telegram may have dropped the file; the text alone is enough
+		logger.Warn("Failed to resend result photo", "user_id", user.ID, "analysis_id", analysis.ID, "error", err)
+	} else if photoFile != nil {
+		// The card doesn't fit a caption, so the photo goes first on its own
+		if _, err := api.Send(tgbotapi.NewPhoto(chatID, photoFile)); err != nil {
+			logger.Warn("Failed to resend result photo", "user_id", user.ID, "analysis_id", analysis.ID, "error", err)
+		}
+	}
+
+	if utf8.RuneCountInString(text) <= utils.TelegramMessageLimit {
+		msg := tgbotapi.NewMessage(chatID, text)
+		msg.ParseMode = "Markdown"
+		msg.ReplyMarkup = keyboard
+		_, err := api.Send(msg)
+		if err == nil {
+			return nil
+		}
+		logger.Warn("Failed to resend result with Markdown, sending plain text",
+			"user_id", user.ID, "analysis_id", analysis.ID, "caption", text, "error", err)
+	}
+	return sendLongText(api, chatID, card.plain(), keyboard)
+}
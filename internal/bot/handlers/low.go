@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/dosing"
+	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+	"github.com/vladimiradmaev/diabetes-helper/internal/services"
+)
+
+// lowRecheckDelay is when the user is reminded to recheck after treating a low
+const lowRecheckDelay = 15 * time.Minute
+
+// maxLowRuleGrams bounds the grams of a low treatment rule
+const maxLowRuleGrams = 100
+
+// lowRuleUsage explains the /low_rule arguments
+const lowRuleUsage = "Использование: /low_rule <граммы> <на сколько поднимают> [цель]\n" +
+	"Например, /low_rule 15 3 — 15 г быстрых углеводов поднимают сахар на 3 ммоль/л. " +
+	"Цель, до которой поднимать сахар, по умолчанию 5.5 ммоль/л."
+
+// lowTarget returns the level in mmol/L the user treats lows up to
+func lowTarget(user *database.User) float64 {
+	if user.LowTarget > 0 {
+		return user.LowTarget
+	}
+	return dosing.DefaultLowTarget
+}
+
+// parsePositiveArg parses a positive number written with a comma or a dot
+func parsePositiveArg(s string) (float64, bool) {
+	value, err := strconv.ParseFloat(strings.ReplaceAll(strings.TrimSpace(s), ",", "."), 64)
+	return value, err == nil && value > 0
+}
+
+// handleLow handles /low <glucose>: the grams of fast carbs that bring the
+// current glucose up to the target by the user's rule. The reading is saved
+// and a recheck reminder is scheduled.
+func (h *CommandHandler) handleLow(ctx context.Context, chatID int64, user *database.User, args string) error {
+	if user.LowRuleGrams <= 0 || user.LowRuleRise <= 0 {
+		msg := tgbotapi.NewMessage(chatID, "Сначала задайте свое правило купирования гипо.\n\n"+lowRuleUsage)
+		_, err := h.api.Send(msg)
+		return err
+	}
+	value, ok := parsePositiveArg(args)
+	if !ok {
+		msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("Использование: /low <сахар в %s>, например /low 3.2",
+			services.GlucoseUnitLabel(user.GlucoseUnit)))
+		_, err := h.api.Send(msg)
+		return err
+	}
+
+	current := services.ToMmol(value, user.GlucoseUnit)
+	target := lowTarget(user)
+	grams := dosing.LowTreatmentGrams(current, target, user.LowRuleGrams, user.LowRuleRise)
+	if grams == 0 {
+		msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("Сахар %s не ниже цели %s, углеводы не нужны.",
+			formatGlucose(current, user.GlucoseUnit), formatGlucose(target, user.GlucoseUnit)))
+		_, err := h.api.Send(msg)
+		return err
+	}
+
+	if err := h.deps.BloodSugarSvc.AddRecord(ctx, user.ID, current); err != nil {
+		logger.Error("Failed to save low reading", "user_id", user.ID, "error", err)
+	}
+	recheck := true
+	if err := h.deps.UserService.ScheduleLowRecheck(ctx, user.ID, time.Now().Add(lowRecheckDelay)); err != nil {
+		logger.Error("Failed to schedule low recheck", "user_id", user.ID, "error", err)
+		recheck = false
+	}
+
+	text := fmt.Sprintf("🍬 Съешьте %.0f г быстрых углеводов, чтобы поднять сахар с %s до %s.\n"+
+		"Ваше правило: %.0f г поднимают на %s.",
+		grams, formatGlucose(current, user.GlucoseUnit), formatGlucose(target, user.GlucoseUnit),
+		user.LowRuleGrams, formatGlucose(user.LowRuleRise, user.GlucoseUnit))
+	if recheck {
+		text += "\n\nЧерез 15 минут напомню проверить сахар."
+	}
+	msg := tgbotapi.NewMessage(chatID, text)
+	_, err := h.api.Send(msg)
+	return err
+}
+
+// hypoWarningText warns that injecting now is dangerous when the recent
+// glucose bg (mmol/L, 0 when unknown) is a hypo, and says how to treat it by
+// the user's rule. It is empty when bolusing is safe as far as is known.
+func hypoWarningText(user *database.User, bg float64) string {
+	if !dosing.BolusUnsafe(bg) {
+		return ""
+	}
+	text := fmt.Sprintf("⛔️ Сахар %s — колоть инсулин на еду сейчас опасно!\n", formatGlucose(bg, user.GlucoseUnit))
+	target := lowTarget(user)
+	if grams := dosing.LowTreatmentGrams(bg, target, user.LowRuleGrams, user.LowRuleRise); grams > 0 {
+		text += fmt.Sprintf("Сначала купируйте гипо: съешьте %.0f г быстрых углеводов, чтобы поднять сахар до %s.",
+			grams, formatGlucose(target, user.GlucoseUnit))
+	} else {
+		text += "Сначала купируйте гипо быстрыми углеводами (сок, сахар, глюкоза)."
+	}
+	return text + " Перепроверьте сахар через 15 минут и колите дозу, только когда он поднимется."
+}
+
+// withLowRecheckButton adds the button that schedules a recheck reminder on
+// top of a result keyboard shown with a hypo warning
+func withLowRecheckButton(keyboard tgbotapi.InlineKeyboardMarkup) tgbotapi.InlineKeyboardMarkup {
+	row := tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("⏰ Напомнить перепроверить сахар", "low_recheck"),
+	)
+	keyboard.InlineKeyboard = append([][]tgbotapi.InlineKeyboardButton{row}, keyboard.InlineKeyboard...)
+	return keyboard
+}
+
+// handleLowRecheck schedules the reminder to recheck glucose after treating a low
+func (h *CallbackHandler) handleLowRecheck(ctx context.Context, chatID int64, user *database.User) error {
+	if err := h.deps.UserService.ScheduleLowRecheck(ctx, user.ID, time.Now().Add(lowRecheckDelay)); err != nil {
+		logger.Error("Failed to schedule low recheck", "user_id", user.ID, "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Не удалось поставить напоминание, перепроверьте сахар через 15 минут сами")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+	msg := tgbotapi.NewMessage(chatID, "⏰ Через 15 минут напомню проверить сахар")
+	_, err := h.api.Send(msg)
+	return err
+}
+
+// handleLowRuleSetup handles the low rule item of the profile checklist. The
+// rule is set with /low_rule, so it explains the command.
+func (h *CallbackHandler) handleLowRuleSetup(chatID int64, user *database.User) error {
+	msg := tgbotapi.NewMessage(chatID, lowRuleText(user))
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("◀️ Настройки", "settings"),
+		),
+	)
+	_, err := h.api.Send(msg)
+	return err
+}
+
+// lowRuleText shows the user's rule, if any, and how to set it
+func lowRuleText(user *database.User) string {
+	if user.LowRuleGrams <= 0 {
+		return lowRuleUsage
+	}
+	return fmt.Sprintf("Ваше правило: %.0f г поднимают на %s, цель %s.\n\n",
+		user.LowRuleGrams, formatGlucose(user.LowRuleRise, user.GlucoseUnit),
+		formatGlucose(lowTarget(user), user.GlucoseUnit)) + lowRuleUsage
+}
+
+// handleLowRule handles /low_rule <grams> <rise> [target], with glucose
+// values in the user's unit. Without arguments it shows the current rule.
+func (h *CommandHandler) handleLowRule(ctx context.Context, chatID int64, user *database.User, args string) error {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		msg := tgbotapi.NewMessage(chatID, lowRuleText(user))
+		_, err := h.api.Send(msg)
+		return err
+	}
+	if len(fields) > 3 {
+		msg := tgbotapi.NewMessage(chatID, lowRuleUsage)
+		_, err := h.api.Send(msg)
+		return err
+	}
+
+	grams, gramsOK := parsePositiveArg(fields[0])
+	riseValue, riseOK := 0.0, len(fields) > 1
+	if riseOK {
+		riseValue, riseOK = parsePositiveArg(fields[1])
+	}
+	if !gramsOK || !riseOK || grams > maxLowRuleGrams {
+		msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("Граммы должны быть от 1 до %d, подъем больше 0.\n\n%s", maxLowRuleGrams, lowRuleUsage))
+		_, err := h.api.Send(msg)
+		return err
+	}
+	rise := services.ToMmol(riseValue, user.GlucoseUnit)
+	if rise <= 0 || rise > 10 {
+		msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("Подъем должен быть больше 0 и не больше %s", formatGlucose(10, user.GlucoseUnit)))
+		_, err := h.api.Send(msg)
+		return err
+	}
+
+	var target float64
+	if len(fields) == 3 {
+		targetValue, ok := parsePositiveArg(fields[2])
+		target = services.ToMmol(targetValue, user.GlucoseUnit)
+		if !ok || target < 3.9 || target > 10 {
+			msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("Цель должна быть от %s до %s",
+				formatGlucose(3.9, user.GlucoseUnit), formatGlucose(10, user.GlucoseUnit)))
+			_, err := h.api.Send(msg)
+			return err
+		}
+	}
+
+	if err := h.deps.UserService.SetLowRule(ctx, user.ID, grams, rise, target); err != nil {
+		logger.Error("Failed to save low rule", "user_id", user.ID, "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при сохранении правила")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+	user.LowRuleGrams, user.LowRuleRise, user.LowTarget = grams, rise, target
+
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("✅ Правило сохранено: %.0f г поднимают на %s, цель %s.\n"+
+		"При низком сахаре отправьте /low <сахар>, например /low 3.2",
+		grams, formatGlucose(rise, user.GlucoseUnit), formatGlucose(lowTarget(user), user.GlucoseUnit)))
+	_, err := h.api.Send(msg)
+	return err
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/dosing"
+	"github.com/vladimiradmaev/diabetes-helper/internal/services"
+)
+
+func TestHypoWarningText(t *testing.T) {
+	mmol := &database.User{GlucoseUnit: services.GlucoseUnitMmol}
+	mmolRule := &database.User{GlucoseUnit: services.GlucoseUnitMmol, LowRuleGrams: 10, LowRuleRise: 2}
+	mgdl := &database.User{GlucoseUnit: services.GlucoseUnitMgdl}
+	mgdlRule := &database.User{GlucoseUnit: services.GlucoseUnitMgdl, LowRuleGrams: 15, LowRuleRise: 2.5, LowTarget: 5}
+
+	tests := []struct {
+		name string
+		user *database.User
+		bg   float64 // mmol/L, as stored
+		want string
+	}{
+		{"unknown glucose", mmol, 0, ""},
+		{"mmol at threshold", mmol, 3.9, ""},
+		{"mmol in range", mmol, 6.2, ""},
+		{
+			"mmol below threshold without rule", mmol, 3.8,
+			"⛔️ Сахар 3.8 ммоль/л — колоть инсулин на еду сейчас опасно!\n" +
+				"Сначала купируйте гипо быстрыми углеводами (сок, сахар, глюкоза). " +
+				"Перепроверьте сахар через 15 минут и колите дозу, только когда он поднимется.",
+		},
+		{
+			"mmol below threshold with rule", mmolRule, 3.0,
+			"⛔️ Сахар 3.0 ммоль/л — колоть инсулин на еду сейчас опасно!\n" +
+				"Сначала купируйте гипо: съешьте 13 г быстрых углеводов, чтобы поднять сахар до 5.5 ммоль/л. " +
+				"Перепроверьте сахар через 15 минут и колите дозу, только когда он поднимется.",
+		},
+		{"mgdl at threshold", mgdl, services.ToMmol(70, services.GlucoseUnitMgdl), ""},
+		{
+			"mgdl below threshold without rule", mgdl, services.ToMmol(65, services.GlucoseUnitMgdl),
+			"⛔️ Сахар 65 мг/дл — колоть инсулин на еду сейчас опасно!\n" +
+				"Сначала купируйте гипо быстрыми углеводами (сок, сахар, глюкоза). " +
+				"Перепроверьте сахар через 15 минут и колите дозу, только когда он поднимется.",
+		},
+		{
+			"mgdl below threshold with rule and target", mgdlRule, services.ToMmol(65, services.GlucoseUnitMgdl),
+			"⛔️ Сахар 65 мг/дл — колоть инсулин на еду сейчас опасно!\n" +
+				"Сначала купируйте гипо: съешьте 9 г быстрых углеводов, чтобы поднять сахар до 90 мг/дл. " +
+				"Перепроверьте сахар через 15 минут и колите дозу, только когда он поднимется.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hypoWarningText(tt.user, tt.bg); got != tt.want {
+				t.Errorf("hypoWarningText(%v) =\n%q\nwant\n%q", tt.bg, got, tt.want)
+			}
+		})
+	}
+}
+
+// hasCallback reports whether the message's inline keyboard has a button with the callback data
+func hasCallback(msg tgbotapi.MessageConfig, data string) bool {
+	keyboard, ok := msg.ReplyMarkup.(tgbotapi.InlineKeyboardMarkup)
+	if !ok {
+		return false
+	}
+	for _, row := range keyboard.InlineKeyboard {
+		for _, button := range row {
+			if button.CallbackData != nil && *button.CallbackData == data {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestCloneMealMessageHypo(t *testing.T) {
+	user := &database.User{GlucoseUnit: services.GlucoseUnitMmol}
+	analysis := &database.FoodAnalysis{Carbs: 48, BreadUnits: 4, InsulinRatio: 1.5, InsulinUnits: dosing.ToDeciUnits(6)}
+
+	tests := []struct {
+		name          string
+		bg            float64
+		wantWarning   bool
+		wantInjection bool
+	}{
+		{"unknown glucose", 0, false, true},
+		{"in range", 6.2, false, true},
+		{"hypo", 3.2, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := cloneMealMessage(1, user, analysis, tt.bg, "")
+			if got := strings.HasPrefix(msg.Text, "⛔️"); got != tt.wantWarning {
+				t.Errorf("text leads with hypo warning = %v, want %v:\n%s", got, tt.wantWarning, msg.Text)
+			}
+			if !strings.Contains(msg.Text, "💉 Рекомендуемая доза инсулина: 6.0 ед.") {
+				t.Errorf("text has no dose:\n%s", msg.Text)
+			}
+			if got := hasCallback(msg, "log_injection"); got != tt.wantInjection {
+				t.Errorf("log injection button = %v, want %v", got, tt.wantInjection)
+			}
+			if got := hasCallback(msg, "low_recheck"); got != tt.wantWarning {
+				t.Errorf("low recheck button = %v, want %v", got, tt.wantWarning)
+			}
+		})
+	}
+}
+
+func TestCorrectionResultMessageHypo(t *testing.T) {
+	user := &database.User{GlucoseUnit: services.GlucoseUnitMgdl, LowRuleGrams: 15, LowRuleRise: 2.5}
+	analysis := &database.FoodAnalysis{Carbs: 36, BreadUnits: 3, InsulinRatio: 1, InsulinUnits: dosing.ToDeciUnits(3)}
+
+	tests := []struct {
+		name        string
+		bg          float64 // mmol/L, as stored
+		wantWarning bool
+	}{
+		{"unknown glucose", 0, false},
+		{"at threshold", services.ToMmol(70, services.GlucoseUnitMgdl), false},
+		{"hypo", services.ToMmol(60, services.GlucoseUnitMgdl), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := correctionResultMessage(1, user, 48, analysis, tt.bg, "")
+			if got := strings.HasPrefix(msg.Text, "⛔️ Сахар "); got != tt.wantWarning {
+				t.Errorf("text leads with hypo warning = %v, want %v:\n%s", got, tt.wantWarning, msg.Text)
+			}
+			if !strings.Contains(msg.Text, "💉 Доза с учетом исправления: 3.0 ед.") {
+				t.Errorf("text has no dose:\n%s", msg.Text)
+			}
+			if got := hasCallback(msg, "low_recheck"); got != tt.wantWarning {
+				t.Errorf("low recheck button = %v, want %v", got, tt.wantWarning)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"context"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+)
+
+// pauseText confirms pausing or resuming notifications
+func pauseText(paused bool) string {
+	if paused {
+		return "🔕 Напоминания на паузе: базальные напоминания и уведомления о смене коэффициентов не придут, " +
+			"а их настройки сохранены. Напоминание перепроверить сахар после гипо приходит всегда.\n" +
+			"Включить снова: /resume или «🔕 Напоминания» в настройках."
+	}
+	return "🔔 Напоминания снова включены"
+}
+
+// setNotificationsPaused saves the pause and updates user
+func setNotificationsPaused(ctx context.Context, deps Dependencies, user *database.User, paused bool) error {
+	if err := deps.UserService.SetNotificationsPaused(ctx, user.ID, paused); err != nil {
+		logger.Error("Failed to save notifications pause", "user_id", user.ID, "error", err)
+		return err
+	}
+	user.NotificationsPaused = paused
+	return nil
+}
+
+// handlePause handles the /pause and /resume commands
+func (h *CommandHandler) handlePause(ctx context.Context, chatID int64, user *database.User, paused bool) error {
+	text := pauseText(paused)
+	if err := setNotificationsPaused(ctx, h.deps, user, paused); err != nil {
+		text = "Ошибка при сохранении настройки"
+	}
+	_, err := h.api.Send(tgbotapi.NewMessage(chatID, text))
+	return err
+}
+
+// handleToggleNotifications pauses or resumes notifications from the settings
+func (h *CallbackHandler) handleToggleNotifications(ctx context.Context, chatID int64, user *database.User) error {
+	paused := !user.NotificationsPaused
+	if err := setNotificationsPaused(ctx, h.deps, user, paused); err != nil {
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при сохранении настройки")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+	if _, err := h.api.Send(tgbotapi.NewMessage(chatID, pauseText(paused))); err != nil {
+		return err
+	}
+	return sendSettingsMenu(ctx, h.api, h.deps, chatID, user)
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// periodFormatHint is shown when a period can't be understood
+const periodFormatHint = "Введите период, например: 08:00-12:00, 8-12 или с 8 до 12"
+
+// periodPattern matches a period after normalizePeriod: two times, each "H",
+// "H:MM" or "H.MM", separated by a dash, "до" or spaces and optionally
+// preceded by "с"
+var periodPattern = regexp.MustCompile(`^(?:с\s*)?(\d{1,2})(?:[:.](\d{2}))?(?:\s*-\s*|\s+до\s+|\s+)(\d{1,2})(?:[:.](\d{2}))?$`)
+
+// normalizePeriod lowercases a period and turns dashes of any kind into "-"
+// and non-breaking spaces into spaces
+func normalizePeriod(text string) string {
+	text = strings.NewReplacer("–", "-", "—", "-", "−", "-", "‐", "-", " ", " ").Replace(text)
+	return strings.ToLower(strings.TrimSpace(text))
+}
+
+// parsePeriod parses a schedule period the way users type it: "08:00-12:00",
+// "8.00 - 12.00", "с 8 до 12", "08–12". Times are returned as "HH:MM"; an end
+// of 24:00 is returned as 00:00, which periods treat as midnight. guessed
+// reports that the input wasn't already in that form, so the result should be
+// shown to the user. When the input is invalid, problem holds the message to
+// show the user.
+func parsePeriod(text string) (startTime, endTime string, guessed bool, problem string) {
+	normalized := normalizePeriod(text)
+	m := periodPattern.FindStringSubmatch(normalized)
+	if m == nil {
+		return "", "", false, "Не понял период «" + strings.TrimSpace(text) + "». " + periodFormatHint
+	}
+
+	startTime, ok := formatClock(m[1], minutesOrZero(m[2]), false)
+	if !ok {
+		return "", "", false, "Неверное время начала: часы 00-23, минуты 00-59"
+	}
+	endTime, ok = formatClock(m[3], minutesOrZero(m[4]), true)
+	if !ok {
+		return "", "", false, "Неверное время окончания: часы 00-24, минуты 00-59"
+	}
+
+	canonical := fmt.Sprintf("%s-%s", startTime, endTime)
+	guessed = strings.ReplaceAll(normalized, " ", "") != canonical
+	return startTime, endTime, guessed, ""
+}
+
+// minutesOrZero returns the minutes of a time entered as hours only as "00"
+func minutesOrZero(minutes string) string {
+	if minutes == "" {
+		return "00"
+	}
+	return minutes
+}
+
+// periodConfirmation echoes how a guessed period was understood
+func periodConfirmation(startTime, endTime string) string {
+	return fmt.Sprintf("🕒 Понял период как %s-%s. Если не так, нажмите «Отмена» и введите заново.\n\n", startTime, endTime)
+}
+
+// periodsConfirmation is periodConfirmation for a list of periods, which the
+// preview shows as understood
+const periodsConfirmation = "🕒 Проверьте, правильно ли я понял периоды. Если нет, нажмите «Отмена» и введите заново.\n\n"
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+)
+
+func TestParsePeriod(t *testing.T) {
+	tests := []struct {
+		name        string
+		text        string
+		start, end  string
+		guessed     bool
+		wantProblem string // Prefix of the problem, empty when valid
+	}{
+		{"canonical", "08:00-12:00", "08:00", "12:00", false, ""},
+		{"canonical with spaces", " 08:00 - 12:00 ", "08:00", "12:00", false, ""},
+		{"hours only", "8-12", "08:00", "12:00", true, ""},
+		{"dots", "8.30 - 12.00", "08:30", "12:00", true, ""},
+		{"words", "с 8 до 12", "08:00", "12:00", true, ""},
+		{"capitalized words", "С 8 до 12", "08:00", "12:00", true, ""},
+		{"en dash", "08:00–12:00", "08:00", "12:00", false, ""},
+		{"em dash", "08:00—12:00", "08:00", "12:00", false, ""},
+		{"space separated", "22 6", "22:00", "06:00", true, ""},
+		{"non-breaking space", "с 8 до 12", "08:00", "12:00", true, ""},
+		{"end at 24", "18:00-24:00", "18:00", "00:00", true, ""},
+		{"end at midnight", "18:00-00:00", "18:00", "00:00", false, ""},
+		{"start at 24", "24-06", "", "", false, "Неверное время начала"},
+		{"end after 24", "08-25", "", "", false, "Неверное время окончания"},
+		{"minutes over 59", "08:60-12:00", "", "", false, "Неверное время начала"},
+		{"end minutes over 59", "08:00-12:75", "", "", false, "Неверное время окончания"},
+		{"single time", "08:00", "", "", false, "Не понял период «08:00»"},
+		{"text", "утро", "", "", false, "Не понял период «утро»"},
+		{"empty", "", "", "", false, "Не понял период «»"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end, guessed, problem := parsePeriod(tt.text)
+			if tt.wantProblem != "" {
+				if !strings.HasPrefix(problem, tt.wantProblem) {
+					t.Fatalf("parsePeriod(%q) problem = %q, want prefix %q", tt.text, problem, tt.wantProblem)
+				}
+				return
+			}
+			if problem != "" {
+				t.Fatalf("parsePeriod(%q) problem = %q", tt.text, problem)
+			}
+			if start != tt.start || end != tt.end || guessed != tt.guessed {
+				t.Errorf("parsePeriod(%q) = %s, %s, guessed %v, want %s, %s, guessed %v",
+					tt.text, start, end, guessed, tt.start, tt.end, tt.guessed)
+			}
+		})
+	}
+}
+
+func TestParseRatioSchedule(t *testing.T) {
+	ratio := func(start, end string, r float64) database.InsulinRatio {
+		return database.InsulinRatio{StartTime: start, EndTime: end, Ratio: r}
+	}
+
+	tests := []struct {
+		name        string
+		text        string
+		want        []database.InsulinRatio
+		guessed     bool
+		wantProblem string // Prefix of the problem, empty when valid
+	}{
+		{
+			"semicolons", "00:00-06:00 0.8; 06:00-11:00 1.5; 11:00-17:00 1.2; 17:00-00:00 1.0",
+			[]database.InsulinRatio{
+				ratio("00:00", "06:00", 0.8), ratio("06:00", "11:00", 1.5),
+				ratio("11:00", "17:00", 1.2), ratio("17:00", "00:00", 1),
+			},
+			false, "",
+		},
+		{
+			"new lines and units", "06:00-11:00 1,5 ед/ХЕ\n\n11:00-17:00: 1.2",
+			[]database.InsulinRatio{ratio("06:00", "11:00", 1.5), ratio("11:00", "17:00", 1.2)},
+			false, "",
+		},
+		{
+			"guessed period", "06:00-11:00 1.5; с 11 до 17 = 1.2",
+			[]database.InsulinRatio{ratio("06:00", "11:00", 1.5), ratio("11:00", "17:00", 1.2)},
+			true, "",
+		},
+		{
+			"crosses midnight", "22-06 0.8",
+			[]database.InsulinRatio{ratio("22:00", "06:00", 0.8)},
+			true, "",
+		},
+		{"overlap", "06:00-12:00 1.5; 11:00-17:00 1.2", nil, false, "Периоды 06:00-12:00 и 11:00-17:00 пересекаются"},
+		{"overlap across midnight", "22:00-06:00 0.8; 05:00-08:00 1", nil, false, "Периоды 22:00-06:00 и 05:00-08:00 пересекаются"},
+		{"zero ratio", "06:00-11:00 0", nil, false, "Коэффициент в «06:00-11:00 0» должен быть больше 0"},
+		{"no ratio", "06:00-11:00 много", nil, false, "Не понял период «06:00-11:00 много»"},
+		{"bad period", "06:00-25:00 1.5", nil, false, "В «06:00-25:00 1.5»: Неверное время окончания"},
+		{"empty", " ; \n", nil, false, "Не нашел ни одного периода"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, guessed, problem := parseRatioSchedule(tt.text)
+			if tt.wantProblem != "" {
+				if !strings.HasPrefix(problem, tt.wantProblem) {
+					t.Fatalf("parseRatioSchedule(%q) problem = %q, want prefix %q", tt.text, problem, tt.wantProblem)
+				}
+				return
+			}
+			if problem != "" {
+				t.Fatalf("parseRatioSchedule(%q) problem = %q", tt.text, problem)
+			}
+			if !reflect.DeepEqual(got, tt.want) || guessed != tt.guessed {
+				t.Errorf("parseRatioSchedule(%q) = %+v, guessed %v, want %+v, guessed %v", tt.text, got, guessed, tt.want, tt.guessed)
+			}
+		})
+	}
+}
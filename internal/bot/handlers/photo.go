@@ -2,14 +2,17 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/vladimiradmaev/diabetes-helper/internal/bot/keyboards"
 	"github.com/vladimiradmaev/diabetes-helper/internal/bot/state"
 	"github.com/vladimiradmaev/diabetes-helper/internal/database"
 	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+	"github.com/vladimiradmaev/diabetes-helper/internal/services"
 )
 
 // PhotoHandler handles photo messages
@@ -32,30 +35,44 @@ func NewPhotoHandler(api *tgbotapi.BotAPI, deps Dependencies, stateManager state
 func (h *PhotoHandler) Handle(ctx context.Context, message *tgbotapi.Message, user *database.User) error {
 	// Get the largest photo
 	photo := message.Photo[len(message.Photo)-1]
-	file, err := h.api.GetFile(tgbotapi.FileConfig{FileID: photo.FileID})
-	if err != nil {
-		return fmt.Errorf("failed to get file: %w", err)
-	}
 
-	// Check if weight is provided in caption or saved from state
+	// Check if weight is provided in caption or saved from state. The caption
+	// may also name the dish, which is used when the AI is unavailable, or
+	// list the ingredients of a recipe, which are passed to the AI.
 	weight := 0.0
+	dish := ""
+	ingredients := ""
+
+	if message.Caption != "" {
+		captionWeight, recipe, isRecipe := parseRecipeCaption(message.Caption)
+		if isRecipe {
+			ingredients = recipe
+		} else {
+			var ok bool
+			captionWeight, dish, ok = parseCaption(message.Caption)
+			if !ok {
+				msg := tgbotapi.NewMessage(message.Chat.ID, "Неверный формат веса. Пожалуйста, укажите вес в граммах (например: 100).")
+				_, err := h.api.Send(msg)
+				return err
+			}
+		}
+		if captionWeight > 0 {
+			weight = captionWeight
+			logger.Infof("User %d provided weight in caption: %.1f g", user.ID, weight)
+		}
+	}
 
-	// First check for saved weight from the food analysis flow
+	// A weight typed before the photo in the food analysis flow is used once,
+	// unless a command ended the flow since; a weight in the caption replaces it
 	savedWeight := h.stateManager.GetUserWeight(user.TelegramID)
 	if savedWeight > 0 {
-		weight = savedWeight
-		logger.Infof("User %d using saved weight: %.1f g", user.ID, weight)
-		// Clear saved weight after use
-		h.stateManager.SetUserWeight(user.TelegramID, 0)
-	} else if message.Caption != "" {
-		weight, err = strconv.ParseFloat(message.Caption, 64)
-		if err != nil {
-			msg := tgbotapi.NewMessage(message.Chat.ID, "Неверный формат веса. Пожалуйста, укажите вес в граммах (например: 100).")
-			_, err := h.api.Send(msg)
-			return err
+		h.stateManager.ClearUserWeight(user.TelegramID)
+		if weight <= 0 && h.stateManager.GetUserState(user.TelegramID) == state.WaitingForFoodWeight {
+			weight = savedWeight
+			logger.Infof("User %d using saved weight: %.1f g", user.ID, weight)
 		}
-		logger.Infof("User %d provided weight in caption: %.1f g", user.ID, weight)
-	} else {
+	}
+	if weight <= 0 {
 		msg := tgbotapi.NewMessage(message.Chat.ID, "Вес не указан. Я попробую оценить вес блюда автоматически.")
 		_, err := h.api.Send(msg)
 		if err != nil {
@@ -63,6 +80,16 @@ func (h *PhotoHandler) Handle(ctx context.Context, message *tgbotapi.Message, us
 		}
 	}
 
+	// Photos over the AI rate limit wait in the queue
+	allowed, err := h.deps.AnalysisQueue.Acquire(ctx)
+	if err != nil {
+		logger.Error("Failed to check the AI rate limit", "user_id", user.ID, "error", err)
+		allowed = true
+	}
+	if !allowed {
+		return h.enqueue(ctx, message.Chat.ID, user, photo.FileID, weight, dish, ingredients)
+	}
+
 	// Send "processing" message
 	processingMsg := tgbotapi.NewMessage(message.Chat.ID, "Анализирую изображение...")
 	sentMsg, err := h.api.Send(processingMsg)
@@ -70,25 +97,53 @@ func (h *PhotoHandler) Handle(ctx context.Context, message *tgbotapi.Message, us
 		return fmt.Errorf("failed to send processing message: %w", err)
 	}
 
+	if err := h.analyze(ctx, message.Chat.ID, user, photo.FileID, weight, dish, ingredients, sentMsg.MessageID); err != nil {
+		return err
+	}
+
+	// Reset user state
+	h.stateManager.SetUserState(user.TelegramID, state.None)
+	return nil
+}
+
+// analyze runs the food analysis of a photo and sends the result card. The
+// status message is removed once the analysis is done.
+func (h *PhotoHandler) analyze(ctx context.Context, chatID int64, user *database.User, fileID string, weight float64, dish, ingredients string, statusMessageID int) error {
+	file, err := h.api.GetFile(tgbotapi.FileConfig{FileID: fileID})
+	if err != nil {
+		return fmt.Errorf("failed to get file: %w", err)
+	}
+
 	// Analyze the image
 	logger.Infof("Starting food analysis for user %d with Gemini", user.ID)
-	analysis, err := h.deps.FoodAnalysisSvc.AnalyzeFood(ctx, user.ID, file.Link(h.api.Token), weight)
+	analysis, err := h.deps.FoodAnalysisSvc.AnalyzeFood(ctx, user.ID, file.Link(h.api.Token), weight, ingredients)
+	var meterErr *services.MeterPhotoError
+	if errors.As(err, &meterErr) {
+		h.api.Send(tgbotapi.NewDeleteMessage(chatID, statusMessageID))
+		return h.offerMeterReading(chatID, user, meterErr.Mmol)
+	}
+	if err != nil && dish != "" {
+		if offered, offerErr := h.offerHistoryEstimate(ctx, chatID, user, dish, weight); offered || offerErr != nil {
+			h.api.Send(tgbotapi.NewDeleteMessage(chatID, statusMessageID))
+			return offerErr
+		}
+	}
 	if err != nil {
-		msg := tgbotapi.NewMessage(message.Chat.ID, "Извините, произошла ошибка при анализе изображения. Пожалуйста, попробуйте еще раз через несколько минут.")
+		msg := tgbotapi.NewMessage(chatID, "Извините, произошла ошибка при анализе изображения. Пожалуйста, попробуйте еще раз через несколько минут.")
 		_, err := h.api.Send(msg)
 		return err
 	}
 	logger.Infof("Food analysis completed for user %d", user.ID)
 
 	// Delete processing message
-	deleteMsg := tgbotapi.NewDeleteMessage(message.Chat.ID, sentMsg.MessageID)
+	deleteMsg := tgbotapi.NewDeleteMessage(chatID, statusMessageID)
 	h.api.Send(deleteMsg)
 
 	// Check if no food was detected (independent of weight)
 	if analysis.Carbs == 0 && len(analysis.AnalysisText) > 0 &&
 		strings.Contains(analysis.AnalysisText, "не обнаружена еда") {
 		// Send a simple text message for non-food images with proper navigation
-		msg := tgbotapi.NewMessage(message.Chat.ID, "На изображении не обнаружена еда. Пожалуйста, отправьте фото блюда для анализа.")
+		msg := tgbotapi.NewMessage(chatID, "На изображении не обнаружена еда. Пожалуйста, отправьте фото блюда для анализа.")
 		keyboard := tgbotapi.NewInlineKeyboardMarkup(
 			tgbotapi.NewInlineKeyboardRow(
 				tgbotapi.NewInlineKeyboardButtonData("🏠 Главное меню", "main_menu"),
@@ -100,97 +155,72 @@ func (h *PhotoHandler) Handle(ctx context.Context, message *tgbotapi.Message, us
 		if err != nil {
 			return fmt.Errorf("failed to send non-food message: %w", err)
 		}
-		// Reset user state
-		h.stateManager.SetUserState(user.TelegramID, state.None)
 		return nil
 	}
 
-	// Escape only essential Markdown characters
-	escapedAnalysisText := strings.ReplaceAll(analysis.AnalysisText, "_", "\\_")
-	escapedAnalysisText = strings.ReplaceAll(escapedAnalysisText, "*", "\\*")
-	escapedAnalysisText = strings.ReplaceAll(escapedAnalysisText, "[", "\\[")
-	escapedAnalysisText = strings.ReplaceAll(escapedAnalysisText, "]", "\\]")
-	escapedAnalysisText = strings.ReplaceAll(escapedAnalysisText, "`", "\\`")
-
-	// Ensure text is valid UTF-8
-	escapedAnalysisText = strings.ToValidUTF8(escapedAnalysisText, "")
-
-	// Truncate analysis text if it's too long (Telegram has a 1024 character limit for captions)
-	const maxCaptionLength = 900 // Leave some room for the rest of the message
-	if len(escapedAnalysisText) > maxCaptionLength {
-		escapedAnalysisText = escapedAnalysisText[:maxCaptionLength-3] + "..."
+	if user.ArchivePhotos {
+		if err := h.deps.FoodAnalysisSvc.ArchivePhoto(ctx, analysis, fileID, file.Link(h.api.Token)); err != nil {
+			logger.Warn("Failed to archive meal photo", "user_id", user.ID, "analysis_id", analysis.ID, "error", err)
+		}
 	}
 
-	// Send analysis result with photo
-	var weightText string
-	if weight > 0 {
-		weightText = fmt.Sprintf("⚖️ *Введенный вес:* %.1f г", weight)
-	} else if analysis.Weight > 0 {
-		weightText = fmt.Sprintf("⚖️ *Рассчитанный вес:* %.1f г", analysis.Weight)
-	} else {
-		weightText = "⚖️ *Вес:* не указан"
+	// The repeat on the primary model needs the photo again later
+	offerReanalysis := false
+	if user.ReanalyzeOnPrimary && services.IsFallbackProvider(analysis.UsedProvider) {
+		if err := h.deps.FoodAnalysisSvc.KeepPhotoFileID(ctx, analysis, fileID); err != nil {
+			logger.Warn("Failed to keep photo for re-analysis", "user_id", user.ID, "analysis_id", analysis.ID, "error", err)
+		} else {
+			offerReanalysis = true
+		}
 	}
 
 	// Log weights for debugging
 	logger.Debug("Weight comparison", "user_weight", weight, "analysis_weight", analysis.Weight)
 
-	// Convert confidence to string representation
-	var confidenceText string
-	switch {
-	case analysis.Confidence >= 0.8:
-		confidenceText = "высокая"
-	case analysis.Confidence >= 0.6:
-		confidenceText = "средняя"
-	default:
-		confidenceText = "низкая"
-	}
-
-	// Format insulin recommendation
-	var insulinText string
-	if analysis.InsulinRatio > 0 {
-		insulinText = fmt.Sprintf("💉 *Рекомендуемая доза инсулина:* %.1f ед.\n(%.1f ХЕ × %.1f ед/ХЕ)",
-			analysis.InsulinUnits,
-			analysis.BreadUnits,
-			analysis.InsulinRatio)
-	} else {
-		insulinText = "💉 *Рекомендация по инсулину:* не настроен коэффициент для текущего времени"
-	}
-
-	resultText := fmt.Sprintf("🍽️ *Анализ блюда*\n\n"+
-		"🍞 *Углеводы:* %.1f г\n"+
-		"🥖 *ХЕ:* %.1f\n"+
-		"%s\n"+
-		"🎯 *Уверенность:* %s\n"+
-		"%s\n\n"+
-		"📊 *Как считали:*\n%s",
-		analysis.Carbs,
-		analysis.BreadUnits,
-		insulinText,
-		confidenceText,
-		weightText,
-		escapedAnalysisText,
-	)
-
-	// Ensure the entire result text is valid UTF-8
-	resultText = strings.ToValidUTF8(resultText, "")
+	// Telegram limits captions to 1024 characters. A breakdown that doesn't
+	// fit is sent as plain text messages after the photo instead of being cut.
+	const maxCaptionLength = 1024
+	bg := recentGlucose(ctx, h.deps, user)
+	card := resultCard{
+		Analysis:      analysis,
+		Compact:       services.CompactResults(user),
+		BreadUnitStep: services.BreadUnitStep(user),
+		GlucoseUnit:   user.GlucoseUnit,
+		EnteredWeight: weight,
+		Progress:      carbProgressText(ctx, h.deps, user),
+		Timing:        bolusTimingText(h.deps, user, analysis, bg),
+		Hypo:          hypoWarningText(user, bg),
+	}
+	var breakdown string
+	if !card.Compact && utf8.RuneCountInString(card.markdown()) > maxCaptionLength {
+		card.AnalysisText = "в следующем сообщении"
+		breakdown = strings.ToValidUTF8(analysis.AnalysisText, "")
+	}
 
 	// Create photo message with caption
-	photoMsg := tgbotapi.NewPhoto(message.Chat.ID, tgbotapi.FileID(photo.FileID))
-	photoMsg.Caption = resultText
+	photoMsg := tgbotapi.NewPhoto(chatID, tgbotapi.FileID(fileID))
+	photoMsg.Caption = card.markdown()
 	photoMsg.ParseMode = "Markdown"
 
 	// Add navigation buttons
-	keyboard := tgbotapi.NewInlineKeyboardMarkup(
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("🏠 Главное меню", "main_menu"),
-			tgbotapi.NewInlineKeyboardButtonData("🔄 Новый анализ", "analyze_food"),
-		),
-	)
-	photoMsg.ReplyMarkup = keyboard
+	keyboard := resultKeyboard(analysis.ID)
+	if offerReanalysis {
+		keyboard = withReanalysisButton(keyboard, analysis.ID)
+	}
+	if card.Hypo != "" {
+		keyboard = withLowRecheckButton(keyboard)
+	}
+	if breakdown == "" {
+		photoMsg.ReplyMarkup = keyboard
+	}
 
 	_, err = h.api.Send(photoMsg)
 	if err != nil {
-		// If Markdown parsing fails, try sending without Markdown
+		// Most likely the Markdown didn't parse. The caption is logged so the
+		// escaping can be fixed, and the same card is sent without markup.
+		logger.Warn("Failed to send result card with Markdown, sending plain text",
+			"user_id", user.ID, "analysis_id", analysis.ID, "caption", photoMsg.Caption, "error", err)
+		photoMsg.Caption = card.plain()
 		photoMsg.ParseMode = ""
 		_, err = h.api.Send(photoMsg)
 		if err != nil {
@@ -198,7 +228,28 @@ func (h *PhotoHandler) Handle(ctx context.Context, message *tgbotapi.Message, us
 		}
 	}
 
-	// Reset user state
-	h.stateManager.SetUserState(user.TelegramID, state.None)
+	// The navigation buttons go under the last message of the result
+	if breakdown != "" {
+		if err := sendLongText(h.api, chatID, "📊 Как считали:\n"+breakdown, keyboard); err != nil {
+			return fmt.Errorf("failed to send analysis breakdown: %w", err)
+		}
+	}
+
 	return nil
 }
+
+// offerMeterReading asks whether to save the reading of a photographed glucose
+// meter. The value waits in the same place as a typed value with a unit
+// mismatch, so the blood sugar confirmation buttons save or discard it.
+func (h *PhotoHandler) offerMeterReading(chatID int64, user *database.User, mmol float64) error {
+	value := mmol
+	if user.GlucoseUnit == services.GlucoseUnitMgdl {
+		value = services.MmolToMgdl(mmol)
+	}
+	h.stateManager.SetTempData(user.TelegramID, "pendingBloodSugar", value)
+
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("📟 На фото глюкометр: %s — сохранить как замер?", formatGlucose(mmol, user.GlucoseUnit)))
+	msg.ReplyMarkup = keyboards.MeterReadingConfirm()
+	_, err := h.api.Send(msg)
+	return err
+}
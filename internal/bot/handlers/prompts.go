@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/vladimiradmaev/diabetes-helper/internal/bot/state"
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+)
+
+// promptsKey is the temp data key of the message IDs of an input flow's
+// prompts and answers, kept as "12,13,14" so it survives the Redis round trip
+const promptsKey = "transientPrompts"
+
+// sendPrompt sends a message of an input flow that is removed when the flow
+// completes or is cancelled, unless the user keeps prompts
+func sendPrompt(api *tgbotapi.BotAPI, stateManager state.StateManager, user *database.User, msg tgbotapi.MessageConfig) error {
+	sent, err := api.Send(msg)
+	if err != nil {
+		return err
+	}
+	trackPrompt(stateManager, user, sent.MessageID)
+	return nil
+}
+
+// trackPrompt remembers a message to remove when the input flow ends
+func trackPrompt(stateManager state.StateManager, user *database.User, messageID int) {
+	if user.KeepPrompts {
+		return
+	}
+	ids := strconv.Itoa(messageID)
+	if val, ok := stateManager.GetTempData(user.TelegramID, promptsKey); ok {
+		if prev, _ := val.(string); prev != "" {
+			ids = prev + "," + ids
+		}
+	}
+	stateManager.SetTempData(user.TelegramID, promptsKey, ids)
+}
+
+// clearPrompts deletes the tracked messages of the user's input flow. Telegram
+// refuses to delete messages older than 48 hours and some chats don't allow
+// it at all; such messages just stay, so errors are ignored.
+func clearPrompts(api *tgbotapi.BotAPI, stateManager state.StateManager, chatID int64, user *database.User) {
+	val, ok := stateManager.GetTempData(user.TelegramID, promptsKey)
+	if !ok {
+		return
+	}
+	stateManager.SetTempData(user.TelegramID, promptsKey, "")
+
+	ids, _ := val.(string)
+	for _, s := range strings.Split(ids, ",") {
+		id, err := strconv.Atoi(s)
+		if err != nil {
+			continue
+		}
+		api.Request(tgbotapi.NewDeleteMessage(chatID, id))
+	}
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/vladimiradmaev/diabetes-helper/internal/bot/state"
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+)
+
+// quietHoursPattern matches "23:00-07:00", also with a dash or spaces
+var quietHoursPattern = regexp.MustCompile(`^(\d{1,2}):(\d{2})\s*[-–—]\s*(\d{1,2}):(\d{2})$`)
+
+// handleQuietHours asks for the quiet hours window
+func (h *CallbackHandler) handleQuietHours(chatID int64, user *database.User) error {
+	h.stateManager.SetUserState(user.TelegramID, state.WaitingForQuietHours)
+
+	text := "Введите тихие часы через дефис, например: 23:00-07:00\n" +
+		"В это время (по вашему часовому поясу) напоминание о базальном инсулине придет после окончания тихих часов, " +
+		"если с его времени прошло не больше двух часов, а остальные сообщения придут без звука. " +
+		"Напоминание перепроверить сахар после гипо приходит всегда.\n" +
+		"Отправьте 0, чтобы выключить тихие часы."
+	if user.QuietHoursStart != "" {
+		text = fmt.Sprintf("Сейчас тихие часы: %s–%s\n\n", user.QuietHoursStart, user.QuietHoursEnd) + text
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("◀️ Отмена", "settings"),
+		),
+	)
+	_, err := h.api.Send(msg)
+	return err
+}
+
+// handleQuietHours saves the quiet hours window
+func (h *TextHandler) handleQuietHours(ctx context.Context, message *tgbotapi.Message, user *database.User) error {
+	input := strings.TrimSpace(message.Text)
+
+	var start, end string
+	if input != "0" {
+		m := quietHoursPattern.FindStringSubmatch(input)
+		if m == nil {
+			msg := tgbotapi.NewMessage(message.Chat.ID, "Пожалуйста, введите тихие часы, например: 23:00-07:00")
+			_, err := h.api.Send(msg)
+			return err
+		}
+		var parts [4]int
+		for i := range parts {
+			parts[i], _ = strconv.Atoi(m[i+1])
+		}
+		if parts[0] > 23 || parts[1] > 59 || parts[2] > 23 || parts[3] > 59 {
+			msg := tgbotapi.NewMessage(message.Chat.ID, "Время должно быть от 00:00 до 23:59")
+			_, err := h.api.Send(msg)
+			return err
+		}
+		start = fmt.Sprintf("%02d:%02d", parts[0], parts[1])
+		end = fmt.Sprintf("%02d:%02d", parts[2], parts[3])
+		if start == end {
+			msg := tgbotapi.NewMessage(message.Chat.ID, "Начало и конец тихих часов должны различаться")
+			_, err := h.api.Send(msg)
+			return err
+		}
+	}
+
+	if err := h.deps.UserService.SetQuietHours(ctx, user.ID, start, end); err != nil {
+		logger.Error("Failed to save quiet hours", "user_id", user.ID, "error", err)
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Ошибка при сохранении тихих часов")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+	user.QuietHoursStart = start
+	user.QuietHoursEnd = end
+	h.stateManager.SetUserState(user.TelegramID, state.None)
+
+	text := "✅ Тихие часы выключены"
+	if start != "" {
+		text = fmt.Sprintf("✅ Тихие часы: %s–%s", start, end)
+	}
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	if _, err := h.api.Send(msg); err != nil {
+		return err
+	}
+	return sendSettingsMenu(ctx, h.api, h.deps, message.Chat.ID, user)
+}
@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/vladimiradmaev/diabetes-helper/internal/bot/state"
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/format"
+	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+	"github.com/vladimiradmaev/diabetes-helper/internal/services"
+)
+
+// maxRatioChangeAhead is how far ahead a ratio change can be scheduled
+const maxRatioChangeAhead = 366 * 24 * time.Hour
+
+// ratioChangeDatePattern matches "03.06" or "03.06.2025"
+var ratioChangeDatePattern = regexp.MustCompile(`^(\d{1,2})\.(\d{1,2})(?:\.(\d{4}))?$`)
+
+// ActivateRatioChanges switches in the scheduled ratio changes that are due
+// and tells each user where the previous ratios were saved. Users who paused
+// notifications get the new ratios without the notice.
+func ActivateRatioChanges(ctx context.Context, api *tgbotapi.BotAPI, deps Dependencies) error {
+	activated, err := deps.SnapshotSvc.ActivateRatioChanges(ctx, time.Now())
+	for _, a := range activated {
+		if a.User.NotificationsPaused {
+			continue
+		}
+		var b strings.Builder
+		b.WriteString("🗓 С сегодняшнего дня действуют новые коэффициенты на ХЕ:\n\n")
+		for _, r := range a.Ratios {
+			fmt.Fprintf(&b, "🕒 %s - %s: %.1f ед/ХЕ\n", r.StartTime, r.EndTime, r.Ratio)
+		}
+		fmt.Fprintf(&b, "\nПрежние настройки сохранены как «%s». Вернуть их можно кнопкой ниже или в настройках: «♻️ Восстановить».", a.Snapshot.Name)
+
+		msg := tgbotapi.NewMessage(a.User.TelegramID, b.String())
+		// Changes start at local midnight, so the notice mustn't wake anyone
+		msg.DisableNotification = services.InQuietHours(&a.User, time.Now())
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("♻️ Вернуть прежние", fmt.Sprintf("snapshot_restore_%d", a.Snapshot.ID)),
+			),
+		)
+		if _, sendErr := api.Send(msg); sendErr != nil {
+			logger.Error("Failed to send ratio change notice", "user_id", a.User.ID, "error", sendErr)
+		}
+	}
+	return err
+}
+
+// parseRatioChangeDate parses the activation date of a ratio change and
+// returns the local midnight it starts at. Without a year the next such date
+// is meant. When the input is invalid, problem holds the message to show.
+func parseRatioChangeDate(text string, now time.Time, loc *time.Location) (at time.Time, problem string) {
+	m := ratioChangeDatePattern.FindStringSubmatch(strings.TrimSpace(text))
+	if m == nil {
+		return time.Time{}, "Пожалуйста, введите дату в формате ДД.ММ, например: 03.06"
+	}
+	day, _ := strconv.Atoi(m[1])
+	month, _ := strconv.Atoi(m[2])
+	local := now.In(loc)
+	year := local.Year()
+	if m[3] != "" {
+		year, _ = strconv.Atoi(m[3])
+	}
+
+	at = time.Date(year, time.Month(month), day, 0, 0, 0, 0, loc)
+	if at.Day() != day || int(at.Month()) != month {
+		return time.Time{}, "Такой даты нет. Введите дату в формате ДД.ММ, например: 03.06"
+	}
+	if m[3] == "" && !at.After(local) {
+		at = at.AddDate(1, 0, 0)
+	}
+	if !at.After(local) {
+		return time.Time{}, "Дата должна быть не раньше завтрашнего дня"
+	}
+	if at.Sub(local) > maxRatioChangeAhead {
+		return time.Time{}, "Изменение можно запланировать не больше чем на год вперед"
+	}
+	return at, ""
+}
+
+// handleScheduleRatioChange asks for the date new ratios should start at
+func (h *CallbackHandler) handleScheduleRatioChange(chatID int64, user *database.User) error {
+	h.stateManager.SetUserState(user.TelegramID, state.WaitingForRatioChangeDate)
+	h.stateManager.ClearTempData(user.TelegramID)
+
+	text := "🗓 С какого дня должны действовать новые коэффициенты? Введите дату в формате ДД.ММ, например: 03.06\n" +
+		"Смена произойдет в полночь по вашему часовому поясу, текущие настройки будут сохранены для отката."
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("◀️ Отмена", "insulin_ratio"),
+		),
+	)
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyMarkup = keyboard
+	_, err := h.api.Send(msg)
+	return err
+}
+
+// handleRatioChange shows the scheduled ratio change with a button to cancel it
+func (h *CallbackHandler) handleRatioChange(chatID int64, user *database.User) error {
+	ratios, err := services.DecodeRatioChange(user)
+	if err != nil {
+		logger.Error("Failed to decode ratio change", "user_id", user.ID, "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при получении запланированных коэффициентов")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+	if user.RatioChangeAt == nil || len(ratios) == 0 {
+		msg := tgbotapi.NewMessage(chatID, "Изменений коэффициентов не запланировано")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "🗓 С %s будут действовать коэффициенты:\n\n", format.Date(*user.RatioChangeAt, services.UserLocation(user), format.Default))
+	for _, r := range ratios {
+		fmt.Fprintf(&b, "🕒 %s - %s: %.1f ед/ХЕ\n", r.StartTime, r.EndTime, r.Ratio)
+	}
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("❌ Отменить изменение", "ratio_change_cancel"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("◀️ Назад", "settings"),
+		),
+	)
+	msg := tgbotapi.NewMessage(chatID, b.String())
+	msg.ReplyMarkup = keyboard
+	_, err = h.api.Send(msg)
+	return err
+}
+
+// handleRatioChangeCancel drops the scheduled ratio change
+func (h *CallbackHandler) handleRatioChangeCancel(ctx context.Context, chatID int64, user *database.User) error {
+	if err := h.deps.SnapshotSvc.CancelRatioChange(ctx, user.ID); err != nil {
+		logger.Error("Failed to cancel ratio change", "user_id", user.ID, "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при отмене изменения")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+	user.RatioChange = ""
+	user.RatioChangeAt = nil
+
+	if _, err := h.api.Send(tgbotapi.NewMessage(chatID, "✅ Запланированное изменение коэффициентов отменено")); err != nil {
+		return err
+	}
+	return sendSettingsMenu(ctx, h.api, h.deps, chatID, user)
+}
+
+// handleRatioChangeDate saves the activation date and asks for the new ratios
+func (h *TextHandler) handleRatioChangeDate(message *tgbotapi.Message, user *database.User) error {
+	at, problem := parseRatioChangeDate(message.Text, time.Now(), services.UserLocation(user))
+	if problem != "" {
+		msg := tgbotapi.NewMessage(message.Chat.ID, problem)
+		_, err := h.api.Send(msg)
+		return err
+	}
+
+	h.stateManager.SetTempData(user.TelegramID, "ratioChangeDate", at.Format(time.RFC3339))
+	h.stateManager.SetUserState(user.TelegramID, state.WaitingForRatioChangeSchedule)
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("◀️ Отмена", "insulin_ratio"),
+		),
+	)
+	msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("Коэффициенты, которые начнут действовать с %s.\n\n", format.Date(at, at.Location(), format.Default))+ratioImportPrompt)
+	msg.ReplyMarkup = keyboard
+	_, err := h.api.Send(msg)
+	return err
+}
+
+// handleRatioChangeSchedule schedules the pasted ratios for the chosen date
+func (h *TextHandler) handleRatioChangeSchedule(ctx context.Context, message *tgbotapi.Message, user *database.User) error {
+	dateVal, _ := h.stateManager.GetTempData(user.TelegramID, "ratioChangeDate")
+	dateStr, _ := dateVal.(string)
+	at, err := time.Parse(time.RFC3339, dateStr)
+	if err != nil {
+		h.stateManager.SetUserState(user.TelegramID, state.None)
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Дата изменения не найдена. Пожалуйста, начните заново.")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+
+	ratios, guessed, problem := parseRatioSchedule(message.Text)
+	if problem != "" {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "⚠️ "+problem+"\n\nИсправьте и отправьте список еще раз.")
+		_, err := h.api.Send(msg)
+		return err
+	}
+
+	if err := h.deps.SnapshotSvc.ScheduleRatioChange(ctx, user.ID, ratios, at); err != nil {
+		logger.Error("Failed to schedule ratio change", "user_id", user.ID, "error", err)
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Ошибка при сохранении изменения")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+	user.RatioChangeAt = &at
+
+	h.stateManager.ClearTempData(user.TelegramID)
+	h.stateManager.SetUserState(user.TelegramID, state.None)
+
+	text := fmt.Sprintf("✅ Запланировано: с %s будут действовать новые коэффициенты (периодов: %d). "+
+		"Отменить изменение можно в настройках.", format.Date(at, services.UserLocation(user), format.Default), len(ratios))
+	if guessed {
+		// Scheduled right away, so the periods as understood are shown after
+		text += "\n\n🕒 Понял периоды так:\n"
+		for _, r := range ratios {
+			text += fmt.Sprintf("%s - %s: %.1f ед/ХЕ\n", r.StartTime, r.EndTime, r.Ratio)
+		}
+	}
+	if _, err := h.api.Send(tgbotapi.NewMessage(message.Chat.ID, text)); err != nil {
+		return err
+	}
+	return sendSettingsMenu(ctx, h.api, h.deps, message.Chat.ID, user)
+}
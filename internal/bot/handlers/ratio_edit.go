@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/vladimiradmaev/diabetes-helper/internal/bot/menus"
+	"github.com/vladimiradmaev/diabetes-helper/internal/bot/state"
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+	"github.com/vladimiradmaev/diabetes-helper/internal/services"
+)
+
+// ratioEditPrompt explains the input of an edited period
+const ratioEditPrompt = "Введите новый период и коэффициент в формате ЧЧ:ММ-ЧЧ:ММ коэффициент, например 06:00-11:00 1.5\n\n" +
+	"Соседние периоды подстроятся под новые границы."
+
+// handleEditInsulinRatio lists the ratio periods to choose the one to edit
+func (h *CallbackHandler) handleEditInsulinRatio(chatID int64, user *database.User) error {
+	ratios, err := h.deps.InsulinSvc.GetUserRatios(context.Background(), user.ID)
+	if err != nil {
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при получении коэффициентов")
+		_, err := h.api.Send(msg)
+		return err
+	}
+
+	if len(ratios) == 0 {
+		msg := tgbotapi.NewMessage(chatID, "Нет сохраненных коэффициентов для редактирования")
+		_, err := h.api.Send(msg)
+		return err
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, r := range ratios {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("✏️ %s-%s: %.1f ед/ХЕ", r.StartTime, r.EndTime, r.Ratio),
+				fmt.Sprintf("edit_ratio_%d", r.ID)),
+		))
+	}
+	rows = append(rows,
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🗑 Удалить все и ввести заново", "clear_and_add_ratio"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("◀️ Назад", "insulin_ratio"),
+		),
+	)
+
+	msg := tgbotapi.NewMessage(chatID, "Выберите период, который нужно изменить:")
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	_, err = h.api.Send(msg)
+	return err
+}
+
+// handleEditRatio asks for the new bounds and ratio of the chosen period
+func (h *CallbackHandler) handleEditRatio(chatID int64, idStr string, user *database.User) error {
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		return h.handleUnknownCallback(chatID)
+	}
+
+	h.stateManager.ClearTempData(user.TelegramID)
+	h.stateManager.SetTempData(user.TelegramID, "ratioEditID", float64(id))
+	h.stateManager.SetUserState(user.TelegramID, state.WaitingForRatioEdit)
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("◀️ Отмена", "insulin_ratio"),
+		),
+	)
+	msg := tgbotapi.NewMessage(chatID, ratioEditPrompt)
+	msg.ReplyMarkup = keyboard
+	return sendPrompt(h.api, h.stateManager, user, msg)
+}
+
+// planRatioEdit parses the entered period and computes the changes it makes
+// to the user's schedule. entered is the period as understood, guessed
+// reports that it should be shown to the user. When it can't be applied,
+// problem holds the message to show the user.
+func planRatioEdit(ctx context.Context, deps Dependencies, stateManager state.StateManager, user *database.User, text string) (plan *services.RatioEditPlan, entered database.InsulinRatio, guessed bool, problem string, err error) {
+	idVal, _ := stateManager.GetTempData(user.TelegramID, "ratioEditID")
+	id, ok := idVal.(float64)
+	if !ok || id <= 0 {
+		return nil, entered, false, "Период не найден. Пожалуйста, выберите его еще раз.", nil
+	}
+
+	parsed, guessed, problem := parseRatioSchedule(text)
+	if problem != "" {
+		return nil, entered, false, problem, nil
+	}
+	if len(parsed) != 1 {
+		return nil, entered, false, "Введите один период. " + ratioEditPrompt, nil
+	}
+	entered = parsed[0]
+
+	ratios, err := deps.InsulinSvc.GetUserRatios(ctx, user.ID)
+	if err != nil {
+		return nil, entered, false, "", err
+	}
+	plan, err = services.PlanRatioEdit(ratios, uint(id), entered.StartTime, entered.EndTime, entered.Ratio)
+	switch {
+	case errors.Is(err, services.ErrRatioEditSplitsPeriod):
+		return nil, entered, false, "Новый период попадает внутрь другого периода. Сначала измените тот период.", nil
+	case err != nil:
+		return nil, entered, false, fmt.Sprintf("Не получается изменить период: %v", err), nil
+	}
+	return plan, entered, guessed, "", nil
+}
+
+// handleRatioEdit shows all changes an edited period makes before saving them
+func (h *TextHandler) handleRatioEdit(ctx context.Context, message *tgbotapi.Message, user *database.User) error {
+	trackPrompt(h.stateManager, user, message.MessageID)
+
+	plan, entered, guessed, problem, err := planRatioEdit(ctx, h.deps, h.stateManager, user, message.Text)
+	if err != nil {
+		logger.Error("Failed to plan ratio edit", "user_id", user.ID, "error", err)
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Ошибка при получении коэффициентов")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+	if problem != "" {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "⚠️ "+problem)
+		return sendPrompt(h.api, h.stateManager, user, msg)
+	}
+
+	// The confirmation plans the edit again from this text, so it applies to
+	// the ratios as they are at that point
+	h.stateManager.SetTempData(user.TelegramID, "ratioEdit", message.Text)
+
+	var b strings.Builder
+	if guessed {
+		b.WriteString(periodConfirmation(entered.StartTime, entered.EndTime))
+	}
+	b.WriteString("📋 Изменения:\n\n")
+	for _, r := range plan.Updates {
+		fmt.Fprintf(&b, "✏️ %s - %s: %.1f ед/ХЕ", r.StartTime, r.EndTime, r.Ratio)
+		if h.deps.InsulinSvc.CheckRatio(r.Ratio) != services.RatioTypical {
+			b.WriteString(" ⚠️ необычное значение")
+		}
+		b.WriteString("\n")
+	}
+	for _, r := range plan.Deletes {
+		fmt.Fprintf(&b, "🗑 %s - %s: %.1f ед/ХЕ — будет удален\n", r.StartTime, r.EndTime, r.Ratio)
+	}
+	b.WriteString("\nСохранить изменения?")
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✅ Сохранить", "confirm_changes"),
+			tgbotapi.NewInlineKeyboardButtonData("◀️ Отмена", "insulin_ratio"),
+		),
+	)
+	msg := tgbotapi.NewMessage(message.Chat.ID, b.String())
+	msg.ReplyMarkup = keyboard
+	return sendPrompt(h.api, h.stateManager, user, msg)
+}
+
+// handleConfirmChanges applies all changes of the previewed edit in one transaction
+func (h *CallbackHandler) handleConfirmChanges(ctx context.Context, chatID int64, user *database.User) error {
+	textVal, _ := h.stateManager.GetTempData(user.TelegramID, "ratioEdit")
+	text, _ := textVal.(string)
+	if text == "" {
+		msg := tgbotapi.NewMessage(chatID, "Изменения не найдены. Пожалуйста, измените период еще раз.")
+		_, err := h.api.Send(msg)
+		return err
+	}
+
+	plan, _, _, problem, err := planRatioEdit(ctx, h.deps, h.stateManager, user, text)
+	if err == nil && problem == "" {
+		err = h.deps.InsulinSvc.ApplyChanges(ctx, user.ID, plan.Updates, plan.DeleteIDs())
+	}
+	if err != nil {
+		logger.Error("Failed to apply ratio changes", "user_id", user.ID, "error", err)
+		problem = "Ошибка при сохранении коэффициентов"
+	}
+	if problem != "" {
+		msg := tgbotapi.NewMessage(chatID, "⚠️ "+problem)
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+
+	clearPrompts(h.api, h.stateManager, chatID, user)
+	h.stateManager.ClearTempData(user.TelegramID)
+	h.stateManager.SetUserState(user.TelegramID, state.None)
+
+	if _, err := h.api.Send(tgbotapi.NewMessage(chatID, "✅ Изменения сохранены")); err != nil {
+		return err
+	}
+	ratios, err := h.deps.InsulinSvc.GetUserRatios(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	return menus.SendInsulinRatioMenu(h.api, chatID, ratios)
+}
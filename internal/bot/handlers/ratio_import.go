@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/vladimiradmaev/diabetes-helper/internal/bot/menus"
+	"github.com/vladimiradmaev/diabetes-helper/internal/bot/state"
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+	"github.com/vladimiradmaev/diabetes-helper/internal/services"
+	"github.com/vladimiradmaev/diabetes-helper/internal/utils"
+)
+
+// ratioImportPrompt explains the format of a pasted ratio schedule
+const ratioImportPrompt = "Отправьте все периоды одним сообщением, через точку с запятой или каждый с новой строки:\n\n" +
+	"00:00-06:00 0.8; 06:00-11:00 1.5; 11:00-17:00 1.2; 17:00-24:00 1.0"
+
+// ratioLinePattern splits one entry into the period, parsed by parsePeriod,
+// and the ratio, which may be followed by a unit such as "ед/ХЕ"
+var ratioLinePattern = regexp.MustCompile(`^(.+?)(?:\s*[:=]\s*|\s+)(\d+(?:[.,]\d+)?)\s*(?:ед.*)?$`)
+
+// parseRatioSchedule parses a pasted ratio schedule and validates the periods
+// together. Entries are separated by ";" or new lines; periods are read like
+// single ones by parsePeriod and commas are accepted as decimal separators.
+// guessed reports that a period wasn't typed as "HH:MM-HH:MM", so the
+// periods should be shown to the user. When the input is invalid, problem
+// holds the message to show the user.
+func parseRatioSchedule(text string) (ratios []database.InsulinRatio, guessed bool, problem string) {
+	entries := strings.FieldsFunc(text, func(r rune) bool { return r == ';' || r == '\n' })
+
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		m := ratioLinePattern.FindStringSubmatch(normalizePeriod(entry))
+		if m == nil {
+			return nil, false, fmt.Sprintf("Не понял период «%s». Нужен формат ЧЧ:ММ-ЧЧ:ММ коэффициент, например 06:00-11:00 1.5", entry)
+		}
+
+		startTime, endTime, entryGuessed, problem := parsePeriod(m[1])
+		if problem != "" {
+			return nil, false, fmt.Sprintf("В «%s»: %s", entry, problem)
+		}
+		guessed = guessed || entryGuessed
+		ratio, err := strconv.ParseFloat(strings.ReplaceAll(m[2], ",", "."), 64)
+		if err != nil || ratio <= 0 {
+			return nil, false, fmt.Sprintf("Коэффициент в «%s» должен быть больше 0", entry)
+		}
+
+		for _, r := range ratios {
+			if utils.PeriodsOverlap(startTime, endTime, r.StartTime, r.EndTime) {
+				return nil, false, fmt.Sprintf("Периоды %s-%s и %s-%s пересекаются", r.StartTime, r.EndTime, startTime, endTime)
+			}
+		}
+		ratios = append(ratios, database.InsulinRatio{StartTime: startTime, EndTime: endTime, Ratio: ratio})
+	}
+
+	if len(ratios) == 0 {
+		return nil, false, "Не нашел ни одного периода. " + ratioImportPrompt
+	}
+
+	total := 0
+	for _, r := range ratios {
+		total += utils.PeriodMinutes(r.StartTime, r.EndTime)
+	}
+	if total > 24*60 {
+		return nil, false, "Периоды в сумме дают больше 24 часов"
+	}
+	return ratios, guessed, ""
+}
+
+// formatClock normalizes hours and minutes to "HH:MM". When end is true,
+// 24:00 is accepted and stored as 00:00, which periods treat as midnight.
+func formatClock(hours, minutes string, end bool) (string, bool) {
+	h, _ := strconv.Atoi(hours)
+	m, _ := strconv.Atoi(minutes)
+	if m > 59 {
+		return "", false
+	}
+	if end && h == 24 && m == 0 {
+		h = 0
+	}
+	if h > 23 {
+		return "", false
+	}
+	return fmt.Sprintf("%02d:%02d", h, m), true
+}
+
+// looksLikeRatioSchedule reports whether a period input contains several periods
+func looksLikeRatioSchedule(text string) bool {
+	return strings.ContainsAny(strings.TrimSpace(text), ";\n")
+}
+
+// handleRatioImport starts importing a whole ratio schedule from one message
+func (h *CallbackHandler) handleRatioImport(chatID int64, user *database.User) error {
+	h.stateManager.SetUserState(user.TelegramID, state.WaitingForRatioImport)
+	h.stateManager.ClearTempData(user.TelegramID)
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("◀️ Отмена", "insulin_ratio"),
+		),
+	)
+	msg := tgbotapi.NewMessage(chatID, ratioImportPrompt)
+	msg.ReplyMarkup = keyboard
+	_, err := h.api.Send(msg)
+	return err
+}
+
+// handleRatioImport parses a pasted schedule and shows a preview before saving
+func (h *TextHandler) handleRatioImport(message *tgbotapi.Message, user *database.User) error {
+	ratios, guessed, problem := parseRatioSchedule(message.Text)
+	if problem != "" {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "⚠️ "+problem+"\n\nИсправьте и отправьте список еще раз.")
+		_, err := h.api.Send(msg)
+		return err
+	}
+
+	// The preview's button saves the text, which is parsed again at that point
+	h.stateManager.SetTempData(user.TelegramID, "ratioImport", message.Text)
+	h.stateManager.SetUserState(user.TelegramID, state.WaitingForRatioImport)
+
+	text := "📋 Проверьте коэффициенты:\n\n"
+	if guessed {
+		text = periodsConfirmation + text
+	}
+	total := 0
+	for _, r := range ratios {
+		text += fmt.Sprintf("🕒 %s - %s: %.1f ед/ХЕ", r.StartTime, r.EndTime, r.Ratio)
+		if h.deps.InsulinSvc.CheckRatio(r.Ratio) != services.RatioTypical {
+			text += " ⚠️ необычное значение"
+		}
+		text += "\n"
+		total += utils.PeriodMinutes(r.StartTime, r.EndTime)
+	}
+	if total < 24*60 {
+		text += fmt.Sprintf("\n⚠️ Периоды покрывают только %.1f часов из 24\n", float64(total)/60)
+	} else {
+		text += "\n✅ Периоды полностью покрывают 24 часа\n"
+	}
+	text += "\nСохранить? Текущие коэффициенты будут заменены."
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✅ Сохранить", "ratio_import_save"),
+			tgbotapi.NewInlineKeyboardButtonData("◀️ Отмена", "insulin_ratio"),
+		),
+	)
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	msg.ReplyMarkup = keyboard
+	_, err := h.api.Send(msg)
+	return err
+}
+
+// handleRatioImportSave replaces the user's ratios with the previewed schedule
+func (h *CallbackHandler) handleRatioImportSave(ctx context.Context, chatID int64, user *database.User) error {
+	textVal, _ := h.stateManager.GetTempData(user.TelegramID, "ratioImport")
+	text, _ := textVal.(string)
+	ratios, _, problem := parseRatioSchedule(text)
+	if text == "" || problem != "" {
+		msg := tgbotapi.NewMessage(chatID, "Список коэффициентов не найден. Пожалуйста, отправьте его еще раз.")
+		_, err := h.api.Send(msg)
+		return err
+	}
+
+	if err := h.deps.InsulinSvc.ReplaceRatios(ctx, user.ID, ratios); err != nil {
+		logger.Error("Failed to import ratios", "user_id", user.ID, "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при сохранении коэффициентов")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+
+	h.stateManager.ClearTempData(user.TelegramID)
+	h.stateManager.SetUserState(user.TelegramID, state.None)
+
+	if _, err := h.api.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("✅ Сохранено периодов: %d", len(ratios)))); err != nil {
+		return err
+	}
+	saved, err := h.deps.InsulinSvc.GetUserRatios(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	return menus.SendInsulinRatioMenu(h.api, chatID, saved)
+}
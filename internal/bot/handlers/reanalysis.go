@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/format"
+	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+	"github.com/vladimiradmaev/diabetes-helper/internal/services"
+)
+
+// withReanalysisButton adds the button that repeats a fallback-model analysis
+// on the primary model on top of a result keyboard
+func withReanalysisButton(keyboard tgbotapi.InlineKeyboardMarkup, analysisID uint) tgbotapi.InlineKeyboardMarkup {
+	row := tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("🔁 Повторить на основной модели позже", fmt.Sprintf("reanalyze_primary_%d", analysisID)),
+	)
+	keyboard.InlineKeyboard = append([][]tgbotapi.InlineKeyboardButton{row}, keyboard.InlineKeyboard...)
+	return keyboard
+}
+
+// handleReanalyzePrimary queues a repeat of the analysis on the primary model.
+// The job runs in the background, so this only queues it and replies right away.
+func (h *CallbackHandler) handleReanalyzePrimary(ctx context.Context, chatID int64, idStr string, user *database.User) error {
+	id, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		return h.handleUnknownCallback(chatID)
+	}
+
+	_, created, err := h.deps.JobSvc.EnqueueReanalysis(ctx, user.ID, chatID, uint(id))
+	if err != nil {
+		logger.Error("Failed to enqueue re-analysis", "user_id", user.ID, "analysis_id", id, "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Не удалось запланировать повторный анализ. Попробуйте позже")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+	text := "⏳ Повторю анализ на основной модели, когда она будет доступна, и пришлю сравнение"
+	if !created {
+		text = "⏳ Повторный анализ уже запланирован, пришлю сравнение, как только он будет готов"
+	}
+	_, err = h.api.Send(tgbotapi.NewMessage(chatID, text))
+	return err
+}
+
+// runReanalysis repeats an analysis on the primary model and sends the
+// comparison. While the primary model is unavailable the job is retried later;
+// the user is told when it gives up.
+func runReanalysis(ctx context.Context, api *tgbotapi.BotAPI, deps Dependencies, job *database.Job) {
+	err := reanalyze(ctx, api, deps, job)
+	if err == nil || ctx.Err() != nil {
+		return
+	}
+
+	logger.Warn("Re-analysis on the primary model failed", "job_id", job.ID, "attempt", job.Attempts, "error", err)
+	retry, failErr := deps.JobSvc.Fail(ctx, job, err)
+	if failErr != nil {
+		logger.Error("Failed to record job failure", "job_id", job.ID, "error", failErr)
+		return
+	}
+	if !retry {
+		msg := tgbotapi.NewMessage(job.ChatID, "❌ Основная модель так и не стала доступна, повторный анализ отменен. "+
+			"Результат резервной модели остается в силе")
+		if _, err := api.Send(msg); err != nil {
+			logger.Warn("Failed to send re-analysis failure", "job_id", job.ID, "error", err)
+		}
+	}
+}
+
+func reanalyze(ctx context.Context, api *tgbotapi.BotAPI, deps Dependencies, job *database.Job) error {
+	analysisID, err := services.ReanalysisID(job)
+	if err != nil {
+		return err
+	}
+	analysis, err := deps.FoodAnalysisSvc.GetAnalysis(ctx, job.UserID, analysisID)
+	if err != nil {
+		return err
+	}
+	if analysis.PhotoFileID == "" {
+		return fmt.Errorf("analysis %d has no photo to analyze again", analysisID)
+	}
+
+	file, err := api.GetFile(tgbotapi.FileConfig{FileID: analysis.PhotoFileID})
+	if err != nil {
+		return fmt.Errorf("failed to get file: %w", err)
+	}
+	primary, err := deps.FoodAnalysisSvc.ReanalyzeOnPrimary(ctx, job.UserID, analysisID, file.Link(api.Token))
+	if err != nil {
+		return err
+	}
+
+	msg := tgbotapi.NewMessage(job.ChatID, reanalysisText(analysis, primary))
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✏️ Исправить углеводы", fmt.Sprintf("correct_analysis_%d", analysis.ID)),
+		),
+	)
+	if _, err := api.Send(msg); err != nil {
+		return fmt.Errorf("failed to send re-analysis: %w", err)
+	}
+	return deps.JobSvc.MarkDelivered(ctx, job)
+}
+
+// reanalysisText compares the saved fallback-model analysis with the repeat
+// on the primary model
+func reanalysisText(analysis *database.FoodAnalysis, primary *services.PrimaryReanalysis) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "🔁 Повторный анализ от %s на основной модели %s\n\n",
+		format.DateTime(analysis.CreatedAt, primary.Loc, format.Default), services.ProviderName(services.PrimaryProvider))
+	fmt.Fprintf(&b, "%s: %s, %s ХЕ\n", services.ProviderName(analysis.UsedProvider),
+		format.Grams(analysis.Carbs, 1, format.Default), format.BreadUnitsNumber(analysis.BreadUnits, 0, format.Default))
+	fmt.Fprintf(&b, "%s: %s, %s ХЕ\n\n", services.ProviderName(services.PrimaryProvider),
+		format.Grams(primary.Carbs, 1, format.Default), format.BreadUnitsNumber(primary.BreadUnits, 0, format.Default))
+
+	if diff := primary.Carbs - analysis.Carbs; diff == 0 {
+		b.WriteString("Результаты совпадают, дозу пересчитывать не нужно.")
+	} else {
+		fmt.Fprintf(&b, "Разница: %+.1f г углеводов. Если вы согласны с основной моделью, исправьте углеводы в сохраненном анализе.", diff)
+	}
+	return b.String()
+}
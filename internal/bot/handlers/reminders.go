@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+	"github.com/vladimiradmaev/diabetes-helper/internal/services"
+)
+
+// reminderRetryDelay is when a reminder that couldn't be sent is tried again
+const reminderRetryDelay = time.Minute
+
+// reminderDigestWindow is how far ahead the other reminders of a user with a
+// reminder due now are pulled in, so that reminders a minute or two apart
+// arrive as one message rather than several pings
+const reminderDigestWindow = 2 * time.Minute
+
+// reminder is one reminder of a user. Its button works the same whether the
+// reminder is sent alone or in a digest, so confirming one item of a digest
+// leaves the others open.
+type reminder struct {
+	text        string // Sent when the reminder is alone
+	digestLine  string // Its line in a digest
+	label       string // Button label when alone
+	digestLabel string // Button label in a digest, naming what it confirms
+	data        string // Button callback data
+
+	// release puts the claimed reminder back when it couldn't be sent, so it
+	// is sent later instead of being lost
+	release func(ctx context.Context) error
+}
+
+// SendReminders sends the basal reminders and low rechecks that are due.
+// A user with several due within reminderDigestWindow gets them as one
+// digest with a button per reminder.
+func SendReminders(ctx context.Context, api *tgbotapi.BotAPI, deps Dependencies) error {
+	now := time.Now()
+	var errs []error
+
+	basal, err := deps.InjectionSvc.DueBasalReminders(ctx, now, nil)
+	errs = append(errs, err)
+	lows, err := deps.UserService.DueLowRechecks(ctx, now, nil)
+	errs = append(errs, err)
+
+	hasBasal := make(map[uint]bool)
+	for _, d := range basal {
+		hasBasal[d.User.ID] = true
+	}
+	hasLow := make(map[uint]bool)
+	for _, u := range lows {
+		hasLow[u.ID] = true
+	}
+
+	// Pull in the other kind of reminder if it is due shortly anyway
+	var onlyLow, onlyBasal []uint
+	for _, u := range lows {
+		if !hasBasal[u.ID] {
+			onlyLow = append(onlyLow, u.ID)
+		}
+	}
+	for _, d := range basal {
+		if !hasLow[d.User.ID] {
+			onlyBasal = append(onlyBasal, d.User.ID)
+		}
+	}
+	if len(onlyLow) > 0 {
+		early, err := deps.InjectionSvc.DueBasalReminders(ctx, now.Add(reminderDigestWindow), onlyLow)
+		errs = append(errs, err)
+		basal = append(basal, early...)
+	}
+	if len(onlyBasal) > 0 {
+		early, err := deps.UserService.DueLowRechecks(ctx, now.Add(reminderDigestWindow), onlyBasal)
+		errs = append(errs, err)
+		lows = append(lows, early...)
+	}
+
+	users := make(map[uint]database.User)
+	pending := make(map[uint][]reminder)
+	for _, u := range lows {
+		users[u.ID] = u
+		item := lowRecheckReminder()
+		userID := u.ID
+		item.release = func(ctx context.Context) error {
+			return deps.UserService.RestoreLowRecheck(ctx, userID, time.Now().Add(reminderRetryDelay))
+		}
+		pending[u.ID] = append(pending[u.ID], item)
+	}
+	for _, d := range basal {
+		users[d.User.ID] = d.User
+		item := basalReminder(d)
+		reminderID := d.Reminder.ID
+		item.release = func(ctx context.Context) error {
+			return deps.InjectionSvc.ReleaseBasalReminder(ctx, reminderID)
+		}
+		pending[d.User.ID] = append(pending[d.User.ID], item)
+	}
+
+	for userID, items := range pending {
+		user := users[userID]
+		sendErr := sendReminders(api, user.TelegramID, items)
+		if sendErr == nil {
+			continue
+		}
+		if undeliverable(sendErr) {
+			logger.Warn("Reminders can't be delivered, dropped", "user_id", user.ID, "count", len(items), "error", sendErr)
+			continue
+		}
+		logger.Error("Failed to send reminders, will retry", "user_id", user.ID, "count", len(items), "error", sendErr)
+		for _, item := range items {
+			if err := item.release(ctx); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// undeliverable reports whether Telegram refused a message for good, e.g.
+// because the user blocked the bot, so retrying it is pointless
+func undeliverable(err error) bool {
+	var apiErr *tgbotapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == 403
+}
+
+// sendReminders sends one reminder as it is, or several as a digest
+func sendReminders(api *tgbotapi.BotAPI, chatID int64, items []reminder) error {
+	if len(items) == 1 {
+		msg := tgbotapi.NewMessage(chatID, items[0].text)
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(items[0].label, items[0].data),
+			),
+		)
+		_, err := api.Send(msg)
+		return err
+	}
+
+	var b strings.Builder
+	b.WriteString("⏰ Напоминания:\n")
+	rows := make([][]tgbotapi.InlineKeyboardButton, 0, len(items))
+	for _, item := range items {
+		b.WriteString("\n• " + item.digestLine)
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(item.digestLabel, item.data),
+		))
+	}
+	msg := tgbotapi.NewMessage(chatID, b.String())
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	_, err := api.Send(msg)
+	return err
+}
+
+// basalReminder is the daily basal insulin reminder; its button logs the
+// injection
+func basalReminder(d services.DueBasalReminder) reminder {
+	return reminder{
+		text: fmt.Sprintf("⏰ Время базального инсулина: %.1f ед.\n\nНажмите «✅ Принял», когда сделаете укол, и он будет записан.",
+			d.User.BasalReminderUnits.Units()),
+		digestLine:  fmt.Sprintf("Базальный инсулин: %.1f ед. Нажмите «✅ Принял базальный», когда сделаете укол.", d.User.BasalReminderUnits.Units()),
+		label:       "✅ Принял",
+		digestLabel: "✅ Принял базальный",
+		data:        fmt.Sprintf("basal_taken_%d", d.Reminder.ID),
+	}
+}
+
+// lowRecheckReminder asks to recheck glucose 15 minutes after treating a low
+func lowRecheckReminder() reminder {
+	return reminder{
+		text: "⏰ Прошло 15 минут после приема углеводов. Проверьте сахар: " +
+			"если он все еще ниже цели, отправьте /low <сахар> еще раз.",
+		digestLine:  "Прошло 15 минут после приема углеводов: проверьте сахар, если он все еще ниже цели, отправьте /low <сахар> еще раз.",
+		label:       "🩸 Записать сахар",
+		digestLabel: "🩸 Записать сахар",
+		data:        "blood_sugar",
+	}
+}
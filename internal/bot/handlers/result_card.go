@@ -0,0 +1,256 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/vladimiradmaev/diabetes-helper/internal/confidence"
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/dosing"
+	"github.com/vladimiradmaev/diabetes-helper/internal/format"
+	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+	"github.com/vladimiradmaev/diabetes-helper/internal/services"
+)
+
+// resultCard is the data of the caption sent with an analyzed photo. It is
+// rendered with Markdown, or as plain text when Telegram rejects the Markdown.
+type resultCard struct {
+	Analysis      *database.FoodAnalysis
+	Compact       bool
+	BreadUnitStep float64 // Display rounding of bread units
+	GlucoseUnit   string  // Unit of the glucose the correction is based on
+	EnteredWeight float64 // Weight from the caption, 0 when the AI estimated it
+	// AnalysisText replaces the AI breakdown, e.g. when the breakdown is sent
+	// in a separate message; empty shows the breakdown itself
+	AnalysisText string
+	Progress     string // Carbs eaten today, empty without a daily target
+	Timing       string // When to inject, empty when there is no advice
+	// Hypo leads the card when glucose is too low to inject, empty otherwise
+	Hypo string
+	// Replay marks a stored analysis sent again, e.g. by /last: the header
+	// shows when it was made and the weight isn't labeled entered or estimated
+	Replay bool
+	Loc    *time.Location // Timezone of the replay header
+}
+
+// markdown renders the card for ParseMode "Markdown"
+func (c resultCard) markdown() string {
+	return c.render(func(s string) string { return "*" + s + "*" }, escapeMarkdown)
+}
+
+// plain renders the same card without any markup
+func (c resultCard) plain() string {
+	return c.render(func(s string) string { return s }, func(s string) string { return s })
+}
+
+// render builds the card; bold marks labels and escape is applied to text
+// that comes from the AI
+func (c resultCard) render(bold, escape func(string) string) string {
+	a := c.Analysis
+	var b strings.Builder
+
+	if c.Hypo != "" {
+		b.WriteString(c.Hypo + "\n\n")
+	}
+	if c.Replay {
+		fmt.Fprintf(&b, "🔁 Результат от %s\n", format.DateTime(a.CreatedAt, c.Loc, format.Default))
+	}
+	fmt.Fprintf(&b, "🍽️ %s\n\n", bold("Анализ блюда"))
+	if services.IsFallbackProvider(a.UsedProvider) {
+		// Fallback models differ in style and accuracy, so users should know
+		fmt.Fprintf(&b, "ℹ️ Анализ выполнен резервной моделью %s\n\n", services.ProviderName(a.UsedProvider))
+	}
+	carbDecimals := 1
+	if a.RawCarbs > 0 {
+		carbDecimals = 0 // Rounded to the user's carbs step
+	}
+	fmt.Fprintf(&b, "🍞 %s %s\n", bold("Углеводы:"), format.Grams(a.Carbs, carbDecimals, format.Default))
+	fmt.Fprintf(&b, "🥖 %s %s\n", bold("ХЕ:"), format.BreadUnitsNumber(a.BreadUnits, c.BreadUnitStep, format.Default))
+
+	if c.Compact {
+		if a.InsulinRatio > 0 {
+			fmt.Fprintf(&b, "💉 %s %.1f ед.", bold("Доза:"), a.InsulinUnits.Units())
+			if text := correctionText(a, c.GlucoseUnit, bold); text != "" {
+				b.WriteString("\n" + text)
+			}
+			if a.ActiveInsulin > 0 {
+				fmt.Fprintf(&b, "\n⏳ %s %.1f ед", bold("Активный инсулин:"), a.ActiveInsulin.Units())
+			}
+		} else {
+			fmt.Fprintf(&b, "💉 %s не настроен коэффициент", bold("Доза:"))
+		}
+	} else {
+		if a.InsulinRatio > 0 {
+			fmt.Fprintf(&b, "💉 %s %.1f ед.\n%s\n", bold("Рекомендуемая доза инсулина:"), a.InsulinUnits.Units(), doseBreakdown(a))
+			if text := correctionText(a, c.GlucoseUnit, bold); text != "" {
+				b.WriteString(text + "\n")
+			}
+			if a.ActiveInsulin > 0 {
+				fmt.Fprintf(&b, "⏳ %s %.1f ед\n", bold("Активный инсулин:"), a.ActiveInsulin.Units())
+			}
+		} else {
+			fmt.Fprintf(&b, "💉 %s не настроен коэффициент для текущего времени\n", bold("Рекомендация по инсулину:"))
+		}
+		fmt.Fprintf(&b, "🎯 %s %s\n", bold("Уверенность:"), confidence.Label(a.Confidence))
+
+		switch {
+		case c.Replay && a.Weight > 0:
+			fmt.Fprintf(&b, "⚖️ %s %s", bold("Вес:"), format.Grams(a.Weight, 1, format.Default))
+		case c.EnteredWeight > 0:
+			fmt.Fprintf(&b, "⚖️ %s %s", bold("Введенный вес:"), format.Grams(c.EnteredWeight, 1, format.Default))
+		case a.Weight > 0:
+			fmt.Fprintf(&b, "⚖️ %s %s", bold("Рассчитанный вес:"), format.Grams(a.Weight, 1, format.Default))
+		default:
+			fmt.Fprintf(&b, "⚖️ %s не указан", bold("Вес:"))
+		}
+
+		analysisText := c.AnalysisText
+		if analysisText == "" {
+			analysisText = escape(a.AnalysisText)
+		}
+		fmt.Fprintf(&b, "\n\n📊 %s\n%s", bold("Как считали:"), analysisText)
+		if a.RawCarbs > 0 {
+			fmt.Fprintf(&b, "\nОценка ИИ до округления: %s", format.Grams(a.RawCarbs, 1, format.Default))
+		}
+	}
+
+	if c.Timing != "" {
+		b.WriteString("\n\n" + c.Timing)
+	}
+	if c.Progress != "" {
+		b.WriteString("\n\n" + c.Progress)
+	}
+	return strings.ToValidUTF8(b.String(), "")
+}
+
+// doseBreakdown shows how the recommended dose of an analysis was computed
+func doseBreakdown(a *database.FoodAnalysis) string {
+	text := fmt.Sprintf("%.1f ХЕ × %.1f ед/ХЕ", a.BreadUnits, a.InsulinRatio)
+	if a.CorrectionUnits > 0 {
+		text += fmt.Sprintf(" + %.1f ед коррекции", a.CorrectionUnits.Units())
+	}
+	if a.ActiveInsulin > 0 {
+		text += fmt.Sprintf(" − %.1f ед активного инсулина", a.ActiveInsulin.Units())
+	}
+	return "(" + text + ")"
+}
+
+// doseText is a meal result sent as a text message rather than the photo
+// card: the header, then the dose labeled doseLabel with how it was computed,
+// then the carbs eaten today. Every text result that shows a dose is built
+// here, so none goes out without the hypo warning: when the recent glucose bg
+// is too low to inject, the warning leads the text and hypo is true, and the
+// caller must not offer to log the injection.
+func doseText(user *database.User, a *database.FoodAnalysis, bg float64, header, doseLabel, progress string) (text string, hypo bool) {
+	var b strings.Builder
+	warning := hypoWarningText(user, bg)
+	if warning != "" {
+		b.WriteString(warning + "\n\n")
+	}
+	b.WriteString(header)
+	if a.InsulinRatio > 0 {
+		fmt.Fprintf(&b, "💉 %s: %.1f ед.\n%s", doseLabel, a.InsulinUnits.Units(), doseBreakdown(a))
+	} else {
+		b.WriteString("💉 Рекомендация по инсулину: не настроен коэффициент для текущего времени")
+	}
+	if progress != "" {
+		b.WriteString("\n\n" + progress)
+	}
+	return b.String(), warning != ""
+}
+
+// correctionText shows the glucose the analysis' correction is based on and
+// the units it adds, or that glucose needs no correction. It is empty when no
+// correction was computed.
+func correctionText(a *database.FoodAnalysis, unit string, bold func(string) string) string {
+	if a.CorrectionGlucose <= 0 {
+		return ""
+	}
+	if a.CorrectionInBand {
+		return fmt.Sprintf("📈 %s %s в пределах целевого диапазона, коррекция не требуется",
+			bold("Сахар:"), formatGlucose(a.CorrectionGlucose, unit))
+	}
+	if a.CorrectionUnits <= 0 {
+		return fmt.Sprintf("📈 %s %s не выше целевого, коррекция не требуется",
+			bold("Сахар:"), formatGlucose(a.CorrectionGlucose, unit))
+	}
+	return fmt.Sprintf("📈 %s +%.1f ед на сахар %s",
+		bold("Коррекция:"), a.CorrectionUnits.Units(), formatGlucose(a.CorrectionGlucose, unit))
+}
+
+// recentGlucoseWindow is how old a glucose record may be to count as the
+// current level for the injection timing hint and the hypo warning, the same
+// as for the dose correction
+const recentGlucoseWindow = services.CurrentGlucoseWindow
+
+// recentGlucose returns the user's latest glucose within recentGlucoseWindow
+// in mmol/L, 0 when there is none
+func recentGlucose(ctx context.Context, deps Dependencies, user *database.User) float64 {
+	record, err := deps.BloodSugarSvc.GetLatestSince(ctx, user.ID, time.Now().Add(-recentGlucoseWindow))
+	if err != nil {
+		logger.Warn("Failed to get recent blood sugar", "user_id", user.ID, "error", err)
+		return 0
+	}
+	if record == nil {
+		return 0
+	}
+	return record.Value
+}
+
+// bolusTimingText advises when to inject the analysis' dose, by the meal's GI
+// and the user's recent glucose bg (0 when unknown). It is empty when the
+// user hid the hint, there is no dose, glucose is too low to inject at all or
+// nothing is known to base the advice on.
+func bolusTimingText(deps Dependencies, user *database.User, a *database.FoodAnalysis, bg float64) string {
+	if user.HideBolusTiming || a.InsulinRatio <= 0 || dosing.BolusUnsafe(bg) {
+		return ""
+	}
+
+	var text string
+	switch dosing.BolusTiming(a.GlycemicIndex, bg, deps.BolusTiming) {
+	case dosing.TimingAfterStart:
+		text = "уколите после начала еды"
+	case dosing.TimingRightBefore:
+		text = "уколите прямо перед едой"
+	case dosing.TimingBefore:
+		text = "уколите за 5–10 минут до еды"
+	case dosing.TimingWellBefore:
+		text = "уколите за 10–15 минут до еды"
+	case dosing.TimingLongBefore:
+		text = "уколите за 15–20 минут до еды"
+	default:
+		return ""
+	}
+	if bg > 0 && (bg < deps.BolusTiming.LowBG || bg >= deps.BolusTiming.HighBG) {
+		return fmt.Sprintf("⏱ Сахар %s — %s", formatGlucose(bg, user.GlucoseUnit), text)
+	}
+	return "⏱ Когда колоть: " + text
+}
+
+// resultKeyboard is shown under an analysis result
+func resultKeyboard(analysisID uint) tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✏️ Исправить углеводы", fmt.Sprintf("correct_analysis_%d", analysisID)),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🏠 Главное меню", "main_menu"),
+			tgbotapi.NewInlineKeyboardButtonData("🔄 Новый анализ", "analyze_food"),
+		),
+	)
+}
+
+// escapeMarkdown escapes the characters that start an entity in Telegram's
+// legacy Markdown
+func escapeMarkdown(s string) string {
+	return strings.NewReplacer(
+		"_", "\\_",
+		"*", "\\*",
+		"[", "\\[",
+		"]", "\\]",
+		"`", "\\`",
+	).Replace(strings.ToValidUTF8(s, ""))
+}
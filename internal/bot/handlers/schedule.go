@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"context"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+)
+
+// sendRatioSchedule sends the user's insulin ratio schedule as an image
+// they can save or show to their doctor
+func sendRatioSchedule(ctx context.Context, api *tgbotapi.BotAPI, deps Dependencies, chatID int64, user *database.User) error {
+	ratios, err := deps.InsulinSvc.GetUserRatios(ctx, user.ID)
+	if err != nil {
+		logger.Error("Failed to get insulin ratios", "user_id", user.ID, "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при получении коэффициентов")
+		_, sendErr := api.Send(msg)
+		return sendErr
+	}
+
+	if len(ratios) == 0 {
+		msg := tgbotapi.NewMessage(chatID, "Коэффициенты на ХЕ ещё не заданы. Добавьте их в настройках: ⚙️ Настройки → 📊 Коэф. на ХЕ")
+		_, err := api.Send(msg)
+		return err
+	}
+
+	image, err := deps.ChartSvc.RenderSchedule(ratios)
+	if err != nil {
+		logger.Error("Failed to render ratio schedule", "user_id", user.ID, "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при создании картинки")
+		_, sendErr := api.Send(msg)
+		return sendErr
+	}
+
+	photo := tgbotapi.NewPhoto(chatID, tgbotapi.FileBytes{Name: "schedule.png", Bytes: image})
+	photo.Caption = "📊 Коэффициенты на ХЕ по времени суток\nСлева период, справа единиц инсулина на 1 ХЕ"
+	_, err = api.Send(photo)
+	return err
+}
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/vladimiradmaev/diabetes-helper/internal/bot/state"
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/dosing"
+	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+	"github.com/vladimiradmaev/diabetes-helper/internal/services"
+	"github.com/vladimiradmaev/diabetes-helper/internal/sharecode"
+)
+
+// handleShareSettings sends the code of the user's dosing setup
+func (h *CallbackHandler) handleShareSettings(ctx context.Context, chatID int64, user *database.User) error {
+	code, err := h.deps.SnapshotSvc.ShareCode(ctx, user.ID)
+	if err != nil {
+		logger.Error("Failed to build share code", "user_id", user.ID, "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при подготовке кода настроек")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+
+	text := "🔗 Код ваших настроек:\n\n`" + code + "`\n\n" +
+		"В нем коэффициенты на ХЕ, базальный профиль, активный инсулин, коррекция, правило купирования гипо и округление. " +
+		"Чтобы перенести их, откройте в другом аккаунте «Настройки» → «📥 Импорт по коду» и отправьте этот код."
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ParseMode = "Markdown"
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("◀️ Назад", "settings"),
+		),
+	)
+	_, err = h.api.Send(msg)
+	return err
+}
+
+// handleShareImport asks for a settings code
+func (h *CallbackHandler) handleShareImport(chatID int64, user *database.User) error {
+	h.stateManager.SetUserState(user.TelegramID, state.WaitingForShareCode)
+	h.stateManager.ClearTempData(user.TelegramID)
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("◀️ Отмена", "settings"),
+		),
+	)
+	msg := tgbotapi.NewMessage(chatID, "Отправьте код настроек, который начинается с "+sharecode.Prefix)
+	msg.ReplyMarkup = keyboard
+	_, err := h.api.Send(msg)
+	return err
+}
+
+// handleShareCode checks a pasted settings code and shows what it contains
+func (h *TextHandler) handleShareCode(message *tgbotapi.Message, user *database.User) error {
+	settings, err := sharecode.Decode(message.Text)
+	if err != nil {
+		text := "⚠️ Код не распознан. Проверьте, что он скопирован целиком, и отправьте еще раз."
+		if errors.Is(err, sharecode.ErrUnsupportedVersion) {
+			text = "⚠️ Код создан более новой версией бота и пока не поддерживается здесь."
+		}
+		msg := tgbotapi.NewMessage(message.Chat.ID, text)
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+
+	// The button applies the saved code, which is decoded again at that point
+	h.stateManager.SetTempData(user.TelegramID, "shareCode", message.Text)
+
+	text := "📋 Настройки из кода:\n\n" + formatSharedSettings(settings) +
+		"\nПрименить? Текущие настройки из кода будут заменены, " +
+		"а прежние сохранены в «♻️ Восстановить»."
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✅ Применить", "share_import_apply"),
+			tgbotapi.NewInlineKeyboardButtonData("◀️ Отмена", "settings"),
+		),
+	)
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	msg.ReplyMarkup = keyboard
+	_, err = h.api.Send(msg)
+	return err
+}
+
+// handleShareImportApply applies the previewed settings code
+func (h *CallbackHandler) handleShareImportApply(ctx context.Context, chatID int64, user *database.User) error {
+	codeVal, _ := h.stateManager.GetTempData(user.TelegramID, "shareCode")
+	code, _ := codeVal.(string)
+	settings, err := sharecode.Decode(code)
+	if err != nil {
+		msg := tgbotapi.NewMessage(chatID, "Код настроек не найден. Пожалуйста, отправьте его еще раз.")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+
+	snapshot, err := h.deps.SnapshotSvc.ApplySharedSettings(ctx, user.ID, settings)
+	if err != nil {
+		logger.Error("Failed to apply shared settings", "user_id", user.ID, "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при применении настроек")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+	user.ActiveInsulinTime = settings.ActiveInsulinTime
+	user.IOBModel = settings.IOBModel
+	if settings.Version >= 2 {
+		user.CorrectionFactor, user.TargetBloodSugar = settings.CorrectionFactor, settings.TargetBloodSugar
+		user.LowRuleGrams, user.LowRuleRise, user.LowTarget = settings.LowRuleGrams, settings.LowRuleRise, settings.LowTarget
+		user.BreadUnitStep, user.CarbsStep = settings.BreadUnitStep, settings.CarbsStep
+	}
+
+	h.stateManager.ClearTempData(user.TelegramID)
+	h.stateManager.SetUserState(user.TelegramID, state.None)
+
+	text := fmt.Sprintf("✅ Настройки применены. Прежние сохранены как «%s».", snapshot.Name)
+	if _, err := h.api.Send(tgbotapi.NewMessage(chatID, text)); err != nil {
+		return err
+	}
+	return sendSettingsMenu(ctx, h.api, h.deps, chatID, user)
+}
+
+// formatSharedSettings lists the contents of a settings code. Glucose values
+// are shown in mmol/L, since the code doesn't carry the sender's unit.
+func formatSharedSettings(s *sharecode.Settings) string {
+	var b strings.Builder
+	b.WriteString("Коэффициенты на ХЕ:\n")
+	if len(s.Ratios) == 0 {
+		b.WriteString("нет\n")
+	}
+	for _, p := range s.Ratios {
+		fmt.Fprintf(&b, "🕒 %s - %s: %.1f ед/ХЕ\n", p.StartTime, p.EndTime, p.Value)
+	}
+	if len(s.BasalRates) > 0 {
+		b.WriteString("\nБазальный профиль:\n")
+		for _, p := range s.BasalRates {
+			fmt.Fprintf(&b, "🕒 %s - %s: %.2f ед/ч\n", p.StartTime, p.EndTime, p.Value)
+		}
+	}
+
+	b.WriteString("\nВремя действия инсулина: ")
+	if s.ActiveInsulinTime > 0 {
+		fmt.Fprintf(&b, "%d ч %02d мин", s.ActiveInsulinTime/60, s.ActiveInsulinTime%60)
+	} else {
+		b.WriteString("по умолчанию")
+	}
+	if s.IOBModel == dosing.IOBCurved {
+		b.WriteString(", по кривой\n")
+	} else {
+		b.WriteString(", линейно\n")
+	}
+	if s.Version < 2 {
+		return b.String()
+	}
+
+	if s.CorrectionFactor > 0 {
+		fmt.Fprintf(&b, "Коррекция: 1 ед снижает на %s, цель %s\n",
+			formatGlucose(s.CorrectionFactor, services.GlucoseUnitMmol), formatGlucose(s.TargetBloodSugar, services.GlucoseUnitMmol))
+	} else {
+		b.WriteString("Коррекция: выключена\n")
+	}
+	if s.LowRuleGrams > 0 {
+		fmt.Fprintf(&b, "Правило гипо: %.0f г поднимают на %s\n", s.LowRuleGrams, formatGlucose(s.LowRuleRise, services.GlucoseUnitMmol))
+	} else {
+		b.WriteString("Правило гипо: не задано\n")
+	}
+	if s.CarbsStep > 0 {
+		fmt.Fprintf(&b, "Округление углеводов: до %.0f г\n", s.CarbsStep)
+	}
+	return b.String()
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/vladimiradmaev/diabetes-helper/internal/confidence"
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/dosing"
+	"github.com/vladimiradmaev/diabetes-helper/internal/format"
+	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+	"github.com/vladimiradmaev/diabetes-helper/internal/services"
+)
+
+// handleSupport handles the admin-only /support <telegramID> command: a
+// read-only view of a user's dosing setup and last analyses, so a report
+// like "бот считает неправильно" can be checked without screenshots
+func (h *CommandHandler) handleSupport(ctx context.Context, chatID int64, admin *database.User, args string) error {
+	telegramID, err := strconv.ParseInt(strings.TrimSpace(args), 10, 64)
+	if err != nil {
+		msg := tgbotapi.NewMessage(chatID, "Использование: /support <telegram ID пользователя>")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+
+	snapshot, err := h.deps.SupportSvc.ViewUser(ctx, admin.TelegramID, telegramID)
+	if errors.Is(err, services.ErrUserNotFound) {
+		msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("Пользователь %d не найден", telegramID))
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+	if err != nil {
+		logger.Error("Failed to get support view", "admin_id", admin.TelegramID, "telegram_id", telegramID, "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при получении данных пользователя")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+	logger.Info("Support view opened", "admin_id", admin.TelegramID, "telegram_id", telegramID)
+
+	return sendLongText(h.api, chatID, formatSupportSnapshot(snapshot), nil)
+}
+
+// formatSupportSnapshot renders a support view. Free-text fields are not
+// part of the snapshot, so nothing the user wrote is shown.
+func formatSupportSnapshot(s *services.SupportSnapshot) string {
+	user := &s.User
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "🛟 Пользователь %d (ID %d), с %s\n\n", user.TelegramID, user.ID, format.Date(user.CreatedAt, user.CreatedAt.Location(), format.Default))
+
+	b.WriteString("Коэффициенты на ХЕ:\n")
+	if len(s.Ratios) == 0 {
+		b.WriteString("нет\n")
+	}
+	for _, r := range s.Ratios {
+		fmt.Fprintf(&b, "%s-%s: %.1f ед/ХЕ\n", r.StartTime, r.EndTime, r.Ratio)
+	}
+	if len(s.BasalRates) > 0 {
+		b.WriteString("\nБазальный профиль:\n")
+		for _, r := range s.BasalRates {
+			fmt.Fprintf(&b, "%s-%s: %.2f ед/ч\n", r.StartTime, r.EndTime, r.Rate)
+		}
+	}
+
+	fmt.Fprintf(&b, "\nХЕ: %.0f г углеводов\n", dosing.BreadUnitGrams)
+	fmt.Fprintf(&b, "Единицы сахара: %s\n", services.GlucoseUnitLabel(user.GlucoseUnit))
+	if user.DailyCarbTarget > 0 {
+		fmt.Fprintf(&b, "Цель по углеводам: %.0f г\n", user.DailyCarbTarget)
+	} else {
+		b.WriteString("Цель по углеводам: не задана\n")
+	}
+	duration := services.ActiveInsulinTime(user)
+	iobModel := "линейно"
+	if services.CurvedIOB(user) {
+		iobModel = "по кривой"
+	}
+	fmt.Fprintf(&b, "Активный инсулин: %d ч %02d мин, %s\n", int(duration.Hours()), int(duration.Minutes())%60, iobModel)
+	fmt.Fprintf(&b, "Часовой пояс: %s\n", services.UserLocation(user))
+	if user.CorrectionFactor > 0 {
+		fmt.Fprintf(&b, "Коррекция: 1 ед снижает на %s, цель %s\n",
+			formatGlucose(user.CorrectionFactor, user.GlucoseUnit), formatGlucose(user.TargetBloodSugar, user.GlucoseUnit))
+	} else {
+		b.WriteString("Коррекция: выключена\n")
+	}
+	if user.LowRuleGrams > 0 {
+		fmt.Fprintf(&b, "Правило гипо: %.0f г поднимают на %s, до %s\n", user.LowRuleGrams,
+			formatGlucose(user.LowRuleRise, user.GlucoseUnit), formatGlucose(lowTarget(user), user.GlucoseUnit))
+	} else {
+		b.WriteString("Правило гипо: не задано\n")
+	}
+	fmt.Fprintf(&b, "Округление: ХЕ до %g", services.BreadUnitStep(user))
+	if user.CarbsStep > 0 {
+		fmt.Fprintf(&b, ", углеводы до %.0f г\n", user.CarbsStep)
+	} else {
+		b.WriteString(", углеводы без округления\n")
+	}
+	if user.QuietHoursStart != "" {
+		fmt.Fprintf(&b, "Тихие часы: %s-%s\n", user.QuietHoursStart, user.QuietHoursEnd)
+	}
+	if user.BasalReminderTime != "" {
+		fmt.Fprintf(&b, "Напоминание о базальном: %s, %.1f ед.\n", user.BasalReminderTime, user.BasalReminderUnits.Units())
+	}
+	var flags []string
+	if user.HideBolusTiming {
+		flags = append(flags, "без подсказки, когда колоть")
+	}
+	if user.KeepPrompts {
+		flags = append(flags, "вопросы не удаляются")
+	}
+	if user.MeasurementSystem == services.MeasurementImperial {
+		flags = append(flags, "имперские меры")
+	}
+	if user.ReanalyzeOnPrimary {
+		flags = append(flags, "повтор на основной модели")
+	}
+	if user.NotificationsPaused {
+		flags = append(flags, "уведомления на паузе")
+	}
+	if len(flags) > 0 {
+		fmt.Fprintf(&b, "Прочее: %s\n", strings.Join(flags, ", "))
+	}
+
+	b.WriteString("\nПоследние анализы:\n")
+	if len(s.Analyses) == 0 {
+		b.WriteString("нет\n")
+	}
+	for _, a := range s.Analyses {
+		fmt.Fprintf(&b, "\n#%d %s, %s, уверенность: %s\n", a.ID, a.CreatedAt, a.UsedProvider, confidence.Label(a.Confidence))
+		if a.Weight > 0 {
+			fmt.Fprintf(&b, "Вес: %.0f г\n", a.Weight)
+		}
+		fmt.Fprintf(&b, "%.1f г углеводов = %.1f ХЕ × %.1f ед/ХЕ = %.1f ед.\n", a.Carbs, a.Dose.BreadUnits, a.Dose.CarbRatio, a.Dose.CarbDose.Units())
+		if a.LoggedUnits != a.Dose.Total {
+			fmt.Fprintf(&b, "Сохраненная доза: %.1f ед.\n", a.LoggedUnits.Units())
+		}
+		if a.OriginalInsulinRatio != nil {
+			fmt.Fprintf(&b, "Коэффициент пересчитан, был %.1f ед/ХЕ\n", *a.OriginalInsulinRatio)
+		}
+	}
+	return b.String()
+}
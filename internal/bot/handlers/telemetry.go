@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+)
+
+// handleTelemetry handles the admin-only /telemetry command: it shows the
+// anonymous usage report exactly as it would be sent now
+func (h *CommandHandler) handleTelemetry(ctx context.Context, chatID int64) error {
+	payload, err := h.deps.Telemetry.Collect(ctx)
+	if err != nil {
+		logger.Error("Failed to collect telemetry", "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при сборе телеметрии")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+	body, err := json.MarshalIndent(payload, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode telemetry report: %w", err)
+	}
+
+	status := "📡 Телеметрия выключена (TELEMETRY_ENABLED=false), отчет не отправляется. Так он выглядел бы сейчас:"
+	if h.deps.Telemetry.Enabled() {
+		status = fmt.Sprintf("📡 Телеметрия включена: раз в неделю отчет отправляется на %s. Так он выглядит сейчас:", h.deps.Telemetry.Endpoint())
+	}
+	msg := tgbotapi.NewMessage(chatID, status+"\n\n"+string(body))
+	_, err = h.api.Send(msg)
+	return err
+}
@@ -6,11 +6,16 @@ import (
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/vladimiradmaev/diabetes-helper/internal/bot/keyboards"
 	"github.com/vladimiradmaev/diabetes-helper/internal/bot/menus"
 	"github.com/vladimiradmaev/diabetes-helper/internal/bot/state"
 	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/format"
+	"github.com/vladimiradmaev/diabetes-helper/internal/services"
+	"github.com/vladimiradmaev/diabetes-helper/internal/utils"
 )
 
 // TextHandler handles text messages
@@ -38,6 +43,48 @@ func (h *TextHandler) Handle(ctx context.Context, message *tgbotapi.Message, use
 		return h.handleTimePeriod(ctx, message, user)
 	case state.WaitingForInsulinRatio:
 		return h.handleInsulinRatio(ctx, message, user)
+	case state.WaitingForRatioImport:
+		return h.handleRatioImport(message, user)
+	case state.WaitingForRatioEdit:
+		return h.handleRatioEdit(ctx, message, user)
+	case state.WaitingForRatioChangeDate:
+		return h.handleRatioChangeDate(message, user)
+	case state.WaitingForRatioChangeSchedule:
+		return h.handleRatioChangeSchedule(ctx, message, user)
+	case state.WaitingForBasalPeriod:
+		return h.handleBasalPeriod(message, user)
+	case state.WaitingForBasalRate:
+		return h.handleBasalRate(ctx, message, user)
+	case state.WaitingForBloodSugar:
+		return h.handleBloodSugar(ctx, message, user)
+	case state.WaitingForCarbTarget:
+		return h.handleCarbTarget(ctx, message, user)
+	case state.WaitingForBasalReminder:
+		return h.handleBasalReminder(ctx, message, user)
+	case state.WaitingForQuietHours:
+		return h.handleQuietHours(ctx, message, user)
+	case state.WaitingForCorrectionFactor:
+		return h.handleCorrectionFactor(ctx, message, user)
+	case state.WaitingForActiveInsulinTime:
+		return h.handleActiveInsulinTime(ctx, message, user)
+	case state.WaitingForTimezone:
+		return h.handleTimezone(ctx, message, user)
+	case state.WaitingForTravelMode:
+		return h.handleTravelMode(ctx, message, user)
+	case state.WaitingForShareCode:
+		return h.handleShareCode(message, user)
+	case state.WaitingForSnapshotName:
+		return h.handleSnapshotName(ctx, message, user)
+	case state.WaitingForFoodWeight:
+		return h.handleFoodWeight(ctx, message, user)
+	case state.WaitingForManualCarbs:
+		return h.handleManualCarbs(ctx, message, user)
+	case state.WaitingForItemCarbs:
+		return h.handleItemCarbs(ctx, message, user)
+	case state.WaitingForTotalCarbs:
+		return h.handleTotalCarbs(ctx, message, user)
+	case state.WaitingForInjection:
+		return h.handleInjectionUnits(message, user)
 	default:
 		return h.handleDefaultText(message.Chat.ID)
 	}
@@ -45,53 +92,17 @@ func (h *TextHandler) Handle(ctx context.Context, message *tgbotapi.Message, use
 
 // handleTimePeriod handles time period input for insulin ratios
 func (h *TextHandler) handleTimePeriod(ctx context.Context, message *tgbotapi.Message, user *database.User) error {
-	// Parse time period
-	parts := strings.Split(message.Text, "-")
-	if len(parts) != 2 {
-		msg := tgbotapi.NewMessage(message.Chat.ID, "Неверный формат. Введите период в формате ЧЧ:ММ-ЧЧ:ММ (например, 08:00-12:00)")
-		_, err := h.api.Send(msg)
-		return err
+	// A pasted list of periods is imported as a whole schedule
+	if looksLikeRatioSchedule(message.Text) {
+		return h.handleRatioImport(message, user)
 	}
 
-	startTime := strings.TrimSpace(parts[0])
-	endTime := strings.TrimSpace(parts[1])
-
-	// Validate empty values
-	if startTime == "" || endTime == "" {
-		msg := tgbotapi.NewMessage(message.Chat.ID, "Время начала и окончания не могут быть пустыми")
-		_, err := h.api.Send(msg)
-		return err
-	}
+	trackPrompt(h.stateManager, user, message.MessageID)
 
-	// Validate time format
-	if _, err := time.Parse("15:04", startTime); err != nil {
-		msg := tgbotapi.NewMessage(message.Chat.ID, "Неверный формат времени начала. Используйте 24-часовой формат ЧЧ:ММ (например, 08:00 или 14:30)")
-		_, err := h.api.Send(msg)
-		return err
-	}
-	if _, err := time.Parse("15:04", endTime); err != nil {
-		msg := tgbotapi.NewMessage(message.Chat.ID, "Неверный формат времени окончания. Используйте 24-часовой формат ЧЧ:ММ (например, 08:00 или 14:30)")
-		_, err := h.api.Send(msg)
-		return err
-	}
-
-	// Additional validation for 24-hour format
-	startHour, _ := strconv.Atoi(strings.Split(startTime, ":")[0])
-	endHour, _ := strconv.Atoi(strings.Split(endTime, ":")[0])
-	if startHour < 0 || startHour > 23 {
-		msg := tgbotapi.NewMessage(message.Chat.ID, "Часы начала должны быть в диапазоне 00-23")
-		_, err := h.api.Send(msg)
-		return err
-	}
-	if endHour < 0 || endHour > 24 {
-		msg := tgbotapi.NewMessage(message.Chat.ID, "Часы окончания должны быть в диапазоне 00-24")
-		_, err := h.api.Send(msg)
-		return err
-	}
-	if endHour == 24 && strings.Split(endTime, ":")[1] != "00" {
-		msg := tgbotapi.NewMessage(message.Chat.ID, "При использовании 24 часов, минуты должны быть 00")
-		_, err := h.api.Send(msg)
-		return err
+	startTime, endTime, guessed, problem := parsePeriod(message.Text)
+	if problem != "" {
+		msg := tgbotapi.NewMessage(message.Chat.ID, problem)
+		return sendPrompt(h.api, h.stateManager, user, msg)
 	}
 
 	// Store time period and ask for ratio
@@ -104,26 +115,29 @@ func (h *TextHandler) handleTimePeriod(ctx context.Context, message *tgbotapi.Me
 			tgbotapi.NewInlineKeyboardButtonData("◀️ Отмена", "insulin_ratio"),
 		),
 	)
-	msg := tgbotapi.NewMessage(message.Chat.ID, "Введите коэффициент (количество единиц инсулина на 1 ХЕ):")
+	text := "Введите коэффициент (количество единиц инсулина на 1 ХЕ):"
+	if guessed {
+		text = periodConfirmation(startTime, endTime) + text
+	}
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
 	msg.ReplyMarkup = keyboard
-	_, err := h.api.Send(msg)
-	return err
+	return sendPrompt(h.api, h.stateManager, user, msg)
 }
 
 // handleInsulinRatio handles insulin ratio input
 func (h *TextHandler) handleInsulinRatio(ctx context.Context, message *tgbotapi.Message, user *database.User) error {
+	trackPrompt(h.stateManager, user, message.MessageID)
+
 	ratio, err := strconv.ParseFloat(message.Text, 64)
 	if err != nil {
 		msg := tgbotapi.NewMessage(message.Chat.ID, "Пожалуйста, введите корректное число (например: 1.5)")
-		_, err := h.api.Send(msg)
-		return err
+		return sendPrompt(h.api, h.stateManager, user, msg)
 	}
 
 	// Validate empty or zero ratio
 	if ratio <= 0 {
 		msg := tgbotapi.NewMessage(message.Chat.ID, "Коэффициент должен быть больше 0")
-		_, err := h.api.Send(msg)
-		return err
+		return sendPrompt(h.api, h.stateManager, user, msg)
 	}
 
 	// Get stored time period
@@ -143,29 +157,312 @@ func (h *TextHandler) handleInsulinRatio(ctx context.Context, message *tgbotapi.
 	startTime := startTimeVal.(string)
 	endTime := endTimeVal.(string)
 
-	// Add insulin ratio
-	if err := h.deps.InsulinSvc.AddRatio(ctx, user.ID, startTime, endTime, ratio); err != nil {
-		msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("Ошибка при сохранении коэффициента: %v", err))
-		_, err := h.api.Send(msg)
+	// Ask before saving a ratio far outside the typical range, it may be a typo
+	if check := h.deps.InsulinSvc.CheckRatio(ratio); check != services.RatioTypical {
+		h.stateManager.SetTempData(user.TelegramID, "pendingRatio", ratio)
+
+		bounds := h.deps.InsulinSvc.RatioBounds()
+		text := fmt.Sprintf("⚠️ Необычно высокий коэффициент: %.1f ед/ХЕ", ratio)
+		if check == services.RatioLow {
+			text = fmt.Sprintf("⚠️ Необычно низкий коэффициент: %.2f ед/ХЕ", ratio)
+		}
+		text += fmt.Sprintf(" (обычно от %g до %g). Вы уверены?", bounds.Low, bounds.High)
+
+		msg := tgbotapi.NewMessage(message.Chat.ID, text)
+		msg.ReplyMarkup = keyboards.RatioConfirm()
+		return sendPrompt(h.api, h.stateManager, user, msg)
+	}
+
+	return saveInsulinRatio(ctx, h.api, h.deps, h.stateManager, message.Chat.ID, user, startTime, endTime, ratio)
+}
+
+// saveInsulinRatio saves an entered ratio and shows the updated ratio menu
+func saveInsulinRatio(ctx context.Context, api *tgbotapi.BotAPI, deps Dependencies, stateManager state.StateManager, chatID int64, user *database.User, startTime, endTime string, ratio float64) error {
+	if err := deps.InsulinSvc.AddRatio(ctx, user.ID, startTime, endTime, ratio); err != nil {
+		msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("Ошибка при сохранении коэффициента: %v", err))
+		_, err := api.Send(msg)
 		return err
 	}
 
 	// Clear temporary data
-	h.stateManager.ClearTempData(user.TelegramID)
+	clearPrompts(api, stateManager, chatID, user)
+	stateManager.ClearTempData(user.TelegramID)
+	stateManager.SetUserState(user.TelegramID, state.None)
+
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("✅ Коэффициент %.1f ед/ХЕ для периода %s-%s успешно сохранен", ratio, startTime, endTime))
+	if _, err := api.Send(msg); err != nil {
+		return err
+	}
+
+	// Get updated ratios and send menu
+	ratios, err := deps.InsulinSvc.GetUserRatios(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	return menus.SendInsulinRatioMenu(api, chatID, ratios)
+}
+
+// handleBloodSugar handles blood sugar input
+func (h *TextHandler) handleBloodSugar(ctx context.Context, message *tgbotapi.Message, user *database.User) error {
+	value, err := strconv.ParseFloat(strings.ReplaceAll(strings.TrimSpace(message.Text), ",", "."), 64)
+	if err != nil || value <= 0 {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Пожалуйста, введите корректное число (например: 6.5)")
+		_, err := h.api.Send(msg)
+		return err
+	}
+
+	// Ask before saving if the value looks like it was entered in the other unit
+	if services.DetectUnitMismatch(value, user.GlucoseUnit) {
+		h.stateManager.SetTempData(user.TelegramID, "pendingBloodSugar", value)
+
+		otherUnit := services.OtherGlucoseUnit(user.GlucoseUnit)
+		converted := services.ToMmol(value, otherUnit)
+		if user.GlucoseUnit == services.GlucoseUnitMgdl {
+			converted = services.MmolToMgdl(converted)
+		}
+		text := fmt.Sprintf("Вы имели в виду %s = %s?",
+			formatGlucoseValue(value, otherUnit),
+			formatGlucoseValue(converted, user.GlucoseUnit))
+
+		msg := tgbotapi.NewMessage(message.Chat.ID, text)
+		msg.ReplyMarkup = keyboards.GlucoseUnitConfirm()
+		_, err := h.api.Send(msg)
+		return err
+	}
+
+	mmol := services.ToMmol(value, user.GlucoseUnit)
+	if err := h.deps.BloodSugarSvc.AddRecord(ctx, user.ID, mmol); err != nil {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Ошибка при сохранении уровня сахара")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+
 	h.stateManager.SetUserState(user.TelegramID, state.None)
 
-	msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("✅ Коэффициент %.1f ед/ХЕ для периода %s-%s успешно сохранен", ratio, startTime, endTime))
+	msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("✅ Уровень сахара %s сохранен", formatGlucose(mmol, user.GlucoseUnit)))
+	msg.ReplyMarkup = keyboards.MainMenu()
 	_, err = h.api.Send(msg)
+	return err
+}
+
+// handleCarbTarget handles daily carb target input
+func (h *TextHandler) handleCarbTarget(ctx context.Context, message *tgbotapi.Message, user *database.User) error {
+	grams, err := strconv.ParseFloat(strings.ReplaceAll(strings.TrimSpace(message.Text), ",", "."), 64)
+	if err != nil || grams < 0 || grams > 1000 {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Пожалуйста, введите число от 0 до 1000 (например: 220)")
+		_, err := h.api.Send(msg)
+		return err
+	}
+
+	if err := h.deps.UserService.SetDailyCarbTarget(ctx, user.ID, grams); err != nil {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Ошибка при сохранении цели по углеводам")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+	user.DailyCarbTarget = grams
+	h.stateManager.SetUserState(user.TelegramID, state.None)
+
+	text := "✅ Отслеживание дневной цели по углеводам отключено"
+	if grams > 0 {
+		text = fmt.Sprintf("✅ Дневная цель по углеводам: %.0f г", grams)
+	}
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	if _, err := h.api.Send(msg); err != nil {
+		return err
+	}
+	return sendSettingsMenu(ctx, h.api, h.deps, message.Chat.ID, user)
+}
+
+// handleTimezone handles timezone input
+func (h *TextHandler) handleTimezone(ctx context.Context, message *tgbotapi.Message, user *database.User) error {
+	timezone, err := utils.ParseTimezone(message.Text)
 	if err != nil {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Не удалось распознать часовой пояс. Введите, например, Europe/Moscow или +3")
+		_, err := h.api.Send(msg)
 		return err
 	}
 
-	// Get updated ratios and send menu
-	ratios, err := h.deps.InsulinSvc.GetUserRatios(ctx, user.ID)
+	if err := h.deps.UserService.SetTimezone(ctx, user.ID, timezone); err != nil {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Ошибка при сохранении часового пояса")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+	user.Timezone = timezone
+	h.stateManager.SetUserState(user.TelegramID, state.None)
+
+	localTime := time.Now().In(utils.LoadLocation(timezone)).Format("15:04")
+	msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("✅ Часовой пояс %s сохранен. Местное время: %s", timezone, localTime))
+	if _, err := h.api.Send(msg); err != nil {
+		return err
+	}
+	return sendSettingsMenu(ctx, h.api, h.deps, message.Chat.ID, user)
+}
+
+// handleTravelMode handles "<timezone> [dd.mm[.yyyy]]" input enabling travel mode
+func (h *TextHandler) handleTravelMode(ctx context.Context, message *tgbotapi.Message, user *database.User) error {
+	fields := strings.Fields(message.Text)
+	if len(fields) == 0 || len(fields) > 2 {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Введите часовой пояс и, по желанию, дату окончания, например: Asia/Tokyo 25.10")
+		_, err := h.api.Send(msg)
+		return err
+	}
+
+	timezone, err := utils.ParseTimezone(fields[0])
+	if err != nil {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Не удалось распознать часовой пояс. Введите, например, Asia/Tokyo или +9")
+		_, err := h.api.Send(msg)
+		return err
+	}
+	loc := utils.LoadLocation(timezone)
+
+	var until *time.Time
+	if len(fields) == 2 {
+		lastDay, err := parseTravelEndDate(fields[1], time.Now().In(loc))
+		if err != nil {
+			msg := tgbotapi.NewMessage(message.Chat.ID, "Не удалось распознать дату. Используйте формат ДД.ММ или ДД.ММ.ГГГГ, дата не может быть в прошлом")
+			_, err := h.api.Send(msg)
+			return err
+		}
+		// Travel mode lasts through the whole last day
+		_, end := utils.DayBounds(lastDay, loc)
+		until = &end
+	}
+
+	if err := h.deps.UserService.SetTravelMode(ctx, user.ID, timezone, until); err != nil {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Ошибка при включении режима путешествия")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+	user.TravelTimezone = timezone
+	user.TravelUntil = until
+	h.stateManager.SetUserState(user.TelegramID, state.None)
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, "✅ "+travelModeBanner(user))
+	if _, err := h.api.Send(msg); err != nil {
+		return err
+	}
+	return sendSettingsMenu(ctx, h.api, h.deps, message.Chat.ID, user)
+}
+
+// parseTravelEndDate parses "dd.mm" or "dd.mm.yyyy" in now's location. A date
+// without a year that has already passed refers to next year.
+func parseTravelEndDate(input string, now time.Time) (time.Time, error) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	if date, err := time.ParseInLocation("02.01.2006", input, now.Location()); err == nil {
+		if date.Before(today) {
+			return time.Time{}, fmt.Errorf("date %s is in the past", input)
+		}
+		return date, nil
+	}
+
+	date, err := time.ParseInLocation("02.01", input, now.Location())
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q: %w", input, err)
+	}
+	date = time.Date(now.Year(), date.Month(), date.Day(), 0, 0, 0, 0, now.Location())
+	if date.Before(today) {
+		date = date.AddDate(1, 0, 0)
+	}
+	return date, nil
+}
+
+// formatGlucose formats a value stored in mmol/L in the user's unit
+func formatGlucose(mmol float64, unit string) string {
+	if unit == services.GlucoseUnitMgdl {
+		return formatGlucoseValue(services.MmolToMgdl(mmol), unit)
+	}
+	return formatGlucoseValue(mmol, unit)
+}
+
+// formatGlucoseValue formats a value that is already in the given unit
+func formatGlucoseValue(value float64, unit string) string {
+	return format.Glucose(value, unit, format.Default)
+}
+
+// handleSnapshotName saves the current settings under the entered name
+func (h *TextHandler) handleSnapshotName(ctx context.Context, message *tgbotapi.Message, user *database.User) error {
+	name := strings.TrimSpace(message.Text)
+	if name == "" || utf8.RuneCountInString(name) > 64 {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Название должно быть от 1 до 64 символов")
+		_, err := h.api.Send(msg)
+		return err
+	}
+
+	_, dropped, err := h.deps.SnapshotSvc.CreateSnapshot(ctx, user.ID, name)
 	if err != nil {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Ошибка при сохранении настроек")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+	h.stateManager.SetUserState(user.TelegramID, state.None)
+
+	text := fmt.Sprintf("💾 Настройки сохранены как «%s»", name)
+	if dropped > 0 {
+		text += fmt.Sprintf("\nХранится не больше %d сохранений, самые старые удалены.", services.MaxSettingsSnapshots)
+	}
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	if _, err := h.api.Send(msg); err != nil {
+		return err
+	}
+	return sendSettingsMenu(ctx, h.api, h.deps, message.Chat.ID, user)
+}
+
+// handleManualCarbs calculates bread units and the dose for entered carbs
+func (h *TextHandler) handleManualCarbs(ctx context.Context, message *tgbotapi.Message, user *database.User) error {
+	carbs, err := strconv.ParseFloat(strings.ReplaceAll(strings.TrimSpace(message.Text), ",", "."), 64)
+	if err != nil || carbs <= 0 || carbs > 1000 {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Пожалуйста, введите количество углеводов от 1 до 1000 г (например: 45)")
+		_, err := h.api.Send(msg)
+		return err
+	}
+
+	analysis, err := h.deps.FoodAnalysisSvc.CalculateManual(ctx, user.ID, carbs, false)
+	if err != nil {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Ошибка при расчете дозы")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+	h.stateManager.SetTempData(user.TelegramID, "pendingManualCarbs", carbs)
+	h.stateManager.SetUserState(user.TelegramID, state.None)
+
+	header := fmt.Sprintf("🍞 Углеводы: %.1f г\n🥖 ХЕ: %s\n", analysis.Carbs,
+		format.BreadUnitsNumber(analysis.BreadUnits, services.BreadUnitStep(user), format.Default))
+	text, hypo := doseText(user, analysis, recentGlucose(ctx, h.deps, user), header, "Рекомендуемая доза инсулина", "")
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("💾 Сохранить в историю", "save_manual_carbs"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🏠 Главное меню", "main_menu"),
+		),
+	)
+	if hypo {
+		keyboard = withLowRecheckButton(keyboard)
+	}
+	msg.ReplyMarkup = keyboard
+	_, err = h.api.Send(msg)
+	return err
+}
+
+// handleInjectionUnits handles the dose of an injection being logged and asks for the site
+func (h *TextHandler) handleInjectionUnits(message *tgbotapi.Message, user *database.User) error {
+	units, err := strconv.ParseFloat(strings.ReplaceAll(strings.TrimSpace(message.Text), ",", "."), 64)
+	if err != nil || units <= 0 || units > 100 {
+		msg := tgbotapi.NewMessage(message.Chat.ID, "Пожалуйста, введите количество единиц от 0.1 до 100 (например: 4.5)")
+		_, err := h.api.Send(msg)
 		return err
 	}
-	return menus.SendInsulinRatioMenu(h.api, message.Chat.ID, ratios)
+
+	h.stateManager.SetTempData(user.TelegramID, "pendingInjectionUnits", units)
+	h.stateManager.SetUserState(user.TelegramID, state.None)
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("💉 %.1f ед. Куда сделан укол?", units))
+	msg.ReplyMarkup = keyboards.InjectionSiteMenu()
+	_, err = h.api.Send(msg)
+	return err
 }
 
 // handleDefaultText handles text when no specific state is set
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/vladimiradmaev/diabetes-helper/internal/bot/state"
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/format"
+	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+	"github.com/vladimiradmaev/diabetes-helper/internal/services"
+)
+
+// handleTransfer handles the /transfer command: a one-time code that moves
+// the user's history to another Telegram account
+func (h *CommandHandler) handleTransfer(ctx context.Context, chatID int64, user *database.User) error {
+	code, expiresAt, err := h.deps.TransferSvc.IssueCode(ctx, user.ID)
+	if err != nil {
+		logger.Error("Failed to issue transfer code", "user_id", user.ID, "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при создании кода переноса")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+	logger.Info("Transfer code issued", "user_id", user.ID)
+
+	text := fmt.Sprintf("🔑 Код переноса: %s\n\n"+
+		"Отправьте с нового аккаунта Telegram команду:\n/claim %s\n\n"+
+		"Вся история и настройки перейдут на новый аккаунт, а этот аккаунт будет отвязан от них. "+
+		"Код одноразовый и действует до %s. Никому его не пересылайте.",
+		code, code, format.DateTime(expiresAt, services.UserLocation(user), format.Default))
+	msg := tgbotapi.NewMessage(chatID, text)
+	_, err = h.api.Send(msg)
+	return err
+}
+
+// handleClaim handles the /claim <code> command on the new account
+func (h *CommandHandler) handleClaim(ctx context.Context, chatID int64, user *database.User, args string) error {
+	code := strings.TrimSpace(args)
+	if code == "" {
+		msg := tgbotapi.NewMessage(chatID, "Использование: /claim <код>\nКод выдает команда /transfer на старом аккаунте.")
+		_, err := h.api.Send(msg)
+		return err
+	}
+	return claimTransfer(ctx, h.api, h.deps, h.stateManager, chatID, user, code, false)
+}
+
+// handleClaimMerge completes a transfer into an account that has data of its own
+func (h *CallbackHandler) handleClaimMerge(ctx context.Context, chatID int64, user *database.User) error {
+	codeVal, _ := h.stateManager.GetTempData(user.TelegramID, "claimCode")
+	code, _ := codeVal.(string)
+	if code == "" {
+		msg := tgbotapi.NewMessage(chatID, "Код не найден. Отправьте /claim <код> еще раз.")
+		_, err := h.api.Send(msg)
+		return err
+	}
+	return claimTransfer(ctx, h.api, h.deps, h.stateManager, chatID, user, code, true)
+}
+
+// handleClaimCancel aborts a transfer into an account that has data of its own
+func (h *CallbackHandler) handleClaimCancel(chatID int64, user *database.User) error {
+	h.stateManager.ClearTempData(user.TelegramID)
+	msg := tgbotapi.NewMessage(chatID, "Перенос отменен, данные не изменились. Код можно использовать, пока он действует.")
+	_, err := h.api.Send(msg)
+	return err
+}
+
+// claimTransfer claims a transfer code for the user's account. When the
+// account has data of its own, the user is asked to merge or abort first.
+func claimTransfer(ctx context.Context, api *tgbotapi.BotAPI, deps Dependencies, stateManager state.StateManager, chatID int64, user *database.User, code string, merge bool) error {
+	transferred, err := deps.TransferSvc.Claim(ctx, code, user, merge)
+	switch {
+	case errors.Is(err, services.ErrInvalidTransferCode):
+		msg := tgbotapi.NewMessage(chatID, "Код не найден, уже использован или истек. Получите новый командой /transfer на старом аккаунте.")
+		_, sendErr := api.Send(msg)
+		return sendErr
+	case errors.Is(err, services.ErrTransferSameAccount):
+		msg := tgbotapi.NewMessage(chatID, "Этот код выдан для этого же аккаунта. Отправьте /claim с нового аккаунта.")
+		_, sendErr := api.Send(msg)
+		return sendErr
+	case errors.Is(err, services.ErrTransferTargetHasData):
+		stateManager.SetTempData(user.TelegramID, "claimCode", code)
+		msg := tgbotapi.NewMessage(chatID, "⚠️ На этом аккаунте уже есть свои данные.\n\n"+
+			"🔀 Объединить: записи (анализы, сахар, уколы) этого аккаунта добавятся к перенесенной истории, "+
+			"а его настройки (коэффициенты, базальный профиль и другие) заменятся перенесенными.\n"+
+			"❌ Отмена: ничего не меняется.")
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("🔀 Объединить", "claim_merge"),
+				tgbotapi.NewInlineKeyboardButtonData("❌ Отмена", "claim_cancel"),
+			),
+		)
+		_, sendErr := api.Send(msg)
+		return sendErr
+	case err != nil:
+		logger.Error("Failed to claim transfer code", "user_id", user.ID, "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при переносе истории, данные не изменились")
+		_, sendErr := api.Send(msg)
+		return sendErr
+	}
+
+	stateManager.ClearTempData(user.TelegramID)
+	stateManager.SetUserState(user.TelegramID, state.None)
+	logger.Info("Account transferred", "user_id", transferred.ID, "telegram_id", transferred.TelegramID, "merged", merge)
+
+	msg := tgbotapi.NewMessage(chatID, "✅ История и настройки перенесены на этот аккаунт. Старый аккаунт больше не связан с ними.")
+	_, err = api.Send(msg)
+	return err
+}
+
+// handleMergeUsers handles the admin-only /merge_users <fromID> <toID>
+// command, which folds a duplicate user row into another one
+func (h *CommandHandler) handleMergeUsers(ctx context.Context, chatID int64, admin *database.User, args string) error {
+	const usage = "Использование: /merge_users <fromID> <toID>\n" +
+		"История пользователя fromID (ID в базе, не Telegram ID) переносится к toID, а fromID удаляется. " +
+		"Коэффициенты и базальный профиль переносятся, только если у toID их нет."
+
+	fields := strings.Fields(args)
+	if len(fields) != 2 {
+		_, err := h.api.Send(tgbotapi.NewMessage(chatID, usage))
+		return err
+	}
+	fromID, fromErr := strconv.ParseUint(fields[0], 10, 64)
+	toID, toErr := strconv.ParseUint(fields[1], 10, 64)
+	if fromErr != nil || toErr != nil {
+		_, err := h.api.Send(tgbotapi.NewMessage(chatID, usage))
+		return err
+	}
+
+	counts, err := h.deps.TransferSvc.MergeUsers(ctx, admin.TelegramID, uint(fromID), uint(toID))
+	switch {
+	case errors.Is(err, services.ErrMergeSameUser):
+		_, sendErr := h.api.Send(tgbotapi.NewMessage(chatID, "fromID и toID совпадают"))
+		return sendErr
+	case errors.Is(err, services.ErrUserNotFound):
+		_, sendErr := h.api.Send(tgbotapi.NewMessage(chatID, "Пользователь с таким ID не найден, данные не изменились"))
+		return sendErr
+	case err != nil:
+		logger.Error("Failed to merge users", "from_id", fromID, "to_id", toID, "error", err)
+		_, sendErr := h.api.Send(tgbotapi.NewMessage(chatID, "Ошибка при объединении, данные не изменились"))
+		return sendErr
+	}
+	logger.Info("Users merged", "from_id", fromID, "to_id", toID, "admin_telegram_id", admin.TelegramID)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "✅ Пользователь %d объединен с %d и удален. Перенесено строк:\n", fromID, toID)
+	for _, c := range counts {
+		fmt.Fprintf(&b, "%s: %d\n", c.Table, c.Rows)
+	}
+	_, err = h.api.Send(tgbotapi.NewMessage(chatID, b.String()))
+	return err
+}
@@ -1,13 +1,163 @@
 package handlers
 
 import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/vladimiradmaev/diabetes-helper/internal/bot/menus"
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/dosing"
+	"github.com/vladimiradmaev/diabetes-helper/internal/format"
 	"github.com/vladimiradmaev/diabetes-helper/internal/interfaces"
+	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+	"github.com/vladimiradmaev/diabetes-helper/internal/services"
+	"github.com/vladimiradmaev/diabetes-helper/internal/utils"
 )
 
 // Dependencies holds all service dependencies for handlers
 type Dependencies struct {
 	UserService     interfaces.UserServiceInterface
 	FoodAnalysisSvc interfaces.FoodAnalysisServiceInterface
+	AnalysisQueue   interfaces.AnalysisQueueInterface
 	BloodSugarSvc   interfaces.BloodSugarServiceInterface
 	InsulinSvc      interfaces.InsulinServiceInterface
+	InjectionSvc    interfaces.InjectionServiceInterface
+	BasalSvc        interfaces.BasalServiceInterface
+	SnapshotSvc     interfaces.SettingsSnapshotServiceInterface
+	StatsSvc        interfaces.StatsServiceInterface
+	EventSvc        interfaces.EventServiceInterface
+	UsageSvc        interfaces.UsageServiceInterface
+	JobSvc          interfaces.JobServiceInterface
+	ChartSvc        interfaces.ChartServiceInterface
+	APITokenSvc     interfaces.APITokenServiceInterface
+	InsightsSvc     interfaces.InsightsServiceInterface
+	SupportSvc      interfaces.SupportServiceInterface
+	DataCheckSvc    interfaces.DataCheckServiceInterface
+	TransferSvc     interfaces.TransferServiceInterface
+	ProfileSvc      interfaces.UserProfileServiceInterface
+	Flags           interfaces.FeatureFlagsInterface
+	Telemetry       interfaces.TelemetryInterface
+	ConversationLog interfaces.ConversationLogInterface
+	BolusTiming     dosing.TimingConfig
+	Admins          Admins
+}
+
+// Admins is the set of Telegram IDs allowed to run operator commands
+type Admins map[int64]bool
+
+// NewAdmins creates an admin set from Telegram IDs
+func NewAdmins(ids []int64) Admins {
+	admins := make(Admins, len(ids))
+	for _, id := range ids {
+		admins[id] = true
+	}
+	return admins
+}
+
+// Contains reports whether the Telegram ID belongs to an admin
+func (a Admins) Contains(telegramID int64) bool {
+	return a[telegramID]
+}
+
+// Notify sends every admin the text. Failures are logged per admin.
+func (a Admins) Notify(api *tgbotapi.BotAPI, text string) {
+	for telegramID := range a {
+		if _, err := api.Send(tgbotapi.NewMessage(telegramID, text)); err != nil {
+			logger.Error("Failed to notify admin", "telegram_id", telegramID, "error", err)
+		}
+	}
+}
+
+// mainMenuStatus returns the status lines shown above the main menu actions
+func mainMenuStatus(ctx context.Context, deps Dependencies, user *database.User) string {
+	var lines []string
+	if services.TravelModeActive(user, time.Now()) {
+		lines = append(lines, travelModeBanner(user))
+	}
+	if progress := carbProgressText(ctx, deps, user); progress != "" {
+		lines = append(lines, progress)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// sendSettingsMenu sends the settings menu with the user's profile checklist.
+// The menu is still sent, without the checklist, when it can't be computed.
+func sendSettingsMenu(ctx context.Context, api *tgbotapi.BotAPI, deps Dependencies, chatID int64, user *database.User) error {
+	profile, err := deps.ProfileSvc.Completeness(ctx, user.ID)
+	if err != nil {
+		logger.Error("Failed to get profile completeness", "user_id", user.ID, "error", err)
+	}
+	return menus.SendSettingsMenu(api, chatID, user, profile)
+}
+
+// travelModeBanner describes the active travel mode
+func travelModeBanner(user *database.User) string {
+	loc := services.UserLocation(user)
+	text := fmt.Sprintf("✈️ Режим путешествия: %s, местное время %s", user.TravelTimezone, format.Clock(time.Now(), loc, format.Default))
+	if user.TravelUntil != nil {
+		text += fmt.Sprintf(", до %s", format.ShortDate(user.TravelUntil.Add(-time.Minute), loc, format.Default))
+	}
+	return text
+}
+
+// carbProgressText returns today's carb progress line for users with a daily target
+func carbProgressText(ctx context.Context, deps Dependencies, user *database.User) string {
+	if user.DailyCarbTarget <= 0 {
+		return ""
+	}
+	carbs, err := deps.FoodAnalysisSvc.GetDailyCarbs(ctx, user.ID, services.UserLocation(user))
+	if err != nil {
+		logger.Error("Failed to get daily carbs", "user_id", user.ID, "error", err)
+		return ""
+	}
+	return fmt.Sprintf("📅 Углеводы сегодня: %.0f из %.0f г", carbs, user.DailyCarbTarget)
+}
+
+// sendLongText sends text as several messages when it exceeds Telegram's
+// limit, attaching markup to the last one
+func sendLongText(api *tgbotapi.BotAPI, chatID int64, text string, markup interface{}) error {
+	parts := utils.SplitMessage(text, utils.TelegramMessageLimit)
+	for i, part := range parts {
+		msg := tgbotapi.NewMessage(chatID, part)
+		if i == len(parts)-1 {
+			msg.ReplyMarkup = markup
+		}
+		if _, err := api.Send(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendInjectionSites sends how often each injection site was used in the last 30 days
+func sendInjectionSites(ctx context.Context, api *tgbotapi.BotAPI, deps Dependencies, chatID int64, user *database.User) error {
+	counts, err := deps.InjectionSvc.GetSiteFrequency(ctx, user.ID, time.Now().AddDate(0, 0, -30))
+	if err != nil {
+		logger.Error("Failed to get injection site frequency", "user_id", user.ID, "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при получении мест уколов")
+		_, sendErr := api.Send(msg)
+		return sendErr
+	}
+
+	if len(counts) == 0 {
+		msg := tgbotapi.NewMessage(chatID, "За последние 30 дней нет уколов с указанным местом")
+		_, err := api.Send(msg)
+		return err
+	}
+
+	total := 0
+	for _, c := range counts {
+		total += c.Count
+	}
+	text := "📍 Места уколов за 30 дней:\n\n"
+	for _, c := range counts {
+		text += fmt.Sprintf("%s: %d (%.0f%%)\n", services.InjectionSiteName(c.Site), c.Count, float64(c.Count)/float64(total)*100)
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	_, err = api.Send(msg)
+	return err
 }
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/vladimiradmaev/diabetes-helper/internal/bot/keyboards"
+	"github.com/vladimiradmaev/diabetes-helper/internal/bot/state"
+	"github.com/vladimiradmaev/diabetes-helper/internal/metrics"
+	"github.com/vladimiradmaev/diabetes-helper/internal/sharecode"
+)
+
+var unsupportedMessages = metrics.NewCounterVec("bot_unsupported_messages_total", "Messages of a type the bot doesn't handle", "kind")
+
+// unsupportedKind names the content of a message the bot can't process
+func unsupportedKind(message *tgbotapi.Message) string {
+	switch {
+	case message.Sticker != nil:
+		return "sticker"
+	case message.Voice != nil:
+		return "voice"
+	case message.VideoNote != nil:
+		return "video_note"
+	case message.Video != nil:
+		return "video"
+	case message.Animation != nil:
+		return "animation"
+	case message.Audio != nil:
+		return "audio"
+	case message.Document != nil:
+		return "document"
+	case message.Location != nil, message.Venue != nil:
+		return "location"
+	case message.Contact != nil:
+		return "contact"
+	case message.Poll != nil:
+		return "poll"
+	default:
+		return "other"
+	}
+}
+
+// stateHint describes what the bot expects from the user in the given state
+func stateHint(userState string) string {
+	switch userState {
+	case state.WaitingForBloodSugar:
+		return "Сейчас жду от вас уровень сахара числом."
+	case state.WaitingForInsulinRatio:
+		return "Сейчас жду от вас коэффициент на ХЕ числом."
+	case state.WaitingForTimePeriod, state.WaitingForBasalPeriod:
+		return "Сейчас жду от вас период в формате ЧЧ:ММ-ЧЧ:ММ."
+	case state.WaitingForRatioImport:
+		return "Сейчас жду от вас список периодов с коэффициентами."
+	case state.WaitingForRatioEdit:
+		return "Сейчас жду от вас новый период с коэффициентом, например 06:00-11:00 1.5."
+	case state.WaitingForRatioChangeDate:
+		return "Сейчас жду от вас дату смены коэффициентов в формате ДД.ММ."
+	case state.WaitingForRatioChangeSchedule:
+		return "Сейчас жду от вас список новых периодов с коэффициентами."
+	case state.WaitingForBasalRate:
+		return "Сейчас жду от вас базальную скорость в ед/ч числом."
+	case state.WaitingForCarbTarget:
+		return "Сейчас жду от вас дневную цель по углеводам в граммах."
+	case state.WaitingForBasalReminder:
+		return "Сейчас жду от вас время и дозу базального инсулина, например: 22:00 18."
+	case state.WaitingForQuietHours:
+		return "Сейчас жду от вас тихие часы, например: 23:00-07:00."
+	case state.WaitingForCorrectionFactor:
+		return "Сейчас жду от вас ФЧИ и целевой сахар через пробел."
+	case state.WaitingForActiveInsulinTime:
+		return "Сейчас жду от вас время действия инсулина, например: 4 или 3:30."
+	case state.WaitingForTimezone:
+		return "Сейчас жду от вас часовой пояс."
+	case state.WaitingForInjection:
+		return "Сейчас жду от вас дозу инсулина в единицах."
+	case state.WaitingForTravelMode:
+		return "Сейчас жду от вас часовой пояс поездки."
+	case state.WaitingForFoodWeight:
+		return "Сейчас жду от вас фото еды или вес порции в граммах."
+	case state.WaitingForManualCarbs, state.WaitingForItemCarbs, state.WaitingForTotalCarbs:
+		return "Сейчас жду от вас количество углеводов в граммах."
+	case state.WaitingForSnapshotName:
+		return "Сейчас жду от вас название снимка настроек."
+	case state.WaitingForShareCode:
+		return "Сейчас жду от вас код настроек, который начинается с " + sharecode.Prefix + "."
+	default:
+		return "Отправьте фото еды для анализа или откройте меню командой /start."
+	}
+}
+
+// unsupportedText is the reply to a message the bot can't process
+func unsupportedText(kind, userState string) string {
+	text := "Я понимаю только текст, фото и команды."
+	switch kind {
+	case "voice", "audio":
+		text = "Голосовые сообщения пока не поддерживаются. " + text
+	case "document":
+		text = "Фото еды отправляйте как изображение, а не файлом. " + text
+	case "location":
+		text = "Геопозиция не нужна: часовой пояс задается в настройках. " + text
+	case "contact":
+		text = "Контакты боту не нужны. " + text
+	}
+	return text + "\n" + stateHint(userState)
+}
+
+// replyUnsupported answers a message the bot can't process with a hint that
+// depends on what the user is currently entering, and the main menu when they
+// aren't entering anything. Service messages without user content are only
+// counted.
+func (h *UpdateHandler) replyUnsupported(message *tgbotapi.Message, userState string) error {
+	kind := unsupportedKind(message)
+	unsupportedMessages.Inc(kind)
+	if kind == "other" {
+		return nil
+	}
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, unsupportedText(kind, userState))
+	if userState == state.None {
+		msg.ReplyMarkup = keyboards.MainMenu()
+	}
+	_, err := h.api.Send(msg)
+	return err
+}
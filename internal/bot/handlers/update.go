@@ -6,19 +6,30 @@ import (
 	"log"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/vladimiradmaev/diabetes-helper/internal/bot/middleware"
 	"github.com/vladimiradmaev/diabetes-helper/internal/bot/state"
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/featureflags"
 	"github.com/vladimiradmaev/diabetes-helper/internal/interfaces"
 )
 
+const maintenanceText = "🛠️ Бот на обслуживании, попробуйте позже"
+
 // UpdateHandler handles telegram updates and coordinates other handlers
 type UpdateHandler struct {
 	api             *tgbotapi.BotAPI
 	userService     interfaces.UserServiceInterface
+	flags           interfaces.FeatureFlagsInterface
+	admins          Admins
 	stateManager    state.StateManager
 	callbackHandler *CallbackHandler
 	commandHandler  *CommandHandler
 	textHandler     *TextHandler
 	photoHandler    *PhotoHandler
+	eventHandler    *EventHandler
+	inlineHandler   *InlineQueryHandler
+	conversationLog interfaces.ConversationLogInterface
+	throttle        *middleware.CallbackThrottle
 }
 
 // NewUpdateHandler creates a new update handler
@@ -31,16 +42,28 @@ func NewUpdateHandler(
 	return &UpdateHandler{
 		api:             api,
 		userService:     userService,
+		flags:           deps.Flags,
+		admins:          deps.Admins,
 		stateManager:    stateManager,
 		callbackHandler: NewCallbackHandler(api, deps, stateManager),
-		commandHandler:  NewCommandHandler(api, stateManager),
+		commandHandler:  NewCommandHandler(api, deps, stateManager),
 		textHandler:     NewTextHandler(api, deps, stateManager),
 		photoHandler:    NewPhotoHandler(api, deps, stateManager),
+		eventHandler:    NewEventHandler(api, deps, stateManager),
+		inlineHandler:   NewInlineQueryHandler(api),
+		conversationLog: deps.ConversationLog,
+		throttle:        middleware.NewCallbackThrottle(middleware.DefaultDebounceWindow, middleware.DefaultMaxConcurrent),
 	}
 }
 
 // Handle processes a telegram update
 func (h *UpdateHandler) Handle(ctx context.Context, update tgbotapi.Update) error {
+	// Inline lookups only read a fixed table, so they need neither a user
+	// row nor the database and are answered even in maintenance mode
+	if update.InlineQuery != nil {
+		return h.inlineHandler.Handle(update.InlineQuery)
+	}
+
 	if update.Message == nil && update.CallbackQuery == nil {
 		return nil
 	}
@@ -53,6 +76,16 @@ func (h *UpdateHandler) Handle(ctx context.Context, update tgbotapi.Update) erro
 		userID = update.CallbackQuery.From.ID
 	}
 
+	chatID, kind, key, text := describeUpdate(update)
+	defer h.conversationLog.BeginUpdate(chatID, update.UpdateID, kind, key, text)()
+
+	// In maintenance mode only admins get through. This runs before the user
+	// is loaded. The flag comes from a cache refreshed at most once a minute;
+	// while the database is unreachable the last loaded state is used.
+	if !h.admins.Contains(userID) && h.flags.Enabled(ctx, featureflags.Maintenance, 0) {
+		return h.replyMaintenance(update)
+	}
+
 	// Get or create user
 	user, err := h.userService.RegisterUser(ctx, userID, "", "", "")
 	if err != nil {
@@ -62,7 +95,7 @@ func (h *UpdateHandler) Handle(ctx context.Context, update tgbotapi.Update) erro
 
 	// Handle different update types
 	if update.CallbackQuery != nil {
-		return h.callbackHandler.Handle(ctx, update.CallbackQuery, user)
+		return h.handleCallback(ctx, update.CallbackQuery, user)
 	}
 
 	if update.Message != nil {
@@ -70,6 +103,16 @@ func (h *UpdateHandler) Handle(ctx context.Context, update tgbotapi.Update) erro
 			return h.commandHandler.Handle(ctx, update.Message, user)
 		}
 
+		if update.Message.Sticker != nil {
+			return h.eventHandler.Handle(ctx, update.Message, user)
+		}
+
+		// A lone recognized emoji is a quick-logged event unless the user is
+		// in the middle of entering something
+		if h.stateManager.GetUserState(user.TelegramID) == state.None && h.eventHandler.IsEventEmoji(update.Message) {
+			return h.eventHandler.Handle(ctx, update.Message, user)
+		}
+
 		if update.Message.Text != "" {
 			return h.textHandler.Handle(ctx, update.Message, user)
 		}
@@ -77,7 +120,37 @@ func (h *UpdateHandler) Handle(ctx context.Context, update tgbotapi.Update) erro
 		if len(update.Message.Photo) > 0 {
 			return h.photoHandler.Handle(ctx, update.Message, user)
 		}
+
+		return h.replyUnsupported(update.Message, h.stateManager.GetUserState(user.TelegramID))
 	}
 
 	return nil
 }
+
+// replyMaintenance tells the user the bot is unavailable. Callbacks are
+// answered with a toast so the button spinner stops.
+func (h *UpdateHandler) replyMaintenance(update tgbotapi.Update) error {
+	if update.CallbackQuery != nil {
+		_, err := h.api.Request(tgbotapi.NewCallbackWithAlert(update.CallbackQuery.ID, maintenanceText))
+		return err
+	}
+	msg := tgbotapi.NewMessage(update.Message.Chat.ID, maintenanceText)
+	_, err := h.api.Send(msg)
+	return err
+}
+
+// handleCallback passes a callback to the callback handler unless it is a
+// repeated tap or the user already has too many callbacks in flight
+func (h *UpdateHandler) handleCallback(ctx context.Context, query *tgbotapi.CallbackQuery, user *database.User) error {
+	switch h.throttle.Acquire(query.From.ID, query.Data) {
+	case middleware.Debounced:
+		_, err := h.api.Request(tgbotapi.NewCallback(query.ID, "⏳ Уже обрабатываю..."))
+		return err
+	case middleware.Throttled:
+		_, err := h.api.Request(tgbotapi.NewCallback(query.ID, "⏳ Подождите, обрабатываю предыдущее действие"))
+		return err
+	}
+	defer h.throttle.Release(query.From.ID)
+
+	return h.callbackHandler.Handle(ctx, query, user)
+}
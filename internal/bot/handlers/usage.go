@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+	"github.com/vladimiradmaev/diabetes-helper/internal/services"
+)
+
+// handleUsage handles the admin-only /usage command with AI usage for the
+// last 7 days and the current month (UTC)
+func (h *CommandHandler) handleUsage(ctx context.Context, chatID int64) error {
+	now := time.Now().UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	tomorrow := today.AddDate(0, 0, 1)
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	week, err := h.deps.UsageSvc.GetTotals(ctx, today.AddDate(0, 0, -6), tomorrow)
+	if err != nil {
+		logger.Error("Failed to get AI usage", "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при получении расходов на AI")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+	month, err := h.deps.UsageSvc.GetTotals(ctx, monthStart, tomorrow)
+	if err != nil {
+		logger.Error("Failed to get AI usage", "error", err)
+		msg := tgbotapi.NewMessage(chatID, "Ошибка при получении расходов на AI")
+		_, sendErr := h.api.Send(msg)
+		return sendErr
+	}
+
+	text := "💰 Расходы на AI\n\n" +
+		formatUsageTotals("За 7 дней", week) + "\n" +
+		formatUsageTotals("С начала месяца", month)
+	msg := tgbotapi.NewMessage(chatID, text)
+	_, err = h.api.Send(msg)
+	return err
+}
+
+// SendMonthlyUsageReport sends every admin the AI usage of the previous UTC
+// month. The report is claimed in the database first, so it goes out once
+// per month no matter how often the job runs.
+func SendMonthlyUsageReport(ctx context.Context, api *tgbotapi.BotAPI, deps Dependencies) error {
+	if len(deps.Admins) == 0 {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	monthEnd := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	monthStart := monthEnd.AddDate(0, -1, 0)
+
+	claimed, err := deps.UsageSvc.ClaimMonthlyReport(ctx, monthStart)
+	if err != nil || !claimed {
+		return err
+	}
+
+	totals, err := deps.UsageSvc.GetTotals(ctx, monthStart, monthEnd)
+	if err != nil {
+		return err
+	}
+
+	text := "💰 Расходы на AI\n\n" + formatUsageTotals(monthStart.Format("01.2006"), totals)
+	deps.Admins.Notify(api, text)
+	logger.Info("Monthly usage report sent", "month", monthStart.Format("2006-01"))
+	return nil
+}
+
+// formatUsageTotals renders usage per provider and model with a grand total
+func formatUsageTotals(title string, totals []services.UsageTotal) string {
+	text := title + ":\n"
+	if len(totals) == 0 {
+		return text + "нет запросов\n"
+	}
+
+	var requests int
+	var cost float64
+	for _, t := range totals {
+		text += fmt.Sprintf("• %s/%s: %d запр., %d вх. + %d вых. токенов, $%.4f\n",
+			t.Provider, t.Model, t.Requests, t.InputTokens, t.OutputTokens, t.Cost)
+		requests += t.Requests
+		cost += t.Cost
+	}
+	return text + fmt.Sprintf("Итого: %d запр., $%.4f\n", requests, cost)
+}
@@ -1,7 +1,13 @@
 package keyboards
 
 import (
+	"fmt"
+	"time"
+
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/format"
+	"github.com/vladimiradmaev/diabetes-helper/internal/services"
 )
 
 // MainMenu creates the main menu keyboard
@@ -9,6 +15,19 @@ func MainMenu() tgbotapi.InlineKeyboardMarkup {
 	return tgbotapi.NewInlineKeyboardMarkup(
 		tgbotapi.NewInlineKeyboardRow(
 			tgbotapi.NewInlineKeyboardButtonData("🍽️ Анализ еды", "analyze_food"),
+			tgbotapi.NewInlineKeyboardButtonData("🔢 Ввести углеводы", "manual_carbs"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("💉 Записать укол", "log_injection"),
+			tgbotapi.NewInlineKeyboardButtonData("📋 Скопировать вчера", "copy_yesterday"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🩸 Сахар крови", "blood_sugar"),
+			tgbotapi.NewInlineKeyboardButtonData("🔁 Повторить результат", "last_result"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("📜 История анализов", "food_history"),
+			tgbotapi.NewInlineKeyboardButtonData("📈 История сахара", "bs_history"),
 		),
 		tgbotapi.NewInlineKeyboardRow(
 			tgbotapi.NewInlineKeyboardButtonData("⚙️ Настройки", "settings"),
@@ -17,16 +36,284 @@ func MainMenu() tgbotapi.InlineKeyboardMarkup {
 	)
 }
 
-// SettingsMenu creates the settings menu keyboard
-func SettingsMenu() tgbotapi.InlineKeyboardMarkup {
-	return tgbotapi.NewInlineKeyboardMarkup(
+// SettingsMenu creates the settings menu keyboard. Items of the profile
+// checklist that aren't set up get a button of their own at the top.
+func SettingsMenu(user *database.User, profile *services.ProfileCompleteness) tgbotapi.InlineKeyboardMarkup {
+	var keyboard tgbotapi.InlineKeyboardMarkup
+	if profile != nil {
+		for _, item := range profile.Items {
+			if !item.Done {
+				keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, tgbotapi.NewInlineKeyboardRow(
+					tgbotapi.NewInlineKeyboardButtonData("❌ Настроить: "+item.Name, item.Callback),
+				))
+			}
+		}
+	}
+	keyboard.InlineKeyboard = append(keyboard.InlineKeyboard,
 		tgbotapi.NewInlineKeyboardRow(
 			tgbotapi.NewInlineKeyboardButtonData("📊 Коэф. на ХЕ", "insulin_ratio"),
+			tgbotapi.NewInlineKeyboardButtonData("💧 Базальный профиль", "basal_rates"),
+		),
+	)
+	if user.RatioChangeAt != nil {
+		label := "🗓 Запланировано изменение с " + format.ShortDate(*user.RatioChangeAt, services.UserLocation(user), format.Default)
+		keyboard.InlineKeyboard = append(keyboard.InlineKeyboard,
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(label, "ratio_change"),
+			),
+		)
+	}
+
+	keyboard.InlineKeyboard = append(keyboard.InlineKeyboard,
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(correctionLabel(user), "correction_factor"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(
+				fmt.Sprintf("🩸 Единицы сахара: %s", services.GlucoseUnitLabel(user.GlucoseUnit)),
+				"toggle_glucose_unit"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(activeInsulinTimeLabel(user), "active_insulin_time"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(iobModelLabel(user), "toggle_iob_model"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(resultVerbosityLabel(user), "toggle_result_verbosity"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(breadUnitStepLabel(user), "toggle_bread_unit_step"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(carbsStepLabel(user), "toggle_carbs_step"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(measurementSystemLabel(user), "toggle_measurement_system"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(bolusTimingLabel(user), "toggle_bolus_timing"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(reanalyzeOnPrimaryLabel(user), "toggle_reanalyze_primary"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(archivePhotosLabel(user), "toggle_archive_photos"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(keepPromptsLabel(user), "toggle_keep_prompts"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(carbTargetLabel(user.DailyCarbTarget), "carb_target"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(basalReminderLabel(user), "basal_reminder"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(quietHoursLabel(user), "quiet_hours"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(notificationsLabel(user), "toggle_notifications"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(timezoneLabel(user.Timezone), "timezone"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(travelModeLabel(user), "travel_mode"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("💾 Сохранить настройки", "snapshot_save"),
+			tgbotapi.NewInlineKeyboardButtonData("♻️ Восстановить", "snapshots"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🔗 Поделиться", "share_settings"),
+			tgbotapi.NewInlineKeyboardButtonData("📥 Импорт по коду", "share_import"),
 		),
 		tgbotapi.NewInlineKeyboardRow(
 			tgbotapi.NewInlineKeyboardButtonData("◀️ Главное меню", "main_menu"),
 		),
 	)
+
+	return keyboard
+}
+
+func resultVerbosityLabel(user *database.User) string {
+	if services.CompactResults(user) {
+		return "📝 Результат анализа: краткий"
+	}
+	return "📝 Результат анализа: подробный"
+}
+
+func activeInsulinTimeLabel(user *database.User) string {
+	if user.ActiveInsulinTime <= 0 {
+		return "⏱️ Время активного инсулина: не задано"
+	}
+	return fmt.Sprintf("⏱️ Время активного инсулина: %d ч %02d мин", user.ActiveInsulinTime/60, user.ActiveInsulinTime%60)
+}
+
+func iobModelLabel(user *database.User) string {
+	if services.CurvedIOB(user) {
+		return "📉 Активный инсулин: по кривой"
+	}
+	return "📉 Активный инсулин: линейно"
+}
+
+func breadUnitStepLabel(user *database.User) string {
+	return fmt.Sprintf("🥖 Округление ХЕ: до %g", services.BreadUnitStep(user))
+}
+
+func carbsStepLabel(user *database.User) string {
+	if user.CarbsStep <= 0 {
+		return "🍞 Округление углеводов: выкл"
+	}
+	return fmt.Sprintf("🍞 Округление углеводов: до %g г", user.CarbsStep)
+}
+
+func measurementSystemLabel(user *database.User) string {
+	if services.ImperialUnits(user) {
+		return "📏 Оценка порций: унции и дюймы"
+	}
+	return "📏 Оценка порций: граммы и сантиметры"
+}
+
+func notificationsLabel(user *database.User) string {
+	if user.NotificationsPaused {
+		return "🔕 Напоминания: на паузе"
+	}
+	return "🔔 Напоминания: вкл"
+}
+
+func bolusTimingLabel(user *database.User) string {
+	if user.HideBolusTiming {
+		return "⏱ Когда колоть: выкл"
+	}
+	return "⏱ Когда колоть: вкл"
+}
+
+func reanalyzeOnPrimaryLabel(user *database.User) string {
+	if user.ReanalyzeOnPrimary {
+		return "🔁 Повтор на основной модели: вкл"
+	}
+	return "🔁 Повтор на основной модели: выкл"
+}
+
+func archivePhotosLabel(user *database.User) string {
+	if user.ArchivePhotos {
+		return "📷 Хранить фото: вкл"
+	}
+	return "📷 Хранить фото: выкл"
+}
+
+func keepPromptsLabel(user *database.User) string {
+	if user.KeepPrompts {
+		return "🧹 Убирать подсказки после ввода: выкл"
+	}
+	return "🧹 Убирать подсказки после ввода: вкл"
+}
+
+func carbTargetLabel(target float64) string {
+	if target <= 0 {
+		return "🎯 Цель по углеводам: не задана"
+	}
+	return "🎯 Цель по углеводам: " + format.Grams(target, 0, format.Default)
+}
+
+func basalReminderLabel(user *database.User) string {
+	if user.BasalReminderTime == "" {
+		return "⏰ Напоминание о базальном: выкл"
+	}
+	return fmt.Sprintf("⏰ Напоминание о базальном: %s, %.1f ед.", user.BasalReminderTime, user.BasalReminderUnits.Units())
+}
+
+func correctionLabel(user *database.User) string {
+	if user.CorrectionFactor <= 0 {
+		return "📈 Коррекция высокого сахара: выкл"
+	}
+	factor, target := user.CorrectionFactor, user.TargetBloodSugar
+	if user.GlucoseUnit == services.GlucoseUnitMgdl {
+		factor, target = services.MmolToMgdl(factor), services.MmolToMgdl(target)
+	}
+	return fmt.Sprintf("📈 ФЧИ %s, цель %s",
+		format.Glucose(factor, user.GlucoseUnit, format.Default), format.Glucose(target, user.GlucoseUnit, format.Default))
+}
+
+func quietHoursLabel(user *database.User) string {
+	if user.QuietHoursStart == "" {
+		return "🌙 Тихие часы: выкл"
+	}
+	return fmt.Sprintf("🌙 Тихие часы: %s–%s", user.QuietHoursStart, user.QuietHoursEnd)
+}
+
+func timezoneLabel(timezone string) string {
+	if timezone == "" {
+		return "🌍 Часовой пояс: время сервера"
+	}
+	return fmt.Sprintf("🌍 Часовой пояс: %s", timezone)
+}
+
+// InjectionSiteMenu creates the keyboard for choosing an injection site
+func InjectionSiteMenu() tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i := 0; i < len(services.InjectionSites); i += 2 {
+		row := tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(services.InjectionSites[i].Name, "inj_site_"+services.InjectionSites[i].ID),
+		)
+		if i+1 < len(services.InjectionSites) {
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData(services.InjectionSites[i+1].Name, "inj_site_"+services.InjectionSites[i+1].ID))
+		}
+		rows = append(rows, row)
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("⏭️ Не указывать", "inj_site_skip"),
+	))
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// InjectionLoggedMenu creates the keyboard shown after an injection was logged
+func InjectionLoggedMenu() tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("📍 Места уколов за 30 дней", "injection_sites"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("◀️ Главное меню", "main_menu"),
+		),
+	)
+}
+
+func travelModeLabel(user *database.User) string {
+	if !services.TravelModeActive(user, time.Now()) {
+		return "✈️ Режим путешествия: выкл"
+	}
+	return fmt.Sprintf("✈️ Режим путешествия: %s", user.TravelTimezone)
+}
+
+// TravelModeMenu creates the keyboard shown while travel mode is active
+func TravelModeMenu() tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🏠 Завершить путешествие", "travel_mode_off"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("◀️ Назад", "settings"),
+		),
+	)
+}
+
+// SnapshotsMenu creates the keyboard listing settings snapshots to restore
+func SnapshotsMenu(snapshots []database.SettingsSnapshot, loc *time.Location) tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, snapshot := range snapshots {
+		label := fmt.Sprintf("♻️ %s (%s %s)", snapshot.Name,
+			format.Date(snapshot.CreatedAt, loc, format.Default), format.Clock(snapshot.CreatedAt, loc, format.Default))
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(label, fmt.Sprintf("snapshot_restore_%d", snapshot.ID)),
+		))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("◀️ Назад", "settings"),
+	))
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
 }
 
 // InsulinRatioMenu creates the insulin ratio management keyboard
@@ -34,6 +321,8 @@ func InsulinRatioMenu(hasRatios bool) tgbotapi.InlineKeyboardMarkup {
 	keyboard := tgbotapi.NewInlineKeyboardMarkup(
 		tgbotapi.NewInlineKeyboardRow(
 			tgbotapi.NewInlineKeyboardButtonData("➕ Добавить", "add_insulin_ratio"),
+			tgbotapi.NewInlineKeyboardButtonData("📥 Списком", "ratio_import"),
+			tgbotapi.NewInlineKeyboardButtonData("📋 Шаблоны", "ratio_presets"),
 		),
 	)
 
@@ -43,6 +332,61 @@ func InsulinRatioMenu(hasRatios bool) tgbotapi.InlineKeyboardMarkup {
 				tgbotapi.NewInlineKeyboardButtonData("✏️ Изменить", "edit_insulin_ratio"),
 				tgbotapi.NewInlineKeyboardButtonData("🗑️ Удалить", "delete_insulin_ratio"),
 			),
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("🖼️ Картинкой", "ratio_schedule_image"),
+			),
+		)
+	}
+
+	keyboard.InlineKeyboard = append(keyboard.InlineKeyboard,
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🗓 Запланировать смену", "schedule_ratio_change"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("◀️ Назад", "settings"),
+		),
+	)
+
+	return keyboard
+}
+
+// GlucoseUnitConfirm creates the keyboard asking whether a suspicious glucose value
+// should be converted from the other unit
+func GlucoseUnitConfirm() tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🔄 Да, конвертировать", "bs_unit_convert"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✅ Нет, сохранить как есть", "bs_unit_keep"),
+			tgbotapi.NewInlineKeyboardButtonData("❌ Отмена", "bs_unit_cancel"),
+		),
+	)
+}
+
+// MeterReadingConfirm creates the keyboard confirming a blood sugar value read from a meter photo
+func MeterReadingConfirm() tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✅ Сохранить замер", "bs_unit_keep"),
+			tgbotapi.NewInlineKeyboardButtonData("❌ Не сохранять", "bs_unit_cancel"),
+		),
+	)
+}
+
+// BasalMenu creates the basal schedule management keyboard
+func BasalMenu(hasRates bool) tgbotapi.InlineKeyboardMarkup {
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("➕ Добавить", "add_basal_rate"),
+		),
+	)
+
+	if hasRates {
+		keyboard.InlineKeyboard = append(keyboard.InlineKeyboard,
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("🗑️ Удалить", "delete_basal_rates"),
+			),
 		)
 	}
 
@@ -54,3 +398,30 @@ func InsulinRatioMenu(hasRatios bool) tgbotapi.InlineKeyboardMarkup {
 
 	return keyboard
 }
+
+// RatioConfirm asks whether to save a ratio outside the typical range
+func RatioConfirm() tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✅ Да, сохранить", "ratio_confirm"),
+			tgbotapi.NewInlineKeyboardButtonData("✏️ Ввести заново", "ratio_reenter"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("◀️ Отмена", "insulin_ratio"),
+		),
+	)
+}
+
+// RatioPresetsMenu creates the keyboard listing built-in ratio presets
+func RatioPresetsMenu() tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, p := range services.RatioPresets {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(p.Name, "ratio_preset_"+p.ID),
+		))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("◀️ Назад", "insulin_ratio"),
+	))
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
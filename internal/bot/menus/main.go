@@ -2,15 +2,18 @@ package menus
 
 import (
 	"fmt"
-	"time"
+	"strings"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/vladimiradmaev/diabetes-helper/internal/bot/keyboards"
 	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/services"
+	"github.com/vladimiradmaev/diabetes-helper/internal/utils"
 )
 
-// SendMainMenu sends the main menu to a chat
-func SendMainMenu(api *tgbotapi.BotAPI, chatID int64) error {
+// SendMainMenu sends the main menu to a chat. A non-empty status is shown
+// above the list of actions (e.g. today's carb progress).
+func SendMainMenu(api *tgbotapi.BotAPI, chatID int64, status string) error {
 	text := `🤖 *ДиаАИ* — твой помощник для управления диабетом
 
 🍽️ Отправь фото еды, и я:
@@ -24,7 +27,11 @@ func SendMainMenu(api *tgbotapi.BotAPI, chatID int64) error {
 
 ⚠️ *Важно:* Это справочная информация, всегда консультируйтесь с врачом!
 
-Выберите действие:`
+`
+	if status != "" {
+		text += status + "\n\n"
+	}
+	text += "Выберите действие:"
 
 	msg := tgbotapi.NewMessage(chatID, text)
 	msg.ParseMode = "Markdown"
@@ -33,29 +40,44 @@ func SendMainMenu(api *tgbotapi.BotAPI, chatID int64) error {
 	return err
 }
 
-// SendSettingsMenu sends the settings menu to a chat
-func SendSettingsMenu(api *tgbotapi.BotAPI, chatID int64) error {
-	msg := tgbotapi.NewMessage(chatID, "Настройки:")
-	msg.ReplyMarkup = keyboards.SettingsMenu()
+// SendSettingsMenu sends the settings menu to a chat. The profile checklist
+// is shown above it unless profile is nil.
+func SendSettingsMenu(api *tgbotapi.BotAPI, chatID int64, user *database.User, profile *services.ProfileCompleteness) error {
+	text := "Настройки:"
+	if profile != nil {
+		text = profileChecklist(profile) + "\n\n" + text
+	}
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyMarkup = keyboards.SettingsMenu(user, profile)
 	_, err := api.Send(msg)
 	return err
 }
 
+// profileChecklist renders the profile items in one line, e.g.
+// "✅ коэффициенты на ХЕ, ❌ часовой пояс — 1 из 2"
+func profileChecklist(profile *services.ProfileCompleteness) string {
+	items := make([]string, len(profile.Items))
+	for i, item := range profile.Items {
+		mark := "❌"
+		if item.Done {
+			mark = "✅"
+		}
+		items[i] = mark + " " + item.Name
+	}
+	return fmt.Sprintf("👤 Профиль: %s — %d из %d", strings.Join(items, ", "), profile.Done(), len(profile.Items))
+}
+
 // SendInsulinRatioMenu sends the insulin ratio management menu
 func SendInsulinRatioMenu(api *tgbotapi.BotAPI, chatID int64, ratios []database.InsulinRatio) error {
 	var text string
 	if len(ratios) == 0 {
-		text = "У вас пока нет сохраненных коэффициентов. Нажмите 'Добавить' чтобы создать новый."
+		text = "У вас пока нет сохраненных коэффициентов. Нажмите 'Добавить' чтобы создать новый " +
+			"или выберите один из 'Шаблонов' как отправную точку."
 	} else {
 		// Calculate total hours
 		totalMinutes := 0
 		for _, r := range ratios {
-			start := timeToMinutes(r.StartTime)
-			end := timeToMinutes(r.EndTime)
-			if end < start {
-				end += 24 * 60 // Handle periods crossing midnight
-			}
-			totalMinutes += end - start
+			totalMinutes += utils.PeriodMinutes(r.StartTime, r.EndTime)
 		}
 		totalHours := float64(totalMinutes) / 60.0
 
@@ -83,8 +105,35 @@ func SendInsulinRatioMenu(api *tgbotapi.BotAPI, chatID int64, ratios []database.
 	return err
 }
 
-// Helper function to convert time string to minutes since midnight
-func timeToMinutes(timeStr string) int {
-	t, _ := time.Parse("15:04", timeStr)
-	return t.Hour()*60 + t.Minute()
+// SendBasalMenu sends the basal schedule with its management keyboard
+func SendBasalMenu(api *tgbotapi.BotAPI, chatID int64, rates []database.BasalRate) error {
+	var text string
+	if len(rates) == 0 {
+		text = "💧 Базальный профиль помпы пока не задан. Нажмите 'Добавить', чтобы задать скорость " +
+			"для периода времени. Суточная доза базала будет учитываться в статистике."
+	} else {
+		totalMinutes := 0
+		for _, r := range rates {
+			totalMinutes += utils.PeriodMinutes(r.StartTime, r.EndTime)
+		}
+		totalHours := float64(totalMinutes) / 60.0
+
+		text = "💧 Ваш базальный профиль:\n\n"
+		for _, r := range rates {
+			text += fmt.Sprintf("🕒 %s - %s: %.2f ед/ч\n", r.StartTime, r.EndTime, r.Rate)
+		}
+		text += fmt.Sprintf("\nВ сутки: %.1f ед\n", services.DailyBasal(rates).Units())
+
+		if totalHours < 24 {
+			text += fmt.Sprintf("⚠️ Внимание: задано только %.1f часов из 24\n", totalHours)
+			text += "Добавьте еще периоды, чтобы покрыть все 24 часа\n"
+		} else {
+			text += "✅ Периоды полностью покрывают 24 часа\n"
+		}
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyMarkup = keyboards.BasalMenu(len(rates) > 0)
+	_, err := api.Send(msg)
+	return err
 }
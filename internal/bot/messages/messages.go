@@ -0,0 +1,59 @@
+// Package messages holds the user-facing texts of the bot commands. The /help
+// command and the help button of the menus both send them from here.
+package messages
+
+// Help lists the commands, sent by /help
+const Help = `Доступные команды:
+/start - Показать главное меню
+/help - Показать это сообщение
+/last - Повторить результат последнего анализа
+/stats - Статистика за последние 7 дней
+/sites - Места уколов за 30 дней
+/schedule - Коэффициенты на ХЕ картинкой
+/insulin - Инсулин по дням за 14 дней картинкой
+/iob - Активный инсулин сейчас
+/pause - Поставить напоминания и другие автоматические сообщения на паузу
+/resume - Снова присылать напоминания
+/low <сахар> - Сколько быстрых углеводов съесть при низком сахаре
+/low_rule <г> <подъем> [цель] - Ваше правило: сколько граммов поднимают сахар и на сколько
+/insights - Продукты, после которых сахар растет сильнее всего
+/search <продукт> - Найти анализы с продуктом, например /search гречка
+/status - Работает ли сейчас анализ еды
+/export - Выгрузить всю историю в CSV или в формате Tidepool
+/api_token - Токен для доступа к API (часы, виджеты)
+/transfer - Код для переноса истории на другой аккаунт Telegram
+/claim <код> - Перенести историю на этот аккаунт
+
+Как указать вес блюда:
+1. Нажмите кнопку "🍽️ Анализ еды"
+2. Отправьте фото еды
+3. В подписи к фото напишите только число - вес в граммах
+Пример: "150" или "200"
+
+В подписи можно написать и состав блюда, например:
+"Овсянка 60г, молоко 200мл, банан 1 шт, вес порции 350"
+Состав поможет точнее посчитать углеводы, а вес берется после слова "вес" или из последней строки с одним числом.
+
+Если вес не указан, бот попробует оценить его автоматически.`
+
+// Guide explains how to use the bot, sent by the help button. It is
+// formatted as Markdown.
+const Guide = `🤖 *Справка по использованию бота*
+
+*🍽️ Анализ еды:*
+• Отправьте фото блюда
+• В подписи можете указать вес в граммах (например: "150")
+• Если вес не указан, ИИ попробует определить его самостоятельно, но результат может быть менее точным
+• Получите информацию об углеводах, ХЕ и дозе инсулина
+
+*⚙️ Настройки:*
+• Установите коэффициенты инсулина на ХЕ для разного времени суток
+• Это повысит точность расчета дозы инсулина
+
+*💡 Советы:*
+• Указывайте точный вес блюда для наиболее точного расчета
+• Настройте коэффициенты для персонализированных рекомендаций
+• Всегда консультируйтесь с врачом!`
+
+// UnknownCommand answers a command the bot doesn't have
+const UnknownCommand = "Неизвестная команда. Используйте /help для просмотра доступных команд."
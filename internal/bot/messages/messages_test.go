@@ -0,0 +1,57 @@
+package messages
+
+import (
+	"strings"
+	"testing"
+	"unicode"
+	"unicode/utf8"
+)
+
+func TestTextsAreReadable(t *testing.T) {
+	texts := []struct {
+		name     string
+		text     string
+		contains []string
+	}{
+		{"help", Help, []string{"/start - Показать главное меню", "/help - Показать это сообщение"}},
+		{"guide", Guide, []string{"Справка по использованию бота"}},
+		{"unknown command", UnknownCommand, []string{"/help"}},
+	}
+
+	for _, tt := range texts {
+		t.Run(tt.name, func(t *testing.T) {
+			if !utf8.ValidString(tt.text) {
+				t.Fatal("text is not valid UTF-8")
+			}
+			if strings.ContainsRune(tt.text, utf8.RuneError) {
+				t.Error("text contains the replacement character")
+			}
+			// Russian read as another encoding turns into Latin letters with
+			// diacritics, e.g. "–î–æ—Å—Ç—É–ø"
+			for _, r := range tt.text {
+				if r >= 0xC0 && r <= 0x24F {
+					t.Fatalf("text contains %q, which looks like mis-decoded Russian", r)
+				}
+			}
+			if !strings.ContainsFunc(tt.text, func(r rune) bool { return unicode.Is(unicode.Cyrillic, r) }) {
+				t.Error("text has no Cyrillic letters")
+			}
+			for _, want := range tt.contains {
+				if !strings.Contains(tt.text, want) {
+					t.Errorf("text doesn't contain %q", want)
+				}
+			}
+		})
+	}
+}
+
+func TestHelpDescribesEveryCommand(t *testing.T) {
+	for _, line := range strings.Split(Help, "\n") {
+		if !strings.HasPrefix(line, "/") {
+			continue
+		}
+		if _, description, ok := strings.Cut(line, " - "); !ok || strings.TrimSpace(description) == "" {
+			t.Errorf("command line %q has no description", line)
+		}
+	}
+}
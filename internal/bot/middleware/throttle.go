@@ -0,0 +1,115 @@
+// Package middleware contains checks applied to updates before they reach
+// the handlers.
+package middleware
+
+import (
+	"sync"
+	"time"
+
+	"github.com/vladimiradmaev/diabetes-helper/internal/metrics"
+)
+
+const (
+	// DefaultDebounceWindow is how long an identical callback from the same
+	// user is ignored after the previous one
+	DefaultDebounceWindow = 700 * time.Millisecond
+	// DefaultMaxConcurrent is how many callbacks of one user may be handled at once
+	DefaultMaxConcurrent = 2
+
+	// pruneThreshold is the number of tracked users above which stale entries are dropped
+	pruneThreshold = 10000
+)
+
+var (
+	callbacksDebounced = metrics.NewCounter("bot_callbacks_debounced_total", "Callbacks ignored as duplicates of a recent identical callback")
+	callbacksThrottled = metrics.NewCounter("bot_callbacks_throttled_total", "Callbacks rejected because the user had too many in flight")
+	callbacksInFlight  = metrics.NewGauge("bot_callbacks_in_flight", "Callbacks currently being handled")
+)
+
+// Decision is the outcome of CallbackThrottle.Acquire
+type Decision int
+
+const (
+	Allowed Decision = iota
+	Debounced
+	Throttled
+)
+
+type userCallbacks struct {
+	lastData string
+	lastAt   time.Time
+	active   int
+}
+
+// CallbackThrottle debounces repeated taps on the same inline button and
+// limits how many callbacks of one user are processed concurrently
+type CallbackThrottle struct {
+	window        time.Duration
+	maxConcurrent int
+
+	mu    sync.Mutex
+	users map[int64]*userCallbacks
+}
+
+// NewCallbackThrottle creates a throttle with the given debounce window and per-user concurrency limit
+func NewCallbackThrottle(window time.Duration, maxConcurrent int) *CallbackThrottle {
+	return &CallbackThrottle{
+		window:        window,
+		maxConcurrent: maxConcurrent,
+		users:         make(map[int64]*userCallbacks),
+	}
+}
+
+// Acquire decides whether a callback may be handled. When the decision is
+// Allowed the caller must call Release once handling finishes.
+func (t *CallbackThrottle) Acquire(userID int64, data string) Decision {
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	u, ok := t.users[userID]
+	if !ok {
+		if len(t.users) >= pruneThreshold {
+			t.prune(now)
+		}
+		u = &userCallbacks{}
+		t.users[userID] = u
+	}
+
+	if data == u.lastData && now.Sub(u.lastAt) < t.window {
+		callbacksDebounced.Inc()
+		return Debounced
+	}
+	if u.active >= t.maxConcurrent {
+		callbacksThrottled.Inc()
+		return Throttled
+	}
+
+	u.lastData = data
+	u.lastAt = now
+	u.active++
+	callbacksInFlight.Inc()
+	return Allowed
+}
+
+// Release marks an allowed callback of the user as finished
+func (t *CallbackThrottle) Release(userID int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if u, ok := t.users[userID]; ok && u.active > 0 {
+		u.active--
+		callbacksInFlight.Dec()
+	}
+}
+
+// prune drops idle users whose last callback is outside the debounce window.
+// Must be called with t.mu held.
+func (t *CallbackThrottle) prune(now time.Time) {
+	for id, u := range t.users {
+		if u.active == 0 && now.Sub(u.lastAt) >= t.window {
+			delete(t.users, id)
+		}
+	}
+}
@@ -6,18 +6,50 @@ import "sync"
 type StateManager interface {
 	SetUserState(userID int64, state string)
 	GetUserState(userID int64) string
+	ClearUserState(userID int64)
 	SetTempData(userID int64, key string, value interface{})
 	GetTempData(userID int64, key string) (interface{}, bool)
 	ClearTempData(userID int64)
 	SetUserWeight(userID int64, weight float64)
 	GetUserWeight(userID int64) float64
+	ClearUserWeight(userID int64)
+	// Close releases the manager's resources; it must not be used afterwards
+	Close() error
 }
 
+// Both managers implement the full contract
+var (
+	_ StateManager = (*InMemoryManager)(nil)
+	_ StateManager = (*RedisManager)(nil)
+)
+
 // User states constants
 const (
-	None                   = "none"
-	WaitingForInsulinRatio = "waiting_for_insulin_ratio"
-	WaitingForTimePeriod   = "waiting_for_time_period"
+	None                    = "none"
+	WaitingForInsulinRatio  = "waiting_for_insulin_ratio"
+	WaitingForTimePeriod    = "waiting_for_time_period"
+	WaitingForBloodSugar    = "waiting_for_blood_sugar"
+	WaitingForCarbTarget    = "waiting_for_carb_target"
+	WaitingForTimezone      = "waiting_for_timezone"
+	WaitingForInjection     = "waiting_for_injection"
+	WaitingForTravelMode    = "waiting_for_travel_mode"
+	WaitingForManualCarbs   = "waiting_for_manual_carbs"
+	WaitingForSnapshotName  = "waiting_for_snapshot_name"
+	WaitingForBasalPeriod   = "waiting_for_basal_period"
+	WaitingForBasalRate     = "waiting_for_basal_rate"
+	WaitingForRatioImport   = "waiting_for_ratio_import"
+	WaitingForItemCarbs     = "waiting_for_item_carbs"
+	WaitingForTotalCarbs    = "waiting_for_total_carbs"
+	WaitingForBasalReminder = "waiting_for_basal_reminder"
+	WaitingForQuietHours    = "waiting_for_quiet_hours"
+
+	WaitingForRatioEdit           = "waiting_for_ratio_edit"
+	WaitingForRatioChangeDate     = "waiting_for_ratio_change_date"
+	WaitingForRatioChangeSchedule = "waiting_for_ratio_change_schedule"
+	WaitingForShareCode           = "waiting_for_share_code"
+	WaitingForCorrectionFactor    = "waiting_for_correction_factor"
+	WaitingForActiveInsulinTime   = "waiting_for_active_insulin_time"
+	WaitingForFoodWeight          = "waiting_for_food_weight"
 )
 
 // InMemoryManager manages user states and temporary data in memory
@@ -69,7 +101,7 @@ func (m *InMemoryManager) SetUserWeight(userID int64, weight float64) {
 	m.userWeights[userID] = weight
 }
 
-// GetUserWeight gets the weight for a user - адаптирую под интерфейс
+// GetUserWeight gets the weight for a user
 func (m *InMemoryManager) GetUserWeight(userID int64) float64 {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
@@ -115,3 +147,8 @@ func (m *InMemoryManager) ClearTempData(userID int64) {
 	defer m.mu.Unlock()
 	delete(m.tempData, userID)
 }
+
+// Close is a no-op; the in-memory manager holds no external resources
+func (m *InMemoryManager) Close() error {
+	return nil
+}
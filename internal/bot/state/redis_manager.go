@@ -59,6 +59,13 @@ func (m *RedisManager) GetUserState(userID int64) string {
 	return result.Val()
 }
 
+// ClearUserState clears the state for a user
+func (m *RedisManager) ClearUserState(userID int64) {
+	ctx := context.Background()
+	key := fmt.Sprintf("user:%d:state", userID)
+	m.client.Del(ctx, key)
+}
+
 // SetTempData sets temporary data for a user
 func (m *RedisManager) SetTempData(userID int64, key string, value interface{}) {
 	// Get current temp data
@@ -111,6 +118,13 @@ func (m *RedisManager) GetUserWeight(userID int64) float64 {
 	return weight
 }
 
+// ClearUserWeight clears the weight for a user
+func (m *RedisManager) ClearUserWeight(userID int64) {
+	ctx := context.Background()
+	key := fmt.Sprintf("user:%d:weight", userID)
+	m.client.Del(ctx, key)
+}
+
 // Close closes the Redis connection
 func (m *RedisManager) Close() error {
 	return m.client.Close()
@@ -1,6 +1,7 @@
 package bot
 
 import (
+	"github.com/vladimiradmaev/diabetes-helper/internal/bot/handlers"
 	"github.com/vladimiradmaev/diabetes-helper/internal/interfaces"
 )
 
@@ -10,4 +11,19 @@ type Dependencies struct {
 	FoodAnalysisSvc interfaces.FoodAnalysisServiceInterface
 	BloodSugarSvc   interfaces.BloodSugarServiceInterface
 	InsulinSvc      interfaces.InsulinServiceInterface
+	InjectionSvc    interfaces.InjectionServiceInterface
+	BasalSvc        interfaces.BasalServiceInterface
+	SnapshotSvc     interfaces.SettingsSnapshotServiceInterface
+	StatsSvc        interfaces.StatsServiceInterface
+	EventSvc        interfaces.EventServiceInterface
+	UsageSvc        interfaces.UsageServiceInterface
+	JobSvc          interfaces.JobServiceInterface
+	ChartSvc        interfaces.ChartServiceInterface
+	APITokenSvc     interfaces.APITokenServiceInterface
+	InsightsSvc     interfaces.InsightsServiceInterface
+	SupportSvc      interfaces.SupportServiceInterface
+	TransferSvc     interfaces.TransferServiceInterface
+	Flags           interfaces.FeatureFlagsInterface
+	Telemetry       interfaces.TelemetryInterface
+	Admins          handlers.Admins
 }
@@ -0,0 +1,47 @@
+// Package buildinfo identifies the running build. The values are set at
+// build time with
+//
+//	go build -ldflags "-X github.com/vladimiradmaev/diabetes-helper/internal/buildinfo.Version=1.4.0 \
+//	  -X github.com/vladimiradmaev/diabetes-helper/internal/buildinfo.Commit=$(git rev-parse --short HEAD) \
+//	  -X github.com/vladimiradmaev/diabetes-helper/internal/buildinfo.Date=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Without them the commit and its date are taken from the VCS information Go
+// embeds when building inside a git checkout, if available.
+package buildinfo
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+const unknown = "unknown"
+
+var (
+	Version = "dev"
+	Commit  = unknown
+	Date    = unknown // UTC build time, RFC 3339
+)
+
+func init() {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return
+	}
+	for _, s := range info.Settings {
+		switch {
+		case s.Key == "vcs.revision" && Commit == unknown:
+			Commit = s.Value
+			if len(Commit) > 7 {
+				Commit = Commit[:7]
+			}
+		case s.Key == "vcs.time" && Date == unknown:
+			Date = s.Value
+		}
+	}
+}
+
+// String returns the version with its commit and build date,
+// e.g. "1.4.0 (abc1234, 2025-06-12T10:00:00Z)"
+func String() string {
+	return fmt.Sprintf("%s (%s, %s)", Version, Commit, Date)
+}
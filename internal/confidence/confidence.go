@@ -0,0 +1,162 @@
+// Package confidence maps the AI's textual confidence to stored scores and
+// back to user-facing wording. All conversions go through the same Config,
+// so a "medium" answer is always displayed as medium.
+package confidence
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Level is a discrete confidence level
+type Level int
+
+const (
+	Low Level = iota
+	Medium
+	High
+)
+
+// Parse converts the AI's "high"/"medium"/"low" answer to a level.
+// Anything unrecognized is treated as Low.
+func Parse(s string) Level {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "high":
+		return High
+	case "medium":
+		return Medium
+	default:
+		return Low
+	}
+}
+
+// String returns the level name as used in the AI response
+func (l Level) String() string {
+	switch l {
+	case High:
+		return "high"
+	case Medium:
+		return "medium"
+	default:
+		return "low"
+	}
+}
+
+// Config holds the numeric score stored for each level and its wording
+type Config struct {
+	Scores [3]float64 // Indexed by Level: low, medium, high
+	Labels [3]string  // Indexed by Level: low, medium, high
+}
+
+// DefaultConfig returns the built-in mapping
+func DefaultConfig() Config {
+	return Config{
+		Scores: [3]float64{0.3, 0.6, 0.9},
+		Labels: [3]string{"низкая", "средняя", "высокая"},
+	}
+}
+
+// Validate checks that scores are within (0, 1] and strictly increasing
+func (c Config) Validate() error {
+	for i, score := range c.Scores {
+		if score <= 0 || score > 1 {
+			return fmt.Errorf("confidence score for %s must be in (0, 1], got %v", Level(i), score)
+		}
+		if i > 0 && score <= c.Scores[i-1] {
+			return fmt.Errorf("confidence scores must be increasing from low to high")
+		}
+	}
+	for i, label := range c.Labels {
+		if label == "" {
+			return fmt.Errorf("confidence label for %s cannot be empty", Level(i))
+		}
+	}
+	return nil
+}
+
+// Score returns the stored score for a level
+func (c Config) Score(l Level) float64 {
+	return c.Scores[l]
+}
+
+// LevelOf returns the level of a stored score. The cutoffs are the midpoints
+// between neighbouring scores, so each level's own score always maps back to it
+// and scores written with an older mapping land on the nearest level.
+func (c Config) LevelOf(score float64) Level {
+	switch {
+	case score >= (c.Scores[Medium]+c.Scores[High])/2:
+		return High
+	case score >= (c.Scores[Low]+c.Scores[Medium])/2:
+		return Medium
+	default:
+		return Low
+	}
+}
+
+// Label returns the user-facing wording of a level
+func (c Config) Label(l Level) string {
+	return c.Labels[l]
+}
+
+// ParseScores parses "low,medium,high" scores, e.g. "0.3,0.6,0.9"
+func ParseScores(s string) ([3]float64, error) {
+	var scores [3]float64
+	parts := strings.Split(s, ",")
+	if len(parts) != 3 {
+		return scores, fmt.Errorf("expected 3 comma-separated scores, got %d", len(parts))
+	}
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return scores, fmt.Errorf("invalid score %q: %w", part, err)
+		}
+		scores[i] = v
+	}
+	return scores, nil
+}
+
+// ParseLabels parses "low,medium,high" labels, e.g. "низкая,средняя,высокая"
+func ParseLabels(s string) ([3]string, error) {
+	var labels [3]string
+	parts := strings.Split(s, ",")
+	if len(parts) != 3 {
+		return labels, fmt.Errorf("expected 3 comma-separated labels, got %d", len(parts))
+	}
+	for i, part := range parts {
+		labels[i] = strings.TrimSpace(part)
+	}
+	return labels, nil
+}
+
+var (
+	currentMu sync.RWMutex
+	current   = DefaultConfig()
+)
+
+// Configure replaces the process-wide configuration; call it once at startup
+func Configure(c Config) {
+	currentMu.Lock()
+	defer currentMu.Unlock()
+	current = c
+}
+
+// Current returns the process-wide configuration
+func Current() Config {
+	currentMu.RLock()
+	defer currentMu.RUnlock()
+	return current
+}
+
+// Score returns the stored score for the AI's textual confidence
+func Score(s string) float64 {
+	c := Current()
+	return c.Score(Parse(s))
+}
+
+// Label returns the user-facing wording for a stored score
+func Label(score float64) string {
+	c := Current()
+	return c.Label(c.LevelOf(score))
+}
@@ -0,0 +1,74 @@
+package confidence
+
+import "testing"
+
+func TestLabelMatchesStoredScore(t *testing.T) {
+	configs := []struct {
+		name string
+		cfg  Config
+	}{
+		{"default", DefaultConfig()},
+		{"custom", Config{Scores: [3]float64{0.5, 0.8, 1}, Labels: [3]string{"мало", "так себе", "точно"}}},
+	}
+
+	for _, tc := range configs {
+		t.Run(tc.name, func(t *testing.T) {
+			Configure(tc.cfg)
+			defer Configure(DefaultConfig())
+
+			for _, answer := range []string{"low", "medium", "high", " High ", "unknown"} {
+				want := tc.cfg.Labels[Parse(answer)]
+				if got := Label(Score(answer)); got != want {
+					t.Errorf("Label(Score(%q)) = %q, want %q", answer, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLevelOf(t *testing.T) {
+	cfg := DefaultConfig()
+	tests := []struct {
+		score float64
+		want  Level
+	}{
+		{0, Low},
+		{0.3, Low},
+		{0.44, Low},
+		{0.45, Medium},
+		{0.6, Medium},
+		{0.74, Medium},
+		{0.75, High},
+		{0.8, High}, // Old cutoff for high
+		{0.9, High},
+		{1, High},
+	}
+
+	for _, tt := range tests {
+		if got := cfg.LevelOf(tt.score); got != tt.want {
+			t.Errorf("LevelOf(%v) = %s, want %s", tt.score, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr bool
+	}{
+		{"default", DefaultConfig(), false},
+		{"not increasing", Config{Scores: [3]float64{0.3, 0.3, 0.9}, Labels: DefaultConfig().Labels}, true},
+		{"above one", Config{Scores: [3]float64{0.3, 0.6, 1.1}, Labels: DefaultConfig().Labels}, true},
+		{"zero", Config{Scores: [3]float64{0, 0.6, 0.9}, Labels: DefaultConfig().Labels}, true},
+		{"empty label", Config{Scores: DefaultConfig().Scores, Labels: [3]string{"низкая", "", "высокая"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -3,18 +3,204 @@ package config
 import (
 	"fmt"
 	"net"
+	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/vladimiradmaev/diabetes-helper/internal/confidence"
+	"github.com/vladimiradmaev/diabetes-helper/internal/dosing"
 	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
 )
 
 type Config struct {
 	TelegramToken string
 	GeminiAPIKey  string
-	DB            DBConfig
-	Logger        LoggerConfig
+	// OpenAIAPIKey enables the OpenAI fallback for when Gemini is over quota
+	// or down; empty disables it
+	OpenAIAPIKey string
+	DB           DBConfig
+	Logger       LoggerConfig
+	MetricsAddr  string // Address for the /metrics and /health endpoints; empty disables them
+	Confidence   confidence.Config
+
+	// AdminTelegramIDs may run operator commands such as /maintenance
+	AdminTelegramIDs []int64
+
+	// InjectionSiteRepeatLimit is how many injections in a row into one site trigger a rotation warning
+	InjectionSiteRepeatLimit int
+
+	// RatioBounds is the range of typical ratios; values outside it need confirmation
+	RatioBounds RatioBounds
+
+	// BolusTiming holds the glucose levels (mmol/L) that shift the injection timing hint
+	BolusTiming dosing.TimingConfig
+
+	// CorrectionBand is how far glucose may be from target (mmol/L) before
+	// the dose corrects it; 0 corrects any rise
+	CorrectionBand float64
+
+	// ModelPrices are AI prices by model name, used to estimate usage cost
+	ModelPrices map[string]ModelPrice
+
+	// UsageMonthlyReport sends admins a summary of the previous month's AI usage
+	UsageMonthlyReport bool
+
+	// APIAddr is where the personal HTTP API listens; empty disables it
+	APIAddr string
+	// APIRateLimit is how many API requests a user or client address may make per minute
+	APIRateLimit int
+
+	// EventWebhookURL receives every saved analysis, glucose reading and
+	// injection as JSON; empty disables it
+	EventWebhookURL string
+
+	// ExportDir is the scratch directory where exports are generated
+	ExportDir string
+
+	// PortionReferences are the typical portions the weight estimation prompt
+	// refers to, read from PORTION_REFERENCES_FILE; empty uses the built-in
+	// Russian/European ones
+	PortionReferences string
+
+	Storage StorageConfig
+
+	Telemetry TelemetryConfig
+
+	AIQueue AIQueueConfig
+
+	ConversationLog ConversationLogConfig
+}
+
+// ConversationLogConfig controls the optional log of both directions of
+// users' conversations with the bot, for debugging wrong replies
+type ConversationLogConfig struct {
+	Enabled   bool
+	Retention time.Duration // Entries older than this are deleted
+	FullText  bool          // Keep message texts; otherwise only their kind, key and numbers
+}
+
+// AIQueueConfig limits how many photos are sent to the AI provider; photos
+// over the limit wait in a queue instead of being refused
+type AIQueueConfig struct {
+	PerMinute int // Analyses started per minute across all users; 0 disables the limit
+	MaxDepth  int // Queued photos beyond which new ones are refused
+}
+
+// TelemetryConfig controls the optional weekly anonymous usage report
+type TelemetryConfig struct {
+	Enabled  bool
+	Endpoint string // URL the report is POSTed to
+}
+
+// RatioBounds is the range of ratios (units per bread unit) considered typical
+type RatioBounds struct {
+	Low  float64
+	High float64
+}
+
+// DefaultRatioBounds returns the typical ratio range used unless configured
+func DefaultRatioBounds() RatioBounds {
+	return RatioBounds{Low: 0.2, High: 5}
+}
+
+// StorageConfig selects where generated artifacts are kept
+type StorageConfig struct {
+	Backend     string        // "local" or "s3"
+	Dir         string        // Root directory of the local backend
+	ArtifactTTL time.Duration // Artifacts older than this are deleted
+	S3          S3Config
+}
+
+// S3Config configures an S3-compatible bucket
+type S3Config struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+}
+
+// Validate validates storage configuration
+func (s *StorageConfig) Validate() []ValidationError {
+	var errors []ValidationError
+
+	switch s.Backend {
+	case "local":
+		if s.Dir == "" {
+			errors = append(errors, ValidationError{
+				Field:   "STORAGE_DIR",
+				Value:   s.Dir,
+				Message: "storage directory cannot be empty",
+			})
+		}
+	case "s3":
+		if s.S3.Endpoint == "" || s.S3.Bucket == "" || s.S3.AccessKey == "" || s.S3.SecretKey == "" {
+			errors = append(errors, ValidationError{
+				Field:   "S3_ENDPOINT",
+				Value:   s.S3.Endpoint,
+				Message: "S3_ENDPOINT, S3_BUCKET, S3_ACCESS_KEY and S3_SECRET_KEY are required for the s3 storage backend",
+			})
+		}
+	default:
+		errors = append(errors, ValidationError{
+			Field:   "STORAGE_BACKEND",
+			Value:   s.Backend,
+			Message: "storage backend must be 'local' or 's3'",
+		})
+	}
+
+	if s.ArtifactTTL <= 0 {
+		errors = append(errors, ValidationError{
+			Field:   "STORAGE_ARTIFACT_TTL",
+			Value:   s.ArtifactTTL.String(),
+			Message: "artifact TTL must be positive",
+		})
+	}
+
+	return errors
+}
+
+// ModelPrice is the price of an AI model in USD per million tokens
+type ModelPrice struct {
+	Input  float64
+	Output float64
+}
+
+// DefaultModelPrices are the published prices of the models the bot uses
+func DefaultModelPrices() map[string]ModelPrice {
+	return map[string]ModelPrice{
+		"gemini-2.0-flash": {Input: 0.10, Output: 0.40},
+		"gpt-4o-mini":      {Input: 0.15, Output: 0.60},
+	}
+}
+
+// ParseModelPrices parses "model=input/output" pairs separated by commas,
+// e.g. "gemini-2.0-flash=0.10/0.40"
+func ParseModelPrices(value string) (map[string]ModelPrice, error) {
+	prices := make(map[string]ModelPrice)
+	for _, part := range strings.Split(value, ",") {
+		model, price, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok || model == "" {
+			return nil, fmt.Errorf("expected model=input/output, got %q", part)
+		}
+		in, out, ok := strings.Cut(price, "/")
+		if !ok {
+			return nil, fmt.Errorf("expected input/output price for %s, got %q", model, price)
+		}
+		input, err := strconv.ParseFloat(strings.TrimSpace(in), 64)
+		if err != nil || input < 0 {
+			return nil, fmt.Errorf("invalid input price for %s: %q", model, in)
+		}
+		output, err := strconv.ParseFloat(strings.TrimSpace(out), 64)
+		if err != nil || output < 0 {
+			return nil, fmt.Errorf("invalid output price for %s: %q", model, out)
+		}
+		prices[strings.TrimSpace(model)] = ModelPrice{Input: input, Output: output}
+	}
+	return prices, nil
 }
 
 type DBConfig struct {
@@ -102,6 +288,18 @@ func (c *Config) Validate() error {
 		errors = append(errors, dbErrors...)
 	}
 
+	if err := c.Confidence.Validate(); err != nil {
+		errors = append(errors, ValidationError{
+			Field:   "CONFIDENCE_SCORES",
+			Value:   fmt.Sprint(c.Confidence.Scores),
+			Message: err.Error(),
+		})
+	}
+
+	if storageErrors := c.Storage.Validate(); len(storageErrors) > 0 {
+		errors = append(errors, storageErrors...)
+	}
+
 	// Validate logger configuration
 	if logErrors := c.Logger.Validate(); len(logErrors) > 0 {
 		errors = append(errors, logErrors...)
@@ -291,6 +489,7 @@ func Load() (*Config, error) {
 	cfg := &Config{
 		TelegramToken: os.Getenv("TELEGRAM_BOT_TOKEN"),
 		GeminiAPIKey:  os.Getenv("GEMINI_API_KEY"),
+		OpenAIAPIKey:  os.Getenv("OPENAI_API_KEY"),
 		DB: DBConfig{
 			Host:     getEnvOrDefault("DB_HOST", "localhost"),
 			Port:     getEnvOrDefault("DB_PORT", "5432"),
@@ -303,6 +502,189 @@ func Load() (*Config, error) {
 			OutputPath: getEnvOrDefault("LOG_OUTPUT", "logs/app.log"),
 			Format:     getEnvOrDefault("LOG_FORMAT", "json"),
 		},
+		MetricsAddr:        os.Getenv("METRICS_ADDR"),
+		Confidence:         confidence.DefaultConfig(),
+		RatioBounds:        DefaultRatioBounds(),
+		BolusTiming:        dosing.DefaultTimingConfig(),
+		CorrectionBand:     dosing.DefaultCorrectionBand,
+		ModelPrices:        DefaultModelPrices(),
+		UsageMonthlyReport: os.Getenv("USAGE_MONTHLY_REPORT") == "true",
+		APIAddr:            os.Getenv("API_ADDR"),
+		APIRateLimit:       30,
+		EventWebhookURL:    os.Getenv("EVENT_WEBHOOK_URL"),
+		AIQueue:            AIQueueConfig{MaxDepth: 50},
+		ExportDir:          getEnvOrDefault("EXPORT_DIR", filepath.Join(os.TempDir(), "diabetes-helper-exports")),
+		Storage: StorageConfig{
+			Backend:     getEnvOrDefault("STORAGE_BACKEND", "local"),
+			Dir:         getEnvOrDefault("STORAGE_DIR", "data/storage"),
+			ArtifactTTL: 24 * time.Hour,
+			S3: S3Config{
+				Endpoint:  os.Getenv("S3_ENDPOINT"),
+				Region:    os.Getenv("S3_REGION"),
+				Bucket:    os.Getenv("S3_BUCKET"),
+				AccessKey: os.Getenv("S3_ACCESS_KEY"),
+				SecretKey: os.Getenv("S3_SECRET_KEY"),
+			},
+		},
+		Telemetry: TelemetryConfig{
+			Enabled:  os.Getenv("TELEMETRY_ENABLED") == "true",
+			Endpoint: os.Getenv("TELEMETRY_ENDPOINT"),
+		},
+		ConversationLog: ConversationLogConfig{
+			Enabled:   os.Getenv("CONVERSATION_LOG_ENABLED") == "true",
+			Retention: 72 * time.Hour,
+			FullText:  os.Getenv("CONVERSATION_LOG_FULL_TEXT") == "true",
+		},
+	}
+
+	if v := os.Getenv("ADMIN_TELEGRAM_IDS"); v != "" {
+		for _, part := range strings.Split(v, ",") {
+			id, err := strconv.ParseInt(strings.TrimSpace(part), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("configuration validation failed: %s", ValidationError{Field: "ADMIN_TELEGRAM_IDS", Value: v, Message: "must be a comma-separated list of Telegram user IDs"})
+			}
+			cfg.AdminTelegramIDs = append(cfg.AdminTelegramIDs, id)
+		}
+	}
+
+	if v := os.Getenv("INJECTION_SITE_REPEAT_LIMIT"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 2 {
+			return nil, fmt.Errorf("configuration validation failed: %s", ValidationError{Field: "INJECTION_SITE_REPEAT_LIMIT", Value: v, Message: "must be a whole number of at least 2"})
+		}
+		cfg.InjectionSiteRepeatLimit = limit
+	}
+
+	for _, bound := range []struct {
+		env   string
+		value *float64
+	}{
+		{"RATIO_WARN_LOW", &cfg.RatioBounds.Low},
+		{"RATIO_WARN_HIGH", &cfg.RatioBounds.High},
+	} {
+		if v := os.Getenv(bound.env); v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil || f <= 0 {
+				return nil, fmt.Errorf("configuration validation failed: %s", ValidationError{Field: bound.env, Value: v, Message: "must be a positive number"})
+			}
+			*bound.value = f
+		}
+	}
+	if cfg.RatioBounds.Low >= cfg.RatioBounds.High {
+		return nil, fmt.Errorf("configuration validation failed: %s", ValidationError{Field: "RATIO_WARN_LOW", Value: fmt.Sprintf("%g", cfg.RatioBounds.Low), Message: "must be less than RATIO_WARN_HIGH"})
+	}
+
+	for _, threshold := range []struct {
+		env   string
+		value *float64
+	}{
+		{"BOLUS_TIMING_LOW_BG", &cfg.BolusTiming.LowBG},
+		{"BOLUS_TIMING_HIGH_BG", &cfg.BolusTiming.HighBG},
+	} {
+		if v := os.Getenv(threshold.env); v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil || f <= 0 {
+				return nil, fmt.Errorf("configuration validation failed: %s", ValidationError{Field: threshold.env, Value: v, Message: "must be a positive number"})
+			}
+			*threshold.value = f
+		}
+	}
+	if cfg.BolusTiming.LowBG >= cfg.BolusTiming.HighBG {
+		return nil, fmt.Errorf("configuration validation failed: %s", ValidationError{Field: "BOLUS_TIMING_LOW_BG", Value: fmt.Sprintf("%g", cfg.BolusTiming.LowBG), Message: "must be less than BOLUS_TIMING_HIGH_BG"})
+	}
+
+	if v := os.Getenv("CORRECTION_BAND"); v != "" {
+		band, err := strconv.ParseFloat(v, 64)
+		if err != nil || band < 0 {
+			return nil, fmt.Errorf("configuration validation failed: %s", ValidationError{Field: "CORRECTION_BAND", Value: v, Message: "must be zero or a positive number"})
+		}
+		cfg.CorrectionBand = band
+	}
+
+	if v := os.Getenv("API_RATE_LIMIT"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			return nil, fmt.Errorf("configuration validation failed: %s", ValidationError{Field: "API_RATE_LIMIT", Value: v, Message: "must be a positive whole number"})
+		}
+		cfg.APIRateLimit = limit
+	}
+
+	if v := os.Getenv("AI_REQUESTS_PER_MINUTE"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("configuration validation failed: %s", ValidationError{Field: "AI_REQUESTS_PER_MINUTE", Value: v, Message: "must be a whole number, 0 for no limit"})
+		}
+		cfg.AIQueue.PerMinute = limit
+	}
+	if v := os.Getenv("AI_QUEUE_MAX_DEPTH"); v != "" {
+		depth, err := strconv.Atoi(v)
+		if err != nil || depth < 1 {
+			return nil, fmt.Errorf("configuration validation failed: %s", ValidationError{Field: "AI_QUEUE_MAX_DEPTH", Value: v, Message: "must be a positive whole number"})
+		}
+		cfg.AIQueue.MaxDepth = depth
+	}
+
+	if v := os.Getenv("STORAGE_ARTIFACT_TTL"); v != "" {
+		ttl, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("configuration validation failed: %s", ValidationError{Field: "STORAGE_ARTIFACT_TTL", Value: v, Message: "must be a duration such as 24h"})
+		}
+		cfg.Storage.ArtifactTTL = ttl
+	}
+
+	if v := os.Getenv("CONVERSATION_LOG_RETENTION"); v != "" {
+		retention, err := time.ParseDuration(v)
+		if err != nil || retention <= 0 {
+			return nil, fmt.Errorf("configuration validation failed: %s", ValidationError{Field: "CONVERSATION_LOG_RETENTION", Value: v, Message: "must be a positive duration such as 72h"})
+		}
+		cfg.ConversationLog.Retention = retention
+	}
+
+	if cfg.Telemetry.Enabled {
+		u, err := url.Parse(cfg.Telemetry.Endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("configuration validation failed: %s", ValidationError{Field: "TELEMETRY_ENDPOINT", Value: cfg.Telemetry.Endpoint, Message: "must be an http(s) URL when TELEMETRY_ENABLED=true"})
+		}
+	}
+
+	if cfg.EventWebhookURL != "" {
+		u, err := url.Parse(cfg.EventWebhookURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("configuration validation failed: %s", ValidationError{Field: "EVENT_WEBHOOK_URL", Value: cfg.EventWebhookURL, Message: "must be an http(s) URL"})
+		}
+	}
+
+	if path := os.Getenv("PORTION_REFERENCES_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil || strings.TrimSpace(string(data)) == "" {
+			return nil, fmt.Errorf("configuration validation failed: %s", ValidationError{Field: "PORTION_REFERENCES_FILE", Value: path, Message: "must be a readable, non-empty file"})
+		}
+		cfg.PortionReferences = strings.TrimSpace(string(data))
+	}
+
+	if v := os.Getenv("AI_MODEL_PRICES"); v != "" {
+		prices, err := ParseModelPrices(v)
+		if err != nil {
+			return nil, fmt.Errorf("configuration validation failed: %s", ValidationError{Field: "AI_MODEL_PRICES", Value: v, Message: err.Error()})
+		}
+		for model, price := range prices {
+			cfg.ModelPrices[model] = price
+		}
+	}
+
+	if v := os.Getenv("CONFIDENCE_SCORES"); v != "" {
+		scores, err := confidence.ParseScores(v)
+		if err != nil {
+			return nil, fmt.Errorf("configuration validation failed: %s", ValidationError{Field: "CONFIDENCE_SCORES", Value: v, Message: err.Error()})
+		}
+		cfg.Confidence.Scores = scores
+	}
+	if v := os.Getenv("CONFIDENCE_LABELS"); v != "" {
+		labels, err := confidence.ParseLabels(v)
+		if err != nil {
+			return nil, fmt.Errorf("configuration validation failed: %s", ValidationError{Field: "CONFIDENCE_LABELS", Value: v, Message: err.Error()})
+		}
+		cfg.Confidence.Labels = labels
 	}
 
 	// Validate configuration
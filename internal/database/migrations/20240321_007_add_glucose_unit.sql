@@ -0,0 +1,2 @@
+-- Add preferred glucose unit to users ('mmol' or 'mgdl')
+ALTER TABLE users ADD COLUMN IF NOT EXISTS glucose_unit VARCHAR(10) NOT NULL DEFAULT 'mmol';
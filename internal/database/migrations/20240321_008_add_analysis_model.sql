@@ -0,0 +1,7 @@
+-- Record the exact AI model that produced an analysis
+ALTER TABLE food_analyses ADD COLUMN IF NOT EXISTS model VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE food_analysis_corrections ADD COLUMN IF NOT EXISTS model VARCHAR(64) NOT NULL DEFAULT '';
+
+-- Everything analyzed before this migration went through Gemini 2.0 Flash
+UPDATE food_analyses SET model = 'gemini-2.0-flash' WHERE model = '' AND used_provider = 'gemini';
+UPDATE food_analysis_corrections SET model = 'gemini-2.0-flash' WHERE model = '' AND used_provider = 'gemini';
@@ -0,0 +1,7 @@
+-- Daily carbohydrate target in grams (0 disables tracking)
+ALTER TABLE users ADD COLUMN IF NOT EXISTS daily_carb_target DOUBLE PRECISION NOT NULL DEFAULT 0;
+
+-- IANA timezone name used for day boundaries and ratio periods (empty = server time)
+ALTER TABLE users ADD COLUMN IF NOT EXISTS timezone VARCHAR(64) NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS idx_food_analyses_user_created_at ON food_analyses(user_id, created_at);
@@ -0,0 +1,19 @@
+-- Precomputed per-user daily aggregates for statistics and reports
+CREATE TABLE IF NOT EXISTS daily_summaries (
+    id SERIAL PRIMARY KEY,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    user_id INTEGER REFERENCES users(id),
+    day DATE NOT NULL,
+    readings_count INTEGER NOT NULL DEFAULT 0,
+    mean_glucose DOUBLE PRECISION NOT NULL DEFAULT 0,
+    min_glucose DOUBLE PRECISION NOT NULL DEFAULT 0,
+    max_glucose DOUBLE PRECISION NOT NULL DEFAULT 0,
+    time_in_range DOUBLE PRECISION NOT NULL DEFAULT 0,
+    analyses_count INTEGER NOT NULL DEFAULT 0,
+    carbs_total DOUBLE PRECISION NOT NULL DEFAULT 0,
+    carb_target DOUBLE PRECISION NOT NULL DEFAULT 0,
+    UNIQUE (user_id, day)
+);
+
+CREATE INDEX IF NOT EXISTS idx_daily_summaries_user_day ON daily_summaries(user_id, day);
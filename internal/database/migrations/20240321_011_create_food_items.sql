@@ -0,0 +1,14 @@
+-- Individual food items recognized in an analysis. normalized_name is the
+-- canonical form used for search and matching; name keeps the AI's wording.
+CREATE TABLE IF NOT EXISTS food_items (
+    id SERIAL PRIMARY KEY,
+    created_at TIMESTAMP WITH TIME ZONE,
+    updated_at TIMESTAMP WITH TIME ZONE,
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    food_analysis_id INTEGER NOT NULL REFERENCES food_analyses(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    normalized_name VARCHAR(255) NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_food_items_user_normalized ON food_items(user_id, normalized_name);
+CREATE INDEX IF NOT EXISTS idx_food_items_analysis ON food_items(food_analysis_id);
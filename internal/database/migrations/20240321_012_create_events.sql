@@ -0,0 +1,12 @@
+-- Lightweight contextual events logged with stickers or emoji (exercise, fast carbs, ...)
+CREATE TABLE IF NOT EXISTS events (
+    id SERIAL PRIMARY KEY,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    user_id INTEGER REFERENCES users(id),
+    kind VARCHAR(32) NOT NULL,
+    emoji VARCHAR(16) NOT NULL DEFAULT '',
+    timestamp TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_events_user_timestamp ON events(user_id, timestamp);
@@ -0,0 +1,4 @@
+-- Analyses whose insulin ratio was recomputed for the user's timezone keep the
+-- original value so the change can be audited or reverted
+ALTER TABLE food_analyses ADD COLUMN IF NOT EXISTS original_insulin_ratio DOUBLE PRECISION;
+ALTER TABLE food_analyses ADD COLUMN IF NOT EXISTS ratio_recomputed_at TIMESTAMP WITH TIME ZONE;
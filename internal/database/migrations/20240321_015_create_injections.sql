@@ -0,0 +1,14 @@
+-- Administered insulin doses. site is empty when the user skipped choosing it.
+CREATE TABLE IF NOT EXISTS injections (
+    id SERIAL PRIMARY KEY,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    user_id INTEGER REFERENCES users(id),
+    units DOUBLE PRECISION NOT NULL,
+    kind VARCHAR(16) NOT NULL DEFAULT 'bolus',
+    site VARCHAR(32) NOT NULL DEFAULT '',
+    food_analysis_id INTEGER REFERENCES food_analyses(id) ON DELETE SET NULL,
+    timestamp TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_injections_user_timestamp ON injections(user_id, timestamp);
@@ -0,0 +1,4 @@
+-- Temporary timezone override while travelling. travel_until is the moment the
+-- override stops applying; NULL means it stays on until ended manually.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS travel_timezone VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS travel_until TIMESTAMP WITH TIME ZONE;
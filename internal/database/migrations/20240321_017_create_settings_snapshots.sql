@@ -0,0 +1,12 @@
+-- Named copies of a user's settings that can be restored later.
+-- data is a JSON document (see services.SettingsSnapshotData).
+CREATE TABLE IF NOT EXISTS settings_snapshots (
+    id SERIAL PRIMARY KEY,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(64) NOT NULL,
+    data TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_settings_snapshots_user ON settings_snapshots(user_id, created_at);
@@ -0,0 +1,20 @@
+-- Daily AI token usage and estimated cost per provider and model
+CREATE TABLE IF NOT EXISTS token_usages (
+    id SERIAL PRIMARY KEY,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    day DATE NOT NULL,
+    provider VARCHAR(32) NOT NULL,
+    model VARCHAR(64) NOT NULL,
+    requests INTEGER NOT NULL DEFAULT 0,
+    input_tokens BIGINT NOT NULL DEFAULT 0,
+    output_tokens BIGINT NOT NULL DEFAULT 0,
+    cost DOUBLE PRECISION NOT NULL DEFAULT 0,
+    UNIQUE (day, provider, model)
+);
+
+-- Months for which the usage summary has already been sent to admins
+CREATE TABLE IF NOT EXISTS usage_reports (
+    month DATE PRIMARY KEY,
+    sent_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
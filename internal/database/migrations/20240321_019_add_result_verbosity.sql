@@ -0,0 +1,2 @@
+-- How much detail the analysis result shows ('full' or 'compact')
+ALTER TABLE users ADD COLUMN IF NOT EXISTS result_verbosity VARCHAR(10) NOT NULL DEFAULT 'full';
@@ -0,0 +1,2 @@
+-- Blob storage key of a finished job's output (see internal/storage)
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS storage_key TEXT NOT NULL DEFAULT '';
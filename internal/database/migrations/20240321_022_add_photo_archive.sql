@@ -0,0 +1,4 @@
+-- Optional archiving of original meal photos
+ALTER TABLE users ADD COLUMN IF NOT EXISTS archive_photos BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE food_analyses ADD COLUMN IF NOT EXISTS photo_file_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE food_analyses ADD COLUMN IF NOT EXISTS photo_key TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,16 @@
+-- Basal rate schedule of pump users: units per hour for each time period
+CREATE TABLE IF NOT EXISTS basal_rates (
+    id SERIAL PRIMARY KEY,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    start_time VARCHAR(5) NOT NULL CHECK (start_time ~ '^([0-1][0-9]|2[0-3]):[0-5][0-9]$'),
+    end_time VARCHAR(5) NOT NULL CHECK (end_time ~ '^([0-1][0-9]|2[0-3]):[0-5][0-9]$'),
+    rate DOUBLE PRECISION NOT NULL CHECK (rate >= 0)
+);
+
+CREATE INDEX IF NOT EXISTS idx_basal_rates_user_id ON basal_rates(user_id);
+
+-- Daily insulin totals in the statistics
+ALTER TABLE daily_summaries ADD COLUMN IF NOT EXISTS bolus_total DOUBLE PRECISION NOT NULL DEFAULT 0;
+ALTER TABLE daily_summaries ADD COLUMN IF NOT EXISTS basal_total DOUBLE PRECISION NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+-- How insulin on board decays ('linear' or 'curved')
+ALTER TABLE users ADD COLUMN IF NOT EXISTS iob_model VARCHAR(10) NOT NULL DEFAULT 'linear';
@@ -0,0 +1,18 @@
+-- Carbs of each recognized food item, 0 when unknown
+ALTER TABLE food_items ADD COLUMN IF NOT EXISTS carbs DOUBLE PRECISION NOT NULL DEFAULT 0;
+
+-- Link corrections to the analysis they correct
+ALTER TABLE food_analysis_corrections ADD COLUMN IF NOT EXISTS food_analysis_id INTEGER REFERENCES food_analyses(id) ON DELETE SET NULL;
+
+-- Per-item changes of a correction
+CREATE TABLE IF NOT EXISTS food_item_corrections (
+    id SERIAL PRIMARY KEY,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    correction_id INTEGER NOT NULL REFERENCES food_analysis_corrections(id) ON DELETE CASCADE,
+    food_item_id INTEGER NOT NULL REFERENCES food_items(id) ON DELETE CASCADE,
+    original_carbs DOUBLE PRECISION NOT NULL,
+    corrected_carbs DOUBLE PRECISION NOT NULL CHECK (corrected_carbs >= 0)
+);
+
+CREATE INDEX IF NOT EXISTS idx_food_item_corrections_correction ON food_item_corrections(correction_id);
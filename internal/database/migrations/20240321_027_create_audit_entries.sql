@@ -0,0 +1,12 @@
+-- Admin access to other users' data, e.g. /support lookups.
+-- Entries are append-only and kept when the target user is deleted.
+CREATE TABLE IF NOT EXISTS audit_entries (
+    id SERIAL PRIMARY KEY,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    actor_telegram_id BIGINT NOT NULL,
+    action VARCHAR(50) NOT NULL,
+    target_telegram_id BIGINT NOT NULL,
+    target_user_id INTEGER
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_entries_target ON audit_entries(target_telegram_id, created_at);
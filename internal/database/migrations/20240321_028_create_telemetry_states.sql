@@ -0,0 +1,7 @@
+-- State of the optional weekly telemetry report: a random instance ID and
+-- when the last report was sent. There is at most one row.
+CREATE TABLE IF NOT EXISTS telemetry_states (
+    id INTEGER PRIMARY KEY CHECK (id = 1),
+    instance_id VARCHAR(32) NOT NULL,
+    last_sent_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
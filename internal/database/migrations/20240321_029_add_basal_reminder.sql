@@ -0,0 +1,15 @@
+-- Daily reminder for a long-acting insulin dose: local time ("HH:MM", empty
+-- when off) and units, plus one row per reminder sent, which links the
+-- injection logged with the "✅ Принял" button.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS basal_reminder_time VARCHAR(5) NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS basal_reminder_units DOUBLE PRECISION NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS basal_reminders (
+    id SERIAL PRIMARY KEY,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    day DATE NOT NULL,
+    injection_id INTEGER REFERENCES injections(id) ON DELETE SET NULL,
+    taken_at TIMESTAMP WITH TIME ZONE,
+    UNIQUE (user_id, day)
+);
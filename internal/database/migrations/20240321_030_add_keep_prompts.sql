@@ -0,0 +1,3 @@
+-- Prompts of input flows are deleted when the flow ends unless the user
+-- keeps them
+ALTER TABLE users ADD COLUMN IF NOT EXISTS keep_prompts BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1,12 @@
+-- One-time codes that move an account's history to another Telegram account.
+-- Only the hash of a code is stored.
+CREATE TABLE IF NOT EXISTS transfer_codes (
+    id SERIAL PRIMARY KEY,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash VARCHAR(64) NOT NULL UNIQUE,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_transfer_codes_user ON transfer_codes(user_id);
@@ -0,0 +1,6 @@
+-- Ratio set scheduled to replace the current ratios at a future date, stored
+-- as JSON like the ratios of a settings snapshot; empty when none is pending
+ALTER TABLE users ADD COLUMN IF NOT EXISTS ratio_change TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS ratio_change_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS idx_users_ratio_change_at ON users(ratio_change_at) WHERE ratio_change_at IS NOT NULL;
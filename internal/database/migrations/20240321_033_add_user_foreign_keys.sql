@@ -0,0 +1,78 @@
+-- Rows left by users deleted while no constraint was in place can't satisfy
+-- the foreign keys. They are medical history, so instead of being dropped
+-- they are kept here as JSON with the table they came from.
+CREATE TABLE IF NOT EXISTS orphaned_user_rows (
+    id BIGSERIAL PRIMARY KEY,
+    table_name TEXT NOT NULL,
+    user_id BIGINT NOT NULL,
+    row_data JSONB NOT NULL,
+    moved_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- Every table with a user_id references users with ON DELETE CASCADE, so
+-- deleting a user row removes all of the user's data. The older tables were
+-- created with plain references (or none, when built by GORM with foreign
+-- keys disabled); their constraints are replaced whatever they are named.
+DO $$
+DECLARE
+    tables TEXT[] := ARRAY[
+        'food_analyses',
+        'food_analysis_corrections',
+        'blood_sugar_records',
+        'insulin_ratios',
+        'events',
+        'food_items',
+        'daily_summaries',
+        'injections'
+    ];
+    tbl TEXT;
+    fk RECORD;
+    moved BIGINT;
+BEGIN
+    -- All orphaned rows are copied before any is deleted, so rows removed by
+    -- a cascade from another table (e.g. food items of an orphaned analysis)
+    -- are kept too
+    FOREACH tbl IN ARRAY tables LOOP
+        EXECUTE format('INSERT INTO orphaned_user_rows (table_name, user_id, row_data)
+            SELECT %L, t.user_id, to_jsonb(t) FROM %I t
+            WHERE t.user_id IS NOT NULL AND t.user_id NOT IN (SELECT id FROM users)', tbl, tbl);
+        GET DIAGNOSTICS moved = ROW_COUNT;
+        IF moved > 0 THEN
+            RAISE WARNING '% rows of %s without a user moved to orphaned_user_rows', moved, tbl;
+        END IF;
+    END LOOP;
+
+    FOREACH tbl IN ARRAY tables LOOP
+        FOR fk IN
+            SELECT c.conname
+            FROM pg_constraint c
+            JOIN pg_attribute a ON a.attrelid = c.conrelid AND a.attnum = ANY (c.conkey)
+            WHERE c.contype = 'f'
+            AND c.conrelid = tbl::regclass
+            AND c.confrelid = 'users'::regclass
+            AND a.attname = 'user_id'
+        LOOP
+            EXECUTE format('ALTER TABLE %I DROP CONSTRAINT %I', tbl, fk.conname);
+        END LOOP;
+
+        -- Copied to orphaned_user_rows above
+        EXECUTE format('DELETE FROM %I WHERE user_id IS NOT NULL AND user_id NOT IN (SELECT id FROM users)', tbl);
+        EXECUTE format('ALTER TABLE %I ADD CONSTRAINT %I FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE',
+            tbl, 'fk_' || tbl || '_user');
+    END LOOP;
+END $$;
+
+-- Audit entries outlive the user they are about
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'fk_audit_entries_target_user') THEN
+        UPDATE audit_entries SET target_user_id = NULL
+        WHERE target_user_id IS NOT NULL AND target_user_id NOT IN (SELECT id FROM users);
+        ALTER TABLE audit_entries ADD CONSTRAINT fk_audit_entries_target_user
+            FOREIGN KEY (target_user_id) REFERENCES users(id) ON DELETE SET NULL;
+    END IF;
+END $$;
+
+-- Indexes for the cascades where no index starts with user_id yet
+CREATE INDEX IF NOT EXISTS idx_feature_flag_overrides_user ON feature_flag_overrides(user_id);
+CREATE INDEX IF NOT EXISTS idx_audit_entries_target_user ON audit_entries(target_user_id);
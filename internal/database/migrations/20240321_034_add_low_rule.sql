@@ -0,0 +1,8 @@
+-- Personal rule for treating lows ("15 г поднимают на 3 ммоль/л"), the level
+-- to treat up to (0 for the default) and the pending recheck reminder
+ALTER TABLE users ADD COLUMN IF NOT EXISTS low_rule_grams DOUBLE PRECISION NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS low_rule_rise DOUBLE PRECISION NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS low_target DOUBLE PRECISION NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS low_recheck_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS idx_users_low_recheck_at ON users(low_recheck_at) WHERE low_recheck_at IS NOT NULL;
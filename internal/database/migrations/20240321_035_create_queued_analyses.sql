@@ -0,0 +1,15 @@
+-- Photos waiting for the AI rate limit. Kept in the database so that queued
+-- photos survive a restart.
+CREATE TABLE IF NOT EXISTS queued_analyses (
+    id SERIAL PRIMARY KEY,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    telegram_id BIGINT NOT NULL,
+    chat_id BIGINT NOT NULL,
+    file_id TEXT NOT NULL,
+    weight DOUBLE PRECISION NOT NULL DEFAULT 0,
+    dish TEXT NOT NULL DEFAULT '',
+    message_id INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS idx_queued_analyses_user ON queued_analyses(user_id);
@@ -0,0 +1,4 @@
+-- Glycemic index category of analyzed meals for the injection timing hint,
+-- and the setting that hides the hint
+ALTER TABLE food_analyses ADD COLUMN IF NOT EXISTS glycemic_index TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS hide_bolus_timing BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,2 @@
+-- Display rounding of bread units (0.1, 0.25 or 0.5); 0 shows tenths
+ALTER TABLE users ADD COLUMN IF NOT EXISTS bread_unit_step DOUBLE PRECISION NOT NULL DEFAULT 0;
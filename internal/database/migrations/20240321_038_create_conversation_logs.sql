@@ -0,0 +1,17 @@
+-- Optional conversation log (CONVERSATION_LOG_ENABLED): incoming updates and
+-- the bot's replies, kept for a short time to debug wrong answers. Keyed by
+-- chat rather than user so entries can be written without a lookup.
+CREATE TABLE IF NOT EXISTS conversation_logs (
+    id SERIAL PRIMARY KEY,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    chat_id BIGINT NOT NULL,
+    update_id BIGINT NOT NULL DEFAULT 0,
+    direction VARCHAR(3) NOT NULL,
+    kind VARCHAR(50) NOT NULL,
+    template TEXT NOT NULL DEFAULT '',
+    variables TEXT NOT NULL DEFAULT '',
+    text TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_conversation_logs_chat ON conversation_logs(chat_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_conversation_logs_created_at ON conversation_logs(created_at);
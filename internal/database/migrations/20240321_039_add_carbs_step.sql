@@ -0,0 +1,4 @@
+-- Rounding of analysed carbs to whole grams (1) or to 5 g; 0 keeps the AI's
+-- value. Analyses keep the unrounded value for the breakdown.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS carbs_step DOUBLE PRECISION NOT NULL DEFAULT 0;
+ALTER TABLE food_analyses ADD COLUMN IF NOT EXISTS raw_carbs DOUBLE PRECISION NOT NULL DEFAULT 0;
@@ -0,0 +1,4 @@
+-- Quiet hours: local "HH:MM" window in which reminders are held and other
+-- pushes arrive silently; both empty when off
+ALTER TABLE users ADD COLUMN IF NOT EXISTS quiet_hours_start VARCHAR(5) NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS quiet_hours_end VARCHAR(5) NOT NULL DEFAULT '';
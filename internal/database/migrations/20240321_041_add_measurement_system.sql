@@ -0,0 +1,2 @@
+-- Units portion estimation prompts reason in: "metric" or "imperial"; empty for metric
+ALTER TABLE users ADD COLUMN IF NOT EXISTS measurement_system VARCHAR(10) NOT NULL DEFAULT '';
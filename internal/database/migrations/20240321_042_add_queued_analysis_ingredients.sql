@@ -0,0 +1,2 @@
+-- Recipe or ingredient list from the photo caption, passed to the AI as a hint
+ALTER TABLE queued_analyses ADD COLUMN IF NOT EXISTS ingredients TEXT NOT NULL DEFAULT '';
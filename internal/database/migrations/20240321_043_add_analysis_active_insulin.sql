@@ -0,0 +1,2 @@
+-- Units of active insulin taken off the recommended dose of an analysis
+ALTER TABLE food_analyses ADD COLUMN IF NOT EXISTS active_insulin DOUBLE PRECISION NOT NULL DEFAULT 0;
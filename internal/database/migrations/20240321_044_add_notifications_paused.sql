@@ -0,0 +1,2 @@
+-- Reminders and other automated messages paused by the user; their settings are kept
+ALTER TABLE users ADD COLUMN IF NOT EXISTS notifications_paused BOOLEAN NOT NULL DEFAULT FALSE;
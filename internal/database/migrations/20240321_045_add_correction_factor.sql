@@ -0,0 +1,9 @@
+-- Correction factor (mmol/L one unit lowers glucose by) and target glucose
+-- for the correction part of a dose; 0 when not set
+ALTER TABLE users ADD COLUMN IF NOT EXISTS correction_factor DOUBLE PRECISION NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS target_blood_sugar DOUBLE PRECISION NOT NULL DEFAULT 0;
+
+-- Correction units in the recommended dose of an analysis and the glucose
+-- they were computed for
+ALTER TABLE food_analyses ADD COLUMN IF NOT EXISTS correction_units DOUBLE PRECISION NOT NULL DEFAULT 0;
+ALTER TABLE food_analyses ADD COLUMN IF NOT EXISTS correction_glucose DOUBLE PRECISION NOT NULL DEFAULT 0;
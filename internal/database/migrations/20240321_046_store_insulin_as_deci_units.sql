@@ -0,0 +1,8 @@
+-- Insulin amounts are stored as integer tenths of a unit, so sums of doses
+-- don't accumulate floating-point error: 2.5 units become 25
+ALTER TABLE food_analyses
+    ALTER COLUMN insulin_units TYPE BIGINT USING ROUND(insulin_units * 10),
+    ALTER COLUMN active_insulin TYPE BIGINT USING ROUND(active_insulin * 10),
+    ALTER COLUMN correction_units TYPE BIGINT USING ROUND(correction_units * 10);
+
+ALTER TABLE injections ALTER COLUMN units TYPE BIGINT USING ROUND(units * 10);
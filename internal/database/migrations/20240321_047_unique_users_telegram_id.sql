@@ -0,0 +1,36 @@
+-- users.telegram_id must be unique: registration inserts with ON CONFLICT
+-- (telegram_id). Databases built without the constraint may already hold
+-- duplicate users; the migration stops with their IDs instead of skipping
+-- the index, and runs again on the next start once they are merged with
+-- cmd/merge-users, which works without starting the bot.
+DO $$
+DECLARE
+    duplicates TEXT;
+BEGIN
+    IF EXISTS (
+        SELECT 1
+        FROM pg_index i
+        JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = i.indkey[0]
+        WHERE i.indrelid = 'users'::regclass
+        AND i.indisunique
+        AND i.indnatts = 1
+        AND a.attname = 'telegram_id'
+    ) THEN
+        RETURN;
+    END IF;
+
+    SELECT string_agg(format('telegram_id %s: users %s', telegram_id, ids), '; ' ORDER BY telegram_id)
+    INTO duplicates
+    FROM (
+        SELECT telegram_id, string_agg(id::text, ', ' ORDER BY id) AS ids
+        FROM users
+        GROUP BY telegram_id
+        HAVING COUNT(*) > 1
+    ) d;
+
+    IF duplicates IS NOT NULL THEN
+        RAISE EXCEPTION 'cannot add unique index on users.telegram_id, duplicate users found (%). Merge them with go run ./cmd/merge-users -apply and start again', duplicates;
+    END IF;
+
+    CREATE UNIQUE INDEX uni_users_telegram_id ON users(telegram_id);
+END $$;
@@ -0,0 +1,7 @@
+-- Users can ask for analyses served by a fallback AI model to be repeated on
+-- the primary model once it is available again
+ALTER TABLE users ADD COLUMN IF NOT EXISTS reanalyze_on_primary BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Jobs that wait for something, such as the primary model recovering, are
+-- not claimed before run_after; NULL runs as soon as possible
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS run_after TIMESTAMP WITH TIME ZONE;
@@ -0,0 +1,3 @@
+-- Glucose inside the no-correction band around target is not corrected, and
+-- the result says it is within the target range
+ALTER TABLE food_analyses ADD COLUMN IF NOT EXISTS correction_in_band BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,7 @@
+-- Daily insulin totals and the reminded basal dose are stored as integer
+-- tenths of a unit like the doses they come from, so they add up exactly
+ALTER TABLE daily_summaries
+    ALTER COLUMN bolus_total TYPE BIGINT USING ROUND(bolus_total * 10),
+    ALTER COLUMN basal_total TYPE BIGINT USING ROUND(basal_total * 10);
+
+ALTER TABLE users ALTER COLUMN basal_reminder_units TYPE BIGINT USING ROUND(basal_reminder_units * 10);
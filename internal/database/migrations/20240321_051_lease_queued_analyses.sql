@@ -0,0 +1,5 @@
+-- A queued photo is leased while its analysis runs and deleted only once the
+-- result is delivered, so a crash mid-analysis doesn't lose it: the lease
+-- expires and another worker picks the photo up again
+ALTER TABLE queued_analyses ADD COLUMN IF NOT EXISTS claimed_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE queued_analyses ADD COLUMN IF NOT EXISTS attempts INTEGER NOT NULL DEFAULT 0;
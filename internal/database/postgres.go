@@ -9,6 +9,7 @@ import (
 
 	"github.com/vladimiradmaev/diabetes-helper/internal/config"
 	"github.com/vladimiradmaev/diabetes-helper/internal/database/migrations"
+	"github.com/vladimiradmaev/diabetes-helper/internal/dosing"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
@@ -22,7 +23,49 @@ type User struct {
 	Username          string
 	FirstName         string
 	LastName          string
-	ActiveInsulinTime int // Time in minutes
+	ActiveInsulinTime int        // Time in minutes
+	GlucoseUnit       string     // "mmol" or "mgdl"
+	DailyCarbTarget   float64    // Grams of carbs per day, 0 if not set
+	Timezone          string     // IANA timezone name, empty for server time
+	TravelTimezone    string     // Temporary timezone override, empty when not travelling
+	TravelUntil       *time.Time // End of the travel override, nil if open-ended
+	ResultVerbosity   string     // "full" or "compact"
+	ArchivePhotos     bool       // Keep a copy of meal photos in blob storage
+	IOBModel          string     // "linear" or "curved"
+	KeepPrompts       bool       // Keep the prompts of finished input flows in the chat
+	HideBolusTiming   bool       // Leave the injection timing hint out of analysis results
+	BreadUnitStep     float64    // Display rounding of bread units: 0.1, 0.25 or 0.5; 0 for 0.1
+	CarbsStep         float64    // Grams analysed carbs are rounded to: 1 or 5; 0 keeps the AI's value
+	MeasurementSystem string     // Units portions are estimated in: "metric" or "imperial"; empty for metric
+
+	// Offer to repeat analyses served by a fallback AI model on the primary
+	// model once it is available again
+	ReanalyzeOnPrimary bool
+
+	BasalReminderTime  string           // Local time of the daily basal reminder, "HH:MM"; empty when off
+	BasalReminderUnits dosing.DeciUnits // Reminded basal dose
+
+	// Local "HH:MM" window in which reminders are held and other pushes are
+	// sent silently, e.g. "23:00"-"07:00"; both empty when off
+	QuietHoursStart string
+	QuietHoursEnd   string
+
+	// Reminders and other automated messages are paused, e.g. during a
+	// hospital stay; their settings are kept
+	NotificationsPaused bool
+
+	RatioChange   string     // Ratios scheduled to replace the current ones, JSON; empty when none
+	RatioChangeAt *time.Time // When RatioChange takes effect, local midnight of its first day
+
+	LowRuleGrams float64    // Grams of fast carbs in the user's rule for treating lows, 0 if not set
+	LowRuleRise  float64    // mmol/L that LowRuleGrams raise glucose by
+	LowTarget    float64    // mmol/L to treat a low up to, 0 for dosing.DefaultLowTarget
+	LowRecheckAt *time.Time // When to remind to recheck after treating a low, nil if none
+
+	// High glucose is corrected down to TargetBloodSugar, each unit lowering
+	// it by CorrectionFactor; both in mmol/L, 0 when not set
+	CorrectionFactor float64
+	TargetBloodSugar float64
 }
 
 type FoodAnalysis struct {
@@ -39,8 +82,38 @@ type FoodAnalysis struct {
 	Confidence   float64
 	AnalysisText string
 	UsedProvider string // "gemini" or "openai"
+	Model        string // Exact model name, e.g. "gemini-2.0-flash"
 	InsulinRatio float64
-	InsulinUnits float64
+	InsulinUnits dosing.DeciUnits
+
+	// Active insulin taken off the dose; 0 when none or the user hasn't set
+	// their active insulin time
+	ActiveInsulin dosing.DeciUnits
+
+	// Insulin added to the dose to bring high glucose down to target and the
+	// glucose in mmol/L it was computed for; CorrectionGlucose is 0 when no
+	// recent glucose was known or the user hasn't set up corrections.
+	// CorrectionInBand is set when the glucose was within the no-correction
+	// band of target and therefore not corrected.
+	CorrectionUnits   dosing.DeciUnits
+	CorrectionGlucose float64
+	CorrectionInBand  bool
+
+	// GI category of the meal for the injection timing hint: "high",
+	// "medium" or "low"; empty when unknown
+	GlycemicIndex string
+
+	// The AI's carbs before rounding to the user's CarbsStep; 0 when not rounded
+	RawCarbs float64
+
+	// Set when InsulinRatio was recomputed for the user's timezone
+	OriginalInsulinRatio *float64
+	RatioRecomputedAt    *time.Time
+
+	// Set when the user archives meal photos; PhotoFileID also when the
+	// analysis is offered for a repeat on the primary AI model
+	PhotoFileID string // Telegram file_id of the original photo
+	PhotoKey    string // Blob storage key of the archived copy
 }
 
 type FoodAnalysisCorrection struct {
@@ -50,6 +123,7 @@ type FoodAnalysisCorrection struct {
 	DeletedAt       *time.Time
 	UserID          uint
 	User            User
+	FoodAnalysisID  *uint // Analysis the correction applies to, nil for older corrections
 	OriginalCarbs   float64
 	CorrectedCarbs  float64
 	BreadUnits      float64
@@ -58,6 +132,7 @@ type FoodAnalysisCorrection struct {
 	ImageURL        string
 	AnalysisText    string
 	UsedProvider    string // "gemini" or "openai"
+	Model           string
 	Confidence      float64
 }
 
@@ -68,7 +143,7 @@ type BloodSugarRecord struct {
 	DeletedAt *time.Time
 	UserID    uint
 	User      User
-	Value     float64
+	Value     float64 // Always stored in mmol/L
 	Timestamp time.Time
 }
 
@@ -84,21 +159,231 @@ type InsulinRatio struct {
 	Ratio     float64 // Insulin units per XE
 }
 
-func NewPostgresDB(cfg config.DBConfig) (*gorm.DB, error) {
-	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		cfg.Host, cfg.Port, cfg.User, cfg.Password, cfg.DBName)
+// BasalRate is a period of a pump user's basal schedule
+type BasalRate struct {
+	ID        uint
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	UserID    uint
+	StartTime string  // Format: "HH:MM"
+	EndTime   string  // Format: "HH:MM"
+	Rate      float64 // Insulin units per hour
+}
 
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
-		DisableForeignKeyConstraintWhenMigrating: true,
-		DisableAutomaticPing:                     true,
-		SkipDefaultTransaction:                   false,
-		PrepareStmt:                              false,
-		CreateBatchSize:                          0,
-		FullSaveAssociations:                     false,
-		AllowGlobalUpdate:                        false,
-	})
+// SettingsSnapshot is a named copy of a user's settings
+type SettingsSnapshot struct {
+	ID        uint
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	UserID    uint
+	Name      string
+	Data      string // JSON-encoded services.SettingsSnapshotData
+}
+
+// Injection is an administered insulin dose
+type Injection struct {
+	ID             uint
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	UserID         uint
+	Units          dosing.DeciUnits
+	Kind           string // "bolus", "basal" or "correction"
+	Site           string // See services.InjectionSite* constants; empty if not specified
+	FoodAnalysisID *uint
+	Timestamp      time.Time
+}
+
+// BasalReminder is a basal reminder sent to a user on a local day
+type BasalReminder struct {
+	ID          uint
+	CreatedAt   time.Time
+	UserID      uint
+	Day         time.Time // Local calendar day of the user
+	InjectionID *uint     // Injection logged from the reminder, nil until taken
+	TakenAt     *time.Time
+}
+
+// Event is a contextual marker such as exercise or fast carbs, logged with an emoji
+type Event struct {
+	ID        uint
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	UserID    uint
+	Kind      string // See services.EventKind* constants
+	Emoji     string // Emoji the user sent, kept for display
+	Timestamp time.Time
+}
+
+// FeatureFlag is the global state of a feature flag
+type FeatureFlag struct {
+	Name      string `gorm:"primaryKey"`
+	Enabled   bool
+	UpdatedAt time.Time
+}
+
+// FeatureFlagOverride enables or disables a feature flag for a single user
+type FeatureFlagOverride struct {
+	FlagName  string `gorm:"primaryKey"`
+	UserID    uint   `gorm:"primaryKey"`
+	Enabled   bool
+	UpdatedAt time.Time
+}
+
+// FoodItem is a single food recognized in an analysis
+type FoodItem struct {
+	ID             uint
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	UserID         uint
+	FoodAnalysisID uint
+	Name           string  // As returned by the AI
+	NormalizedName string  // Canonical form used for search and matching
+	Carbs          float64 // Carbs of this item, 0 if unknown
+}
+
+// FoodItemCorrection is the user's change to the carbs of one food item
+type FoodItemCorrection struct {
+	ID             uint
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	CorrectionID   uint
+	FoodItemID     uint
+	OriginalCarbs  float64
+	CorrectedCarbs float64
+}
+
+type DailySummary struct {
+	ID            uint
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	UserID        uint
+	Day           time.Time // Local calendar day of the user
+	ReadingsCount int
+	MeanGlucose   float64 // mmol/L
+	MinGlucose    float64 // mmol/L
+	MaxGlucose    float64 // mmol/L
+	TimeInRange   float64 // Share of readings within 3.9-10.0 mmol/L, 0..1
+	AnalysesCount int
+	CarbsTotal    float64
+	CarbTarget    float64          // User's daily carb target at the time of aggregation
+	BolusTotal    dosing.DeciUnits // Logged bolus and correction injections
+	BasalTotal    dosing.DeciUnits // Logged basal injections plus the pump basal schedule
+}
+
+// Job is a background task such as a history export
+type Job struct {
+	ID         uint
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	UserID     uint
+	ChatID     int64
+	Kind       string // See services.JobKind* constants
+	Status     string // "queued", "running", "done" or "failed"
+	Cursor     string // Resume position; the format depends on Kind
+	FileOffset int64  // Bytes of the output file written up to Cursor
+	FilePath   string // Local file the job writes to
+	StorageKey string // Blob storage key of the finished output, empty until complete
+	Processed  int
+	Total      int
+	MessageID  int        // Status message updated with progress, 0 if none
+	RunAfter   *time.Time // Not claimed before this time; nil to run as soon as possible
+	Attempts   int
+	Error      string
+}
+
+// APIToken is a user's personal token for the HTTP API
+type APIToken struct {
+	ID         uint
+	CreatedAt  time.Time
+	UserID     uint
+	TokenHash  string // Hex SHA-256 of the token; the token itself is not stored
+	LastUsedAt *time.Time
+}
+
+// TokenUsage is the AI token usage of one UTC day for a provider and model
+type TokenUsage struct {
+	ID           uint
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Day          time.Time
+	Provider     string
+	Model        string
+	Requests     int
+	InputTokens  int64
+	OutputTokens int64
+	Cost         float64 // Estimated cost in USD
+}
+
+// UsageReport marks a month whose usage summary was sent to admins
+type UsageReport struct {
+	Month  time.Time `gorm:"primaryKey"`
+	SentAt time.Time `gorm:"autoCreateTime"`
+}
+
+// AuditEntry records an admin looking at another user's data
+type AuditEntry struct {
+	ID               uint
+	CreatedAt        time.Time
+	ActorTelegramID  int64
+	Action           string // See services.AuditAction* constants
+	TargetTelegramID int64
+	TargetUserID     *uint // Nil when no user has the target Telegram ID
+}
+
+type TransferCode struct {
+	ID        uint
+	CreatedAt time.Time
+	UserID    uint   // Account whose history the code moves
+	CodeHash  string // Hex SHA-256 of the code; the code itself is not stored
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+// QueuedAnalysis is a photo waiting until the AI rate limit allows its analysis
+type QueuedAnalysis struct {
+	ID         uint
+	CreatedAt  time.Time
+	UserID     uint
+	TelegramID int64
+	ChatID     int64
+	FileID     string // Telegram file ID of the largest photo size
+	Weight     float64
+	Dish       string // Dish named in the caption, for the history fallback
+	// Recipe or ingredient list from the caption, passed to the AI as a hint
+	Ingredients string
+	MessageID   int // Queue status message, edited with the position; 0 if none
+
+	// Set while a worker analyzes the photo; the row is deleted once the
+	// result is delivered. Attempts counts the leases taken so far.
+	ClaimedAt *time.Time
+	Attempts  int
+}
+
+// ConversationLog is one incoming update or outgoing Bot API call of the
+// optional conversation log
+type ConversationLog struct {
+	ID        uint
+	CreatedAt time.Time
+	ChatID    int64
+	UpdateID  int    // Incoming update the entry belongs to; 0 for messages sent outside one, e.g. reminders
+	Direction string // "in" or "out"
+	Kind      string // Update type or Bot API method
+	Template  string // Command, callback data or the reply's first line with digits masked
+	Variables string // Numbers in the text, space-separated
+	Text      string // Full text, only when the log keeps it
+}
+
+// TelemetryState is the single row behind the weekly telemetry report
+type TelemetryState struct {
+	ID         uint   `gorm:"primaryKey;autoIncrement:false"`
+	InstanceID string // Random hex ID, not derived from anything on the host
+	LastSentAt time.Time
+}
+
+func NewPostgresDB(cfg config.DBConfig) (*gorm.DB, error) {
+	db, err := OpenPostgresDB(cfg)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to database: %w", err)
+		return nil, err
 	}
 
 	// Get the directory of the current file
@@ -122,3 +407,23 @@ func NewPostgresDB(cfg config.DBConfig) (*gorm.DB, error) {
 	log.Println("Database connection established and migrations completed")
 	return db, nil
 }
+
+// OpenPostgresDB connects to the database without running migrations, for
+// tools that repair data a migration refuses to run on
+func OpenPostgresDB(cfg config.DBConfig) (*gorm.DB, error) {
+	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+		cfg.Host, cfg.Port, cfg.User, cfg.Password, cfg.DBName)
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
+		DisableAutomaticPing:   true,
+		SkipDefaultTransaction: false,
+		PrepareStmt:            false,
+		CreateBatchSize:        0,
+		FullSaveAssociations:   false,
+		AllowGlobalUpdate:      false,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	return db, nil
+}
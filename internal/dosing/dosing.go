@@ -0,0 +1,82 @@
+// Package dosing holds the insulin dose math shared by photo analyses,
+// manual carb entry and anything else that recommends a dose.
+package dosing
+
+import "math"
+
+// BreadUnitGrams is the amount of carbs in one bread unit (ХЕ)
+const BreadUnitGrams = 12.0
+
+// DefaultCorrectionBand is how far, in mmol/L, glucose may be from target
+// before it is corrected, unless configured
+const DefaultCorrectionBand = 1.0
+
+// Input is everything a dose recommendation depends on
+type Input struct {
+	Carbs     float64 // Grams of carbs in the meal
+	CarbRatio float64 // Insulin units per bread unit for the current time, 0 if not configured
+	// Rapid-acting insulin still active from earlier doses, taken off the
+	// meal dose; 0 to leave the dose as is
+	ActiveInsulin DeciUnits
+	// Insulin bringing high glucose down to target, see CorrectionDose; 0 for none
+	Correction DeciUnits
+}
+
+// Result is a dose recommendation with its components
+type Result struct {
+	BreadUnits float64
+	CarbRatio  float64
+	CarbDose   DeciUnits // Insulin covering the meal's carbs
+	// Insulin added to CarbDose to bring high glucose down to target
+	CorrectionDose DeciUnits
+	// Active insulin taken off CarbDose and CorrectionDose; the dose stops at
+	// zero when it is more than both
+	ActiveInsulin DeciUnits
+	Total         DeciUnits // Recommended dose
+}
+
+// BreadUnits converts grams of carbs to bread units
+func BreadUnits(carbs float64) float64 {
+	return carbs / BreadUnitGrams
+}
+
+// CorrectionDose returns the insulin that brings glucose from current down to
+// target when each unit lowers it by factor, all in mmol/L. It is 0 when
+// glucose is at or below target, within band of it (see InTargetBand) or any
+// of the values is unknown.
+func CorrectionDose(current, target, factor, band float64) DeciUnits {
+	if current <= 0 || target <= 0 || factor <= 0 || InTargetBand(current, target, band) {
+		return 0
+	}
+	return ToDeciUnits(max((current-target)/factor, 0))
+}
+
+// InTargetBand reports whether glucose is within band of target, in mmol/L.
+// Such deviations are mostly meter noise, and correcting them only makes
+// glucose swing around target; a band of 0 corrects any rise.
+func InTargetBand(current, target, band float64) bool {
+	if current <= 0 || target <= 0 || band <= 0 {
+		return false
+	}
+	// Rounded to hundredths so that e.g. 6.1 against a target of 5.1 is
+	// exactly 1.0 apart rather than a hair more
+	return math.Round(math.Abs(current-target)*100)/100 <= band
+}
+
+// CalculateDose computes the recommended insulin dose for a meal. Active
+// insulin reduces the dose down to zero but never below.
+func CalculateDose(in Input) Result {
+	breadUnits := BreadUnits(in.Carbs)
+	carbDose := ToDeciUnits(breadUnits * in.CarbRatio)
+	correction := max(in.Correction, 0)
+	active := max(in.ActiveInsulin, 0)
+
+	return Result{
+		BreadUnits:     breadUnits,
+		CarbRatio:      in.CarbRatio,
+		CarbDose:       carbDose,
+		CorrectionDose: correction,
+		ActiveInsulin:  active,
+		Total:          max(carbDose+correction-active, 0),
+	}
+}
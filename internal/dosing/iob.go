@@ -0,0 +1,70 @@
+package dosing
+
+import (
+	"math"
+	"time"
+)
+
+// DefaultActiveInsulinTime is how long rapid-acting insulin is assumed to act
+// when the user hasn't configured it
+const DefaultActiveInsulinTime = 4 * time.Hour
+
+// IOB decay models. An empty value means linear.
+const (
+	IOBLinear = "linear"
+	IOBCurved = "curved"
+)
+
+// curvePeak is when rapid-acting insulin acts the strongest in the curved model
+const curvePeak = 75 * time.Minute
+
+// Dose is an administered insulin dose
+type Dose struct {
+	Units DeciUnits
+	At    time.Time
+}
+
+// InsulinOnBoard returns the insulin still active at now, assuming each dose
+// decays to zero over duration following model. Doses in the future are
+// ignored. The sum is rounded to a tenth once, not per dose.
+func InsulinOnBoard(doses []Dose, now time.Time, duration time.Duration, model string) DeciUnits {
+	if duration <= 0 {
+		duration = DefaultActiveInsulinTime
+	}
+	remaining := linearRemaining
+	if model == IOBCurved {
+		remaining = curvedRemaining
+	}
+
+	total := 0.0
+	for _, d := range doses {
+		elapsed := now.Sub(d.At)
+		if elapsed < 0 || elapsed >= duration {
+			continue
+		}
+		total += d.Units.Units() * remaining(elapsed, duration)
+	}
+	return ToDeciUnits(total)
+}
+
+// linearRemaining is the active fraction of a dose when it decays at a constant rate
+func linearRemaining(elapsed, duration time.Duration) float64 {
+	return 1 - float64(elapsed)/float64(duration)
+}
+
+// curvedRemaining is the active fraction of a dose under the exponential
+// insulin activity curve used by open-source dosing systems: activity rises
+// to a peak at curvePeak and then tails off, so little insulin is used in the
+// first minutes and more of it stays active later than with linear decay.
+// For short durations the peak moves to a third of the duration.
+func curvedRemaining(elapsed, duration time.Duration) float64 {
+	td := duration.Minutes()
+	tp := math.Min(curvePeak.Minutes(), td/3)
+	t := elapsed.Minutes()
+
+	tau := tp * (1 - tp/td) / (1 - 2*tp/td)
+	a := 2 * tau / td
+	s := 1 / (1 - a + (1+a)*math.Exp(-td/tau))
+	remaining := 1 - s*(1-a)*((t*t/(tau*td*(1-a))-t/tau-1)*math.Exp(-t/tau)+1)
+	return math.Max(0, math.Min(1, remaining))
+}
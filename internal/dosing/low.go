@@ -0,0 +1,28 @@
+package dosing
+
+import "math"
+
+// DefaultLowTarget is the glucose level in mmol/L a low is treated up to when
+// the user hasn't set one
+const DefaultLowTarget = 5.5
+
+// HypoThreshold is the glucose level in mmol/L below which a reading is a
+// hypo and a meal bolus must wait until the low is treated
+const HypoThreshold = 3.9
+
+// BolusUnsafe reports whether injecting a meal bolus at glucose bg (mmol/L, 0
+// when unknown) is dangerous
+func BolusUnsafe(bg float64) bool {
+	return bg > 0 && bg < HypoThreshold
+}
+
+// LowTreatmentGrams returns the grams of fast carbs that raise glucose from
+// current to target, by the user's rule that ruleGrams raise it by ruleRise
+// (both in mmol/L). It is rounded up to whole grams and 0 when no carbs are
+// needed or the rule isn't set.
+func LowTreatmentGrams(current, target, ruleGrams, ruleRise float64) float64 {
+	if ruleGrams <= 0 || ruleRise <= 0 || current >= target {
+		return 0
+	}
+	return math.Ceil((target - current) / ruleRise * ruleGrams)
+}
@@ -0,0 +1,57 @@
+package dosing_test
+
+import (
+	"testing"
+
+	"github.com/vladimiradmaev/diabetes-helper/internal/dosing"
+	"github.com/vladimiradmaev/diabetes-helper/internal/services"
+)
+
+func TestBolusUnsafe(t *testing.T) {
+	tests := []struct {
+		name string
+		bg   float64 // mmol/L
+		want bool
+	}{
+		{"unknown", 0, false},
+		{"deep hypo", 2.2, true},
+		{"just below threshold", 3.8, true},
+		{"hair below threshold", 3.89, true},
+		{"at threshold", dosing.HypoThreshold, false},
+		{"hair above threshold", 3.91, false},
+		{"just above threshold", 4.0, false},
+		{"in range", 6.5, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dosing.BolusUnsafe(tt.bg); got != tt.want {
+				t.Errorf("BolusUnsafe(%v) = %v, want %v", tt.bg, got, tt.want)
+			}
+		})
+	}
+}
+
+// Readings entered in mg/dL are stored in mmol/L and checked in mmol/L, so
+// the threshold must hold after the conversion: 70 mg/dL is the usual hypo
+// boundary and is not a hypo itself
+func TestBolusUnsafeMgdl(t *testing.T) {
+	tests := []struct {
+		name string
+		mgdl float64
+		want bool
+	}{
+		{"deep hypo", 40, true},
+		{"just below threshold", 69, true},
+		{"at threshold", 70, false},
+		{"just above threshold", 71, false},
+		{"in range", 120, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bg := services.ToMmol(tt.mgdl, services.GlucoseUnitMgdl)
+			if got := dosing.BolusUnsafe(bg); got != tt.want {
+				t.Errorf("BolusUnsafe(%v mg/dL = %v mmol/L) = %v, want %v", tt.mgdl, bg, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package dosing
+
+// Glycemic index categories of a meal as reported by the AI. An empty value
+// means unknown.
+const (
+	GIHigh   = "high"
+	GIMedium = "medium"
+	GILow    = "low"
+)
+
+// Timing is when to inject the meal bolus relative to eating
+type Timing int
+
+const (
+	TimingUnknown     Timing = iota // No advice: neither the meal's GI nor a notable glucose level is known
+	TimingAfterStart                // After starting to eat, so a low isn't deepened before the carbs arrive
+	TimingRightBefore               // Right before eating
+	TimingBefore                    // 5–10 minutes before eating
+	TimingWellBefore                // 10–15 minutes before eating
+	TimingLongBefore                // 15–20 minutes before eating
+)
+
+// TimingConfig holds the glucose levels in mmol/L that shift the injection time
+type TimingConfig struct {
+	LowBG  float64 // Below this, inject after starting to eat
+	HighBG float64 // From this on, inject one step earlier than the GI alone suggests
+}
+
+// DefaultTimingConfig returns the thresholds used unless configured
+func DefaultTimingConfig() TimingConfig {
+	return TimingConfig{LowBG: 5, HighBG: 10}
+}
+
+// BolusTiming advises when to inject for a meal of the given GI category.
+// Fast carbs need the insulin to start working first, slow ones don't; a
+// high current glucose asks for more head start and a low one for none at
+// all. bg is the current glucose in mmol/L, 0 when unknown.
+func BolusTiming(gi string, bg float64, c TimingConfig) Timing {
+	if bg > 0 && bg < c.LowBG {
+		return TimingAfterStart
+	}
+
+	var timing Timing
+	switch gi {
+	case GIHigh:
+		timing = TimingWellBefore
+	case GIMedium:
+		timing = TimingBefore
+	case GILow:
+		timing = TimingRightBefore
+	default:
+		return TimingUnknown
+	}
+	if bg >= c.HighBG && timing < TimingLongBefore {
+		timing++
+	}
+	return timing
+}
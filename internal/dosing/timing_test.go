@@ -0,0 +1,46 @@
+package dosing
+
+import "testing"
+
+func TestBolusTiming(t *testing.T) {
+	c := DefaultTimingConfig()
+	tests := []struct {
+		name string
+		gi   string
+		bg   float64
+		want Timing
+	}{
+		{"unknown GI, unknown glucose", "", 0, TimingUnknown},
+		{"unknown GI, normal glucose", "", 7, TimingUnknown},
+		{"unknown GI, high glucose", "", 12, TimingUnknown},
+		{"unknown GI, low glucose", "", 4.5, TimingAfterStart},
+		{"high GI", GIHigh, 0, TimingWellBefore},
+		{"medium GI", GIMedium, 0, TimingBefore},
+		{"low GI", GILow, 0, TimingRightBefore},
+		{"unrecognized GI", "very high", 7, TimingUnknown},
+		{"high GI, low glucose", GIHigh, 4.9, TimingAfterStart},
+		{"high GI, at low threshold", GIHigh, 5, TimingWellBefore},
+		{"high GI, below high threshold", GIHigh, 9.9, TimingWellBefore},
+		{"high GI, at high threshold", GIHigh, 10, TimingLongBefore},
+		{"medium GI, high glucose", GIMedium, 14, TimingWellBefore},
+		{"low GI, high glucose", GILow, 14, TimingBefore},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := BolusTiming(tt.gi, tt.bg, c); got != tt.want {
+				t.Errorf("BolusTiming(%q, %v) = %d, want %d", tt.gi, tt.bg, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBolusTimingCustomConfig(t *testing.T) {
+	c := TimingConfig{LowBG: 4, HighBG: 8}
+	if got := BolusTiming(GIMedium, 4.5, c); got != TimingBefore {
+		t.Errorf("BolusTiming above the custom low threshold = %d, want %d", got, TimingBefore)
+	}
+	if got := BolusTiming(GIMedium, 8, c); got != TimingWellBefore {
+		t.Errorf("BolusTiming at the custom high threshold = %d, want %d", got, TimingWellBefore)
+	}
+}
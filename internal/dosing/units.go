@@ -0,0 +1,18 @@
+package dosing
+
+import "math"
+
+// DeciUnits is an amount of insulin in tenths of a unit. Doses are stored and
+// added up as integers, so sums of 0.1 steps don't drift to 2.9999999; they
+// are converted to units only for display and export.
+type DeciUnits int64
+
+// ToDeciUnits rounds an amount of insulin in units to the nearest tenth
+func ToDeciUnits(units float64) DeciUnits {
+	return DeciUnits(math.Round(units * 10))
+}
+
+// Units returns the amount in units
+func (d DeciUnits) Units() float64 {
+	return float64(d) / 10
+}
@@ -0,0 +1,84 @@
+package dosing
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeciUnitsSumDoesNotDrift(t *testing.T) {
+	step := ToDeciUnits(0.1)
+	var total DeciUnits
+	for i := 0; i < 1000; i++ {
+		total += step
+	}
+	if total != 1000 || total.Units() != 100 {
+		t.Errorf("1000 × 0.1 U = %v (%d tenths), want 100 U", total.Units(), total)
+	}
+}
+
+func TestToDeciUnits(t *testing.T) {
+	tests := []struct {
+		units float64
+		want  DeciUnits
+	}{
+		{0, 0},
+		{0.1, 1},
+		{0.25, 3},
+		{1.04, 10},
+		{2.9999999, 30},
+		{0.1 + 0.2, 3},
+	}
+	for _, tt := range tests {
+		if got := ToDeciUnits(tt.units); got != tt.want {
+			t.Errorf("ToDeciUnits(%v) = %d, want %d", tt.units, got, tt.want)
+		}
+	}
+}
+
+// A thousand 0.1 U doses must leave exactly as much insulin on board as one
+// 100 U dose given at the same time, with either decay model
+func TestInsulinOnBoardDoesNotDrift(t *testing.T) {
+	now := time.Date(2024, 3, 21, 12, 0, 0, 0, time.UTC)
+	duration := DefaultActiveInsulinTime
+
+	for _, model := range []string{IOBLinear, IOBCurved} {
+		for _, elapsed := range []time.Duration{0, 30 * time.Minute, 75 * time.Minute, 2 * time.Hour, 3*time.Hour + 59*time.Minute, duration} {
+			at := now.Add(-elapsed)
+			small := make([]Dose, 1000)
+			for i := range small {
+				small[i] = Dose{Units: ToDeciUnits(0.1), At: at}
+			}
+			whole := []Dose{{Units: ToDeciUnits(100), At: at}}
+
+			got := InsulinOnBoard(small, now, duration, model)
+			want := InsulinOnBoard(whole, now, duration, model)
+			if got != want {
+				t.Errorf("%s after %v: 1000 × 0.1 U leaves %v U, one 100 U dose %v U", model, elapsed, got.Units(), want.Units())
+			}
+		}
+	}
+}
+
+func TestInsulinOnBoardLinearTotals(t *testing.T) {
+	now := time.Date(2024, 3, 21, 12, 0, 0, 0, time.UTC)
+	duration := DefaultActiveInsulinTime
+	tests := []struct {
+		elapsed time.Duration
+		want    DeciUnits
+	}{
+		{0, 1000},
+		{time.Hour, 750},
+		{2 * time.Hour, 500},
+		{3 * time.Hour, 250},
+		{duration, 0},
+	}
+	for _, tt := range tests {
+		doses := make([]Dose, 1000)
+		for i := range doses {
+			doses[i] = Dose{Units: ToDeciUnits(0.1), At: now.Add(-tt.elapsed)}
+		}
+		if got := InsulinOnBoard(doses, now, duration, IOBLinear); got != tt.want {
+			t.Errorf("after %v: %v U on board, want %v U", tt.elapsed, got.Units(), tt.want.Units())
+		}
+	}
+}
@@ -0,0 +1,91 @@
+// Package events lets code outside the services react to what users record,
+// e.g. to forward new analyses to a dashboard, without changing the services.
+// The services publish an event after the change is committed; subscribers
+// are registered once at startup.
+package events
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/vladimiradmaev/diabetes-helper/internal/dosing"
+	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+)
+
+// Event is one of the event types below
+type Event interface {
+	// Name identifies the event type, e.g. in webhook payloads
+	Name() string
+}
+
+// AnalysisSaved is published when a meal analysis is saved or corrected
+type AnalysisSaved struct {
+	UserID       uint
+	AnalysisID   uint
+	Carbs        float64
+	BreadUnits   float64
+	InsulinUnits dosing.DeciUnits // Recommended dose
+	Provider     string           // AI provider, or "manual" or "history"
+	Time         time.Time
+}
+
+// BloodSugarLogged is published when the user logs a glucose reading
+type BloodSugarLogged struct {
+	UserID   uint
+	RecordID uint
+	Value    float64 // mmol/L
+	Time     time.Time
+}
+
+// InjectionLogged is published when the user logs an insulin injection
+type InjectionLogged struct {
+	UserID      uint
+	InjectionID uint
+	Units       dosing.DeciUnits
+	Kind        string
+	Site        string // May be empty
+	Time        time.Time
+}
+
+func (AnalysisSaved) Name() string    { return "analysis_saved" }
+func (BloodSugarLogged) Name() string { return "blood_sugar_logged" }
+func (InjectionLogged) Name() string  { return "injection_logged" }
+
+// Subscriber handles published events. It runs on the publisher's goroutine,
+// so slow work such as network requests must be moved off it.
+type Subscriber func(ctx context.Context, event Event)
+
+var (
+	subscribersMu sync.RWMutex
+	subscribers   []Subscriber
+)
+
+// Subscribe registers a subscriber for all events
+func Subscribe(s Subscriber) {
+	subscribersMu.Lock()
+	defer subscribersMu.Unlock()
+	subscribers = append(subscribers, s)
+}
+
+// Publish passes the event to every subscriber in the order they subscribed.
+// A panicking subscriber is logged and does not affect the publisher or the
+// other subscribers.
+func Publish(ctx context.Context, event Event) {
+	subscribersMu.RLock()
+	current := subscribers
+	subscribersMu.RUnlock()
+
+	for _, s := range current {
+		deliver(ctx, s, event)
+	}
+}
+
+func deliver(ctx context.Context, s Subscriber, event Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error("Event subscriber panicked", "event", event.Name(), "panic", r)
+		}
+	}()
+	s(ctx, event)
+}
@@ -0,0 +1,111 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+)
+
+// webhookTimeout bounds a single delivery to the webhook
+const webhookTimeout = 10 * time.Second
+
+// webhookPayload is the JSON body POSTed for each event
+type webhookPayload struct {
+	Event  string      `json:"event"`
+	UserID uint        `json:"user_id"`
+	Time   time.Time   `json:"time"`
+	Data   interface{} `json:"data"`
+}
+
+type webhookAnalysis struct {
+	AnalysisID       uint    `json:"analysis_id"`
+	Carbs            float64 `json:"carbs"`
+	BreadUnits       float64 `json:"bread_units"`
+	RecommendedUnits float64 `json:"recommended_units"`
+	Provider         string  `json:"provider"`
+}
+
+type webhookBloodSugar struct {
+	RecordID uint    `json:"record_id"`
+	Mmol     float64 `json:"mmol"`
+}
+
+type webhookInjection struct {
+	InjectionID uint    `json:"injection_id"`
+	Units       float64 `json:"units"`
+	Kind        string  `json:"kind"`
+	Site        string  `json:"site,omitempty"`
+}
+
+// Webhook returns a subscriber that POSTs every event as JSON to url. Each
+// delivery runs in the background and is not retried; failures are logged.
+func Webhook(url string) Subscriber {
+	client := &http.Client{Timeout: webhookTimeout}
+	return func(ctx context.Context, event Event) {
+		payload, ok := newWebhookPayload(event)
+		if !ok {
+			return
+		}
+		body, err := json.Marshal(payload)
+		if err != nil {
+			logger.Error("Failed to encode webhook event", "event", event.Name(), "error", err)
+			return
+		}
+		// The request outlives the update that published the event
+		ctx = context.WithoutCancel(ctx)
+		go func() {
+			if err := postWebhook(ctx, client, url, body); err != nil {
+				logger.Warning("Failed to deliver webhook event", "event", event.Name(), "error", err)
+			}
+		}()
+	}
+}
+
+func newWebhookPayload(event Event) (webhookPayload, bool) {
+	switch e := event.(type) {
+	case AnalysisSaved:
+		return webhookPayload{Event: e.Name(), UserID: e.UserID, Time: e.Time, Data: webhookAnalysis{
+			AnalysisID:       e.AnalysisID,
+			Carbs:            e.Carbs,
+			BreadUnits:       e.BreadUnits,
+			RecommendedUnits: e.InsulinUnits.Units(),
+			Provider:         e.Provider,
+		}}, true
+	case BloodSugarLogged:
+		return webhookPayload{Event: e.Name(), UserID: e.UserID, Time: e.Time, Data: webhookBloodSugar{
+			RecordID: e.RecordID,
+			Mmol:     e.Value,
+		}}, true
+	case InjectionLogged:
+		return webhookPayload{Event: e.Name(), UserID: e.UserID, Time: e.Time, Data: webhookInjection{
+			InjectionID: e.InjectionID,
+			Units:       e.Units.Units(),
+			Kind:        e.Kind,
+			Site:        e.Site,
+		}}, true
+	}
+	return webhookPayload{}, false
+}
+
+func postWebhook(ctx context.Context, client *http.Client, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
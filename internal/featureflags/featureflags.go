@@ -0,0 +1,224 @@
+// Package featureflags gates experimental features. A flag's default comes
+// from the environment (FEATURE_<NAME>=true), or from builtinDefaults when
+// unset; a row in the feature_flags table overrides it for everyone, and
+// feature_flag_overrides for single users.
+package featureflags
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Known flags
+const (
+	// OpenAIFallback lets meal photos go to OpenAI while Gemini is over quota
+	// or down. On by default; turned off for a user, their photos are only
+	// ever sent to Gemini.
+	OpenAIFallback = "openai_fallback"
+
+	// Maintenance is a global operator switch: while on, non-admin users only
+	// get a "service unavailable" reply. Check it with userID 0.
+	Maintenance = "maintenance"
+)
+
+// cacheTTL bounds how long a change in the database takes to apply
+const cacheTTL = time.Minute
+
+// After a failed refresh the next one waits minRetryDelay, doubling with every
+// further failure up to cacheTTL, so an outage doesn't turn every check into
+// a database query
+const minRetryDelay = 5 * time.Second
+
+type snapshot struct {
+	global    map[string]bool
+	overrides map[string]map[uint]bool
+	loadedAt  time.Time
+}
+
+// Flags resolves feature flags for users
+type Flags struct {
+	db  *gorm.DB
+	env map[string]bool
+
+	mu    sync.Mutex
+	cache *snapshot
+	// refreshing is set while one caller loads the flags; the others keep
+	// using cache meanwhile instead of waiting for the database
+	refreshing bool
+	generation int // Incremented by invalidate, so a refresh started before a change isn't kept as fresh
+	retryAt    time.Time
+	retryDelay time.Duration
+}
+
+// builtinDefaults are the defaults of flags that are on without FEATURE_<NAME>
+var builtinDefaults = map[string]bool{
+	OpenAIFallback: true,
+}
+
+// New creates flags backed by the database with env defaults
+func New(db *gorm.DB) *Flags {
+	return &Flags{
+		db:  db,
+		env: envDefaults(os.Environ()),
+	}
+}
+
+// envDefaults collects FEATURE_<NAME>=<bool> variables over builtinDefaults
+func envDefaults(environ []string) map[string]bool {
+	defaults := make(map[string]bool, len(builtinDefaults))
+	for name, enabled := range builtinDefaults {
+		defaults[name] = enabled
+	}
+	for _, kv := range environ {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok || !strings.HasPrefix(key, "FEATURE_") {
+			continue
+		}
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			continue
+		}
+		defaults[strings.ToLower(strings.TrimPrefix(key, "FEATURE_"))] = enabled
+	}
+	return defaults
+}
+
+// Enabled reports whether a flag is on for the user. On database errors the
+// last loaded state (or the env default) is used, so a flag never fails open
+// because of an outage.
+func (f *Flags) Enabled(ctx context.Context, name string, userID uint) bool {
+	snap := f.load(ctx)
+	if snap != nil {
+		if enabled, ok := snap.overrides[name][userID]; ok {
+			return enabled
+		}
+		if enabled, ok := snap.global[name]; ok {
+			return enabled
+		}
+	}
+	return f.env[name]
+}
+
+// SetGlobal stores the state of a flag for everyone
+func (f *Flags) SetGlobal(ctx context.Context, name string, enabled bool) error {
+	flag := database.FeatureFlag{Name: name, Enabled: enabled, UpdatedAt: time.Now()}
+	if err := f.db.WithContext(ctx).
+		Clauses(clause.OnConflict{UpdateAll: true}).
+		Create(&flag).Error; err != nil {
+		return fmt.Errorf("failed to set feature flag: %w", err)
+	}
+	f.invalidate()
+	return nil
+}
+
+// SetOverride enables or disables a flag for one user
+func (f *Flags) SetOverride(ctx context.Context, name string, userID uint, enabled bool) error {
+	override := database.FeatureFlagOverride{FlagName: name, UserID: userID, Enabled: enabled, UpdatedAt: time.Now()}
+	if err := f.db.WithContext(ctx).
+		Clauses(clause.OnConflict{UpdateAll: true}).
+		Create(&override).Error; err != nil {
+		return fmt.Errorf("failed to set feature flag override: %w", err)
+	}
+	f.invalidate()
+	return nil
+}
+
+// ClearOverride removes a user's override so the global state applies again
+func (f *Flags) ClearOverride(ctx context.Context, name string, userID uint) error {
+	if err := f.db.WithContext(ctx).
+		Where("flag_name = ? AND user_id = ?", name, userID).
+		Delete(&database.FeatureFlagOverride{}).Error; err != nil {
+		return fmt.Errorf("failed to clear feature flag override: %w", err)
+	}
+	f.invalidate()
+	return nil
+}
+
+// load returns the cached flags, refreshing them when they are older than
+// cacheTTL. The database is queried without holding the lock: while one
+// caller refreshes, or after a failed refresh until retryAt, the others get
+// the last snapshot, which is nil if the flags were never loaded.
+func (f *Flags) load(ctx context.Context) *snapshot {
+	f.mu.Lock()
+	fresh := f.cache != nil && time.Since(f.cache.loadedAt) < cacheTTL
+	if fresh || f.refreshing || time.Now().Before(f.retryAt) {
+		snap := f.cache
+		f.mu.Unlock()
+		return snap
+	}
+	f.refreshing = true
+	generation := f.generation
+	f.mu.Unlock()
+
+	snap, err := f.query(ctx)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.refreshing = false
+	if err != nil {
+		f.retryDelay = min(max(2*f.retryDelay, minRetryDelay), cacheTTL)
+		f.retryAt = time.Now().Add(f.retryDelay)
+		logger.Error("Failed to refresh feature flags, using the last loaded state", "error", err, "retry_in", f.retryDelay)
+		return f.cache
+	}
+	f.retryDelay = 0
+	f.retryAt = time.Time{}
+	if generation != f.generation {
+		// Changed while loading: serve what was read, but read again next time
+		snap.loadedAt = time.Time{}
+	}
+	f.cache = snap
+	return snap
+}
+
+// query reads all flags and overrides from the database
+func (f *Flags) query(ctx context.Context) (*snapshot, error) {
+	var flags []database.FeatureFlag
+	var overrides []database.FeatureFlagOverride
+	if err := f.db.WithContext(ctx).Find(&flags).Error; err != nil {
+		return nil, fmt.Errorf("failed to load feature flags: %w", err)
+	}
+	if err := f.db.WithContext(ctx).Find(&overrides).Error; err != nil {
+		return nil, fmt.Errorf("failed to load feature flag overrides: %w", err)
+	}
+
+	snap := &snapshot{
+		global:    make(map[string]bool, len(flags)),
+		overrides: make(map[string]map[uint]bool),
+		loadedAt:  time.Now(),
+	}
+	for _, flag := range flags {
+		snap.global[flag.Name] = flag.Enabled
+	}
+	for _, o := range overrides {
+		if snap.overrides[o.FlagName] == nil {
+			snap.overrides[o.FlagName] = make(map[uint]bool)
+		}
+		snap.overrides[o.FlagName][o.UserID] = o.Enabled
+	}
+	return snap, nil
+}
+
+// invalidate makes the next check reload the flags. The old snapshot is kept
+// for when the reload fails.
+func (f *Flags) invalidate() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.generation++
+	f.retryAt = time.Time{}
+	if f.cache != nil {
+		stale := *f.cache
+		stale.loadedAt = time.Time{}
+		f.cache = &stale
+	}
+}
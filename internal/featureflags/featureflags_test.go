@@ -0,0 +1,50 @@
+package featureflags
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEnvDefaults(t *testing.T) {
+	tests := []struct {
+		name    string
+		environ []string
+		want    map[string]bool
+	}{
+		{"builtin only", []string{"PATH=/bin"}, map[string]bool{OpenAIFallback: true}},
+		{"env turns builtin off", []string{"FEATURE_OPENAI_FALLBACK=false"}, map[string]bool{OpenAIFallback: false}},
+		{"env flag", []string{"FEATURE_MAINTENANCE=1"}, map[string]bool{OpenAIFallback: true, Maintenance: true}},
+		{"invalid value ignored", []string{"FEATURE_MAINTENANCE=maybe"}, map[string]bool{OpenAIFallback: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := envDefaults(tt.environ); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("envDefaults(%v) = %v, want %v", tt.environ, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnabledPrecedence(t *testing.T) {
+	f := &Flags{
+		env: map[string]bool{OpenAIFallback: true},
+		cache: &snapshot{
+			global:    map[string]bool{Maintenance: true},
+			overrides: map[string]map[uint]bool{OpenAIFallback: {7: false}},
+		},
+	}
+	f.cache.loadedAt = time.Now()
+
+	ctx := context.Background()
+	if !f.Enabled(ctx, OpenAIFallback, 1) {
+		t.Error("openai_fallback is off for a user without an override")
+	}
+	if f.Enabled(ctx, OpenAIFallback, 7) {
+		t.Error("openai_fallback is on for a user who has it turned off")
+	}
+	if !f.Enabled(ctx, Maintenance, 0) {
+		t.Error("maintenance set globally is off")
+	}
+}
@@ -0,0 +1,153 @@
+// Package format renders numbers, glucose values and dates for messages. All
+// user-facing text goes through it, so output can follow the user's language
+// once the bot has more than one.
+//
+// Decimals are written with a dot in every locale: the bot has always shown
+// them that way and its input examples ("например: 6.5") ask for a dot.
+package format
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Locale selects the language of formatted values
+type Locale string
+
+// Supported locales
+const (
+	RU Locale = "ru"
+	EN Locale = "en"
+)
+
+// Default is the locale of all messages until users can choose one
+const Default = RU
+
+// unitMgdl is services.GlucoseUnitMgdl; any other unit is mmol/L
+const unitMgdl = "mgdl"
+
+type localeData struct {
+	mmol, mgdl string
+	grams      string
+	breadUnits string
+	exactly    string
+	dateTime   string
+	date       string
+	shortDate  string
+	clock      string
+}
+
+var locales = map[Locale]localeData{
+	RU: {
+		mmol:       "ммоль/л",
+		mgdl:       "мг/дл",
+		grams:      "г",
+		breadUnits: "ХЕ",
+		exactly:    "точно",
+		dateTime:   "02.01 15:04",
+		date:       "02.01.2006",
+		shortDate:  "02.01",
+		clock:      "15:04",
+	},
+	EN: {
+		mmol:       "mmol/L",
+		mgdl:       "mg/dL",
+		grams:      "g",
+		breadUnits: "XE",
+		exactly:    "exactly",
+		dateTime:   "Jan 2 15:04",
+		date:       "Jan 2, 2006",
+		shortDate:  "Jan 2",
+		clock:      "15:04",
+	},
+}
+
+func data(l Locale) localeData {
+	if d, ok := locales[l]; ok {
+		return d
+	}
+	return locales[Default]
+}
+
+// Number formats a value with the given number of decimals
+func Number(value float64, decimals int) string {
+	return strconv.FormatFloat(value, 'f', decimals, 64)
+}
+
+// GlucoseUnit returns the label of a glucose unit, "mmol" or "mgdl"
+func GlucoseUnit(unit string, l Locale) string {
+	if unit == unitMgdl {
+		return data(l).mgdl
+	}
+	return data(l).mmol
+}
+
+// Glucose formats a glucose value that is already in the given unit: whole
+// numbers for mg/dL, one decimal for mmol/L
+func Glucose(value float64, unit string, l Locale) string {
+	decimals := 1
+	if unit == unitMgdl {
+		decimals = 0
+	}
+	return fmt.Sprintf("%s %s", Number(value, decimals), GlucoseUnit(unit, l))
+}
+
+// Grams formats a weight or an amount of carbs with the given decimals
+func Grams(value float64, decimals int, l Locale) string {
+	return fmt.Sprintf("%s %s", Number(value, decimals), data(l).grams)
+}
+
+// BreadUnitsNumber formats bread units rounded to step (0.1 when step is 0),
+// followed by the value to one decimal when rounding changed it, e.g.
+// "2.5 (точно 2.3)". Only the display is rounded, doses keep full precision.
+func BreadUnitsNumber(value, step float64, l Locale) string {
+	rounded, exact := roundBreadUnits(value, step)
+	if rounded == exact {
+		return exact
+	}
+	return fmt.Sprintf("%s (%s %s)", rounded, data(l).exactly, exact)
+}
+
+// BreadUnits formats bread units like BreadUnitsNumber with the unit, e.g.
+// "2.5 ХЕ (точно 2.3)"
+func BreadUnits(value, step float64, l Locale) string {
+	rounded, exact := roundBreadUnits(value, step)
+	if rounded == exact {
+		return fmt.Sprintf("%s %s", exact, data(l).breadUnits)
+	}
+	return fmt.Sprintf("%s %s (%s %s)", rounded, data(l).breadUnits, data(l).exactly, exact)
+}
+
+// roundBreadUnits returns the value rounded to step and to one decimal. Two
+// decimals fit quarter steps; a trailing zero is dropped so halves and whole
+// units read like the exact value ("2.5", "3.0").
+func roundBreadUnits(value, step float64) (rounded, exact string) {
+	exact = Number(value, 1)
+	if step <= 0 {
+		return exact, exact
+	}
+	return strings.TrimSuffix(Number(math.Round(value/step)*step, 2), "0"), exact
+}
+
+// DateTime formats a moment in tz as day, month and time, e.g. "02.01 15:04"
+func DateTime(t time.Time, tz *time.Location, l Locale) string {
+	return t.In(tz).Format(data(l).dateTime)
+}
+
+// Date formats the day of a moment in tz with the year, e.g. "02.01.2006"
+func Date(t time.Time, tz *time.Location, l Locale) string {
+	return t.In(tz).Format(data(l).date)
+}
+
+// ShortDate formats the day of a moment in tz without the year, e.g. "02.01"
+func ShortDate(t time.Time, tz *time.Location, l Locale) string {
+	return t.In(tz).Format(data(l).shortDate)
+}
+
+// Clock formats the time of day of a moment in tz, e.g. "15:04"
+func Clock(t time.Time, tz *time.Location, l Locale) string {
+	return t.In(tz).Format(data(l).clock)
+}
@@ -0,0 +1,106 @@
+package format
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGlucose(t *testing.T) {
+	tests := []struct {
+		value float64
+		unit  string
+		l     Locale
+		want  string
+	}{
+		{6.5, "mmol", RU, "6.5 ммоль/л"},
+		{6, "mmol", RU, "6.0 ммоль/л"},
+		{6.54, "", RU, "6.5 ммоль/л"}, // Any unit but mg/dL is mmol/L
+		{117, unitMgdl, RU, "117 мг/дл"},
+		{117.4, unitMgdl, EN, "117 mg/dL"},
+		{6.5, "mmol", EN, "6.5 mmol/L"},
+		{6.5, "mmol", "de", "6.5 ммоль/л"}, // Unknown locales fall back to Default
+	}
+
+	for _, tt := range tests {
+		if got := Glucose(tt.value, tt.unit, tt.l); got != tt.want {
+			t.Errorf("Glucose(%v, %q, %s) = %q, want %q", tt.value, tt.unit, tt.l, got, tt.want)
+		}
+	}
+}
+
+func TestGrams(t *testing.T) {
+	tests := []struct {
+		value    float64
+		decimals int
+		l        Locale
+		want     string
+	}{
+		{45, 0, RU, "45 г"},
+		{45.26, 1, RU, "45.3 г"},
+		{45.26, 1, EN, "45.3 g"},
+	}
+
+	for _, tt := range tests {
+		if got := Grams(tt.value, tt.decimals, tt.l); got != tt.want {
+			t.Errorf("Grams(%v, %d, %s) = %q, want %q", tt.value, tt.decimals, tt.l, got, tt.want)
+		}
+	}
+}
+
+func TestBreadUnits(t *testing.T) {
+	tests := []struct {
+		name       string
+		value      float64
+		step       float64
+		want       string
+		wantNumber string
+	}{
+		{"no step", 2.34, 0, "2.3 ХЕ", "2.3"},
+		{"tenths step", 2.34, 0.1, "2.3 ХЕ", "2.3"},
+		{"half step rounds", 2.3, 0.5, "2.5 ХЕ (точно 2.3)", "2.5 (точно 2.3)"},
+		{"half step exact", 2.5, 0.5, "2.5 ХЕ", "2.5"},
+		{"whole value on half step", 3, 0.5, "3.0 ХЕ", "3.0"},
+		{"quarter step", 2.3, 0.25, "2.25 ХЕ (точно 2.3)", "2.25 (точно 2.3)"},
+		{"whole step", 2.2, 1, "2.0 ХЕ (точно 2.2)", "2.0 (точно 2.2)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := BreadUnits(tt.value, tt.step, RU); got != tt.want {
+				t.Errorf("BreadUnits(%v, %v) = %q, want %q", tt.value, tt.step, got, tt.want)
+			}
+			if got := BreadUnitsNumber(tt.value, tt.step, RU); got != tt.wantNumber {
+				t.Errorf("BreadUnitsNumber(%v, %v) = %q, want %q", tt.value, tt.step, got, tt.wantNumber)
+			}
+		})
+	}
+}
+
+func TestDates(t *testing.T) {
+	moscow := time.FixedZone("MSK", 3*60*60)
+	// 21:30 UTC is already the next day in Moscow
+	at := time.Date(2025, 1, 2, 21, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		format func(time.Time, *time.Location, Locale) string
+		l      Locale
+		want   string
+	}{
+		{"date time", DateTime, RU, "03.01 00:30"},
+		{"date time en", DateTime, EN, "Jan 3 00:30"},
+		{"date", Date, RU, "03.01.2025"},
+		{"date en", Date, EN, "Jan 3, 2025"},
+		{"short date", ShortDate, RU, "03.01"},
+		{"short date en", ShortDate, EN, "Jan 3"},
+		{"clock", Clock, RU, "00:30"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.format(at, moscow, tt.l); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
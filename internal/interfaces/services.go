@@ -2,40 +2,229 @@ package interfaces
 
 import (
 	"context"
+	"time"
 
+	"github.com/vladimiradmaev/diabetes-helper/internal/config"
 	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/dosing"
 	"github.com/vladimiradmaev/diabetes-helper/internal/services"
+	"github.com/vladimiradmaev/diabetes-helper/internal/sharecode"
+	"github.com/vladimiradmaev/diabetes-helper/internal/telemetry"
 )
 
 // UserServiceInterface defines the contract for user operations
 type UserServiceInterface interface {
 	RegisterUser(ctx context.Context, telegramID int64, username, firstName, lastName string) (*database.User, error)
 	GetUserByTelegramID(ctx context.Context, telegramID int64) (*database.User, error)
+	SetGlucoseUnit(ctx context.Context, userID uint, unit string) error
+	SetResultVerbosity(ctx context.Context, userID uint, verbosity string) error
+	SetArchivePhotos(ctx context.Context, userID uint, enabled bool) error
+	SetKeepPrompts(ctx context.Context, userID uint, keep bool) error
+	SetHideBolusTiming(ctx context.Context, userID uint, hide bool) error
+	SetReanalyzeOnPrimary(ctx context.Context, userID uint, enabled bool) error
+	SetBreadUnitStep(ctx context.Context, userID uint, step float64) error
+	SetCarbsStep(ctx context.Context, userID uint, step float64) error
+	SetIOBModel(ctx context.Context, userID uint, model string) error
+	SetMeasurementSystem(ctx context.Context, userID uint, system string) error
+	SetBasalReminder(ctx context.Context, userID uint, at string, units dosing.DeciUnits) error
+	SetQuietHours(ctx context.Context, userID uint, start, end string) error
+	SetNotificationsPaused(ctx context.Context, userID uint, paused bool) error
+	SetDailyCarbTarget(ctx context.Context, userID uint, grams float64) error
+	SetLowRule(ctx context.Context, userID uint, grams, rise, target float64) error
+	SetCorrection(ctx context.Context, userID uint, factor, target float64) error
+	ScheduleLowRecheck(ctx context.Context, userID uint, at time.Time) error
+	DueLowRechecks(ctx context.Context, now time.Time, userIDs []uint) ([]database.User, error)
+	RestoreLowRecheck(ctx context.Context, userID uint, at time.Time) error
+	SetTimezone(ctx context.Context, userID uint, timezone string) error
+	SetTravelMode(ctx context.Context, userID uint, timezone string, until *time.Time) error
+	ClearTravelMode(ctx context.Context, userID uint) error
+	ClearExpiredTravelModes(ctx context.Context) error
 }
 
 // FoodAnalysisServiceInterface defines the contract for food analysis operations
 type FoodAnalysisServiceInterface interface {
-	AnalyzeFood(ctx context.Context, userID uint, imageURL string, weight float64) (*database.FoodAnalysis, error)
-	GetUserAnalyses(ctx context.Context, userID uint) ([]database.FoodAnalysis, error)
+	AnalyzeFood(ctx context.Context, userID uint, imageURL string, weight float64, ingredients string) (*database.FoodAnalysis, error)
+	GetUserAnalyses(ctx context.Context, userID uint, limit, offset int) ([]database.FoodAnalysis, int, error)
+	GetAnalysis(ctx context.Context, userID, analysisID uint) (*database.FoodAnalysis, error)
+	GetDailyCarbs(ctx context.Context, userID uint, loc *time.Location) (float64, error)
+	SearchAnalyses(ctx context.Context, userID uint, query string, limit int) ([]database.FoodAnalysis, error)
+	GetLastAnalysis(ctx context.Context, userID uint) (*database.FoodAnalysis, error)
+	CalculateManual(ctx context.Context, userID uint, carbs float64, save bool) (*database.FoodAnalysis, error)
+	CloneDay(ctx context.Context, userID uint, fromDate, toDate time.Time) ([]services.MealDraft, error)
+	CloneAnalysis(ctx context.Context, userID, sourceID uint) (*database.FoodAnalysis, error)
+	EstimateFromHistory(ctx context.Context, userID uint, dish string, weight float64) (*services.HistoryEstimate, error)
+	GetAnalysisWithItems(ctx context.Context, userID, analysisID uint) (*database.FoodAnalysis, []database.FoodItem, error)
+	CorrectAnalysis(ctx context.Context, userID, analysisID uint, correction services.AnalysisCorrection) (*database.FoodAnalysis, error)
+	SaveFromHistory(ctx context.Context, userID uint, estimate services.HistoryEstimate, weight float64) (*database.FoodAnalysis, error)
+	AIHealth() services.AIHealth
+	ArchivePhoto(ctx context.Context, analysis *database.FoodAnalysis, fileID, imageURL string) error
+	KeepPhotoFileID(ctx context.Context, analysis *database.FoodAnalysis, fileID string) error
+	ReanalyzeOnPrimary(ctx context.Context, userID, analysisID uint, imageURL string) (*services.PrimaryReanalysis, error)
+	DeleteArchivedPhotos(ctx context.Context, userID uint) error
+}
+
+// AnalysisQueueInterface defines the contract for the AI rate limit and its queue of photos
+type AnalysisQueueInterface interface {
+	Acquire(ctx context.Context) (bool, error)
+	Enqueue(ctx context.Context, item *database.QueuedAnalysis) (int, error)
+	SetMessageID(ctx context.Context, id uint, messageID int) error
+	Cancel(ctx context.Context, userID, id uint) (*database.QueuedAnalysis, error)
+	Next(ctx context.Context) (*database.QueuedAnalysis, error)
+	Done(ctx context.Context, id uint) error
+	Queued(ctx context.Context) ([]database.QueuedAnalysis, error)
+	EstimateWait(position int) time.Duration
 }
 
 // BloodSugarServiceInterface defines the contract for blood sugar operations
 type BloodSugarServiceInterface interface {
 	AddRecord(ctx context.Context, userID uint, value float64) error
 	GetUserRecords(ctx context.Context, userID uint) ([]database.BloodSugarRecord, error)
+	GetLatestSince(ctx context.Context, userID uint, since time.Time) (*database.BloodSugarRecord, error)
+	GetStatistics(ctx context.Context, userID uint, since time.Time) (*services.BloodSugarStats, error)
 }
 
 // InsulinServiceInterface defines the contract for insulin operations
 type InsulinServiceInterface interface {
 	AddRatio(ctx context.Context, userID uint, startTime, endTime string, ratio float64) error
+	CheckRatio(ratio float64) string
+	RatioBounds() config.RatioBounds
 	GetUserRatios(ctx context.Context, userID uint) ([]database.InsulinRatio, error)
 	DeleteRatio(ctx context.Context, userID uint, ratioID uint) error
 	UpdateRatio(ctx context.Context, userID uint, ratioID uint, startTime, endTime string, ratio float64) error
+	ReplaceRatios(ctx context.Context, userID uint, ratios []database.InsulinRatio) error
+	ApplyChanges(ctx context.Context, userID uint, updates []database.InsulinRatio, deletes []uint) error
+	ApplyRatioPreset(ctx context.Context, userID uint, presetID string) error
 	GetActiveInsulinTime(ctx context.Context, userID uint) (int, error)
 	SetActiveInsulinTime(ctx context.Context, userID uint, minutes int) error
 }
 
+// InjectionServiceInterface defines the contract for the injection log
+type InjectionServiceInterface interface {
+	LogInjection(ctx context.Context, userID uint, units dosing.DeciUnits, kind, site string) (*database.Injection, error)
+	SiteOverused(ctx context.Context, userID uint, site string) (bool, int, error)
+	GetSiteFrequency(ctx context.Context, userID uint, since time.Time) ([]services.SiteCount, error)
+	GetUserInjections(ctx context.Context, userID uint, since time.Time) ([]database.Injection, error)
+	InsulinOnBoard(ctx context.Context, user *database.User, now time.Time) (dosing.DeciUnits, error)
+	DueBasalReminders(ctx context.Context, now time.Time, userIDs []uint) ([]services.DueBasalReminder, error)
+	ReleaseBasalReminder(ctx context.Context, reminderID uint) error
+	TakeBasalReminder(ctx context.Context, user *database.User, reminderID uint) (*database.Injection, error)
+}
+
+// BasalServiceInterface defines the contract for the pump basal schedule
+type BasalServiceInterface interface {
+	AddRate(ctx context.Context, userID uint, startTime, endTime string, rate float64) error
+	GetUserRates(ctx context.Context, userID uint) ([]database.BasalRate, error)
+	ClearRates(ctx context.Context, userID uint) error
+}
+
+// SettingsSnapshotServiceInterface defines the contract for settings snapshots
+type SettingsSnapshotServiceInterface interface {
+	CreateSnapshot(ctx context.Context, userID uint, name string) (*database.SettingsSnapshot, int, error)
+	ListSnapshots(ctx context.Context, userID uint) ([]database.SettingsSnapshot, error)
+	GetSnapshot(ctx context.Context, userID, snapshotID uint) (*database.SettingsSnapshot, error)
+	RestoreSnapshot(ctx context.Context, userID, snapshotID uint) error
+	ScheduleRatioChange(ctx context.Context, userID uint, ratios []database.InsulinRatio, activateAt time.Time) error
+	CancelRatioChange(ctx context.Context, userID uint) error
+	ActivateRatioChanges(ctx context.Context, now time.Time) ([]services.ActivatedRatioChange, error)
+	ShareCode(ctx context.Context, userID uint) (string, error)
+	ApplySharedSettings(ctx context.Context, userID uint, settings *sharecode.Settings) (*database.SettingsSnapshot, error)
+}
+
+// EventServiceInterface defines the contract for quick-logged events
+type EventServiceInterface interface {
+	AddEvent(ctx context.Context, userID uint, kind, emoji string) (*database.Event, error)
+	GetUserEvents(ctx context.Context, userID uint, since time.Time) ([]database.Event, error)
+}
+
+// FeatureFlagsInterface defines the contract for feature flag checks
+type FeatureFlagsInterface interface {
+	Enabled(ctx context.Context, name string, userID uint) bool
+	SetGlobal(ctx context.Context, name string, enabled bool) error
+	SetOverride(ctx context.Context, name string, userID uint, enabled bool) error
+	ClearOverride(ctx context.Context, name string, userID uint) error
+}
+
+// StatsServiceInterface defines the contract for aggregated statistics
+type StatsServiceInterface interface {
+	GetDailySummaries(ctx context.Context, userID uint, days int) ([]database.DailySummary, error)
+}
+
+// UsageServiceInterface defines the contract for AI usage accounting
+type UsageServiceInterface interface {
+	GetTotals(ctx context.Context, from, to time.Time) ([]services.UsageTotal, error)
+	ClaimMonthlyReport(ctx context.Context, monthStart time.Time) (bool, error)
+}
+
+// JobServiceInterface defines the contract for background jobs
+type JobServiceInterface interface {
+	EnqueueExport(ctx context.Context, userID uint, chatID int64, kind string) (*database.Job, bool, error)
+	EnqueueReanalysis(ctx context.Context, userID uint, chatID int64, analysisID uint) (*database.Job, bool, error)
+	SetMessageID(ctx context.Context, jobID uint, messageID int) error
+	ClaimNext(ctx context.Context) (*database.Job, error)
+	RunChunk(ctx context.Context, job *database.Job) (bool, error)
+	Fail(ctx context.Context, job *database.Job, jobErr error) (bool, error)
+	Result(ctx context.Context, job *database.Job) (filename, contentType string, data []byte, err error)
+	MarkDelivered(ctx context.Context, job *database.Job) error
+}
+
+// APITokenServiceInterface defines the contract for personal HTTP API tokens
+type APITokenServiceInterface interface {
+	IssueToken(ctx context.Context, userID uint) (string, error)
+	RevokeToken(ctx context.Context, userID uint) error
+	Authenticate(ctx context.Context, token string) (*database.User, error)
+}
+
+// InsightsServiceInterface defines the contract for meal outcome insights
+type InsightsServiceInterface interface {
+	TopSpikes(ctx context.Context, userID uint, limit int) ([]services.FoodInsight, error)
+}
+
+// SupportServiceInterface defines the contract for admin support lookups
+type SupportServiceInterface interface {
+	ViewUser(ctx context.Context, adminTelegramID, telegramID int64) (*services.SupportSnapshot, error)
+}
+
+// DataCheckServiceInterface defines the contract for the admin data-consistency check
+type DataCheckServiceInterface interface {
+	Check(ctx context.Context) ([]services.DataCheckFinding, error)
+}
+
+// ConversationLogInterface defines the contract for the optional log of
+// users' conversations with the bot
+type ConversationLogInterface interface {
+	Enabled() bool
+	FullText() bool
+	BeginUpdate(chatID int64, updateID int, kind, key, text string) func()
+	RecordOutgoing(chatID int64, method, text string)
+	Transcript(ctx context.Context, adminTelegramID, telegramID int64, exchanges int) ([]database.ConversationLog, error)
+}
+
+// TransferServiceInterface defines the contract for moving an account to a new Telegram ID
+type TransferServiceInterface interface {
+	IssueCode(ctx context.Context, userID uint) (string, time.Time, error)
+	Claim(ctx context.Context, code string, claimer *database.User, merge bool) (*database.User, error)
+	MergeUsers(ctx context.Context, adminTelegramID int64, fromID, toID uint) ([]services.MergeCount, error)
+}
+
+// UserProfileServiceInterface defines the contract for the settings checklist
+type UserProfileServiceInterface interface {
+	Completeness(ctx context.Context, userID uint) (*services.ProfileCompleteness, error)
+}
+
+// TelemetryInterface defines the contract for the anonymous usage report
+type TelemetryInterface interface {
+	Enabled() bool
+	Endpoint() string
+	Collect(ctx context.Context) (*telemetry.Payload, error)
+}
+
+// ChartServiceInterface defines the contract for rendering images
+type ChartServiceInterface interface {
+	RenderSchedule(ratios []database.InsulinRatio) ([]byte, error)
+	RenderInsulinChart(injections []database.Injection, loc *time.Location, days int) ([]byte, error)
+}
+
 // AIServiceInterface defines the contract for AI operations
 type AIServiceInterface interface {
-	AnalyzeFoodImage(ctx context.Context, imageURL string, weight float64) (*services.FoodAnalysisResult, error)
+	AnalyzeFoodImage(ctx context.Context, imageURL string, weight float64, opts services.PromptOptions) (*services.FoodAnalysisResult, error)
 }
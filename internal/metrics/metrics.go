@@ -0,0 +1,220 @@
+// Package metrics provides minimal counters and gauges exposed in the
+// Prometheus text format, without pulling in the Prometheus client library.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type metric interface {
+	write(w io.Writer)
+	snapshot() map[string]int64
+}
+
+var (
+	registryMu sync.Mutex
+	registry   = map[string]metric{}
+)
+
+func register(name string, m metric) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, exists := registry[name]; exists {
+		panic(fmt.Sprintf("metrics: %s registered twice", name))
+	}
+	registry[name] = m
+}
+
+// Counter is a monotonically increasing value
+type Counter struct {
+	name  string
+	help  string
+	value atomic.Int64
+}
+
+// NewCounter creates and registers a counter
+func NewCounter(name, help string) *Counter {
+	c := &Counter{name: name, help: help}
+	register(name, c)
+	return c
+}
+
+// Inc increments the counter by one
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+// Add increments the counter by n
+func (c *Counter) Add(n int64) {
+	c.value.Add(n)
+}
+
+func (c *Counter) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", c.name, c.help, c.name, c.name, c.value.Load())
+}
+
+func (c *Counter) snapshot() map[string]int64 {
+	return map[string]int64{"": c.value.Load()}
+}
+
+// Gauge is a value that can go up and down
+type Gauge struct {
+	name  string
+	help  string
+	value atomic.Int64
+}
+
+// NewGauge creates and registers a gauge
+func NewGauge(name, help string) *Gauge {
+	g := &Gauge{name: name, help: help}
+	register(name, g)
+	return g
+}
+
+// Inc increments the gauge by one
+func (g *Gauge) Inc() {
+	g.value.Add(1)
+}
+
+// Dec decrements the gauge by one
+func (g *Gauge) Dec() {
+	g.value.Add(-1)
+}
+
+// Set sets the gauge to v
+func (g *Gauge) Set(v int64) {
+	g.value.Store(v)
+}
+
+func (g *Gauge) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %d\n", g.name, g.help, g.name, g.name, g.value.Load())
+}
+
+func (g *Gauge) snapshot() map[string]int64 {
+	return map[string]int64{"": g.value.Load()}
+}
+
+// Info is a constant metric with value 1 that carries its data in labels,
+// such as the version of the running build
+type Info struct {
+	name   string
+	help   string
+	labels map[string]string
+}
+
+// NewInfo creates and registers an info metric
+func NewInfo(name, help string, labels map[string]string) *Info {
+	i := &Info{name: name, help: help, labels: labels}
+	register(name, i)
+	return i
+}
+
+func (i *Info) write(w io.Writer) {
+	keys := make([]string, 0, len(i.labels))
+	for k := range i.labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for n, k := range keys {
+		pairs[n] = fmt.Sprintf("%s=%q", k, i.labels[k])
+	}
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s{%s} 1\n", i.name, i.help, i.name, i.name, strings.Join(pairs, ","))
+}
+
+func (i *Info) snapshot() map[string]int64 {
+	return map[string]int64{"": 1}
+}
+
+// CounterVec is a set of counters partitioned by a single label
+type CounterVec struct {
+	name  string
+	help  string
+	label string
+
+	mu     sync.Mutex
+	values map[string]*atomic.Int64
+}
+
+// NewCounterVec creates and registers a counter partitioned by label
+func NewCounterVec(name, help, label string) *CounterVec {
+	c := &CounterVec{name: name, help: help, label: label, values: map[string]*atomic.Int64{}}
+	register(name, c)
+	return c
+}
+
+// Inc increments the counter for the given label value
+func (c *CounterVec) Inc(value string) {
+	c.mu.Lock()
+	v, ok := c.values[value]
+	if !ok {
+		v = &atomic.Int64{}
+		c.values[value] = v
+	}
+	c.mu.Unlock()
+	v.Add(1)
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	c.mu.Lock()
+	keys := make([]string, 0, len(c.values))
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s{%s=%q} %d\n", c.name, c.label, k, c.values[k].Load())
+	}
+	c.mu.Unlock()
+}
+
+func (c *CounterVec) snapshot() map[string]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	values := make(map[string]int64, len(c.values))
+	for k, v := range c.values {
+		values[k] = v.Load()
+	}
+	return values
+}
+
+// Snapshot returns the current value of every registered metric by name and
+// label value; metrics without a label have a single "" entry
+func Snapshot() map[string]map[string]int64 {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	snapshot := make(map[string]map[string]int64, len(registry))
+	for name, m := range registry {
+		snapshot[name] = m.snapshot()
+	}
+	return snapshot
+}
+
+// Handler serves all registered metrics in the Prometheus text format
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		registryMu.Lock()
+		names := make([]string, 0, len(registry))
+		for name := range registry {
+			names = append(names, name)
+		}
+		metrics := make([]metric, 0, len(names))
+		sort.Strings(names)
+		for _, name := range names {
+			metrics = append(metrics, registry[name])
+		}
+		registryMu.Unlock()
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		for _, m := range metrics {
+			m.write(w)
+		}
+	})
+}
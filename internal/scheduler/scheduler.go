@@ -0,0 +1,30 @@
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+)
+
+// Every runs fn immediately and then on every interval until ctx is cancelled.
+// Errors are logged and do not stop the job.
+func Every(ctx context.Context, name string, interval time.Duration, fn func(ctx context.Context) error) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			if err := fn(ctx); err != nil {
+				logger.Error("Scheduled job failed", "job", name, "error", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				logger.Info("Scheduled job stopped", "job", name)
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"sync"
+	"time"
+
+	"github.com/vladimiradmaev/diabetes-helper/internal/metrics"
+)
+
+// aiUnhealthyAfter is how many failed AI requests in a row mark the provider as unhealthy
+const aiUnhealthyAfter = 3
+
+var (
+	aiRequests       = metrics.NewCounter("ai_requests_total", "AI provider requests")
+	aiRequestsFailed = metrics.NewCounter("ai_requests_failed_total", "AI provider requests that returned an error")
+	aiHealthy        = metrics.NewGauge("ai_provider_healthy", "1 while the AI provider is considered healthy")
+)
+
+// AIHealth is the AI provider state as seen by this bot instance
+type AIHealth struct {
+	Healthy             bool
+	LastSuccess         time.Time // Zero if no request succeeded since start
+	LastFailure         time.Time // Zero if no request failed since start
+	ConsecutiveFailures int
+}
+
+// aiHealthTracker follows the outcome of AI requests. A success resets the
+// failure streak, so the provider is back to healthy after one good answer.
+type aiHealthTracker struct {
+	mu    sync.Mutex
+	state AIHealth
+}
+
+func newAIHealthTracker() *aiHealthTracker {
+	aiHealthy.Set(1)
+	return &aiHealthTracker{state: AIHealth{Healthy: true}}
+}
+
+func (t *aiHealthTracker) record(err error) {
+	aiRequests.Inc()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err != nil {
+		aiRequestsFailed.Inc()
+		t.state.LastFailure = time.Now()
+		t.state.ConsecutiveFailures++
+	} else {
+		t.state.LastSuccess = time.Now()
+		t.state.ConsecutiveFailures = 0
+	}
+
+	t.state.Healthy = t.state.ConsecutiveFailures < aiUnhealthyAfter
+	if t.state.Healthy {
+		aiHealthy.Set(1)
+	} else {
+		aiHealthy.Set(0)
+	}
+}
+
+// recordSelfTest applies the outcome of a startup check of the provider. A
+// failure marks the provider unhealthy right away; the next successful
+// request makes it healthy again.
+func (t *aiHealthTracker) recordSelfTest(err error) {
+	if err == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.state.LastFailure = time.Now()
+	if t.state.ConsecutiveFailures < aiUnhealthyAfter {
+		t.state.ConsecutiveFailures = aiUnhealthyAfter
+	}
+	t.state.Healthy = false
+	aiHealthy.Set(0)
+}
+
+func (t *aiHealthTracker) snapshot() AIHealth {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.state
+}
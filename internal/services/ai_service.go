@@ -3,37 +3,122 @@ package services
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"math"
+	"math/rand"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/google/generative-ai-go/genai"
+	"github.com/vladimiradmaev/diabetes-helper/internal/confidence"
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
 	apperrors "github.com/vladimiradmaev/diabetes-helper/internal/errors"
+	"github.com/vladimiradmaev/diabetes-helper/internal/featureflags"
 	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+	"github.com/vladimiradmaev/diabetes-helper/internal/metrics"
 	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
 )
 
+const (
+	providerGemini = "gemini"
+	geminiModel    = "gemini-2.0-flash"
+)
+
+// PrimaryProvider is the AI provider analyses normally come from. Analyses
+// with another AI provider in UsedProvider were served by a fallback model and
+// say so in the result.
+const PrimaryProvider = providerGemini
+
+// providerNames are the provider names shown to users
+var providerNames = map[string]string{
+	providerGemini: "Gemini",
+	providerOpenAI: "OpenAI",
+}
+
+// ProviderName returns the name of an AI provider as shown to users
+func ProviderName(provider string) string {
+	if name, ok := providerNames[provider]; ok {
+		return name
+	}
+	return provider
+}
+
+// IsFallbackProvider reports whether an analysis by the provider came from a
+// fallback AI model rather than the primary one. Analyses that didn't come from
+// an AI model at all, such as ManualProvider and HistoryProvider, are not.
+func IsFallbackProvider(provider string) bool {
+	_, ai := providerNames[provider]
+	return ai && provider != PrimaryProvider
+}
+
+// weightRetryChangeThreshold is the relative carb change from a weight
+// re-estimation that is counted as significant
+const weightRetryChangeThreshold = 0.2
+
+var aiFallbacks = metrics.NewCounter("ai_fallbacks_total", "Meal analyses sent to OpenAI because Gemini was unavailable")
+
+var weightRetries = metrics.NewCounterVec("ai_weight_retries_total",
+	"Weight re-estimations of low-confidence analyses by outcome: carbs_changed (by more than 20%), carbs_kept or failed", "outcome")
+
+// FlagChecker reports whether a feature flag is on for a user
+type FlagChecker interface {
+	Enabled(ctx context.Context, name string, userID uint) bool
+}
+
 type AIService struct {
-	geminiClient *genai.Client
-	logger       *slog.Logger
+	geminiClient      *genai.Client
+	openai            *openaiClient // Fallback when Gemini is over quota or down; nil without OPENAI_API_KEY
+	flags             FlagChecker   // Gates the OpenAI fallback per user; nil allows it
+	usage             *UsageService
+	health            *aiHealthTracker
+	logger            *slog.Logger
+	portionReferences string // Typical portions for weight estimation, empty for the defaults
 }
 
 type FoodAnalysisResult struct {
-	FoodItems    []string `json:"food_items"`
-	Carbs        float64  `json:"carbs"`
-	Confidence   string   `json:"confidence"`
-	AnalysisText string   `json:"analysis_text"`
-	Weight       float64  `json:"weight"`
+	FoodItems    []string  `json:"food_items"`
+	ItemCarbs    []float64 `json:"item_carbs"` // Carbs of each food item, in the order of FoodItems
+	Carbs        float64   `json:"carbs"`
+	Confidence   string    `json:"confidence"`
+	AnalysisText string    `json:"analysis_text"`
+	Weight       float64   `json:"weight"`
+	// GlycemicIndex is the meal's overall GI category: "high", "medium" or "low"
+	GlycemicIndex string `json:"glycemic_index"`
+
+	// MeterReading is set instead of the food fields when the photo shows a
+	// glucose meter display rather than a meal
+	MeterReading *MeterReading `json:"meter_reading,omitempty"`
+
+	// Provider and Model identify which AI produced the result; they are
+	// filled in by AIService and never parsed from the model response.
+	Provider string `json:"-"`
+	Model    string `json:"-"`
 }
 
-func NewAIService(geminiAPIKey string) *AIService {
+// MeterReading is the value shown on a glucose meter display
+type MeterReading struct {
+	Value float64 `json:"value"`
+	Unit  string  `json:"unit"` // "mmol" or "mgdl", empty when the display doesn't show it
+}
+
+// NewAIService creates the AI service. OpenAI serves as the fallback when
+// openaiAPIKey is not empty and the featureflags.OpenAIFallback flag is on for
+// the user. Token usage of every request is recorded in usage when it is not
+// nil. portionReferences replaces the typical portions of the weight
+// estimation prompt when not empty.
+func NewAIService(geminiAPIKey, openaiAPIKey string, flags FlagChecker, usage *UsageService, portionReferences string) *AIService {
 	service := &AIService{
-		logger: logger.GetLogger(),
+		flags:             flags,
+		usage:             usage,
+		health:            newAIHealthTracker(),
+		logger:            logger.GetLogger(),
+		portionReferences: portionReferences,
 	}
 
 	// Initialize Gemini client
@@ -46,109 +131,215 @@ func NewAIService(geminiAPIKey string) *AIService {
 		} else {
 			service.geminiClient = client
 			service.logger.Info("Gemini client initialized successfully")
-			service.logger.Info("Testing Gemini model", "model", "gemini-2.0-flash")
+			service.logger.Info("Testing Gemini model", "model", geminiModel)
 		}
 	} else {
 		service.logger.Error("Gemini API key not provided")
 	}
 
+	if openaiAPIKey != "" {
+		service.openai = newOpenAIClient(openaiAPIKey)
+		service.logger.Info("OpenAI fallback enabled", "model", openaiModel)
+	}
+
 	return service
 }
 
-func retryWithBackoff(ctx context.Context, maxRetries int, fn func() error) error {
-	var lastErr error
-	for i := 0; i < maxRetries; i++ {
-		if err := fn(); err != nil {
-			lastErr = err
-
-			// Check if it's a retryable error
-			if googleErr, ok := err.(*googleapi.Error); ok {
-				if googleErr.Code == 429 || googleErr.Code >= 500 {
-					// Rate limit or server error - retry with backoff
-					backoff := time.Duration(i+1) * time.Second
-					logger.Warningf("Retryable error occurred (attempt %d/%d): %v. Retrying in %v", i+1, maxRetries, err, backoff)
-
-					select {
-					case <-time.After(backoff):
-						continue
-					case <-ctx.Done():
-						return ctx.Err()
-					}
-				} else {
-					// Non-retryable error
-					logger.Errorf("Non-retryable error occurred: %v", err)
-					return err
-				}
-			} else {
-				// Other errors - retry with backoff
-				backoff := time.Duration(i+1) * time.Second
-				logger.Warningf("Error occurred (attempt %d/%d): %v. Retrying in %v", i+1, maxRetries, err, backoff)
-
-				select {
-				case <-time.After(backoff):
-					continue
-				case <-ctx.Done():
-					return ctx.Err()
-				}
-			}
-		} else {
+// SelfTest checks that the Gemini key works by fetching the model's info,
+// which costs no tokens. A failure marks the provider unhealthy.
+func (s *AIService) SelfTest(ctx context.Context) error {
+	err := s.selfTest(ctx)
+	s.health.recordSelfTest(err)
+	return err
+}
+
+func (s *AIService) selfTest(ctx context.Context) error {
+	if s.geminiClient == nil {
+		return fmt.Errorf("gemini client is not initialized, check GEMINI_API_KEY")
+	}
+	if _, err := s.geminiClient.GenerativeModel(geminiModel).Info(ctx); err != nil {
+		return fmt.Errorf("failed to get gemini model info: %w", err)
+	}
+	return nil
+}
+
+// Retry backoff: the delay before attempt n+1 is a random duration up to
+// retryBaseDelay*2^n, capped at retryMaxDelay. The randomness keeps users
+// hit by the same outage from retrying in lockstep.
+const (
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 8 * time.Second
+	// retryDefaultBudget limits retries when the context has no deadline
+	retryDefaultBudget = 30 * time.Second
+	// retryPrimaryShare is the part of the budget Gemini may retry in when
+	// OpenAI can take over; the rest is left for the fallback's own retries
+	retryPrimaryShare = 0.6
+)
+
+// retryDeadlineKey holds a retry deadline earlier than the context's own
+type retryDeadlineKey struct{}
+
+// retryDeadline returns when retries under ctx must stop: the earlier of the
+// context deadline and one set by withPrimaryShare, or retryDefaultBudget
+// from now without either
+func retryDeadline(ctx context.Context) time.Time {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(retryDefaultBudget)
+	}
+	if d, ok := ctx.Value(retryDeadlineKey{}).(time.Time); ok && d.Before(deadline) {
+		deadline = d
+	}
+	return deadline
+}
+
+// withPrimaryShare limits the retries under the returned context to
+// retryPrimaryShare of the remaining budget, so a failing Gemini doesn't use
+// it all before the fallback starts. Unlike a context deadline, it only stops
+// new retries and doesn't cancel a request in flight.
+func withPrimaryShare(ctx context.Context) context.Context {
+	share := time.Duration(float64(time.Until(retryDeadline(ctx))) * retryPrimaryShare)
+	return context.WithValue(ctx, retryDeadlineKey{}, time.Now().Add(share))
+}
+
+// retryWithBackoff calls fn until it succeeds, returns a non-retryable error
+// or the time budget runs out. The budget is given by retryDeadline. No retry
+// is started that would wait past the budget; the last error is returned
+// instead.
+func retryWithBackoff(ctx context.Context, fn func() error) error {
+	deadline := retryDeadline(ctx)
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
 			return nil
 		}
+
+		// Client errors other than rate limiting won't go away on retry
+		if code := apiErrorCode(err); code != 0 && code != 429 && code < 500 {
+			logger.Errorf("Non-retryable error occurred: %v", err)
+			return err
+		}
+
+		backoff := retryBackoff(attempt, rand.Float64())
+		if time.Now().Add(backoff).After(deadline) {
+			logger.Warningf("Error occurred (attempt %d): %v. Retry budget exhausted", attempt, err)
+			return err
+		}
+		logger.Warningf("Error occurred (attempt %d): %v. Retrying in %v", attempt, err, backoff)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// apiErrorCode returns the HTTP status of a Gemini or OpenAI API error, or 0
+// for other errors
+func apiErrorCode(err error) int {
+	var googleErr *googleapi.Error
+	if errors.As(err, &googleErr) {
+		return googleErr.Code
+	}
+	var openaiErr *OpenAIError
+	if errors.As(err, &openaiErr) {
+		return openaiErr.StatusCode
 	}
-	return lastErr
+	return 0
+}
+
+// shouldFallback reports whether a failed Gemini request is worth repeating
+// with the fallback provider: Gemini is over quota or still failing on its
+// side after the retries
+func shouldFallback(err error) bool {
+	code := apiErrorCode(err)
+	return code == 429 || code >= 500
+}
+
+// retryBackoff returns the delay after the given failed attempt (1-based)
+// for a jitter fraction in [0, 1)
+func retryBackoff(attempt int, jitter float64) time.Duration {
+	ceiling := retryMaxDelay
+	if attempt <= 5 {
+		ceiling = min(retryBaseDelay<<(attempt-1), retryMaxDelay)
+	}
+	return time.Duration(jitter * float64(ceiling))
+}
+
+// gramsPerOunce converts the ounces of imperial prompts back to grams
+const gramsPerOunce = 28.3495
+
+// PromptOptions adapt the analysis prompts to the user
+type PromptOptions struct {
+	// UserID is whose feature flags apply to the analysis
+	UserID uint
+	// Imperial makes the prompts reason with inch and ounce references;
+	// weights are still returned in grams
+	Imperial bool
+	// Ingredients is the recipe the user wrote in the caption, empty if none
+	Ingredients string
+}
+
+// PromptOptionsFor returns the prompt options for the user's preferences and
+// the ingredients from the caption
+func PromptOptionsFor(user *database.User, ingredients string) PromptOptions {
+	return PromptOptions{UserID: user.ID, Imperial: ImperialUnits(user), Ingredients: ingredients}
 }
 
-func (s *AIService) AnalyzeFoodImage(ctx context.Context, imageURL string, weight float64) (*FoodAnalysisResult, error) {
+func (s *AIService) AnalyzeFoodImage(ctx context.Context, imageURL string, weight float64, opts PromptOptions) (*FoodAnalysisResult, error) {
 	s.logger.InfoContext(ctx, "Starting food image analysis",
 		"image_url", imageURL,
-		"weight", weight)
+		"weight", weight,
+		"imperial", opts.Imperial,
+		"ingredients", opts.Ingredients != "")
 
-	if s.geminiClient == nil {
+	fallback := s.openai != nil && s.fallbackEnabled(ctx, opts.UserID)
+	if s.geminiClient == nil && !fallback {
 		return nil, apperrors.NewExternalAPIError(
 			fmt.Errorf("Gemini client not available"),
 			"Gemini").WithContext("operation", "analyze_food_image")
 	}
 
-	var estimatedWeight float64
+	var result *FoodAnalysisResult
 	var err error
-
-	if weight <= 0 {
-		s.logger.InfoContext(ctx, "No weight provided, estimating weight from image")
-		// If no weight provided, estimate it first
-		estimatedWeight, err = s.estimateWeight(ctx, imageURL)
-		if err != nil {
-			// Проверяем, не обнаружена ли еда
-			if strings.Contains(err.Error(), "NO_FOOD_DETECTED") {
-				s.logger.InfoContext(ctx, "No food detected in image during weight estimation")
-				// Возвращаем стандартный ответ для отсутствия еды
-				return &FoodAnalysisResult{
-					FoodItems:    []string{},
-					Carbs:        0,
-					Confidence:   "low",
-					AnalysisText: "На изображении не обнаружена еда. Пожалуйста, отправьте фото блюда для анализа.",
-					Weight:       0,
-				}, nil
-			}
-			s.logger.WarnContext(ctx, "Failed to estimate weight", "error", err)
-			// Не возвращаем ошибку, а продолжаем анализ без веса
-			s.logger.InfoContext(ctx, "Continuing analysis without weight estimation")
-		} else {
-			weight = estimatedWeight
-			s.logger.InfoContext(ctx, "Estimated weight", "weight", weight)
+	if s.geminiClient != nil {
+		geminiCtx := ctx
+		if fallback {
+			geminiCtx = withPrimaryShare(ctx)
+		}
+		result, err = s.analyzeWithGemini(geminiCtx, imageURL, weight, opts)
+		if err != nil && (!fallback || !shouldFallback(err)) {
+			return nil, apperrors.NewExternalAPIError(err, "Gemini").
+				WithContext("operation", "analyze_with_gemini").
+				WithContext("image_url", imageURL).
+				WithContext("weight", weight)
 		}
 	}
-
-	result, err := s.analyzeWithGemini(ctx, imageURL, weight)
-	if err != nil {
-		return nil, apperrors.NewExternalAPIError(err, "Gemini").
-			WithContext("operation", "analyze_with_gemini").
-			WithContext("image_url", imageURL).
-			WithContext("weight", weight)
+	if result == nil {
+		if err != nil {
+			s.logger.WarnContext(ctx, "Gemini is unavailable, falling back to OpenAI", "model", openaiModel, "gemini_error", err)
+			aiFallbacks.Inc()
+		}
+		result, err = s.analyzeWithOpenAI(ctx, imageURL, weight, opts)
+		if err != nil {
+			return nil, apperrors.NewExternalAPIError(err, "OpenAI").
+				WithContext("operation", "analyze_with_openai").
+				WithContext("image_url", imageURL).
+				WithContext("weight", weight)
+		}
 	}
 
 	// Ensure the weight is set in the result
 	if weight > 0 {
 		result.Weight = weight
+	} else if result.MeterReading == nil && confidence.Parse(result.Confidence) == confidence.Low && result.Carbs > 0 && result.Weight > 0 {
+		// The weight the model guessed is the usual culprit of a poor answer,
+		// so only then pay for a second call focused on the weight alone
+		s.refineWeight(ctx, imageURL, result, opts)
 	}
 
 	s.logger.InfoContext(ctx, "Food analysis completed successfully",
@@ -158,29 +349,160 @@ func (s *AIService) AnalyzeFoodImage(ctx context.Context, imageURL string, weigh
 	return result, nil
 }
 
-func (s *AIService) estimateWeight(ctx context.Context, imageURL string) (float64, error) {
+// fallbackEnabled reports whether the user's photos may go to OpenAI
+func (s *AIService) fallbackEnabled(ctx context.Context, userID uint) bool {
+	return s.flags == nil || s.flags.Enabled(ctx, featureflags.OpenAIFallback, userID)
+}
+
+// AnalyzeFoodImagePrimary analyzes the image with the primary model only,
+// without falling back to another provider. It is used to repeat analyses a
+// fallback model served once the primary model is available again.
+func (s *AIService) AnalyzeFoodImagePrimary(ctx context.Context, imageURL string, weight float64, opts PromptOptions) (*FoodAnalysisResult, error) {
 	if s.geminiClient == nil {
-		return 0, fmt.Errorf("Gemini client not available for weight estimation")
+		return nil, apperrors.NewExternalAPIError(
+			fmt.Errorf("Gemini client not available"),
+			"Gemini").WithContext("operation", "analyze_food_image_primary")
+	}
+
+	result, err := s.analyzeWithGemini(ctx, imageURL, weight, opts)
+	if err != nil {
+		return nil, apperrors.NewExternalAPIError(err, "Gemini").
+			WithContext("operation", "analyze_with_gemini").
+			WithContext("image_url", imageURL).
+			WithContext("weight", weight)
 	}
-	return s.estimateWeightWithGemini(ctx, imageURL)
+	if weight > 0 {
+		result.Weight = weight
+	}
+	return result, nil
 }
 
-func (s *AIService) estimateWeightWithGemini(ctx context.Context, imageURL string) (float64, error) {
-	model := s.geminiClient.GenerativeModel("gemini-2.0-flash")
+// Health returns the provider state based on recent requests
+func (s *AIService) Health() AIHealth {
+	health := s.health.snapshot()
+	if s.geminiClient == nil {
+		health.Healthy = false
+	}
+	return health
+}
 
-	// Download image
-	resp, err := http.Get(imageURL)
+// generateContent calls Gemini and records the token usage of the request.
+// This SDK version doesn't return usage metadata, so output tokens are taken
+// from the candidates and prompt tokens are counted with a separate, free
+// CountTokens call in the background.
+func (s *AIService) generateContent(ctx context.Context, model *genai.GenerativeModel, parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+	resp, err := model.GenerateContent(ctx, parts...)
+	s.health.record(err)
 	if err != nil {
-		return 0, fmt.Errorf("failed to download image: %w", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	imageData, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return 0, fmt.Errorf("failed to read image data: %w", err)
+	if s.usage != nil {
+		usage := AIUsage{Provider: providerGemini, Model: geminiModel}
+		for _, candidate := range resp.Candidates {
+			usage.OutputTokens += int(candidate.TokenCount)
+		}
+		go s.recordUsage(context.WithoutCancel(ctx), model, usage, parts)
+	}
+	return resp, nil
+}
+
+func (s *AIService) recordUsage(ctx context.Context, model *genai.GenerativeModel, usage AIUsage, parts []genai.Part) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	if count, err := model.CountTokens(ctx, parts...); err != nil {
+		s.logger.WarnContext(ctx, "Failed to count prompt tokens", "error", err)
+	} else {
+		usage.InputTokens = int(count.TotalTokens)
+	}
+
+	if err := s.usage.Record(ctx, usage); err != nil {
+		s.logger.ErrorContext(ctx, "Failed to record AI usage", "error", err)
+	}
+}
+
+// refineWeight re-estimates the weight of a low-confidence result with the
+// reference-object prompt and scales its carbs to the new weight
+func (s *AIService) refineWeight(ctx context.Context, imageURL string, result *FoodAnalysisResult, opts PromptOptions) {
+	estimated, err := s.estimateWeight(ctx, imageURL, result.Provider, opts)
+	if err != nil || estimated <= 0 {
+		weightRetries.Inc("failed")
+		s.logger.WarnContext(ctx, "Failed to re-estimate weight, keeping the analysis as is", "error", err)
+		return
+	}
+
+	carbs := result.Carbs * estimated / result.Weight
+	change := math.Abs(carbs-result.Carbs) / result.Carbs
+	if change > weightRetryChangeThreshold {
+		weightRetries.Inc("carbs_changed")
+	} else {
+		weightRetries.Inc("carbs_kept")
+	}
+	s.logger.InfoContext(ctx, "Re-estimated weight of a low-confidence analysis",
+		"weight", result.Weight,
+		"estimated_weight", estimated,
+		"carbs", result.Carbs,
+		"scaled_carbs", carbs)
+
+	result.AnalysisText += fmt.Sprintf("\n\nВес уточнен повторной оценкой: %.0f г вместо %.0f г, углеводы пересчитаны пропорционально.",
+		estimated, result.Weight)
+	for i := range result.ItemCarbs {
+		result.ItemCarbs[i] *= estimated / result.Weight
+	}
+	result.Weight = estimated
+	result.Carbs = carbs
+}
+
+// defaultPortionReferences are the typical portions the weight estimation
+// prompt refers to unless the operator configures their own. They follow
+// Russian and European portion norms.
+const defaultPortionReferences = `- Рис/гречка/макароны: 150-250г (размер кулака)
+- Мясо/рыба: 100-200г (размер ладони)
+- Овощи свежие: 100-200г
+- Хлеб (ломтик): 25-30г
+- Картофель (средний): 100-150г
+- Яйцо: 50-60г
+- Сыр (кусок): 30-50г`
+
+// imperialPortionReferences are the typical portions of the imperial weight
+// estimation prompt. They follow US serving sizes, so the operator's gram
+// portions don't replace them.
+const imperialPortionReferences = `- Рис/макароны: 1 чашка (cup), 5-7 унций
+- Мясо/рыба: 4-6 унций (размер ладони)
+- Стейк: 8-12 унций
+- Овощи свежие: 3-6 унций
+- Хлеб (ломтик): 1 унция
+- Картофель (средний): 5-6 унций
+- Яйцо (large): 2 унции
+- Сыр (ломтик): 1 унция`
+
+// weightEstimationPrompt returns the weight estimation prompt with the given
+// typical portions, one "- food: grams" line each. The imperial prompt uses
+// inch references and US portions and asks for ounces.
+func weightEstimationPrompt(portionReferences string, imperial bool) string {
+	if imperial {
+		return `Оцени вес еды в унциях, используя визуальные подсказки:
+
+РЕФЕРЕНСНЫЕ ОБЪЕКТЫ для масштаба:
+- Тарелка стандартная: диаметр 10-11 дюймов
+- Столовая ложка: длина 8 дюймов
+- Вилка: длина 7-8 дюймов
+- Стакан: высота 4-5 дюймов, диаметр 3 дюйма
+- Кружка кофе: диаметр 3-3.5 дюйма
+- Монета (если видна): quarter 1 дюйм, penny 0.75 дюйма
+
+ТИПИЧНЫЕ ПОРЦИИ:
+` + imperialPortionReferences + `
+` + weightEstimationSteps + `
+
+Верни ТОЛЬКО число в унциях (например: 6.5) или NO_FOOD`
 	}
 
-	prompt := `Оцени вес еды в граммах, используя визуальные подсказки:
+	if strings.TrimSpace(portionReferences) == "" {
+		portionReferences = defaultPortionReferences
+	}
+	return `Оцени вес еды в граммах, используя визуальные подсказки:
 
 РЕФЕРЕНСНЫЕ ОБЪЕКТЫ для масштаба:
 - Тарелка стандартная: диаметр 24-26см
@@ -191,40 +513,83 @@ func (s *AIService) estimateWeightWithGemini(ctx context.Context, imageURL strin
 - Монета (если видна): диаметр 2-2.5см
 
 ТИПИЧНЫЕ ПОРЦИИ:
-- Рис/гречка/макароны: 150-250г (размер кулака)
-- Мясо/рыба: 100-200г (размер ладони)
-- Овощи свежие: 100-200г
-- Хлеб (ломтик): 25-30г
-- Картофель (средний): 100-150г
-- Яйцо: 50-60г
-- Сыр (кусок): 30-50г
+` + strings.TrimSpace(portionReferences) + `
+` + weightEstimationSteps + `
 
+Верни ТОЛЬКО число в граммах (например: 180) или NO_FOOD`
+}
+
+// weightEstimationSteps is the unit-independent part of the weight estimation
+// prompts
+const weightEstimationSteps = `
 АНАЛИЗИРУЙ:
 1. Размер порции относительно тарелки/посуды
 2. Толщину/высоту блюда
 3. Плотность продуктов (мясо тяжелее овощей)
 4. Количество компонентов
 
-ВАЖНО: Если на изображении НЕТ ЕДЫ (только тарелки, приборы, или другие объекты), верни ТОЧНО: NO_FOOD
+ВАЖНО: Если на изображении НЕТ ЕДЫ (только тарелки, приборы, или другие объекты), верни ТОЧНО: NO_FOOD`
 
-Верни ТОЛЬКО число в граммах (например: 180) или NO_FOOD`
+// estimateWeight estimates the weight of the food in the photo in grams. It
+// asks the provider that made the analysis, so an analysis that already fell
+// back to OpenAI doesn't wait for an over-quota Gemini again.
+func (s *AIService) estimateWeight(ctx context.Context, imageURL, provider string, opts PromptOptions) (float64, error) {
+	fallback := s.openai != nil && s.fallbackEnabled(ctx, opts.UserID)
+	if s.geminiClient == nil && !fallback {
+		return 0, fmt.Errorf("Gemini client not available for weight estimation")
+	}
 
-	var weight float64
-	err = retryWithBackoff(ctx, 3, func() error {
-		// Detect image format
-		imageFormat := "image/jpeg"
-		if len(imageData) > 4 {
-			if imageData[0] == 0x89 && imageData[1] == 0x50 && imageData[2] == 0x4E && imageData[3] == 0x47 {
-				imageFormat = "image/png"
-			} else if imageData[0] == 0x47 && imageData[1] == 0x49 && imageData[2] == 0x46 {
-				imageFormat = "image/gif"
-			} else if imageData[0] == 0xFF && imageData[1] == 0xD8 {
-				imageFormat = "image/jpeg"
-			}
+	prompt := weightEstimationPrompt(s.portionReferences, opts.Imperial)
+	weight, err := s.estimateWeightWith(ctx, imageURL, provider, prompt, fallback)
+	if err != nil {
+		return 0, err
+	}
+	if opts.Imperial {
+		weight *= gramsPerOunce
+	}
+	return weight, nil
+}
+
+// estimateWeightWith sends the weight estimation prompt to the provider,
+// falling back to OpenAI when fallback is set, and returns the number in the
+// answer
+func (s *AIService) estimateWeightWith(ctx context.Context, imageURL, provider, prompt string, fallback bool) (float64, error) {
+	if s.geminiClient != nil && provider != providerOpenAI {
+		geminiCtx := ctx
+		if fallback {
+			geminiCtx = withPrimaryShare(ctx)
 		}
+		weight, err := s.estimateWeightWithGemini(geminiCtx, imageURL, prompt)
+		if err == nil || !fallback || !shouldFallback(err) {
+			return weight, err
+		}
+		s.logger.WarnContext(ctx, "Gemini is unavailable, estimating weight with OpenAI", "model", openaiModel, "gemini_error", err)
+	}
+	if !fallback {
+		return 0, fmt.Errorf("OpenAI fallback not available for weight estimation")
+	}
+	return s.estimateWeightWithOpenAI(ctx, imageURL, prompt)
+}
 
-		img := genai.ImageData(imageFormat, imageData)
-		geminiResp, err := model.GenerateContent(ctx, img, genai.Text(prompt))
+func (s *AIService) estimateWeightWithGemini(ctx context.Context, imageURL, prompt string) (float64, error) {
+	model := s.geminiClient.GenerativeModel(geminiModel)
+
+	// Download image
+	resp, err := http.Get(imageURL)
+	if err != nil {
+		return 0, fmt.Errorf("failed to download image: %w", err)
+	}
+	defer resp.Body.Close()
+
+	imageData, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read image data: %w", err)
+	}
+
+	var weight float64
+	err = retryWithBackoff(ctx, func() error {
+		img := genai.ImageData(detectImageFormat(imageData), imageData)
+		geminiResp, err := s.generateContent(ctx, model, img, genai.Text(prompt))
 		if err != nil {
 			return err
 		}
@@ -241,28 +606,37 @@ func (s *AIService) estimateWeightWithGemini(ctx context.Context, imageURL strin
 		}
 
 		responseText := geminiResp.Candidates[0].Content.Parts[0].(genai.Text)
-		responseStr := strings.TrimSpace(string(responseText))
+		weight, err = parseWeight(string(responseText))
+		return err
+	})
 
-		// Проверяем, есть ли на изображении еда
-		if responseStr == "NO_FOOD" {
-			return fmt.Errorf("NO_FOOD_DETECTED")
-		}
+	if err != nil {
+		return 0, fmt.Errorf("failed to estimate weight with retries: %w", err)
+	}
 
-		// Проверяем, содержит ли ответ только число
-		if strings.Contains(strings.ToLower(responseStr), "невозможно") ||
-			strings.Contains(strings.ToLower(responseStr), "нет еды") ||
-			strings.Contains(strings.ToLower(responseStr), "не видно") ||
-			len(responseStr) > 10 { // Если ответ слишком длинный, это не число
-			return fmt.Errorf("AI не смог определить вес: %s", responseStr)
-		}
+	return weight, nil
+}
 
-		parsedWeight, parseErr := strconv.ParseFloat(responseStr, 64)
-		if parseErr != nil {
-			return fmt.Errorf("failed to parse weight from response '%s': %w", responseStr, parseErr)
-		}
+func (s *AIService) estimateWeightWithOpenAI(ctx context.Context, imageURL, prompt string) (float64, error) {
+	resp, err := http.Get(imageURL)
+	if err != nil {
+		return 0, fmt.Errorf("failed to download image: %w", err)
+	}
+	defer resp.Body.Close()
 
-		weight = parsedWeight
-		return nil
+	imageData, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read image data: %w", err)
+	}
+
+	var weight float64
+	err = retryWithBackoff(ctx, func() error {
+		responseText, err := s.openaiRequest(ctx, imageData, prompt)
+		if err != nil {
+			return err
+		}
+		weight, err = parseWeight(responseText)
+		return err
 	})
 
 	if err != nil {
@@ -272,70 +646,117 @@ func (s *AIService) estimateWeightWithGemini(ctx context.Context, imageURL strin
 	return weight, nil
 }
 
-func (s *AIService) analyzeWithGemini(ctx context.Context, imageURL string, weight float64) (*FoodAnalysisResult, error) {
-	s.logger.DebugContext(ctx, "Starting Gemini analysis", "image_url", imageURL, "weight", weight)
-	model := s.geminiClient.GenerativeModel("gemini-2.0-flash")
+// parseWeight reads the weight from an answer to the weight estimation prompt
+func parseWeight(responseText string) (float64, error) {
+	responseStr := strings.TrimSpace(responseText)
 
-	// Download image
-	s.logger.DebugContext(ctx, "Downloading image from URL")
-	resp, err := http.Get(imageURL)
-	if err != nil {
-		return nil, apperrors.NewExternalAPIError(err, "HTTP").
-			WithContext("image_url", imageURL).
-			WithContext("operation", "download_image")
+	// Проверяем, есть ли на изображении еда
+	if responseStr == "NO_FOOD" {
+		return 0, fmt.Errorf("NO_FOOD_DETECTED")
 	}
-	defer resp.Body.Close()
 
-	imageData, err := io.ReadAll(resp.Body)
+	// Проверяем, содержит ли ответ только число
+	if strings.Contains(strings.ToLower(responseStr), "невозможно") ||
+		strings.Contains(strings.ToLower(responseStr), "нет еды") ||
+		strings.Contains(strings.ToLower(responseStr), "не видно") ||
+		len(responseStr) > 10 { // Если ответ слишком длинный, это не число
+		return 0, fmt.Errorf("AI не смог определить вес: %s", responseStr)
+	}
+
+	weight, err := strconv.ParseFloat(responseStr, 64)
 	if err != nil {
-		return nil, apperrors.NewInternalError(err).
-			WithContext("operation", "read_image_data")
+		return 0, fmt.Errorf("failed to parse weight from response '%s': %w", responseStr, err)
 	}
-	s.logger.DebugContext(ctx, "Downloaded image data", "bytes", len(imageData))
+	return weight, nil
+}
 
-	prompt := fmt.Sprintf(`Вы — точный ассистент по анализу продуктов питания для контроля диабета. Ваша основная задача — распознавать продукты на изображении, оценивать их вес, если он не указан, и рассчитывать общее количество углеводов.
+// imperialAnalysisNote makes the analysis prompt estimate sizes with US
+// references while keeping the JSON in grams
+const imperialAnalysisNote = `
+   * Пользователь мыслит в дюймах и унциях: оценивайте размеры по американским ориентирам (тарелка 10-11 дюймов, порция мяса 4-6 унций, чашка риса 5-7 унций), но в JSON указывайте вес в граммах (1 унция = 28,35 г).`
+
+// ingredientsAnalysisNote gives the analysis prompt the recipe from the
+// caption
+const ingredientsAnalysisNote = `
+**Состав от пользователя** (рецепт или список ингредиентов из подписи к фото, количества в нем точнее оценки по фото):
+"""
+%s
+"""
+Используйте его, чтобы определить продукты и их количество.`
+
+// foodAnalysisPrompt returns the meal analysis prompt for the entered weight,
+// 0 to let the model estimate it. Every provider gets the same prompt.
+func foodAnalysisPrompt(weight float64, opts PromptOptions) string {
+	var unitsNote, ingredientsNote string
+	if opts.Imperial {
+		unitsNote = imperialAnalysisNote
+	}
+	if opts.Ingredients != "" {
+		ingredientsNote = fmt.Sprintf(ingredientsAnalysisNote, opts.Ingredients)
+	}
+	return fmt.Sprintf(`Вы — точный ассистент по анализу продуктов питания для контроля диабета. Ваша основная задача — распознавать продукты на изображении, оценивать их вес, если он не указан, и рассчитывать общее количество углеводов.
 
-**Входные данные:** Изображение еды. Вес: %.1f г (если 0 - оцените самостоятельно).
+**Входные данные:** Изображение еды. Вес: %.1f г (если 0 - оцените самостоятельно).%s
 
 **Процесс:**
 1. **Определите ВСЕ съедобные продукты.** Сюда входят приготовленные блюда, сырые ингредиенты, закуски и калорийные напитки.
 2. **Если еда отсутствует:** (например, пустые тарелки, только столовые приборы, объекты, не являющиеся едой), верните JSON-структуру "НЕТ ЕДЫ", указанную ниже.
+   * Если на фото экран глюкометра или CGM с показанием сахара крови, верните структуру "ГЛЮКОМЕТР": значение с экрана в value и единицы в unit ("mmol" для ммоль/л, "mgdl" для мг/дл, "" если не видно).
 3. **Для каждого найденного продукта:**
-   * Оцените его индивидуальный вес в граммах, если общий вес равен 0 или требует уточнения.
+   * Оцените его индивидуальный вес в граммах, если общий вес равен 0 или требует уточнения.%s
    * Рассчитайте содержание углеводов в граммах, включая крахмалы, сахара и углеводы из панировки, соусов или глазури.
+   * Укажите углеводы каждого продукта в item_carbs в том же порядке, что и в food_items.
 4. **Рассчитайте общее количество углеводов** для всех найденных продуктов.
-5. **Определите уровень достоверности:** "high" (высокий), если продукты четко видны и легко идентифицируются; "medium" (средний), если есть некоторые неясности; "low" (низкий), если идентификация очень сложна или частична.
+5. **Определите гликемический индекс блюда в целом** (glycemic_index): "high" — быстрые углеводы (сладкое, белый хлеб, картофельное пюре, соки), "medium" — смешанная еда, "low" — медленные углеводы или много белка, жира и клетчатки.
+6. **Определите уровень достоверности:** "high" (высокий), если продукты четко видны и легко идентифицируются; "medium" (средний), если есть некоторые неясности; "low" (низкий), если идентификация очень сложна или частична.
 
 **КРИТИЧЕСКИ ВАЖНО: Отвечайте ТОЛЬКО валидным JSON объектом! Никакого дополнительного текста!**
 
 **Формат вывода (ТОЛЬКО JSON):**
 
 **A. Если еда не обнаружена:**
-{"food_items":[],"carbs":0,"confidence":"low","analysis_text":"На изображении не обнаружена еда. Пожалуйста, отправьте фото блюда для анализа.","weight":0}
+{"food_items":[],"item_carbs":[],"carbs":0,"confidence":"low","analysis_text":"На изображении не обнаружена еда. Пожалуйста, отправьте фото блюда для анализа.","weight":0}
+
+**B. Если на фото глюкометр:**
+{"food_items":[],"item_carbs":[],"carbs":0,"confidence":"high","analysis_text":"На фото глюкометр","weight":0,"meter_reading":{"value":X.X,"unit":"mmol/mgdl"}}
+
+**C. Если еда найдена:**
+{"food_items":["продукт1","продукт2"],"item_carbs":[Y1,Y2],"carbs":X.X,"confidence":"high/medium/low","analysis_text":"ПОДРОБНЫЙ АНАЛИЗ НА РУССКОМ: 1. Название блюда: Xг, Yг углеводов","weight":X.X,"glycemic_index":"high/medium/low"}
+
+Начинайте ответ с { и заканчивайте }. Возвращайте ТОЛЬКО JSON!`, weight, ingredientsNote, unitsNote)
+}
+
+func (s *AIService) analyzeWithGemini(ctx context.Context, imageURL string, weight float64, opts PromptOptions) (*FoodAnalysisResult, error) {
+	s.logger.DebugContext(ctx, "Starting Gemini analysis", "image_url", imageURL, "weight", weight)
+	model := s.geminiClient.GenerativeModel(geminiModel)
 
-**B. Если еда найдена:**
-{"food_items":["продукт1","продукт2"],"carbs":X.X,"confidence":"high/medium/low","analysis_text":"ПОДРОБНЫЙ АНАЛИЗ НА РУССКОМ: 1. Название блюда: Xг, Yг углеводов","weight":X.X}
+	// Download image
+	s.logger.DebugContext(ctx, "Downloading image from URL")
+	resp, err := http.Get(imageURL)
+	if err != nil {
+		return nil, apperrors.NewExternalAPIError(err, "HTTP").
+			WithContext("image_url", imageURL).
+			WithContext("operation", "download_image")
+	}
+	defer resp.Body.Close()
 
-Начинайте ответ с { и заканчивайте }. Возвращайте ТОЛЬКО JSON!`, weight)
+	imageData, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, apperrors.NewInternalError(err).
+			WithContext("operation", "read_image_data")
+	}
+	s.logger.DebugContext(ctx, "Downloaded image data", "bytes", len(imageData))
+
+	prompt := foodAnalysisPrompt(weight, opts)
 
 	var result FoodAnalysisResult
 	logger.Debug("Sending request to Gemini API")
-	err = retryWithBackoff(ctx, 3, func() error {
-		// Detect image format from content
-		imageFormat := "image/jpeg"
-		if len(imageData) > 4 {
-			if imageData[0] == 0x89 && imageData[1] == 0x50 && imageData[2] == 0x4E && imageData[3] == 0x47 {
-				imageFormat = "image/png"
-			} else if imageData[0] == 0x47 && imageData[1] == 0x49 && imageData[2] == 0x46 {
-				imageFormat = "image/gif"
-			} else if imageData[0] == 0xFF && imageData[1] == 0xD8 {
-				imageFormat = "image/jpeg"
-			}
-		}
+	err = retryWithBackoff(ctx, func() error {
+		imageFormat := detectImageFormat(imageData)
 		s.logger.DebugContext(ctx, "Detected image format", "format", imageFormat)
 
 		img := genai.ImageData(imageFormat, imageData)
-		geminiResp, err := model.GenerateContent(ctx, img, genai.Text(prompt))
+		geminiResp, err := s.generateContent(ctx, model, img, genai.Text(prompt))
 		if err != nil {
 			logger.Errorf("Gemini API request failed: %v", err)
 			return err
@@ -362,41 +783,131 @@ func (s *AIService) analyzeWithGemini(ctx context.Context, imageURL string, weig
 		responseText := geminiResp.Candidates[0].Content.Parts[0].(genai.Text)
 		s.logger.DebugContext(ctx, "Gemini raw response", "response", string(responseText))
 
-		// Extract JSON from the response, handling code blocks or text wrapping
-		jsonStr := extractJSON(string(responseText))
-		if jsonStr == "" {
-			logger.Error("No valid JSON found in Gemini response")
-			return fmt.Errorf("no valid JSON found in response")
-		}
-		s.logger.DebugContext(ctx, "Extracted JSON", "json", jsonStr)
-
-		if parseErr := json.Unmarshal([]byte(jsonStr), &result); parseErr != nil {
-			logger.Errorf("Failed to parse JSON response: %v", parseErr)
-			return fmt.Errorf("failed to parse response: %w", parseErr)
-		}
-		return nil
+		return parseFoodAnalysis(string(responseText), &result)
 	})
 
 	if err != nil {
 		// Check if it's a JSON parsing error - treat as no food detected
-		if strings.Contains(err.Error(), "no valid JSON found") || strings.Contains(err.Error(), "failed to parse response") {
+		if isUnparsableResponse(err) {
 			s.logger.InfoContext(ctx, "No valid JSON found, treating as no food detected")
-			return &FoodAnalysisResult{
-				FoodItems:    []string{},
-				Carbs:        0,
-				Confidence:   "low",
-				AnalysisText: "На изображении не обнаружена еда. Пожалуйста, отправьте фото блюда для анализа.",
-				Weight:       weight,
-			}, nil
+			return noFoodResult(weight, providerGemini, geminiModel), nil
 		}
 
 		logger.Errorf("Gemini analysis failed after retries: %v", err)
 		return nil, fmt.Errorf("failed to analyze with retries: %w", err)
 	}
 
+	result.Provider = providerGemini
+	result.Model = geminiModel
+	return &result, nil
+}
+
+func (s *AIService) analyzeWithOpenAI(ctx context.Context, imageURL string, weight float64, opts PromptOptions) (*FoodAnalysisResult, error) {
+	s.logger.DebugContext(ctx, "Starting OpenAI analysis", "image_url", imageURL, "weight", weight)
+
+	resp, err := http.Get(imageURL)
+	if err != nil {
+		return nil, apperrors.NewExternalAPIError(err, "HTTP").
+			WithContext("image_url", imageURL).
+			WithContext("operation", "download_image")
+	}
+	defer resp.Body.Close()
+
+	imageData, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, apperrors.NewInternalError(err).
+			WithContext("operation", "read_image_data")
+	}
+
+	prompt := foodAnalysisPrompt(weight, opts)
+
+	var result FoodAnalysisResult
+	err = retryWithBackoff(ctx, func() error {
+		responseText, err := s.openaiRequest(ctx, imageData, prompt)
+		if err != nil {
+			return err
+		}
+		s.logger.DebugContext(ctx, "OpenAI raw response", "response", responseText)
+		return parseFoodAnalysis(responseText, &result)
+	})
+
+	if err != nil {
+		if isUnparsableResponse(err) {
+			s.logger.InfoContext(ctx, "No valid JSON found, treating as no food detected")
+			return noFoodResult(weight, providerOpenAI, openaiModel), nil
+		}
+		return nil, fmt.Errorf("failed to analyze with retries: %w", err)
+	}
+
+	result.Provider = providerOpenAI
+	result.Model = openaiModel
 	return &result, nil
 }
 
+// openaiRequest sends the image and prompt to OpenAI and records the token
+// usage of the request
+func (s *AIService) openaiRequest(ctx context.Context, imageData []byte, prompt string) (string, error) {
+	text, usage, err := s.openai.analyzeImage(ctx, detectImageFormat(imageData), imageData, prompt)
+	if err != nil {
+		return "", err
+	}
+	if s.usage != nil {
+		if err := s.usage.Record(context.WithoutCancel(ctx), usage); err != nil {
+			s.logger.ErrorContext(ctx, "Failed to record AI usage", "error", err)
+		}
+	}
+	return text, nil
+}
+
+// parseFoodAnalysis fills result from a model's answer, which may wrap the
+// JSON in a code block or text
+func parseFoodAnalysis(responseText string, result *FoodAnalysisResult) error {
+	jsonStr := extractJSON(responseText)
+	if jsonStr == "" {
+		logger.Error("No valid JSON found in AI response")
+		return fmt.Errorf("no valid JSON found in response")
+	}
+
+	if err := json.Unmarshal([]byte(jsonStr), result); err != nil {
+		logger.Errorf("Failed to parse JSON response: %v", err)
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil
+}
+
+// isUnparsableResponse reports whether the model kept answering with
+// something other than the analysis JSON, which usually means there is no
+// food in the photo
+func isUnparsableResponse(err error) bool {
+	return strings.Contains(err.Error(), "no valid JSON found") || strings.Contains(err.Error(), "failed to parse response")
+}
+
+// noFoodResult is the analysis of a photo without food
+func noFoodResult(weight float64, provider, model string) *FoodAnalysisResult {
+	return &FoodAnalysisResult{
+		FoodItems:    []string{},
+		Carbs:        0,
+		Confidence:   "low",
+		AnalysisText: "На изображении не обнаружена еда. Пожалуйста, отправьте фото блюда для анализа.",
+		Weight:       weight,
+		Provider:     provider,
+		Model:        model,
+	}
+}
+
+// detectImageFormat returns the MIME type of the image by its magic bytes,
+// JPEG when unknown
+func detectImageFormat(imageData []byte) string {
+	if len(imageData) > 4 {
+		if imageData[0] == 0x89 && imageData[1] == 0x50 && imageData[2] == 0x4E && imageData[3] == 0x47 {
+			return "image/png"
+		} else if imageData[0] == 0x47 && imageData[1] == 0x49 && imageData[2] == 0x46 {
+			return "image/gif"
+		}
+	}
+	return "image/jpeg"
+}
+
 func extractJSON(s string) string {
 	// Remove markdown code blocks
 	s = strings.ReplaceAll(s, "```json", "")
@@ -0,0 +1,94 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	tests := []struct {
+		name    string
+		attempt int
+		jitter  float64
+		want    time.Duration
+	}{
+		{"first attempt, no jitter", 1, 0, 0},
+		{"first attempt, half", 1, 0.5, 250 * time.Millisecond},
+		{"first attempt, almost full", 1, 0.999, 499500 * time.Microsecond},
+		{"second attempt", 2, 0.5, 500 * time.Millisecond},
+		{"third attempt", 3, 0.5, time.Second},
+		{"fourth attempt", 4, 0.5, 2 * time.Second},
+		{"fifth attempt reaches the cap", 5, 0.5, 4 * time.Second},
+		{"sixth attempt stays at the cap", 6, 0.5, 4 * time.Second},
+		{"large attempt doesn't overflow", 70, 0.5, 4 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryBackoff(tt.attempt, tt.jitter); got != tt.want {
+				t.Errorf("retryBackoff(%d, %v) = %v, want %v", tt.attempt, tt.jitter, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryDeadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	contextDeadline, _ := ctx.Deadline()
+
+	if got := retryDeadline(ctx); !got.Equal(contextDeadline) {
+		t.Errorf("retryDeadline() = %v, want the context deadline %v", got, contextDeadline)
+	}
+
+	primary := retryDeadline(withPrimaryShare(ctx))
+	if left := time.Until(primary); left > 6*time.Second || left < 5*time.Second {
+		t.Errorf("primary share leaves %v, want about 6s of 10s", left)
+	}
+	// The fallback keeps the rest of the context's budget
+	if left := contextDeadline.Sub(primary); left < 3900*time.Millisecond {
+		t.Errorf("fallback gets %v, want about 4s", left)
+	}
+
+	// The share never extends a context deadline
+	late := context.WithValue(ctx, retryDeadlineKey{}, contextDeadline.Add(time.Minute))
+	if got := retryDeadline(late); !got.Equal(contextDeadline) {
+		t.Errorf("retryDeadline() with a later share = %v, want %v", got, contextDeadline)
+	}
+
+	if left := time.Until(retryDeadline(context.Background())); left > retryDefaultBudget || left < retryDefaultBudget-time.Second {
+		t.Errorf("retryDeadline() without a deadline leaves %v, want %v", left, retryDefaultBudget)
+	}
+}
+
+func TestRetryWithBackoffStopsAtPrimaryShare(t *testing.T) {
+	if err := logger.InitWithConfig(logger.Config{Level: logger.LevelError, OutputPath: "stdout"}); err != nil {
+		t.Fatalf("failed to init logger: %v", err)
+	}
+	// A share that is already used up leaves no time for a retry
+	ctx := context.WithValue(context.Background(), retryDeadlineKey{}, time.Now())
+	unavailable := &OpenAIError{StatusCode: 503, Message: "unavailable"}
+
+	calls := 0
+	start := time.Now()
+	err := retryWithBackoff(ctx, func() error {
+		calls++
+		return unavailable
+	})
+	if !errors.Is(err, unavailable) {
+		t.Errorf("retryWithBackoff() error = %v, want the last error", err)
+	}
+	if calls != 1 {
+		t.Errorf("fn called %d times, want 1", calls)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("retryWithBackoff() took %v, want it to return without waiting", elapsed)
+	}
+	if !shouldFallback(err) {
+		t.Error("the error left by the primary share should start the fallback")
+	}
+}
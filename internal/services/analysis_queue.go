@@ -0,0 +1,211 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/vladimiradmaev/diabetes-helper/internal/config"
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"gorm.io/gorm"
+)
+
+// ErrAnalysisQueueFull is returned when a photo would exceed the queue's maximum depth
+var ErrAnalysisQueueFull = errors.New("analysis queue is full")
+
+// analysisLease is how long a worker may take to deliver a queued photo's
+// result. A lease that runs out, e.g. because the instance crashed, makes the
+// photo available to the next worker.
+const analysisLease = 10 * time.Minute
+
+// MaxQueuedAttempts is how many leases a queued photo gets. A photo leased
+// more often has crashed its workers each time and is given up.
+const MaxQueuedAttempts = 3
+
+// analysisQueueLock is the advisory lock key that serializes Enqueue, so
+// concurrent photos can't both pass the depth check
+const analysisQueueLock = 0x41515545 // "AQUE"
+
+// AnalysisQueueService limits how many analyses are sent to the AI provider
+// per minute. The limit is a token bucket shared by all users: it holds up
+// to a minute's worth of analyses and refills continuously. Photos over the
+// limit wait in the queued_analyses table, so a restart doesn't drop them,
+// and are started in order as the bucket refills. A started photo stays in
+// the table, leased, until its result is delivered.
+//
+// The bucket lives in the process, so with several instances each one
+// allows the configured rate.
+type AnalysisQueueService struct {
+	db  *gorm.DB
+	cfg config.AIQueueConfig
+
+	mu       sync.Mutex
+	tokens   float64
+	refilled time.Time
+}
+
+func NewAnalysisQueueService(db *gorm.DB, cfg config.AIQueueConfig) *AnalysisQueueService {
+	return &AnalysisQueueService{
+		db:     db,
+		cfg:    cfg,
+		tokens: float64(cfg.PerMinute),
+	}
+}
+
+// takeToken takes one analysis from the bucket if the rate allows it now
+func (s *AnalysisQueueService) takeToken(now time.Time) bool {
+	if s.cfg.PerMinute <= 0 {
+		return true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	capacity := float64(s.cfg.PerMinute)
+	if !s.refilled.IsZero() {
+		s.tokens = min(capacity, s.tokens+now.Sub(s.refilled).Minutes()*capacity)
+	}
+	s.refilled = now
+	if s.tokens < 1 {
+		return false
+	}
+	s.tokens--
+	return true
+}
+
+// returnToken puts back a token that wasn't used
+func (s *AnalysisQueueService) returnToken() {
+	if s.cfg.PerMinute <= 0 {
+		return
+	}
+	s.mu.Lock()
+	s.tokens = min(float64(s.cfg.PerMinute), s.tokens+1)
+	s.mu.Unlock()
+}
+
+// Acquire reports whether an analysis may start right away. It may not when
+// the rate is used up or other photos are already waiting, so a new photo
+// doesn't overtake the queue.
+func (s *AnalysisQueueService) Acquire(ctx context.Context) (bool, error) {
+	if s.cfg.PerMinute <= 0 {
+		return true, nil
+	}
+
+	var queued int64
+	if err := s.db.WithContext(ctx).Model(&database.QueuedAnalysis{}).Where("claimed_at IS NULL").Count(&queued).Error; err != nil {
+		return false, fmt.Errorf("failed to count queued analyses: %w", err)
+	}
+	if queued > 0 {
+		return false, nil
+	}
+	return s.takeToken(time.Now()), nil
+}
+
+// Enqueue adds a photo to the end of the queue and returns its 1-based
+// position, or ErrAnalysisQueueFull when the queue is at its maximum depth.
+// An advisory lock held until commit makes the depth check and the insert
+// one step for concurrent callers.
+func (s *AnalysisQueueService) Enqueue(ctx context.Context, item *database.QueuedAnalysis) (int, error) {
+	var position int
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", analysisQueueLock).Error; err != nil {
+			return fmt.Errorf("failed to lock analysis queue: %w", err)
+		}
+		var queued int64
+		if err := tx.Model(&database.QueuedAnalysis{}).Where("claimed_at IS NULL").Count(&queued).Error; err != nil {
+			return fmt.Errorf("failed to count queued analyses: %w", err)
+		}
+		if int(queued) >= s.cfg.MaxDepth {
+			return ErrAnalysisQueueFull
+		}
+		if err := tx.Create(item).Error; err != nil {
+			return fmt.Errorf("failed to queue analysis: %w", err)
+		}
+		position = int(queued) + 1
+		return nil
+	})
+	return position, err
+}
+
+// SetMessageID stores the status message that is updated with the photo's position
+func (s *AnalysisQueueService) SetMessageID(ctx context.Context, id uint, messageID int) error {
+	if err := s.db.WithContext(ctx).Model(&database.QueuedAnalysis{}).Where("id = ?", id).Update("message_id", messageID).Error; err != nil {
+		return fmt.Errorf("failed to update queued analysis message: %w", err)
+	}
+	return nil
+}
+
+// Cancel removes the user's queued photo and returns it. It returns nil when
+// the photo is no longer queued, e.g. because its analysis has already started.
+func (s *AnalysisQueueService) Cancel(ctx context.Context, userID, id uint) (*database.QueuedAnalysis, error) {
+	var item database.QueuedAnalysis
+	if err := s.db.WithContext(ctx).Raw(`
+		DELETE FROM queued_analyses WHERE id = ? AND user_id = ? AND claimed_at IS NULL
+		RETURNING *`, id, userID).
+		Scan(&item).Error; err != nil {
+		return nil, fmt.Errorf("failed to cancel queued analysis: %w", err)
+	}
+	if item.ID == 0 {
+		return nil, nil
+	}
+	return &item, nil
+}
+
+// Next leases and returns the oldest queued photo if the rate allows starting
+// its analysis now, or nil. Photos whose lease ran out are taken again. The
+// photo stays queued until Done is called with it. SKIP LOCKED lets several
+// workers share the queue.
+func (s *AnalysisQueueService) Next(ctx context.Context) (*database.QueuedAnalysis, error) {
+	now := time.Now()
+	if !s.takeToken(now) {
+		return nil, nil
+	}
+
+	var item database.QueuedAnalysis
+	if err := s.db.WithContext(ctx).Raw(`
+		UPDATE queued_analyses SET claimed_at = ?, attempts = attempts + 1
+		WHERE id = (
+			SELECT id FROM queued_analyses
+			WHERE claimed_at IS NULL OR claimed_at < ?
+			ORDER BY id LIMIT 1 FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`, now, now.Add(-analysisLease)).
+		Scan(&item).Error; err != nil {
+		s.returnToken()
+		return nil, fmt.Errorf("failed to take queued analysis: %w", err)
+	}
+	if item.ID == 0 {
+		s.returnToken()
+		return nil, nil
+	}
+	return &item, nil
+}
+
+// Done removes a leased photo once its result, or the news that it failed,
+// has been delivered
+func (s *AnalysisQueueService) Done(ctx context.Context, id uint) error {
+	if err := s.db.WithContext(ctx).Delete(&database.QueuedAnalysis{}, id).Error; err != nil {
+		return fmt.Errorf("failed to remove queued analysis: %w", err)
+	}
+	return nil
+}
+
+// Queued returns the waiting photos in queue order
+func (s *AnalysisQueueService) Queued(ctx context.Context) ([]database.QueuedAnalysis, error) {
+	var items []database.QueuedAnalysis
+	if err := s.db.WithContext(ctx).Where("claimed_at IS NULL").Order("id ASC").Find(&items).Error; err != nil {
+		return nil, fmt.Errorf("failed to get queued analyses: %w", err)
+	}
+	return items, nil
+}
+
+// EstimateWait returns roughly how long the photo at the 1-based position
+// waits before its analysis starts
+func (s *AnalysisQueueService) EstimateWait(position int) time.Duration {
+	if s.cfg.PerMinute <= 0 {
+		return 0
+	}
+	return time.Duration(position) * time.Minute / time.Duration(s.cfg.PerMinute)
+}
@@ -0,0 +1,85 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// apiTokenPrefix makes tokens recognizable, e.g. in leaked-secret scanners
+const apiTokenPrefix = "dh_"
+
+// ErrInvalidAPIToken is returned when a token doesn't belong to any user
+var ErrInvalidAPIToken = errors.New("invalid API token")
+
+// APITokenService issues and checks personal HTTP API tokens
+type APITokenService struct {
+	db *gorm.DB
+}
+
+func NewAPITokenService(db *gorm.DB) *APITokenService {
+	return &APITokenService{db: db}
+}
+
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// IssueToken creates a new token for the user, replacing the previous one.
+// The token is only returned here; the database keeps its hash.
+func (s *APITokenService) IssueToken(ctx context.Context, userID uint) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate API token: %w", err)
+	}
+	token := apiTokenPrefix + hex.EncodeToString(buf)
+
+	record := database.APIToken{UserID: userID, TokenHash: hashAPIToken(token)}
+	err := s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"token_hash": record.TokenHash, "created_at": gorm.Expr("CURRENT_TIMESTAMP"), "last_used_at": nil}),
+	}).Create(&record).Error
+	if err != nil {
+		return "", fmt.Errorf("failed to save API token: %w", err)
+	}
+	return token, nil
+}
+
+// RevokeToken deletes the user's token; it is not an error if there is none
+func (s *APITokenService) RevokeToken(ctx context.Context, userID uint) error {
+	if err := s.db.WithContext(ctx).Where("user_id = ?", userID).Delete(&database.APIToken{}).Error; err != nil {
+		return fmt.Errorf("failed to revoke API token: %w", err)
+	}
+	return nil
+}
+
+// Authenticate returns the user the token belongs to
+func (s *APITokenService) Authenticate(ctx context.Context, token string) (*database.User, error) {
+	var record database.APIToken
+	err := s.db.WithContext(ctx).Where("token_hash = ?", hashAPIToken(token)).First(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidAPIToken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find API token: %w", err)
+	}
+
+	var user database.User
+	if err := s.db.WithContext(ctx).First(&user, record.UserID).Error; err != nil {
+		return nil, fmt.Errorf("failed to get API token user: %w", err)
+	}
+
+	if err := s.db.WithContext(ctx).Model(&record).Update("last_used_at", time.Now()).Error; err != nil {
+		return nil, fmt.Errorf("failed to update API token: %w", err)
+	}
+	return &user, nil
+}
@@ -0,0 +1,149 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// basalReminderWindow is how late a reminder is still sent, e.g. after a
+// restart. Later than that the dose may already have been taken without it.
+const basalReminderWindow = 2 * time.Hour
+
+// ErrBasalReminderTaken is returned when a reminder's dose was already logged
+var ErrBasalReminderTaken = errors.New("basal reminder already taken")
+
+// DueBasalReminder is a basal reminder to send to a user now
+type DueBasalReminder struct {
+	User     database.User
+	Reminder database.BasalReminder
+}
+
+// DueBasalReminders returns the basal reminders whose time has come by now in
+// their users' local time, of the given users or of all when userIDs is nil.
+// Each is recorded before it is returned, so a user gets one reminder per
+// local day even with several instances running. A reminder is held during
+// the user's quiet hours and sent within basalReminderWindow after they end,
+// and one set shortly before midnight is still sent after it. Users who paused
+// notifications get none.
+func (s *InjectionService) DueBasalReminders(ctx context.Context, now time.Time, userIDs []uint) ([]DueBasalReminder, error) {
+	query := s.db.WithContext(ctx).Where("basal_reminder_time <> '' AND NOT notifications_paused AND deleted_at IS NULL")
+	if userIDs != nil {
+		query = query.Where("id IN ?", userIDs)
+	}
+	var users []database.User
+	if err := query.Find(&users).Error; err != nil {
+		return nil, fmt.Errorf("failed to get users with basal reminders: %w", err)
+	}
+
+	var due []DueBasalReminder
+	for _, user := range users {
+		if InQuietHours(&user, now) {
+			continue
+		}
+		dayStart, ok := basalReminderDay(&user, now)
+		if !ok {
+			continue
+		}
+
+		reminder := database.BasalReminder{UserID: user.ID, Day: summaryDay(dayStart)}
+		result := s.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&reminder)
+		if result.Error != nil {
+			return due, fmt.Errorf("failed to record basal reminder: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+		due = append(due, DueBasalReminder{User: user, Reminder: reminder})
+	}
+	return due, nil
+}
+
+// basalReminderDay returns the start of the local day whose basal reminder is
+// due at now, today's or, when it runs past midnight, yesterday's. A reminder
+// is due from its time until basalReminderWindow after it, or after the end of
+// the quiet hours it fell into.
+func basalReminderDay(user *database.User, now time.Time) (time.Time, bool) {
+	loc := UserLocation(user)
+	today, _ := utils.DayBounds(now, loc)
+	yesterday := time.Date(today.Year(), today.Month(), today.Day()-1, 0, 0, 0, 0, loc)
+	offset := time.Duration(utils.TimeToMinutes(user.BasalReminderTime)) * time.Minute
+
+	for _, dayStart := range []time.Time{today, yesterday} {
+		at := dayStart.Add(offset)
+		if !now.Before(at) && !now.After(quietHoursOver(user, at).Add(basalReminderWindow)) {
+			return dayStart, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// quietHoursOver returns t, or the end of the user's quiet hours when t falls
+// into them
+func quietHoursOver(user *database.User, t time.Time) time.Time {
+	if !InQuietHours(user, t) {
+		return t
+	}
+	loc := UserLocation(user)
+	dayStart, _ := utils.DayBounds(t, loc)
+	end := dayStart.Add(time.Duration(utils.TimeToMinutes(user.QuietHoursEnd)) * time.Minute)
+	if !end.After(t) {
+		end = time.Date(dayStart.Year(), dayStart.Month(), dayStart.Day()+1, 0, 0, 0, 0, loc).
+			Add(time.Duration(utils.TimeToMinutes(user.QuietHoursEnd)) * time.Minute)
+	}
+	return end
+}
+
+// ReleaseBasalReminder drops the record of a basal reminder that couldn't be
+// sent, so it is sent again while still within basalReminderWindow. A
+// reminder already taken is kept.
+func (s *InjectionService) ReleaseBasalReminder(ctx context.Context, reminderID uint) error {
+	if err := s.db.WithContext(ctx).
+		Where("id = ? AND injection_id IS NULL", reminderID).
+		Delete(&database.BasalReminder{}).Error; err != nil {
+		return fmt.Errorf("failed to release basal reminder: %w", err)
+	}
+	return nil
+}
+
+// TakeBasalReminder logs the basal injection the reminder was about, with the
+// units currently set for the reminder. A reminder can be taken once.
+func (s *InjectionService) TakeBasalReminder(ctx context.Context, user *database.User, reminderID uint) (*database.Injection, error) {
+	if user.BasalReminderUnits <= 0 {
+		return nil, fmt.Errorf("basal reminder of user %d has no dose", user.ID)
+	}
+
+	now := time.Now()
+	injection := &database.Injection{
+		UserID:    user.ID,
+		Units:     user.BasalReminderUnits,
+		Kind:      InjectionKindBasal,
+		Timestamp: now,
+	}
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(injection).Error; err != nil {
+			return fmt.Errorf("failed to create injection: %w", err)
+		}
+		result := tx.Model(&database.BasalReminder{}).
+			Where("id = ? AND user_id = ? AND injection_id IS NULL", reminderID, user.ID).
+			Updates(map[string]interface{}{"injection_id": injection.ID, "taken_at": now})
+		if result.Error != nil {
+			return fmt.Errorf("failed to mark basal reminder taken: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrBasalReminderTaken
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	publishInjectionLogged(ctx, injection)
+	return injection, nil
+}
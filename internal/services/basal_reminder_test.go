@@ -0,0 +1,44 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+)
+
+func TestBasalReminderDay(t *testing.T) {
+	date := func(day, hour, minute int) time.Time {
+		return time.Date(2024, time.March, day, hour, minute, 0, 0, time.UTC)
+	}
+	evening := &database.User{Timezone: "UTC", BasalReminderTime: "22:00"}
+	lateEvening := &database.User{Timezone: "UTC", BasalReminderTime: "23:30"}
+	quiet := &database.User{Timezone: "UTC", BasalReminderTime: "23:00", QuietHoursStart: "22:30", QuietHoursEnd: "07:00"}
+	morningQuiet := &database.User{Timezone: "UTC", BasalReminderTime: "06:00", QuietHoursStart: "23:00", QuietHoursEnd: "08:00"}
+
+	tests := []struct {
+		name    string
+		user    *database.User
+		now     time.Time
+		wantDay time.Time // Zero when no reminder is due
+	}{
+		{"before the time", evening, date(10, 21, 59), time.Time{}},
+		{"at the time", evening, date(10, 22, 0), date(10, 0, 0)},
+		{"end of the window", evening, date(11, 0, 0), date(10, 0, 0)},
+		{"after the window", evening, date(11, 0, 1), time.Time{}},
+		{"past midnight", lateEvening, date(11, 0, 30), date(10, 0, 0)},
+		{"in quiet hours after midnight", quiet, date(11, 6, 0), date(10, 0, 0)},
+		{"held until quiet hours end", quiet, date(11, 8, 30), date(10, 0, 0)},
+		{"held too long", quiet, date(11, 9, 1), time.Time{}},
+		{"same day quiet hours", morningQuiet, date(10, 9, 0), date(10, 0, 0)},
+		{"same day quiet hours over", morningQuiet, date(10, 10, 1), time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			day, ok := basalReminderDay(tt.user, tt.now)
+			if ok != !tt.wantDay.IsZero() || (ok && !day.Equal(tt.wantDay)) {
+				t.Errorf("basalReminderDay(%v) = %v, %v, want %v", tt.now, day, ok, tt.wantDay)
+			}
+		})
+	}
+}
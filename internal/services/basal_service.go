@@ -0,0 +1,95 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/dosing"
+	"github.com/vladimiradmaev/diabetes-helper/internal/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// BasalService manages the basal rate schedule of pump users. Periods follow
+// the same rules as insulin ratios: HH:MM boundaries, may cross midnight,
+// must not overlap and cover at most 24 hours.
+type BasalService struct {
+	db *gorm.DB
+}
+
+func NewBasalService(db *gorm.DB) *BasalService {
+	return &BasalService{db: db}
+}
+
+// AddRate adds a period with the given rate in units per hour
+func (s *BasalService) AddRate(ctx context.Context, userID uint, startTime, endTime string, rate float64) error {
+	if _, err := time.Parse("15:04", startTime); err != nil {
+		return fmt.Errorf("invalid start time format: %w", err)
+	}
+	if _, err := time.Parse("15:04", endTime); err != nil {
+		return fmt.Errorf("invalid end time format: %w", err)
+	}
+	if rate < 0 {
+		return fmt.Errorf("basal rate cannot be negative")
+	}
+
+	// The user row is locked so that concurrent additions can't both pass the overlap check
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&database.User{}, userID).Error; err != nil {
+			return fmt.Errorf("failed to lock user: %w", err)
+		}
+
+		var existing []database.BasalRate
+		if err := tx.Where("user_id = ?", userID).Find(&existing).Error; err != nil {
+			return fmt.Errorf("failed to check existing basal rates: %w", err)
+		}
+		periods := make([][2]string, len(existing))
+		for i, r := range existing {
+			periods[i] = [2]string{r.StartTime, r.EndTime}
+		}
+		if err := checkPeriod(periods, startTime, endTime, "basal rate"); err != nil {
+			return err
+		}
+
+		basal := &database.BasalRate{
+			UserID:    userID,
+			StartTime: startTime,
+			EndTime:   endTime,
+			Rate:      rate,
+		}
+		if err := tx.Create(basal).Error; err != nil {
+			return fmt.Errorf("failed to create basal rate: %w", err)
+		}
+		return nil
+	})
+}
+
+func (s *BasalService) GetUserRates(ctx context.Context, userID uint) ([]database.BasalRate, error) {
+	var rates []database.BasalRate
+	if err := s.db.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Order("start_time ASC").
+		Find(&rates).Error; err != nil {
+		return nil, fmt.Errorf("failed to get basal rates: %w", err)
+	}
+	return rates, nil
+}
+
+// ClearRates deletes the user's whole basal schedule
+func (s *BasalService) ClearRates(ctx context.Context, userID uint) error {
+	if err := s.db.WithContext(ctx).Where("user_id = ?", userID).Delete(&database.BasalRate{}).Error; err != nil {
+		return fmt.Errorf("failed to delete basal rates: %w", err)
+	}
+	return nil
+}
+
+// DailyBasal returns the insulin a basal schedule delivers over a day
+func DailyBasal(rates []database.BasalRate) dosing.DeciUnits {
+	total := 0.0
+	for _, r := range rates {
+		total += r.Rate * float64(utils.PeriodMinutes(r.StartTime, r.EndTime)) / 60
+	}
+	return dosing.ToDeciUnits(total)
+}
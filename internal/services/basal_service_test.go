@@ -0,0 +1,35 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/dosing"
+)
+
+func TestDailyBasal(t *testing.T) {
+	tests := []struct {
+		name  string
+		rates []database.BasalRate
+		want  dosing.DeciUnits
+	}{
+		{"none", nil, 0},
+		{"whole day", []database.BasalRate{{StartTime: "00:00", EndTime: "00:00", Rate: 0.85}}, 204},
+		{"across midnight", []database.BasalRate{
+			{StartTime: "22:00", EndTime: "06:00", Rate: 0.7},
+			{StartTime: "06:00", EndTime: "22:00", Rate: 0.95},
+		}, 208},
+		{"tenths add up exactly", []database.BasalRate{
+			{StartTime: "00:00", EndTime: "08:00", Rate: 0.1},
+			{StartTime: "08:00", EndTime: "16:00", Rate: 0.2},
+			{StartTime: "16:00", EndTime: "00:00", Rate: 0.3},
+		}, 48},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DailyBasal(tt.rates); got != tt.want {
+				t.Errorf("DailyBasal = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
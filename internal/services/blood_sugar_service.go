@@ -3,12 +3,33 @@ package services
 import (
 	"context"
 	"fmt"
+	"math"
 	"time"
 
 	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/events"
+	"github.com/vladimiradmaev/diabetes-helper/internal/format"
 	"gorm.io/gorm"
 )
 
+// Glucose units supported by the bot
+const (
+	GlucoseUnitMmol = "mmol"
+	GlucoseUnitMgdl = "mgdl"
+)
+
+const (
+	// mgdlPerMmol is the conversion factor between mg/dL and mmol/L for glucose
+	mgdlPerMmol = 18.0
+
+	// Plausible meter readings for each unit. Values outside the configured
+	// unit's range but inside the other one are most likely a unit mix-up.
+	minPlausibleMmol = 1.0
+	maxPlausibleMmol = 33.3
+	minPlausibleMgdl = 20.0
+	maxPlausibleMgdl = 600.0
+)
+
 type BloodSugarService struct {
 	db *gorm.DB
 }
@@ -19,6 +40,7 @@ func NewBloodSugarService(db *gorm.DB) *BloodSugarService {
 	}
 }
 
+// AddRecord stores a blood sugar value, which must already be in mmol/L
 func (s *BloodSugarService) AddRecord(ctx context.Context, userID uint, value float64) error {
 	record := &database.BloodSugarRecord{
 		UserID:    userID,
@@ -29,6 +51,12 @@ func (s *BloodSugarService) AddRecord(ctx context.Context, userID uint, value fl
 	if err := s.db.WithContext(ctx).Create(record).Error; err != nil {
 		return fmt.Errorf("failed to create blood sugar record: %w", err)
 	}
+	events.Publish(ctx, events.BloodSugarLogged{
+		UserID:   userID,
+		RecordID: record.ID,
+		Value:    record.Value,
+		Time:     record.Timestamp,
+	})
 
 	return nil
 }
@@ -43,3 +71,136 @@ func (s *BloodSugarService) GetUserRecords(ctx context.Context, userID uint) ([]
 	}
 	return records, nil
 }
+
+// GetLatestSince returns the user's most recent record taken at or after
+// since, or nil when there is none
+func (s *BloodSugarService) GetLatestSince(ctx context.Context, userID uint, since time.Time) (*database.BloodSugarRecord, error) {
+	var records []database.BloodSugarRecord
+	if err := s.db.WithContext(ctx).
+		Where("user_id = ? AND timestamp >= ?", userID, since).
+		Order("timestamp DESC").
+		Limit(1).
+		Find(&records).Error; err != nil {
+		return nil, fmt.Errorf("failed to get latest blood sugar record: %w", err)
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	return &records[0], nil
+}
+
+// BloodSugarStats summarizes the glucose readings of a period, in mmol/L
+type BloodSugarStats struct {
+	Count  int
+	Mean   float64
+	Min    float64
+	Max    float64
+	StdDev float64 // Population standard deviation, 0 for fewer than two readings
+	// EstimatedHbA1c is the HbA1c in percent matching Mean by the ADAG study:
+	// (mean + 2.59) / 1.59
+	EstimatedHbA1c float64
+	// Trend is the mean of the second half of the period minus the mean of
+	// the first half; 0 when either half has no readings
+	Trend float64
+}
+
+// GetStatistics returns the statistics of the user's readings taken since
+// since; Count is 0 when there are none
+func (s *BloodSugarService) GetStatistics(ctx context.Context, userID uint, since time.Time) (*BloodSugarStats, error) {
+	var records []database.BloodSugarRecord
+	if err := s.db.WithContext(ctx).
+		Where("user_id = ? AND timestamp >= ?", userID, since).
+		Order("timestamp").
+		Find(&records).Error; err != nil {
+		return nil, fmt.Errorf("failed to get blood sugar records: %w", err)
+	}
+	return bloodSugarStats(records, since, time.Now()), nil
+}
+
+// bloodSugarStats computes the statistics of the readings of the period from
+// since to now
+func bloodSugarStats(records []database.BloodSugarRecord, since, now time.Time) *BloodSugarStats {
+	stats := &BloodSugarStats{Count: len(records)}
+	if len(records) == 0 {
+		return stats
+	}
+
+	middle := since.Add(now.Sub(since) / 2)
+	var sum, firstSum, secondSum float64
+	var firstCount, secondCount int
+	stats.Min, stats.Max = records[0].Value, records[0].Value
+	for _, r := range records {
+		sum += r.Value
+		stats.Min = math.Min(stats.Min, r.Value)
+		stats.Max = math.Max(stats.Max, r.Value)
+		if r.Timestamp.Before(middle) {
+			firstSum += r.Value
+			firstCount++
+		} else {
+			secondSum += r.Value
+			secondCount++
+		}
+	}
+	stats.Mean = sum / float64(len(records))
+
+	var squares float64
+	for _, r := range records {
+		squares += (r.Value - stats.Mean) * (r.Value - stats.Mean)
+	}
+	stats.StdDev = math.Sqrt(squares / float64(len(records)))
+	stats.EstimatedHbA1c = (stats.Mean + 2.59) / 1.59
+	if firstCount > 0 && secondCount > 0 {
+		stats.Trend = secondSum/float64(secondCount) - firstSum/float64(firstCount)
+	}
+	return stats
+}
+
+// MgdlToMmol converts a glucose value from mg/dL to mmol/L
+func MgdlToMmol(value float64) float64 {
+	return math.Round(value/mgdlPerMmol*10) / 10
+}
+
+// MmolToMgdl converts a glucose value from mmol/L to mg/dL
+func MmolToMgdl(value float64) float64 {
+	return math.Round(value * mgdlPerMmol)
+}
+
+// ToMmol converts a value entered in the given unit to mmol/L
+func ToMmol(value float64, unit string) float64 {
+	if unit == GlucoseUnitMgdl {
+		return MgdlToMmol(value)
+	}
+	return value
+}
+
+// OtherGlucoseUnit returns the unit opposite to the given one
+func OtherGlucoseUnit(unit string) string {
+	if unit == GlucoseUnitMgdl {
+		return GlucoseUnitMmol
+	}
+	return GlucoseUnitMgdl
+}
+
+// GlucoseUnitLabel returns the user-facing label for a glucose unit
+func GlucoseUnitLabel(unit string) string {
+	return format.GlucoseUnit(unit, format.Default)
+}
+
+// DetectUnitMismatch reports whether a value entered in the configured unit
+// looks like it was actually measured in the other unit. It only fires when
+// the value is implausible for the configured unit and plausible for the other,
+// so readings in the overlapping 20–33.3 range are never questioned.
+func DetectUnitMismatch(value float64, unit string) bool {
+	if unit == GlucoseUnitMgdl {
+		return !isPlausibleMgdl(value) && isPlausibleMmol(value)
+	}
+	return !isPlausibleMmol(value) && isPlausibleMgdl(value)
+}
+
+func isPlausibleMmol(value float64) bool {
+	return value >= minPlausibleMmol && value <= maxPlausibleMmol
+}
+
+func isPlausibleMgdl(value float64) bool {
+	return value >= minPlausibleMgdl && value <= maxPlausibleMgdl
+}
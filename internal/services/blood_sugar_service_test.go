@@ -0,0 +1,57 @@
+package services
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+)
+
+func TestBloodSugarStats(t *testing.T) {
+	now := time.Date(2025, 3, 15, 12, 0, 0, 0, time.UTC)
+	since := now.Add(-14 * 24 * time.Hour)
+	reading := func(daysAgo int, value float64) database.BloodSugarRecord {
+		return database.BloodSugarRecord{Timestamp: now.Add(-time.Duration(daysAgo) * 24 * time.Hour), Value: value}
+	}
+
+	tests := []struct {
+		name    string
+		records []database.BloodSugarRecord
+		want    BloodSugarStats
+	}{
+		{"no readings", nil, BloodSugarStats{}},
+		{
+			"one reading", []database.BloodSugarRecord{reading(1, 7)},
+			BloodSugarStats{Count: 1, Mean: 7, Min: 7, Max: 7, EstimatedHbA1c: (7 + 2.59) / 1.59},
+		},
+		{
+			"rising", []database.BloodSugarRecord{reading(12, 5), reading(10, 7), reading(3, 8), reading(1, 10)},
+			BloodSugarStats{Count: 4, Mean: 7.5, Min: 5, Max: 10, StdDev: math.Sqrt(3.25), EstimatedHbA1c: (7.5 + 2.59) / 1.59, Trend: 3},
+		},
+		{
+			"falling", []database.BloodSugarRecord{reading(13, 9), reading(2, 6)},
+			BloodSugarStats{Count: 2, Mean: 7.5, Min: 6, Max: 9, StdDev: 1.5, EstimatedHbA1c: (7.5 + 2.59) / 1.59, Trend: -3},
+		},
+		{
+			// The middle of the period counts toward the second half
+			"only second half", []database.BloodSugarRecord{reading(7, 4), reading(2, 6)},
+			BloodSugarStats{Count: 2, Mean: 5, Min: 4, Max: 6, StdDev: 1, EstimatedHbA1c: (5 + 2.59) / 1.59},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := bloodSugarStats(tt.records, since, now)
+			if got.Count != tt.want.Count || !near(got.Mean, tt.want.Mean) || !near(got.Min, tt.want.Min) ||
+				!near(got.Max, tt.want.Max) || !near(got.StdDev, tt.want.StdDev) ||
+				!near(got.EstimatedHbA1c, tt.want.EstimatedHbA1c) || !near(got.Trend, tt.want.Trend) {
+				t.Errorf("bloodSugarStats() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
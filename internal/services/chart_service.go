@@ -0,0 +1,234 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/dosing"
+	"github.com/vladimiradmaev/diabetes-helper/internal/utils"
+)
+
+// Layout of the schedule image, in pixels
+const (
+	chartWidth        = 760
+	chartPadding      = 20
+	chartTimelineTop  = 20
+	chartTimelineH    = 56
+	chartTicksH       = 36
+	chartRowH         = 52
+	chartTextScale    = 4
+	chartSmallScale   = 2
+	chartMinutesPerPx = 2 // 24h timeline is 720 px wide
+)
+
+var (
+	chartBackground = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	chartText       = color.RGBA{0x33, 0x33, 0x33, 0xff}
+	chartGrid       = color.RGBA{0xdd, 0xdd, 0xdd, 0xff}
+	chartUncovered  = color.RGBA{0xee, 0xee, 0xee, 0xff}
+	chartPalette    = []color.RGBA{
+		{0x8e, 0xc5, 0xfc, 0xff},
+		{0xff, 0xc8, 0x7c, 0xff},
+		{0xa8, 0xe0, 0x9c, 0xff},
+		{0xf4, 0xa6, 0xb8, 0xff},
+		{0xc9, 0xb3, 0xf2, 0xff},
+		{0xfa, 0xe3, 0x8a, 0xff},
+	}
+)
+
+// ChartService renders images for users to save or show their doctor
+type ChartService struct{}
+
+func NewChartService() *ChartService {
+	return &ChartService{}
+}
+
+// RenderSchedule draws the ratio schedule as a PNG: a 24-hour timeline with
+// each period in its own color, followed by a table of periods and ratios.
+// The image only contains digits; titles belong in the message caption.
+func (s *ChartService) RenderSchedule(ratios []database.InsulinRatio) ([]byte, error) {
+	ratios = append([]database.InsulinRatio(nil), ratios...)
+	sort.Slice(ratios, func(i, j int) bool { return ratios[i].StartTime < ratios[j].StartTime })
+
+	tableTop := chartTimelineTop + chartTimelineH + chartTicksH + chartPadding
+	height := tableTop + len(ratios)*chartRowH + chartPadding
+	img := image.NewRGBA(image.Rect(0, 0, chartWidth, height))
+	draw.Draw(img, img.Bounds(), &image.Uniform{chartBackground}, image.Point{}, draw.Src)
+
+	// Timeline: every column is colored by the period covering its minutes
+	for x := 0; x < 24*60/chartMinutesPerPx; x++ {
+		fill := chartUncovered
+		for i, r := range ratios {
+			if utils.PeriodContains(r.StartTime, r.EndTime, x*chartMinutesPerPx) {
+				fill = chartPalette[i%len(chartPalette)]
+				break
+			}
+		}
+		fillRect(img, chartPadding+x, chartTimelineTop, 1, chartTimelineH, fill)
+	}
+
+	// Hour ticks every 3 hours
+	for hour := 0; hour <= 24; hour += 3 {
+		x := chartPadding + hour*60/chartMinutesPerPx
+		fillRect(img, x, chartTimelineTop, 1, chartTimelineH+8, chartGrid)
+		label := fmt.Sprintf("%d", hour)
+		drawText(img, x-textWidth(label, chartSmallScale)/2, chartTimelineTop+chartTimelineH+14, chartSmallScale, chartText, label)
+	}
+
+	// Table: color swatch, period and ratio
+	for i, r := range ratios {
+		y := tableTop + i*chartRowH
+		fillRect(img, chartPadding, y, chartWidth-2*chartPadding, 1, chartGrid)
+		fillRect(img, chartPadding, y+12, 28, 28, chartPalette[i%len(chartPalette)])
+		drawText(img, chartPadding+48, y+12, chartTextScale, chartText, r.StartTime+"-"+r.EndTime)
+		ratio := fmt.Sprintf("%.1f", r.Ratio)
+		drawText(img, chartWidth-chartPadding-textWidth(ratio, chartTextScale), y+12, chartTextScale, chartText, ratio)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("failed to encode schedule image: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Layout of the insulin chart, in pixels
+const (
+	insulinChartTop     = 40 // Room for the total above the highest bar
+	insulinChartBarsH   = 320
+	insulinChartLabelsH = 40
+)
+
+// Insulin chart colors by injection kind, bottom to top of a bar
+var insulinChartKinds = []struct {
+	kind  string
+	color color.RGBA
+}{
+	{InjectionKindBasal, chartPalette[2]},
+	{InjectionKindBolus, chartPalette[0]},
+	{InjectionKindCorrection, chartPalette[1]},
+}
+
+// RenderInsulinChart draws the logged insulin of the last days (today
+// included) as a PNG: one stacked bar per local day split into basal (green),
+// bolus (blue) and correction (orange) units, with the day's total above the
+// bar and the day of month below. Like the schedule, the image only contains
+// digits; the legend belongs in the caption.
+func (s *ChartService) RenderInsulinChart(injections []database.Injection, loc *time.Location, days int) ([]byte, error) {
+	if days < 1 {
+		return nil, fmt.Errorf("invalid number of days: %d", days)
+	}
+
+	todayStart, _ := utils.DayBounds(time.Now(), loc)
+	firstDay := todayStart.AddDate(0, 0, -(days - 1))
+	units := make([]map[string]dosing.DeciUnits, days)
+	for i := range units {
+		units[i] = make(map[string]dosing.DeciUnits)
+	}
+	for _, inj := range injections {
+		dayStart, _ := utils.DayBounds(inj.Timestamp, loc)
+		i := int(math.Round(dayStart.Sub(firstDay).Hours() / 24))
+		if i < 0 || i >= days {
+			continue
+		}
+		units[i][inj.Kind] += inj.Units
+	}
+
+	maxTotal := 0.0
+	for _, u := range units {
+		maxTotal = math.Max(maxTotal, (u[InjectionKindBasal] + u[InjectionKindBolus] + u[InjectionKindCorrection]).Units())
+	}
+
+	height := insulinChartTop + insulinChartBarsH + insulinChartLabelsH + chartPadding
+	img := image.NewRGBA(image.Rect(0, 0, chartWidth, height))
+	draw.Draw(img, img.Bounds(), &image.Uniform{chartBackground}, image.Point{}, draw.Src)
+
+	baseline := insulinChartTop + insulinChartBarsH
+	fillRect(img, chartPadding, baseline, chartWidth-2*chartPadding, 1, chartGrid)
+
+	slot := (chartWidth - 2*chartPadding) / days
+	barW := slot * 3 / 5
+	for i, u := range units {
+		x := chartPadding + i*slot + (slot-barW)/2
+		top := baseline
+		var total dosing.DeciUnits
+		for _, k := range insulinChartKinds {
+			if u[k.kind] <= 0 {
+				continue
+			}
+			total += u[k.kind]
+			h := int(math.Round(u[k.kind].Units() / maxTotal * insulinChartBarsH))
+			fillRect(img, x, top-h, barW, h, k.color)
+			top -= h
+		}
+		if total > 0 {
+			label := fmt.Sprintf("%.0f", total.Units())
+			drawText(img, x+barW/2-textWidth(label, chartSmallScale)/2, top-8-7*chartSmallScale, chartSmallScale, chartText, label)
+		}
+		day := fmt.Sprintf("%d", firstDay.AddDate(0, 0, i).Day())
+		drawText(img, x+barW/2-textWidth(day, chartSmallScale)/2, baseline+14, chartSmallScale, chartText, day)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("failed to encode insulin chart: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func fillRect(img *image.RGBA, x, y, w, h int, c color.RGBA) {
+	draw.Draw(img, image.Rect(x, y, x+w, y+h), &image.Uniform{c}, image.Point{}, draw.Src)
+}
+
+// glyphs is a 5x7 bitmap font covering what schedules need: digits and
+// time/number punctuation. Each row is 5 bits, most significant bit first.
+var glyphs = map[rune][7]uint8{
+	'0': {0x0e, 0x11, 0x13, 0x15, 0x19, 0x11, 0x0e},
+	'1': {0x04, 0x0c, 0x04, 0x04, 0x04, 0x04, 0x0e},
+	'2': {0x0e, 0x11, 0x01, 0x02, 0x04, 0x08, 0x1f},
+	'3': {0x1f, 0x02, 0x04, 0x02, 0x01, 0x11, 0x0e},
+	'4': {0x02, 0x06, 0x0a, 0x12, 0x1f, 0x02, 0x02},
+	'5': {0x1f, 0x10, 0x1e, 0x01, 0x01, 0x11, 0x0e},
+	'6': {0x06, 0x08, 0x10, 0x1e, 0x11, 0x11, 0x0e},
+	'7': {0x1f, 0x01, 0x02, 0x04, 0x08, 0x08, 0x08},
+	'8': {0x0e, 0x11, 0x11, 0x0e, 0x11, 0x11, 0x0e},
+	'9': {0x0e, 0x11, 0x11, 0x0f, 0x01, 0x02, 0x0c},
+	':': {0x00, 0x0c, 0x0c, 0x00, 0x0c, 0x0c, 0x00},
+	'-': {0x00, 0x00, 0x00, 0x1f, 0x00, 0x00, 0x00},
+	'.': {0x00, 0x00, 0x00, 0x00, 0x00, 0x0c, 0x0c},
+}
+
+// glyphAdvance is the width of a glyph plus spacing, in font pixels
+const glyphAdvance = 6
+
+func textWidth(text string, scale int) int {
+	n := len([]rune(text))
+	if n == 0 {
+		return 0
+	}
+	return (n*glyphAdvance - 1) * scale
+}
+
+// drawText draws text with the bitmap font; characters without a glyph are left blank
+func drawText(img *image.RGBA, x, y, scale int, c color.RGBA, text string) {
+	for _, r := range text {
+		if glyph, ok := glyphs[r]; ok {
+			for row, bits := range glyph {
+				for col := 0; col < 5; col++ {
+					if bits&(0x10>>col) != 0 {
+						fillRect(img, x+col*scale, y+row*scale, scale, scale, c)
+					}
+				}
+			}
+		}
+		x += glyphAdvance * scale
+	}
+}
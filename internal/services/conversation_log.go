@@ -0,0 +1,191 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/vladimiradmaev/diabetes-helper/internal/config"
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+	"gorm.io/gorm"
+)
+
+// Conversation log directions
+const (
+	ConversationIn  = "in"
+	ConversationOut = "out"
+)
+
+// Limits of what one conversation log entry keeps when texts are not stored
+const (
+	conversationTemplateRunes = 60
+	conversationMaxVariables  = 10
+)
+
+var conversationNumber = regexp.MustCompile(`\d+(?:[.,]\d+)?`)
+
+// ErrConversationLogDisabled is returned when the conversation log is read
+// while CONVERSATION_LOG_ENABLED is off
+var ErrConversationLogDisabled = errors.New("conversation log is disabled")
+
+// ConversationLogService records both directions of users' conversations for
+// a short time, so a complaint about a wrong reply can be traced back to the
+// update that caused it. Unless full texts are enabled, only the kind of a
+// message, its key and the numbers in it are kept.
+type ConversationLogService struct {
+	db  *gorm.DB
+	cfg config.ConversationLogConfig
+
+	mu      sync.Mutex
+	current map[int64]int // Chat ID → update being handled in it
+}
+
+func NewConversationLogService(db *gorm.DB, cfg config.ConversationLogConfig) *ConversationLogService {
+	return &ConversationLogService{db: db, cfg: cfg, current: make(map[int64]int)}
+}
+
+// Enabled reports whether conversations are recorded
+func (s *ConversationLogService) Enabled() bool {
+	return s.cfg.Enabled
+}
+
+// FullText reports whether message texts are kept
+func (s *ConversationLogService) FullText() bool {
+	return s.cfg.FullText
+}
+
+// BeginUpdate records an incoming update and attributes the messages sent to
+// the chat to it until the returned function is called. With several updates
+// of one chat in flight, replies go to the latest one.
+func (s *ConversationLogService) BeginUpdate(chatID int64, updateID int, kind, key, text string) func() {
+	if !s.cfg.Enabled {
+		return func() {}
+	}
+
+	s.mu.Lock()
+	s.current[chatID] = updateID
+	s.mu.Unlock()
+
+	entry := database.ConversationLog{
+		ChatID:    chatID,
+		UpdateID:  updateID,
+		Direction: ConversationIn,
+		Kind:      kind,
+		Template:  key,
+	}
+	s.save(entry, text)
+
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.current[chatID] == updateID {
+			delete(s.current, chatID)
+		}
+	}
+}
+
+// RecordOutgoing records a Bot API call sending or editing a message in the
+// chat. Only the first line of the text is kept as its template, with the
+// digits masked so that replies of the same kind look the same.
+func (s *ConversationLogService) RecordOutgoing(chatID int64, method, text string) {
+	if !s.cfg.Enabled {
+		return
+	}
+
+	s.mu.Lock()
+	updateID := s.current[chatID]
+	s.mu.Unlock()
+
+	template, _, _ := strings.Cut(strings.TrimSpace(text), "\n")
+	template = conversationNumber.ReplaceAllString(template, "#")
+	if utf8.RuneCountInString(template) > conversationTemplateRunes {
+		template = string([]rune(template)[:conversationTemplateRunes]) + "…"
+	}
+
+	entry := database.ConversationLog{
+		ChatID:    chatID,
+		UpdateID:  updateID,
+		Direction: ConversationOut,
+		Kind:      method,
+		Template:  template,
+	}
+	s.save(entry, text)
+}
+
+// save adds the numbers and, if enabled, the text to the entry and writes it
+// in the background so that logging never delays a reply
+func (s *ConversationLogService) save(entry database.ConversationLog, text string) {
+	numbers := conversationNumber.FindAllString(text, conversationMaxVariables)
+	entry.Variables = strings.Join(numbers, " ")
+	if s.cfg.FullText {
+		entry.Text = text
+	}
+	entry.CreatedAt = time.Now()
+
+	go func() {
+		if err := s.db.Create(&entry).Error; err != nil {
+			logger.Warning("Failed to write conversation log entry", "chat_id", entry.ChatID, "error", err)
+		}
+	}()
+}
+
+// Transcript returns the entries of the chat since its exchanges-th latest
+// incoming update, oldest first. The access is recorded like a /support
+// lookup.
+func (s *ConversationLogService) Transcript(ctx context.Context, adminTelegramID, telegramID int64, exchanges int) ([]database.ConversationLog, error) {
+	if !s.cfg.Enabled {
+		return nil, ErrConversationLogDisabled
+	}
+
+	entry := &database.AuditEntry{
+		ActorTelegramID:  adminTelegramID,
+		Action:           AuditActionConversationView,
+		TargetTelegramID: telegramID,
+	}
+	var user database.User
+	if err := s.db.WithContext(ctx).Where("telegram_id = ?", telegramID).First(&user).Error; err == nil {
+		entry.TargetUserID = &user.ID
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if err := s.db.WithContext(ctx).Create(entry).Error; err != nil {
+		return nil, fmt.Errorf("failed to write audit entry: %w", err)
+	}
+
+	// Private chats have the user's Telegram ID as chat ID
+	var incoming []database.ConversationLog
+	if err := s.db.WithContext(ctx).Where("chat_id = ? AND direction = ?", telegramID, ConversationIn).
+		Order("created_at DESC, id DESC").Limit(exchanges).Find(&incoming).Error; err != nil {
+		return nil, fmt.Errorf("failed to get conversation log: %w", err)
+	}
+	if len(incoming) == 0 {
+		return nil, nil
+	}
+	since := incoming[len(incoming)-1].CreatedAt
+
+	var entries []database.ConversationLog
+	if err := s.db.WithContext(ctx).Where("chat_id = ? AND created_at >= ?", telegramID, since).
+		Order("created_at ASC, id ASC").Find(&entries).Error; err != nil {
+		return nil, fmt.Errorf("failed to get conversation log: %w", err)
+	}
+	return entries, nil
+}
+
+// DeleteExpired removes entries older than the retention period
+func (s *ConversationLogService) DeleteExpired(ctx context.Context) error {
+	result := s.db.WithContext(ctx).Where("created_at < ?", time.Now().Add(-s.cfg.Retention)).
+		Delete(&database.ConversationLog{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete expired conversation log entries: %w", result.Error)
+	}
+	if result.RowsAffected > 0 {
+		logger.Info("Expired conversation log entries deleted", "count", result.RowsAffected)
+	}
+	return nil
+}
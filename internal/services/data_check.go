@@ -0,0 +1,157 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/dosing"
+	"github.com/vladimiradmaev/diabetes-helper/internal/utils"
+	"gorm.io/gorm"
+)
+
+// dataCheckSamples is how many IDs each finding lists
+const dataCheckSamples = 5
+
+// Stored values beyond these are not a typo a user could make through the
+// bot and point to a bug or a manual edit
+const (
+	maxSaneRatio         = 20.0  // Insulin units per XE
+	maxSaneInjectionUnit = 100.0 // Same limit as the injection input
+)
+
+// DataCheckFinding is one kind of anomaly with the number of affected rows
+// and the IDs of a few of them
+type DataCheckFinding struct {
+	Name      string
+	Count     int
+	SampleIDs []uint
+}
+
+// DataCheckService looks for inconsistent data, e.g. left by past bugs, so
+// affected users can be fixed before they notice. It only reads.
+type DataCheckService struct {
+	db *gorm.DB
+}
+
+func NewDataCheckService(db *gorm.DB) *DataCheckService {
+	return &DataCheckService{db: db}
+}
+
+// dataCheckQuery finds the IDs of rows of a table matching a condition
+type dataCheckQuery struct {
+	name  string
+	table string
+	where string
+	args  []interface{}
+}
+
+// orphanTables are the tables whose rows belong to a user
+var orphanTables = []string{
+	"food_analyses",
+	"food_analysis_corrections",
+	"food_items",
+	"blood_sugar_records",
+	"insulin_ratios",
+	"basal_rates",
+	"injections",
+	"events",
+	"daily_summaries",
+	"settings_snapshots",
+	"jobs",
+	"api_tokens",
+}
+
+// Check runs all checks and returns every kind of anomaly, including those
+// with no rows, in a fixed order
+func (s *DataCheckService) Check(ctx context.Context) ([]DataCheckFinding, error) {
+	var findings []DataCheckFinding
+
+	coverage, err := s.checkRatioCoverage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	findings = append(findings, coverage)
+
+	queries := []dataCheckQuery{
+		{"Анализы: углеводов больше веса (analysis id)", "food_analyses", "weight > 0 AND carbs > weight", nil},
+		{"Анализы: отрицательные вес, углеводы или доза (analysis id)", "food_analyses", "weight < 0 OR carbs < 0 OR insulin_units < 0", nil},
+		{"Сахар вне диапазона глюкометра (record id)", "blood_sugar_records", "value < ? OR value > ?", []interface{}{minPlausibleMmol, maxPlausibleMmol}},
+		{"Коэффициенты ≤ 0 или больше 20 ед/ХЕ (ratio id)", "insulin_ratios", "ratio <= 0 OR ratio > ?", []interface{}{maxSaneRatio}},
+		{"Уколы ≤ 0 или больше 100 ед (injection id)", "injections", "units <= 0 OR units > ?", []interface{}{dosing.ToDeciUnits(maxSaneInjectionUnit)}},
+	}
+	queries = append(queries, dataCheckQuery{
+		name:  "Дубли пользователей с одним Telegram ID, объединить: /merge_users (user id)",
+		table: "users",
+		where: "telegram_id IN (SELECT telegram_id FROM users GROUP BY telegram_id HAVING COUNT(*) > 1)",
+	})
+	queries = append(queries, dataCheckQuery{
+		name:  "Записи без пользователя, перенесенные миграцией в orphaned_user_rows (id)",
+		table: "orphaned_user_rows",
+		where: "TRUE",
+	})
+	for _, table := range orphanTables {
+		queries = append(queries, dataCheckQuery{
+			name:  fmt.Sprintf("Записи без пользователя: %s (id)", table),
+			table: table,
+			where: "NOT EXISTS (SELECT 1 FROM users WHERE users.id = " + table + ".user_id)",
+		})
+	}
+
+	for _, q := range queries {
+		finding, err := s.runQuery(ctx, q)
+		if err != nil {
+			return nil, err
+		}
+		findings = append(findings, finding)
+	}
+	return findings, nil
+}
+
+// runQuery counts the rows matching the query and takes the first few IDs
+func (s *DataCheckService) runQuery(ctx context.Context, q dataCheckQuery) (DataCheckFinding, error) {
+	finding := DataCheckFinding{Name: q.name}
+
+	var count int64
+	if err := s.db.WithContext(ctx).Table(q.table).Where(q.where, q.args...).Count(&count).Error; err != nil {
+		return finding, fmt.Errorf("failed to check %s: %w", q.table, err)
+	}
+	finding.Count = int(count)
+	if count == 0 {
+		return finding, nil
+	}
+
+	if err := s.db.WithContext(ctx).Table(q.table).Where(q.where, q.args...).
+		Order("id ASC").Limit(dataCheckSamples).Pluck("id", &finding.SampleIDs).Error; err != nil {
+		return finding, fmt.Errorf("failed to check %s: %w", q.table, err)
+	}
+	return finding, nil
+}
+
+// checkRatioCoverage finds users whose ratio periods don't add up to exactly
+// 24 hours. Users without any ratios haven't set them up yet and are skipped.
+func (s *DataCheckService) checkRatioCoverage(ctx context.Context) (DataCheckFinding, error) {
+	finding := DataCheckFinding{Name: "Коэффициенты покрывают не 24 часа (user id)"}
+
+	var ratios []database.InsulinRatio
+	if err := s.db.WithContext(ctx).Select("user_id", "start_time", "end_time").Find(&ratios).Error; err != nil {
+		return finding, fmt.Errorf("failed to check insulin ratios: %w", err)
+	}
+
+	minutes := make(map[uint]int)
+	for _, r := range ratios {
+		minutes[r.UserID] += utils.PeriodMinutes(r.StartTime, r.EndTime)
+	}
+	var userIDs []uint
+	for userID, total := range minutes {
+		if total != 24*60 {
+			userIDs = append(userIDs, userID)
+		}
+	}
+	sort.Slice(userIDs, func(i, j int) bool { return userIDs[i] < userIDs[j] })
+
+	finding.Count = len(userIDs)
+	finding.SampleIDs = userIDs[:min(len(userIDs), dataCheckSamples)]
+	return finding, nil
+}
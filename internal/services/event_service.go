@@ -0,0 +1,113 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"gorm.io/gorm"
+)
+
+// Event kinds that can be quick-logged
+const (
+	EventKindFastCarbs = "fast_carbs"
+	EventKindExercise  = "exercise"
+	EventKindSick      = "sick"
+	EventKindStress    = "stress"
+	EventKindSleep     = "sleep"
+)
+
+// eventEmoji maps recognized emoji to event kinds
+var eventEmoji = map[string]string{
+	"🍬": EventKindFastCarbs,
+	"🍭": EventKindFastCarbs,
+	"🧃": EventKindFastCarbs,
+	"🏃": EventKindExercise,
+	"🚴": EventKindExercise,
+	"🏋": EventKindExercise,
+	"🏊": EventKindExercise,
+	"🤒": EventKindSick,
+	"🤢": EventKindSick,
+	"😰": EventKindStress,
+	"😫": EventKindStress,
+	"😴": EventKindSleep,
+}
+
+var eventKindLabels = map[string]string{
+	EventKindFastCarbs: "Быстрые углеводы",
+	EventKindExercise:  "Физическая нагрузка",
+	EventKindSick:      "Плохое самочувствие",
+	EventKindStress:    "Стресс",
+	EventKindSleep:     "Сон",
+}
+
+// EventKindForEmoji returns the event kind for an emoji. Skin tones, gender
+// variants and variation selectors are ignored, so "🏃‍♀️" counts as "🏃".
+func EventKindForEmoji(emoji string) (string, bool) {
+	kind, ok := eventEmoji[baseEmoji(emoji)]
+	return kind, ok
+}
+
+// EventKindLabel returns the user-facing name of an event kind
+func EventKindLabel(kind string) string {
+	if label, ok := eventKindLabels[kind]; ok {
+		return label
+	}
+	return kind
+}
+
+// EventEmojiHelp lists the supported emoji, one per event kind
+func EventEmojiHelp() string {
+	return "🍬 быстрые углеводы, 🏃 нагрузка, 🤒 плохое самочувствие, 😰 стресс, 😴 сон"
+}
+
+// baseEmoji strips modifiers from an emoji and keeps only the first element
+// of a ZWJ sequence
+func baseEmoji(emoji string) string {
+	emoji = strings.TrimSpace(emoji)
+	if i := strings.Index(emoji, "\u200d"); i >= 0 {
+		emoji = emoji[:i]
+	}
+	return strings.Map(func(r rune) rune {
+		if r == '\ufe0f' || (r >= 0x1f3fb && r <= 0x1f3ff) {
+			return -1
+		}
+		return r
+	}, emoji)
+}
+
+type EventService struct {
+	db *gorm.DB
+}
+
+func NewEventService(db *gorm.DB) *EventService {
+	return &EventService{db: db}
+}
+
+// AddEvent records an event of the given kind at the current time
+func (s *EventService) AddEvent(ctx context.Context, userID uint, kind, emoji string) (*database.Event, error) {
+	event := &database.Event{
+		UserID:    userID,
+		Kind:      kind,
+		Emoji:     emoji,
+		Timestamp: time.Now(),
+	}
+	if err := s.db.WithContext(ctx).Create(event).Error; err != nil {
+		return nil, fmt.Errorf("failed to create event: %w", err)
+	}
+	return event, nil
+}
+
+// GetUserEvents returns the user's events since the given time, oldest first
+func (s *EventService) GetUserEvents(ctx context.Context, userID uint, since time.Time) ([]database.Event, error) {
+	var events []database.Event
+	if err := s.db.WithContext(ctx).
+		Where("user_id = ? AND timestamp >= ?", userID, since).
+		Order("timestamp ASC").
+		Find(&events).Error; err != nil {
+		return nil, fmt.Errorf("failed to get user events: %w", err)
+	}
+	return events, nil
+}
@@ -0,0 +1,327 @@
+package services
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/format"
+	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+	"github.com/vladimiradmaev/diabetes-helper/internal/storage"
+)
+
+// exportChunkSize is how many records one export chunk writes
+const exportChunkSize = 500
+
+// exportSections are exported one after another, each in ID order
+var exportSections = []string{"analyses", "blood_sugar", "injections", "events"}
+
+// exportSectionModels are the tables the sections are read from
+var exportSectionModels = map[string]interface{}{
+	"analyses":    &database.FoodAnalysis{},
+	"blood_sugar": &database.BloodSugarRecord{},
+	"injections":  &database.Injection{},
+	"events":      &database.Event{},
+}
+
+// Tidepool exports are one JSON array
+const (
+	tidepoolArrayStart = "[\n"
+	tidepoolArrayEnd   = "\n]\n"
+)
+
+// jobExportSections returns the sections an export job of the kind writes
+func jobExportSections(kind string) []string {
+	if kind == JobKindExportTidepool {
+		return tidepoolSections
+	}
+	return exportSections
+}
+
+var exportHeader = []string{"Время", "Тип", "Значение", "Единицы", "Подробности"}
+
+// exportCursor is the resume position of an export: the current section and
+// the last record ID written from it. It is stored as "section:lastID".
+type exportCursor struct {
+	section int
+	lastID  uint
+}
+
+func parseExportCursor(value string) (exportCursor, error) {
+	if value == "" {
+		return exportCursor{}, nil
+	}
+	section, lastID, ok := strings.Cut(value, ":")
+	if !ok {
+		return exportCursor{}, fmt.Errorf("invalid export cursor: %s", value)
+	}
+	s, err := strconv.Atoi(section)
+	if err != nil {
+		return exportCursor{}, fmt.Errorf("invalid export cursor: %s", value)
+	}
+	id, err := strconv.ParseUint(lastID, 10, 64)
+	if err != nil {
+		return exportCursor{}, fmt.Errorf("invalid export cursor: %s", value)
+	}
+	return exportCursor{section: s, lastID: uint(id)}, nil
+}
+
+func (c exportCursor) String() string {
+	return fmt.Sprintf("%d:%d", c.section, c.lastID)
+}
+
+// runExportChunk appends the next chunk of the user's history to the job's
+// CSV or Tidepool JSON file. The file is first truncated to the offset saved
+// with the cursor, which drops a partial chunk left by an interrupted run.
+func (s *JobService) runExportChunk(ctx context.Context, job *database.Job) (bool, error) {
+	cursor, err := parseExportCursor(job.Cursor)
+	if err != nil {
+		return false, err
+	}
+	sections := jobExportSections(job.Kind)
+	if cursor.section >= len(sections) {
+		return true, nil
+	}
+	tidepool := job.Kind == JobKindExportTidepool
+
+	var user database.User
+	if err := s.db.WithContext(ctx).First(&user, job.UserID).Error; err != nil {
+		return false, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	if job.FilePath == "" {
+		total, err := s.countExportRecords(ctx, job.UserID, sections)
+		if err != nil {
+			return false, err
+		}
+		job.Total = total
+		job.FilePath = filepath.Join(s.dir, fmt.Sprintf("export_%d_%d.%s", job.UserID, job.ID, exportExtension(job.Kind)))
+	}
+
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return false, fmt.Errorf("failed to create export directory: %w", err)
+	}
+	file, err := os.OpenFile(job.FilePath, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return false, fmt.Errorf("failed to open export file: %w", err)
+	}
+	defer file.Close()
+
+	if err := file.Truncate(job.FileOffset); err != nil {
+		return false, fmt.Errorf("failed to truncate export file: %w", err)
+	}
+	if _, err := file.Seek(job.FileOffset, io.SeekStart); err != nil {
+		return false, fmt.Errorf("failed to seek export file: %w", err)
+	}
+
+	var read int
+	var lastID uint
+	if tidepool {
+		read, lastID, err = s.writeTidepoolChunk(ctx, file, &user, sections[cursor.section], cursor.lastID, job.FileOffset)
+	} else {
+		read, lastID, err = s.writeCSVChunk(ctx, file, &user, sections[cursor.section], cursor.lastID, job.FileOffset)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if read < exportChunkSize {
+		cursor = exportCursor{section: cursor.section + 1}
+	} else {
+		cursor.lastID = lastID
+	}
+	done := cursor.section >= len(sections)
+
+	if done && tidepool {
+		if _, err := file.WriteString(tidepoolArrayEnd); err != nil {
+			return false, fmt.Errorf("failed to write export file: %w", err)
+		}
+	}
+	offset, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return false, fmt.Errorf("failed to seek export file: %w", err)
+	}
+
+	// The finished file moves to blob storage before the final cursor is
+	// saved, so a crash in between only repeats the last chunk
+	if done {
+		data, err := os.ReadFile(job.FilePath)
+		if err != nil {
+			return false, fmt.Errorf("failed to read export file: %w", err)
+		}
+		key := storage.Key(strings.TrimSuffix(storage.ArtifactsPrefix, "/"), "exports", strconv.Itoa(int(job.UserID)), fmt.Sprintf("%d.%s", job.ID, exportExtension(job.Kind)))
+		if err := s.blob.Put(ctx, key, data); err != nil {
+			return false, fmt.Errorf("failed to store export: %w", err)
+		}
+		job.StorageKey = key
+	}
+
+	job.Cursor = cursor.String()
+	job.FileOffset = offset
+	job.Processed += read
+	if job.Processed > job.Total {
+		job.Total = job.Processed
+	}
+	if err := s.db.WithContext(ctx).Model(job).Updates(map[string]interface{}{
+		"cursor":      job.Cursor,
+		"file_offset": job.FileOffset,
+		"file_path":   job.FilePath,
+		"storage_key": job.StorageKey,
+		"processed":   job.Processed,
+		"total":       job.Total,
+	}).Error; err != nil {
+		return false, fmt.Errorf("failed to save job progress: %w", err)
+	}
+
+	if done {
+		if err := os.Remove(job.FilePath); err != nil {
+			logger.Warn("Failed to remove export file", "job_id", job.ID, "error", err)
+		}
+	}
+	return done, nil
+}
+
+// writeCSVChunk writes the next chunk of a section as CSV rows, after the
+// header when the file is empty. It returns the number of records written
+// and the ID of the last one.
+func (s *JobService) writeCSVChunk(ctx context.Context, file *os.File, user *database.User, section string, afterID uint, fileOffset int64) (int, uint, error) {
+	writer := csv.NewWriter(file)
+	if fileOffset == 0 {
+		// BOM so that spreadsheet apps detect UTF-8
+		if _, err := file.WriteString("\ufeff"); err != nil {
+			return 0, 0, fmt.Errorf("failed to write export file: %w", err)
+		}
+		if err := writer.Write(exportHeader); err != nil {
+			return 0, 0, fmt.Errorf("failed to write export file: %w", err)
+		}
+	}
+
+	rows, lastID, err := s.exportRows(ctx, user, section, afterID)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := writer.WriteAll(rows); err != nil {
+		return 0, 0, fmt.Errorf("failed to write export file: %w", err)
+	}
+	return len(rows), lastID, nil
+}
+
+// writeTidepoolChunk writes the next chunk of a section as Tidepool records,
+// opening the JSON array when the file is empty. It returns the number of
+// records written and the ID of the last one.
+func (s *JobService) writeTidepoolChunk(ctx context.Context, file *os.File, user *database.User, section string, afterID uint, fileOffset int64) (int, uint, error) {
+	if fileOffset == 0 {
+		if _, err := file.WriteString(tidepoolArrayStart); err != nil {
+			return 0, 0, fmt.Errorf("failed to write export file: %w", err)
+		}
+	}
+
+	records, lastID, read, err := s.tidepoolRecords(ctx, user, section, afterID)
+	if err != nil {
+		return 0, 0, err
+	}
+	first := fileOffset <= int64(len(tidepoolArrayStart))
+	if err := writeTidepoolRecords(file, records, first); err != nil {
+		return 0, 0, fmt.Errorf("failed to write export file: %w", err)
+	}
+	return read, lastID, nil
+}
+
+// exportExtension returns the file extension of an export job's output
+func exportExtension(kind string) string {
+	if kind == JobKindExportTidepool {
+		return "json"
+	}
+	return "csv"
+}
+
+func (s *JobService) countExportRecords(ctx context.Context, userID uint, sections []string) (int, error) {
+	total := 0
+	for _, section := range sections {
+		var count int64
+		if err := s.db.WithContext(ctx).Model(exportSectionModels[section]).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+			return 0, fmt.Errorf("failed to count export records: %w", err)
+		}
+		total += int(count)
+	}
+	return total, nil
+}
+
+// exportRows returns up to exportChunkSize CSV rows of a section after afterID
+// together with the ID of the last record
+func (s *JobService) exportRows(ctx context.Context, user *database.User, section string, afterID uint) ([][]string, uint, error) {
+	loc := UserLocation(user)
+	formatTime := func(t time.Time) string {
+		return t.In(loc).Format("2006-01-02 15:04")
+	}
+	query := s.db.WithContext(ctx).
+		Where("user_id = ? AND id > ?", user.ID, afterID).
+		Order("id").
+		Limit(exportChunkSize)
+
+	var rows [][]string
+	var lastID uint
+	switch section {
+	case "analyses":
+		var analyses []database.FoodAnalysis
+		if err := query.Find(&analyses).Error; err != nil {
+			return nil, 0, fmt.Errorf("failed to get food analyses: %w", err)
+		}
+		for _, a := range analyses {
+			details := fmt.Sprintf("%s, доза %.1f ед.", format.BreadUnits(a.BreadUnits, BreadUnitStep(user), format.Default), a.InsulinUnits.Units())
+			switch a.UsedProvider {
+			case ManualProvider:
+				details += ", введено вручную"
+			case HistoryProvider:
+				details += ", по истории похожих блюд"
+			}
+			rows = append(rows, []string{formatTime(a.CreatedAt), "Еда", fmt.Sprintf("%.1f", a.Carbs), "г углеводов", details})
+			lastID = a.ID
+		}
+	case "blood_sugar":
+		var records []database.BloodSugarRecord
+		if err := query.Find(&records).Error; err != nil {
+			return nil, 0, fmt.Errorf("failed to get blood sugar records: %w", err)
+		}
+		for _, r := range records {
+			value := fmt.Sprintf("%.1f", r.Value)
+			if user.GlucoseUnit == GlucoseUnitMgdl {
+				value = fmt.Sprintf("%.0f", MmolToMgdl(r.Value))
+			}
+			rows = append(rows, []string{formatTime(r.Timestamp), "Сахар", value, GlucoseUnitLabel(user.GlucoseUnit), ""})
+			lastID = r.ID
+		}
+	case "injections":
+		var injections []database.Injection
+		if err := query.Find(&injections).Error; err != nil {
+			return nil, 0, fmt.Errorf("failed to get injections: %w", err)
+		}
+		for _, i := range injections {
+			details := InjectionKindLabel(i.Kind)
+			if i.Site != "" {
+				details += ", " + InjectionSiteName(i.Site)
+			}
+			rows = append(rows, []string{formatTime(i.Timestamp), "Укол", fmt.Sprintf("%.1f", i.Units.Units()), "ед.", details})
+			lastID = i.ID
+		}
+	case "events":
+		var events []database.Event
+		if err := query.Find(&events).Error; err != nil {
+			return nil, 0, fmt.Errorf("failed to get events: %w", err)
+		}
+		for _, e := range events {
+			rows = append(rows, []string{formatTime(e.Timestamp), "Отметка", "", "", e.Emoji + " " + EventKindLabel(e.Kind)})
+			lastID = e.ID
+		}
+	default:
+		return nil, 0, fmt.Errorf("unknown export section: %s", section)
+	}
+	return rows, lastID, nil
+}
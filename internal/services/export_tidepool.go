@@ -0,0 +1,171 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+)
+
+// tidepoolDeviceID identifies the bot as the source of the exported data
+const tidepoolDeviceID = "diabetes-helper"
+
+// tidepoolSections are the sections of a Tidepool export; events have no
+// counterpart in the Tidepool data model
+var tidepoolSections = []string{"analyses", "blood_sugar", "injections"}
+
+// tidepoolDatum is one record in the Tidepool data model. Only the fields of
+// the types the export produces are set: smbg (fingerstick glucose), food,
+// bolus and insulin (pen injections other than boluses).
+type tidepoolDatum struct {
+	Type     string `json:"type"`
+	SubType  string `json:"subType,omitempty"`
+	DeviceID string `json:"deviceId"`
+	// Time is ISO 8601 with the user's UTC offset, DeviceTime the same local
+	// time without offset and TimezoneOffset the offset in minutes
+	Time           string `json:"time"`
+	DeviceTime     string `json:"deviceTime"`
+	TimezoneOffset int    `json:"timezoneOffset"`
+
+	// smbg
+	Units string   `json:"units,omitempty"`
+	Value *float64 `json:"value,omitempty"`
+
+	// food
+	Nutrition *tidepoolNutrition `json:"nutrition,omitempty"`
+
+	// bolus
+	Normal *float64 `json:"normal,omitempty"`
+
+	// insulin
+	Dose        *tidepoolDose        `json:"dose,omitempty"`
+	Formulation *tidepoolFormulation `json:"formulation,omitempty"`
+}
+
+type tidepoolNutrition struct {
+	Carbohydrate tidepoolCarbohydrate `json:"carbohydrate"`
+}
+
+type tidepoolCarbohydrate struct {
+	Net   float64 `json:"net"`
+	Units string  `json:"units"`
+}
+
+type tidepoolDose struct {
+	Total float64 `json:"total"`
+	Units string  `json:"units"`
+}
+
+type tidepoolFormulation struct {
+	Simple tidepoolSimpleFormulation `json:"simple"`
+}
+
+type tidepoolSimpleFormulation struct {
+	ActingType string `json:"actingType"`
+}
+
+// newTidepoolDatum creates a datum of the type at t in the user's local time
+func newTidepoolDatum(kind string, t time.Time, loc *time.Location) tidepoolDatum {
+	local := t.In(loc)
+	_, offset := local.Zone()
+	return tidepoolDatum{
+		Type:           kind,
+		DeviceID:       tidepoolDeviceID,
+		Time:           local.Format(time.RFC3339),
+		DeviceTime:     local.Format("2006-01-02T15:04:05"),
+		TimezoneOffset: offset / 60,
+	}
+}
+
+// tidepoolRecords returns up to exportChunkSize Tidepool records of a section
+// after afterID together with the ID of the last source row and the number
+// of rows read. Glucose is exported in mmol/L, as stored.
+func (s *JobService) tidepoolRecords(ctx context.Context, user *database.User, section string, afterID uint) ([]tidepoolDatum, uint, int, error) {
+	loc := UserLocation(user)
+	query := s.db.WithContext(ctx).
+		Where("user_id = ? AND id > ?", user.ID, afterID).
+		Order("id").
+		Limit(exportChunkSize)
+
+	var records []tidepoolDatum
+	var lastID uint
+	var read int
+	switch section {
+	case "analyses":
+		var analyses []database.FoodAnalysis
+		if err := query.Find(&analyses).Error; err != nil {
+			return nil, 0, 0, fmt.Errorf("failed to get food analyses: %w", err)
+		}
+		for _, a := range analyses {
+			datum := newTidepoolDatum("food", a.CreatedAt, loc)
+			datum.Nutrition = &tidepoolNutrition{Carbohydrate: tidepoolCarbohydrate{Net: a.Carbs, Units: "grams"}}
+			records = append(records, datum)
+			lastID = a.ID
+		}
+		read = len(analyses)
+	case "blood_sugar":
+		var bloodSugar []database.BloodSugarRecord
+		if err := query.Find(&bloodSugar).Error; err != nil {
+			return nil, 0, 0, fmt.Errorf("failed to get blood sugar records: %w", err)
+		}
+		for _, r := range bloodSugar {
+			value := r.Value
+			datum := newTidepoolDatum("smbg", r.Timestamp, loc)
+			datum.Units = "mmol/L"
+			datum.Value = &value
+			records = append(records, datum)
+			lastID = r.ID
+		}
+		read = len(bloodSugar)
+	case "injections":
+		var injections []database.Injection
+		if err := query.Find(&injections).Error; err != nil {
+			return nil, 0, 0, fmt.Errorf("failed to get injections: %w", err)
+		}
+		for _, i := range injections {
+			units := i.Units.Units()
+			var datum tidepoolDatum
+			if i.Kind == InjectionKindBasal {
+				// Tidepool's basal type describes pump rates; a pen dose of
+				// long-acting insulin is an insulin record
+				datum = newTidepoolDatum("insulin", i.Timestamp, loc)
+				datum.Dose = &tidepoolDose{Total: units, Units: "Units"}
+				datum.Formulation = &tidepoolFormulation{Simple: tidepoolSimpleFormulation{ActingType: "long"}}
+			} else {
+				datum = newTidepoolDatum("bolus", i.Timestamp, loc)
+				datum.SubType = "normal"
+				datum.Normal = &units
+			}
+			records = append(records, datum)
+			lastID = i.ID
+		}
+		read = len(injections)
+	default:
+		return nil, 0, 0, fmt.Errorf("unknown export section: %s", section)
+	}
+	return records, lastID, read, nil
+}
+
+// writeTidepoolRecords appends records to the JSON array of the export file.
+// first tells whether the array has no records yet.
+func writeTidepoolRecords(w io.Writer, records []tidepoolDatum, first bool) error {
+	for _, record := range records {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return fmt.Errorf("failed to encode export record: %w", err)
+		}
+		if !first {
+			if _, err := io.WriteString(w, ",\n"); err != nil {
+				return err
+			}
+		}
+		first = false
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -2,124 +2,501 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/vladimiradmaev/diabetes-helper/internal/confidence"
 	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/dosing"
+	"github.com/vladimiradmaev/diabetes-helper/internal/events"
+	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+	"github.com/vladimiradmaev/diabetes-helper/internal/metrics"
+	"github.com/vladimiradmaev/diabetes-helper/internal/storage"
 	"github.com/vladimiradmaev/diabetes-helper/internal/utils"
 	"gorm.io/gorm"
 )
 
 type FoodAnalysisService struct {
-	aiService *AIService
-	db        *gorm.DB
+	aiService      *AIService
+	db             *gorm.DB
+	blob           storage.Blob
+	correctionBand float64 // Glucose within this of target (mmol/L) is not corrected
+
+	dailyCarbsMu    sync.Mutex
+	dailyCarbsCache map[uint]dailyCarbsEntry
 }
 
-const (
-	highConfidenceThreshold   = 0.8
-	mediumConfidenceThreshold = 0.6
-	lowConfidenceThreshold    = 0.4
-)
+// dailyCarbsEntry caches a user's carb total for one local day
+type dailyCarbsEntry struct {
+	dayStart  time.Time
+	carbs     float64
+	expiresAt time.Time
+}
+
+const dailyCarbsCacheTTL = 5 * time.Minute
 
-func NewFoodAnalysisService(aiService *AIService, db *gorm.DB) *FoodAnalysisService {
+var analysesSaved = metrics.NewCounterVec("food_analyses_total", "Saved meal analyses by provider", "provider")
+
+var carbsClamped = metrics.NewCounter("ai_carbs_clamped_total", "AI analyses whose carbs exceeded the dish weight and were clamped to it")
+
+func NewFoodAnalysisService(aiService *AIService, db *gorm.DB, blob storage.Blob, correctionBand float64) *FoodAnalysisService {
 	return &FoodAnalysisService{
-		aiService: aiService,
-		db:        db,
+		aiService:       aiService,
+		db:              db,
+		blob:            blob,
+		correctionBand:  correctionBand,
+		dailyCarbsCache: make(map[uint]dailyCarbsEntry),
 	}
 }
 
-func (s *FoodAnalysisService) AnalyzeFood(ctx context.Context, userID uint, imageURL string, weight float64) (*database.FoodAnalysis, error) {
-	result, err := s.aiService.AnalyzeFoodImage(ctx, imageURL, weight)
+func (s *FoodAnalysisService) AnalyzeFood(ctx context.Context, userID uint, imageURL string, weight float64, ingredients string) (*database.FoodAnalysis, error) {
+	var user database.User
+	if err := s.db.WithContext(ctx).Select("carbs_step", "measurement_system").First(&user, userID).Error; err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	result, err := s.aiService.AnalyzeFoodImage(ctx, imageURL, weight, PromptOptionsFor(&user, ingredients))
 	if err != nil {
 		return nil, fmt.Errorf("failed to analyze food image: %w", err)
 	}
 
+	if result.MeterReading != nil {
+		mmol, ok := result.MeterReading.Mmol()
+		if !ok {
+			return nil, fmt.Errorf("implausible glucose meter reading %.1f %q", result.MeterReading.Value, result.MeterReading.Unit)
+		}
+		return nil, &MeterPhotoError{Mmol: mmol}
+	}
+
 	// Use the weight from the AI result if no weight was provided
 	if weight <= 0 && result.Weight > 0 {
 		weight = result.Weight
 	}
 
-	// Convert confidence string to float64
-	var confidence float64
-	switch strings.ToLower(result.Confidence) {
-	case "high":
-		confidence = 0.9
-	case "medium":
-		confidence = 0.6
-	case "low":
-		confidence = 0.3
-	default:
-		confidence = 0.5
+	if aiCarbs := result.Carbs; clampCarbsToWeight(result, weight) {
+		logger.Warn("AI returned more carbs than the dish weighs, carbs clamped",
+			"user_id", userID, "provider", result.Provider, "weight", weight, "ai_carbs", aiCarbs)
 	}
 
-	// Calculate bread units (ХЕ) - 1 ХЕ = 12g of carbs
-	breadUnits := result.Carbs / 12.0
+	// Sub-gram precision would only suggest accuracy the estimate doesn't
+	// have, so carbs are rounded as the user chose before the dose is computed
+	var rawCarbs float64
+	if rounded := roundCarbs(result.Carbs, user.CarbsStep); rounded != result.Carbs {
+		rawCarbs, result.Carbs = result.Carbs, rounded
+	}
 
-	// Get current time to find the appropriate insulin ratio
-	now := time.Now()
+	confidenceScore := confidence.Score(result.Confidence)
 
-	// Get user's insulin ratios
-	var ratios []database.InsulinRatio
-	if err := s.db.Where("user_id = ?", userID).Find(&ratios).Error; err != nil {
-		return nil, fmt.Errorf("failed to get insulin ratios: %w", err)
+	dose, err := s.calculateDose(ctx, userID, result.Carbs)
+	if err != nil {
+		return nil, err
 	}
 
-	// Find the appropriate ratio for current time
-	var insulinRatio float64
-	currentMinutes := now.Hour()*60 + now.Minute()
+	// Record the provider that actually served the request (it differs from
+	// Gemini when a fallback provider was used)
+	provider := result.Provider
+	if provider == "" {
+		provider = PrimaryProvider
+	}
 
-	for _, r := range ratios {
-		startMinutes := utils.TimeToMinutes(r.StartTime)
-		endMinutes := utils.TimeToMinutes(r.EndTime)
-
-		// Handle periods that cross midnight (e.g., 13:00-00:00)
-		if endMinutes < startMinutes {
-			// Period crosses midnight
-			if currentMinutes >= startMinutes || currentMinutes <= endMinutes {
-				insulinRatio = r.Ratio
-				break
-			}
-		} else {
-			// Normal period within same day
-			if currentMinutes >= startMinutes && currentMinutes <= endMinutes {
-				insulinRatio = r.Ratio
-				break
-			}
+	analysis := &database.FoodAnalysis{
+		UserID:            userID,
+		ImageURL:          imageURL,
+		Weight:            weight,
+		Carbs:             result.Carbs,
+		BreadUnits:        dose.BreadUnits,
+		Confidence:        confidenceScore,
+		AnalysisText:      result.AnalysisText,
+		UsedProvider:      provider,
+		Model:             result.Model,
+		InsulinRatio:      dose.CarbRatio,
+		InsulinUnits:      dose.Total,
+		ActiveInsulin:     dose.ActiveInsulin,
+		CorrectionUnits:   dose.CorrectionDose,
+		CorrectionGlucose: dose.Glucose,
+		CorrectionInBand:  dose.InTargetBand,
+		GlycemicIndex:     glycemicIndex(result.GlycemicIndex),
+		RawCarbs:          rawCarbs,
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(analysis).Error; err != nil {
+			return fmt.Errorf("failed to save analysis: %w", err)
+		}
+		items := foodItemsFromNames(userID, analysis.ID, result.FoodItems, result.ItemCarbs)
+		if len(items) == 0 {
+			return nil
 		}
+		if err := tx.Create(&items).Error; err != nil {
+			return fmt.Errorf("failed to save food items: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	analysesSaved.Inc(analysis.UsedProvider)
+	publishAnalysisSaved(ctx, analysis)
 
-	// Calculate insulin units (ХЕ * ratio)
-	insulinUnits := breadUnits * insulinRatio
+	return analysis, nil
+}
+
+// roundCarbs rounds carbs to the nearest multiple of step; a step of 0 keeps
+// them as they are
+func roundCarbs(carbs, step float64) float64 {
+	if step <= 0 {
+		return carbs
+	}
+	return math.Round(carbs/step) * step
+}
+
+// glycemicIndex keeps the AI's GI category only when it is one of the known ones
+func glycemicIndex(gi string) string {
+	switch gi = strings.ToLower(strings.TrimSpace(gi)); gi {
+	case dosing.GIHigh, dosing.GIMedium, dosing.GILow:
+		return gi
+	}
+	return ""
+}
+
+// MeterPhotoError is returned by AnalyzeFood when the photo shows a glucose
+// meter instead of food. No analysis is saved; the reading is offered to the
+// user as a blood sugar record.
+type MeterPhotoError struct {
+	Mmol float64 // The reading converted to mmol/L
+}
+
+func (e *MeterPhotoError) Error() string {
+	return fmt.Sprintf("photo shows a glucose meter reading %.1f mmol/L", e.Mmol)
+}
+
+// Mmol returns the reading in mmol/L and whether it is a plausible blood
+// sugar. A reading without a unit is taken in the unit it is plausible for.
+func (r MeterReading) Mmol() (float64, bool) {
+	unit := r.Unit
+	if unit != GlucoseUnitMmol && unit != GlucoseUnitMgdl {
+		unit = GlucoseUnitMmol
+		if !isPlausibleMmol(r.Value) {
+			unit = GlucoseUnitMgdl
+		}
+	}
+	if unit == GlucoseUnitMgdl {
+		return ToMmol(r.Value, unit), isPlausibleMgdl(r.Value)
+	}
+	return r.Value, isPlausibleMmol(r.Value)
+}
+
+// clampCarbsToWeight limits carbs to the weight of the dish: more carbs than
+// grams of food is impossible and means the model's answer is wrong. The
+// carbs are lowered to the weight, item carbs scaled to match, confidence
+// dropped to low and a note added to the breakdown. Returns whether the
+// result was clamped; carbs are kept as they are when the weight is unknown.
+func clampCarbsToWeight(result *FoodAnalysisResult, weight float64) bool {
+	if weight <= 0 || result.Carbs <= weight {
+		return false
+	}
+	carbsClamped.Inc()
+
+	for i := range result.ItemCarbs {
+		result.ItemCarbs[i] *= weight / result.Carbs
+	}
+	result.AnalysisText += fmt.Sprintf("\n\n⚠️ ИИ оценил углеводы в %.0f г при весе блюда %.0f г, что невозможно. "+
+		"Углеводы ограничены весом блюда, проверьте их перед уколом.", result.Carbs, weight)
+	result.Carbs = weight
+	result.Confidence = confidence.Low.String()
+	return true
+}
+
+// AIHealth reports whether the AI provider behind the analyses is currently healthy
+func (s *FoodAnalysisService) AIHealth() AIHealth {
+	if s.aiService == nil {
+		return AIHealth{}
+	}
+	return s.aiService.Health()
+}
+
+// ManualProvider marks analyses created from carbs entered by the user
+const ManualProvider = "manual"
+
+// CalculateManual computes bread units and the dose for carbs the user already
+// knows (e.g. from a package label). When save is true the result is stored as
+// an analysis with UsedProvider "manual"; otherwise it is returned unsaved.
+func (s *FoodAnalysisService) CalculateManual(ctx context.Context, userID uint, carbs float64, save bool) (*database.FoodAnalysis, error) {
+	dose, err := s.calculateDose(ctx, userID, carbs)
+	if err != nil {
+		return nil, err
+	}
 
 	analysis := &database.FoodAnalysis{
-		UserID:       userID,
-		ImageURL:     imageURL,
-		Weight:       weight,
-		Carbs:        result.Carbs,
-		BreadUnits:   breadUnits,
-		Confidence:   confidence,
-		AnalysisText: result.AnalysisText,
-		UsedProvider: "gemini",
-		InsulinRatio: insulinRatio,
-		InsulinUnits: insulinUnits,
+		UserID:            userID,
+		Carbs:             carbs,
+		BreadUnits:        dose.BreadUnits,
+		Confidence:        1,
+		AnalysisText:      "Углеводы введены вручную",
+		UsedProvider:      ManualProvider,
+		InsulinRatio:      dose.CarbRatio,
+		InsulinUnits:      dose.Total,
+		ActiveInsulin:     dose.ActiveInsulin,
+		CorrectionUnits:   dose.CorrectionDose,
+		CorrectionGlucose: dose.Glucose,
+		CorrectionInBand:  dose.InTargetBand,
+	}
+	if !save {
+		return analysis, nil
 	}
 
 	if err := s.db.WithContext(ctx).Create(analysis).Error; err != nil {
-		return nil, fmt.Errorf("failed to save analysis: %w", err)
+		return nil, fmt.Errorf("failed to save manual analysis: %w", err)
 	}
-
+	analysesSaved.Inc(analysis.UsedProvider)
+	publishAnalysisSaved(ctx, analysis)
 	return analysis, nil
 }
 
-func (s *FoodAnalysisService) GetUserAnalyses(ctx context.Context, userID uint) ([]database.FoodAnalysis, error) {
+// CurrentGlucoseWindow is how old a glucose record may be to count as the
+// current level, e.g. for the correction part of a dose
+const CurrentGlucoseWindow = 30 * time.Minute
+
+// mealDose is a dose recommendation with the glucose its correction is based
+// on, in mmol/L; Glucose is 0 when there is no correction. InTargetBand is
+// set when the glucose was within the no-correction band of target.
+type mealDose struct {
+	dosing.Result
+	Glucose      float64
+	InTargetBand bool
+}
+
+// calculateDose computes the dose for carbs using the ratio period that
+// contains the current time in the user's timezone. High glucose logged in
+// the last CurrentGlucoseWindow is corrected once the user has set their
+// correction factor and target, unless it is within the correction band.
+func (s *FoodAnalysisService) calculateDose(ctx context.Context, userID uint, carbs float64) (mealDose, error) {
+	var user database.User
+	if err := s.db.WithContext(ctx).First(&user, userID).Error; err != nil {
+		return mealDose{}, fmt.Errorf("failed to get user: %w", err)
+	}
+	now := time.Now().In(UserLocation(&user))
+
+	var ratios []database.InsulinRatio
+	if err := s.db.WithContext(ctx).Where("user_id = ?", userID).Find(&ratios).Error; err != nil {
+		return mealDose{}, fmt.Errorf("failed to get insulin ratios: %w", err)
+	}
+
+	// Active insulin is only taken off once the user has set how long their
+	// insulin acts; the default duration is too rough a guess to lower a dose
+	var active dosing.DeciUnits
+	if user.ActiveInsulinTime > 0 {
+		iob, err := insulinOnBoard(ctx, s.db, &user, now)
+		if err != nil {
+			return mealDose{}, err
+		}
+		active = iob
+	}
+
+	var glucose float64
+	var inBand bool
+	var correction dosing.DeciUnits
+	if user.CorrectionFactor > 0 && user.TargetBloodSugar > 0 {
+		var records []database.BloodSugarRecord
+		if err := s.db.WithContext(ctx).
+			Where("user_id = ? AND timestamp >= ?", userID, now.Add(-CurrentGlucoseWindow)).
+			Order("timestamp DESC").
+			Limit(1).
+			Find(&records).Error; err != nil {
+			return mealDose{}, fmt.Errorf("failed to get current blood sugar: %w", err)
+		}
+		if len(records) > 0 {
+			glucose = records[0].Value
+			correction = dosing.CorrectionDose(glucose, user.TargetBloodSugar, user.CorrectionFactor, s.correctionBand)
+			inBand = dosing.InTargetBand(glucose, user.TargetBloodSugar, s.correctionBand)
+		}
+	}
+
+	return mealDose{
+		Result: dosing.CalculateDose(dosing.Input{
+			Carbs:         carbs,
+			CarbRatio:     ratioAt(ratios, now),
+			ActiveInsulin: active,
+			Correction:    correction,
+		}),
+		Glucose:      glucose,
+		InTargetBand: inBand,
+	}, nil
+}
+
+// ratioAt returns the ratio of the period containing t's wall-clock time, or 0
+func ratioAt(ratios []database.InsulinRatio, t time.Time) float64 {
+	currentMinutes := t.Hour()*60 + t.Minute()
+
+	for _, r := range ratios {
+		if utils.PeriodContains(r.StartTime, r.EndTime, currentMinutes) {
+			return r.Ratio
+		}
+	}
+	return 0
+}
+
+// GetDailyCarbs returns the total carbs the user logged during the current local day
+func (s *FoodAnalysisService) GetDailyCarbs(ctx context.Context, userID uint, loc *time.Location) (float64, error) {
+	now := time.Now()
+	dayStart, dayEnd := utils.DayBounds(now, loc)
+
+	s.dailyCarbsMu.Lock()
+	entry, ok := s.dailyCarbsCache[userID]
+	s.dailyCarbsMu.Unlock()
+	if ok && entry.dayStart.Equal(dayStart) && now.Before(entry.expiresAt) {
+		return entry.carbs, nil
+	}
+
+	var total float64
+	if err := s.db.WithContext(ctx).
+		Model(&database.FoodAnalysis{}).
+		Where("user_id = ? AND created_at >= ? AND created_at < ?", userID, dayStart, dayEnd).
+		Select("COALESCE(SUM(carbs), 0)").
+		Scan(&total).Error; err != nil {
+		return 0, fmt.Errorf("failed to sum daily carbs: %w", err)
+	}
+
+	s.dailyCarbsMu.Lock()
+	s.dailyCarbsCache[userID] = dailyCarbsEntry{
+		dayStart:  dayStart,
+		carbs:     total,
+		expiresAt: now.Add(dailyCarbsCacheTTL),
+	}
+	s.dailyCarbsMu.Unlock()
+
+	return total, nil
+}
+
+// HandleEvent drops the cached daily carbs of a user whose analyses changed.
+// It is subscribed to events at startup.
+func (s *FoodAnalysisService) HandleEvent(ctx context.Context, event events.Event) {
+	if e, ok := event.(events.AnalysisSaved); ok {
+		s.dailyCarbsMu.Lock()
+		defer s.dailyCarbsMu.Unlock()
+		delete(s.dailyCarbsCache, e.UserID)
+	}
+}
+
+// publishAnalysisSaved tells subscribers about a saved or corrected analysis
+func publishAnalysisSaved(ctx context.Context, analysis *database.FoodAnalysis) {
+	events.Publish(ctx, events.AnalysisSaved{
+		UserID:       analysis.UserID,
+		AnalysisID:   analysis.ID,
+		Carbs:        analysis.Carbs,
+		BreadUnits:   analysis.BreadUnits,
+		InsulinUnits: analysis.InsulinUnits,
+		Provider:     analysis.UsedProvider,
+		Time:         analysis.CreatedAt,
+	})
+}
+
+// GetUserAnalyses returns up to limit of the user's analyses after skipping
+// offset, newest first, and how many analyses the user has in total
+func (s *FoodAnalysisService) GetUserAnalyses(ctx context.Context, userID uint, limit, offset int) ([]database.FoodAnalysis, int, error) {
+	var total int64
+	if err := s.db.WithContext(ctx).Model(&database.FoodAnalysis{}).Where("user_id = ?", userID).Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count user analyses: %w", err)
+	}
+
+	var analyses []database.FoodAnalysis
+	if err := s.db.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Limit(limit).
+		Offset(offset).
+		Find(&analyses).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to get user analyses: %w", err)
+	}
+	return analyses, int(total), nil
+}
+
+// GetAnalysis returns one of the user's analyses
+func (s *FoodAnalysisService) GetAnalysis(ctx context.Context, userID, analysisID uint) (*database.FoodAnalysis, error) {
+	var analysis database.FoodAnalysis
+	err := s.db.WithContext(ctx).Where("id = ? AND user_id = ?", analysisID, userID).First(&analysis).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("analysis %d not found", analysisID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get analysis: %w", err)
+	}
+	return &analysis, nil
+}
+
+// GetLastAnalysis returns the user's most recent analysis, or nil if there is none
+func (s *FoodAnalysisService) GetLastAnalysis(ctx context.Context, userID uint) (*database.FoodAnalysis, error) {
 	var analyses []database.FoodAnalysis
-	if err := s.db.WithContext(ctx).Where("user_id = ?", userID).Order("created_at DESC").Find(&analyses).Error; err != nil {
-		return nil, fmt.Errorf("failed to get user analyses: %w", err)
+	if err := s.db.WithContext(ctx).Where("user_id = ?", userID).Order("created_at DESC").Limit(1).Find(&analyses).Error; err != nil {
+		return nil, fmt.Errorf("failed to get last analysis: %w", err)
+	}
+	if len(analyses) == 0 {
+		return nil, nil
+	}
+	return &analyses[0], nil
+}
+
+// SearchAnalyses returns the user's analyses containing a food whose normalized
+// name matches the query, newest first
+func (s *FoodAnalysisService) SearchAnalyses(ctx context.Context, userID uint, query string, limit int) ([]database.FoodAnalysis, error) {
+	normalized := NormalizeFoodName(query)
+	if normalized == "" {
+		return nil, nil
+	}
+
+	var analyses []database.FoodAnalysis
+	if err := s.db.WithContext(ctx).
+		Where("user_id = ? AND id IN (?)", userID,
+			s.db.Model(&database.FoodItem{}).
+				Select("food_analysis_id").
+				Where("user_id = ? AND normalized_name LIKE ?", userID, "%"+normalized+"%")).
+		Order("created_at DESC").
+		Limit(limit).
+		Find(&analyses).Error; err != nil {
+		return nil, fmt.Errorf("failed to search analyses: %w", err)
 	}
 	return analyses, nil
 }
 
+// foodItemsFromNames builds food item rows for an analysis, skipping names
+// that normalize to nothing. Duplicates within the same analysis are merged,
+// adding up their carbs. carbs holds the carbs of each name; it is ignored
+// unless it matches names in length.
+func foodItemsFromNames(userID, analysisID uint, names []string, carbs []float64) []database.FoodItem {
+	if len(carbs) != len(names) {
+		carbs = nil
+	}
+	seen := make(map[string]int, len(names))
+	items := make([]database.FoodItem, 0, len(names))
+	for i, name := range names {
+		normalized := NormalizeFoodName(name)
+		if normalized == "" {
+			continue
+		}
+		itemCarbs := 0.0
+		if carbs != nil && carbs[i] > 0 {
+			itemCarbs = carbs[i]
+		}
+		if idx, ok := seen[normalized]; ok {
+			items[idx].Carbs += itemCarbs
+			continue
+		}
+		seen[normalized] = len(items)
+		items = append(items, database.FoodItem{
+			UserID:         userID,
+			FoodAnalysisID: analysisID,
+			Name:           strings.TrimSpace(name),
+			NormalizedName: normalized,
+			Carbs:          itemCarbs,
+		})
+	}
+	return items
+}
+
 func (s *FoodAnalysisService) SaveCorrection(ctx context.Context, userID uint, originalAnalysis *database.FoodAnalysis, correctedCarbs, correctedWeight float64) error {
 	correction := &database.FoodAnalysisCorrection{
 		UserID:          userID,
@@ -130,6 +507,7 @@ func (s *FoodAnalysisService) SaveCorrection(ctx context.Context, userID uint, o
 		ImageURL:        originalAnalysis.ImageURL,
 		AnalysisText:    originalAnalysis.AnalysisText,
 		UsedProvider:    originalAnalysis.UsedProvider,
+		Model:           originalAnalysis.Model,
 		Confidence:      originalAnalysis.Confidence,
 	}
 	if err := s.db.Create(correction).Error; err != nil {
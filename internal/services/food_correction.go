@@ -0,0 +1,148 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/dosing"
+	"gorm.io/gorm"
+)
+
+// AnalysisCorrection is the user's correction of an analysis: either new
+// carbs of individual food items, keyed by item ID, or a new total
+type AnalysisCorrection struct {
+	Items map[uint]float64
+	Carbs float64 // Used when Items is empty
+	// Corrected weight of the portion in grams; 0 keeps the analysed weight
+	Weight float64
+}
+
+// GetAnalysisWithItems returns one of the user's analyses with its food items
+func (s *FoodAnalysisService) GetAnalysisWithItems(ctx context.Context, userID, analysisID uint) (*database.FoodAnalysis, []database.FoodItem, error) {
+	var analysis database.FoodAnalysis
+	err := s.db.WithContext(ctx).Where("id = ? AND user_id = ?", analysisID, userID).First(&analysis).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, fmt.Errorf("analysis %d not found", analysisID)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get analysis: %w", err)
+	}
+
+	var items []database.FoodItem
+	if err := s.db.WithContext(ctx).Where("food_analysis_id = ?", analysisID).Order("id").Find(&items).Error; err != nil {
+		return nil, nil, fmt.Errorf("failed to get food items: %w", err)
+	}
+	return &analysis, items, nil
+}
+
+// CorrectAnalysis applies the user's correction to an analysis. Item changes
+// shift the total by the difference, since the item carbs the AI returned
+// don't always add up to its total exactly. Bread units and the dose are
+// recomputed with the ratio the analysis was made with. The correction is
+// recorded with one row per changed item.
+func (s *FoodAnalysisService) CorrectAnalysis(ctx context.Context, userID, analysisID uint, correction AnalysisCorrection) (*database.FoodAnalysis, error) {
+	var analysis database.FoodAnalysis
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id = ? AND user_id = ?", analysisID, userID).First(&analysis).Error; err != nil {
+			return fmt.Errorf("failed to get analysis: %w", err)
+		}
+
+		var items []database.FoodItem
+		if len(correction.Items) > 0 {
+			if err := tx.Where("food_analysis_id = ?", analysisID).Find(&items).Error; err != nil {
+				return fmt.Errorf("failed to get food items: %w", err)
+			}
+		}
+
+		carbs := correction.Carbs
+		var changes []database.FoodItemCorrection
+		if len(correction.Items) > 0 {
+			carbs = analysis.Carbs
+			for _, item := range items {
+				corrected, ok := correction.Items[item.ID]
+				if !ok || corrected == item.Carbs {
+					continue
+				}
+				carbs += corrected - item.Carbs
+				changes = append(changes, database.FoodItemCorrection{
+					FoodItemID:     item.ID,
+					OriginalCarbs:  item.Carbs,
+					CorrectedCarbs: corrected,
+				})
+			}
+			if len(changes) == 0 {
+				return fmt.Errorf("correction changes no items of analysis %d", analysisID)
+			}
+		}
+		if carbs < 0 {
+			carbs = 0
+		}
+
+		weight := analysis.Weight
+		if correction.Weight > 0 {
+			weight = correction.Weight
+		}
+
+		dose := dosing.CalculateDose(dosing.Input{
+			Carbs:         carbs,
+			CarbRatio:     analysis.InsulinRatio,
+			ActiveInsulin: analysis.ActiveInsulin,
+			Correction:    analysis.CorrectionUnits,
+		})
+		record := &database.FoodAnalysisCorrection{
+			UserID:          userID,
+			FoodAnalysisID:  &analysis.ID,
+			OriginalCarbs:   analysis.Carbs,
+			CorrectedCarbs:  carbs,
+			BreadUnits:      dose.BreadUnits,
+			OriginalWeight:  analysis.Weight,
+			CorrectedWeight: weight,
+			ImageURL:        analysis.ImageURL,
+			AnalysisText:    analysis.AnalysisText,
+			UsedProvider:    analysis.UsedProvider,
+			Model:           analysis.Model,
+			Confidence:      analysis.Confidence,
+		}
+		if err := tx.Create(record).Error; err != nil {
+			return fmt.Errorf("failed to save correction: %w", err)
+		}
+
+		for i := range changes {
+			changes[i].CorrectionID = record.ID
+			if err := tx.Model(&database.FoodItem{}).Where("id = ?", changes[i].FoodItemID).
+				Update("carbs", changes[i].CorrectedCarbs).Error; err != nil {
+				return fmt.Errorf("failed to update food item: %w", err)
+			}
+		}
+		if len(changes) > 0 {
+			if err := tx.Create(&changes).Error; err != nil {
+				return fmt.Errorf("failed to save item corrections: %w", err)
+			}
+		}
+
+		analysis.Carbs = carbs
+		analysis.Weight = weight
+		analysis.BreadUnits = dose.BreadUnits
+		analysis.InsulinUnits = dose.Total
+		analysis.ActiveInsulin = dose.ActiveInsulin
+		analysis.RawCarbs = 0 // The carbs are the user's now, not a rounded estimate
+		if err := tx.Model(&analysis).Updates(map[string]interface{}{
+			"carbs":          analysis.Carbs,
+			"weight":         analysis.Weight,
+			"bread_units":    analysis.BreadUnits,
+			"insulin_units":  analysis.InsulinUnits,
+			"active_insulin": analysis.ActiveInsulin,
+			"raw_carbs":      analysis.RawCarbs,
+		}).Error; err != nil {
+			return fmt.Errorf("failed to update analysis: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	publishAnalysisSaved(ctx, &analysis)
+	return &analysis, nil
+}
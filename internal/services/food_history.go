@@ -0,0 +1,135 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"gorm.io/gorm"
+)
+
+// HistoryProvider marks analyses whose carbs were taken from the user's
+// earlier meals with the same food while the AI was unavailable
+const HistoryProvider = "history"
+
+// historyMatchLimit is how many recent similar meals an estimate is based on
+const historyMatchLimit = 10
+
+// HistoryEstimate is the typical carb amount of a dish in the user's history
+type HistoryEstimate struct {
+	Dish    string  // Normalized food name that matched
+	Carbs   float64 // Median carbs of the matching meals, scaled to the weight when known
+	Matches int     // Number of meals the estimate is based on
+}
+
+// EstimateFromHistory looks for the user's recent meals containing the dish
+// and returns their typical carbs, or nil when nothing matches. Meals whose
+// food name equals the dish are preferred over ones that only contain it.
+// When weight is given and the matching meals have weights too, the carbs
+// per gram are scaled to it.
+func (s *FoodAnalysisService) EstimateFromHistory(ctx context.Context, userID uint, dish string, weight float64) (*HistoryEstimate, error) {
+	normalized := NormalizeFoodName(dish)
+	if normalized == "" {
+		return nil, nil
+	}
+
+	matches, err := s.similarAnalyses(ctx, userID, "normalized_name = ?", normalized)
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		matches, err = s.similarAnalyses(ctx, userID, "normalized_name LIKE ?", "%"+normalized+"%")
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(matches) == 0 {
+		return nil, nil
+	}
+
+	var carbs, carbsPerGram []float64
+	for _, a := range matches {
+		carbs = append(carbs, a.Carbs)
+		if a.Weight > 0 {
+			carbsPerGram = append(carbsPerGram, a.Carbs/a.Weight)
+		}
+	}
+
+	estimate := &HistoryEstimate{Dish: normalized, Carbs: median(carbs), Matches: len(matches)}
+	if weight > 0 && len(carbsPerGram) > 0 {
+		estimate.Carbs = median(carbsPerGram) * weight
+	}
+	return estimate, nil
+}
+
+// similarAnalyses returns the user's most recent analyses with carbs that
+// have a food item matching the condition
+func (s *FoodAnalysisService) similarAnalyses(ctx context.Context, userID uint, condition string, value string) ([]database.FoodAnalysis, error) {
+	var analyses []database.FoodAnalysis
+	if err := s.db.WithContext(ctx).
+		Where("user_id = ? AND carbs > 0 AND id IN (?)", userID,
+			s.db.Model(&database.FoodItem{}).
+				Select("food_analysis_id").
+				Where("user_id = ?", userID).
+				Where(condition, value)).
+		Order("created_at DESC").
+		Limit(historyMatchLimit).
+		Find(&analyses).Error; err != nil {
+		return nil, fmt.Errorf("failed to find similar meals: %w", err)
+	}
+	return analyses, nil
+}
+
+// SaveFromHistory logs a meal with the carbs of a history estimate and the
+// dose for the current ratio
+func (s *FoodAnalysisService) SaveFromHistory(ctx context.Context, userID uint, estimate HistoryEstimate, weight float64) (*database.FoodAnalysis, error) {
+	dose, err := s.calculateDose(ctx, userID, estimate.Carbs)
+	if err != nil {
+		return nil, err
+	}
+
+	analysis := &database.FoodAnalysis{
+		UserID:            userID,
+		Weight:            weight,
+		Carbs:             estimate.Carbs,
+		BreadUnits:        dose.BreadUnits,
+		Confidence:        0,
+		AnalysisText:      fmt.Sprintf("ИИ был недоступен: углеводы взяты из истории («%s», приемов пищи: %d)", estimate.Dish, estimate.Matches),
+		UsedProvider:      HistoryProvider,
+		InsulinRatio:      dose.CarbRatio,
+		InsulinUnits:      dose.Total,
+		ActiveInsulin:     dose.ActiveInsulin,
+		CorrectionUnits:   dose.CorrectionDose,
+		CorrectionGlucose: dose.Glucose,
+		CorrectionInBand:  dose.InTargetBand,
+	}
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(analysis).Error; err != nil {
+			return fmt.Errorf("failed to save analysis from history: %w", err)
+		}
+		items := foodItemsFromNames(userID, analysis.ID, []string{estimate.Dish}, []float64{estimate.Carbs})
+		if err := tx.Create(&items).Error; err != nil {
+			return fmt.Errorf("failed to save food items: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	analysesSaved.Inc(analysis.UsedProvider)
+	publishAnalysisSaved(ctx, analysis)
+	return analysis, nil
+}
+
+// median returns the middle value of values, which must not be empty
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
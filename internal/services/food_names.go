@@ -0,0 +1,93 @@
+package services
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var (
+	// parenthesizedRe matches notes like "(150 г)" that the AI appends to names
+	parenthesizedRe = regexp.MustCompile(`\([^)]*\)`)
+	// nonWordRe matches everything except letters, digits and spaces
+	nonWordRe = regexp.MustCompile(`[^\p{L}\p{N} ]+`)
+)
+
+// foodStopWords are cooking descriptors that don't change what the food is
+var foodStopWords = map[string]bool{
+	"отварной": true, "отварная": true, "отварное": true, "отварные": true,
+	"вареный": true, "вареная": true, "вареное": true, "вареные": true,
+	"жареный": true, "жареная": true, "жареное": true, "жареные": true,
+	"запеченный": true, "запеченная": true, "запеченное": true, "запеченные": true,
+	"тушеный": true, "тушеная": true, "тушеное": true, "тушеные": true,
+	"свежий": true, "свежая": true, "свежее": true, "свежие": true,
+	"порция": true, "кусок": true, "ломтик": true,
+}
+
+// foodPhraseSynonyms maps whole cleaned names to their canonical form
+var foodPhraseSynonyms = map[string]string{
+	"гречневая каша":     "гречка",
+	"каша гречневая":     "гречка",
+	"гречневая крупа":    "гречка",
+	"рисовая каша":       "рис",
+	"каша рисовая":       "рис",
+	"овсяная каша":       "овсянка",
+	"каша овсяная":       "овсянка",
+	"картофельное пюре":  "пюре",
+	"пюре картофельное":  "пюре",
+	"картофель фри":      "картофель фри",
+	"куриная грудка":     "курица",
+	"куриное филе":       "курица",
+	"филе курицы":        "курица",
+	"белый хлеб":         "хлеб",
+	"хлеб белый":         "хлеб",
+	"макаронные изделия": "макароны",
+}
+
+// foodWordSynonyms maps single words (plurals, colloquial names) to their canonical form
+var foodWordSynonyms = map[string]string{
+	"гречневая": "гречка",
+	"картошка":  "картофель",
+	"паста":     "макароны",
+	"яблоки":    "яблоко",
+	"бананы":    "банан",
+	"огурцы":    "огурец",
+	"помидоры":  "помидор",
+	"томат":     "помидор",
+	"томаты":    "помидор",
+	"апельсины": "апельсин",
+	"котлеты":   "котлета",
+	"пельмени":  "пельмени",
+	"блины":     "блин",
+	"сырники":   "сырник",
+}
+
+// NormalizeFoodName reduces a free-form food name returned by the AI to a
+// canonical key, so "Гречневая каша" and "гречка отварная" compare equal.
+// The original name should still be shown to users; the normalized one is
+// for search and matching only.
+func NormalizeFoodName(name string) string {
+	name = strings.ToLower(name)
+	name = strings.ReplaceAll(name, "ё", "е")
+	name = parenthesizedRe.ReplaceAllString(name, " ")
+	name = nonWordRe.ReplaceAllString(name, " ")
+
+	words := make([]string, 0, 4)
+	for _, w := range strings.Fields(name) {
+		// Drop stop words and amounts such as "150г"
+		if !foodStopWords[w] && !unicode.IsDigit([]rune(w)[0]) {
+			words = append(words, w)
+		}
+	}
+	name = strings.Join(words, " ")
+
+	if canonical, ok := foodPhraseSynonyms[name]; ok {
+		return canonical
+	}
+	for i, w := range words {
+		if canonical, ok := foodWordSynonyms[w]; ok {
+			words[i] = canonical
+		}
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,86 @@
+package services
+
+import "strings"
+
+// FoodReference is the typical carb content of a common food
+type FoodReference struct {
+	Name         string
+	CarbsPer100  float64 // Grams of carbs per 100 g
+	Portion      string  // Typical portion, e.g. "1 средний"
+	PortionGrams float64
+}
+
+// PortionCarbs returns the carbs of the typical portion
+func (r FoodReference) PortionCarbs() float64 {
+	return r.CarbsPer100 * r.PortionGrams / 100
+}
+
+// foodReferences are typical values from nutrition tables for ready-to-eat
+// foods; actual products vary, which the inline lookup says
+var foodReferences = []FoodReference{
+	{"Банан", 21, "1 средний", 120},
+	{"Яблоко", 10, "1 среднее", 150},
+	{"Груша", 10, "1 средняя", 160},
+	{"Апельсин", 8, "1 средний", 180},
+	{"Мандарин", 8, "1 средний", 80},
+	{"Виноград", 16, "горсть", 100},
+	{"Арбуз", 8, "ломоть", 300},
+	{"Дыня", 8, "ломоть", 200},
+	{"Огурец", 2.5, "1 средний", 120},
+	{"Помидор", 3.7, "1 средний", 120},
+	{"Хлеб белый", 49, "ломтик", 25},
+	{"Хлеб ржаной", 40, "ломтик", 30},
+	{"Батон", 50, "ломтик", 25},
+	{"Гречка отварная", 20, "порция", 200},
+	{"Рис отварной", 28, "порция", 200},
+	{"Овсянка на воде", 15, "тарелка", 250},
+	{"Макароны отварные", 25, "порция", 200},
+	{"Картофель отварной", 16, "порция", 200},
+	{"Картофельное пюре", 14, "порция", 200},
+	{"Картофель фри", 35, "средняя порция", 120},
+	{"Пельмени", 29, "порция", 250},
+	{"Вареники с картофелем", 24, "порция", 250},
+	{"Блины", 26, "1 блин", 50},
+	{"Сырники", 20, "1 сырник", 60},
+	{"Котлета", 8, "1 котлета", 100},
+	{"Пицца", 30, "кусок", 120},
+	{"Борщ", 6, "тарелка", 300},
+	{"Молоко", 4.7, "стакан", 250},
+	{"Кефир", 4, "стакан", 250},
+	{"Йогурт фруктовый", 14, "баночка", 125},
+	{"Творог", 3, "порция", 150},
+	{"Сок апельсиновый", 10, "стакан", 250},
+	{"Кола", 10.6, "банка", 330},
+	{"Квас", 5, "стакан", 250},
+	{"Сахар", 100, "чайная ложка", 5},
+	{"Мед", 80, "чайная ложка", 10},
+	{"Шоколад молочный", 55, "плитка", 90},
+	{"Мороженое пломбир", 20, "стаканчик", 80},
+	{"Печенье", 70, "1 штука", 12},
+}
+
+// LookupFoodReferences returns up to limit reference foods matching the
+// query, compared by normalized name: exact matches first, then names
+// starting with the query, then names containing it or contained in it
+func LookupFoodReferences(query string, limit int) []FoodReference {
+	normalized := NormalizeFoodName(query)
+	if normalized == "" {
+		return nil
+	}
+
+	var exact, prefix, partial []FoodReference
+	for _, ref := range foodReferences {
+		name := NormalizeFoodName(ref.Name)
+		switch {
+		case name == normalized:
+			exact = append(exact, ref)
+		case strings.HasPrefix(name, normalized):
+			prefix = append(prefix, ref)
+		case strings.Contains(name, normalized) || strings.Contains(normalized, name):
+			partial = append(partial, ref)
+		}
+	}
+
+	matches := append(append(exact, prefix...), partial...)
+	return matches[:min(len(matches), limit)]
+}
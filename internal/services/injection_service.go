@@ -0,0 +1,217 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/dosing"
+	"github.com/vladimiradmaev/diabetes-helper/internal/events"
+	"gorm.io/gorm"
+)
+
+// Injection kinds
+const (
+	InjectionKindBolus      = "bolus"
+	InjectionKindBasal      = "basal"
+	InjectionKindCorrection = "correction"
+)
+
+var injectionKindLabels = map[string]string{
+	InjectionKindBolus:      "болюс",
+	InjectionKindBasal:      "базальный",
+	InjectionKindCorrection: "коррекция",
+}
+
+// InjectionKindLabel returns the user-facing name of an injection kind
+func InjectionKindLabel(kind string) string {
+	if label, ok := injectionKindLabels[kind]; ok {
+		return label
+	}
+	return kind
+}
+
+// DefaultSiteRepeatLimit is how many injections in a row into the same site
+// trigger a rotation warning
+const DefaultSiteRepeatLimit = 3
+
+// InjectionSite is a body area insulin can be injected into
+type InjectionSite struct {
+	ID   string
+	Name string
+}
+
+// InjectionSites are the sites offered when logging an injection
+var InjectionSites = []InjectionSite{
+	{ID: "abdomen_left", Name: "Живот слева"},
+	{ID: "abdomen_right", Name: "Живот справа"},
+	{ID: "thigh_left", Name: "Бедро слева"},
+	{ID: "thigh_right", Name: "Бедро справа"},
+	{ID: "arm_left", Name: "Плечо слева"},
+	{ID: "arm_right", Name: "Плечо справа"},
+	{ID: "buttock_left", Name: "Ягодица слева"},
+	{ID: "buttock_right", Name: "Ягодица справа"},
+}
+
+// InjectionSiteName returns the user-facing name of a site
+func InjectionSiteName(id string) string {
+	for _, site := range InjectionSites {
+		if site.ID == id {
+			return site.Name
+		}
+	}
+	return "не указано"
+}
+
+// IsInjectionSite reports whether id is a known site
+func IsInjectionSite(id string) bool {
+	for _, site := range InjectionSites {
+		if site.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// SiteCount is the number of injections into a site
+type SiteCount struct {
+	Site  string
+	Count int
+}
+
+type InjectionService struct {
+	db              *gorm.DB
+	siteRepeatLimit int
+}
+
+// NewInjectionService creates an injection service that warns after
+// siteRepeatLimit injections in a row into the same site
+func NewInjectionService(db *gorm.DB, siteRepeatLimit int) *InjectionService {
+	if siteRepeatLimit < 2 {
+		siteRepeatLimit = DefaultSiteRepeatLimit
+	}
+	return &InjectionService{db: db, siteRepeatLimit: siteRepeatLimit}
+}
+
+// LogInjection stores an administered dose. site may be empty.
+func (s *InjectionService) LogInjection(ctx context.Context, userID uint, units dosing.DeciUnits, kind, site string) (*database.Injection, error) {
+	injection := &database.Injection{
+		UserID:    userID,
+		Units:     units,
+		Kind:      kind,
+		Site:      site,
+		Timestamp: time.Now(),
+	}
+	if err := s.db.WithContext(ctx).Create(injection).Error; err != nil {
+		return nil, fmt.Errorf("failed to create injection: %w", err)
+	}
+	publishInjectionLogged(ctx, injection)
+	return injection, nil
+}
+
+// publishInjectionLogged tells subscribers about a logged injection
+func publishInjectionLogged(ctx context.Context, injection *database.Injection) {
+	events.Publish(ctx, events.InjectionLogged{
+		UserID:      injection.UserID,
+		InjectionID: injection.ID,
+		Units:       injection.Units,
+		Kind:        injection.Kind,
+		Site:        injection.Site,
+		Time:        injection.Timestamp,
+	})
+}
+
+// SiteOverused reports whether the user's last injections that specify a site
+// all went into the given site, reaching the configured repeat limit
+func (s *InjectionService) SiteOverused(ctx context.Context, userID uint, site string) (bool, int, error) {
+	if site == "" {
+		return false, 0, nil
+	}
+
+	var sites []string
+	if err := s.db.WithContext(ctx).
+		Model(&database.Injection{}).
+		Where("user_id = ? AND site <> ''", userID).
+		Order("timestamp DESC").
+		Limit(s.siteRepeatLimit).
+		Pluck("site", &sites).Error; err != nil {
+		return false, 0, fmt.Errorf("failed to get recent injection sites: %w", err)
+	}
+
+	if len(sites) < s.siteRepeatLimit {
+		return false, 0, nil
+	}
+	for _, recent := range sites {
+		if recent != site {
+			return false, 0, nil
+		}
+	}
+	return true, len(sites), nil
+}
+
+// GetSiteFrequency counts the user's injections per site since the given time,
+// most used first. Injections without a site are not counted.
+func (s *InjectionService) GetSiteFrequency(ctx context.Context, userID uint, since time.Time) ([]SiteCount, error) {
+	var counts []SiteCount
+	if err := s.db.WithContext(ctx).
+		Model(&database.Injection{}).
+		Select("site, COUNT(*) AS count").
+		Where("user_id = ? AND site <> '' AND timestamp >= ?", userID, since).
+		Group("site").
+		Order("count DESC").
+		Scan(&counts).Error; err != nil {
+		return nil, fmt.Errorf("failed to get injection site frequency: %w", err)
+	}
+	return counts, nil
+}
+
+// GetUserInjections returns the user's injections since the given time, newest first
+func (s *InjectionService) GetUserInjections(ctx context.Context, userID uint, since time.Time) ([]database.Injection, error) {
+	var injections []database.Injection
+	if err := s.db.WithContext(ctx).
+		Where("user_id = ? AND timestamp >= ?", userID, since).
+		Order("timestamp DESC").
+		Find(&injections).Error; err != nil {
+		return nil, fmt.Errorf("failed to get user injections: %w", err)
+	}
+	return injections, nil
+}
+
+// ActiveInsulinTime returns how long the user's rapid-acting insulin acts
+func ActiveInsulinTime(user *database.User) time.Duration {
+	if user.ActiveInsulinTime <= 0 {
+		return dosing.DefaultActiveInsulinTime
+	}
+	return time.Duration(user.ActiveInsulinTime) * time.Minute
+}
+
+// CurvedIOB reports whether the user's insulin on board follows the curved
+// decay model rather than the default linear one
+func CurvedIOB(user *database.User) bool {
+	return user.IOBModel == dosing.IOBCurved
+}
+
+// InsulinOnBoard returns the rapid-acting insulin still active at now, from
+// bolus and correction injections, using the user's decay model. Basal
+// injections are not counted.
+func (s *InjectionService) InsulinOnBoard(ctx context.Context, user *database.User, now time.Time) (dosing.DeciUnits, error) {
+	return insulinOnBoard(ctx, s.db, user, now)
+}
+
+func insulinOnBoard(ctx context.Context, db *gorm.DB, user *database.User, now time.Time) (dosing.DeciUnits, error) {
+	duration := ActiveInsulinTime(user)
+	var injections []database.Injection
+	if err := db.WithContext(ctx).
+		Where("user_id = ? AND kind IN ? AND timestamp > ? AND timestamp <= ?",
+			user.ID, []string{InjectionKindBolus, InjectionKindCorrection}, now.Add(-duration), now).
+		Find(&injections).Error; err != nil {
+		return 0, fmt.Errorf("failed to get recent injections: %w", err)
+	}
+
+	doses := make([]dosing.Dose, len(injections))
+	for i, inj := range injections {
+		doses[i] = dosing.Dose{Units: inj.Units, At: inj.Timestamp}
+	}
+	return dosing.InsulinOnBoard(doses, now, duration, user.IOBModel), nil
+}
@@ -0,0 +1,207 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"gorm.io/gorm"
+)
+
+const (
+	// InsightsPeriod is how far back meals are considered for insights
+	InsightsPeriod = 90 * 24 * time.Hour
+
+	// A reading up to preMealWindow before a meal is its baseline
+	preMealWindow = time.Hour
+	// A reading between postMealFrom and postMealTo after a meal is its
+	// outcome; the one closest to postMealTarget is used
+	postMealFrom   = 90 * time.Minute
+	postMealTo     = 3 * time.Hour
+	postMealTarget = 2 * time.Hour
+
+	// minInsightMeals is how many linked meals a food needs to be listed
+	minInsightMeals = 2
+)
+
+// MealOutcome links a meal to the readings before and after it
+type MealOutcome struct {
+	Analysis database.FoodAnalysis
+	Before   database.BloodSugarRecord
+	After    database.BloodSugarRecord
+}
+
+// Rise returns the change of blood sugar after the meal, mmol/L
+func (o MealOutcome) Rise() float64 {
+	return o.After.Value - o.Before.Value
+}
+
+// FoodInsight is the average post-meal rise of meals containing a food
+type FoodInsight struct {
+	Food     string  // Normalized food name
+	Meals    int     // Meals with readings before and after
+	AvgRise  float64 // mmol/L
+	AvgCarbs float64 // Grams of carbs of the whole meal
+}
+
+// InsightsService relates logged meals to the blood sugar readings around them
+type InsightsService struct {
+	db *gorm.DB
+}
+
+func NewInsightsService(db *gorm.DB) *InsightsService {
+	return &InsightsService{db: db}
+}
+
+// MealOutcomes returns the user's meals since the given time that have a
+// reading shortly before and about two hours after them. Meals followed by
+// another meal before the outcome reading are skipped, since the rise can't
+// be attributed to either.
+func (s *InsightsService) MealOutcomes(ctx context.Context, userID uint, since time.Time) ([]MealOutcome, error) {
+	var analyses []database.FoodAnalysis
+	if err := s.db.WithContext(ctx).
+		Where("user_id = ? AND created_at >= ? AND carbs > 0", userID, since).
+		Order("created_at").
+		Find(&analyses).Error; err != nil {
+		return nil, fmt.Errorf("failed to get analyses: %w", err)
+	}
+	if len(analyses) == 0 {
+		return nil, nil
+	}
+
+	var readings []database.BloodSugarRecord
+	if err := s.db.WithContext(ctx).
+		Where("user_id = ? AND timestamp >= ?", userID, since.Add(-preMealWindow)).
+		Order("timestamp").
+		Find(&readings).Error; err != nil {
+		return nil, fmt.Errorf("failed to get blood sugar records: %w", err)
+	}
+	return linkMealOutcomes(analyses, readings), nil
+}
+
+// linkMealOutcomes pairs meals with readings; both must be sorted by time
+func linkMealOutcomes(analyses []database.FoodAnalysis, readings []database.BloodSugarRecord) []MealOutcome {
+	var outcomes []MealOutcome
+	for i, meal := range analyses {
+		before, okBefore := lastReadingBetween(readings, meal.CreatedAt.Add(-preMealWindow), meal.CreatedAt)
+		after, okAfter := readingClosestTo(readings, meal.CreatedAt.Add(postMealFrom), meal.CreatedAt.Add(postMealTo), meal.CreatedAt.Add(postMealTarget))
+		if !okBefore || !okAfter {
+			continue
+		}
+		if i+1 < len(analyses) && !analyses[i+1].CreatedAt.After(after.Timestamp) {
+			continue
+		}
+		outcomes = append(outcomes, MealOutcome{Analysis: meal, Before: before, After: after})
+	}
+	return outcomes
+}
+
+// lastReadingBetween returns the latest reading in [from, to]
+func lastReadingBetween(readings []database.BloodSugarRecord, from, to time.Time) (database.BloodSugarRecord, bool) {
+	for i := len(readings) - 1; i >= 0; i-- {
+		t := readings[i].Timestamp
+		if t.After(to) {
+			continue
+		}
+		if t.Before(from) {
+			break
+		}
+		return readings[i], true
+	}
+	return database.BloodSugarRecord{}, false
+}
+
+// readingClosestTo returns the reading in [from, to] closest to target
+func readingClosestTo(readings []database.BloodSugarRecord, from, to, target time.Time) (database.BloodSugarRecord, bool) {
+	var best database.BloodSugarRecord
+	found := false
+	for _, r := range readings {
+		if r.Timestamp.Before(from) {
+			continue
+		}
+		if r.Timestamp.After(to) {
+			break
+		}
+		if !found || absDuration(r.Timestamp.Sub(target)) < absDuration(best.Timestamp.Sub(target)) {
+			best = r
+			found = true
+		}
+	}
+	return best, found
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// TopSpikes returns the foods whose meals are followed by the highest average
+// blood sugar rise over InsightsPeriod, highest first. Foods need at least
+// two linked meals to be listed.
+func (s *InsightsService) TopSpikes(ctx context.Context, userID uint, limit int) ([]FoodInsight, error) {
+	outcomes, err := s.MealOutcomes(ctx, userID, time.Now().Add(-InsightsPeriod))
+	if err != nil {
+		return nil, err
+	}
+	if len(outcomes) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]uint, len(outcomes))
+	for i, o := range outcomes {
+		ids[i] = o.Analysis.ID
+	}
+	var items []database.FoodItem
+	if err := s.db.WithContext(ctx).Where("food_analysis_id IN ?", ids).Find(&items).Error; err != nil {
+		return nil, fmt.Errorf("failed to get food items: %w", err)
+	}
+	foods := make(map[uint][]string, len(outcomes))
+	for _, item := range items {
+		foods[item.FoodAnalysisID] = append(foods[item.FoodAnalysisID], item.NormalizedName)
+	}
+
+	type totals struct {
+		meals       int
+		rise, carbs float64
+	}
+	byFood := make(map[string]*totals)
+	for _, o := range outcomes {
+		for _, food := range foods[o.Analysis.ID] {
+			t := byFood[food]
+			if t == nil {
+				t = &totals{}
+				byFood[food] = t
+			}
+			t.meals++
+			t.rise += o.Rise()
+			t.carbs += o.Analysis.Carbs
+		}
+	}
+
+	var insights []FoodInsight
+	for food, t := range byFood {
+		if t.meals < minInsightMeals {
+			continue
+		}
+		insights = append(insights, FoodInsight{
+			Food:     food,
+			Meals:    t.meals,
+			AvgRise:  t.rise / float64(t.meals),
+			AvgCarbs: t.carbs / float64(t.meals),
+		})
+	}
+	sort.Slice(insights, func(i, j int) bool {
+		if insights[i].AvgRise != insights[j].AvgRise {
+			return insights[i].AvgRise > insights[j].AvgRise
+		}
+		return insights[i].Food < insights[j].Food
+	})
+	if limit > 0 && len(insights) > limit {
+		insights = insights[:limit]
+	}
+	return insights, nil
+}
@@ -5,20 +5,54 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/vladimiradmaev/diabetes-helper/internal/config"
 	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/utils"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// addRatioIdempotencyWindow is how long an identical AddRatio call is treated as a repeat
+const addRatioIdempotencyWindow = 2 * time.Minute
+
+// Ratio checks returned by CheckRatio
+const (
+	RatioTypical = ""
+	RatioLow     = "low"
+	RatioHigh    = "high"
 )
 
 type InsulinService struct {
-	db *gorm.DB
+	db     *gorm.DB
+	bounds config.RatioBounds
 }
 
-func NewInsulinService(db *gorm.DB) *InsulinService {
+func NewInsulinService(db *gorm.DB, bounds config.RatioBounds) *InsulinService {
 	return &InsulinService{
-		db: db,
+		db:     db,
+		bounds: bounds,
 	}
 }
 
+// CheckRatio reports whether a ratio is unusually low or high. A mistyped
+// ratio (10 instead of 1.0) multiplies every dose, so such values are
+// confirmed with the user before saving.
+func (s *InsulinService) CheckRatio(ratio float64) string {
+	switch {
+	case ratio < s.bounds.Low:
+		return RatioLow
+	case ratio > s.bounds.High:
+		return RatioHigh
+	default:
+		return RatioTypical
+	}
+}
+
+// RatioBounds returns the range of ratios considered typical
+func (s *InsulinService) RatioBounds() config.RatioBounds {
+	return s.bounds
+}
+
 func (s *InsulinService) AddRatio(ctx context.Context, userID uint, startTime, endTime string, ratio float64) error {
 	// Validate time format
 	if _, err := time.Parse("15:04", startTime); err != nil {
@@ -28,62 +62,42 @@ func (s *InsulinService) AddRatio(ctx context.Context, userID uint, startTime, e
 		return fmt.Errorf("invalid end time format: %w", err)
 	}
 
-	// Check if the new period overlaps with existing ones
-	var existingRatios []database.InsulinRatio
-	if err := s.db.WithContext(ctx).
-		Where("user_id = ?", userID).
-		Find(&existingRatios).Error; err != nil {
-		return fmt.Errorf("failed to check existing ratios: %w", err)
-	}
-
-	// Convert times to minutes for easier comparison
-	startMinutes := timeToMinutes(startTime)
-	endMinutes := timeToMinutes(endTime)
-
-	for _, r := range existingRatios {
-		existingStart := timeToMinutes(r.StartTime)
-		existingEnd := timeToMinutes(r.EndTime)
-
-		if (startMinutes >= existingStart && startMinutes < existingEnd) ||
-			(endMinutes > existingStart && endMinutes <= existingEnd) ||
-			(startMinutes <= existingStart && endMinutes >= existingEnd) {
-			return fmt.Errorf("time period overlaps with existing ratio")
+	// The user row is locked so that two deliveries of the same update can't
+	// both pass the checks below
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&database.User{}, userID).Error; err != nil {
+			return fmt.Errorf("failed to lock user: %w", err)
 		}
-	}
 
-	// Check if total coverage is 24 hours
-	totalMinutes := 0
-	for _, r := range existingRatios {
-		existingStart := timeToMinutes(r.StartTime)
-		existingEnd := timeToMinutes(r.EndTime)
-		if existingEnd < existingStart {
-			existingEnd += 24 * 60 // Add 24 hours if period crosses midnight
+		var existingRatios []database.InsulinRatio
+		if err := tx.Where("user_id = ?", userID).Find(&existingRatios).Error; err != nil {
+			return fmt.Errorf("failed to check existing ratios: %w", err)
 		}
-		totalMinutes += existingEnd - existingStart
-	}
 
-	// Add new period
-	if endMinutes < startMinutes {
-		endMinutes += 24 * 60 // Add 24 hours if period crosses midnight
-	}
-	totalMinutes += endMinutes - startMinutes
-
-	if totalMinutes > 24*60 {
-		return fmt.Errorf("total time coverage exceeds 24 hours")
-	}
-
-	insulinRatio := &database.InsulinRatio{
-		UserID:    userID,
-		StartTime: startTime,
-		EndTime:   endTime,
-		Ratio:     ratio,
-	}
+		// A repeat of a ratio that was just added (e.g. Telegram redelivering
+		// the message) succeeds without creating a duplicate
+		for _, r := range existingRatios {
+			if r.StartTime == startTime && r.EndTime == endTime && r.Ratio == ratio &&
+				time.Since(r.CreatedAt) < addRatioIdempotencyWindow {
+				return nil
+			}
+		}
 
-	if err := s.db.WithContext(ctx).Create(insulinRatio).Error; err != nil {
-		return fmt.Errorf("failed to create insulin ratio: %w", err)
-	}
+		if err := checkRatioPeriod(existingRatios, startTime, endTime); err != nil {
+			return err
+		}
 
-	return nil
+		insulinRatio := &database.InsulinRatio{
+			UserID:    userID,
+			StartTime: startTime,
+			EndTime:   endTime,
+			Ratio:     ratio,
+		}
+		if err := tx.Create(insulinRatio).Error; err != nil {
+			return fmt.Errorf("failed to create insulin ratio: %w", err)
+		}
+		return nil
+	})
 }
 
 func (s *InsulinService) GetUserRatios(ctx context.Context, userID uint) ([]database.InsulinRatio, error) {
@@ -128,48 +142,8 @@ func (s *InsulinService) UpdateRatio(ctx context.Context, userID uint, ratioID u
 		return fmt.Errorf("failed to check existing ratios: %w", err)
 	}
 
-	// Convert times to minutes for easier comparison
-	startMinutes := timeToMinutes(startTime)
-	endMinutes := timeToMinutes(endTime)
-
-	// Handle midnight crossing
-	if endMinutes < startMinutes {
-		endMinutes += 24 * 60
-	}
-
-	for _, r := range existingRatios {
-		existingStart := timeToMinutes(r.StartTime)
-		existingEnd := timeToMinutes(r.EndTime)
-
-		// Handle midnight crossing for existing period
-		if existingEnd < existingStart {
-			existingEnd += 24 * 60
-		}
-
-		// Check for overlap
-		if (startMinutes >= existingStart && startMinutes < existingEnd) ||
-			(endMinutes > existingStart && endMinutes <= existingEnd) ||
-			(startMinutes <= existingStart && endMinutes >= existingEnd) {
-			return fmt.Errorf("time period overlaps with existing ratio")
-		}
-	}
-
-	// Check if total coverage is 24 hours
-	totalMinutes := 0
-	for _, r := range existingRatios {
-		existingStart := timeToMinutes(r.StartTime)
-		existingEnd := timeToMinutes(r.EndTime)
-		if existingEnd < existingStart {
-			existingEnd += 24 * 60 // Add 24 hours if period crosses midnight
-		}
-		totalMinutes += existingEnd - existingStart
-	}
-
-	// Add new period
-	totalMinutes += endMinutes - startMinutes
-
-	if totalMinutes > 24*60 {
-		return fmt.Errorf("total time coverage exceeds 24 hours")
+	if err := checkRatioPeriod(existingRatios, startTime, endTime); err != nil {
+		return err
 	}
 
 	result := s.db.WithContext(ctx).
@@ -191,10 +165,69 @@ func (s *InsulinService) UpdateRatio(ctx context.Context, userID uint, ratioID u
 	return nil
 }
 
-// Helper function to convert time string to minutes since midnight
-func timeToMinutes(timeStr string) int {
-	t, _ := time.Parse("15:04", timeStr)
-	return t.Hour()*60 + t.Minute()
+// ReplaceRatios atomically replaces all of the user's ratios with the given periods
+func (s *InsulinService) ReplaceRatios(ctx context.Context, userID uint, ratios []database.InsulinRatio) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&database.InsulinRatio{}).Error; err != nil {
+			return fmt.Errorf("failed to delete existing ratios: %w", err)
+		}
+		for _, r := range ratios {
+			ratio := &database.InsulinRatio{
+				UserID:    userID,
+				StartTime: r.StartTime,
+				EndTime:   r.EndTime,
+				Ratio:     r.Ratio,
+			}
+			if err := tx.Create(ratio).Error; err != nil {
+				return fmt.Errorf("failed to create insulin ratio: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// ApplyRatioPreset replaces the user's ratios with a built-in preset schedule
+func (s *InsulinService) ApplyRatioPreset(ctx context.Context, userID uint, presetID string) error {
+	preset, ok := GetRatioPreset(presetID)
+	if !ok {
+		return fmt.Errorf("unknown ratio preset: %s", presetID)
+	}
+
+	ratios := make([]database.InsulinRatio, 0, len(preset.Periods))
+	for _, p := range preset.Periods {
+		ratios = append(ratios, database.InsulinRatio{
+			StartTime: p.StartTime,
+			EndTime:   p.EndTime,
+			Ratio:     p.Ratio,
+		})
+	}
+	return s.ReplaceRatios(ctx, userID, ratios)
+}
+
+// checkRatioPeriod validates a new period against the user's other periods
+func checkRatioPeriod(existing []database.InsulinRatio, startTime, endTime string) error {
+	periods := make([][2]string, len(existing))
+	for i, r := range existing {
+		periods[i] = [2]string{r.StartTime, r.EndTime}
+	}
+	return checkPeriod(periods, startTime, endTime, "ratio")
+}
+
+// checkPeriod validates a new schedule period against the other periods of
+// the same schedule; what names the schedule entry in errors
+func checkPeriod(existing [][2]string, startTime, endTime, what string) error {
+	totalMinutes := utils.PeriodMinutes(startTime, endTime)
+	for _, p := range existing {
+		if utils.PeriodsOverlap(startTime, endTime, p[0], p[1]) {
+			return fmt.Errorf("time period overlaps with existing %s", what)
+		}
+		totalMinutes += utils.PeriodMinutes(p[0], p[1])
+	}
+
+	if totalMinutes > 24*60 {
+		return fmt.Errorf("total time coverage exceeds 24 hours")
+	}
+	return nil
 }
 
 // GetActiveInsulinTime returns the active insulin time in minutes for a user
@@ -0,0 +1,256 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+	"github.com/vladimiradmaev/diabetes-helper/internal/storage"
+	"gorm.io/gorm"
+)
+
+// Job kinds
+const (
+	JobKindExport         = "export_csv"
+	JobKindExportTidepool = "export_tidepool" // Tidepool data model JSON
+	// JobKindReanalysis repeats an analysis served by a fallback AI model on
+	// the primary model; Cursor holds the analysis ID
+	JobKindReanalysis = "reanalyze_primary"
+)
+
+// Job statuses
+const (
+	JobStatusQueued  = "queued"
+	JobStatusRunning = "running"
+	JobStatusDone    = "done"
+	JobStatusFailed  = "failed"
+)
+
+// jobMaxAttempts is how many times a job is started before it is marked failed
+const jobMaxAttempts = 3
+
+// A re-analysis waits for the primary model to recover, so it is first run
+// after reanalysisDelay and retried every reanalysisDelay for about six hours
+const (
+	reanalysisDelay       = 30 * time.Minute
+	reanalysisMaxAttempts = 12
+)
+
+// JobService queues background jobs and runs them chunk by chunk. Progress is
+// saved after every chunk, so a job interrupted by a restart resumes instead
+// of starting over.
+type JobService struct {
+	db   *gorm.DB
+	dir  string       // Scratch directory where output is generated
+	blob storage.Blob // Finished output waiting for delivery
+}
+
+func NewJobService(db *gorm.DB, dir string, blob storage.Blob) *JobService {
+	return &JobService{db: db, dir: dir, blob: blob}
+}
+
+// EnqueueExport queues an export of the user's history of the given kind,
+// JobKindExport or JobKindExportTidepool. A user has at most one export of a
+// kind in progress: if one exists it is returned with created false.
+func (s *JobService) EnqueueExport(ctx context.Context, userID uint, chatID int64, kind string) (*database.Job, bool, error) {
+	if kind != JobKindExport && kind != JobKindExportTidepool {
+		return nil, false, fmt.Errorf("unknown export kind: %s", kind)
+	}
+
+	var existing database.Job
+	err := s.db.WithContext(ctx).
+		Where("user_id = ? AND kind = ? AND status IN ?", userID, kind, []string{JobStatusQueued, JobStatusRunning}).
+		First(&existing).Error
+	if err == nil {
+		return &existing, false, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, false, fmt.Errorf("failed to check export jobs: %w", err)
+	}
+
+	job := &database.Job{
+		UserID: userID,
+		ChatID: chatID,
+		Kind:   kind,
+		Status: JobStatusQueued,
+	}
+	if err := s.db.WithContext(ctx).Create(job).Error; err != nil {
+		return nil, false, fmt.Errorf("failed to create job: %w", err)
+	}
+	return job, true, nil
+}
+
+// EnqueueReanalysis queues a repeat of the analysis on the primary AI model,
+// run once reanalysisDelay has passed. An analysis is queued at most once: if
+// it already is, that job is returned with created false.
+func (s *JobService) EnqueueReanalysis(ctx context.Context, userID uint, chatID int64, analysisID uint) (*database.Job, bool, error) {
+	cursor := strconv.FormatUint(uint64(analysisID), 10)
+
+	var existing database.Job
+	err := s.db.WithContext(ctx).
+		Where("user_id = ? AND kind = ? AND cursor = ? AND status IN ?", userID, JobKindReanalysis, cursor, []string{JobStatusQueued, JobStatusRunning}).
+		First(&existing).Error
+	if err == nil {
+		return &existing, false, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, false, fmt.Errorf("failed to check re-analysis jobs: %w", err)
+	}
+
+	runAfter := time.Now().Add(reanalysisDelay)
+	job := &database.Job{
+		UserID:   userID,
+		ChatID:   chatID,
+		Kind:     JobKindReanalysis,
+		Status:   JobStatusQueued,
+		Cursor:   cursor,
+		RunAfter: &runAfter,
+	}
+	if err := s.db.WithContext(ctx).Create(job).Error; err != nil {
+		return nil, false, fmt.Errorf("failed to create job: %w", err)
+	}
+	return job, true, nil
+}
+
+// ReanalysisID returns the ID of the analysis a JobKindReanalysis job repeats
+func ReanalysisID(job *database.Job) (uint, error) {
+	id, err := strconv.ParseUint(job.Cursor, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("job %d has no analysis ID: %w", job.ID, err)
+	}
+	return uint(id), nil
+}
+
+// SetMessageID stores the status message that is updated with the job's progress
+func (s *JobService) SetMessageID(ctx context.Context, jobID uint, messageID int) error {
+	if err := s.db.WithContext(ctx).Model(&database.Job{}).Where("id = ?", jobID).Update("message_id", messageID).Error; err != nil {
+		return fmt.Errorf("failed to update job message: %w", err)
+	}
+	return nil
+}
+
+// RequeueInterrupted puts jobs left running by a previous process back into the queue
+func (s *JobService) RequeueInterrupted(ctx context.Context) error {
+	result := s.db.WithContext(ctx).Model(&database.Job{}).
+		Where("status = ?", JobStatusRunning).
+		Update("status", JobStatusQueued)
+	if result.Error != nil {
+		return fmt.Errorf("failed to requeue interrupted jobs: %w", result.Error)
+	}
+	return nil
+}
+
+// ClaimNext marks the oldest queued job that is due as running and returns it,
+// or nil if there is none. SKIP LOCKED lets several workers share the queue.
+func (s *JobService) ClaimNext(ctx context.Context) (*database.Job, error) {
+	var job database.Job
+	if err := s.db.WithContext(ctx).Raw(`
+		UPDATE jobs SET status = ?, attempts = attempts + 1, updated_at = NOW()
+		WHERE id = (
+			SELECT id FROM jobs WHERE status = ? AND (run_after IS NULL OR run_after <= NOW())
+			ORDER BY id LIMIT 1 FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`, JobStatusRunning, JobStatusQueued).
+		Scan(&job).Error; err != nil {
+		return nil, fmt.Errorf("failed to claim job: %w", err)
+	}
+	if job.ID == 0 {
+		return nil, nil
+	}
+	return &job, nil
+}
+
+// RunChunk processes the next chunk of a running job and saves its progress.
+// It returns true once the job is complete.
+func (s *JobService) RunChunk(ctx context.Context, job *database.Job) (bool, error) {
+	switch job.Kind {
+	case JobKindExport, JobKindExportTidepool:
+		return s.runExportChunk(ctx, job)
+	default:
+		return false, fmt.Errorf("unknown job kind: %s", job.Kind)
+	}
+}
+
+// Fail records a job error. The job is queued again unless it has used all
+// attempts; retry reports which of the two happened. A re-analysis is retried
+// after reanalysisDelay, other jobs right away.
+func (s *JobService) Fail(ctx context.Context, job *database.Job, jobErr error) (retry bool, err error) {
+	maxAttempts := jobMaxAttempts
+	if job.Kind == JobKindReanalysis {
+		maxAttempts = reanalysisMaxAttempts
+	}
+	retry = job.Attempts < maxAttempts
+	updates := map[string]interface{}{
+		"status": JobStatusFailed,
+		"error":  jobErr.Error(),
+	}
+	if retry {
+		updates["status"] = JobStatusQueued
+		if job.Kind == JobKindReanalysis {
+			updates["run_after"] = time.Now().Add(reanalysisDelay)
+		}
+	}
+
+	if err := s.db.WithContext(ctx).Model(job).Updates(updates).Error; err != nil {
+		return false, fmt.Errorf("failed to update job: %w", err)
+	}
+	if !retry {
+		s.removeOutput(ctx, job)
+	}
+	return retry, nil
+}
+
+// Result returns the finished output of a job with the file name and MIME
+// type it is sent to the user under
+func (s *JobService) Result(ctx context.Context, job *database.Job) (filename, contentType string, data []byte, err error) {
+	if job.StorageKey == "" {
+		return "", "", nil, fmt.Errorf("job %d has no output", job.ID)
+	}
+	r, err := s.blob.Get(ctx, job.StorageKey)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("failed to open job output: %w", err)
+	}
+	defer r.Close()
+	data, err = io.ReadAll(r)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("failed to read job output: %w", err)
+	}
+	if job.Kind == JobKindExportTidepool {
+		return fmt.Sprintf("diabetes_export_tidepool_%s.json", job.CreatedAt.Format("2006-01-02")), "application/json", data, nil
+	}
+	return fmt.Sprintf("diabetes_export_%s.csv", job.CreatedAt.Format("2006-01-02")), "text/csv", data, nil
+}
+
+// MarkDelivered removes the output of a job whose result was sent to the user
+func (s *JobService) MarkDelivered(ctx context.Context, job *database.Job) error {
+	s.removeOutput(ctx, job)
+	if err := s.db.WithContext(ctx).Model(job).Updates(map[string]interface{}{
+		"status":      JobStatusDone,
+		"file_path":   "",
+		"storage_key": "",
+	}).Error; err != nil {
+		return fmt.Errorf("failed to update job: %w", err)
+	}
+	return nil
+}
+
+// removeOutput deletes the job's scratch file and stored output. Failures are
+// only logged: leftovers in storage expire with the other artifacts.
+func (s *JobService) removeOutput(ctx context.Context, job *database.Job) {
+	if job.FilePath != "" {
+		if err := os.Remove(job.FilePath); err != nil && !os.IsNotExist(err) {
+			logger.Warn("Failed to remove job file", "job_id", job.ID, "path", job.FilePath, "error", err)
+		}
+	}
+	if job.StorageKey != "" {
+		if err := s.blob.Delete(ctx, job.StorageKey); err != nil {
+			logger.Warn("Failed to delete job output", "job_id", job.ID, "key", job.StorageKey, "error", err)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/dosing"
+	"github.com/vladimiradmaev/diabetes-helper/internal/utils"
+	"gorm.io/gorm"
+)
+
+// MealDraft is a meal from an earlier day proposed for logging again
+type MealDraft struct {
+	SourceID uint                  // Analysis the draft was copied from
+	Analysis database.FoodAnalysis // Unsaved copy with the dose for its new time
+}
+
+// CloneDay proposes the meals logged during the local day containing fromDate
+// for the day containing toDate. Nothing is saved: each draft keeps its time
+// of day and gets the dose for the ratio in effect at that time on toDate,
+// so the user can confirm meals one by one with CloneAnalysis. Both days are
+// taken in fromDate's location.
+func (s *FoodAnalysisService) CloneDay(ctx context.Context, userID uint, fromDate, toDate time.Time) ([]MealDraft, error) {
+	loc := fromDate.Location()
+	dayStart, dayEnd := utils.DayBounds(fromDate, loc)
+	targetStart, _ := utils.DayBounds(toDate, loc)
+
+	var sources []database.FoodAnalysis
+	if err := s.db.WithContext(ctx).
+		Where("user_id = ? AND created_at >= ? AND created_at < ?", userID, dayStart, dayEnd).
+		Order("created_at").
+		Find(&sources).Error; err != nil {
+		return nil, fmt.Errorf("failed to get analyses to copy: %w", err)
+	}
+	if len(sources) == 0 {
+		return nil, nil
+	}
+
+	var ratios []database.InsulinRatio
+	if err := s.db.WithContext(ctx).Where("user_id = ?", userID).Find(&ratios).Error; err != nil {
+		return nil, fmt.Errorf("failed to get insulin ratios: %w", err)
+	}
+
+	drafts := make([]MealDraft, 0, len(sources))
+	for _, source := range sources {
+		local := source.CreatedAt.In(loc)
+		at := time.Date(targetStart.Year(), targetStart.Month(), targetStart.Day(),
+			local.Hour(), local.Minute(), local.Second(), 0, loc)
+		drafts = append(drafts, MealDraft{
+			SourceID: source.ID,
+			Analysis: cloneAnalysis(source, at, dosing.Input{CarbRatio: ratioAt(ratios, at)}),
+		})
+	}
+	return drafts, nil
+}
+
+// CloneAnalysis logs a copy of one of the user's analyses at the current
+// time, with the dose for the ratio in effect now. Food items are copied too,
+// so the copy shows up in search like the original.
+func (s *FoodAnalysisService) CloneAnalysis(ctx context.Context, userID, sourceID uint) (*database.FoodAnalysis, error) {
+	var source database.FoodAnalysis
+	err := s.db.WithContext(ctx).Where("id = ? AND user_id = ?", sourceID, userID).First(&source).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("analysis %d not found", sourceID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get analysis: %w", err)
+	}
+
+	dose, err := s.calculateDose(ctx, userID, source.Carbs)
+	if err != nil {
+		return nil, err
+	}
+	analysis := cloneAnalysis(source, time.Now(), dosing.Input{
+		CarbRatio:     dose.CarbRatio,
+		ActiveInsulin: dose.ActiveInsulin,
+		Correction:    dose.CorrectionDose,
+	})
+	analysis.CorrectionGlucose = dose.Glucose
+	analysis.CorrectionInBand = dose.InTargetBand
+
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&analysis).Error; err != nil {
+			return fmt.Errorf("failed to save copied analysis: %w", err)
+		}
+
+		var items []database.FoodItem
+		if err := tx.Where("food_analysis_id = ?", source.ID).Find(&items).Error; err != nil {
+			return fmt.Errorf("failed to get food items: %w", err)
+		}
+		if len(items) == 0 {
+			return nil
+		}
+		for i := range items {
+			items[i].ID = 0
+			items[i].CreatedAt = time.Time{}
+			items[i].UpdatedAt = time.Time{}
+			items[i].FoodAnalysisID = analysis.ID
+		}
+		if err := tx.Create(&items).Error; err != nil {
+			return fmt.Errorf("failed to copy food items: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	analysesSaved.Inc(analysis.UsedProvider)
+	publishAnalysisSaved(ctx, &analysis)
+	return &analysis, nil
+}
+
+// cloneAnalysis copies the carbs and description of an analysis to a new time
+// with the dose for in; its Carbs are taken from source. Copies count as
+// carbs entered by the user: the photo itself was not analysed again.
+func cloneAnalysis(source database.FoodAnalysis, at time.Time, in dosing.Input) database.FoodAnalysis {
+	in.Carbs = source.Carbs
+	dose := dosing.CalculateDose(in)
+	return database.FoodAnalysis{
+		CreatedAt:       at,
+		UserID:          source.UserID,
+		Weight:          source.Weight,
+		Carbs:           source.Carbs,
+		BreadUnits:      dose.BreadUnits,
+		Confidence:      source.Confidence,
+		AnalysisText:    source.AnalysisText,
+		UsedProvider:    ManualProvider,
+		InsulinRatio:    dose.CarbRatio,
+		InsulinUnits:    dose.Total,
+		ActiveInsulin:   dose.ActiveInsulin,
+		CorrectionUnits: dose.CorrectionDose,
+		PhotoFileID:     source.PhotoFileID,
+		PhotoKey:        source.PhotoKey,
+	}
+}
@@ -0,0 +1,137 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	providerOpenAI = "openai"
+	openaiModel    = "gpt-4o-mini"
+
+	openaiChatURL = "https://api.openai.com/v1/chat/completions"
+)
+
+// openaiClient calls the OpenAI chat completions API. It only does what the
+// fallback needs: one image and one text prompt per request.
+type openaiClient struct {
+	apiKey string
+	http   *http.Client
+}
+
+func newOpenAIClient(apiKey string) *openaiClient {
+	return &openaiClient{
+		apiKey: apiKey,
+		http:   &http.Client{Timeout: 60 * time.Second},
+	}
+}
+
+// OpenAIError is a non-2xx response of the OpenAI API
+type OpenAIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *OpenAIError) Error() string {
+	return fmt.Sprintf("openai: status %d: %s", e.StatusCode, e.Message)
+}
+
+type openaiContentPart struct {
+	Type     string          `json:"type"`
+	Text     string          `json:"text,omitempty"`
+	ImageURL *openaiImageURL `json:"image_url,omitempty"`
+}
+
+type openaiImageURL struct {
+	URL string `json:"url"`
+}
+
+type openaiMessage struct {
+	Role    string              `json:"role"`
+	Content []openaiContentPart `json:"content"`
+}
+
+type openaiRequest struct {
+	Model    string          `json:"model"`
+	Messages []openaiMessage `json:"messages"`
+}
+
+type openaiResponse struct {
+	Choices []struct {
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+	} `json:"choices"`
+	Usage struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// analyzeImage sends the image with the prompt and returns the model's answer
+// text and the token usage of the request
+func (c *openaiClient) analyzeImage(ctx context.Context, imageFormat string, imageData []byte, prompt string) (string, AIUsage, error) {
+	usage := AIUsage{Provider: providerOpenAI, Model: openaiModel}
+
+	body, err := json.Marshal(openaiRequest{
+		Model: openaiModel,
+		Messages: []openaiMessage{{
+			Role: "user",
+			Content: []openaiContentPart{
+				{Type: "text", Text: prompt},
+				{Type: "image_url", ImageURL: &openaiImageURL{
+					URL: "data:" + imageFormat + ";base64," + base64.StdEncoding.EncodeToString(imageData),
+				}},
+			},
+		}},
+	})
+	if err != nil {
+		return "", usage, fmt.Errorf("failed to encode openai request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, openaiChatURL, bytes.NewReader(body))
+	if err != nil {
+		return "", usage, fmt.Errorf("failed to create openai request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", usage, fmt.Errorf("openai request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", usage, fmt.Errorf("failed to read openai response: %w", err)
+	}
+
+	var parsed openaiResponse
+	if err := json.Unmarshal(data, &parsed); err != nil && resp.StatusCode/100 == 2 {
+		return "", usage, fmt.Errorf("failed to parse openai response: %w", err)
+	}
+	if resp.StatusCode/100 != 2 {
+		message := http.StatusText(resp.StatusCode)
+		if parsed.Error != nil && parsed.Error.Message != "" {
+			message = parsed.Error.Message
+		}
+		return "", usage, &OpenAIError{StatusCode: resp.StatusCode, Message: message}
+	}
+
+	usage.InputTokens = parsed.Usage.PromptTokens
+	usage.OutputTokens = parsed.Usage.CompletionTokens
+	if len(parsed.Choices) == 0 {
+		return "", usage, fmt.Errorf("no choices in openai response")
+	}
+	return parsed.Choices[0].Message.Content, usage, nil
+}
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/storage"
+)
+
+// maxArchivedPhotoSize matches the largest file the Bot API lets bots download
+const maxArchivedPhotoSize = 20 << 20
+
+var photoDownloadClient = &http.Client{Timeout: time.Minute}
+
+// photosPrefix is where a user's archived meal photos are kept. It is outside
+// storage.ArtifactsPrefix, so archived photos never expire.
+func photosPrefix(userID uint) string {
+	return storage.Key("photos", strconv.FormatUint(uint64(userID), 10)) + "/"
+}
+
+// ArchivePhoto stores a copy of the analysed photo and records where it is, so
+// the photo stays available even if its Telegram file_id stops working
+func (s *FoodAnalysisService) ArchivePhoto(ctx context.Context, analysis *database.FoodAnalysis, fileID, imageURL string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, imageURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create photo request: %w", err)
+	}
+	resp, err := photoDownloadClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to download photo: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download photo: %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxArchivedPhotoSize))
+	if err != nil {
+		return fmt.Errorf("failed to read photo: %w", err)
+	}
+
+	key := photosPrefix(analysis.UserID) + strconv.FormatUint(uint64(analysis.ID), 10) + ".jpg"
+	if err := s.blob.Put(ctx, key, data); err != nil {
+		return err
+	}
+
+	err = s.db.WithContext(ctx).Model(&database.FoodAnalysis{}).Where("id = ?", analysis.ID).
+		Updates(map[string]interface{}{"photo_file_id": fileID, "photo_key": key}).Error
+	if err != nil {
+		return fmt.Errorf("failed to save photo key: %w", err)
+	}
+	analysis.PhotoFileID = fileID
+	analysis.PhotoKey = key
+	return nil
+}
+
+// DeleteArchivedPhotos removes all of the user's archived photos. Everything
+// under the user's prefix is deleted, including copies whose key was never
+// saved to the database.
+func (s *FoodAnalysisService) DeleteArchivedPhotos(ctx context.Context, userID uint) error {
+	if _, err := s.blob.DeleteOlderThan(ctx, photosPrefix(userID), time.Now().Add(time.Minute)); err != nil {
+		return fmt.Errorf("failed to delete archived photos: %w", err)
+	}
+	err := s.db.WithContext(ctx).Model(&database.FoodAnalysis{}).
+		Where("user_id = ? AND photo_key <> ''", userID).
+		Update("photo_key", "").Error
+	if err != nil {
+		return fmt.Errorf("failed to clear photo keys: %w", err)
+	}
+	return nil
+}
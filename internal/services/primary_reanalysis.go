@@ -0,0 +1,70 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/dosing"
+	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+)
+
+// PrimaryReanalysis is the result of repeating an analysis on the primary AI
+// model. It is only compared with the saved analysis and never replaces it:
+// the user decides whether to correct the carbs.
+type PrimaryReanalysis struct {
+	Carbs      float64
+	BreadUnits float64
+	Loc        *time.Location // User's timezone, to show when the saved analysis was made
+}
+
+// KeepPhotoFileID records the Telegram file_id of the analysed photo, so the
+// analysis can be repeated on the primary model later. Analyses whose photo is
+// archived already have it.
+func (s *FoodAnalysisService) KeepPhotoFileID(ctx context.Context, analysis *database.FoodAnalysis, fileID string) error {
+	if analysis.PhotoFileID != "" {
+		return nil
+	}
+	err := s.db.WithContext(ctx).Model(&database.FoodAnalysis{}).Where("id = ?", analysis.ID).
+		Update("photo_file_id", fileID).Error
+	if err != nil {
+		return fmt.Errorf("failed to save photo file id: %w", err)
+	}
+	analysis.PhotoFileID = fileID
+	return nil
+}
+
+// ReanalyzeOnPrimary analyzes the photo of a saved analysis again with the
+// primary model. The saved weight is kept, so the two results differ only in
+// how the models read the dish.
+func (s *FoodAnalysisService) ReanalyzeOnPrimary(ctx context.Context, userID, analysisID uint, imageURL string) (*PrimaryReanalysis, error) {
+	analysis, err := s.GetAnalysis(ctx, userID, analysisID)
+	if err != nil {
+		return nil, err
+	}
+	var user database.User
+	if err := s.db.WithContext(ctx).First(&user, userID).Error; err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	result, err := s.aiService.AnalyzeFoodImagePrimary(ctx, imageURL, analysis.Weight, PromptOptionsFor(&user, ""))
+	if err != nil {
+		return nil, fmt.Errorf("failed to analyze food image: %w", err)
+	}
+	if result.MeterReading != nil {
+		return nil, fmt.Errorf("primary model read analysis %d as a glucose meter photo", analysisID)
+	}
+
+	if aiCarbs := result.Carbs; clampCarbsToWeight(result, analysis.Weight) {
+		logger.Warn("AI returned more carbs than the dish weighs, carbs clamped",
+			"user_id", userID, "provider", result.Provider, "weight", analysis.Weight, "ai_carbs", aiCarbs)
+	}
+	carbs := roundCarbs(result.Carbs, user.CarbsStep)
+
+	return &PrimaryReanalysis{
+		Carbs:      carbs,
+		BreadUnits: dosing.BreadUnits(carbs),
+		Loc:        UserLocation(&user),
+	}, nil
+}
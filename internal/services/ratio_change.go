@@ -0,0 +1,133 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/format"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ActivatedRatioChange is a scheduled ratio change that has just taken effect
+type ActivatedRatioChange struct {
+	User     database.User
+	Ratios   []SettingsSnapshotRatio
+	Snapshot *database.SettingsSnapshot // Settings before the change, for rollback
+}
+
+// ScheduleRatioChange stores ratios that replace the user's current ones at
+// activateAt, replacing a change scheduled before
+func (s *SettingsSnapshotService) ScheduleRatioChange(ctx context.Context, userID uint, ratios []database.InsulinRatio, activateAt time.Time) error {
+	change := make([]SettingsSnapshotRatio, 0, len(ratios))
+	for _, r := range ratios {
+		change = append(change, SettingsSnapshotRatio{StartTime: r.StartTime, EndTime: r.EndTime, Ratio: r.Ratio})
+	}
+	encoded, err := json.Marshal(change)
+	if err != nil {
+		return fmt.Errorf("failed to encode ratio change: %w", err)
+	}
+
+	if err := s.db.WithContext(ctx).Model(&database.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"ratio_change":    string(encoded),
+		"ratio_change_at": activateAt,
+	}).Error; err != nil {
+		return fmt.Errorf("failed to schedule ratio change: %w", err)
+	}
+	return nil
+}
+
+// CancelRatioChange drops the user's scheduled ratio change, if any
+func (s *SettingsSnapshotService) CancelRatioChange(ctx context.Context, userID uint) error {
+	if err := s.db.WithContext(ctx).Model(&database.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"ratio_change":    "",
+		"ratio_change_at": nil,
+	}).Error; err != nil {
+		return fmt.Errorf("failed to cancel ratio change: %w", err)
+	}
+	return nil
+}
+
+// ActivateRatioChanges applies the scheduled ratio changes that are due. The
+// settings before each change are saved as a snapshot first, so the change
+// can be rolled back with a restore. Each change is applied in its own
+// transaction and only once, also with several instances running.
+func (s *SettingsSnapshotService) ActivateRatioChanges(ctx context.Context, now time.Time) ([]ActivatedRatioChange, error) {
+	var userIDs []uint
+	if err := s.db.WithContext(ctx).Model(&database.User{}).
+		Where("ratio_change_at <= ? AND deleted_at IS NULL", now).
+		Pluck("id", &userIDs).Error; err != nil {
+		return nil, fmt.Errorf("failed to get due ratio changes: %w", err)
+	}
+
+	var activated []ActivatedRatioChange
+	for _, userID := range userIDs {
+		change, err := s.activateRatioChange(ctx, userID, now)
+		if err != nil {
+			return activated, err
+		}
+		if change != nil {
+			activated = append(activated, *change)
+		}
+	}
+	return activated, nil
+}
+
+func (s *SettingsSnapshotService) activateRatioChange(ctx context.Context, userID uint, now time.Time) (*ActivatedRatioChange, error) {
+	var change *ActivatedRatioChange
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var user database.User
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND ratio_change_at <= ?", userID, now).
+			First(&user).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// Applied by another instance or cancelled meanwhile
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get user: %w", err)
+		}
+
+		ratios, err := DecodeRatioChange(&user)
+		if err != nil {
+			return err
+		}
+		name := "До смены коэффициентов " + format.ShortDate(*user.RatioChangeAt, UserLocation(&user), format.Default)
+		snapshot, _, err := createSnapshot(tx, userID, name)
+		if err != nil {
+			return err
+		}
+		if err := replaceRatios(tx, userID, ratios); err != nil {
+			return err
+		}
+		if err := tx.Model(&user).Updates(map[string]interface{}{
+			"ratio_change":    "",
+			"ratio_change_at": nil,
+		}).Error; err != nil {
+			return fmt.Errorf("failed to clear ratio change: %w", err)
+		}
+
+		change = &ActivatedRatioChange{User: user, Ratios: ratios, Snapshot: snapshot}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return change, nil
+}
+
+// DecodeRatioChange returns the user's scheduled ratios, nil when none is pending
+func DecodeRatioChange(user *database.User) ([]SettingsSnapshotRatio, error) {
+	if user.RatioChange == "" {
+		return nil, nil
+	}
+	var ratios []SettingsSnapshotRatio
+	if err := json.Unmarshal([]byte(user.RatioChange), &ratios); err != nil {
+		return nil, fmt.Errorf("failed to decode ratio change: %w", err)
+	}
+	return ratios, nil
+}
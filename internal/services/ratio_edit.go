@@ -0,0 +1,181 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrRatioEditSplitsPeriod is returned when an edited period would fall
+// strictly inside another period, which would have to be split in two
+var ErrRatioEditSplitsPeriod = errors.New("edited period lies inside another period")
+
+// RatioEditPlan is the set of changes that applies an edit of one ratio period
+type RatioEditPlan struct {
+	Updates []database.InsulinRatio // Changed periods, including the edited one
+	Deletes []database.InsulinRatio // Periods fully covered by the edited one
+}
+
+// PlanRatioEdit computes the changes that give the edited period new bounds
+// and ratio while keeping the schedule free of overlaps. Neighbors the new
+// period overlaps are trimmed, or deleted when it covers them completely.
+// When the period shrinks, the neighbors that touched the freed edge are
+// extended to it, so no gap appears where there was none.
+func PlanRatioEdit(existing []database.InsulinRatio, ratioID uint, startTime, endTime string, ratio float64) (*RatioEditPlan, error) {
+	var old *database.InsulinRatio
+	for i := range existing {
+		if existing[i].ID == ratioID {
+			old = &existing[i]
+		}
+	}
+	if old == nil {
+		return nil, fmt.Errorf("insulin ratio not found")
+	}
+
+	endMinute := func(end string) int {
+		return (utils.TimeToMinutes(end) + 24*60 - 1) % (24 * 60)
+	}
+	inNew := func(minute int) bool {
+		return utils.PeriodContains(startTime, endTime, minute)
+	}
+	resized := utils.PeriodsOverlap(startTime, endTime, old.StartTime, old.EndTime)
+	shrunkStart := resized && !inNew(utils.TimeToMinutes(old.StartTime))
+	shrunkEnd := resized && !inNew(endMinute(old.EndTime))
+
+	plan := &RatioEditPlan{}
+	for _, r := range existing {
+		if r.ID == ratioID {
+			continue
+		}
+		changed := r
+
+		if utils.PeriodsOverlap(startTime, endTime, r.StartTime, r.EndTime) {
+			startIn := inNew(utils.TimeToMinutes(r.StartTime))
+			endIn := inNew(endMinute(r.EndTime))
+			switch {
+			case utils.PeriodOverlapMinutes(startTime, endTime, r.StartTime, r.EndTime) == utils.PeriodMinutes(r.StartTime, r.EndTime):
+				plan.Deletes = append(plan.Deletes, r)
+				continue
+			case startIn && !endIn:
+				changed.StartTime = endTime
+			case endIn && !startIn:
+				changed.EndTime = startTime
+			default:
+				return nil, ErrRatioEditSplitsPeriod
+			}
+		} else {
+			if shrunkStart && r.EndTime == old.StartTime {
+				changed.EndTime = startTime
+			}
+			if shrunkEnd && r.StartTime == old.EndTime {
+				changed.StartTime = endTime
+			}
+		}
+
+		if changed.StartTime != r.StartTime || changed.EndTime != r.EndTime {
+			plan.Updates = append(plan.Updates, changed)
+		}
+	}
+
+	edited := *old
+	edited.StartTime = startTime
+	edited.EndTime = endTime
+	edited.Ratio = ratio
+	plan.Updates = append(plan.Updates, edited)
+
+	// The resulting schedule must be valid as a whole; unusual layouts such
+	// as periods crossing midnight on both sides are rejected here
+	var final []database.InsulinRatio
+	for _, r := range existing {
+		if planDeletes(plan, r.ID) {
+			continue
+		}
+		for _, u := range plan.Updates {
+			if u.ID == r.ID {
+				r = u
+			}
+		}
+		if err := checkRatioPeriod(final, r.StartTime, r.EndTime); err != nil {
+			return nil, err
+		}
+		final = append(final, r)
+	}
+
+	sort.Slice(plan.Updates, func(i, j int) bool {
+		return plan.Updates[i].StartTime < plan.Updates[j].StartTime
+	})
+	return plan, nil
+}
+
+// planDeletes reports whether the plan deletes the ratio
+func planDeletes(plan *RatioEditPlan, ratioID uint) bool {
+	for _, r := range plan.Deletes {
+		if r.ID == ratioID {
+			return true
+		}
+	}
+	return false
+}
+
+// DeleteIDs returns the IDs of the periods the plan deletes
+func (p *RatioEditPlan) DeleteIDs() []uint {
+	ids := make([]uint, 0, len(p.Deletes))
+	for _, r := range p.Deletes {
+		ids = append(ids, r.ID)
+	}
+	return ids
+}
+
+// ApplyChanges updates and deletes several of the user's ratios in one
+// transaction. The resulting schedule is validated as a whole, and nothing
+// is saved if any change fails or the periods would overlap.
+func (s *InsulinService) ApplyChanges(ctx context.Context, userID uint, updates []database.InsulinRatio, deletes []uint) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&database.User{}, userID).Error; err != nil {
+			return fmt.Errorf("failed to lock user: %w", err)
+		}
+
+		if len(deletes) > 0 {
+			result := tx.Where("user_id = ? AND id IN ?", userID, deletes).Delete(&database.InsulinRatio{})
+			if result.Error != nil {
+				return fmt.Errorf("failed to delete insulin ratios: %w", result.Error)
+			}
+			if result.RowsAffected != int64(len(deletes)) {
+				return fmt.Errorf("insulin ratio not found")
+			}
+		}
+
+		for _, r := range updates {
+			result := tx.Model(&database.InsulinRatio{}).
+				Where("user_id = ? AND id = ?", userID, r.ID).
+				Updates(map[string]interface{}{
+					"start_time": r.StartTime,
+					"end_time":   r.EndTime,
+					"ratio":      r.Ratio,
+				})
+			if result.Error != nil {
+				return fmt.Errorf("failed to update insulin ratio: %w", result.Error)
+			}
+			if result.RowsAffected == 0 {
+				return fmt.Errorf("insulin ratio not found")
+			}
+		}
+
+		var ratios []database.InsulinRatio
+		if err := tx.Where("user_id = ?", userID).Find(&ratios).Error; err != nil {
+			return fmt.Errorf("failed to check updated ratios: %w", err)
+		}
+		for i, r := range ratios {
+			if err := checkRatioPeriod(ratios[:i], r.StartTime, r.EndTime); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,69 @@
+package services
+
+// RatioPresetPeriod is a single time period of a ratio preset
+type RatioPresetPeriod struct {
+	StartTime string
+	EndTime   string
+	Ratio     float64
+}
+
+// RatioPreset is a full 24h schedule of placeholder coefficients that users can
+// start from and then personalize together with their doctor
+type RatioPreset struct {
+	ID          string
+	Name        string
+	Description string
+	Periods     []RatioPresetPeriod
+}
+
+// RatioPresets are the built-in starting schedules. The values are typical
+// placeholders, not medical advice.
+var RatioPresets = []RatioPreset{
+	{
+		ID:          "adult_t1",
+		Name:        "Типичный взрослый Т1",
+		Description: "Повышенная потребность утром, ровная днем и вечером",
+		Periods: []RatioPresetPeriod{
+			{StartTime: "00:00", EndTime: "06:00", Ratio: 1.0},
+			{StartTime: "06:00", EndTime: "11:00", Ratio: 1.5},
+			{StartTime: "11:00", EndTime: "16:00", Ratio: 1.2},
+			{StartTime: "16:00", EndTime: "21:00", Ratio: 1.3},
+			{StartTime: "21:00", EndTime: "00:00", Ratio: 1.0},
+		},
+	},
+	{
+		ID:          "child",
+		Name:        "Ребёнок",
+		Description: "Низкие коэффициенты, выше всего утром",
+		Periods: []RatioPresetPeriod{
+			{StartTime: "00:00", EndTime: "06:00", Ratio: 0.5},
+			{StartTime: "06:00", EndTime: "11:00", Ratio: 1.0},
+			{StartTime: "11:00", EndTime: "16:00", Ratio: 0.7},
+			{StartTime: "16:00", EndTime: "21:00", Ratio: 0.8},
+			{StartTime: "21:00", EndTime: "00:00", Ratio: 0.5},
+		},
+	},
+	{
+		ID:          "pump",
+		Name:        "Помпа",
+		Description: "Более дробное расписание с учетом феномена утренней зари",
+		Periods: []RatioPresetPeriod{
+			{StartTime: "00:00", EndTime: "04:00", Ratio: 0.8},
+			{StartTime: "04:00", EndTime: "08:00", Ratio: 1.2},
+			{StartTime: "08:00", EndTime: "12:00", Ratio: 1.4},
+			{StartTime: "12:00", EndTime: "17:00", Ratio: 1.1},
+			{StartTime: "17:00", EndTime: "21:00", Ratio: 1.2},
+			{StartTime: "21:00", EndTime: "00:00", Ratio: 0.9},
+		},
+	},
+}
+
+// GetRatioPreset returns a built-in preset by its ID
+func GetRatioPreset(id string) (RatioPreset, bool) {
+	for _, p := range RatioPresets {
+		if p.ID == id {
+			return p, true
+		}
+	}
+	return RatioPreset{}, false
+}
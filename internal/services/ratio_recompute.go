@@ -0,0 +1,88 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/dosing"
+	"github.com/vladimiradmaev/diabetes-helper/internal/utils"
+)
+
+// RatioRecomputeReport summarizes a pass over historical analyses
+type RatioRecomputeReport struct {
+	Checked    int // Analyses of users with a timezone that were examined
+	Mismatched int // Stored ratio matches server time but not the user's local time
+	Updated    int // Mismatched analyses rewritten (only when applying)
+	Ambiguous  int // Stored ratio matches neither period, e.g. the schedule changed since
+}
+
+// RecomputeHistoricalRatios finds analyses whose insulin ratio was picked by the
+// server clock before timezones were supported. An analysis is considered
+// mismatched only when its stored ratio equals the current schedule's ratio for
+// server time and differs from the ratio for the user's local time; anything
+// else is left alone. When apply is false nothing is written. When apply is
+// true mismatched rows get the local ratio and recomputed insulin units, and
+// the previous ratio is kept in OriginalInsulinRatio. Already recomputed rows
+// are skipped, so the pass is safe to repeat.
+func (s *FoodAnalysisService) RecomputeHistoricalRatios(ctx context.Context, serverLoc *time.Location, apply bool) (*RatioRecomputeReport, error) {
+	var users []database.User
+	if err := s.db.WithContext(ctx).Where("timezone <> ''").Find(&users).Error; err != nil {
+		return nil, fmt.Errorf("failed to get users: %w", err)
+	}
+
+	report := &RatioRecomputeReport{}
+	for _, user := range users {
+		userLoc := utils.LoadLocation(user.Timezone)
+
+		var ratios []database.InsulinRatio
+		if err := s.db.WithContext(ctx).Where("user_id = ?", user.ID).Find(&ratios).Error; err != nil {
+			return nil, fmt.Errorf("failed to get insulin ratios: %w", err)
+		}
+		if len(ratios) == 0 {
+			continue
+		}
+
+		var analyses []database.FoodAnalysis
+		if err := s.db.WithContext(ctx).
+			Where("user_id = ? AND ratio_recomputed_at IS NULL", user.ID).
+			Find(&analyses).Error; err != nil {
+			return nil, fmt.Errorf("failed to get analyses: %w", err)
+		}
+
+		for _, a := range analyses {
+			report.Checked++
+
+			localRatio := ratioAt(ratios, a.CreatedAt.In(userLoc))
+			if localRatio == a.InsulinRatio {
+				continue
+			}
+			if a.InsulinRatio != ratioAt(ratios, a.CreatedAt.In(serverLoc)) || localRatio == 0 {
+				report.Ambiguous++
+				continue
+			}
+			report.Mismatched++
+			if !apply {
+				continue
+			}
+
+			original := a.InsulinRatio
+			now := time.Now()
+			if err := s.db.WithContext(ctx).
+				Model(&database.FoodAnalysis{}).
+				Where("id = ? AND ratio_recomputed_at IS NULL", a.ID).
+				Updates(map[string]interface{}{
+					"insulin_ratio":          localRatio,
+					"insulin_units":          dosing.ToDeciUnits(a.BreadUnits * localRatio),
+					"original_insulin_ratio": original,
+					"ratio_recomputed_at":    now,
+				}).Error; err != nil {
+				return nil, fmt.Errorf("failed to update analysis %d: %w", a.ID, err)
+			}
+			report.Updated++
+		}
+	}
+
+	return report, nil
+}
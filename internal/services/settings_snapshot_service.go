@@ -0,0 +1,322 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/dosing"
+	"gorm.io/gorm"
+)
+
+// MaxSettingsSnapshots is how many snapshots a user keeps; saving more
+// removes the oldest ones
+const MaxSettingsSnapshots = 10
+
+// settingsSnapshotVersion is bumped whenever SettingsSnapshotData changes shape
+const settingsSnapshotVersion = 4
+
+// SettingsSnapshotRatio is one ratio period in a snapshot
+type SettingsSnapshotRatio struct {
+	StartTime string  `json:"start_time"`
+	EndTime   string  `json:"end_time"`
+	Ratio     float64 `json:"ratio"`
+}
+
+// SettingsSnapshotBasal is one basal rate period in a snapshot
+type SettingsSnapshotBasal struct {
+	StartTime string  `json:"start_time"`
+	EndTime   string  `json:"end_time"`
+	Rate      float64 `json:"rate"`
+}
+
+// SettingsSnapshotData is everything a snapshot restores. New settings must be
+// added here (and to captureSettings and snapshotUpdates) so snapshots stay
+// complete.
+type SettingsSnapshotData struct {
+	Version           int                     `json:"version"`
+	Ratios            []SettingsSnapshotRatio `json:"ratios"`
+	GlucoseUnit       string                  `json:"glucose_unit"`
+	DailyCarbTarget   float64                 `json:"daily_carb_target"`
+	Timezone          string                  `json:"timezone"`
+	ActiveInsulinTime int                     `json:"active_insulin_time"`
+	ResultVerbosity   string                  `json:"result_verbosity,omitempty"` // Since version 2
+	IOBModel          string                  `json:"iob_model,omitempty"`        // Since version 3
+
+	// Since version 4; older snapshots leave these settings as they are
+	BasalRates         []SettingsSnapshotBasal `json:"basal_rates,omitempty"`
+	CorrectionFactor   float64                 `json:"correction_factor,omitempty"`
+	TargetBloodSugar   float64                 `json:"target_blood_sugar,omitempty"`
+	LowRuleGrams       float64                 `json:"low_rule_grams,omitempty"`
+	LowRuleRise        float64                 `json:"low_rule_rise,omitempty"`
+	LowTarget          float64                 `json:"low_target,omitempty"`
+	BreadUnitStep      float64                 `json:"bread_unit_step,omitempty"`
+	CarbsStep          float64                 `json:"carbs_step,omitempty"`
+	MeasurementSystem  string                  `json:"measurement_system,omitempty"`
+	HideBolusTiming    bool                    `json:"hide_bolus_timing,omitempty"`
+	KeepPrompts        bool                    `json:"keep_prompts,omitempty"`
+	ArchivePhotos      bool                    `json:"archive_photos,omitempty"`
+	ReanalyzeOnPrimary bool                    `json:"reanalyze_on_primary,omitempty"`
+	QuietHoursStart    string                  `json:"quiet_hours_start,omitempty"`
+	QuietHoursEnd      string                  `json:"quiet_hours_end,omitempty"`
+	BasalReminderTime  string                  `json:"basal_reminder_time,omitempty"`
+	BasalReminderUnits float64                 `json:"basal_reminder_units,omitempty"` // Units
+}
+
+type SettingsSnapshotService struct {
+	db *gorm.DB
+}
+
+func NewSettingsSnapshotService(db *gorm.DB) *SettingsSnapshotService {
+	return &SettingsSnapshotService{db: db}
+}
+
+// CreateSnapshot stores the user's current settings under a name, dropping the
+// oldest snapshots beyond MaxSettingsSnapshots. It returns how many were dropped.
+func (s *SettingsSnapshotService) CreateSnapshot(ctx context.Context, userID uint, name string) (*database.SettingsSnapshot, int, error) {
+	var snapshot *database.SettingsSnapshot
+	dropped := 0
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		snapshot, dropped, err = createSnapshot(tx, userID, name)
+		return err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return snapshot, dropped, nil
+}
+
+// createSnapshot stores the user's current settings in tx, see CreateSnapshot
+func createSnapshot(tx *gorm.DB, userID uint, name string) (*database.SettingsSnapshot, int, error) {
+	data, err := captureSettings(tx, userID)
+	if err != nil {
+		return nil, 0, err
+	}
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to encode settings snapshot: %w", err)
+	}
+
+	snapshot := &database.SettingsSnapshot{UserID: userID, Name: name, Data: string(encoded)}
+	if err := tx.Create(snapshot).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to create settings snapshot: %w", err)
+	}
+
+	var stale []uint
+	if err := tx.Model(&database.SettingsSnapshot{}).
+		Where("user_id = ?", userID).
+		Order("created_at DESC, id DESC").
+		Offset(MaxSettingsSnapshots).
+		Pluck("id", &stale).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to find old settings snapshots: %w", err)
+	}
+	if len(stale) > 0 {
+		if err := tx.Delete(&database.SettingsSnapshot{}, stale).Error; err != nil {
+			return nil, 0, fmt.Errorf("failed to delete old settings snapshots: %w", err)
+		}
+	}
+	return snapshot, len(stale), nil
+}
+
+// ListSnapshots returns the user's snapshots, newest first
+func (s *SettingsSnapshotService) ListSnapshots(ctx context.Context, userID uint) ([]database.SettingsSnapshot, error) {
+	var snapshots []database.SettingsSnapshot
+	if err := s.db.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Order("created_at DESC, id DESC").
+		Find(&snapshots).Error; err != nil {
+		return nil, fmt.Errorf("failed to list settings snapshots: %w", err)
+	}
+	return snapshots, nil
+}
+
+// GetSnapshot returns one of the user's snapshots
+func (s *SettingsSnapshotService) GetSnapshot(ctx context.Context, userID, snapshotID uint) (*database.SettingsSnapshot, error) {
+	var snapshot database.SettingsSnapshot
+	if err := s.db.WithContext(ctx).
+		Where("id = ? AND user_id = ?", snapshotID, userID).
+		First(&snapshot).Error; err != nil {
+		return nil, fmt.Errorf("failed to get settings snapshot: %w", err)
+	}
+	return &snapshot, nil
+}
+
+// RestoreSnapshot replaces the user's current settings with the snapshot's in a
+// single transaction
+func (s *SettingsSnapshotService) RestoreSnapshot(ctx context.Context, userID, snapshotID uint) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var snapshot database.SettingsSnapshot
+		if err := tx.Where("id = ? AND user_id = ?", snapshotID, userID).First(&snapshot).Error; err != nil {
+			return fmt.Errorf("failed to get settings snapshot: %w", err)
+		}
+
+		var data SettingsSnapshotData
+		if err := json.Unmarshal([]byte(snapshot.Data), &data); err != nil {
+			return fmt.Errorf("failed to decode settings snapshot: %w", err)
+		}
+
+		if err := replaceRatios(tx, userID, data.Ratios); err != nil {
+			return err
+		}
+		if data.Version >= 4 {
+			if err := replaceBasalRates(tx, userID, data.BasalRates); err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(&database.User{}).Where("id = ?", userID).Updates(snapshotUpdates(&data)).Error; err != nil {
+			return fmt.Errorf("failed to restore user settings: %w", err)
+		}
+		return nil
+	})
+}
+
+// snapshotUpdates returns the user columns a snapshot restores. Settings
+// added after the snapshot's version are left out, so they keep their values.
+func snapshotUpdates(data *SettingsSnapshotData) map[string]interface{} {
+	glucoseUnit := data.GlucoseUnit
+	if glucoseUnit == "" {
+		glucoseUnit = GlucoseUnitMmol
+	}
+	resultVerbosity := data.ResultVerbosity
+	if resultVerbosity == "" {
+		resultVerbosity = ResultVerbosityFull
+	}
+	iobModel := data.IOBModel
+	if iobModel == "" {
+		iobModel = dosing.IOBLinear
+	}
+	updates := map[string]interface{}{
+		"glucose_unit":        glucoseUnit,
+		"result_verbosity":    resultVerbosity,
+		"iob_model":           iobModel,
+		"daily_carb_target":   data.DailyCarbTarget,
+		"timezone":            data.Timezone,
+		"active_insulin_time": data.ActiveInsulinTime,
+	}
+	if data.Version >= 4 {
+		updates["correction_factor"] = data.CorrectionFactor
+		updates["target_blood_sugar"] = data.TargetBloodSugar
+		updates["low_rule_grams"] = data.LowRuleGrams
+		updates["low_rule_rise"] = data.LowRuleRise
+		updates["low_target"] = data.LowTarget
+		updates["bread_unit_step"] = data.BreadUnitStep
+		updates["carbs_step"] = data.CarbsStep
+		updates["measurement_system"] = data.MeasurementSystem
+		updates["hide_bolus_timing"] = data.HideBolusTiming
+		updates["keep_prompts"] = data.KeepPrompts
+		updates["archive_photos"] = data.ArchivePhotos
+		updates["reanalyze_on_primary"] = data.ReanalyzeOnPrimary
+		updates["quiet_hours_start"] = data.QuietHoursStart
+		updates["quiet_hours_end"] = data.QuietHoursEnd
+		updates["basal_reminder_time"] = data.BasalReminderTime
+		updates["basal_reminder_units"] = dosing.ToDeciUnits(data.BasalReminderUnits)
+	}
+	return updates
+}
+
+// replaceRatios replaces all of the user's ratios in tx
+func replaceRatios(tx *gorm.DB, userID uint, ratios []SettingsSnapshotRatio) error {
+	if err := tx.Where("user_id = ?", userID).Delete(&database.InsulinRatio{}).Error; err != nil {
+		return fmt.Errorf("failed to delete existing ratios: %w", err)
+	}
+	for _, r := range ratios {
+		ratio := &database.InsulinRatio{
+			UserID:    userID,
+			StartTime: r.StartTime,
+			EndTime:   r.EndTime,
+			Ratio:     r.Ratio,
+		}
+		if err := tx.Create(ratio).Error; err != nil {
+			return fmt.Errorf("failed to create insulin ratio: %w", err)
+		}
+	}
+	return nil
+}
+
+// replaceBasalRates replaces all of the user's basal rates in tx
+func replaceBasalRates(tx *gorm.DB, userID uint, rates []SettingsSnapshotBasal) error {
+	if err := tx.Where("user_id = ?", userID).Delete(&database.BasalRate{}).Error; err != nil {
+		return fmt.Errorf("failed to delete basal rates: %w", err)
+	}
+	for _, r := range rates {
+		rate := &database.BasalRate{UserID: userID, StartTime: r.StartTime, EndTime: r.EndTime, Rate: r.Rate}
+		if err := tx.Create(rate).Error; err != nil {
+			return fmt.Errorf("failed to create basal rate: %w", err)
+		}
+	}
+	return nil
+}
+
+// DecodeSnapshot parses a snapshot's settings, e.g. for previews or exports
+func DecodeSnapshot(snapshot *database.SettingsSnapshot) (*SettingsSnapshotData, error) {
+	var data SettingsSnapshotData
+	if err := json.Unmarshal([]byte(snapshot.Data), &data); err != nil {
+		return nil, fmt.Errorf("failed to decode settings snapshot: %w", err)
+	}
+	return &data, nil
+}
+
+func captureSettings(tx *gorm.DB, userID uint) (*SettingsSnapshotData, error) {
+	var user database.User
+	if err := tx.First(&user, userID).Error; err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	var ratios []database.InsulinRatio
+	if err := tx.Where("user_id = ?", userID).Order("start_time").Find(&ratios).Error; err != nil {
+		return nil, fmt.Errorf("failed to get insulin ratios: %w", err)
+	}
+	var rates []database.BasalRate
+	if err := tx.Where("user_id = ?", userID).Order("start_time").Find(&rates).Error; err != nil {
+		return nil, fmt.Errorf("failed to get basal rates: %w", err)
+	}
+	return snapshotOf(&user, ratios, rates), nil
+}
+
+// snapshotOf builds the snapshot of a user's settings
+func snapshotOf(user *database.User, ratios []database.InsulinRatio, rates []database.BasalRate) *SettingsSnapshotData {
+	data := &SettingsSnapshotData{
+		Version:            settingsSnapshotVersion,
+		Ratios:             make([]SettingsSnapshotRatio, 0, len(ratios)),
+		GlucoseUnit:        user.GlucoseUnit,
+		DailyCarbTarget:    user.DailyCarbTarget,
+		Timezone:           user.Timezone,
+		ActiveInsulinTime:  user.ActiveInsulinTime,
+		ResultVerbosity:    user.ResultVerbosity,
+		IOBModel:           user.IOBModel,
+		CorrectionFactor:   user.CorrectionFactor,
+		TargetBloodSugar:   user.TargetBloodSugar,
+		LowRuleGrams:       user.LowRuleGrams,
+		LowRuleRise:        user.LowRuleRise,
+		LowTarget:          user.LowTarget,
+		BreadUnitStep:      user.BreadUnitStep,
+		CarbsStep:          user.CarbsStep,
+		MeasurementSystem:  user.MeasurementSystem,
+		HideBolusTiming:    user.HideBolusTiming,
+		KeepPrompts:        user.KeepPrompts,
+		ArchivePhotos:      user.ArchivePhotos,
+		ReanalyzeOnPrimary: user.ReanalyzeOnPrimary,
+		QuietHoursStart:    user.QuietHoursStart,
+		QuietHoursEnd:      user.QuietHoursEnd,
+		BasalReminderTime:  user.BasalReminderTime,
+		BasalReminderUnits: user.BasalReminderUnits.Units(),
+	}
+	for _, r := range ratios {
+		data.Ratios = append(data.Ratios, SettingsSnapshotRatio{
+			StartTime: r.StartTime,
+			EndTime:   r.EndTime,
+			Ratio:     r.Ratio,
+		})
+	}
+	for _, r := range rates {
+		data.BasalRates = append(data.BasalRates, SettingsSnapshotBasal{
+			StartTime: r.StartTime,
+			EndTime:   r.EndTime,
+			Rate:      r.Rate,
+		})
+	}
+	return data
+}
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/dosing"
+	"gorm.io/gorm/schema"
+)
+
+// notInSnapshot lists the user columns a snapshot leaves alone on purpose:
+// identity, temporary state and schedules. Any other new column must be
+// added to the snapshot.
+var notInSnapshot = map[string]bool{
+	"id": true, "created_at": true, "updated_at": true, "deleted_at": true,
+	"telegram_id": true, "username": true, "first_name": true, "last_name": true,
+	"travel_timezone": true, "travel_until": true,
+	"ratio_change": true, "ratio_change_at": true,
+	"notifications_paused": true, "low_recheck_at": true,
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	user := &database.User{
+		ActiveInsulinTime:  240,
+		GlucoseUnit:        GlucoseUnitMgdl,
+		DailyCarbTarget:    180,
+		Timezone:           "Europe/Moscow",
+		ResultVerbosity:    ResultVerbosityCompact,
+		ArchivePhotos:      true,
+		IOBModel:           dosing.IOBCurved,
+		KeepPrompts:        true,
+		HideBolusTiming:    true,
+		BreadUnitStep:      0.5,
+		CarbsStep:          5,
+		MeasurementSystem:  "imperial",
+		ReanalyzeOnPrimary: true,
+		BasalReminderTime:  "22:00",
+		BasalReminderUnits: dosing.ToDeciUnits(14.5),
+		QuietHoursStart:    "23:00",
+		QuietHoursEnd:      "07:00",
+		LowRuleGrams:       15,
+		LowRuleRise:        2.5,
+		LowTarget:          6,
+		CorrectionFactor:   2.2,
+		TargetBloodSugar:   6.5,
+	}
+	ratios := []database.InsulinRatio{{StartTime: "06:00", EndTime: "12:00", Ratio: 1.5}, {StartTime: "12:00", EndTime: "06:00", Ratio: 1}}
+	rates := []database.BasalRate{{StartTime: "00:00", EndTime: "00:00", Rate: 0.85}}
+
+	encoded, err := json.Marshal(snapshotOf(user, ratios, rates))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data SettingsSnapshotData
+	if err := json.Unmarshal(encoded, &data); err != nil {
+		t.Fatal(err)
+	}
+
+	wantRatios := []SettingsSnapshotRatio{{"06:00", "12:00", 1.5}, {"12:00", "06:00", 1}}
+	if !reflect.DeepEqual(data.Ratios, wantRatios) {
+		t.Errorf("ratios = %v, want %v", data.Ratios, wantRatios)
+	}
+	wantRates := []SettingsSnapshotBasal{{"00:00", "00:00", 0.85}}
+	if !reflect.DeepEqual(data.BasalRates, wantRates) {
+		t.Errorf("basal rates = %v, want %v", data.BasalRates, wantRates)
+	}
+
+	updates := snapshotUpdates(&data)
+	naming := schema.NamingStrategy{}
+	userValue := reflect.ValueOf(user).Elem()
+	for i := 0; i < userValue.NumField(); i++ {
+		field := userValue.Type().Field(i)
+		column := naming.ColumnName("", field.Name)
+		if notInSnapshot[column] {
+			if _, ok := updates[column]; ok {
+				t.Errorf("%s is restored, but listed as not in snapshots", column)
+			}
+			continue
+		}
+		restored, ok := updates[column]
+		if !ok {
+			t.Errorf("%s is not restored; add it to the snapshot or to notInSnapshot", column)
+			continue
+		}
+		if want := userValue.Field(i).Interface(); !reflect.DeepEqual(restored, want) {
+			t.Errorf("%s restored as %v, want %v", column, restored, want)
+		}
+	}
+}
+
+func TestSnapshotUpdatesOldVersion(t *testing.T) {
+	// A version 3 snapshot was saved before the newer settings existed
+	data := SettingsSnapshotData{Version: 3, Timezone: "Europe/Moscow", ActiveInsulinTime: 180}
+	updates := snapshotUpdates(&data)
+	for _, column := range []string{"correction_factor", "low_rule_grams", "quiet_hours_start", "basal_reminder_time"} {
+		if _, ok := updates[column]; ok {
+			t.Errorf("version 3 snapshot restores %s", column)
+		}
+	}
+	want := map[string]interface{}{
+		"glucose_unit":        GlucoseUnitMmol,
+		"result_verbosity":    ResultVerbosityFull,
+		"iob_model":           dosing.IOBLinear,
+		"daily_carb_target":   0.0,
+		"timezone":            "Europe/Moscow",
+		"active_insulin_time": 180,
+	}
+	if !reflect.DeepEqual(updates, want) {
+		t.Errorf("snapshotUpdates = %v, want %v", updates, want)
+	}
+}
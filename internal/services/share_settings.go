@@ -0,0 +1,108 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/dosing"
+	"github.com/vladimiradmaev/diabetes-helper/internal/sharecode"
+	"gorm.io/gorm"
+)
+
+// SharedSettingsSnapshotName names the snapshot saved before shared settings
+// are applied
+const SharedSettingsSnapshotName = "До импорта настроек"
+
+// ShareCode returns a code with the user's ratio schedule, basal profile,
+// active insulin settings, correction, low treatment rule and dose rounding
+func (s *SettingsSnapshotService) ShareCode(ctx context.Context, userID uint) (string, error) {
+	var user database.User
+	if err := s.db.WithContext(ctx).First(&user, userID).Error; err != nil {
+		return "", fmt.Errorf("failed to get user: %w", err)
+	}
+	var ratios []database.InsulinRatio
+	if err := s.db.WithContext(ctx).Where("user_id = ?", userID).Order("start_time").Find(&ratios).Error; err != nil {
+		return "", fmt.Errorf("failed to get insulin ratios: %w", err)
+	}
+	var rates []database.BasalRate
+	if err := s.db.WithContext(ctx).Where("user_id = ?", userID).Order("start_time").Find(&rates).Error; err != nil {
+		return "", fmt.Errorf("failed to get basal rates: %w", err)
+	}
+
+	settings := &sharecode.Settings{
+		ActiveInsulinTime: user.ActiveInsulinTime,
+		IOBModel:          user.IOBModel,
+		CorrectionFactor:  user.CorrectionFactor,
+		TargetBloodSugar:  user.TargetBloodSugar,
+		LowRuleGrams:      user.LowRuleGrams,
+		LowRuleRise:       user.LowRuleRise,
+		LowTarget:         user.LowTarget,
+		BreadUnitStep:     user.BreadUnitStep,
+		CarbsStep:         user.CarbsStep,
+	}
+	for _, r := range ratios {
+		settings.Ratios = append(settings.Ratios, sharecode.Period{StartTime: r.StartTime, EndTime: r.EndTime, Value: r.Ratio})
+	}
+	for _, r := range rates {
+		settings.BasalRates = append(settings.BasalRates, sharecode.Period{StartTime: r.StartTime, EndTime: r.EndTime, Value: r.Rate})
+	}
+	return sharecode.Encode(settings), nil
+}
+
+// ApplySharedSettings replaces the user's dosing setup with shared settings in
+// a single transaction; settings a version 1 code doesn't carry are kept. The
+// settings before are saved as a snapshot first, which is returned for
+// rollback.
+func (s *SettingsSnapshotService) ApplySharedSettings(ctx context.Context, userID uint, settings *sharecode.Settings) (*database.SettingsSnapshot, error) {
+	var snapshot *database.SettingsSnapshot
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		snapshot, _, err = createSnapshot(tx, userID, SharedSettingsSnapshotName)
+		if err != nil {
+			return err
+		}
+
+		ratios := make([]SettingsSnapshotRatio, 0, len(settings.Ratios))
+		for _, p := range settings.Ratios {
+			ratios = append(ratios, SettingsSnapshotRatio{StartTime: p.StartTime, EndTime: p.EndTime, Ratio: p.Value})
+		}
+		if err := replaceRatios(tx, userID, ratios); err != nil {
+			return err
+		}
+
+		rates := make([]SettingsSnapshotBasal, 0, len(settings.BasalRates))
+		for _, p := range settings.BasalRates {
+			rates = append(rates, SettingsSnapshotBasal{StartTime: p.StartTime, EndTime: p.EndTime, Rate: p.Value})
+		}
+		if err := replaceBasalRates(tx, userID, rates); err != nil {
+			return err
+		}
+
+		iobModel := settings.IOBModel
+		if iobModel == "" {
+			iobModel = dosing.IOBLinear
+		}
+		updates := map[string]interface{}{
+			"active_insulin_time": settings.ActiveInsulinTime,
+			"iob_model":           iobModel,
+		}
+		if settings.Version >= 2 {
+			updates["correction_factor"] = settings.CorrectionFactor
+			updates["target_blood_sugar"] = settings.TargetBloodSugar
+			updates["low_rule_grams"] = settings.LowRuleGrams
+			updates["low_rule_rise"] = settings.LowRuleRise
+			updates["low_target"] = settings.LowTarget
+			updates["bread_unit_step"] = settings.BreadUnitStep
+			updates["carbs_step"] = settings.CarbsStep
+		}
+		if err := tx.Model(&database.User{}).Where("id = ?", userID).Updates(updates).Error; err != nil {
+			return fmt.Errorf("failed to update user settings: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
@@ -0,0 +1,206 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/dosing"
+	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+	"github.com/vladimiradmaev/diabetes-helper/internal/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// Target range for time-in-range, mmol/L
+	timeInRangeLow  = 3.9
+	timeInRangeHigh = 10.0
+)
+
+// StatsAggregationService precomputes daily per-user aggregates so that
+// statistics don't have to scan the whole history on every request
+type StatsAggregationService struct {
+	db *gorm.DB
+}
+
+func NewStatsAggregationService(db *gorm.DB) *StatsAggregationService {
+	return &StatsAggregationService{db: db}
+}
+
+// RunDaily stores the summary of the previous local day for every user that
+// doesn't have one yet. It is safe to run repeatedly: users are processed once
+// their local midnight has passed and already stored days are skipped.
+func (s *StatsAggregationService) RunDaily(ctx context.Context) error {
+	var users []database.User
+	if err := s.db.WithContext(ctx).Find(&users).Error; err != nil {
+		return fmt.Errorf("failed to get users: %w", err)
+	}
+
+	now := time.Now()
+	stored := 0
+	for _, user := range users {
+		loc := UserLocation(&user)
+		todayStart, _ := utils.DayBounds(now, loc)
+		yesterdayStart, _ := utils.DayBounds(todayStart.Add(-time.Hour), loc)
+
+		var count int64
+		if err := s.db.WithContext(ctx).
+			Model(&database.DailySummary{}).
+			Where("user_id = ? AND day = ?", user.ID, summaryDay(yesterdayStart)).
+			Count(&count).Error; err != nil {
+			return fmt.Errorf("failed to check daily summary: %w", err)
+		}
+		if count > 0 {
+			continue
+		}
+
+		summary, err := s.computeDay(ctx, &user, yesterdayStart, todayStart)
+		if err != nil {
+			return err
+		}
+		if err := s.db.WithContext(ctx).
+			Clauses(clause.OnConflict{DoNothing: true}).
+			Create(summary).Error; err != nil {
+			return fmt.Errorf("failed to save daily summary: %w", err)
+		}
+		stored++
+	}
+
+	if stored > 0 {
+		logger.Info("Daily summaries aggregated", "count", stored)
+	}
+	return nil
+}
+
+// GetDailySummaries returns summaries for the last `days` local days including today,
+// newest first. Past days are read from the table; today and any day the job
+// hasn't processed yet are computed on the fly.
+func (s *StatsAggregationService) GetDailySummaries(ctx context.Context, userID uint, days int) ([]database.DailySummary, error) {
+	var user database.User
+	if err := s.db.WithContext(ctx).First(&user, userID).Error; err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	loc := UserLocation(&user)
+
+	todayStart, tomorrowStart := utils.DayBounds(time.Now(), loc)
+	firstDay := todayStart.AddDate(0, 0, -(days - 1))
+
+	var stored []database.DailySummary
+	if err := s.db.WithContext(ctx).
+		Where("user_id = ? AND day >= ?", userID, summaryDay(firstDay)).
+		Find(&stored).Error; err != nil {
+		return nil, fmt.Errorf("failed to get daily summaries: %w", err)
+	}
+	byDay := make(map[string]database.DailySummary, len(stored))
+	for _, summary := range stored {
+		byDay[summary.Day.Format("2006-01-02")] = summary
+	}
+
+	summaries := make([]database.DailySummary, 0, days)
+	dayEnd := tomorrowStart
+	for dayStart := todayStart; !dayStart.Before(firstDay); {
+		key := dayStart.Format("2006-01-02")
+		if summary, ok := byDay[key]; ok && !dayStart.Equal(todayStart) {
+			summaries = append(summaries, summary)
+		} else {
+			summary, err := s.computeDay(ctx, &user, dayStart, dayEnd)
+			if err != nil {
+				return nil, err
+			}
+			summaries = append(summaries, *summary)
+		}
+		dayEnd = dayStart
+		dayStart, _ = utils.DayBounds(dayStart.Add(-time.Hour), loc)
+	}
+
+	return summaries, nil
+}
+
+// computeDay aggregates readings and analyses in [start, end)
+func (s *StatsAggregationService) computeDay(ctx context.Context, user *database.User, start, end time.Time) (*database.DailySummary, error) {
+	summary := &database.DailySummary{
+		UserID:     user.ID,
+		Day:        summaryDay(start),
+		CarbTarget: user.DailyCarbTarget,
+	}
+
+	var readings []database.BloodSugarRecord
+	if err := s.db.WithContext(ctx).
+		Where("user_id = ? AND timestamp >= ? AND timestamp < ?", user.ID, start, end).
+		Find(&readings).Error; err != nil {
+		return nil, fmt.Errorf("failed to get blood sugar records: %w", err)
+	}
+
+	if len(readings) > 0 {
+		var sum float64
+		inRange := 0
+		summary.MinGlucose = readings[0].Value
+		summary.MaxGlucose = readings[0].Value
+		for _, r := range readings {
+			sum += r.Value
+			if r.Value < summary.MinGlucose {
+				summary.MinGlucose = r.Value
+			}
+			if r.Value > summary.MaxGlucose {
+				summary.MaxGlucose = r.Value
+			}
+			if r.Value >= timeInRangeLow && r.Value <= timeInRangeHigh {
+				inRange++
+			}
+		}
+		summary.ReadingsCount = len(readings)
+		summary.MeanGlucose = sum / float64(len(readings))
+		summary.TimeInRange = float64(inRange) / float64(len(readings))
+	}
+
+	var carbs struct {
+		Count int
+		Total float64
+	}
+	if err := s.db.WithContext(ctx).
+		Model(&database.FoodAnalysis{}).
+		Where("user_id = ? AND created_at >= ? AND created_at < ?", user.ID, start, end).
+		Select("COUNT(*) AS count, COALESCE(SUM(carbs), 0) AS total").
+		Scan(&carbs).Error; err != nil {
+		return nil, fmt.Errorf("failed to aggregate carbs: %w", err)
+	}
+	summary.AnalysesCount = carbs.Count
+	summary.CarbsTotal = carbs.Total
+
+	var insulin []struct {
+		Kind  string
+		Total dosing.DeciUnits
+	}
+	if err := s.db.WithContext(ctx).
+		Model(&database.Injection{}).
+		Where("user_id = ? AND timestamp >= ? AND timestamp < ?", user.ID, start, end).
+		Group("kind").
+		Select("kind, COALESCE(SUM(units), 0)::BIGINT AS total").
+		Scan(&insulin).Error; err != nil {
+		return nil, fmt.Errorf("failed to aggregate injections: %w", err)
+	}
+	for _, row := range insulin {
+		if row.Kind == InjectionKindBasal {
+			summary.BasalTotal += row.Total
+		} else {
+			summary.BolusTotal += row.Total
+		}
+	}
+
+	// Pump users don't log basal: their schedule delivers it
+	var rates []database.BasalRate
+	if err := s.db.WithContext(ctx).Where("user_id = ?", user.ID).Find(&rates).Error; err != nil {
+		return nil, fmt.Errorf("failed to get basal rates: %w", err)
+	}
+	summary.BasalTotal += DailyBasal(rates)
+
+	return summary, nil
+}
+
+// summaryDay normalizes a local day start to a UTC date so the DATE column
+// stores the user's calendar day regardless of the session timezone
+func summaryDay(dayStart time.Time) time.Time {
+	return time.Date(dayStart.Year(), dayStart.Month(), dayStart.Day(), 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/dosing"
+	"github.com/vladimiradmaev/diabetes-helper/internal/format"
+	"gorm.io/gorm"
+)
+
+// Audit actions
+const (
+	AuditActionSupportView      = "support_view"
+	AuditActionAccountTransfer  = "account_transfer"
+	AuditActionUserMerge        = "user_merge"
+	AuditActionConversationView = "conversation_view"
+)
+
+// supportAnalysesLimit is how many recent analyses a support view includes
+const supportAnalysesLimit = 5
+
+// ErrUserNotFound is returned when no user has the requested Telegram ID
+var ErrUserNotFound = errors.New("user not found")
+
+// SupportAnalysis is a recent analysis with its dose breakdown. Free text
+// (the AI description, captions) is left out on purpose.
+type SupportAnalysis struct {
+	ID                   uint
+	CreatedAt            string // In the user's timezone, "02.01 15:04"
+	Weight               float64
+	Carbs                float64
+	Confidence           float64
+	UsedProvider         string
+	Dose                 dosing.Result
+	LoggedUnits          dosing.DeciUnits // Dose saved with the analysis
+	OriginalInsulinRatio *float64         // Ratio before a schedule recompute, nil if never recomputed
+}
+
+// SupportSnapshot is a read-only view of a user's dosing setup for support
+type SupportSnapshot struct {
+	User       database.User
+	Ratios     []database.InsulinRatio
+	BasalRates []database.BasalRate
+	Analyses   []SupportAnalysis
+}
+
+// SupportService lets admins look at a user's configuration when they
+// report a problem. Every lookup is written to the audit log.
+type SupportService struct {
+	db *gorm.DB
+}
+
+func NewSupportService(db *gorm.DB) *SupportService {
+	return &SupportService{db: db}
+}
+
+// ViewUser returns the configuration and last analyses of the user with the
+// given Telegram ID. The access is recorded before anything is read, also
+// when the user doesn't exist.
+func (s *SupportService) ViewUser(ctx context.Context, adminTelegramID, telegramID int64) (*SupportSnapshot, error) {
+	var user database.User
+	err := s.db.WithContext(ctx).Where("telegram_id = ?", telegramID).First(&user).Error
+	found := err == nil
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	entry := &database.AuditEntry{
+		ActorTelegramID:  adminTelegramID,
+		Action:           AuditActionSupportView,
+		TargetTelegramID: telegramID,
+	}
+	if found {
+		entry.TargetUserID = &user.ID
+	}
+	if err := s.db.WithContext(ctx).Create(entry).Error; err != nil {
+		return nil, fmt.Errorf("failed to write audit entry: %w", err)
+	}
+	if !found {
+		return nil, ErrUserNotFound
+	}
+
+	snapshot := &SupportSnapshot{User: user}
+	if err := s.db.WithContext(ctx).Where("user_id = ?", user.ID).Order("start_time ASC").Find(&snapshot.Ratios).Error; err != nil {
+		return nil, fmt.Errorf("failed to get insulin ratios: %w", err)
+	}
+	if err := s.db.WithContext(ctx).Where("user_id = ?", user.ID).Order("start_time ASC").Find(&snapshot.BasalRates).Error; err != nil {
+		return nil, fmt.Errorf("failed to get basal rates: %w", err)
+	}
+
+	var analyses []database.FoodAnalysis
+	if err := s.db.WithContext(ctx).
+		Select("id", "created_at", "weight", "carbs", "confidence", "used_provider", "insulin_ratio", "insulin_units", "original_insulin_ratio").
+		Where("user_id = ?", user.ID).
+		Order("created_at DESC").
+		Limit(supportAnalysesLimit).
+		Find(&analyses).Error; err != nil {
+		return nil, fmt.Errorf("failed to get analyses: %w", err)
+	}
+
+	loc := UserLocation(&user)
+	for _, a := range analyses {
+		snapshot.Analyses = append(snapshot.Analyses, SupportAnalysis{
+			ID:                   a.ID,
+			CreatedAt:            format.DateTime(a.CreatedAt, loc, format.Default),
+			Weight:               a.Weight,
+			Carbs:                a.Carbs,
+			Confidence:           a.Confidence,
+			UsedProvider:         a.UsedProvider,
+			Dose:                 dosing.CalculateDose(dosing.Input{Carbs: a.Carbs, CarbRatio: a.InsulinRatio, ActiveInsulin: a.ActiveInsulin, Correction: a.CorrectionUnits}),
+			LoggedUnits:          a.InsulinUnits,
+			OriginalInsulinRatio: a.OriginalInsulinRatio,
+		})
+	}
+	return snapshot, nil
+}
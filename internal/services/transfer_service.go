@@ -0,0 +1,327 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// TransferCodeTTL is how long a transfer code can be claimed
+const TransferCodeTTL = 24 * time.Hour
+
+// transferCodeAlphabet is Crockford's base32: no I, L, O or U, which are
+// easy to mix up with 1, 0 and V
+const transferCodeAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// transferCodeLength is the number of code characters, shown as two groups of four
+const transferCodeLength = 8
+
+// transferHistoryTables hold the user's history. Their rows are moved to the
+// claimed account when it is merged, and the receiving account's rows in them
+// are always moved so it can be deleted.
+var transferHistoryTables = []string{
+	"food_analyses",
+	"food_analysis_corrections",
+	"blood_sugar_records",
+	"injections",
+	"events",
+	"food_items",
+}
+
+// transferDataTables are checked to tell whether the receiving account has
+// data of its own that a transfer would merge
+var transferDataTables = []string{
+	"food_analyses",
+	"blood_sugar_records",
+	"injections",
+	"insulin_ratios",
+	"basal_rates",
+}
+
+var (
+	// ErrInvalidTransferCode is returned for unknown, used or expired codes
+	ErrInvalidTransferCode = errors.New("invalid transfer code")
+	// ErrTransferSameAccount is returned when a code is claimed by the account that issued it
+	ErrTransferSameAccount = errors.New("transfer code claimed by its own account")
+	// ErrTransferTargetHasData is returned when the claiming account has data
+	// of its own and merging was not requested
+	ErrTransferTargetHasData = errors.New("claiming account has data")
+	// ErrMergeSameUser is returned when a user row would be merged into itself
+	ErrMergeSameUser = errors.New("cannot merge a user into itself")
+)
+
+// mergeScheduleTables hold a user's schedules. They are moved by a merge only
+// when the receiving user has none, since two schedules would overlap.
+var mergeScheduleTables = []string{
+	"insulin_ratios",
+	"basal_rates",
+}
+
+// MergeCount is how many rows of a table a merge moved
+type MergeCount struct {
+	Table string
+	Rows  int64
+}
+
+// TransferService moves a user's history to a new Telegram account, e.g.
+// after the old one was lost. The user row is kept and gets the new
+// Telegram ID, so nothing that refers to the user ID has to change.
+type TransferService struct {
+	db *gorm.DB
+}
+
+func NewTransferService(db *gorm.DB) *TransferService {
+	return &TransferService{db: db}
+}
+
+func hashTransferCode(code string) string {
+	sum := sha256.Sum256([]byte(normalizeTransferCode(code)))
+	return hex.EncodeToString(sum[:])
+}
+
+// normalizeTransferCode accepts codes typed in lower case, without the dash
+// or with O, I and L for 0 and 1
+func normalizeTransferCode(code string) string {
+	code = strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	return strings.NewReplacer("O", "0", "I", "1", "L", "1").Replace(code)
+}
+
+// IssueCode creates a one-time code that moves the user's history to the
+// account that claims it, replacing the user's unused codes. The code is
+// only returned here; the database keeps its hash.
+func (s *TransferService) IssueCode(ctx context.Context, userID uint) (string, time.Time, error) {
+	buf := make([]byte, transferCodeLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to generate transfer code: %w", err)
+	}
+	var b strings.Builder
+	for i, c := range buf {
+		if i == transferCodeLength/2 {
+			b.WriteByte('-')
+		}
+		b.WriteByte(transferCodeAlphabet[c&31])
+	}
+	code := b.String()
+
+	expiresAt := time.Now().Add(TransferCodeTTL)
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ? AND used_at IS NULL", userID).Delete(&database.TransferCode{}).Error; err != nil {
+			return fmt.Errorf("failed to delete previous transfer codes: %w", err)
+		}
+		record := database.TransferCode{UserID: userID, CodeHash: hashTransferCode(code), ExpiresAt: expiresAt}
+		if err := tx.Create(&record).Error; err != nil {
+			return fmt.Errorf("failed to save transfer code: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return code, expiresAt, nil
+}
+
+// Claim moves the history of the code's account to the claiming user's
+// Telegram account: the claiming user row is deleted and the code's user row
+// takes over its Telegram ID. When the claiming account has data of
+// its own, the transfer fails with ErrTransferTargetHasData unless merge is
+// set; with merge its history is moved to the code's account and its
+// settings are dropped. Everything happens in one transaction, which also
+// uses up the code and writes the audit entry.
+func (s *TransferService) Claim(ctx context.Context, code string, claimer *database.User, merge bool) (*database.User, error) {
+	var user database.User
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var record database.TransferCode
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("code_hash = ? AND used_at IS NULL AND expires_at > ?", hashTransferCode(code), time.Now()).
+			First(&record).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidTransferCode
+		}
+		if err != nil {
+			return fmt.Errorf("failed to find transfer code: %w", err)
+		}
+		if record.UserID == claimer.ID {
+			return ErrTransferSameAccount
+		}
+		if err := tx.First(&user, record.UserID).Error; err != nil {
+			return fmt.Errorf("failed to get transferred user: %w", err)
+		}
+
+		if !merge {
+			hasData, err := userHasData(tx, claimer.ID)
+			if err != nil {
+				return err
+			}
+			if hasData {
+				return ErrTransferTargetHasData
+			}
+		}
+
+		for _, table := range transferHistoryTables {
+			if err := tx.Exec("UPDATE "+table+" SET user_id = ? WHERE user_id = ?", user.ID, claimer.ID).Error; err != nil {
+				return fmt.Errorf("failed to move %s: %w", table, err)
+			}
+		}
+		// Summaries of both accounts are stale after a merge; missing days are
+		// computed from the history when they are read
+		if err := tx.Where("user_id IN ?", []uint{user.ID, claimer.ID}).Delete(&database.DailySummary{}).Error; err != nil {
+			return fmt.Errorf("failed to delete daily summaries: %w", err)
+		}
+		// The remaining settings of the claiming account are deleted with it
+		// by the user_id foreign keys
+		if err := tx.Delete(&database.User{}, claimer.ID).Error; err != nil {
+			return fmt.Errorf("failed to delete claiming user: %w", err)
+		}
+
+		previousTelegramID := user.TelegramID
+		if err := tx.Model(&user).Update("telegram_id", claimer.TelegramID).Error; err != nil {
+			return fmt.Errorf("failed to update telegram ID: %w", err)
+		}
+		if err := tx.Model(&record).Update("used_at", time.Now()).Error; err != nil {
+			return fmt.Errorf("failed to use transfer code: %w", err)
+		}
+
+		entry := &database.AuditEntry{
+			ActorTelegramID:  claimer.TelegramID,
+			Action:           AuditActionAccountTransfer,
+			TargetTelegramID: previousTelegramID,
+			TargetUserID:     &user.ID,
+		}
+		if err := tx.Create(entry).Error; err != nil {
+			return fmt.Errorf("failed to write audit entry: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// userHasData reports whether the user has logged or configured anything
+func userHasData(tx *gorm.DB, userID uint) (bool, error) {
+	for _, table := range transferDataTables {
+		var count int64
+		if err := tx.Table(table).Where("user_id = ?", userID).Limit(1).Count(&count).Error; err != nil {
+			return false, fmt.Errorf("failed to check %s: %w", table, err)
+		}
+		if count > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// DuplicateUsers are user rows registered with the same Telegram ID
+type DuplicateUsers struct {
+	TelegramID int64
+	UserIDs    []uint // Ascending; the bot always read the first one
+}
+
+// FindDuplicateUsers returns the Telegram IDs held by more than one user row
+func (s *TransferService) FindDuplicateUsers(ctx context.Context) ([]DuplicateUsers, error) {
+	var users []database.User
+	if err := s.db.WithContext(ctx).Select("id", "telegram_id").
+		Where("telegram_id IN (SELECT telegram_id FROM users GROUP BY telegram_id HAVING COUNT(*) > 1)").
+		Order("telegram_id, id").
+		Find(&users).Error; err != nil {
+		return nil, fmt.Errorf("failed to find duplicate users: %w", err)
+	}
+
+	var duplicates []DuplicateUsers
+	for _, u := range users {
+		if n := len(duplicates); n > 0 && duplicates[n-1].TelegramID == u.TelegramID {
+			duplicates[n-1].UserIDs = append(duplicates[n-1].UserIDs, u.ID)
+			continue
+		}
+		duplicates = append(duplicates, DuplicateUsers{TelegramID: u.TelegramID, UserIDs: []uint{u.ID}})
+	}
+	return duplicates, nil
+}
+
+// MergeUsers moves the history of the user row fromID to the row toID and
+// deletes fromID, e.g. for duplicates registered with the same Telegram ID
+// before it was unique. Ratios and basal rates move only when toID has none;
+// the settings of fromID are dropped. Everything happens in one transaction,
+// which also writes the audit entry.
+func (s *TransferService) MergeUsers(ctx context.Context, adminTelegramID int64, fromID, toID uint) ([]MergeCount, error) {
+	if fromID == toID {
+		return nil, ErrMergeSameUser
+	}
+
+	var counts []MergeCount
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var users []database.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id IN ?", []uint{fromID, toID}).Find(&users).Error; err != nil {
+			return fmt.Errorf("failed to lock users: %w", err)
+		}
+		if len(users) != 2 {
+			return ErrUserNotFound
+		}
+		from := users[0]
+		if from.ID != fromID {
+			from = users[1]
+		}
+
+		move := func(table string) error {
+			result := tx.Exec("UPDATE "+table+" SET user_id = ? WHERE user_id = ?", toID, fromID)
+			if result.Error != nil {
+				return fmt.Errorf("failed to move %s: %w", table, result.Error)
+			}
+			counts = append(counts, MergeCount{Table: table, Rows: result.RowsAffected})
+			return nil
+		}
+		for _, table := range transferHistoryTables {
+			if err := move(table); err != nil {
+				return err
+			}
+		}
+		for _, table := range mergeScheduleTables {
+			var existing int64
+			if err := tx.Table(table).Where("user_id = ?", toID).Count(&existing).Error; err != nil {
+				return fmt.Errorf("failed to check %s: %w", table, err)
+			}
+			if existing > 0 {
+				counts = append(counts, MergeCount{Table: table})
+				continue
+			}
+			if err := move(table); err != nil {
+				return err
+			}
+		}
+
+		// Summaries are computed again from the merged history when read
+		if err := tx.Where("user_id IN ?", []uint{fromID, toID}).Delete(&database.DailySummary{}).Error; err != nil {
+			return fmt.Errorf("failed to delete daily summaries: %w", err)
+		}
+		// What is left of fromID is deleted with it by the user_id foreign keys
+		if err := tx.Delete(&database.User{}, fromID).Error; err != nil {
+			return fmt.Errorf("failed to delete merged user: %w", err)
+		}
+
+		entry := &database.AuditEntry{
+			ActorTelegramID:  adminTelegramID,
+			Action:           AuditActionUserMerge,
+			TargetTelegramID: from.TelegramID,
+			TargetUserID:     &toID,
+		}
+		if err := tx.Create(entry).Error; err != nil {
+			return fmt.Errorf("failed to write audit entry: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
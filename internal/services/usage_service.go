@@ -0,0 +1,107 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/vladimiradmaev/diabetes-helper/internal/config"
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// AIUsage is the token usage of a single AI request
+type AIUsage struct {
+	Provider     string
+	Model        string
+	InputTokens  int
+	OutputTokens int
+}
+
+// UsageTotal is the aggregated AI usage of a provider and model over a period
+type UsageTotal struct {
+	Provider     string
+	Model        string
+	Requests     int
+	InputTokens  int64
+	OutputTokens int64
+	Cost         float64 // Estimated cost in USD
+}
+
+// UsageService accounts AI token usage per UTC day, provider and model
+type UsageService struct {
+	db     *gorm.DB
+	prices map[string]config.ModelPrice
+}
+
+func NewUsageService(db *gorm.DB, prices map[string]config.ModelPrice) *UsageService {
+	return &UsageService{db: db, prices: prices}
+}
+
+// Cost estimates the USD cost of the usage. Models without a configured price cost nothing.
+func (s *UsageService) Cost(usage AIUsage) float64 {
+	price := s.prices[usage.Model]
+	return (float64(usage.InputTokens)*price.Input + float64(usage.OutputTokens)*price.Output) / 1e6
+}
+
+// Record adds one request to the current day's totals
+func (s *UsageService) Record(ctx context.Context, usage AIUsage) error {
+	row := &database.TokenUsage{
+		Day:          usageDay(time.Now()),
+		Provider:     usage.Provider,
+		Model:        usage.Model,
+		Requests:     1,
+		InputTokens:  int64(usage.InputTokens),
+		OutputTokens: int64(usage.OutputTokens),
+		Cost:         s.Cost(usage),
+	}
+
+	if err := s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "day"}, {Name: "provider"}, {Name: "model"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"requests":      gorm.Expr("token_usages.requests + 1"),
+			"input_tokens":  gorm.Expr("token_usages.input_tokens + ?", row.InputTokens),
+			"output_tokens": gorm.Expr("token_usages.output_tokens + ?", row.OutputTokens),
+			"cost":          gorm.Expr("token_usages.cost + ?", row.Cost),
+			"updated_at":    time.Now(),
+		}),
+	}).Create(row).Error; err != nil {
+		return fmt.Errorf("failed to record token usage: %w", err)
+	}
+	return nil
+}
+
+// GetTotals returns usage per provider and model for the UTC days in [from, to)
+func (s *UsageService) GetTotals(ctx context.Context, from, to time.Time) ([]UsageTotal, error) {
+	var totals []UsageTotal
+	if err := s.db.WithContext(ctx).
+		Model(&database.TokenUsage{}).
+		Select("provider, model, SUM(requests) AS requests, SUM(input_tokens) AS input_tokens, SUM(output_tokens) AS output_tokens, SUM(cost) AS cost").
+		Where("day >= ? AND day < ?", usageDay(from), usageDay(to)).
+		Group("provider, model").
+		Order("cost DESC").
+		Scan(&totals).Error; err != nil {
+		return nil, fmt.Errorf("failed to get token usage totals: %w", err)
+	}
+	return totals, nil
+}
+
+// ClaimMonthlyReport marks the month starting at monthStart as reported. It
+// returns false when the report was already sent, so that several instances
+// or restarts never send it twice.
+func (s *UsageService) ClaimMonthlyReport(ctx context.Context, monthStart time.Time) (bool, error) {
+	result := s.db.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&database.UsageReport{Month: usageDay(monthStart)})
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to claim usage report: %w", result.Error)
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// usageDay truncates a time to its UTC calendar day
+func usageDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
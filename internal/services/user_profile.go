@@ -0,0 +1,77 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"gorm.io/gorm"
+)
+
+// ProfileItem is one setting the dose math depends on
+type ProfileItem struct {
+	Name     string // Shown in the checklist, e.g. "коэффициенты на ХЕ"
+	Done     bool
+	Callback string // Callback data that starts the item's setup flow
+}
+
+// ProfileCompleteness is the checklist of the settings the dose math needs,
+// in the order they are shown
+type ProfileCompleteness struct {
+	Items []ProfileItem
+}
+
+// Done returns how many items are set up
+func (c *ProfileCompleteness) Done() int {
+	done := 0
+	for _, item := range c.Items {
+		if item.Done {
+			done++
+		}
+	}
+	return done
+}
+
+// Complete reports whether every item is set up
+func (c *ProfileCompleteness) Complete() bool {
+	return c.Done() == len(c.Items)
+}
+
+// UserProfileService tells which of the settings the dose math relies on a
+// user hasn't set up. Without them the bot silently falls back to defaults
+// or leaves parts of the advice out.
+type UserProfileService struct {
+	db *gorm.DB
+}
+
+func NewUserProfileService(db *gorm.DB) *UserProfileService {
+	return &UserProfileService{db: db}
+}
+
+// Completeness returns the user's profile checklist
+func (s *UserProfileService) Completeness(ctx context.Context, userID uint) (*ProfileCompleteness, error) {
+	var user database.User
+	if err := s.db.WithContext(ctx).First(&user, userID).Error; err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	var ratios int64
+	if err := s.db.WithContext(ctx).Model(&database.InsulinRatio{}).
+		Where("user_id = ?", userID).
+		Count(&ratios).Error; err != nil {
+		return nil, fmt.Errorf("failed to count insulin ratios: %w", err)
+	}
+
+	return &ProfileCompleteness{Items: []ProfileItem{
+		// Without ratios there is no dose at all
+		{Name: "коэффициенты на ХЕ", Done: ratios > 0, Callback: "insulin_ratio"},
+		// Ratio periods are matched in local time, server time may be hours off
+		{Name: "часовой пояс", Done: user.Timezone != "", Callback: "timezone"},
+		// Without the rule /low can't say how many carbs to take
+		{Name: "правило при гипо", Done: user.LowRuleGrams > 0 && user.LowRuleRise > 0, Callback: "low_rule"},
+		// Without it active insulin isn't taken off the dose
+		{Name: "время активного инсулина", Done: user.ActiveInsulinTime > 0, Callback: "active_insulin_time"},
+		// Without the factor and target high glucose isn't corrected
+		{Name: "ФЧИ и целевой сахар", Done: user.CorrectionFactor > 0 && user.TargetBloodSugar > 0, Callback: "correction_factor"},
+	}}, nil
+}
@@ -3,9 +3,15 @@ package services
 import (
 	"context"
 	"fmt"
+	"slices"
+	"time"
 
 	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/dosing"
+	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+	"github.com/vladimiradmaev/diabetes-helper/internal/utils"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type UserService struct {
@@ -16,6 +22,43 @@ func NewUserService(db *gorm.DB) *UserService {
 	return &UserService{db: db}
 }
 
+// Analysis result verbosity. An empty value means full.
+const (
+	ResultVerbosityFull    = "full"
+	ResultVerbosityCompact = "compact"
+)
+
+// CompactResults reports whether the user wants analysis results without the breakdown
+func CompactResults(user *database.User) bool {
+	return user.ResultVerbosity == ResultVerbosityCompact
+}
+
+// Measurement systems for portion estimation. An empty value means metric.
+const (
+	MeasurementMetric   = "metric"
+	MeasurementImperial = "imperial"
+)
+
+// ImperialUnits reports whether the user estimates portions in ounces and inches
+func ImperialUnits(user *database.User) bool {
+	return user.MeasurementSystem == MeasurementImperial
+}
+
+// BreadUnitSteps are the display roundings of bread units a user can choose
+var BreadUnitSteps = []float64{0.1, 0.25, 0.5}
+
+// BreadUnitStep returns the step the user's bread units are displayed rounded to
+func BreadUnitStep(user *database.User) float64 {
+	if user.BreadUnitStep <= 0 {
+		return BreadUnitSteps[0]
+	}
+	return user.BreadUnitStep
+}
+
+// CarbsSteps are the roundings of analysed carbs a user can choose; 0 keeps
+// the AI's value
+var CarbsSteps = []float64{0, 1, 5}
+
 func (s *UserService) RegisterUser(ctx context.Context, telegramID int64, username, firstName, lastName string) (*database.User, error) {
 	// Try to find existing user first
 	var user database.User
@@ -31,7 +74,9 @@ func (s *UserService) RegisterUser(ctx context.Context, telegramID int64, userna
 		return nil, fmt.Errorf("failed to find user: %w", result.Error)
 	}
 
-	// User doesn't exist, create new one
+	// User doesn't exist, create new one. Two first updates of a new user can
+	// get here at the same time; the unique index on telegram_id lets only
+	// one insert through and the other reads the row it created.
 	user = database.User{
 		TelegramID: telegramID,
 		Username:   username,
@@ -39,8 +84,17 @@ func (s *UserService) RegisterUser(ctx context.Context, telegramID int64, userna
 		LastName:   lastName,
 	}
 
-	if err := s.db.WithContext(ctx).Create(&user).Error; err != nil {
-		return nil, fmt.Errorf("failed to create user: %w", err)
+	result = s.db.WithContext(ctx).
+		Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "telegram_id"}}, DoNothing: true}).
+		Create(&user)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to create user: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		user = database.User{}
+		if err := s.db.WithContext(ctx).Where("telegram_id = ?", telegramID).First(&user).Error; err != nil {
+			return nil, fmt.Errorf("failed to find user: %w", err)
+		}
 	}
 
 	return &user, nil
@@ -53,3 +107,307 @@ func (s *UserService) GetUserByTelegramID(ctx context.Context, telegramID int64)
 	}
 	return &user, nil
 }
+
+func (s *UserService) SetGlucoseUnit(ctx context.Context, userID uint, unit string) error {
+	if unit != GlucoseUnitMmol && unit != GlucoseUnitMgdl {
+		return fmt.Errorf("unsupported glucose unit: %s", unit)
+	}
+	if err := s.db.WithContext(ctx).Model(&database.User{}).Where("id = ?", userID).Update("glucose_unit", unit).Error; err != nil {
+		return fmt.Errorf("failed to update glucose unit: %w", err)
+	}
+	return nil
+}
+
+// SetResultVerbosity sets how much detail analysis results show
+func (s *UserService) SetResultVerbosity(ctx context.Context, userID uint, verbosity string) error {
+	if verbosity != ResultVerbosityFull && verbosity != ResultVerbosityCompact {
+		return fmt.Errorf("unsupported result verbosity: %s", verbosity)
+	}
+	if err := s.db.WithContext(ctx).Model(&database.User{}).Where("id = ?", userID).Update("result_verbosity", verbosity).Error; err != nil {
+		return fmt.Errorf("failed to update result verbosity: %w", err)
+	}
+	return nil
+}
+
+// SetIOBModel sets how the user's insulin on board decays
+func (s *UserService) SetIOBModel(ctx context.Context, userID uint, model string) error {
+	if model != dosing.IOBLinear && model != dosing.IOBCurved {
+		return fmt.Errorf("unsupported IOB model: %s", model)
+	}
+	if err := s.db.WithContext(ctx).Model(&database.User{}).Where("id = ?", userID).Update("iob_model", model).Error; err != nil {
+		return fmt.Errorf("failed to update IOB model: %w", err)
+	}
+	return nil
+}
+
+// SetMeasurementSystem sets the units portion estimation reasons in
+func (s *UserService) SetMeasurementSystem(ctx context.Context, userID uint, system string) error {
+	if system != MeasurementMetric && system != MeasurementImperial {
+		return fmt.Errorf("unsupported measurement system: %s", system)
+	}
+	if err := s.db.WithContext(ctx).Model(&database.User{}).Where("id = ?", userID).Update("measurement_system", system).Error; err != nil {
+		return fmt.Errorf("failed to update measurement system: %w", err)
+	}
+	return nil
+}
+
+// SetArchivePhotos turns archiving of meal photos on or off
+func (s *UserService) SetArchivePhotos(ctx context.Context, userID uint, enabled bool) error {
+	if err := s.db.WithContext(ctx).Model(&database.User{}).Where("id = ?", userID).Update("archive_photos", enabled).Error; err != nil {
+		return fmt.Errorf("failed to update photo archiving: %w", err)
+	}
+	return nil
+}
+
+// SetKeepPrompts sets whether prompts of finished input flows stay in the chat
+func (s *UserService) SetKeepPrompts(ctx context.Context, userID uint, keep bool) error {
+	if err := s.db.WithContext(ctx).Model(&database.User{}).Where("id = ?", userID).Update("keep_prompts", keep).Error; err != nil {
+		return fmt.Errorf("failed to update prompt cleanup: %w", err)
+	}
+	return nil
+}
+
+// SetHideBolusTiming sets whether analysis results leave out the injection timing hint
+func (s *UserService) SetHideBolusTiming(ctx context.Context, userID uint, hide bool) error {
+	if err := s.db.WithContext(ctx).Model(&database.User{}).Where("id = ?", userID).Update("hide_bolus_timing", hide).Error; err != nil {
+		return fmt.Errorf("failed to update bolus timing hint: %w", err)
+	}
+	return nil
+}
+
+// SetReanalyzeOnPrimary sets whether analyses served by a fallback AI model
+// offer a repeat on the primary model
+func (s *UserService) SetReanalyzeOnPrimary(ctx context.Context, userID uint, enabled bool) error {
+	if err := s.db.WithContext(ctx).Model(&database.User{}).Where("id = ?", userID).Update("reanalyze_on_primary", enabled).Error; err != nil {
+		return fmt.Errorf("failed to update primary re-analysis: %w", err)
+	}
+	return nil
+}
+
+// SetBreadUnitStep sets the display rounding of bread units, one of BreadUnitSteps
+func (s *UserService) SetBreadUnitStep(ctx context.Context, userID uint, step float64) error {
+	if !slices.Contains(BreadUnitSteps, step) {
+		return fmt.Errorf("unsupported bread unit step %g", step)
+	}
+	if err := s.db.WithContext(ctx).Model(&database.User{}).Where("id = ?", userID).Update("bread_unit_step", step).Error; err != nil {
+		return fmt.Errorf("failed to update bread unit rounding: %w", err)
+	}
+	return nil
+}
+
+// SetCarbsStep sets the rounding of analysed carbs, one of CarbsSteps
+func (s *UserService) SetCarbsStep(ctx context.Context, userID uint, step float64) error {
+	if !slices.Contains(CarbsSteps, step) {
+		return fmt.Errorf("unsupported carbs step %g", step)
+	}
+	if err := s.db.WithContext(ctx).Model(&database.User{}).Where("id = ?", userID).Update("carbs_step", step).Error; err != nil {
+		return fmt.Errorf("failed to update carbs rounding: %w", err)
+	}
+	return nil
+}
+
+func (s *UserService) SetDailyCarbTarget(ctx context.Context, userID uint, grams float64) error {
+	if grams < 0 {
+		return fmt.Errorf("daily carb target cannot be negative")
+	}
+	if err := s.db.WithContext(ctx).Model(&database.User{}).Where("id = ?", userID).Update("daily_carb_target", grams).Error; err != nil {
+		return fmt.Errorf("failed to update daily carb target: %w", err)
+	}
+	return nil
+}
+
+// SetBasalReminder sets the daily basal reminder to the local time "HH:MM"
+// with the given units; an empty time turns it off
+func (s *UserService) SetBasalReminder(ctx context.Context, userID uint, at string, units dosing.DeciUnits) error {
+	if at != "" {
+		if _, err := time.Parse("15:04", at); err != nil {
+			return fmt.Errorf("invalid basal reminder time %q", at)
+		}
+		if units <= 0 {
+			return fmt.Errorf("basal reminder dose must be positive")
+		}
+	} else {
+		units = 0
+	}
+	if err := s.db.WithContext(ctx).Model(&database.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"basal_reminder_time":  at,
+		"basal_reminder_units": units,
+	}).Error; err != nil {
+		return fmt.Errorf("failed to update basal reminder: %w", err)
+	}
+	return nil
+}
+
+// SetQuietHours sets the user's quiet hours to the local times "HH:MM";
+// empty times turn them off
+func (s *UserService) SetQuietHours(ctx context.Context, userID uint, start, end string) error {
+	if start != "" || end != "" {
+		for _, at := range []string{start, end} {
+			if _, err := time.Parse("15:04", at); err != nil {
+				return fmt.Errorf("invalid quiet hours time %q", at)
+			}
+		}
+		if start == end {
+			return fmt.Errorf("quiet hours must not be empty")
+		}
+	}
+	if err := s.db.WithContext(ctx).Model(&database.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"quiet_hours_start": start,
+		"quiet_hours_end":   end,
+	}).Error; err != nil {
+		return fmt.Errorf("failed to update quiet hours: %w", err)
+	}
+	return nil
+}
+
+// SetNotificationsPaused pauses or resumes the user's reminders and other
+// automated messages
+func (s *UserService) SetNotificationsPaused(ctx context.Context, userID uint, paused bool) error {
+	if err := s.db.WithContext(ctx).Model(&database.User{}).Where("id = ?", userID).Update("notifications_paused", paused).Error; err != nil {
+		return fmt.Errorf("failed to update notifications pause: %w", err)
+	}
+	return nil
+}
+
+// InQuietHours reports whether now falls into the user's quiet hours in their
+// local time. The window may span midnight.
+func InQuietHours(user *database.User, now time.Time) bool {
+	if user.QuietHoursStart == "" || user.QuietHoursEnd == "" {
+		return false
+	}
+	local := now.In(UserLocation(user))
+	return utils.PeriodContains(user.QuietHoursStart, user.QuietHoursEnd, local.Hour()*60+local.Minute())
+}
+
+// SetLowRule stores the user's rule for treating lows: grams of fast carbs
+// raise glucose by rise, and lows are treated up to target (0 for the
+// default). Glucose values are in mmol/L.
+func (s *UserService) SetLowRule(ctx context.Context, userID uint, grams, rise, target float64) error {
+	if err := s.db.WithContext(ctx).Model(&database.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"low_rule_grams": grams,
+		"low_rule_rise":  rise,
+		"low_target":     target,
+	}).Error; err != nil {
+		return fmt.Errorf("failed to update low rule: %w", err)
+	}
+	return nil
+}
+
+// SetCorrection stores how much one unit of insulin lowers the user's glucose
+// and the target high glucose is corrected to, both in mmol/L; zeros turn
+// corrections off
+func (s *UserService) SetCorrection(ctx context.Context, userID uint, factor, target float64) error {
+	if factor < 0 || target < 0 || (factor == 0) != (target == 0) {
+		return fmt.Errorf("invalid correction factor %.2f and target %.2f", factor, target)
+	}
+	if err := s.db.WithContext(ctx).Model(&database.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"correction_factor":  factor,
+		"target_blood_sugar": target,
+	}).Error; err != nil {
+		return fmt.Errorf("failed to update correction settings: %w", err)
+	}
+	return nil
+}
+
+// ScheduleLowRecheck sets when the user is reminded to recheck glucose after
+// treating a low, replacing an earlier reminder
+func (s *UserService) ScheduleLowRecheck(ctx context.Context, userID uint, at time.Time) error {
+	if err := s.db.WithContext(ctx).Model(&database.User{}).Where("id = ?", userID).Update("low_recheck_at", at).Error; err != nil {
+		return fmt.Errorf("failed to schedule low recheck: %w", err)
+	}
+	return nil
+}
+
+// DueLowRechecks returns the users whose recheck reminder is due by now, of
+// the given users or of all when userIDs is nil. The reminders are cleared in
+// the same statement, so each is sent once even with several instances
+// running; one that can't be sent is put back with RestoreLowRecheck.
+func (s *UserService) DueLowRechecks(ctx context.Context, now time.Time, userIDs []uint) ([]database.User, error) {
+	var users []database.User
+	query := s.db.WithContext(ctx).Model(&users).
+		Clauses(clause.Returning{}).
+		Where("low_recheck_at <= ? AND deleted_at IS NULL", now)
+	if userIDs != nil {
+		query = query.Where("id IN ?", userIDs)
+	}
+	if err := query.Update("low_recheck_at", nil).Error; err != nil {
+		return nil, fmt.Errorf("failed to claim low rechecks: %w", err)
+	}
+	return users, nil
+}
+
+// RestoreLowRecheck puts back a claimed recheck reminder that couldn't be
+// sent, to be sent again at at. A reminder scheduled meanwhile is kept.
+func (s *UserService) RestoreLowRecheck(ctx context.Context, userID uint, at time.Time) error {
+	if err := s.db.WithContext(ctx).Model(&database.User{}).
+		Where("id = ? AND low_recheck_at IS NULL", userID).
+		Update("low_recheck_at", at).Error; err != nil {
+		return fmt.Errorf("failed to restore low recheck: %w", err)
+	}
+	return nil
+}
+
+func (s *UserService) SetTimezone(ctx context.Context, userID uint, timezone string) error {
+	if err := s.db.WithContext(ctx).Model(&database.User{}).Where("id = ?", userID).Update("timezone", timezone).Error; err != nil {
+		return fmt.Errorf("failed to update timezone: %w", err)
+	}
+	return nil
+}
+
+// TravelModeActive reports whether the user's travel timezone applies at now
+func TravelModeActive(user *database.User, now time.Time) bool {
+	return user.TravelTimezone != "" && (user.TravelUntil == nil || now.Before(*user.TravelUntil))
+}
+
+// UserLocation returns the location used for the user's local time: the travel
+// timezone while travel mode is active, otherwise the home timezone.
+// Everything that depends on the user's wall clock (ratio periods, day bounds,
+// displayed times) must go through it.
+func UserLocation(user *database.User) *time.Location {
+	if TravelModeActive(user, time.Now()) {
+		return utils.LoadLocation(user.TravelTimezone)
+	}
+	return utils.LoadLocation(user.Timezone)
+}
+
+// SetTravelMode enables a temporary timezone override. until may be nil.
+func (s *UserService) SetTravelMode(ctx context.Context, userID uint, timezone string, until *time.Time) error {
+	if err := s.db.WithContext(ctx).Model(&database.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"travel_timezone": timezone,
+		"travel_until":    until,
+	}).Error; err != nil {
+		return fmt.Errorf("failed to enable travel mode: %w", err)
+	}
+	return nil
+}
+
+// ClearTravelMode ends travel mode so the home timezone applies again
+func (s *UserService) ClearTravelMode(ctx context.Context, userID uint) error {
+	if err := s.db.WithContext(ctx).Model(&database.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"travel_timezone": "",
+		"travel_until":    nil,
+	}).Error; err != nil {
+		return fmt.Errorf("failed to end travel mode: %w", err)
+	}
+	return nil
+}
+
+// ClearExpiredTravelModes ends travel mode for users whose end date has passed.
+// UserLocation already ignores expired overrides; this keeps the stored state
+// in line with it.
+func (s *UserService) ClearExpiredTravelModes(ctx context.Context) error {
+	result := s.db.WithContext(ctx).
+		Model(&database.User{}).
+		Where("travel_timezone <> '' AND travel_until IS NOT NULL AND travel_until <= ?", time.Now()).
+		Updates(map[string]interface{}{
+			"travel_timezone": "",
+			"travel_until":    nil,
+		})
+	if result.Error != nil {
+		return fmt.Errorf("failed to clear expired travel modes: %w", result.Error)
+	}
+	if result.RowsAffected > 0 {
+		logger.Info("Travel mode ended", "users", result.RowsAffected)
+	}
+	return nil
+}
@@ -0,0 +1,256 @@
+// Package sharecode packs a user's dosing setup (ratio schedule, basal
+// profile, active insulin time, correction, low treatment rule and dose
+// rounding) into a short text code that can be pasted into another account,
+// possibly on another instance of the bot.
+//
+// A code is "DH-" followed by URL-safe base64 of: a version byte, the
+// periods and settings as uvarints, and a CRC-32 of everything before it.
+// The checksum catches codes mangled while copying; it is not a signature,
+// since no key is shared between instances.
+package sharecode
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"strings"
+
+	"github.com/vladimiradmaev/diabetes-helper/internal/dosing"
+	"github.com/vladimiradmaev/diabetes-helper/internal/utils"
+)
+
+// Version is the format written by Encode. Decode rejects newer versions, so
+// a code from an updated instance fails clearly instead of being misread.
+const Version = 2
+
+// Prefix starts every code
+const Prefix = "DH-"
+
+// Scales of the fixed-point values
+const (
+	ratioScale   = 100  // Ratios to 0.01 units per bread unit
+	rateScale    = 1000 // Basal rates to 0.001 units per hour
+	glucoseScale = 100  // Glucose to 0.01 mmol/L
+	gramsScale   = 10   // Grams to 0.1 g
+	stepScale    = 100  // Rounding steps to 0.01
+)
+
+const flagCurvedIOB = 1 << 0
+
+var (
+	// ErrInvalidCode is returned for text that isn't a complete, intact code
+	ErrInvalidCode = errors.New("invalid share code")
+	// ErrUnsupportedVersion is returned for codes written by a newer format
+	ErrUnsupportedVersion = errors.New("unsupported share code version")
+)
+
+// Period is one period of a ratio schedule or basal profile
+type Period struct {
+	StartTime string  // "HH:MM"
+	EndTime   string  // "HH:MM", "00:00" for midnight
+	Value     float64 // Units per bread unit or units per hour
+}
+
+// Settings is what a code carries
+type Settings struct {
+	// Version of the decoded code. Version 1 codes carry only the periods
+	// and the active insulin settings; the rest is zero and must not be
+	// applied.
+	Version int
+
+	Ratios            []Period
+	BasalRates        []Period
+	ActiveInsulinTime int    // Minutes, 0 for the default
+	IOBModel          string // dosing.IOBLinear or dosing.IOBCurved
+
+	// Correction, in mmol/L; both 0 when off
+	CorrectionFactor float64
+	TargetBloodSugar float64
+
+	// Low treatment rule: LowRuleGrams of fast carbs raise glucose by
+	// LowRuleRise mmol/L, lows are treated up to LowTarget (0 for the default)
+	LowRuleGrams float64
+	LowRuleRise  float64
+	LowTarget    float64
+
+	BreadUnitStep float64 // Display rounding of bread units, 0 for the default
+	CarbsStep     float64 // Grams analysed carbs are rounded to, 0 for none
+}
+
+// Encode returns the code for the settings
+func Encode(s *Settings) string {
+	buf := []byte{Version}
+	buf = appendPeriods(buf, s.Ratios, ratioScale)
+	buf = appendPeriods(buf, s.BasalRates, rateScale)
+	buf = binary.AppendUvarint(buf, uint64(s.ActiveInsulinTime))
+	var flags byte
+	if s.IOBModel == dosing.IOBCurved {
+		flags |= flagCurvedIOB
+	}
+	buf = append(buf, flags)
+	for _, v := range []struct{ value, scale float64 }{
+		{s.CorrectionFactor, glucoseScale},
+		{s.TargetBloodSugar, glucoseScale},
+		{s.LowRuleGrams, gramsScale},
+		{s.LowRuleRise, glucoseScale},
+		{s.LowTarget, glucoseScale},
+		{s.BreadUnitStep, stepScale},
+		{s.CarbsStep, gramsScale},
+	} {
+		buf = binary.AppendUvarint(buf, uint64(math.Round(v.value*v.scale)))
+	}
+	buf = binary.BigEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf))
+	return Prefix + base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// Decode parses and validates a code. Spaces and line breaks added while
+// copying are ignored.
+func Decode(code string) (*Settings, error) {
+	code = strings.Join(strings.Fields(code), "")
+	if !strings.HasPrefix(strings.ToUpper(code), Prefix) {
+		return nil, ErrInvalidCode
+	}
+	buf, err := base64.RawURLEncoding.DecodeString(code[len(Prefix):])
+	if err != nil || len(buf) < 1+4 {
+		return nil, ErrInvalidCode
+	}
+	body, sum := buf[:len(buf)-4], binary.BigEndian.Uint32(buf[len(buf)-4:])
+	if crc32.ChecksumIEEE(body) != sum {
+		return nil, ErrInvalidCode
+	}
+	if body[0] > Version {
+		return nil, ErrUnsupportedVersion
+	}
+
+	r := &reader{buf: body[1:]}
+	s := &Settings{
+		Version:    int(body[0]),
+		Ratios:     r.periods(ratioScale),
+		BasalRates: r.periods(rateScale),
+	}
+	s.ActiveInsulinTime = int(r.uvarint())
+	s.IOBModel = dosing.IOBLinear
+	if r.readByte()&flagCurvedIOB != 0 {
+		s.IOBModel = dosing.IOBCurved
+	}
+	if s.Version >= 2 {
+		s.CorrectionFactor = r.fixed(glucoseScale)
+		s.TargetBloodSugar = r.fixed(glucoseScale)
+		s.LowRuleGrams = r.fixed(gramsScale)
+		s.LowRuleRise = r.fixed(glucoseScale)
+		s.LowTarget = r.fixed(glucoseScale)
+		s.BreadUnitStep = r.fixed(stepScale)
+		s.CarbsStep = r.fixed(gramsScale)
+	}
+	if r.err != nil || len(r.buf) > 0 {
+		return nil, ErrInvalidCode
+	}
+	if err := validate(s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func appendPeriods(buf []byte, periods []Period, scale float64) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(periods)))
+	for _, p := range periods {
+		buf = binary.AppendUvarint(buf, uint64(utils.TimeToMinutes(p.StartTime)))
+		buf = binary.AppendUvarint(buf, uint64(utils.TimeToMinutes(p.EndTime)))
+		buf = binary.AppendUvarint(buf, uint64(math.Round(p.Value*scale)))
+	}
+	return buf
+}
+
+// validate checks that the periods are usable as a schedule
+func validate(s *Settings) error {
+	for _, periods := range [][]Period{s.Ratios, s.BasalRates} {
+		for i, p := range periods {
+			for _, other := range periods[:i] {
+				if utils.PeriodsOverlap(p.StartTime, p.EndTime, other.StartTime, other.EndTime) {
+					return fmt.Errorf("%w: periods %s-%s and %s-%s overlap", ErrInvalidCode, other.StartTime, other.EndTime, p.StartTime, p.EndTime)
+				}
+			}
+		}
+	}
+	for _, p := range s.Ratios {
+		if p.Value <= 0 {
+			return fmt.Errorf("%w: ratio of %s-%s is 0", ErrInvalidCode, p.StartTime, p.EndTime)
+		}
+	}
+	if s.ActiveInsulinTime > 24*60 {
+		return fmt.Errorf("%w: active insulin time of %d minutes", ErrInvalidCode, s.ActiveInsulinTime)
+	}
+	if (s.CorrectionFactor == 0) != (s.TargetBloodSugar == 0) {
+		return fmt.Errorf("%w: correction factor %.2f with target %.2f", ErrInvalidCode, s.CorrectionFactor, s.TargetBloodSugar)
+	}
+	if (s.LowRuleGrams == 0) != (s.LowRuleRise == 0) {
+		return fmt.Errorf("%w: low rule of %.1f g raising by %.2f", ErrInvalidCode, s.LowRuleGrams, s.LowRuleRise)
+	}
+	return nil
+}
+
+// reader reads the encoded fields, remembering the first error
+type reader struct {
+	buf []byte
+	err error
+}
+
+func (r *reader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		r.err = ErrInvalidCode
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+
+// fixed reads a fixed-point value
+func (r *reader) fixed(scale float64) float64 {
+	return float64(r.uvarint()) / scale
+}
+
+func (r *reader) readByte() byte {
+	if r.err != nil || len(r.buf) == 0 {
+		r.err = ErrInvalidCode
+		return 0
+	}
+	b := r.buf[0]
+	r.buf = r.buf[1:]
+	return b
+}
+
+func (r *reader) periods(scale float64) []Period {
+	n := r.uvarint()
+	// Each period takes at least 3 bytes, which bounds n for damaged codes
+	if n > uint64(len(r.buf)/3) {
+		r.err = ErrInvalidCode
+		return nil
+	}
+	periods := make([]Period, 0, n)
+	for i := uint64(0); i < n; i++ {
+		start, end, value := r.uvarint(), r.uvarint(), r.uvarint()
+		if start >= 24*60 || end >= 24*60 {
+			r.err = ErrInvalidCode
+		}
+		if r.err != nil {
+			return nil
+		}
+		periods = append(periods, Period{
+			StartTime: formatMinutes(start),
+			EndTime:   formatMinutes(end),
+			Value:     float64(value) / scale,
+		})
+	}
+	return periods
+}
+
+func formatMinutes(m uint64) string {
+	return fmt.Sprintf("%02d:%02d", m/60, m%60)
+}
@@ -0,0 +1,127 @@
+package sharecode
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vladimiradmaev/diabetes-helper/internal/dosing"
+)
+
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings Settings
+	}{
+		{"empty", Settings{IOBModel: dosing.IOBLinear}},
+		{"ratios across midnight", Settings{
+			Ratios:   []Period{{"06:00", "12:00", 1.5}, {"12:00", "22:30", 1.25}, {"22:30", "06:00", 0.75}},
+			IOBModel: dosing.IOBLinear,
+		}},
+		{"full setup", Settings{
+			Ratios:            []Period{{"00:00", "00:00", 1.2}},
+			BasalRates:        []Period{{"00:00", "06:00", 0.85}, {"06:00", "00:00", 0.925}},
+			ActiveInsulinTime: 270,
+			IOBModel:          dosing.IOBCurved,
+			CorrectionFactor:  2.2,
+			TargetBloodSugar:  6.5,
+			LowRuleGrams:      15,
+			LowRuleRise:       2.5,
+			LowTarget:         5.5,
+			BreadUnitStep:     0.25,
+			CarbsStep:         5,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code := Encode(&tt.settings)
+			if !strings.HasPrefix(code, Prefix) {
+				t.Fatalf("code %q has no prefix", code)
+			}
+			got, err := Decode(code)
+			if err != nil {
+				t.Fatalf("Decode(%q): %v", code, err)
+			}
+			want := tt.settings
+			want.Version = Version
+			if want.Ratios == nil {
+				want.Ratios = []Period{}
+			}
+			if want.BasalRates == nil {
+				want.BasalRates = []Period{}
+			}
+			if !reflect.DeepEqual(*got, want) {
+				t.Errorf("Decode(Encode()) =\n%+v\nwant\n%+v", *got, want)
+			}
+		})
+	}
+}
+
+func TestDecodeVersion1(t *testing.T) {
+	// Written by the first format: periods, active insulin time and flags only
+	buf := []byte{1}
+	buf = appendPeriods(buf, []Period{{"00:00", "00:00", 1}}, ratioScale)
+	buf = appendPeriods(buf, nil, rateScale)
+	buf = binary.AppendUvarint(buf, 180)
+	buf = append(buf, flagCurvedIOB)
+	buf = binary.BigEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf))
+
+	got, err := Decode(Prefix + base64.RawURLEncoding.EncodeToString(buf))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	want := Settings{
+		Version:           1,
+		Ratios:            []Period{{"00:00", "00:00", 1}},
+		BasalRates:        []Period{},
+		ActiveInsulinTime: 180,
+		IOBModel:          dosing.IOBCurved,
+	}
+	if !reflect.DeepEqual(*got, want) {
+		t.Errorf("Decode = %+v, want %+v", *got, want)
+	}
+}
+
+func TestDecodeRejects(t *testing.T) {
+	valid := Encode(&Settings{Ratios: []Period{{"08:00", "12:00", 1}}})
+	newer := func() string {
+		buf := []byte{Version + 1}
+		buf = binary.BigEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf))
+		return Prefix + base64.RawURLEncoding.EncodeToString(buf)
+	}()
+
+	tests := []struct {
+		name string
+		code string
+		want error
+	}{
+		{"empty", "", ErrInvalidCode},
+		{"no prefix", strings.TrimPrefix(valid, Prefix), ErrInvalidCode},
+		{"damaged", valid[:len(valid)-2] + "AA", ErrInvalidCode},
+		{"truncated", valid[:len(valid)-4], ErrInvalidCode},
+		{"newer version", newer, ErrUnsupportedVersion},
+		{"overlapping periods", Encode(&Settings{Ratios: []Period{{"08:00", "12:00", 1}, {"11:00", "13:00", 1}}}), ErrInvalidCode},
+		{"zero ratio", Encode(&Settings{Ratios: []Period{{"08:00", "12:00", 0}}}), ErrInvalidCode},
+		{"correction without target", Encode(&Settings{CorrectionFactor: 2}), ErrInvalidCode},
+		{"low rule without rise", Encode(&Settings{LowRuleGrams: 15}), ErrInvalidCode},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Decode(tt.code); !errors.Is(err, tt.want) {
+				t.Errorf("Decode(%q) error = %v, want %v", tt.code, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeIgnoresWhitespace(t *testing.T) {
+	code := Encode(&Settings{Ratios: []Period{{"08:00", "12:00", 1.5}}})
+	split := code[:10] + "\n " + code[10:]
+	if _, err := Decode(split); err != nil {
+		t.Errorf("Decode(%q): %v", split, err)
+	}
+}
@@ -0,0 +1,92 @@
+// Package storage keeps generated artifacts such as exports in a local
+// directory or an S3-compatible bucket, selected by configuration.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/vladimiradmaev/diabetes-helper/internal/config"
+)
+
+// Backends supported by New
+const (
+	BackendLocal = "local"
+	BackendS3    = "s3"
+)
+
+// ArtifactsPrefix holds short-lived generated files that expire automatically
+const ArtifactsPrefix = "artifacts/"
+
+// ErrNotFound is returned when a key doesn't exist
+var ErrNotFound = errors.New("blob not found")
+
+// Blob stores binary objects under slash-separated keys
+type Blob interface {
+	Put(ctx context.Context, key string, data []byte) error
+	// Get returns the object's content; the caller closes it
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes an object; a missing key is not an error
+	Delete(ctx context.Context, key string) error
+	// DeleteOlderThan removes objects under prefix last modified before the
+	// given time and returns how many were removed
+	DeleteOlderThan(ctx context.Context, prefix string, before time.Time) (int, error)
+}
+
+// New creates the blob storage selected by the configuration
+func New(cfg config.StorageConfig) (Blob, error) {
+	switch cfg.Backend {
+	case BackendLocal, "":
+		return NewLocal(cfg.Dir), nil
+	case BackendS3:
+		return NewS3(cfg.S3)
+	default:
+		return nil, fmt.Errorf("unknown storage backend: %s", cfg.Backend)
+	}
+}
+
+// Key builds a storage key from parts. Characters outside [A-Za-z0-9._-] are
+// replaced with "_" and "." or ".." parts are dropped, so user-provided values
+// can never escape their prefix.
+func Key(parts ...string) string {
+	cleaned := make([]string, 0, len(parts))
+	for _, part := range parts {
+		part = strings.Map(func(r rune) rune {
+			if isKeyRune(r) {
+				return r
+			}
+			return '_'
+		}, part)
+		if part == "" || part == "." || part == ".." {
+			continue
+		}
+		cleaned = append(cleaned, part)
+	}
+	return strings.Join(cleaned, "/")
+}
+
+// validateKey rejects keys that were not built with Key
+func validateKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.HasSuffix(key, "/") {
+		return fmt.Errorf("invalid storage key: %q", key)
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return fmt.Errorf("invalid storage key: %q", key)
+		}
+		for _, r := range part {
+			if !isKeyRune(r) {
+				return fmt.Errorf("invalid storage key: %q", key)
+			}
+		}
+	}
+	return nil
+}
+
+func isKeyRune(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '_' || r == '-'
+}
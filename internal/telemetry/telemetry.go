@@ -0,0 +1,213 @@
+// Package telemetry sends the maintainer an optional weekly summary of how an
+// instance is used. It is off unless TELEMETRY_ENABLED=true, and the report
+// only holds instance-wide aggregates: no user IDs, names, readings, meals or
+// anything else about a single user leaves the instance.
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/vladimiradmaev/diabetes-helper/internal/config"
+	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/metrics"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Interval is how often a report is sent
+const Interval = 7 * 24 * time.Hour
+
+const stateID = 1
+
+// Payload is the report sent to the telemetry endpoint
+type Payload struct {
+	InstanceID       string           `json:"instance_id"`
+	Version          string           `json:"version"`
+	Users            string           `json:"users"`        // Bucket of the user count, e.g. "11-100"
+	PeriodHours      int              `json:"period_hours"` // Time the counters below cover
+	Analyses         int64            `json:"analyses"`
+	Providers        map[string]int64 `json:"providers"` // Analyses by provider
+	AIRequests       int64            `json:"ai_requests"`
+	AIRequestsFailed int64            `json:"ai_requests_failed"`
+}
+
+// Collector builds reports from the process metrics counters and sends them
+type Collector struct {
+	db      *gorm.DB
+	cfg     config.TelemetryConfig
+	version string
+	client  *http.Client
+
+	// Counters are reported as the change since the last report sent by this
+	// process, or since it started
+	mu         sync.Mutex
+	baseline   map[string]map[string]int64
+	baselineAt time.Time
+}
+
+func New(db *gorm.DB, cfg config.TelemetryConfig, version string) *Collector {
+	return &Collector{
+		db:         db,
+		cfg:        cfg,
+		version:    version,
+		client:     &http.Client{Timeout: 30 * time.Second},
+		baseline:   map[string]map[string]int64{},
+		baselineAt: time.Now(),
+	}
+}
+
+// Enabled reports whether reports are sent
+func (c *Collector) Enabled() bool {
+	return c.cfg.Enabled
+}
+
+// Endpoint returns the URL reports are sent to
+func (c *Collector) Endpoint() string {
+	return c.cfg.Endpoint
+}
+
+// Collect returns the report that would be sent now, without sending it
+func (c *Collector) Collect(ctx context.Context) (*Payload, error) {
+	payload, _, err := c.collect(ctx)
+	return payload, err
+}
+
+func (c *Collector) collect(ctx context.Context) (*Payload, map[string]map[string]int64, error) {
+	state, err := c.state(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var users int64
+	if err := c.db.WithContext(ctx).Model(&database.User{}).Where("deleted_at IS NULL").Count(&users).Error; err != nil {
+		return nil, nil, fmt.Errorf("failed to count users: %w", err)
+	}
+
+	snapshot := metrics.Snapshot()
+	c.mu.Lock()
+	delta := func(name, label string) int64 {
+		return snapshot[name][label] - c.baseline[name][label]
+	}
+	payload := &Payload{
+		InstanceID:       state.InstanceID,
+		Version:          c.version,
+		Users:            userBucket(users),
+		PeriodHours:      int(time.Since(c.baselineAt).Hours()),
+		Providers:        map[string]int64{},
+		AIRequests:       delta("ai_requests_total", ""),
+		AIRequestsFailed: delta("ai_requests_failed_total", ""),
+	}
+	for provider := range snapshot["food_analyses_total"] {
+		if n := delta("food_analyses_total", provider); n > 0 {
+			payload.Providers[provider] = n
+			payload.Analyses += n
+		}
+	}
+	c.mu.Unlock()
+
+	return payload, snapshot, nil
+}
+
+// SendWeekly sends a report when the last one is at least Interval old. The
+// slot is claimed in the database first, so several instances or restarts
+// send one report per week; a failed report is not retried until the next.
+func (c *Collector) SendWeekly(ctx context.Context) error {
+	if !c.cfg.Enabled {
+		return nil
+	}
+	if _, err := c.state(ctx); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	result := c.db.WithContext(ctx).Model(&database.TelemetryState{}).
+		Where("id = ? AND last_sent_at <= ?", stateID, now.Add(-Interval)).
+		Update("last_sent_at", now)
+	if result.Error != nil {
+		return fmt.Errorf("failed to claim telemetry report: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil
+	}
+
+	payload, snapshot, err := c.collect(ctx)
+	if err != nil {
+		return err
+	}
+	if err := c.send(ctx, payload); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.baseline = snapshot
+	c.baselineAt = now
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *Collector) send(ctx context.Context, payload *Payload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode telemetry report: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.cfg.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create telemetry request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send telemetry report: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("telemetry endpoint returned %s", resp.Status)
+	}
+	return nil
+}
+
+// state returns the telemetry row, creating it with a new instance ID on
+// first use. The first report is due Interval after that.
+func (c *Collector) state(ctx context.Context) (*database.TelemetryState, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, fmt.Errorf("failed to generate instance ID: %w", err)
+	}
+	initial := database.TelemetryState{ID: stateID, InstanceID: hex.EncodeToString(buf), LastSentAt: time.Now()}
+	if err := c.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&initial).Error; err != nil {
+		return nil, fmt.Errorf("failed to create telemetry state: %w", err)
+	}
+
+	var state database.TelemetryState
+	if err := c.db.WithContext(ctx).First(&state, stateID).Error; err != nil {
+		return nil, fmt.Errorf("failed to get telemetry state: %w", err)
+	}
+	return &state, nil
+}
+
+// userBucket hides the exact user count
+func userBucket(n int64) string {
+	switch {
+	case n == 0:
+		return "0"
+	case n <= 10:
+		return "1-10"
+	case n <= 100:
+		return "11-100"
+	case n <= 1000:
+		return "101-1000"
+	case n <= 10000:
+		return "1001-10000"
+	default:
+		return "10000+"
+	}
+}
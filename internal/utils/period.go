@@ -0,0 +1,69 @@
+package utils
+
+const minutesPerDay = 24 * 60
+
+// Time-of-day periods are "HH:MM" start and end pairs covering [start, end).
+// A period whose end is earlier than its start crosses midnight
+// ("22:00"-"06:00"); a period whose end equals its start covers the whole day.
+
+// periodRanges splits a period into at most two non-wrapping half-open
+// minute ranges within [0, minutesPerDay)
+func periodRanges(start, end string) [][2]int {
+	s := TimeToMinutes(start)
+	e := TimeToMinutes(end)
+	switch {
+	case s < e:
+		return [][2]int{{s, e}}
+	case s == e:
+		return [][2]int{{0, minutesPerDay}}
+	case e == 0:
+		return [][2]int{{s, minutesPerDay}}
+	default:
+		return [][2]int{{s, minutesPerDay}, {0, e}}
+	}
+}
+
+// PeriodMinutes returns the length of a period in minutes
+func PeriodMinutes(start, end string) int {
+	total := 0
+	for _, r := range periodRanges(start, end) {
+		total += r[1] - r[0]
+	}
+	return total
+}
+
+// PeriodContains reports whether the minute of the day falls inside the period
+func PeriodContains(start, end string, minute int) bool {
+	for _, r := range periodRanges(start, end) {
+		if minute >= r[0] && minute < r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// PeriodsOverlap reports whether two periods share at least one minute.
+// Periods that only touch ("08:00"-"12:00" and "12:00"-"18:00") don't overlap.
+func PeriodsOverlap(aStart, aEnd, bStart, bEnd string) bool {
+	for _, a := range periodRanges(aStart, aEnd) {
+		for _, b := range periodRanges(bStart, bEnd) {
+			if a[0] < b[1] && b[0] < a[1] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// PeriodOverlapMinutes returns how many minutes two periods share
+func PeriodOverlapMinutes(aStart, aEnd, bStart, bEnd string) int {
+	total := 0
+	for _, a := range periodRanges(aStart, aEnd) {
+		for _, b := range periodRanges(bStart, bEnd) {
+			if lo, hi := max(a[0], b[0]), min(a[1], b[1]); lo < hi {
+				total += hi - lo
+			}
+		}
+	}
+	return total
+}
@@ -0,0 +1,56 @@
+package utils
+
+import "testing"
+
+func TestPeriodsOverlap(t *testing.T) {
+	tests := []struct {
+		name                       string
+		aStart, aEnd, bStart, bEnd string
+		want                       bool
+	}{
+		{"disjoint", "08:00", "12:00", "13:00", "18:00", false},
+		{"touching", "08:00", "12:00", "12:00", "18:00", false},
+		{"touching reversed", "12:00", "18:00", "08:00", "12:00", false},
+		{"one minute shared", "08:00", "12:01", "12:00", "18:00", true},
+		{"contained", "08:00", "18:00", "10:00", "12:00", true},
+		{"same", "08:00", "12:00", "08:00", "12:00", true},
+		{"end at midnight", "18:00", "00:00", "00:00", "06:00", false},
+		{"end at midnight overlaps", "18:00", "00:00", "23:00", "23:30", true},
+		{"across midnight, disjoint", "22:00", "06:00", "06:00", "22:00", false},
+		{"across midnight, before midnight", "22:00", "06:00", "21:00", "23:00", true},
+		{"across midnight, after midnight", "22:00", "06:00", "05:00", "07:00", true},
+		{"both across midnight", "23:00", "01:00", "22:00", "02:00", true},
+		{"whole day", "00:00", "00:00", "10:00", "11:00", true},
+		{"whole day from another start", "06:00", "06:00", "05:00", "05:30", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := PeriodsOverlap(tt.aStart, tt.aEnd, tt.bStart, tt.bEnd); got != tt.want {
+				t.Errorf("PeriodsOverlap(%s-%s, %s-%s) = %v, want %v", tt.aStart, tt.aEnd, tt.bStart, tt.bEnd, got, tt.want)
+			}
+			if got := PeriodsOverlap(tt.bStart, tt.bEnd, tt.aStart, tt.aEnd); got != tt.want {
+				t.Errorf("PeriodsOverlap(%s-%s, %s-%s) = %v, want %v", tt.bStart, tt.bEnd, tt.aStart, tt.aEnd, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPeriodMinutes(t *testing.T) {
+	tests := []struct {
+		start, end string
+		want       int
+	}{
+		{"08:00", "12:00", 240},
+		{"18:00", "00:00", 360},
+		{"22:00", "06:00", 480},
+		{"00:00", "00:00", 1440},
+		{"07:30", "07:30", 1440},
+	}
+
+	for _, tt := range tests {
+		if got := PeriodMinutes(tt.start, tt.end); got != tt.want {
+			t.Errorf("PeriodMinutes(%s, %s) = %d, want %d", tt.start, tt.end, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"strings"
+	"unicode"
+)
+
+// TelegramMessageLimit is the longest text Telegram accepts in one message, in characters
+const TelegramMessageLimit = 4096
+
+// messageBreaks are the places a long message is preferably split at, best first
+var messageBreaks = []string{"\n\n", "\n", ". ", "! ", "? ", "; ", ", ", " "}
+
+// SplitMessage splits s into parts of at most limit characters. Parts end at
+// a paragraph, line or sentence boundary when there is one in the second half
+// of the part, so short leftovers are avoided; otherwise at a word boundary
+// and, for a single very long word, anywhere. Whitespace around the cuts is dropped.
+func SplitMessage(s string, limit int) []string {
+	s = strings.TrimSpace(s)
+	if limit <= 0 || s == "" {
+		return []string{s}
+	}
+
+	var parts []string
+	runes := []rune(s)
+	for len(runes) > limit {
+		cut := splitPoint(runes[:limit+1])
+		parts = append(parts, strings.TrimRightFunc(string(runes[:cut]), unicode.IsSpace))
+		runes = []rune(strings.TrimLeftFunc(string(runes[cut:]), unicode.IsSpace))
+	}
+	if len(runes) > 0 {
+		parts = append(parts, string(runes))
+	}
+	return parts
+}
+
+// splitPoint returns how many runes of window go into the current part. The
+// window holds one rune more than the limit, so a break right at the limit is found.
+func splitPoint(window []rune) int {
+	text := string(window)
+	minCut := len(window) / 2
+	for _, sep := range messageBreaks {
+		idx := strings.LastIndex(text, sep)
+		if idx < 0 {
+			continue
+		}
+		// Keep the punctuation of a sentence break with the current part
+		cut := len([]rune(text[:idx])) + len([]rune(strings.TrimRightFunc(sep, unicode.IsSpace)))
+		if cut >= minCut || (sep == " " && cut > 0) {
+			return cut
+		}
+	}
+	return len(window) - 1
+}
@@ -1,9 +1,65 @@
 package utils
 
-import "time"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
 
 // TimeToMinutes converts time string to minutes since midnight
 func TimeToMinutes(timeStr string) int {
 	t, _ := time.Parse("15:04", timeStr)
 	return t.Hour()*60 + t.Minute()
 }
+
+// LoadLocation returns the location for an IANA timezone name,
+// falling back to the server's local time when the name is empty or unknown
+func LoadLocation(name string) *time.Location {
+	if name == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// DayBounds returns the start of the local day containing t and the start of the next one.
+// Both are computed in loc, so DST transitions produce 23 or 25 hour days as expected.
+func DayBounds(t time.Time, loc *time.Location) (time.Time, time.Time) {
+	local := t.In(loc)
+	start := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+	end := time.Date(local.Year(), local.Month(), local.Day()+1, 0, 0, 0, 0, loc)
+	return start, end
+}
+
+// ParseTimezone accepts an IANA timezone name ("Europe/Moscow") or a whole-hour
+// UTC offset ("+3", "UTC+3", "-5") and returns a name usable with LoadLocation
+func ParseTimezone(input string) (string, error) {
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return "", fmt.Errorf("empty timezone")
+	}
+
+	offset := strings.TrimPrefix(strings.TrimPrefix(strings.ToUpper(input), "UTC"), "GMT")
+	if offset == "" {
+		return "UTC", nil
+	}
+	if hours, err := strconv.Atoi(offset); err == nil {
+		if hours < -12 || hours > 14 {
+			return "", fmt.Errorf("offset out of range: %d", hours)
+		}
+		if hours == 0 {
+			return "UTC", nil
+		}
+		// Etc/GMT zones use inverted signs: Etc/GMT-3 is UTC+3
+		return fmt.Sprintf("Etc/GMT%+d", -hours), nil
+	}
+
+	if _, err := time.LoadLocation(input); err != nil {
+		return "", fmt.Errorf("unknown timezone %q: %w", input, err)
+	}
+	return input, nil
+}
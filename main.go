@@ -7,16 +7,29 @@ import (
 	"os/signal"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/joho/godotenv"
+	"github.com/vladimiradmaev/diabetes-helper/internal/api"
 	"github.com/vladimiradmaev/diabetes-helper/internal/bot"
+	"github.com/vladimiradmaev/diabetes-helper/internal/buildinfo"
+	"github.com/vladimiradmaev/diabetes-helper/internal/confidence"
 	"github.com/vladimiradmaev/diabetes-helper/internal/config"
 	"github.com/vladimiradmaev/diabetes-helper/internal/database"
+	"github.com/vladimiradmaev/diabetes-helper/internal/events"
+	"github.com/vladimiradmaev/diabetes-helper/internal/featureflags"
 	"github.com/vladimiradmaev/diabetes-helper/internal/interfaces"
 	"github.com/vladimiradmaev/diabetes-helper/internal/logger"
+	"github.com/vladimiradmaev/diabetes-helper/internal/metrics"
+	"github.com/vladimiradmaev/diabetes-helper/internal/scheduler"
 	"github.com/vladimiradmaev/diabetes-helper/internal/services"
+	"github.com/vladimiradmaev/diabetes-helper/internal/storage"
+	"github.com/vladimiradmaev/diabetes-helper/internal/telemetry"
 )
 
+// aiSelfTestTimeout bounds the startup check of the AI provider
+const aiSelfTestTimeout = 15 * time.Second
+
 func main() {
 	// Initialize basic logger first
 	if err := logger.Init(); err != nil {
@@ -47,10 +60,13 @@ func main() {
 	}
 
 	logger.Info("Starting Diabetes Helper Bot...",
-		"version", "1.0.0",
+		"version", buildinfo.Version,
+		"commit", buildinfo.Commit,
+		"build_date", buildinfo.Date,
 		"log_level", cfg.Logger.Level,
 		"log_format", cfg.Logger.Format)
 	logger.Info("Configuration loaded successfully")
+	confidence.Configure(cfg.Confidence)
 
 	db, err := database.NewPostgresDB(cfg.DB)
 	if err != nil {
@@ -59,16 +75,44 @@ func main() {
 	}
 	logger.Info("Database connection established and migrations completed")
 
-	// Initialize AI service
-	aiService := services.NewAIService(cfg.GeminiAPIKey)
+	// Initialize AI service with usage accounting
+	usageService := services.NewUsageService(db, cfg.ModelPrices)
+	var flags interfaces.FeatureFlagsInterface = featureflags.New(db)
+	aiService := services.NewAIService(cfg.GeminiAPIKey, cfg.OpenAIAPIKey, flags, usageService, cfg.PortionReferences)
 
 	// Initialize services implementing interfaces
 	var userService interfaces.UserServiceInterface = services.NewUserService(db)
-	var foodAnalysisService interfaces.FoodAnalysisServiceInterface = services.NewFoodAnalysisService(aiService, db)
 	var bloodSugarService interfaces.BloodSugarServiceInterface = services.NewBloodSugarService(db)
-	var insulinService interfaces.InsulinServiceInterface = services.NewInsulinService(db)
+	var insulinService interfaces.InsulinServiceInterface = services.NewInsulinService(db, cfg.RatioBounds)
+	var injectionService interfaces.InjectionServiceInterface = services.NewInjectionService(db, cfg.InjectionSiteRepeatLimit)
+	var basalService interfaces.BasalServiceInterface = services.NewBasalService(db)
+	var snapshotService interfaces.SettingsSnapshotServiceInterface = services.NewSettingsSnapshotService(db)
+	statsService := services.NewStatsAggregationService(db)
+	var eventService interfaces.EventServiceInterface = services.NewEventService(db)
+	blob, err := storage.New(cfg.Storage)
+	if err != nil {
+		logger.Error("Failed to initialize storage", "error", err)
+		os.Exit(1)
+	}
+	foodAnalysis := services.NewFoodAnalysisService(aiService, db, blob, cfg.CorrectionBand)
+	var foodAnalysisService interfaces.FoodAnalysisServiceInterface = foodAnalysis
+	var analysisQueue interfaces.AnalysisQueueInterface = services.NewAnalysisQueueService(db, cfg.AIQueue)
+	var apiTokenService interfaces.APITokenServiceInterface = services.NewAPITokenService(db)
+	jobService := services.NewJobService(db, cfg.ExportDir, blob)
+	if err := jobService.RequeueInterrupted(ctx); err != nil {
+		logger.Error("Failed to requeue interrupted jobs", "error", err)
+	}
+	telemetryCollector := telemetry.New(db, cfg.Telemetry, buildinfo.Version)
+	conversationLog := services.NewConversationLogService(db, cfg.ConversationLog)
 	logger.Info("Services initialized successfully")
 
+	// Subscribers to what users record. Register new ones here so that they
+	// see every event from the first update on.
+	events.Subscribe(foodAnalysis.HandleEvent)
+	if cfg.EventWebhookURL != "" {
+		events.Subscribe(events.Webhook(cfg.EventWebhookURL))
+	}
+
 	// Get Redis settings from environment
 	redisHost := os.Getenv("REDIS_HOST")
 	if redisHost == "" {
@@ -80,17 +124,72 @@ func main() {
 	}
 
 	// Initialize bot with interfaces
-	telegramBot, err := bot.NewBot(cfg.TelegramToken, redisHost, redisPort, userService, foodAnalysisService, bloodSugarService, insulinService)
+	telegramBot, err := bot.NewBot(cfg.TelegramToken, redisHost, redisPort, userService, foodAnalysisService, analysisQueue, bloodSugarService, insulinService, injectionService, basalService, snapshotService, statsService, eventService, usageService, jobService, services.NewChartService(), apiTokenService, services.NewInsightsService(db), services.NewSupportService(db), services.NewDataCheckService(db), services.NewTransferService(db), services.NewUserProfileService(db), flags, telemetryCollector, conversationLog, cfg.BolusTiming, cfg.AdminTelegramIDs)
 	if err != nil {
 		logger.Error("Failed to create bot", "error", err)
 		os.Exit(1)
 	}
 	logger.Info("Bot initialized successfully")
 
+	// Check the Gemini key in the background so that a revoked or mistyped key
+	// shows up before the first photo fails. Non-AI features work either way.
+	aiSelfTest := func() {
+		testCtx, cancel := context.WithTimeout(context.Background(), aiSelfTestTimeout)
+		defer cancel()
+		if err := aiService.SelfTest(testCtx); err != nil {
+			logger.Error("AI provider self-test failed", "error", err)
+			telegramBot.NotifyAdmins("⚠️ Проверка ключа Gemini не прошла, анализ фото может не работать: " + err.Error())
+			return
+		}
+		logger.Info("AI provider self-test passed")
+	}
+	go aiSelfTest()
+
 	// Create context for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	if cfg.MetricsAddr != "" {
+		metrics.NewInfo("build_info", "Version of the running build", map[string]string{
+			"version":    buildinfo.Version,
+			"commit":     buildinfo.Commit,
+			"build_date": buildinfo.Date,
+		})
+		metrics.Serve(ctx, cfg.MetricsAddr)
+	}
+	if cfg.APIAddr != "" {
+		api.Serve(ctx, cfg.APIAddr, api.Dependencies{
+			TokenSvc:        apiTokenService,
+			InjectionSvc:    injectionService,
+			FoodAnalysisSvc: foodAnalysisService,
+		}, cfg.APIRateLimit)
+	}
+
+	// Aggregate daily statistics in the background. The job runs hourly so that
+	// every user's previous day is stored soon after their local midnight.
+	scheduler.Every(ctx, "daily_stats", time.Hour, statsService.RunDaily)
+	scheduler.Every(ctx, "travel_mode_expiry", 15*time.Minute, userService.ClearExpiredTravelModes)
+	scheduler.Every(ctx, "jobs", 10*time.Second, telegramBot.ProcessJobs)
+	scheduler.Every(ctx, "analysis_queue", 5*time.Second, telegramBot.ProcessAnalysisQueue)
+	scheduler.Every(ctx, "reminders", time.Minute, telegramBot.SendReminders)
+	scheduler.Every(ctx, "ratio_changes", time.Minute, telegramBot.ActivateRatioChanges)
+	scheduler.Every(ctx, "storage_expiry", time.Hour, func(ctx context.Context) error {
+		deleted, err := blob.DeleteOlderThan(ctx, storage.ArtifactsPrefix, time.Now().Add(-cfg.Storage.ArtifactTTL))
+		if deleted > 0 {
+			logger.Info("Expired artifacts deleted", "count", deleted)
+		}
+		return err
+	})
+	if cfg.UsageMonthlyReport {
+		scheduler.Every(ctx, "usage_report", time.Hour, telegramBot.SendMonthlyUsageReport)
+	}
+	if cfg.ConversationLog.Enabled {
+		scheduler.Every(ctx, "conversation_log_expiry", time.Hour, conversationLog.DeleteExpired)
+	}
+	if cfg.Telemetry.Enabled {
+		scheduler.Every(ctx, "telemetry", time.Hour, telemetryCollector.SendWeekly)
+	}
+
 	// Start bot in a goroutine
 	var wg sync.WaitGroup
 	wg.Add(1)
@@ -108,6 +207,17 @@ func main() {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
+	// SIGHUP repeats the AI self-test, e.g. after the key was rotated at the
+	// provider
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+	go func() {
+		for range hupChan {
+			logger.Info("Received SIGHUP, repeating AI provider self-test")
+			aiSelfTest()
+		}
+	}()
+
 	logger.Info("Bot is running. Press Ctrl+C to stop.")
 	<-sigChan
 	logger.Info("Received shutdown signal, stopping bot...")